@@ -0,0 +1,171 @@
+package shuttle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+
+	"github.com/Azure/go-shuttle/v2/metrics/sender"
+)
+
+const (
+	defaultAdaptiveBatchInitialSize    = 10
+	defaultAdaptiveBatchMaxSize        = 500
+	defaultAdaptiveBatchTargetLatency  = 500 * time.Millisecond
+	defaultAdaptiveBatchBackoffInitial = 100 * time.Millisecond
+	defaultAdaptiveBatchBackoffMax     = 5 * time.Second
+)
+
+// AdaptiveBatchOptions configures the AdaptiveBatchSender.
+type AdaptiveBatchOptions struct {
+	// InitialSize is the number of messages of the first batch. Defaults to 10.
+	InitialSize int
+	// MinSize is the smallest batch size. Defaults to 1.
+	MinSize int
+	// MaxSize is the largest batch size. Defaults to 500.
+	MaxSize int
+	// TargetLatency is the send latency above which the batch size decreases. Defaults to 500ms.
+	TargetLatency time.Duration
+	// Backoff is the wait before retrying a throttled batch, from the number of consecutive throttled batches.
+	// Defaults to an ExponentialBackoff from 100ms to 5 seconds.
+	Backoff Backoff
+	// Clock is used to wait for the Backoff. Defaults to the clock of the sender.
+	Clock Clock
+}
+
+// AdaptiveBatchSender sends messages in batches sized like a TCP congestion window:
+// the batch size grows while the sends succeed under the TargetLatency, shrinks when they are slower,
+// and is halved when the namespace throttles or the batch exceeds the maximum batch size in bytes.
+// A throttled batch is retried after the Backoff.
+// The current size is exposed by WindowSize and the batch_window_size metric.
+type AdaptiveBatchSender struct {
+	send    func(ctx context.Context, messages []*azservicebus.Message) error
+	entity  string
+	options AdaptiveBatchOptions
+
+	mu     sync.Mutex
+	window int
+}
+
+// NewAdaptiveBatchSender creates an AdaptiveBatchSender sending with the sender.
+func NewAdaptiveBatchSender(sender *Sender, options *AdaptiveBatchOptions) *AdaptiveBatchSender {
+	opts := AdaptiveBatchOptions{}
+	if options != nil {
+		opts = *options
+	}
+	if opts.MinSize <= 0 {
+		opts.MinSize = 1
+	}
+	if opts.MaxSize <= 0 {
+		opts.MaxSize = defaultAdaptiveBatchMaxSize
+	}
+	if opts.MaxSize < opts.MinSize {
+		opts.MaxSize = opts.MinSize
+	}
+	if opts.InitialSize <= 0 {
+		opts.InitialSize = defaultAdaptiveBatchInitialSize
+	}
+	if opts.TargetLatency <= 0 {
+		opts.TargetLatency = defaultAdaptiveBatchTargetLatency
+	}
+	if opts.Backoff == nil {
+		opts.Backoff = ExponentialBackoff{Initial: defaultAdaptiveBatchBackoffInitial, Max: defaultAdaptiveBatchBackoffMax}
+	}
+	if opts.Clock == nil {
+		opts.Clock = clockOrDefault(sender.options.Clock)
+	}
+	s := &AdaptiveBatchSender{send: sender.SendMessageBatch, entity: sender.options.EntityName, options: opts}
+	s.setWindow(opts.InitialSize)
+	return s
+}
+
+// WindowSize returns the current batch size.
+func (s *AdaptiveBatchSender) WindowSize() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.window
+}
+
+// SendMessages sends the messages in order, in batches of the current window size.
+// A batch throttled or too large is retried with a smaller window, after the Backoff when it is throttled,
+// and the send fails when it is throttled at the MinSize. It returns the number of messages sent,
+// including when an error occurred.
+func (s *AdaptiveBatchSender) SendMessages(ctx context.Context, messages []*azservicebus.Message) (int, error) {
+	sent := 0
+	throttles := 0
+	for sent < len(messages) {
+		window := s.WindowSize()
+		end := sent + window
+		if end > len(messages) {
+			end = len(messages)
+		}
+		start := time.Now()
+		err := s.send(ctx, messages[sent:end])
+		latency := time.Since(start)
+		switch {
+		case err == nil:
+			sent = end
+			throttles = 0
+			s.onSuccess(window, latency)
+		case errors.Is(err, azservicebus.ErrMessageTooLarge) && window > s.options.MinSize:
+			log(ctx, fmt.Sprintf("batch of %d messages too large, retrying with a smaller batch: %s", window, err))
+			s.setWindow(window / 2)
+		case isThrottlingError(err) && window > s.options.MinSize:
+			throttles++
+			delay := s.options.Backoff.Delay(throttles)
+			log(ctx, fmt.Sprintf("batch of %d messages throttled, retrying with a smaller batch in %s: %s", window, delay, err))
+			s.setWindow(window / 2)
+			if err := s.wait(ctx, delay); err != nil {
+				return sent, err
+			}
+		default:
+			if isThrottlingError(err) {
+				s.setWindow(s.options.MinSize)
+			}
+			return sent, err
+		}
+	}
+	return sent, nil
+}
+
+// wait waits for the delay, or until the context is done.
+func (s *AdaptiveBatchSender) wait(ctx context.Context, delay time.Duration) error {
+	timer := s.options.Clock.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C():
+		return nil
+	}
+}
+
+// onSuccess grows the window additively under the target latency, and shrinks it above.
+func (s *AdaptiveBatchSender) onSuccess(window int, latency time.Duration) {
+	if latency > s.options.TargetLatency {
+		s.setWindow(window * 3 / 4)
+		return
+	}
+	increase := window / 10
+	if increase < 1 {
+		increase = 1
+	}
+	s.setWindow(window + increase)
+}
+
+func (s *AdaptiveBatchSender) setWindow(window int) {
+	if window < s.options.MinSize {
+		window = s.options.MinSize
+	}
+	if window > s.options.MaxSize {
+		window = s.options.MaxSize
+	}
+	s.mu.Lock()
+	s.window = window
+	s.mu.Unlock()
+	sender.Metric.SetBatchWindowSize(s.entity, window)
+}
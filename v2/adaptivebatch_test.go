@@ -0,0 +1,124 @@
+package shuttle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	. "github.com/onsi/gomega"
+)
+
+func testMessages(n int) []*azservicebus.Message {
+	messages := make([]*azservicebus.Message, n)
+	for i := range messages {
+		messages[i] = &azservicebus.Message{Body: []byte(fmt.Sprint(i))}
+	}
+	return messages
+}
+
+func TestAdaptiveBatchSender_Grows(t *testing.T) {
+	g := NewWithT(t)
+	s := NewAdaptiveBatchSender(NewSender(&fakeAzSender{}, nil), &AdaptiveBatchOptions{InitialSize: 10, MaxSize: 12})
+	var sizes []int
+	s.send = func(_ context.Context, messages []*azservicebus.Message) error {
+		sizes = append(sizes, len(messages))
+		return nil
+	}
+	sent, err := s.SendMessages(context.Background(), testMessages(40))
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(sent).To(Equal(40))
+	g.Expect(sizes).To(Equal([]int{10, 11, 12, 7}))
+	g.Expect(s.WindowSize()).To(Equal(12))
+}
+
+func TestAdaptiveBatchSender_ShrinksOnThrottling(t *testing.T) {
+	g := NewWithT(t)
+	var attempts []int
+	backoff := BackoffFunc(func(attempt int) time.Duration {
+		attempts = append(attempts, attempt)
+		return time.Millisecond
+	})
+	s := NewAdaptiveBatchSender(NewSender(&fakeAzSender{}, nil), &AdaptiveBatchOptions{InitialSize: 8, Backoff: backoff})
+	var sizes []int
+	var delivered []*azservicebus.Message
+	s.send = func(_ context.Context, messages []*azservicebus.Message) error {
+		sizes = append(sizes, len(messages))
+		if len(messages) > 2 {
+			return errServerBusy
+		}
+		delivered = append(delivered, messages...)
+		return nil
+	}
+	messages := testMessages(5)
+	sent, err := s.SendMessages(context.Background(), messages)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(sent).To(Equal(5))
+	g.Expect(delivered).To(Equal(messages))
+	g.Expect(sizes).To(Equal([]int{5, 4, 2, 3, 1, 2}))
+	g.Expect(attempts).To(Equal([]int{1, 2, 1}), "the backoff restarts after a successful batch")
+}
+
+func TestAdaptiveBatchSender_ThrottledWaitStopsOnContextDone(t *testing.T) {
+	g := NewWithT(t)
+	s := NewAdaptiveBatchSender(NewSender(&fakeAzSender{}, nil), &AdaptiveBatchOptions{InitialSize: 8, Backoff: ConstantBackoff(time.Hour)})
+	ctx, cancel := context.WithCancel(context.Background())
+	s.send = func(_ context.Context, _ []*azservicebus.Message) error {
+		cancel()
+		return errServerBusy
+	}
+	sent, err := s.SendMessages(ctx, testMessages(8))
+	g.Expect(err).To(MatchError(context.Canceled))
+	g.Expect(sent).To(Equal(0))
+	g.Expect(s.WindowSize()).To(Equal(4))
+}
+
+func TestAdaptiveBatchSender_ShrinksOnBatchTooLarge(t *testing.T) {
+	g := NewWithT(t)
+	s := NewAdaptiveBatchSender(NewSender(&fakeAzSender{}, nil), &AdaptiveBatchOptions{InitialSize: 4})
+	s.send = func(_ context.Context, messages []*azservicebus.Message) error {
+		if len(messages) > 1 {
+			return azservicebus.ErrMessageTooLarge
+		}
+		return nil
+	}
+	sent, err := s.SendMessages(context.Background(), testMessages(2))
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(sent).To(Equal(2))
+}
+
+func TestAdaptiveBatchSender_ShrinksOnLatency(t *testing.T) {
+	g := NewWithT(t)
+	s := NewAdaptiveBatchSender(NewSender(&fakeAzSender{}, nil), &AdaptiveBatchOptions{InitialSize: 8, TargetLatency: time.Millisecond})
+	s.send = func(_ context.Context, _ []*azservicebus.Message) error {
+		time.Sleep(5 * time.Millisecond)
+		return nil
+	}
+	_, err := s.SendMessages(context.Background(), testMessages(8))
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(s.WindowSize()).To(Equal(6))
+}
+
+func TestAdaptiveBatchSender_Failures(t *testing.T) {
+	g := NewWithT(t)
+	s := NewAdaptiveBatchSender(NewSender(&fakeAzSender{}, nil), &AdaptiveBatchOptions{InitialSize: 2})
+	calls := 0
+	s.send = func(_ context.Context, _ []*azservicebus.Message) error {
+		calls++
+		if calls == 1 {
+			return nil
+		}
+		return errServerBusy
+	}
+	sent, err := s.SendMessages(context.Background(), testMessages(6))
+	g.Expect(err).To(MatchError(errServerBusy))
+	g.Expect(sent).To(Equal(2))
+	g.Expect(s.WindowSize()).To(Equal(1))
+
+	s.send = func(_ context.Context, _ []*azservicebus.Message) error { return errors.New("unauthorized") }
+	sent, err = s.SendMessages(context.Background(), testMessages(2))
+	g.Expect(err).To(MatchError("unauthorized"))
+	g.Expect(sent).To(Equal(0))
+}
@@ -0,0 +1,88 @@
+package shuttle
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+)
+
+// AMQPBodyType is the section carrying the body of an AMQP message.
+type AMQPBodyType string
+
+const (
+	// AMQPBodyData is a body in one or more data sections, the format of the azservicebus messages.
+	AMQPBodyData AMQPBodyType = "data"
+	// AMQPBodyValue is a body in an amqp-value section, such as a string or a map.
+	AMQPBodyValue AMQPBodyType = "value"
+	// AMQPBodySequence is a body in one or more amqp-sequence sections, each a list of values.
+	AMQPBodySequence AMQPBodyType = "sequence"
+)
+
+// ErrUnsupportedBodyType is returned when unmarshalling a message whose body cannot be read as bytes,
+// such as a sequence body, or a value body that is not a string or a binary.
+// Such bodies are read with ValueBody and SequenceBody.
+var ErrUnsupportedBodyType = errors.New("unsupported AMQP body type")
+
+// BodyType returns the section carrying the body of the received message.
+// The producers other than the azservicebus sdks, like the AMQP libraries of other languages or the bridges,
+// often send their bodies in a value or a sequence section, for which azservicebus leaves the Body empty.
+func BodyType(message *azservicebus.ReceivedMessage) AMQPBodyType {
+	if message.RawAMQPMessage == nil {
+		return AMQPBodyData
+	}
+	body := message.RawAMQPMessage.Body
+	switch {
+	case body.Value != nil:
+		return AMQPBodyValue
+	case len(body.Sequence) > 0:
+		return AMQPBodySequence
+	default:
+		return AMQPBodyData
+	}
+}
+
+// ValueBody returns the amqp-value body of the received message.
+// It returns false when the body is not in a value section.
+func ValueBody(message *azservicebus.ReceivedMessage) (any, bool) {
+	if BodyType(message) != AMQPBodyValue {
+		return nil, false
+	}
+	return message.RawAMQPMessage.Body.Value, true
+}
+
+// SequenceBody returns the amqp-sequence sections of the body of the received message.
+// It returns false when the body is not in sequence sections.
+func SequenceBody(message *azservicebus.ReceivedMessage) ([][]any, bool) {
+	if BodyType(message) != AMQPBodySequence {
+		return nil, false
+	}
+	return message.RawAMQPMessage.Body.Sequence, true
+}
+
+// BodyBytes returns the body of the received message as bytes, whatever the section carrying it:
+// the Body, the data sections joined together when there are several, or the string or binary of a value body.
+// It returns ErrUnsupportedBodyType for the sequence bodies and the other value bodies.
+// Unmarshal, DecodedBody and MarshallerRegistry.Unmarshal unmarshal the bytes it returns.
+func BodyBytes(message *azservicebus.ReceivedMessage) ([]byte, error) {
+	switch BodyType(message) {
+	case AMQPBodyValue:
+		switch value := message.RawAMQPMessage.Body.Value.(type) {
+		case []byte:
+			return value, nil
+		case string:
+			return []byte(value), nil
+		default:
+			return nil, fmt.Errorf("%w: value body of type %T", ErrUnsupportedBodyType, value)
+		}
+	case AMQPBodySequence:
+		return nil, fmt.Errorf("%w: sequence body", ErrUnsupportedBodyType)
+	default:
+		// azservicebus only sets the Body when there is a single data section
+		if message.Body == nil && message.RawAMQPMessage != nil && len(message.RawAMQPMessage.Body.Data) > 1 {
+			return bytes.Join(message.RawAMQPMessage.Body.Data, nil), nil
+		}
+		return message.Body, nil
+	}
+}
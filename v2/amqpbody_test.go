@@ -0,0 +1,87 @@
+package shuttle_test
+
+import (
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	. "github.com/onsi/gomega"
+
+	"github.com/Azure/go-shuttle/v2"
+)
+
+func amqpMessage(body azservicebus.AMQPAnnotatedMessageBody) *azservicebus.ReceivedMessage {
+	message := &azservicebus.ReceivedMessage{RawAMQPMessage: &azservicebus.AMQPAnnotatedMessage{Body: body}}
+	if len(body.Data) == 1 {
+		message.Body = body.Data[0]
+	}
+	return message
+}
+
+func TestBodyAccessors(t *testing.T) {
+	g := NewWithT(t)
+	data := amqpMessage(azservicebus.AMQPAnnotatedMessageBody{Data: [][]byte{[]byte("data")}})
+	g.Expect(shuttle.BodyType(data)).To(Equal(shuttle.AMQPBodyData))
+	g.Expect(shuttle.BodyType(&azservicebus.ReceivedMessage{Body: []byte("data")})).To(Equal(shuttle.AMQPBodyData))
+	_, ok := shuttle.ValueBody(data)
+	g.Expect(ok).To(BeFalse())
+	_, ok = shuttle.SequenceBody(data)
+	g.Expect(ok).To(BeFalse())
+
+	value := amqpMessage(azservicebus.AMQPAnnotatedMessageBody{Value: map[string]any{"id": int64(1)}})
+	g.Expect(shuttle.BodyType(value)).To(Equal(shuttle.AMQPBodyValue))
+	v, ok := shuttle.ValueBody(value)
+	g.Expect(ok).To(BeTrue())
+	g.Expect(v).To(Equal(map[string]any{"id": int64(1)}))
+
+	sequence := amqpMessage(azservicebus.AMQPAnnotatedMessageBody{Sequence: [][]any{{"a", int32(1)}, {"b"}}})
+	g.Expect(shuttle.BodyType(sequence)).To(Equal(shuttle.AMQPBodySequence))
+	s, ok := shuttle.SequenceBody(sequence)
+	g.Expect(ok).To(BeTrue())
+	g.Expect(s).To(Equal([][]any{{"a", int32(1)}, {"b"}}))
+}
+
+func TestBodyBytes(t *testing.T) {
+	testCases := []struct {
+		name     string
+		message  *azservicebus.ReceivedMessage
+		expected string
+		err      bool
+	}{
+		{name: "body", message: &azservicebus.ReceivedMessage{Body: []byte(`{"id":1}`)}, expected: `{"id":1}`},
+		{name: "data sections", message: amqpMessage(azservicebus.AMQPAnnotatedMessageBody{Data: [][]byte{[]byte(`{"id"`), []byte(`:1}`)}}), expected: `{"id":1}`},
+		{name: "string value", message: amqpMessage(azservicebus.AMQPAnnotatedMessageBody{Value: `{"id":1}`}), expected: `{"id":1}`},
+		{name: "binary value", message: amqpMessage(azservicebus.AMQPAnnotatedMessageBody{Value: []byte(`{"id":1}`)}), expected: `{"id":1}`},
+		{name: "map value", message: amqpMessage(azservicebus.AMQPAnnotatedMessageBody{Value: map[string]any{"id": 1}}), err: true},
+		{name: "sequence", message: amqpMessage(azservicebus.AMQPAnnotatedMessageBody{Sequence: [][]any{{1}}}), err: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+			body, err := shuttle.BodyBytes(tc.message)
+			if tc.err {
+				g.Expect(err).To(MatchError(shuttle.ErrUnsupportedBodyType))
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(string(body)).To(Equal(tc.expected))
+		})
+	}
+}
+
+func TestUnmarshal_ValueBody(t *testing.T) {
+	type order struct {
+		ID int `json:"id"`
+	}
+	g := NewWithT(t)
+	message := amqpMessage(azservicebus.AMQPAnnotatedMessageBody{Value: `{"id":1}`})
+	decoded, err := shuttle.Unmarshal[order](&shuttle.DefaultJSONMarshaller{}, message)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(decoded).To(Equal(&order{ID: 1}))
+
+	registered := &order{}
+	g.Expect(shuttle.NewMarshallerRegistry(&shuttle.DefaultJSONMarshaller{}).Unmarshal(message, registered)).To(Succeed())
+	g.Expect(registered).To(Equal(&order{ID: 1}))
+
+	_, err = shuttle.Unmarshal[order](&shuttle.DefaultJSONMarshaller{}, amqpMessage(azservicebus.AMQPAnnotatedMessageBody{Sequence: [][]any{{1}}}))
+	g.Expect(err).To(MatchError(shuttle.ErrUnsupportedBodyType))
+}
@@ -0,0 +1,139 @@
+package shuttle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+
+	"github.com/Azure/go-shuttle/v2/metrics/sender"
+)
+
+const (
+	partitionKeyAnnotation         = "x-opt-partition-key"
+	scheduledEnqueueTimeAnnotation = "x-opt-scheduled-enqueue-time"
+)
+
+// ErrAnnotationsNotSupported is returned when sending a message with annotations through an AzServiceBusSender
+// that does not implement AMQPAnnotatedSender.
+var ErrAnnotationsNotSupported = errors.New("message annotations are not supported by this send operation")
+
+// AMQPAnnotatedSender is satisfied by *azservicebus.Sender.
+// The Sender uses it to send the messages carrying annotations.
+type AMQPAnnotatedSender interface {
+	SendAMQPAnnotatedMessage(ctx context.Context, message *azservicebus.AMQPAnnotatedMessage, options *azservicebus.SendAMQPAnnotatedMessageOptions) error
+}
+
+// MessageAnnotations are the AMQP message annotations sent with SendMessageWithAnnotations and SendAzMessageWithAnnotations,
+// for the brokers and bridges keying on annotations.
+// The "x-opt-" annotations are reserved by Service Bus and overwriting them can get the message rejected.
+type MessageAnnotations map[string]any
+
+// SendMessageWithAnnotations sends the message body like SendMessage, with the AMQP message annotations.
+// azservicebus.Message does not expose the annotations, so the message is converted with ToAMQPAnnotatedMessage
+// and sent with SendAMQPAnnotatedMessage. The batches and the scheduled messages cannot carry annotations.
+func (d *Sender) SendMessageWithAnnotations(ctx context.Context, mb MessageBody, annotations MessageAnnotations, options ...func(msg *azservicebus.Message) error) error {
+	if err := d.begin(ctx); err != nil {
+		return err
+	}
+	defer d.inflight.Done()
+	msg, err := d.ToServiceBusMessage(ctx, mb, options...)
+	if err != nil {
+		return err
+	}
+	return d.sendAnnotated(ctx, msg, annotations)
+}
+
+// SendAzMessageWithAnnotations sends a pre-built azservicebus.Message like SendAzMessage, with the AMQP message annotations.
+// The message is not modified by the annotations, so it can be sent again with the same ones.
+func (d *Sender) SendAzMessageWithAnnotations(ctx context.Context, msg *azservicebus.Message, annotations MessageAnnotations, options ...func(msg *azservicebus.Message) error) error {
+	if err := d.begin(ctx); err != nil {
+		return err
+	}
+	defer d.inflight.Done()
+	if err := d.applyOptions(ctx, msg, options); err != nil {
+		return err
+	}
+	return d.sendAnnotated(ctx, msg, annotations)
+}
+
+func (d *Sender) sendAnnotated(ctx context.Context, msg *azservicebus.Message, annotations MessageAnnotations) error {
+	for key := range annotations {
+		if key == "" {
+			return fmt.Errorf("message annotation key must not be empty")
+		}
+	}
+	annotatedSender, ok := d.sbSender.(AMQPAnnotatedSender)
+	if !ok {
+		return ErrAnnotationsNotSupported
+	}
+	msg, err := d.checkSize(ctx, msg)
+	if err != nil {
+		return err
+	}
+	sender.Metric.ObserveMessageSize(d.options.EntityName, len(msg.Body))
+	annotated := ToAMQPAnnotatedMessage(msg)
+	for key, value := range annotations {
+		annotated.MessageAnnotations[key] = value
+	}
+	return d.sendTimed(ctx, func(ctx context.Context) error {
+		return annotatedSender.SendAMQPAnnotatedMessage(ctx, annotated, nil)
+	})
+}
+
+// ToAMQPAnnotatedMessage converts the message to an AMQP message with the same properties and body,
+// to set the AMQP fields azservicebus.Message does not expose before sending it with Sender.SendAMQPAnnotatedMessage.
+func ToAMQPAnnotatedMessage(msg *azservicebus.Message) *azservicebus.AMQPAnnotatedMessage {
+	annotated := &azservicebus.AMQPAnnotatedMessage{
+		Body:                  azservicebus.AMQPAnnotatedMessageBody{Data: [][]byte{msg.Body}},
+		Header:                &azservicebus.AMQPAnnotatedMessageHeader{},
+		MessageAnnotations:    map[any]any{},
+		ApplicationProperties: map[string]any{},
+		Properties: &azservicebus.AMQPAnnotatedMessageProperties{
+			ContentType:    msg.ContentType,
+			Subject:        msg.Subject,
+			To:             msg.To,
+			ReplyTo:        msg.ReplyTo,
+			GroupID:        msg.SessionID,
+			ReplyToGroupID: msg.ReplyToSessionID,
+		},
+	}
+	if msg.MessageID != nil {
+		annotated.Properties.MessageID = *msg.MessageID
+	}
+	if msg.CorrelationID != nil {
+		annotated.Properties.CorrelationID = *msg.CorrelationID
+	}
+	if msg.TimeToLive != nil {
+		annotated.Header.TTL = *msg.TimeToLive
+	}
+	if msg.PartitionKey != nil {
+		annotated.MessageAnnotations[partitionKeyAnnotation] = *msg.PartitionKey
+	}
+	if msg.ScheduledEnqueueTime != nil {
+		annotated.MessageAnnotations[scheduledEnqueueTimeAnnotation] = *msg.ScheduledEnqueueTime
+	}
+	for k, v := range msg.ApplicationProperties {
+		annotated.ApplicationProperties[k] = v
+	}
+	return annotated
+}
+
+// SendAMQPAnnotatedMessage is the escape hatch to send an AMQP message with fields azservicebus.Message does not expose,
+// like the message annotations, the delivery annotations, the header or a sequence or value body.
+// It applies the SendTimeout, the backpressure and the metrics of SendMessage, but none of its message options.
+// It returns ErrAnnotationsNotSupported when the AzServiceBusSender does not implement AMQPAnnotatedSender.
+func (d *Sender) SendAMQPAnnotatedMessage(ctx context.Context, msg *azservicebus.AMQPAnnotatedMessage) error {
+	if err := d.begin(ctx); err != nil {
+		return err
+	}
+	defer d.inflight.Done()
+	annotatedSender, ok := d.sbSender.(AMQPAnnotatedSender)
+	if !ok {
+		return ErrAnnotationsNotSupported
+	}
+	return d.sendTimed(ctx, func(ctx context.Context) error {
+		return annotatedSender.SendAMQPAnnotatedMessage(ctx, msg, nil)
+	})
+}
@@ -0,0 +1,97 @@
+package shuttle
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	. "github.com/onsi/gomega"
+)
+
+type fakeAnnotatedSender struct {
+	*fakeAzSender
+	annotated []*azservicebus.AMQPAnnotatedMessage
+}
+
+func (f *fakeAnnotatedSender) SendAMQPAnnotatedMessage(_ context.Context, message *azservicebus.AMQPAnnotatedMessage, _ *azservicebus.SendAMQPAnnotatedMessageOptions) error {
+	f.annotated = append(f.annotated, message)
+	return nil
+}
+
+func TestSender_SendAzMessageWithAnnotations(t *testing.T) {
+	g := NewWithT(t)
+	azSender := &fakeAnnotatedSender{fakeAzSender: &fakeAzSender{}}
+	sender := NewSender(azSender, nil)
+	msg := &azservicebus.Message{
+		Body:                  []byte("body"),
+		MessageID:             to.Ptr("id"),
+		CorrelationID:         to.Ptr("correlation"),
+		SessionID:             to.Ptr("session"),
+		PartitionKey:          to.Ptr("session"),
+		TimeToLive:            to.Ptr(time.Minute),
+		ApplicationProperties: map[string]any{"type": "order"},
+	}
+	annotations := MessageAnnotations{"x-broker-of-record": "erp", "x-region": "eu"}
+	g.Expect(sender.SendAzMessageWithAnnotations(context.Background(), msg, annotations)).To(Succeed())
+	g.Expect(azSender.SendMessageCalled).To(BeFalse())
+	g.Expect(azSender.annotated).To(HaveLen(1))
+
+	annotated := azSender.annotated[0]
+	g.Expect(annotated.MessageAnnotations).To(Equal(map[any]any{
+		"x-broker-of-record":  "erp",
+		"x-region":            "eu",
+		"x-opt-partition-key": "session",
+	}))
+	g.Expect(annotated.ApplicationProperties).To(Equal(map[string]any{"type": "order"}))
+	g.Expect(annotated.Body.Data).To(Equal([][]byte{[]byte("body")}))
+	g.Expect(annotated.Properties.MessageID).To(Equal("id"))
+	g.Expect(annotated.Properties.CorrelationID).To(Equal("correlation"))
+	g.Expect(*annotated.Properties.GroupID).To(Equal("session"))
+	g.Expect(annotated.Header.TTL).To(Equal(time.Minute))
+
+	// the message is left as-is, and is sent again with the annotations
+	g.Expect(msg.ApplicationProperties).To(Equal(map[string]any{"type": "order"}))
+	g.Expect(sender.SendAzMessageWithAnnotations(context.Background(), msg, annotations)).To(Succeed())
+	g.Expect(azSender.annotated[1].MessageAnnotations).To(HaveKeyWithValue("x-region", "eu"))
+}
+
+func TestSender_SendMessageWithAnnotations(t *testing.T) {
+	g := NewWithT(t)
+	azSender := &fakeAnnotatedSender{fakeAzSender: &fakeAzSender{}}
+	sender := NewSender(azSender, nil)
+	err := sender.SendMessageWithAnnotations(context.Background(), "hello", MessageAnnotations{"x-region": "eu"}, SetMessageId(to.Ptr("id")))
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(azSender.annotated).To(HaveLen(1))
+	g.Expect(azSender.annotated[0].MessageAnnotations).To(HaveKeyWithValue("x-region", "eu"))
+	g.Expect(azSender.annotated[0].Body.Data).To(Equal([][]byte{[]byte(`"hello"`)}))
+	g.Expect(azSender.annotated[0].Properties.MessageID).To(Equal("id"))
+
+	err = sender.SendMessageWithAnnotations(context.Background(), "hello", MessageAnnotations{"": "eu"})
+	g.Expect(err).To(HaveOccurred())
+}
+
+func TestSender_SendWithAnnotations_Unsupported(t *testing.T) {
+	g := NewWithT(t)
+	azSender := &fakeAzSender{}
+	sender := NewSender(azSender, nil)
+	err := sender.SendAzMessageWithAnnotations(context.Background(), &azservicebus.Message{}, MessageAnnotations{"x-region": "eu"})
+	g.Expect(err).To(MatchError(ErrAnnotationsNotSupported))
+	g.Expect(azSender.SendMessageCalled).To(BeFalse())
+}
+
+func TestSender_SendAMQPAnnotatedMessage(t *testing.T) {
+	g := NewWithT(t)
+	azSender := &fakeAnnotatedSender{fakeAzSender: &fakeAzSender{}}
+	sender := NewSender(azSender, nil)
+	msg := &azservicebus.AMQPAnnotatedMessage{
+		Body:                azservicebus.AMQPAnnotatedMessageBody{Value: "value"},
+		DeliveryAnnotations: map[any]any{"x-trace": "1"},
+	}
+	g.Expect(sender.SendAMQPAnnotatedMessage(context.Background(), msg)).To(Succeed())
+	g.Expect(azSender.annotated).To(ConsistOf(msg))
+
+	unsupported := NewSender(&fakeAzSender{}, nil)
+	g.Expect(unsupported.SendAMQPAnnotatedMessage(context.Background(), msg)).To(MatchError(ErrAnnotationsNotSupported))
+}
@@ -0,0 +1,213 @@
+package shuttle
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+)
+
+// Audit directions and outcomes.
+const (
+	AuditDirectionSend    = "send"
+	AuditDirectionReceive = "receive"
+
+	AuditOutcomeSent         = "sent"
+	AuditOutcomeScheduled    = "scheduled"
+	AuditOutcomeFailed       = "failed"
+	AuditOutcomeCompleted    = "completed"
+	AuditOutcomeAbandoned    = "abandoned"
+	AuditOutcomeDeadLettered = "deadlettered"
+	AuditOutcomeDeferred     = "deferred"
+	// AuditOutcomeUnsettled is recorded when the handler returns without settling the message.
+	AuditOutcomeUnsettled = "unsettled"
+)
+
+// AuditRecord is the compact record written to the AuditSink for each message sent or handled.
+type AuditRecord struct {
+	Timestamp     time.Time     `json:"timestamp"`
+	Direction     string        `json:"direction"`
+	Entity        string        `json:"entity,omitempty"`
+	Type          string        `json:"type,omitempty"`
+	MessageID     string        `json:"messageId,omitempty"`
+	CorrelationID string        `json:"correlationId,omitempty"`
+	Outcome       string        `json:"outcome"`
+	Latency       time.Duration `json:"latency"`
+	Error         string        `json:"error,omitempty"`
+	// Properties are the application properties of the message, masked by the Redactor of the middleware.
+	Properties map[string]any `json:"properties,omitempty"`
+}
+
+// AuditSink stores the audit records.
+// Implement it to write to a durable store such as Azure Table storage or Event Hubs.
+type AuditSink interface {
+	Write(ctx context.Context, record AuditRecord) error
+}
+
+// AuditSinkFunc allows to use a func as an AuditSink.
+type AuditSinkFunc func(ctx context.Context, record AuditRecord) error
+
+func (f AuditSinkFunc) Write(ctx context.Context, record AuditRecord) error {
+	return f(ctx, record)
+}
+
+type jsonAuditSink struct {
+	mu      sync.Mutex
+	encoder *json.Encoder
+}
+
+// NewJSONAuditSink writes the audit records as JSON lines to w, for example os.Stdout.
+func NewJSONAuditSink(w io.Writer) AuditSink {
+	return &jsonAuditSink{encoder: json.NewEncoder(w)}
+}
+
+func (s *jsonAuditSink) Write(_ context.Context, record AuditRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.encoder.Encode(record)
+}
+
+func writeAuditRecord(ctx context.Context, sink AuditSink, record AuditRecord) {
+	if err := sink.Write(ctx, record); err != nil {
+		log(ctx, fmt.Sprintf("failed to write audit record: %s", err))
+	}
+}
+
+// NewAuditHandler is a middleware that writes an audit record for each message once the next handler returns.
+// The outcome is the first successful settlement made by the next handler.
+// The properties of the record are masked by the DefaultRedactor, unless WithRedactor is used.
+func NewAuditHandler(sink AuditSink, entityName string, next Handler, options ...RedactionOption) HandlerFunc {
+	redactor := redactorFrom(options)
+	return func(ctx context.Context, settler MessageSettler, message *azservicebus.ReceivedMessage) {
+		start := time.Now()
+		auditSettler := &auditSettler{MessageSettler: settler}
+		next.Handle(ctx, auditSettler, message)
+		record := AuditRecord{
+			Timestamp: start,
+			Direction: AuditDirectionReceive,
+			Entity:    entityName,
+			Outcome:   auditSettler.result(),
+			Latency:   time.Since(start),
+		}
+		if message != nil {
+			record.MessageID = message.MessageID
+			if message.CorrelationID != nil {
+				record.CorrelationID = *message.CorrelationID
+			}
+			if msgType, ok := message.ApplicationProperties[msgTypeField].(string); ok {
+				record.Type = msgType
+			}
+			record.Properties = redactor.RedactProperties(message.ApplicationProperties)
+		}
+		writeAuditRecord(ctx, sink, record)
+	}
+}
+
+// auditSettler captures the first successful settlement made through the wrapped MessageSettler.
+type auditSettler struct {
+	MessageSettler
+	mu      sync.Mutex
+	outcome string
+}
+
+// Unwrap returns the wrapped MessageSettler, for UnwrapSettler.
+func (s *auditSettler) Unwrap() MessageSettler {
+	return s.MessageSettler
+}
+
+func (s *auditSettler) AbandonMessage(ctx context.Context, message *azservicebus.ReceivedMessage, options *azservicebus.AbandonMessageOptions) error {
+	return s.record(AuditOutcomeAbandoned, s.MessageSettler.AbandonMessage(ctx, message, options))
+}
+
+func (s *auditSettler) CompleteMessage(ctx context.Context, message *azservicebus.ReceivedMessage, options *azservicebus.CompleteMessageOptions) error {
+	return s.record(AuditOutcomeCompleted, s.MessageSettler.CompleteMessage(ctx, message, options))
+}
+
+func (s *auditSettler) DeadLetterMessage(ctx context.Context, message *azservicebus.ReceivedMessage, options *azservicebus.DeadLetterOptions) error {
+	return s.record(AuditOutcomeDeadLettered, s.MessageSettler.DeadLetterMessage(ctx, message, options))
+}
+
+func (s *auditSettler) DeferMessage(ctx context.Context, message *azservicebus.ReceivedMessage, options *azservicebus.DeferMessageOptions) error {
+	return s.record(AuditOutcomeDeferred, s.MessageSettler.DeferMessage(ctx, message, options))
+}
+
+func (s *auditSettler) record(outcome string, err error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err == nil && s.outcome == "" {
+		s.outcome = outcome
+	}
+	return err
+}
+
+func (s *auditSettler) result() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.outcome == "" {
+		return AuditOutcomeUnsettled
+	}
+	return s.outcome
+}
+
+var _ AzServiceBusSender = &auditingSender{}
+
+// auditingSender writes an audit record for each message sent or scheduled through the wrapped AzServiceBusSender.
+type auditingSender struct {
+	AzServiceBusSender
+	sink       AuditSink
+	entityName string
+	redactor   Redactor
+}
+
+// NewAuditingSender wraps the AzServiceBusSender to write an audit record for each message sent or scheduled.
+// Pass it to NewSender to audit the messages sent by a Sender.
+// Messages sent in a batch are not audited, as the batch does not expose them.
+// The properties of the records are masked by the DefaultRedactor, unless WithRedactor is used.
+func NewAuditingSender(sender AzServiceBusSender, sink AuditSink, entityName string, options ...RedactionOption) AzServiceBusSender {
+	return &auditingSender{AzServiceBusSender: sender, sink: sink, entityName: entityName, redactor: redactorFrom(options)}
+}
+
+func (s *auditingSender) SendMessage(ctx context.Context, message *azservicebus.Message, options *azservicebus.SendMessageOptions) error {
+	start := time.Now()
+	err := s.AzServiceBusSender.SendMessage(ctx, message, options)
+	s.audit(ctx, start, message, AuditOutcomeSent, err)
+	return err
+}
+
+func (s *auditingSender) ScheduleMessages(ctx context.Context, messages []*azservicebus.Message, scheduledEnqueueTime time.Time, options *azservicebus.ScheduleMessagesOptions) ([]int64, error) {
+	start := time.Now()
+	sequenceNumbers, err := s.AzServiceBusSender.ScheduleMessages(ctx, messages, scheduledEnqueueTime, options)
+	for _, message := range messages {
+		s.audit(ctx, start, message, AuditOutcomeScheduled, err)
+	}
+	return sequenceNumbers, err
+}
+
+func (s *auditingSender) audit(ctx context.Context, start time.Time, message *azservicebus.Message, outcome string, err error) {
+	record := AuditRecord{
+		Timestamp: start,
+		Direction: AuditDirectionSend,
+		Entity:    s.entityName,
+		Outcome:   outcome,
+		Latency:   time.Since(start),
+	}
+	if err != nil {
+		record.Outcome = AuditOutcomeFailed
+		record.Error = err.Error()
+	}
+	if message.MessageID != nil {
+		record.MessageID = *message.MessageID
+	}
+	if message.CorrelationID != nil {
+		record.CorrelationID = *message.CorrelationID
+	}
+	if msgType, ok := message.ApplicationProperties[msgTypeField].(string); ok {
+		record.Type = msgType
+	}
+	record.Properties = s.redactor.RedactProperties(message.ApplicationProperties)
+	writeAuditRecord(ctx, s.sink, record)
+}
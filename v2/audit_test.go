@@ -0,0 +1,120 @@
+package shuttle_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	. "github.com/onsi/gomega"
+
+	"github.com/Azure/go-shuttle/v2"
+	"github.com/Azure/go-shuttle/v2/shuttletest"
+)
+
+type recordingSink struct {
+	records []shuttle.AuditRecord
+}
+
+func (s *recordingSink) Write(_ context.Context, record shuttle.AuditRecord) error {
+	s.records = append(s.records, record)
+	return nil
+}
+
+func TestAuditHandler(t *testing.T) {
+	g := NewWithT(t)
+	sink := &recordingSink{}
+	message := &azservicebus.ReceivedMessage{
+		MessageID:             "id",
+		CorrelationID:         to.Ptr("correlation"),
+		ApplicationProperties: map[string]any{"type": "OrderCreated"},
+	}
+	handler := shuttle.NewAuditHandler(sink, "orders", shuttle.HandlerFunc(
+		func(ctx context.Context, settler shuttle.MessageSettler, message *azservicebus.ReceivedMessage) {
+			_ = settler.CompleteMessage(ctx, message, nil)
+			_ = settler.AbandonMessage(ctx, message, nil)
+		}))
+	settler := &fakeSettler{}
+	handler(context.Background(), settler, message)
+	g.Expect(settler.CompleteCalled.Load()).To(Equal(int32(1)))
+	g.Expect(sink.records).To(HaveLen(1))
+	record := sink.records[0]
+	g.Expect(record.Direction).To(Equal(shuttle.AuditDirectionReceive))
+	g.Expect(record.Entity).To(Equal("orders"))
+	g.Expect(record.Type).To(Equal("OrderCreated"))
+	g.Expect(record.MessageID).To(Equal("id"))
+	g.Expect(record.CorrelationID).To(Equal("correlation"))
+	g.Expect(record.Outcome).To(Equal(shuttle.AuditOutcomeCompleted), "the first settlement is recorded")
+
+	handler = shuttle.NewAuditHandler(sink, "orders", shuttle.HandlerFunc(
+		func(ctx context.Context, settler shuttle.MessageSettler, message *azservicebus.ReceivedMessage) {}))
+	handler(context.Background(), settler, message)
+	g.Expect(sink.records[1].Outcome).To(Equal(shuttle.AuditOutcomeUnsettled))
+}
+
+func TestAuditingSender(t *testing.T) {
+	g := NewWithT(t)
+	sink := &recordingSink{}
+	inMemory := shuttletest.NewInMemorySender(nil)
+	sender := shuttle.NewSender(shuttle.NewAuditingSender(inMemory, sink, "orders"), nil)
+	g.Expect(sender.SendMessage(context.Background(), "body", shuttle.SetMessageId(to.Ptr("id")))).To(Succeed())
+	g.Expect(sink.records).To(HaveLen(1))
+	g.Expect(sink.records[0].Direction).To(Equal(shuttle.AuditDirectionSend))
+	g.Expect(sink.records[0].Outcome).To(Equal(shuttle.AuditOutcomeSent))
+	g.Expect(sink.records[0].MessageID).To(Equal("id"))
+	g.Expect(sink.records[0].Type).To(Equal("string"))
+
+	failing := shuttle.NewAuditingSender(&failingAzSender{AzServiceBusSender: inMemory}, sink, "orders")
+	g.Expect(failing.SendMessage(context.Background(), &azservicebus.Message{}, nil)).ToNot(Succeed())
+	g.Expect(sink.records[1].Outcome).To(Equal(shuttle.AuditOutcomeFailed))
+	g.Expect(sink.records[1].Error).To(Equal("send failed"))
+}
+
+func TestAuditHandler_RedactsProperties(t *testing.T) {
+	g := NewWithT(t)
+	sink := &recordingSink{}
+	message := &azservicebus.ReceivedMessage{
+		MessageID:             "id",
+		ApplicationProperties: map[string]any{"type": "OrderCreated", "customer-email": "jane@contoso.com"},
+	}
+	noop := shuttle.HandlerFunc(func(ctx context.Context, settler shuttle.MessageSettler, message *azservicebus.ReceivedMessage) {})
+	shuttle.NewAuditHandler(sink, "orders", noop).Handle(context.Background(), &fakeSettler{}, message)
+	g.Expect(sink.records[0].Properties).To(HaveKeyWithValue("type", "OrderCreated"))
+	g.Expect(sink.records[0].Properties).To(HaveKeyWithValue("customer-email", shuttle.RedactedValue), "redacted by default")
+
+	shuttle.NewAuditHandler(sink, "orders", noop, shuttle.WithRedactor(shuttle.NoRedaction)).Handle(context.Background(), &fakeSettler{}, message)
+	g.Expect(sink.records[1].Properties).To(HaveKeyWithValue("customer-email", "jane@contoso.com"))
+
+	inMemory := shuttletest.NewInMemorySender(nil)
+	sender := shuttle.NewSender(shuttle.NewAuditingSender(inMemory, sink, "orders",
+		shuttle.WithRedactor(&shuttle.FieldRedactor{Names: []string{"tenant"}})), nil)
+	g.Expect(sender.SendMessage(context.Background(), "body", func(msg *azservicebus.Message) error {
+		msg.ApplicationProperties["tenant"] = "contoso"
+		return nil
+	})).To(Succeed())
+	g.Expect(sink.records[2].Properties).To(HaveKeyWithValue("tenant", shuttle.RedactedValue))
+	g.Expect(inMemory.SentMessages()[0].ApplicationProperties).To(HaveKeyWithValue("tenant", "contoso"), "the sent message is not redacted")
+}
+
+type failingAzSender struct {
+	shuttle.AzServiceBusSender
+}
+
+func (f *failingAzSender) SendMessage(_ context.Context, _ *azservicebus.Message, _ *azservicebus.SendMessageOptions) error {
+	return errors.New("send failed")
+}
+
+func TestJSONAuditSink(t *testing.T) {
+	g := NewWithT(t)
+	buf := &bytes.Buffer{}
+	sink := shuttle.NewJSONAuditSink(buf)
+	g.Expect(sink.Write(context.Background(), shuttle.AuditRecord{Direction: "send", Outcome: "sent", MessageID: "id"})).To(Succeed())
+	var record map[string]any
+	g.Expect(json.Unmarshal(buf.Bytes(), &record)).To(Succeed())
+	g.Expect(record).To(HaveKeyWithValue("messageId", "id"))
+	g.Expect(record).To(HaveKeyWithValue("outcome", "sent"))
+	g.Expect(record).ToNot(HaveKey("correlationId"))
+}
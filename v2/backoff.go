@@ -0,0 +1,119 @@
+package shuttle
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Backoff computes the delay before the next attempt of an operation, from the number of consecutive failed attempts,
+// starting at 1. It is shared by the retry sites of the package: the settlement retries of the ManagedSettler
+// with NewBackoffDelayStrategy, the send delays of BackpressureOptions and the failed polls of the outbox relay.
+type Backoff interface {
+	Delay(attempt int) time.Duration
+}
+
+// BackoffFunc allows to use a func as a Backoff.
+type BackoffFunc func(attempt int) time.Duration
+
+func (f BackoffFunc) Delay(attempt int) time.Duration {
+	return f(attempt)
+}
+
+// ConstantBackoff waits the same delay before each attempt.
+type ConstantBackoff time.Duration
+
+func (b ConstantBackoff) Delay(_ int) time.Duration {
+	return time.Duration(b)
+}
+
+// LinearBackoff increases the delay by Step after each attempt: Initial, Initial+Step, Initial+2*Step...
+type LinearBackoff struct {
+	Initial time.Duration
+	Step    time.Duration
+	// Max caps the delay when positive.
+	Max time.Duration
+}
+
+func (b LinearBackoff) Delay(attempt int) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+	return capDelay(b.Initial+time.Duration(attempt-1)*b.Step, b.Max)
+}
+
+// ExponentialBackoff multiplies the delay after each attempt: Initial, Initial*Multiplier, Initial*Multiplier^2...
+type ExponentialBackoff struct {
+	Initial time.Duration
+	// Multiplier defaults to 2.
+	Multiplier float64
+	// Max caps the delay when positive.
+	Max time.Duration
+	// Jitter randomizes the delay by up to this fraction of it, between 0 and 1, so that the clients
+	// failing together do not retry together. Defaults to 0, without jitter.
+	Jitter float64
+}
+
+func (b ExponentialBackoff) Delay(attempt int) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+	multiplier := b.Multiplier
+	if multiplier <= 0 {
+		multiplier = 2
+	}
+	delay := capDelay(scaleDelay(b.Initial, math.Pow(multiplier, float64(attempt-1))), b.Max)
+	if b.Jitter > 0 {
+		delay -= scaleDelay(delay, b.Jitter*rand.Float64())
+	}
+	return delay
+}
+
+// DecorrelatedJitterBackoff picks a random delay between Base and three times the delay bound of the previous attempt,
+// Base*3^(attempt-1), capped at Max. It spreads the retries more than ExponentialBackoff with jitter.
+type DecorrelatedJitterBackoff struct {
+	Base time.Duration
+	// Max caps the delay when positive.
+	Max time.Duration
+}
+
+func (b DecorrelatedJitterBackoff) Delay(attempt int) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+	upper := capDelay(scaleDelay(b.Base, math.Pow(3, float64(attempt-1))), b.Max)
+	if upper <= b.Base {
+		return upper
+	}
+	return b.Base + time.Duration(rand.Int63n(int64(upper-b.Base)+1))
+}
+
+// scaleDelay multiplies the delay without overflowing.
+func scaleDelay(delay time.Duration, factor float64) time.Duration {
+	scaled := float64(delay) * factor
+	if scaled >= math.MaxInt64 {
+		return math.MaxInt64
+	}
+	return time.Duration(scaled)
+}
+
+func capDelay(delay, max time.Duration) time.Duration {
+	if max > 0 && delay > max {
+		return max
+	}
+	return delay
+}
+
+// NewBackoffDelayStrategy adapts the backoff to the RetryDelayStrategy of the ManagedSettler.
+// The attempt is the DeliveryCount of the message.
+func NewBackoffDelayStrategy(backoff Backoff) RetryDelayStrategy {
+	return &backoffDelayStrategy{backoff: backoff}
+}
+
+type backoffDelayStrategy struct {
+	backoff Backoff
+}
+
+func (s *backoffDelayStrategy) GetDelay(deliveryCount uint32) time.Duration {
+	return s.backoff.Delay(int(deliveryCount))
+}
@@ -0,0 +1,69 @@
+package shuttle
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	. "github.com/onsi/gomega"
+)
+
+func TestBackoff_Delay(t *testing.T) {
+	g := NewWithT(t)
+	g.Expect(ConstantBackoff(time.Second).Delay(5)).To(Equal(time.Second))
+
+	linear := LinearBackoff{Initial: time.Second, Step: 2 * time.Second, Max: 4 * time.Second}
+	g.Expect(linear.Delay(0)).To(Equal(time.Second))
+	g.Expect(linear.Delay(2)).To(Equal(3 * time.Second))
+	g.Expect(linear.Delay(3)).To(Equal(4 * time.Second))
+
+	exponential := ExponentialBackoff{Initial: time.Second, Max: 10 * time.Second}
+	g.Expect(exponential.Delay(1)).To(Equal(time.Second))
+	g.Expect(exponential.Delay(3)).To(Equal(4 * time.Second))
+	g.Expect(exponential.Delay(10)).To(Equal(10 * time.Second))
+	g.Expect(ExponentialBackoff{Initial: time.Second}.Delay(math.MaxInt32)).To(Equal(time.Duration(math.MaxInt64)))
+
+	jittered := ExponentialBackoff{Initial: time.Second, Multiplier: 3, Jitter: 0.5}
+	for i := 0; i < 100; i++ {
+		g.Expect(jittered.Delay(2)).To(BeNumerically("~", 2250*time.Millisecond, 750*time.Millisecond))
+	}
+
+	decorrelated := DecorrelatedJitterBackoff{Base: time.Second, Max: 5 * time.Second}
+	g.Expect(decorrelated.Delay(1)).To(Equal(time.Second))
+	for i := 0; i < 100; i++ {
+		g.Expect(decorrelated.Delay(2)).To(BeNumerically("~", 2*time.Second, time.Second))
+		g.Expect(decorrelated.Delay(5)).To(BeNumerically("~", 3*time.Second, 2*time.Second))
+	}
+
+	custom := BackoffFunc(func(attempt int) time.Duration { return time.Duration(attempt) * time.Minute })
+	g.Expect(custom.Delay(2)).To(Equal(2 * time.Minute))
+}
+
+func TestBackoffDelayStrategy(t *testing.T) {
+	g := NewWithT(t)
+	settler := &fakeSettler{}
+	handler := NewManagedSettlingHandler(&ManagedSettlingOptions{
+		RetryDelayStrategy: NewBackoffDelayStrategy(ExponentialBackoff{Initial: time.Millisecond}),
+	}, ManagedSettlingFunc(func(ctx context.Context, message *azservicebus.ReceivedMessage) error {
+		return context.DeadlineExceeded
+	}))
+	start := time.Now()
+	handler.Handle(context.Background(), settler, &azservicebus.ReceivedMessage{DeliveryCount: 4})
+	g.Expect(settler.abandoned).To(BeTrue())
+	g.Expect(time.Since(start)).To(BeNumerically(">=", 8*time.Millisecond))
+}
+
+func TestSender_BackpressureBackoff(t *testing.T) {
+	g := NewWithT(t)
+	azSender := &fakeAzSender{SendMessageErr: errServerBusy}
+	sender := NewSenderWithOptions(azSender, WithBackpressure(BackpressureOptions{
+		Window:  time.Minute,
+		Backoff: LinearBackoff{Initial: time.Second, Step: time.Second},
+	}))
+	for i := 0; i < 3; i++ {
+		g.Expect(sender.SendMessage(context.Background(), "hello")).ToNot(Succeed())
+	}
+	g.Expect(sender.Backpressure().Delay).To(Equal(3 * time.Second))
+}
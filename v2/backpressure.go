@@ -0,0 +1,125 @@
+package shuttle
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	defaultBackpressureWindow    = 10 * time.Second
+	defaultBackpressureBaseDelay = 100 * time.Millisecond
+	defaultBackpressureMaxDelay  = 5 * time.Second
+)
+
+// BackpressureOptions configures how the Sender reacts to the throttling of the namespace.
+type BackpressureOptions struct {
+	// Window is the time after the last throttled operation during which the sender is considered throttled.
+	// Defaults to 10 seconds.
+	Window time.Duration
+	// DelaySends waits before each send while the sender is throttled, to smooth the bursts instead of
+	// hammering the namespace with retries. The delay is not counted in the SendTimeout.
+	DelaySends bool
+	// BaseDelay is the delay after one throttled operation. It doubles with each consecutive one. Defaults to 100ms.
+	BaseDelay time.Duration
+	// MaxDelay caps the delay. Defaults to 5 seconds.
+	MaxDelay time.Duration
+	// Backoff computes the delay from the number of consecutive throttled operations, instead of BaseDelay and MaxDelay.
+	// Defaults to an ExponentialBackoff from BaseDelay to MaxDelay.
+	Backoff Backoff
+}
+
+// Backpressure reports the recent throttling of the Sender, for producers to slow down or shed load.
+type Backpressure struct {
+	// Throttled is true when an operation was throttled within the BackpressureOptions.Window.
+	Throttled bool
+	// ConsecutiveThrottles counts the throttled operations. It is decreased by each successful operation.
+	ConsecutiveThrottles int
+	// LastThrottledAt is the time of the last throttled operation.
+	LastThrottledAt time.Time
+	// Delay is the delay applied before the sends when BackpressureOptions.DelaySends is set.
+	Delay time.Duration
+}
+
+// WithBackpressure configures how the sender reacts to the throttling of the namespace.
+func WithBackpressure(options BackpressureOptions) SenderOption {
+	return func(o *SenderOptions) {
+		o.Backpressure = &options
+	}
+}
+
+// backpressureTracker tracks the throttled operations of a Sender.
+type backpressureTracker struct {
+	options BackpressureOptions
+	clock   Clock
+
+	mu                   sync.Mutex
+	consecutiveThrottles int
+	lastThrottledAt      time.Time
+}
+
+func newBackpressureTracker(options *BackpressureOptions, clock Clock) *backpressureTracker {
+	opts := BackpressureOptions{}
+	if options != nil {
+		opts = *options
+	}
+	if opts.Window <= 0 {
+		opts.Window = defaultBackpressureWindow
+	}
+	if opts.BaseDelay <= 0 {
+		opts.BaseDelay = defaultBackpressureBaseDelay
+	}
+	if opts.MaxDelay <= 0 {
+		opts.MaxDelay = defaultBackpressureMaxDelay
+	}
+	if opts.Backoff == nil {
+		opts.Backoff = ExponentialBackoff{Initial: opts.BaseDelay, Max: opts.MaxDelay}
+	}
+	return &backpressureTracker{options: opts, clock: clockOrDefault(clock)}
+}
+
+func (t *backpressureTracker) record(err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if isThrottlingError(err) {
+		t.consecutiveThrottles++
+		t.lastThrottledAt = t.clock.Now()
+		return
+	}
+	if err == nil && t.consecutiveThrottles > 0 {
+		t.consecutiveThrottles--
+	}
+}
+
+func (t *backpressureTracker) state() Backpressure {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	state := Backpressure{ConsecutiveThrottles: t.consecutiveThrottles, LastThrottledAt: t.lastThrottledAt}
+	if t.lastThrottledAt.IsZero() || t.clock.Now().Sub(t.lastThrottledAt) >= t.options.Window {
+		return state
+	}
+	state.Throttled = true
+	if t.consecutiveThrottles > 0 {
+		state.Delay = t.options.Backoff.Delay(t.consecutiveThrottles)
+	}
+	return state
+}
+
+// wait delays the send while the sender is throttled, when DelaySends is set.
+func (t *backpressureTracker) wait(ctx context.Context) error {
+	if !t.options.DelaySends {
+		return nil
+	}
+	delay := t.state().Delay
+	if delay <= 0 {
+		return nil
+	}
+	log(ctx, fmt.Sprintf("namespace is throttling, delaying send by %s", delay))
+	select {
+	case <-ctx.Done():
+		return fmt.Errorf("failed to send message while throttled: %w", ctx.Err())
+	case <-t.clock.After(delay):
+		return nil
+	}
+}
@@ -0,0 +1,74 @@
+package shuttle
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Azure/go-amqp"
+	. "github.com/onsi/gomega"
+)
+
+var errServerBusy error = &amqp.Error{Condition: serverBusyCondition, Description: "the request was throttled"}
+
+func TestSender_Backpressure(t *testing.T) {
+	g := NewWithT(t)
+	azSender := &fakeAzSender{SendMessageErr: errServerBusy}
+	sender := NewSenderWithOptions(azSender, WithBackpressure(BackpressureOptions{Window: time.Minute, BaseDelay: time.Second, MaxDelay: 3 * time.Second}))
+	g.Expect(sender.Backpressure().Throttled).To(BeFalse())
+
+	for i := 0; i < 3; i++ {
+		g.Expect(sender.SendMessage(context.Background(), "hello")).ToNot(Succeed())
+	}
+	state := sender.Backpressure()
+	g.Expect(state.Throttled).To(BeTrue())
+	g.Expect(state.ConsecutiveThrottles).To(Equal(3))
+	g.Expect(state.Delay).To(Equal(3 * time.Second))
+
+	azSender.SendMessageErr = nil
+	g.Expect(sender.SendMessage(context.Background(), "hello")).To(Succeed())
+	state = sender.Backpressure()
+	g.Expect(state.ConsecutiveThrottles).To(Equal(2))
+	g.Expect(state.Delay).To(Equal(2 * time.Second))
+}
+
+func TestSender_BackpressureWindow(t *testing.T) {
+	g := NewWithT(t)
+	azSender := &fakeAzSender{SendMessageErr: errServerBusy}
+	sender := NewSenderWithOptions(azSender, WithBackpressure(BackpressureOptions{Window: 20 * time.Millisecond}))
+	g.Expect(sender.SendMessage(context.Background(), "hello")).ToNot(Succeed())
+	g.Expect(sender.Backpressure().Throttled).To(BeTrue())
+	g.Eventually(func() bool { return sender.Backpressure().Throttled }).Should(BeFalse())
+}
+
+func TestSender_BackpressureIgnoresOtherErrors(t *testing.T) {
+	g := NewWithT(t)
+	azSender := &fakeAzSender{SendMessageErr: errors.New("connection lost")}
+	sender := NewSender(azSender, nil)
+	g.Expect(sender.SendMessage(context.Background(), "hello")).ToNot(Succeed())
+	g.Expect(sender.Backpressure().Throttled).To(BeFalse())
+}
+
+func TestSender_BackpressureDelaysSends(t *testing.T) {
+	g := NewWithT(t)
+	azSender := &fakeAzSender{SendMessageErr: errServerBusy}
+	sender := NewSenderWithOptions(azSender, WithBackpressure(BackpressureOptions{
+		DelaySends: true,
+		BaseDelay:  50 * time.Millisecond,
+	}))
+	g.Expect(sender.SendMessage(context.Background(), "hello")).ToNot(Succeed())
+
+	azSender.SendMessageErr = nil
+	start := time.Now()
+	g.Expect(sender.SendMessage(context.Background(), "hello")).To(Succeed())
+	g.Expect(time.Since(start)).To(BeNumerically(">=", 50*time.Millisecond))
+
+	// the delay is aborted with the context
+	azSender.SendMessageErr = errServerBusy
+	g.Expect(sender.SendMessage(context.Background(), "hello")).ToNot(Succeed())
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := sender.SendMessage(ctx, "hello")
+	g.Expect(err).To(MatchError(context.Canceled))
+}
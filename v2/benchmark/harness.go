@@ -0,0 +1,127 @@
+// Package benchmark provides a harness to benchmark message handlers running in a shuttle.Processor
+// without a connection to service bus.
+package benchmark
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+
+	"github.com/Azure/go-shuttle/v2"
+)
+
+var _ shuttle.Receiver = &Receiver{}
+
+// Receiver is an in-memory shuttle.Receiver that serves a fixed number of messages
+// and counts the messages settled by the handler.
+type Receiver struct {
+	newMessage func(i int) *azservicebus.ReceivedMessage
+	total      int
+	served     int
+	settled    atomic.Int64
+	mu         sync.Mutex
+	done       chan struct{}
+	doneOnce   sync.Once
+}
+
+// NewReceiver creates a Receiver serving total messages created by newMessage.
+// a nil newMessage serves empty messages.
+func NewReceiver(total int, newMessage func(i int) *azservicebus.ReceivedMessage) *Receiver {
+	if newMessage == nil {
+		newMessage = func(_ int) *azservicebus.ReceivedMessage { return &azservicebus.ReceivedMessage{} }
+	}
+	r := &Receiver{newMessage: newMessage, total: total, done: make(chan struct{})}
+	if total == 0 {
+		r.doneOnce.Do(func() { close(r.done) })
+	}
+	return r
+}
+
+// Done is closed when all the messages have been settled.
+func (r *Receiver) Done() <-chan struct{} {
+	return r.done
+}
+
+// Settled returns the number of messages settled so far.
+func (r *Receiver) Settled() int {
+	return int(r.settled.Load())
+}
+
+func (r *Receiver) ReceiveMessages(_ context.Context, maxMessages int, _ *azservicebus.ReceiveMessagesOptions) ([]*azservicebus.ReceivedMessage, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var messages []*azservicebus.ReceivedMessage
+	for len(messages) < maxMessages && r.served < r.total {
+		messages = append(messages, r.newMessage(r.served))
+		r.served++
+	}
+	return messages, nil
+}
+
+func (r *Receiver) settle() error {
+	if int(r.settled.Add(1)) == r.total {
+		r.doneOnce.Do(func() { close(r.done) })
+	}
+	return nil
+}
+
+func (r *Receiver) AbandonMessage(_ context.Context, _ *azservicebus.ReceivedMessage, _ *azservicebus.AbandonMessageOptions) error {
+	return r.settle()
+}
+
+func (r *Receiver) CompleteMessage(_ context.Context, _ *azservicebus.ReceivedMessage, _ *azservicebus.CompleteMessageOptions) error {
+	return r.settle()
+}
+
+func (r *Receiver) DeadLetterMessage(_ context.Context, _ *azservicebus.ReceivedMessage, _ *azservicebus.DeadLetterOptions) error {
+	return r.settle()
+}
+
+func (r *Receiver) DeferMessage(_ context.Context, _ *azservicebus.ReceivedMessage, _ *azservicebus.DeferMessageOptions) error {
+	return r.settle()
+}
+
+func (r *Receiver) RenewMessageLock(_ context.Context, _ *azservicebus.ReceivedMessage, _ *azservicebus.RenewMessageLockOptions) error {
+	return nil
+}
+
+// Options configures RunProcessor.
+type Options struct {
+	// ProcessorOptions are passed to the processor. ReceiveInterval defaults to 1ms to keep the pump busy.
+	ProcessorOptions shuttle.ProcessorOptions
+	// NewMessage creates the i-th message served to the processor. Defaults to empty messages.
+	NewMessage func(i int) *azservicebus.ReceivedMessage
+}
+
+// RunProcessor pumps b.N messages through a shuttle.Processor running the handler,
+// and stops the benchmark timer once all of them are settled. The handler must settle every message.
+func RunProcessor(b *testing.B, handler shuttle.HandlerFunc, options *Options) {
+	b.Helper()
+	if options == nil {
+		options = &Options{}
+	}
+	processorOptions := options.ProcessorOptions
+	if processorOptions.ReceiveInterval == nil {
+		processorOptions.ReceiveInterval = to.Ptr(1 * time.Millisecond)
+	}
+	receiver := NewReceiver(b.N, options.NewMessage)
+	p := shuttle.NewProcessor(receiver, handler, &processorOptions)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errCh := make(chan error, 1)
+	b.ResetTimer()
+	go func() { errCh <- p.Start(ctx) }()
+	select {
+	case <-receiver.Done():
+		b.StopTimer()
+	case err := <-errCh:
+		b.Fatalf("processor exited before all messages were settled: %s", err)
+	}
+	cancel()
+	<-errCh
+}
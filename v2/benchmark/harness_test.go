@@ -0,0 +1,87 @@
+package benchmark_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+
+	"github.com/Azure/go-shuttle/v2"
+	"github.com/Azure/go-shuttle/v2/benchmark"
+)
+
+func completingHandler(work time.Duration) shuttle.HandlerFunc {
+	return func(ctx context.Context, settler shuttle.MessageSettler, message *azservicebus.ReceivedMessage) {
+		time.Sleep(work)
+		_ = settler.CompleteMessage(ctx, message, nil)
+	}
+}
+
+func typedMessage(i int) *azservicebus.ReceivedMessage {
+	msgType := "OrderCreated"
+	if i%2 == 0 {
+		msgType = "OrderDeleted"
+	}
+	return &azservicebus.ReceivedMessage{ApplicationProperties: map[string]any{"type": msgType}}
+}
+
+// BenchmarkProcessor_Concurrency compares the processor throughput for a handler doing 1ms of work
+// across different MaxConcurrency settings.
+func BenchmarkProcessor_Concurrency(b *testing.B) {
+	for _, concurrency := range []int{1, 10, 100} {
+		b.Run(fmt.Sprintf("MaxConcurrency=%d", concurrency), func(b *testing.B) {
+			benchmark.RunProcessor(b, completingHandler(time.Millisecond), &benchmark.Options{
+				ProcessorOptions: shuttle.ProcessorOptions{MaxConcurrency: concurrency, EntityName: "benchmark"},
+				NewMessage:       typedMessage,
+			})
+		})
+	}
+}
+
+// BenchmarkProcessor_Middlewares measures the overhead of the built-in middlewares.
+func BenchmarkProcessor_Middlewares(b *testing.B) {
+	handler := shuttle.NewPanicHandler(nil,
+		shuttle.NewTracingHandler(
+			shuttle.NewSettlementHandler(nil,
+				func(_ context.Context, _ *azservicebus.ReceivedMessage) shuttle.Settlement {
+					return &shuttle.Complete{}
+				})))
+	benchmark.RunProcessor(b, handler, &benchmark.Options{
+		ProcessorOptions: shuttle.ProcessorOptions{MaxConcurrency: 10},
+	})
+}
+
+func TestRunProcessor_SettlesAllMessages(t *testing.T) {
+	result := testing.Benchmark(func(b *testing.B) {
+		benchmark.RunProcessor(b, completingHandler(0), &benchmark.Options{
+			ProcessorOptions: shuttle.ProcessorOptions{MaxConcurrency: 5},
+		})
+	})
+	if result.N == 0 {
+		t.Errorf("expected the benchmark to run at least once")
+	}
+}
+
+func TestReceiver_ServesTotalMessages(t *testing.T) {
+	r := benchmark.NewReceiver(3, nil)
+	messages, err := r.ReceiveMessages(context.Background(), 10, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(messages) != 3 {
+		t.Errorf("expected 3 messages, got %d", len(messages))
+	}
+	for _, m := range messages {
+		_ = r.CompleteMessage(context.Background(), m, nil)
+	}
+	select {
+	case <-r.Done():
+	default:
+		t.Errorf("expected the receiver to be done after all messages were settled")
+	}
+	if r.Settled() != 3 {
+		t.Errorf("expected 3 settled messages, got %d", r.Settled())
+	}
+}
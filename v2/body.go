@@ -0,0 +1,80 @@
+package shuttle
+
+import (
+	"context"
+	"reflect"
+	"sync"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+)
+
+type decodedBodiesKey struct{}
+
+// decodedBodies stores the bodies of a message decoded by DecodedBody, keyed by their type.
+type decodedBodies struct {
+	mu     sync.Mutex
+	bodies map[reflect.Type]*decodedBody
+}
+
+type decodedBody struct {
+	once  sync.Once
+	value any
+	err   error
+}
+
+// Unmarshal unmarshals the body of the received message into a new T with the marshaller.
+// For the DefaultProtoMarshaller, T is the generated struct, such as Unmarshal[pb.Order](marshaller, message).
+// The bodies sent in a value section are unmarshalled too, see BodyBytes.
+func Unmarshal[T any](m Marshaller, msg *azservicebus.ReceivedMessage) (*T, error) {
+	data, err := BodyBytes(msg)
+	if err != nil {
+		return nil, err
+	}
+	body := new(T)
+	if err := m.Unmarshal(&azservicebus.Message{Body: data, ContentType: msg.ContentType}, body); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+// DecodedBody unmarshals the body of the received message into a T like Unmarshal, and stores it in the context
+// set by NewDecodedBodyHandler, so that the middlewares and the handler decode the body once.
+// The later calls for the same T return the same value, or the same error, which must not be modified.
+// Without NewDecodedBodyHandler, the body is unmarshalled on every call.
+func DecodedBody[T any](ctx context.Context, m Marshaller, msg *azservicebus.ReceivedMessage) (*T, error) {
+	cache, ok := ctx.Value(decodedBodiesKey{}).(*decodedBodies)
+	if !ok {
+		return Unmarshal[T](m, msg)
+	}
+	entry := cache.get(reflect.TypeOf((*T)(nil)).Elem())
+	entry.once.Do(func() {
+		entry.value, entry.err = Unmarshal[T](m, msg)
+	})
+	if entry.err != nil {
+		return nil, entry.err
+	}
+	return entry.value.(*T), nil
+}
+
+// NewDecodedBodyHandler is a middleware that stores the bodies decoded by DecodedBody in the context of the message.
+// It must be placed before the middlewares and the handler that decode the body.
+// The bodies already stored by an outer NewDecodedBodyHandler are reused.
+func NewDecodedBodyHandler(next Handler) HandlerFunc {
+	return func(ctx context.Context, settler MessageSettler, message *azservicebus.ReceivedMessage) {
+		if _, ok := ctx.Value(decodedBodiesKey{}).(*decodedBodies); !ok {
+			ctx = context.WithValue(ctx, decodedBodiesKey{}, &decodedBodies{bodies: map[reflect.Type]*decodedBody{}})
+		}
+		next.Handle(ctx, settler, message)
+	}
+}
+
+func (d *decodedBodies) get(t reflect.Type) *decodedBody {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	entry, ok := d.bodies[t]
+	if !ok {
+		entry = &decodedBody{}
+		d.bodies[t] = entry
+	}
+	return entry
+}
@@ -0,0 +1,81 @@
+package shuttle
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	. "github.com/onsi/gomega"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// countingMarshaller counts the calls to Unmarshal.
+type countingMarshaller struct {
+	Marshaller
+	unmarshalCount int
+}
+
+func (c *countingMarshaller) Unmarshal(msg *azservicebus.Message, mb MessageBody) error {
+	c.unmarshalCount++
+	return c.Marshaller.Unmarshal(msg, mb)
+}
+
+func TestUnmarshal(t *testing.T) {
+	g := NewWithT(t)
+	msg, err := (&DefaultJSONMarshaller{}).Marshal(testStruct)
+	g.Expect(err).ToNot(HaveOccurred())
+	request, err := Unmarshal[ContosoCreateUserRequest](&DefaultJSONMarshaller{}, &azservicebus.ReceivedMessage{Body: msg.Body})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(request).To(Equal(testStruct))
+
+	msg, err = (&DefaultProtoMarshaller{}).Marshal(wrapperspb.String("hello"))
+	g.Expect(err).ToNot(HaveOccurred())
+	value, err := Unmarshal[wrapperspb.StringValue](&DefaultProtoMarshaller{}, &azservicebus.ReceivedMessage{Body: msg.Body})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(value.GetValue()).To(Equal("hello"))
+
+	_, err = Unmarshal[ContosoCreateUserRequest](&DefaultJSONMarshaller{}, &azservicebus.ReceivedMessage{Body: []byte("{")})
+	g.Expect(err).To(HaveOccurred())
+}
+
+func TestDecodedBody(t *testing.T) {
+	g := NewWithT(t)
+	marshaller := &countingMarshaller{Marshaller: &DefaultJSONMarshaller{}}
+	msg, err := marshaller.Marshal(testStruct)
+	g.Expect(err).ToNot(HaveOccurred())
+	message := &azservicebus.ReceivedMessage{Body: msg.Body}
+
+	// without the middleware, the body is decoded on every call
+	_, err = DecodedBody[ContosoCreateUserRequest](context.Background(), marshaller, message)
+	g.Expect(err).ToNot(HaveOccurred())
+	_, err = DecodedBody[ContosoCreateUserRequest](context.Background(), marshaller, message)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(marshaller.unmarshalCount).To(Equal(2))
+
+	marshaller.unmarshalCount = 0
+	var fromMiddleware, fromHandler *ContosoCreateUserRequest
+	handler := NewDecodedBodyHandler(HandlerFunc(func(ctx context.Context, settler MessageSettler, message *azservicebus.ReceivedMessage) {
+		fromMiddleware, err = DecodedBody[ContosoCreateUserRequest](ctx, marshaller, message)
+		g.Expect(err).ToNot(HaveOccurred())
+		fromHandler, err = DecodedBody[ContosoCreateUserRequest](ctx, marshaller, message)
+		g.Expect(err).ToNot(HaveOccurred())
+		// a different type is decoded separately
+		_, err = DecodedBody[map[string]any](ctx, marshaller, message)
+		g.Expect(err).ToNot(HaveOccurred())
+	}))
+	handler(context.Background(), nil, message)
+	g.Expect(fromHandler).To(BeIdenticalTo(fromMiddleware))
+	g.Expect(fromHandler).To(Equal(testStruct))
+	g.Expect(marshaller.unmarshalCount).To(Equal(2))
+
+	// the errors are cached too
+	marshaller.unmarshalCount = 0
+	handler = NewDecodedBodyHandler(HandlerFunc(func(ctx context.Context, settler MessageSettler, message *azservicebus.ReceivedMessage) {
+		for i := 0; i < 2; i++ {
+			_, err := DecodedBody[ContosoCreateUserRequest](ctx, marshaller, message)
+			g.Expect(err).To(HaveOccurred())
+		}
+	}))
+	handler(context.Background(), nil, &azservicebus.ReceivedMessage{Body: []byte("{")})
+	g.Expect(marshaller.unmarshalCount).To(Equal(1))
+}
@@ -0,0 +1,156 @@
+package shuttle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+)
+
+const defaultBrowsePageSize = 100
+
+// ErrMessageNotFound is returned by PeekMessage when no message has the sequence number.
+var ErrMessageNotFound = errors.New("message not found")
+
+// errStopBrowsing stops BrowseMessages once the limit is reached.
+var errStopBrowsing = errors.New("stop browsing")
+
+// MessageStateName returns the name of the state: active, deferred or scheduled.
+func MessageStateName(state azservicebus.MessageState) string {
+	switch state {
+	case azservicebus.MessageStateActive:
+		return "active"
+	case azservicebus.MessageStateDeferred:
+		return "deferred"
+	case azservicebus.MessageStateScheduled:
+		return "scheduled"
+	default:
+		return fmt.Sprintf("unknown(%d)", state)
+	}
+}
+
+// SequenceNumber returns the sequence number assigned to the message by the broker.
+// It returns false when the message was not received from the broker.
+func SequenceNumber(message *azservicebus.ReceivedMessage) (int64, bool) {
+	if message.SequenceNumber == nil {
+		return 0, false
+	}
+	return *message.SequenceNumber, true
+}
+
+// EnqueuedSequenceNumber returns the sequence number of the message in the entity it was first enqueued to,
+// before it was auto-forwarded. It returns false when the broker did not set it.
+func EnqueuedSequenceNumber(message *azservicebus.ReceivedMessage) (int64, bool) {
+	if message.EnqueuedSequenceNumber == nil {
+		return 0, false
+	}
+	return *message.EnqueuedSequenceNumber, true
+}
+
+// BrowseOptions configures BrowseMessages.
+type BrowseOptions struct {
+	// PageSize is the number of messages peeked per request. Defaults to 100.
+	PageSize int
+	// FromSequenceNumber is the sequence number of the first message to browse. Defaults to the first message.
+	FromSequenceNumber int64
+	// States selects the messages in these states. Defaults to all the states.
+	States []azservicebus.MessageState
+	// Limit is the maximum number of selected messages. Defaults to 0, browsing the whole entity.
+	Limit int
+}
+
+// BrowseMessages calls fn with the messages of the entity in sequence number order, to build support tooling
+// such as the inspection of a backlog. The messages are peeked: they stay in the entity, and are not locked nor settled.
+// The peeker is a receiver of the queue or subscription, or of its dead-letter queue.
+// Browsing stops at the first error returned by fn, which is returned as is.
+func BrowseMessages(ctx context.Context, peeker MessagePeeker, options *BrowseOptions, fn func(msg *azservicebus.ReceivedMessage) error) error {
+	opts := BrowseOptions{}
+	if options != nil {
+		opts = *options
+	}
+	selected := 0
+	err := browsePages(ctx, peeker, opts.PageSize, opts.FromSequenceNumber, func(page []*azservicebus.ReceivedMessage) error {
+		for _, msg := range page {
+			if len(opts.States) > 0 && !slices.Contains(opts.States, msg.State) {
+				continue
+			}
+			if err := fn(msg); err != nil {
+				return err
+			}
+			if selected++; opts.Limit > 0 && selected >= opts.Limit {
+				return errStopBrowsing
+			}
+		}
+		return nil
+	})
+	if errors.Is(err, errStopBrowsing) {
+		return nil
+	}
+	return err
+}
+
+// PeekFromSequence returns up to count messages of the entity, starting at the sequence number.
+func PeekFromSequence(ctx context.Context, peeker MessagePeeker, sequenceNumber int64, count int) ([]*azservicebus.ReceivedMessage, error) {
+	var messages []*azservicebus.ReceivedMessage
+	err := BrowseMessages(ctx, peeker, &BrowseOptions{PageSize: count, FromSequenceNumber: sequenceNumber, Limit: count},
+		func(msg *azservicebus.ReceivedMessage) error {
+			messages = append(messages, msg)
+			return nil
+		})
+	return messages, err
+}
+
+// PeekMessage returns the message with the sequence number, in any state. It returns ErrMessageNotFound
+// when the message was settled or expired.
+func PeekMessage(ctx context.Context, peeker MessagePeeker, sequenceNumber int64) (*azservicebus.ReceivedMessage, error) {
+	messages, err := PeekFromSequence(ctx, peeker, sequenceNumber, 1)
+	if err != nil {
+		return nil, err
+	}
+	if len(messages) == 0 || messages[0].SequenceNumber == nil || *messages[0].SequenceNumber != sequenceNumber {
+		return nil, fmt.Errorf("%w: sequence number %d", ErrMessageNotFound, sequenceNumber)
+	}
+	return messages[0], nil
+}
+
+// CountMessagesByState browses the entity and counts its messages per state.
+// Unlike the runtime properties of the entity, the counts are consistent with the messages that can be browsed.
+func CountMessagesByState(ctx context.Context, peeker MessagePeeker, pageSize int) (map[azservicebus.MessageState]int, error) {
+	counts := map[azservicebus.MessageState]int{}
+	err := BrowseMessages(ctx, peeker, &BrowseOptions{PageSize: pageSize}, func(msg *azservicebus.ReceivedMessage) error {
+		counts[msg.State]++
+		return nil
+	})
+	return counts, err
+}
+
+// browsePages pages through the entity from the sequence number, and calls onPage with the messages of each page.
+func browsePages(
+	ctx context.Context,
+	peeker MessagePeeker,
+	pageSize int,
+	from int64,
+	onPage func(page []*azservicebus.ReceivedMessage) error) error {
+	if pageSize <= 0 {
+		pageSize = defaultBrowsePageSize
+	}
+	for {
+		messages, err := peeker.PeekMessages(ctx, pageSize, &azservicebus.PeekMessagesOptions{FromSequenceNumber: &from})
+		if err != nil {
+			return fmt.Errorf("failed to peek messages: %w", err)
+		}
+		if len(messages) == 0 {
+			return nil
+		}
+		for _, msg := range messages {
+			if msg.SequenceNumber != nil && *msg.SequenceNumber >= from {
+				from = *msg.SequenceNumber + 1
+			}
+		}
+		if err := onPage(messages); err != nil {
+			return err
+		}
+	}
+}
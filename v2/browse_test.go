@@ -0,0 +1,120 @@
+package shuttle_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	. "github.com/onsi/gomega"
+
+	"github.com/Azure/go-shuttle/v2"
+)
+
+// browsePeeker holds 10 messages, from sequence number 1 to 10, every third one deferred and the last one scheduled.
+func browsePeeker() *fakePeeker {
+	peeker := &fakePeeker{}
+	for i := int64(1); i <= 10; i++ {
+		state := azservicebus.MessageStateActive
+		if i%3 == 0 {
+			state = azservicebus.MessageStateDeferred
+		}
+		if i == 10 {
+			state = azservicebus.MessageStateScheduled
+		}
+		peeker.messages = append(peeker.messages, &azservicebus.ReceivedMessage{SequenceNumber: to.Ptr(i), State: state})
+	}
+	return peeker
+}
+
+func sequenceNumbers(messages []*azservicebus.ReceivedMessage) []int64 {
+	var numbers []int64
+	for _, msg := range messages {
+		number, _ := shuttle.SequenceNumber(msg)
+		numbers = append(numbers, number)
+	}
+	return numbers
+}
+
+func TestBrowseMessages(t *testing.T) {
+	g := NewWithT(t)
+	peeker := browsePeeker()
+	var browsed []*azservicebus.ReceivedMessage
+	err := shuttle.BrowseMessages(context.Background(), peeker, &shuttle.BrowseOptions{
+		PageSize:           2,
+		FromSequenceNumber: 2,
+		States:             []azservicebus.MessageState{azservicebus.MessageStateDeferred},
+	}, func(msg *azservicebus.ReceivedMessage) error {
+		browsed = append(browsed, msg)
+		return nil
+	})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(sequenceNumbers(browsed)).To(Equal([]int64{3, 6, 9}))
+
+	// the limit stops browsing without peeking the whole entity
+	peeker.peekCalls = 0
+	browsed = nil
+	err = shuttle.BrowseMessages(context.Background(), peeker, &shuttle.BrowseOptions{PageSize: 2, Limit: 3},
+		func(msg *azservicebus.ReceivedMessage) error {
+			browsed = append(browsed, msg)
+			return nil
+		})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(sequenceNumbers(browsed)).To(Equal([]int64{1, 2, 3}))
+	g.Expect(peeker.peekCalls).To(Equal(2))
+
+	stop := errors.New("stop")
+	g.Expect(shuttle.BrowseMessages(context.Background(), peeker, nil, func(*azservicebus.ReceivedMessage) error {
+		return stop
+	})).To(MatchError(stop))
+	peeker.err = errors.New("unauthorized")
+	g.Expect(shuttle.BrowseMessages(context.Background(), peeker, nil, func(*azservicebus.ReceivedMessage) error {
+		return nil
+	})).To(MatchError(peeker.err))
+}
+
+func TestPeekFromSequence(t *testing.T) {
+	g := NewWithT(t)
+	peeker := browsePeeker()
+	messages, err := shuttle.PeekFromSequence(context.Background(), peeker, 4, 3)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(sequenceNumbers(messages)).To(Equal([]int64{4, 5, 6}))
+
+	msg, err := shuttle.PeekMessage(context.Background(), peeker, 10)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(shuttle.MessageStateName(msg.State)).To(Equal("scheduled"))
+
+	// a settled message is not found, even when later messages exist
+	peeker.messages = append(peeker.messages[:4], peeker.messages[5:]...)
+	_, err = shuttle.PeekMessage(context.Background(), peeker, 5)
+	g.Expect(err).To(MatchError(shuttle.ErrMessageNotFound))
+}
+
+func TestCountMessagesByState(t *testing.T) {
+	g := NewWithT(t)
+	counts, err := shuttle.CountMessagesByState(context.Background(), browsePeeker(), 4)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(counts).To(Equal(map[azservicebus.MessageState]int{
+		azservicebus.MessageStateActive:    6,
+		azservicebus.MessageStateDeferred:  3,
+		azservicebus.MessageStateScheduled: 1,
+	}))
+}
+
+func TestMessageHelpers(t *testing.T) {
+	g := NewWithT(t)
+	g.Expect(shuttle.MessageStateName(azservicebus.MessageStateActive)).To(Equal("active"))
+	g.Expect(shuttle.MessageStateName(azservicebus.MessageStateDeferred)).To(Equal("deferred"))
+	g.Expect(shuttle.MessageStateName(azservicebus.MessageState(7))).To(Equal("unknown(7)"))
+
+	msg := &azservicebus.ReceivedMessage{}
+	_, ok := shuttle.SequenceNumber(msg)
+	g.Expect(ok).To(BeFalse())
+	_, ok = shuttle.EnqueuedSequenceNumber(msg)
+	g.Expect(ok).To(BeFalse())
+	msg.EnqueuedSequenceNumber = to.Ptr(int64(42))
+	enqueued, ok := shuttle.EnqueuedSequenceNumber(msg)
+	g.Expect(ok).To(BeTrue())
+	g.Expect(enqueued).To(Equal(int64(42)))
+}
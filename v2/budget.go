@@ -0,0 +1,160 @@
+package shuttle
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+)
+
+// BudgetLimit is what limits the ProcessingBudget of a message.
+type BudgetLimit string
+
+const (
+	// BudgetLimitLock is the expiry of the message lock, LockedUntil.
+	BudgetLimitLock BudgetLimit = "lock"
+	// BudgetLimitContext is the deadline of the handler context, such as a handler timeout or the message deadline.
+	BudgetLimitContext BudgetLimit = "context"
+	// BudgetLimitExpiry is the expiry of the message on the broker, see TimeToExpiry.
+	BudgetLimitExpiry BudgetLimit = "expiry"
+)
+
+// ProcessingBudget is the time left to process a message: the earliest of the expiry of its lock,
+// the deadline of the handler context and the expiry of the message.
+type ProcessingBudget struct {
+	// Deadline is the time by which the message must be processed, the Reserve deduced.
+	Deadline time.Time
+	// Limit is what sets the Deadline.
+	Limit BudgetLimit
+	// Remaining is the time left until the Deadline, when the budget was computed. It is negative when exceeded.
+	Remaining time.Duration
+}
+
+// ProcessingBudgetOptions configures NewProcessingBudgetHandler.
+type ProcessingBudgetOptions struct {
+	// Reserve is kept out of the budget, for the handler to settle the message once its downstream calls time out.
+	// Defaults to 1 second. Negative disables the reserve.
+	Reserve time.Duration
+	// IgnoreLock leaves the lock out of the budget, for the handlers wrapped in NewLockRenewalHandler
+	// whose lock is renewed for the duration of the handler.
+	IgnoreLock bool
+	// Clock is used to compute the remaining time. Defaults to the system clock.
+	Clock Clock
+}
+
+const defaultBudgetReserve = time.Second
+
+type processingBudgetKey struct{}
+
+type processingBudgetState struct {
+	message *azservicebus.ReceivedMessage
+	options ProcessingBudgetOptions
+	clock   Clock
+}
+
+// NewProcessingBudgetHandler is a middleware exposing the ProcessingBudget of the message in the context of next,
+// for the downstream clients to derive their timeouts from it with WithProcessingBudget or NewBudgetTransport,
+// instead of timing out long after the message lock was lost.
+// The budget is computed on each call, so it follows the renewals of the lock.
+func NewProcessingBudgetHandler(options *ProcessingBudgetOptions, next Handler) HandlerFunc {
+	opts := ProcessingBudgetOptions{}
+	if options != nil {
+		opts = *options
+	}
+	if opts.Reserve == 0 {
+		opts.Reserve = defaultBudgetReserve
+	}
+	if opts.Reserve < 0 {
+		opts.Reserve = 0
+	}
+	clock := clockOrDefault(opts.Clock)
+	return func(ctx context.Context, settler MessageSettler, message *azservicebus.ReceivedMessage) {
+		state := &processingBudgetState{message: message, options: opts, clock: clock}
+		next.Handle(context.WithValue(ctx, processingBudgetKey{}, state), settler, message)
+	}
+}
+
+// ProcessingBudgetFromContext returns the ProcessingBudget of the message handled in the context.
+// It returns false outside of NewProcessingBudgetHandler, or when nothing limits the processing of the message.
+func ProcessingBudgetFromContext(ctx context.Context) (ProcessingBudget, bool) {
+	state, ok := ctx.Value(processingBudgetKey{}).(*processingBudgetState)
+	if !ok {
+		return ProcessingBudget{}, false
+	}
+	return processingBudget(ctx, state.message, state.options, state.clock.Now())
+}
+
+func processingBudget(ctx context.Context, message *azservicebus.ReceivedMessage, options ProcessingBudgetOptions, now time.Time) (ProcessingBudget, bool) {
+	var budget ProcessingBudget
+	limit := func(deadline time.Time, limit BudgetLimit) {
+		if budget.Deadline.IsZero() || deadline.Before(budget.Deadline) {
+			budget.Deadline, budget.Limit = deadline, limit
+		}
+	}
+	if message.LockedUntil != nil && !options.IgnoreLock {
+		limit(*message.LockedUntil, BudgetLimitLock)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		limit(deadline, BudgetLimitContext)
+	}
+	if remaining, ok := timeToExpiry(message, now); ok {
+		limit(now.Add(remaining), BudgetLimitExpiry)
+	}
+	if budget.Deadline.IsZero() {
+		return ProcessingBudget{}, false
+	}
+	budget.Deadline = budget.Deadline.Add(-options.Reserve)
+	budget.Remaining = budget.Deadline.Sub(now)
+	return budget, true
+}
+
+// WithProcessingBudget returns a copy of ctx with the deadline of the ProcessingBudget of the message,
+// for a downstream call such as a database query. The ctx is returned unchanged outside of NewProcessingBudgetHandler.
+func WithProcessingBudget(ctx context.Context) (context.Context, context.CancelFunc) {
+	budget, ok := ProcessingBudgetFromContext(ctx)
+	if !ok {
+		return ctx, func() {}
+	}
+	return context.WithDeadline(ctx, budget.Deadline)
+}
+
+// NewBudgetTransport returns a http.RoundTripper applying WithProcessingBudget to the context of the requests,
+// so that the http.Client using it times out its calls made in a handler with the ProcessingBudget of the message:
+//
+//	client := &http.Client{Transport: shuttle.NewBudgetTransport(nil)}
+//
+// The base defaults to http.DefaultTransport.
+func NewBudgetTransport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &budgetTransport{base: base}
+}
+
+type budgetTransport struct {
+	base http.RoundTripper
+}
+
+func (t *budgetTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, cancel := WithProcessingBudget(req.Context())
+	res, err := t.base.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	// the deadline also applies to the reading of the body, until it is closed
+	res.Body = &cancelOnCloseBody{ReadCloser: res.Body, cancel: cancel}
+	return res, nil
+}
+
+type cancelOnCloseBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelOnCloseBody) Close() error {
+	defer b.cancel()
+	return b.ReadCloser.Close()
+}
@@ -0,0 +1,151 @@
+package shuttle_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	. "github.com/onsi/gomega"
+
+	"github.com/Azure/go-shuttle/v2"
+	"github.com/Azure/go-shuttle/v2/shuttletest"
+)
+
+func budgetOf(ctx context.Context, options *shuttle.ProcessingBudgetOptions, message *azservicebus.ReceivedMessage) (shuttle.ProcessingBudget, bool) {
+	var budget shuttle.ProcessingBudget
+	var ok bool
+	shuttle.NewProcessingBudgetHandler(options, shuttle.HandlerFunc(
+		func(ctx context.Context, settler shuttle.MessageSettler, message *azservicebus.ReceivedMessage) {
+			budget, ok = shuttle.ProcessingBudgetFromContext(ctx)
+		})).Handle(ctx, &fakeSettler{}, message)
+	return budget, ok
+}
+
+func TestProcessingBudget(t *testing.T) {
+	now := time.Date(2024, 1, 31, 10, 0, 0, 0, time.UTC)
+	clock := shuttletest.NewFakeClock(now)
+	deadlineCtx, cancel := context.WithDeadline(context.Background(), now.Add(20*time.Second))
+	defer cancel()
+	testCases := []struct {
+		name      string
+		ctx       context.Context
+		options   shuttle.ProcessingBudgetOptions
+		message   *azservicebus.ReceivedMessage
+		limit     shuttle.BudgetLimit
+		remaining time.Duration
+	}{
+		{
+			name:      "lock",
+			ctx:       deadlineCtx,
+			message:   &azservicebus.ReceivedMessage{LockedUntil: to.Ptr(now.Add(10 * time.Second))},
+			limit:     shuttle.BudgetLimitLock,
+			remaining: 9 * time.Second,
+		},
+		{
+			name:      "handler timeout",
+			ctx:       deadlineCtx,
+			message:   &azservicebus.ReceivedMessage{LockedUntil: to.Ptr(now.Add(time.Minute))},
+			limit:     shuttle.BudgetLimitContext,
+			remaining: 19 * time.Second,
+		},
+		{
+			name: "ttl",
+			ctx:  deadlineCtx,
+			message: &azservicebus.ReceivedMessage{
+				LockedUntil:  to.Ptr(now.Add(time.Minute)),
+				EnqueuedTime: to.Ptr(now.Add(-time.Minute)),
+				TimeToLive:   to.Ptr(65 * time.Second),
+			},
+			limit:     shuttle.BudgetLimitExpiry,
+			remaining: 4 * time.Second,
+		},
+		{
+			name:      "lock renewed for the handler",
+			ctx:       deadlineCtx,
+			options:   shuttle.ProcessingBudgetOptions{IgnoreLock: true, Reserve: -1},
+			message:   &azservicebus.ReceivedMessage{LockedUntil: to.Ptr(now.Add(10 * time.Second))},
+			limit:     shuttle.BudgetLimitContext,
+			remaining: 20 * time.Second,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+			tc.options.Clock = clock
+			budget, ok := budgetOf(tc.ctx, &tc.options, tc.message)
+			g.Expect(ok).To(BeTrue())
+			g.Expect(budget.Limit).To(Equal(tc.limit))
+			g.Expect(budget.Remaining).To(Equal(tc.remaining))
+			g.Expect(budget.Deadline).To(Equal(now.Add(tc.remaining)))
+		})
+	}
+}
+
+func TestProcessingBudget_Unlimited(t *testing.T) {
+	g := NewWithT(t)
+	_, ok := budgetOf(context.Background(), nil, &azservicebus.ReceivedMessage{})
+	g.Expect(ok).To(BeFalse())
+	_, ok = shuttle.ProcessingBudgetFromContext(context.Background())
+	g.Expect(ok).To(BeFalse())
+
+	ctx, cancel := shuttle.WithProcessingBudget(context.Background())
+	defer cancel()
+	_, ok = ctx.Deadline()
+	g.Expect(ok).To(BeFalse())
+}
+
+func TestProcessingBudget_FollowsLockRenewals(t *testing.T) {
+	g := NewWithT(t)
+	now := time.Now()
+	message := &azservicebus.ReceivedMessage{LockedUntil: to.Ptr(now.Add(10 * time.Second))}
+	shuttle.NewProcessingBudgetHandler(&shuttle.ProcessingBudgetOptions{Reserve: time.Second}, shuttle.HandlerFunc(
+		func(ctx context.Context, settler shuttle.MessageSettler, message *azservicebus.ReceivedMessage) {
+			callCtx, cancel := shuttle.WithProcessingBudget(ctx)
+			deadline, _ := callCtx.Deadline()
+			cancel()
+			g.Expect(deadline).To(Equal(now.Add(9 * time.Second)))
+
+			message.LockedUntil = to.Ptr(now.Add(time.Minute))
+			callCtx, cancel = shuttle.WithProcessingBudget(ctx)
+			deadline, _ = callCtx.Deadline()
+			cancel()
+			g.Expect(deadline).To(Equal(now.Add(59 * time.Second)))
+		})).Handle(context.Background(), &fakeSettler{}, message)
+}
+
+func TestBudgetTransport(t *testing.T) {
+	g := NewWithT(t)
+	release := make(chan struct{})
+	defer close(release)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			select {
+			case <-release:
+			case <-r.Context().Done():
+			}
+		}
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+	client := &http.Client{Transport: shuttle.NewBudgetTransport(nil)}
+
+	message := &azservicebus.ReceivedMessage{LockedUntil: to.Ptr(time.Now().Add(100 * time.Millisecond))}
+	shuttle.NewProcessingBudgetHandler(&shuttle.ProcessingBudgetOptions{Reserve: -1}, shuttle.HandlerFunc(
+		func(ctx context.Context, settler shuttle.MessageSettler, message *azservicebus.ReceivedMessage) {
+			req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/fast", nil)
+			res, err := client.Do(req)
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(res.Body.Close()).To(Succeed())
+
+			req, _ = http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/slow", nil)
+			start := time.Now()
+			_, err = client.Do(req)
+			g.Expect(errors.Is(err, context.DeadlineExceeded)).To(BeTrue())
+			g.Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+		})).Handle(context.Background(), &fakeSettler{}, message)
+}
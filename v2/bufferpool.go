@@ -0,0 +1,62 @@
+package shuttle
+
+import (
+	"bytes"
+	"sync"
+)
+
+// maxPooledBufferSize is the capacity above which buffers are not returned to the pool,
+// so that a few large messages do not keep their memory allocated for the lifetime of the process.
+const maxPooledBufferSize = 1 << 20
+
+// BufferPool provides the buffers used by the marshallers to serialize the message bodies.
+// The marshallers copy the serialized body out of the buffer before putting it back.
+type BufferPool interface {
+	Get() *bytes.Buffer
+	Put(buf *bytes.Buffer)
+}
+
+var _ BufferPool = &SyncBufferPool{}
+
+// SyncBufferPool is a BufferPool backed by a sync.Pool.
+type SyncBufferPool struct {
+	pool sync.Pool
+}
+
+// NewSyncBufferPool creates an empty SyncBufferPool.
+func NewSyncBufferPool() *SyncBufferPool {
+	return &SyncBufferPool{pool: sync.Pool{New: func() any { return &bytes.Buffer{} }}}
+}
+
+func (p *SyncBufferPool) Get() *bytes.Buffer {
+	buf := p.pool.Get().(*bytes.Buffer)
+	buf.Reset()
+	return buf
+}
+
+func (p *SyncBufferPool) Put(buf *bytes.Buffer) {
+	if buf.Cap() > maxPooledBufferSize {
+		return
+	}
+	p.pool.Put(buf)
+}
+
+// defaultBufferPool is shared by the marshallers that do not set their own BufferPool.
+var defaultBufferPool = NewSyncBufferPool()
+
+// noBufferPool allocates a new buffer for each message.
+type noBufferPool struct{}
+
+func (noBufferPool) Get() *bytes.Buffer { return &bytes.Buffer{} }
+func (noBufferPool) Put(*bytes.Buffer)  {}
+
+func bufferPoolOrDefault(pool BufferPool, disabled bool) BufferPool {
+	switch {
+	case disabled:
+		return noBufferPool{}
+	case pool != nil:
+		return pool
+	default:
+		return defaultBufferPool
+	}
+}
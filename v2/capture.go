@@ -0,0 +1,227 @@
+package shuttle
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+)
+
+// CapturedMessage is the serializable copy of a received message, written by NewCaptureHandler.
+// The ApplicationProperties go through JSON when written by NewJSONCaptureSink, so numbers are read back as float64.
+type CapturedMessage struct {
+	CapturedAt            time.Time      `json:"capturedAt"`
+	MessageID             string         `json:"messageId"`
+	CorrelationID         *string        `json:"correlationId,omitempty"`
+	SessionID             *string        `json:"sessionId,omitempty"`
+	PartitionKey          *string        `json:"partitionKey,omitempty"`
+	Subject               *string        `json:"subject,omitempty"`
+	ContentType           *string        `json:"contentType,omitempty"`
+	To                    *string        `json:"to,omitempty"`
+	ReplyTo               *string        `json:"replyTo,omitempty"`
+	ReplyToSessionID      *string        `json:"replyToSessionId,omitempty"`
+	ApplicationProperties map[string]any `json:"applicationProperties,omitempty"`
+	Body                  []byte         `json:"body"`
+	DeliveryCount         uint32         `json:"deliveryCount"`
+	EnqueuedTime          *time.Time     `json:"enqueuedTime,omitempty"`
+	SequenceNumber        *int64         `json:"sequenceNumber,omitempty"`
+	TimeToLive            *time.Duration `json:"timeToLive,omitempty"`
+	ScheduledEnqueueTime  *time.Time     `json:"scheduledEnqueueTime,omitempty"`
+}
+
+// NewCapturedMessage copies the body and metadata of the received message.
+func NewCapturedMessage(message *azservicebus.ReceivedMessage, capturedAt time.Time) CapturedMessage {
+	return CapturedMessage{
+		CapturedAt:            capturedAt,
+		MessageID:             message.MessageID,
+		CorrelationID:         message.CorrelationID,
+		SessionID:             message.SessionID,
+		PartitionKey:          message.PartitionKey,
+		Subject:               message.Subject,
+		ContentType:           message.ContentType,
+		To:                    message.To,
+		ReplyTo:               message.ReplyTo,
+		ReplyToSessionID:      message.ReplyToSessionID,
+		ApplicationProperties: message.ApplicationProperties,
+		Body:                  message.Body,
+		DeliveryCount:         message.DeliveryCount,
+		EnqueuedTime:          message.EnqueuedTime,
+		SequenceNumber:        message.SequenceNumber,
+		TimeToLive:            message.TimeToLive,
+		ScheduledEnqueueTime:  message.ScheduledEnqueueTime,
+	}
+}
+
+// ReceivedMessage rebuilds the received message from the capture.
+// The lock token is not captured: replayed messages can only be settled with a settler that does not call the broker.
+func (c CapturedMessage) ReceivedMessage() *azservicebus.ReceivedMessage {
+	return &azservicebus.ReceivedMessage{
+		MessageID:             c.MessageID,
+		CorrelationID:         c.CorrelationID,
+		SessionID:             c.SessionID,
+		PartitionKey:          c.PartitionKey,
+		Subject:               c.Subject,
+		ContentType:           c.ContentType,
+		To:                    c.To,
+		ReplyTo:               c.ReplyTo,
+		ReplyToSessionID:      c.ReplyToSessionID,
+		ApplicationProperties: c.ApplicationProperties,
+		Body:                  c.Body,
+		DeliveryCount:         c.DeliveryCount,
+		EnqueuedTime:          c.EnqueuedTime,
+		SequenceNumber:        c.SequenceNumber,
+		TimeToLive:            c.TimeToLive,
+		ScheduledEnqueueTime:  c.ScheduledEnqueueTime,
+	}
+}
+
+// CaptureSink stores the captured messages.
+// Implement it to write to a durable store such as blob storage.
+type CaptureSink interface {
+	Write(ctx context.Context, message CapturedMessage) error
+}
+
+// CaptureSinkFunc allows to use a func as a CaptureSink.
+type CaptureSinkFunc func(ctx context.Context, message CapturedMessage) error
+
+func (f CaptureSinkFunc) Write(ctx context.Context, message CapturedMessage) error {
+	return f(ctx, message)
+}
+
+type jsonCaptureSink struct {
+	mu      sync.Mutex
+	encoder *json.Encoder
+}
+
+// NewJSONCaptureSink writes the captured messages as JSON lines to w, for example a file.
+// The file can be read back with ReadCapturedMessages.
+func NewJSONCaptureSink(w io.Writer) CaptureSink {
+	return &jsonCaptureSink{encoder: json.NewEncoder(w)}
+}
+
+func (s *jsonCaptureSink) Write(_ context.Context, message CapturedMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.encoder.Encode(message)
+}
+
+// ReadCapturedMessages reads the JSON lines written by NewJSONCaptureSink.
+func ReadCapturedMessages(r io.Reader) ([]CapturedMessage, error) {
+	var messages []CapturedMessage
+	decoder := json.NewDecoder(bufio.NewReader(r))
+	for decoder.More() {
+		var message CapturedMessage
+		if err := decoder.Decode(&message); err != nil {
+			return messages, fmt.Errorf("failed to read captured message %d: %w", len(messages), err)
+		}
+		messages = append(messages, message)
+	}
+	return messages, nil
+}
+
+// NewCaptureHandler is a middleware that writes a copy of each received message to the sink before calling next,
+// to replay the live traffic locally with NewReplaySource when debugging an incident.
+// The properties and the body of the copy are masked by the DefaultRedactor, unless WithRedactor is used.
+// A failure to capture a message is logged and does not prevent its handling.
+func NewCaptureHandler(sink CaptureSink, next Handler, options ...RedactionOption) HandlerFunc {
+	redactor := redactorFrom(options)
+	return func(ctx context.Context, settler MessageSettler, message *azservicebus.ReceivedMessage) {
+		if message != nil {
+			captured := NewCapturedMessage(message, time.Now())
+			captured.ApplicationProperties = redactor.RedactProperties(captured.ApplicationProperties)
+			contentType, _ := deref(message.ContentType)
+			captured.Body = redactor.RedactBody(contentType, captured.Body)
+			if err := sink.Write(ctx, captured); err != nil {
+				log(ctx, fmt.Sprintf("failed to capture message %s: %s", message.MessageID, err))
+			}
+		}
+		next.Handle(ctx, settler, message)
+	}
+}
+
+// ReplayOptions configures the ReplaySource.
+type ReplayOptions struct {
+	// Speed is the replay pace relative to the capture: 1 replays at the original pace, 10 ten times faster.
+	// Defaults to 0, which replays the messages as fast as they are received.
+	Speed float64
+	// Clock is used to pace the replay. Defaults to the system clock.
+	Clock Clock
+}
+
+var _ MessageSource = &ReplaySource{}
+
+// ReplaySource is a MessageSource serving captured messages, to drive a Processor with the traffic captured by NewCaptureHandler.
+// Combine it with a MessageSettler in NewSourceReceiver.
+// Once all the messages are served, ReceiveMessages blocks until the context is done, like for an idle entity.
+type ReplaySource struct {
+	messages []CapturedMessage
+	options  ReplayOptions
+	mu       sync.Mutex
+	next     int
+	start    time.Time
+	done     chan struct{}
+}
+
+// NewReplaySource creates a ReplaySource serving the messages in order.
+func NewReplaySource(messages []CapturedMessage, options *ReplayOptions) *ReplaySource {
+	opts := ReplayOptions{}
+	if options != nil {
+		opts = *options
+	}
+	opts.Clock = clockOrDefault(opts.Clock)
+	s := &ReplaySource{messages: messages, options: opts, done: make(chan struct{})}
+	if len(messages) == 0 {
+		close(s.done)
+	}
+	return s
+}
+
+// Done is closed when all the messages have been served.
+func (s *ReplaySource) Done() <-chan struct{} {
+	return s.done
+}
+
+// ReceiveMessages returns up to maxMessages captured messages which are due according to the replay speed.
+func (s *ReplaySource) ReceiveMessages(ctx context.Context, maxMessages int, _ *azservicebus.ReceiveMessagesOptions) ([]*azservicebus.ReceivedMessage, error) {
+	s.mu.Lock()
+	if s.next >= len(s.messages) {
+		s.mu.Unlock()
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	defer s.mu.Unlock()
+	if s.start.IsZero() {
+		s.start = s.options.Clock.Now()
+	}
+	if wait := s.dueAt(s.next).Sub(s.options.Clock.Now()); wait > 0 {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-s.options.Clock.After(wait):
+		}
+	}
+	now := s.options.Clock.Now()
+	var received []*azservicebus.ReceivedMessage
+	for s.next < len(s.messages) && len(received) < maxMessages && !s.dueAt(s.next).After(now) {
+		received = append(received, s.messages[s.next].ReceivedMessage())
+		s.next++
+	}
+	if s.next >= len(s.messages) {
+		close(s.done)
+	}
+	return received, nil
+}
+
+// dueAt returns the time at which the i-th message is replayed.
+func (s *ReplaySource) dueAt(i int) time.Time {
+	if s.options.Speed <= 0 {
+		return s.start
+	}
+	offset := s.messages[i].CapturedAt.Sub(s.messages[0].CapturedAt)
+	return s.start.Add(time.Duration(float64(offset) / s.options.Speed))
+}
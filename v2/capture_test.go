@@ -0,0 +1,136 @@
+package shuttle_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	. "github.com/onsi/gomega"
+
+	"github.com/Azure/go-shuttle/v2"
+	"github.com/Azure/go-shuttle/v2/shuttletest"
+)
+
+func TestCaptureHandler_WritesReadableCapture(t *testing.T) {
+	g := NewWithT(t)
+	buf := &bytes.Buffer{}
+	var handled int
+	h := shuttle.NewCaptureHandler(shuttle.NewJSONCaptureSink(buf),
+		shuttle.HandlerFunc(func(ctx context.Context, settler shuttle.MessageSettler, message *azservicebus.ReceivedMessage) {
+			handled++
+		}))
+	h.Handle(context.Background(), &fakeSettler{}, &azservicebus.ReceivedMessage{
+		MessageID:             "id-1",
+		CorrelationID:         to.Ptr("correlation"),
+		Body:                  []byte(`{"a":1}`),
+		ApplicationProperties: map[string]any{"type": "OrderCreated"},
+		SequenceNumber:        to.Ptr(int64(42)),
+	})
+	h.Handle(context.Background(), &fakeSettler{}, &azservicebus.ReceivedMessage{MessageID: "id-2"})
+	g.Expect(handled).To(Equal(2))
+
+	captured, err := shuttle.ReadCapturedMessages(buf)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(captured).To(HaveLen(2))
+	replayed := captured[0].ReceivedMessage()
+	g.Expect(replayed.MessageID).To(Equal("id-1"))
+	g.Expect(*replayed.CorrelationID).To(Equal("correlation"))
+	g.Expect(replayed.Body).To(Equal([]byte(`{"a":1}`)))
+	g.Expect(replayed.ApplicationProperties).To(HaveKeyWithValue("type", "OrderCreated"))
+	g.Expect(*replayed.SequenceNumber).To(Equal(int64(42)))
+}
+
+func TestCaptureHandler_RedactsCapture(t *testing.T) {
+	g := NewWithT(t)
+	var captured []shuttle.CapturedMessage
+	sink := shuttle.CaptureSinkFunc(func(ctx context.Context, message shuttle.CapturedMessage) error {
+		captured = append(captured, message)
+		return nil
+	})
+	var handled *azservicebus.ReceivedMessage
+	next := shuttle.HandlerFunc(func(ctx context.Context, settler shuttle.MessageSettler, message *azservicebus.ReceivedMessage) {
+		handled = message
+	})
+	message := &azservicebus.ReceivedMessage{
+		MessageID:             "id",
+		Body:                  []byte(`{"customer":{"email":"jane@contoso.com"}}`),
+		ApplicationProperties: map[string]any{"authorization": "Bearer abc"},
+	}
+	shuttle.NewCaptureHandler(sink, next).Handle(context.Background(), &fakeSettler{}, message)
+	g.Expect(string(captured[0].Body)).To(Equal(`{"customer":{"email":"[REDACTED]"}}`))
+	g.Expect(captured[0].ApplicationProperties).To(HaveKeyWithValue("authorization", shuttle.RedactedValue))
+	g.Expect(handled.Body).To(Equal(message.Body), "the handled message is not redacted")
+	g.Expect(handled.ApplicationProperties).To(HaveKeyWithValue("authorization", "Bearer abc"))
+
+	shuttle.NewCaptureHandler(sink, next, shuttle.WithRedactor(shuttle.NoRedaction)).Handle(context.Background(), &fakeSettler{}, message)
+	g.Expect(captured[1].Body).To(Equal(message.Body))
+}
+
+func TestCaptureHandler_HandlesMessageWhenCaptureFails(t *testing.T) {
+	g := NewWithT(t)
+	handled := false
+	h := shuttle.NewCaptureHandler(shuttle.CaptureSinkFunc(func(ctx context.Context, message shuttle.CapturedMessage) error {
+		return errors.New("storage unavailable")
+	}), shuttle.HandlerFunc(func(ctx context.Context, settler shuttle.MessageSettler, message *azservicebus.ReceivedMessage) {
+		handled = true
+	}))
+	h.Handle(context.Background(), &fakeSettler{}, &azservicebus.ReceivedMessage{})
+	g.Expect(handled).To(BeTrue())
+}
+
+func TestReplaySource_DrivesProcessor(t *testing.T) {
+	g := NewWithT(t)
+	start := time.Now()
+	captured := []shuttle.CapturedMessage{
+		{CapturedAt: start, MessageID: "1"},
+		{CapturedAt: start.Add(time.Millisecond), MessageID: "2"},
+		{CapturedAt: start.Add(2 * time.Millisecond), MessageID: "3"},
+	}
+	source := shuttle.NewReplaySource(captured, nil)
+	settler := shuttletest.NewRecordingSettler()
+	processor := shuttle.NewProcessorWithOptions(shuttle.NewSourceReceiver(source, settler),
+		MyHandler(0), shuttle.WithMaxConcurrency(2), shuttle.WithReceiveInterval(time.Millisecond))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = processor.Start(ctx) }()
+
+	g.Eventually(source.Done()).Should(BeClosed())
+	g.Eventually(func() int { return len(settler.Completed()) }).Should(Equal(3))
+}
+
+func TestReplaySource_ReplaysAtCapturedPace(t *testing.T) {
+	g := NewWithT(t)
+	start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := shuttletest.NewFakeClock(start)
+	captured := []shuttle.CapturedMessage{
+		{CapturedAt: start, MessageID: "1"},
+		{CapturedAt: start, MessageID: "2"},
+		{CapturedAt: start.Add(10 * time.Second), MessageID: "3"},
+	}
+	source := shuttle.NewReplaySource(captured, &shuttle.ReplayOptions{Speed: 2, Clock: clock})
+
+	messages, err := source.ReceiveMessages(context.Background(), 10, nil)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(messages).To(HaveLen(2))
+
+	received := make(chan []*azservicebus.ReceivedMessage)
+	go func() {
+		messages, _ := source.ReceiveMessages(context.Background(), 10, nil)
+		received <- messages
+	}()
+	g.Eventually(clock.PendingTimers).Should(Equal(1))
+	clock.Advance(4 * time.Second)
+	g.Consistently(received).ShouldNot(Receive())
+	clock.Advance(time.Second)
+	g.Eventually(received).Should(Receive(HaveLen(1)))
+	g.Expect(source.Done()).To(BeClosed())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = source.ReceiveMessages(ctx, 10, nil)
+	g.Expect(err).To(MatchError(context.DeadlineExceeded))
+}
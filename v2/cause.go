@@ -0,0 +1,46 @@
+package shuttle
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+)
+
+// Causes of the cancellation of the message context, returned by context.Cause.
+// They allow the handlers and middlewares to react differently, for example abandoning the message quickly
+// on shutdown, but dead-lettering it after repeated timeouts.
+var (
+	// ErrProcessorShuttingDown is the cause when the context passed to Processor.Start is done.
+	ErrProcessorShuttingDown = errors.New("processor shutting down")
+	// ErrHandleTimeout is the cause when the timeout of NewHandleTimeoutHandler elapsed.
+	ErrHandleTimeout = errors.New("message handle timeout")
+	// ErrLockLost is the cause when the lock renewal handler fails to renew the lock because it was lost.
+	ErrLockLost = errors.New("message lock lost")
+)
+
+// IsShuttingDown returns true when the message context was canceled because the processor is shutting down.
+func IsShuttingDown(ctx context.Context) bool {
+	return errors.Is(context.Cause(ctx), ErrProcessorShuttingDown)
+}
+
+// IsHandleTimeout returns true when the message context was canceled because the handle timeout elapsed.
+func IsHandleTimeout(ctx context.Context) bool {
+	return errors.Is(context.Cause(ctx), ErrHandleTimeout)
+}
+
+// IsLockLost returns true when the message context was canceled because the message lock was lost.
+func IsLockLost(ctx context.Context) bool {
+	return errors.Is(context.Cause(ctx), ErrLockLost)
+}
+
+// NewHandleTimeoutHandler is a middleware that bounds the time spent handling a message.
+// The message context is canceled with the ErrHandleTimeout cause once the timeout elapses.
+func NewHandleTimeoutHandler(timeout time.Duration, next Handler) HandlerFunc {
+	return func(ctx context.Context, settler MessageSettler, message *azservicebus.ReceivedMessage) {
+		ctx, cancel := context.WithTimeoutCause(ctx, timeout, ErrHandleTimeout)
+		defer cancel()
+		next.Handle(ctx, settler, message)
+	}
+}
@@ -0,0 +1,79 @@
+package shuttle_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	. "github.com/onsi/gomega"
+
+	"github.com/Azure/go-shuttle/v2"
+)
+
+func TestProcessor_ShutdownCause(t *testing.T) {
+	g := NewWithT(t)
+	rcv := &fakeReceiver{
+		fakeSettler:           &fakeSettler{},
+		SetupReceivedMessages: messagesChannel(1),
+		SetupMaxReceiveCalls:  2,
+	}
+	causes := make(chan error, 1)
+	started := make(chan struct{})
+	handler := shuttle.HandlerFunc(func(ctx context.Context, settler shuttle.MessageSettler, message *azservicebus.ReceivedMessage) {
+		close(started)
+		<-ctx.Done()
+		g.Expect(shuttle.IsShuttingDown(ctx)).To(BeTrue())
+		g.Expect(shuttle.IsHandleTimeout(ctx)).To(BeFalse())
+		causes <- context.Cause(ctx)
+	})
+	processor := shuttle.NewProcessor(rcv, handler, &shuttle.ProcessorOptions{
+		MaxConcurrency:  1,
+		ReceiveInterval: to.Ptr(time.Hour),
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-started
+		cancel()
+	}()
+	g.Expect(processor.Start(ctx)).To(MatchError(context.Canceled))
+	g.Eventually(causes).Should(Receive(MatchError(shuttle.ErrProcessorShuttingDown)))
+}
+
+func TestProcessor_MessageContextKeepsDeadline(t *testing.T) {
+	g := NewWithT(t)
+	rcv := &fakeReceiver{
+		fakeSettler:           &fakeSettler{},
+		SetupReceivedMessages: messagesChannel(1),
+		SetupMaxReceiveCalls:  2,
+	}
+	deadlines := make(chan time.Time, 1)
+	handler := shuttle.HandlerFunc(func(ctx context.Context, settler shuttle.MessageSettler, message *azservicebus.ReceivedMessage) {
+		deadline, ok := ctx.Deadline()
+		g.Expect(ok).To(BeTrue())
+		deadlines <- deadline
+	})
+	processor := shuttle.NewProcessor(rcv, handler, &shuttle.ProcessorOptions{
+		MaxConcurrency:  1,
+		ReceiveInterval: to.Ptr(time.Hour),
+	})
+	deadline := time.Now().Add(time.Second)
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+	go func() { _ = processor.Start(ctx) }()
+	g.Eventually(deadlines).Should(Receive(Equal(deadline)))
+}
+
+func TestNewHandleTimeoutHandler(t *testing.T) {
+	g := NewWithT(t)
+	handler := shuttle.NewHandleTimeoutHandler(time.Millisecond, shuttle.HandlerFunc(
+		func(ctx context.Context, settler shuttle.MessageSettler, message *azservicebus.ReceivedMessage) {
+			<-ctx.Done()
+			g.Expect(ctx.Err()).To(Equal(context.DeadlineExceeded))
+			g.Expect(shuttle.IsHandleTimeout(ctx)).To(BeTrue())
+			g.Expect(shuttle.IsShuttingDown(ctx)).To(BeFalse())
+			g.Expect(shuttle.IsLockLost(ctx)).To(BeFalse())
+		}))
+	handler(context.Background(), &fakeSettler{}, &azservicebus.ReceivedMessage{})
+}
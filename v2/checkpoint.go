@@ -0,0 +1,166 @@
+package shuttle
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+)
+
+// ErrCheckpointNotFound is returned by a CheckpointStore when no checkpoint is saved for a MessageID.
+var ErrCheckpointNotFound = errors.New("checkpoint not found")
+
+// ErrCheckpointingDisabled is returned by Checkpoint when the handler is not wrapped by NewCheckpointHandler.
+var ErrCheckpointingDisabled = errors.New("checkpointing is not enabled, use NewCheckpointHandler")
+
+// CheckpointStore persists the checkpoints of the messages by MessageID.
+// Use a durable implementation (sql, redis, table storage...) to resume on another replica after a redelivery.
+type CheckpointStore interface {
+	Save(ctx context.Context, messageID string, state []byte) error
+	// Load returns ErrCheckpointNotFound when no checkpoint is saved for the MessageID.
+	Load(ctx context.Context, messageID string) ([]byte, error)
+	Delete(ctx context.Context, messageID string) error
+}
+
+var _ CheckpointStore = &InMemoryCheckpointStore{}
+
+// InMemoryCheckpointStore is a CheckpointStore that keeps the checkpoints in memory.
+// The checkpoints are lost when the process restarts.
+type InMemoryCheckpointStore struct {
+	mu          sync.Mutex
+	checkpoints map[string][]byte
+}
+
+// NewInMemoryCheckpointStore creates an empty InMemoryCheckpointStore.
+func NewInMemoryCheckpointStore() *InMemoryCheckpointStore {
+	return &InMemoryCheckpointStore{checkpoints: map[string][]byte{}}
+}
+
+func (s *InMemoryCheckpointStore) Save(_ context.Context, messageID string, state []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.checkpoints[messageID] = append([]byte(nil), state...)
+	return nil
+}
+
+func (s *InMemoryCheckpointStore) Load(_ context.Context, messageID string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state, ok := s.checkpoints[messageID]
+	if !ok {
+		return nil, ErrCheckpointNotFound
+	}
+	return state, nil
+}
+
+func (s *InMemoryCheckpointStore) Delete(_ context.Context, messageID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.checkpoints, messageID)
+	return nil
+}
+
+type checkpointKey struct{}
+
+// checkpointer saves the checkpoints of the message being handled.
+type checkpointer struct {
+	store     CheckpointStore
+	messageID string
+
+	mu    sync.Mutex
+	state []byte
+}
+
+// Checkpoint saves the state of the handling of the message, marshalled to JSON, so that the handler can resume
+// from it with LastCheckpoint when the message is redelivered after an abandon, a lock loss or a crash.
+// It requires the NewCheckpointHandler middleware.
+func Checkpoint(ctx context.Context, state any) error {
+	c, ok := ctx.Value(checkpointKey{}).(*checkpointer)
+	if !ok {
+		return ErrCheckpointingDisabled
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("failed to marshal checkpoint: %w", err)
+	}
+	if err := c.store.Save(ctx, c.messageID, data); err != nil {
+		return fmt.Errorf("failed to save checkpoint of message %s: %w", c.messageID, err)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.state = data
+	return nil
+}
+
+// LastCheckpoint unmarshals the last checkpoint of the message into state.
+// It returns false when the message has no checkpoint, and the handling starts from scratch.
+func LastCheckpoint(ctx context.Context, state any) (bool, error) {
+	c, ok := ctx.Value(checkpointKey{}).(*checkpointer)
+	if !ok {
+		return false, nil
+	}
+	c.mu.Lock()
+	data := c.state
+	c.mu.Unlock()
+	if data == nil {
+		return false, nil
+	}
+	if err := json.Unmarshal(data, state); err != nil {
+		return false, fmt.Errorf("failed to unmarshal checkpoint: %w", err)
+	}
+	return true, nil
+}
+
+// NewCheckpointHandler is a middleware that enables Checkpoint and LastCheckpoint in the handler.
+// It loads the last checkpoint of the message before calling next, and deletes it once the message is completed
+// or dead-lettered. The checkpoint is kept when the message is abandoned or deferred, to resume from it.
+// The handling starts from scratch when the checkpoint cannot be loaded.
+func NewCheckpointHandler(store CheckpointStore, next Handler) HandlerFunc {
+	return func(ctx context.Context, settler MessageSettler, message *azservicebus.ReceivedMessage) {
+		c := &checkpointer{store: store, messageID: message.MessageID}
+		state, err := store.Load(ctx, message.MessageID)
+		switch {
+		case err == nil:
+			c.state = state
+		case !errors.Is(err, ErrCheckpointNotFound):
+			log(ctx, fmt.Sprintf("failed to load checkpoint of message %s, starting from scratch: %s", message.MessageID, err))
+		}
+		next.Handle(context.WithValue(ctx, checkpointKey{}, c), &checkpointSettler{MessageSettler: settler, checkpointer: c}, message)
+	}
+}
+
+// checkpointSettler deletes the checkpoint of the message once it is settled for good.
+type checkpointSettler struct {
+	MessageSettler
+	checkpointer *checkpointer
+}
+
+// Unwrap returns the wrapped MessageSettler, for UnwrapSettler.
+func (s *checkpointSettler) Unwrap() MessageSettler {
+	return s.MessageSettler
+}
+
+func (s *checkpointSettler) CompleteMessage(ctx context.Context, message *azservicebus.ReceivedMessage, options *azservicebus.CompleteMessageOptions) error {
+	if err := s.MessageSettler.CompleteMessage(ctx, message, options); err != nil {
+		return err
+	}
+	s.delete(ctx)
+	return nil
+}
+
+func (s *checkpointSettler) DeadLetterMessage(ctx context.Context, message *azservicebus.ReceivedMessage, options *azservicebus.DeadLetterOptions) error {
+	if err := s.MessageSettler.DeadLetterMessage(ctx, message, options); err != nil {
+		return err
+	}
+	s.delete(ctx)
+	return nil
+}
+
+func (s *checkpointSettler) delete(ctx context.Context) {
+	if err := s.checkpointer.store.Delete(ctx, s.checkpointer.messageID); err != nil {
+		log(ctx, fmt.Sprintf("failed to delete checkpoint of message %s: %s", s.checkpointer.messageID, err))
+	}
+}
@@ -0,0 +1,99 @@
+package shuttle
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	. "github.com/onsi/gomega"
+)
+
+type stages struct {
+	Done []string
+}
+
+func TestCheckpointHandler(t *testing.T) {
+	g := NewWithT(t)
+	store := NewInMemoryCheckpointStore()
+	var executed []string
+	failAt := "charge"
+	handler := NewCheckpointHandler(store, HandlerFunc(func(ctx context.Context, settler MessageSettler, message *azservicebus.ReceivedMessage) {
+		state := stages{}
+		_, err := LastCheckpoint(ctx, &state)
+		g.Expect(err).ToNot(HaveOccurred())
+		for _, stage := range []string{"reserve", "charge", "ship"} {
+			if contains(state.Done, stage) {
+				continue
+			}
+			if stage == failAt {
+				_ = settler.AbandonMessage(ctx, message, nil)
+				return
+			}
+			executed = append(executed, stage)
+			state.Done = append(state.Done, stage)
+			g.Expect(Checkpoint(ctx, state)).To(Succeed())
+		}
+		_ = settler.CompleteMessage(ctx, message, nil)
+	}))
+	message := &azservicebus.ReceivedMessage{MessageID: "m1"}
+
+	settler := &fakeSettler{}
+	handler(context.Background(), settler, message)
+	g.Expect(settler.abandoned).To(BeTrue())
+	g.Expect(executed).To(Equal([]string{"reserve"}))
+	_, err := store.Load(context.Background(), "m1")
+	g.Expect(err).ToNot(HaveOccurred())
+
+	// the redelivery resumes from the checkpoint
+	failAt = ""
+	settler = &fakeSettler{}
+	handler(context.Background(), settler, message)
+	g.Expect(settler.completed).To(BeTrue())
+	g.Expect(executed).To(Equal([]string{"reserve", "charge", "ship"}))
+	_, err = store.Load(context.Background(), "m1")
+	g.Expect(err).To(MatchError(ErrCheckpointNotFound))
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+type failingCheckpointStore struct {
+	*InMemoryCheckpointStore
+}
+
+func (s failingCheckpointStore) Load(_ context.Context, _ string) ([]byte, error) {
+	return nil, errors.New("connection refused")
+}
+
+func TestCheckpointHandler_LoadFailure(t *testing.T) {
+	g := NewWithT(t)
+	called := false
+	handler := NewCheckpointHandler(failingCheckpointStore{NewInMemoryCheckpointStore()}, HandlerFunc(
+		func(ctx context.Context, settler MessageSettler, message *azservicebus.ReceivedMessage) {
+			called = true
+			ok, err := LastCheckpoint(ctx, &stages{})
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(ok).To(BeFalse())
+			g.Expect(Checkpoint(ctx, stages{})).To(Succeed())
+			_ = settler.DeadLetterMessage(ctx, message, nil)
+		}))
+	settler := &fakeSettler{}
+	handler(context.Background(), settler, &azservicebus.ReceivedMessage{MessageID: "m1"})
+	g.Expect(called).To(BeTrue())
+	g.Expect(settler.deadlettered).To(BeTrue())
+}
+
+func TestCheckpoint_Disabled(t *testing.T) {
+	g := NewWithT(t)
+	g.Expect(Checkpoint(context.Background(), stages{})).To(MatchError(ErrCheckpointingDisabled))
+	ok, err := LastCheckpoint(context.Background(), &stages{})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(ok).To(BeFalse())
+}
@@ -0,0 +1,103 @@
+package shuttle
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Clock abstracts the passage of time for the sender timeouts, the lock renewal and the scheduling helpers.
+// It allows tests to control time deterministically instead of relying on real sleeps.
+// The default implementation uses the time package.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+	NewTimer(d time.Duration) Timer
+}
+
+// Timer abstracts time.Timer so that it can be provided by a Clock.
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+var _ Clock = systemClock{}
+
+// systemClock is the default Clock, backed by the time package.
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+func (systemClock) NewTimer(d time.Duration) Timer {
+	return &systemTimer{timer: time.NewTimer(d)}
+}
+
+type systemTimer struct {
+	timer *time.Timer
+}
+
+func (t *systemTimer) C() <-chan time.Time {
+	return t.timer.C
+}
+
+func (t *systemTimer) Stop() bool {
+	return t.timer.Stop()
+}
+
+func (t *systemTimer) Reset(d time.Duration) bool {
+	return t.timer.Reset(d)
+}
+
+func clockOrDefault(clock Clock) Clock {
+	if clock == nil {
+		return systemClock{}
+	}
+	return clock
+}
+
+// withClockTimeout returns a context that times out when the timeout elapses on the clock,
+// so that a fake clock triggers the timeout without waiting for the real time.
+// the returned func must be called to release the resources.
+func withClockTimeout(ctx context.Context, clock Clock, timeout time.Duration) (context.Context, context.CancelFunc) {
+	timeoutCtx, cancel := context.WithCancelCause(ctx)
+	timer := clock.NewTimer(timeout)
+	go func() {
+		select {
+		case <-timer.C():
+			cancel(context.DeadlineExceeded)
+		case <-timeoutCtx.Done():
+		}
+	}()
+	return &clockTimeoutContext{Context: timeoutCtx, deadline: clock.Now().Add(timeout)}, func() {
+		timer.Stop()
+		cancel(context.Canceled)
+	}
+}
+
+// clockTimeoutContext reports the deadline of the clock timeout, and DeadlineExceeded when it elapses.
+type clockTimeoutContext struct {
+	context.Context
+	deadline time.Time
+}
+
+func (c *clockTimeoutContext) Deadline() (time.Time, bool) {
+	if deadline, ok := c.Context.Deadline(); ok && deadline.Before(c.deadline) {
+		return deadline, true
+	}
+	return c.deadline, true
+}
+
+func (c *clockTimeoutContext) Err() error {
+	err := c.Context.Err()
+	if err != nil && errors.Is(context.Cause(c.Context), context.DeadlineExceeded) {
+		return context.DeadlineExceeded
+	}
+	return err
+}
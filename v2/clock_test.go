@@ -0,0 +1,91 @@
+package shuttle_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	. "github.com/onsi/gomega"
+
+	"github.com/Azure/go-shuttle/v2"
+	"github.com/Azure/go-shuttle/v2/shuttletest"
+)
+
+func TestSender_SendTimeoutWithFakeClock(t *testing.T) {
+	g := NewWithT(t)
+	clock := shuttletest.NewFakeClock(time.Now())
+	// the injected latency blocks the send until the context is canceled
+	azSender := shuttletest.NewFaultInjectingSender(shuttletest.NewInMemorySender(nil), &shuttletest.FaultInjectionOptions{
+		Latency:            time.Hour,
+		LatencyProbability: 1,
+	})
+	sender := shuttle.NewSender(azSender, &shuttle.SenderOptions{
+		Marshaller:  &shuttle.DefaultJSONMarshaller{},
+		SendTimeout: 10 * time.Second,
+		Clock:       clock,
+	})
+	errCh := make(chan error, 1)
+	go func() { errCh <- sender.SendMessage(context.Background(), "test") }()
+	g.Eventually(clock.PendingTimers).Should(Equal(1))
+	clock.Advance(9 * time.Second)
+	g.Consistently(errCh, 20*time.Millisecond).ShouldNot(Receive())
+	clock.Advance(1 * time.Second)
+	g.Eventually(errCh).Should(Receive(MatchError(context.DeadlineExceeded)))
+}
+
+// deadlineRecordingSender records the deadline of the context of the sends.
+type deadlineRecordingSender struct {
+	*shuttletest.InMemorySender
+	deadline time.Time
+}
+
+func (s *deadlineRecordingSender) SendMessage(ctx context.Context, message *azservicebus.Message, options *azservicebus.SendMessageOptions) error {
+	s.deadline, _ = ctx.Deadline()
+	return s.InMemorySender.SendMessage(ctx, message, options)
+}
+
+func TestSender_SendTimeoutDeadlineFromClock(t *testing.T) {
+	g := NewWithT(t)
+	start := time.Date(2023, 1, 1, 10, 0, 0, 0, time.UTC)
+	azSender := &deadlineRecordingSender{InMemorySender: shuttletest.NewInMemorySender(nil)}
+	sender := shuttle.NewSender(azSender, &shuttle.SenderOptions{
+		SendTimeout: 10 * time.Second,
+		Clock:       shuttletest.NewFakeClock(start),
+	})
+	g.Expect(sender.SendMessage(context.Background(), "test")).To(Succeed())
+	g.Expect(azSender.deadline).To(Equal(start.Add(10 * time.Second)))
+}
+
+func TestLockRenewalHandler_RenewsWithFakeClock(t *testing.T) {
+	g := NewWithT(t)
+	clock := shuttletest.NewFakeClock(time.Now())
+	renewer := &fakeSBLockRenewer{}
+	interval := 10 * time.Second
+	release := make(chan struct{})
+	lr := shuttle.NewLockRenewalHandler(renewer, &shuttle.LockRenewalOptions{Interval: &interval, Clock: clock},
+		shuttle.HandlerFunc(func(ctx context.Context, settler shuttle.MessageSettler, message *azservicebus.ReceivedMessage) {
+			<-release
+		}))
+	done := make(chan struct{})
+	go func() {
+		lr.Handle(context.Background(), &fakeSettler{}, &azservicebus.ReceivedMessage{})
+		close(done)
+	}()
+	for i := 1; i <= 3; i++ {
+		g.Eventually(clock.PendingTimers).Should(Equal(1))
+		clock.Advance(interval)
+		g.Eventually(renewer.RenewCount.Load).Should(Equal(int32(i)))
+	}
+	close(release)
+	g.Eventually(done).Should(BeClosed())
+}
+
+func TestSetMessageDelayWithClock(t *testing.T) {
+	g := NewWithT(t)
+	now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	msg := &azservicebus.Message{}
+	err := shuttle.SetMessageDelayWithClock(shuttletest.NewFakeClock(now), time.Minute)(msg)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(*msg.ScheduledEnqueueTime).To(Equal(now.Add(time.Minute)))
+}
@@ -0,0 +1,134 @@
+package shuttle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// ErrProcessorRunning is returned by Processor.Start when the processor is already running.
+// A processor receives from a single goroutine: Start can only be called again once the previous call returned.
+var ErrProcessorRunning = errors.New("processor is already running")
+
+// optionsGuard detects the changes made by the caller to the options struct passed to a constructor.
+// The constructors copy the options, so that these changes are ignored. The guard logs them once, like a go vet warning.
+// It is only enabled in the builds with the race detector, see raceEnabled: reading the caller's options is then
+// reported as a data race when the caller changes them concurrently, and the later sequential changes are logged.
+type optionsGuard[T any] struct {
+	caller   *T
+	snapshot T
+	once     sync.Once
+}
+
+// newOptionsGuard snapshots the options of the caller.
+// It returns nil when there are none, or when the race detector is disabled.
+func newOptionsGuard[T any](caller *T) *optionsGuard[T] {
+	if !raceEnabled || caller == nil {
+		return nil
+	}
+	return &optionsGuard[T]{caller: caller, snapshot: snapshotOptions(caller)}
+}
+
+// snapshotOptions copies the options struct, and the option values its fields point to, see isOptionValue.
+func snapshotOptions[T any](options *T) T {
+	snapshot := *options
+	v := reflect.ValueOf(&snapshot).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		if field := v.Field(i); field.CanSet() && isOptionValue(field) {
+			copied := reflect.New(field.Type().Elem())
+			copied.Elem().Set(field.Elem())
+			field.Set(copied)
+		}
+	}
+	return snapshot
+}
+
+// check logs the fields of the caller's options changed since the snapshot, the first time a change is detected.
+func (g *optionsGuard[T]) check(ctx context.Context, owner string) {
+	if g == nil {
+		return
+	}
+	changed := changedFields(reflect.ValueOf(g.snapshot), reflect.ValueOf(*g.caller))
+	if len(changed) == 0 {
+		return
+	}
+	g.once.Do(func() {
+		log(ctx, fmt.Sprintf("%T %s modified after creating the %s: the change is ignored, the options are copied at construction",
+			g.snapshot, strings.Join(changed, ", "), owner))
+	})
+}
+
+// isOptionValue is true for the pointers to the values copied by the constructors, like *Hooks and *time.Duration,
+// as opposed to the shared components holding an unexported state, like *ConcurrencyLimiter.
+func isOptionValue(v reflect.Value) bool {
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return false
+	}
+	t := v.Type().Elem()
+	if t.Kind() != reflect.Struct {
+		return true
+	}
+	for i := 0; i < t.NumField(); i++ {
+		if !t.Field(i).IsExported() {
+			return false
+		}
+	}
+	return true
+}
+
+// changedFields compares the exported fields of two options structs of the same type.
+// The option values pointed to are compared by value, the other pointers by identity.
+func changedFields(before, after reflect.Value) []string {
+	var changed []string
+	for i := 0; i < before.NumField(); i++ {
+		field := before.Type().Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		b, a := before.Field(i), after.Field(i)
+		same := sameValue(b, a)
+		if isOptionValue(b) && isOptionValue(a) {
+			same = sameValue(b.Elem(), a.Elem())
+		}
+		if !same {
+			changed = append(changed, field.Name)
+		}
+	}
+	return changed
+}
+
+// sameValue compares two values of the same type without calling reflect.DeepEqual,
+// which reports the non-nil funcs as different even when they are the same.
+// The funcs are compared by code pointer, the pointers, maps, slices and channels by identity.
+func sameValue(a, b reflect.Value) bool {
+	switch a.Kind() {
+	case reflect.Func, reflect.Ptr, reflect.Chan, reflect.UnsafePointer:
+		return a.Pointer() == b.Pointer()
+	case reflect.Map, reflect.Slice:
+		return a.Pointer() == b.Pointer() && a.Len() == b.Len()
+	case reflect.Interface:
+		if a.IsNil() || b.IsNil() {
+			return a.IsNil() == b.IsNil()
+		}
+		return a.Elem().Type() == b.Elem().Type() && sameValue(a.Elem(), b.Elem())
+	case reflect.Struct:
+		for i := 0; i < a.NumField(); i++ {
+			if !sameValue(a.Field(i), b.Field(i)) {
+				return false
+			}
+		}
+		return true
+	case reflect.Array:
+		for i := 0; i < a.Len(); i++ {
+			if !sameValue(a.Index(i), b.Index(i)) {
+				return false
+			}
+		}
+		return true
+	default:
+		return a.Equal(b)
+	}
+}
@@ -0,0 +1,33 @@
+//go:build race
+
+package shuttle
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestNewSender_LogsOptionsModifiedAfterCreation(t *testing.T) {
+	g := NewWithT(t)
+	t.Setenv("GOSHUTTLE_LOG", "ALL")
+	SetLoggerFunc(getTestLogger)
+	defer SetLoggerFunc(func(_ context.Context) Logger { return &printLogger{} })
+	options := &SenderOptions{SendTimeout: time.Second, Hooks: &Hooks{}}
+	sender := NewSender(&fakeAzSender{}, options)
+
+	logger := &testLogger{}
+	ctx := context.WithValue(context.Background(), testlogkey, logger)
+	g.Expect(sender.SendMessage(ctx, "hello")).To(Succeed())
+	g.Expect(logger.entries).ToNot(ContainElement(ContainSubstring("modified after creating")))
+
+	options.SendTimeout = time.Nanosecond
+	options.Hooks.OnSendAttempt = func(context.Context, SendAttemptEvent) {}
+	g.Expect(sender.SendMessage(ctx, "hello")).To(Succeed())
+	g.Expect(sender.SendMessage(ctx, "hello")).To(Succeed())
+	g.Expect(logger.entries).To(ContainElement(
+		"shuttle.SenderOptions SendTimeout, Hooks modified after creating the Sender: the change is ignored, the options are copied at construction"))
+	g.Expect(logger.entries).To(HaveLen(1), "the changes are logged once")
+}
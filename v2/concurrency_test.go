@@ -0,0 +1,133 @@
+package shuttle
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	. "github.com/onsi/gomega"
+)
+
+// countingAzSender is a fakeAzSender safe for concurrent use.
+type countingAzSender struct {
+	fakeAzSender
+	mu  sync.Mutex
+	ids map[string]bool
+}
+
+func (s *countingAzSender) SendMessage(_ context.Context, message *azservicebus.Message, _ *azservicebus.SendMessageOptions) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ids[*message.MessageID] = true
+	return nil
+}
+
+// TestSender_ConcurrentUse is meant to run with -race.
+func TestSender_ConcurrentUse(t *testing.T) {
+	g := NewWithT(t)
+	azSender := &countingAzSender{ids: map[string]bool{}}
+	var attempts atomic.Int32
+	sender := NewSenderWithOptions(azSender,
+		WithSenderTracePropagation(),
+		WithDeadlinePropagation(),
+		WithSenderHostInfo(HostInfo{Host: "node-1"}),
+		WithBackpressure(BackpressureOptions{}),
+		WithSenderHooks(&Hooks{OnSendAttempt: func(context.Context, SendAttemptEvent) { attempts.Add(1) }}))
+
+	shared := []func(msg *azservicebus.Message) error{SetMessageTTL(time.Minute)}
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				id := fmt.Sprintf("%d-%d", i, j)
+				g.Expect(sender.SendMessage(context.Background(), id, append(shared, SetMessageId(&id))...)).To(Succeed())
+				_ = sender.Backpressure()
+			}
+		}(i)
+	}
+	wg.Wait()
+	g.Expect(azSender.ids).To(HaveLen(400))
+	g.Expect(attempts.Load()).To(Equal(int32(400)))
+	g.Expect(shared).To(HaveLen(1))
+	g.Expect(sender.Close(context.Background())).To(Succeed())
+}
+
+func TestNewSender_CopiesOptions(t *testing.T) {
+	g := NewWithT(t)
+	var attempts atomic.Int32
+	options := &SenderOptions{
+		SendTimeout: time.Second,
+		Hooks:       &Hooks{OnSendAttempt: func(context.Context, SendAttemptEvent) { attempts.Add(1) }},
+	}
+	sender := NewSender(&fakeAzSender{}, options)
+	g.Expect(options.Marshaller).To(BeNil())
+
+	options.SendTimeout = time.Nanosecond
+	options.Hooks.OnSendAttempt = nil
+	g.Expect(sender.SendMessage(context.Background(), "hello")).To(Succeed())
+	g.Expect(attempts.Load()).To(Equal(int32(1)))
+}
+
+func TestProcessor_StartRunning(t *testing.T) {
+	g := NewWithT(t)
+	options := &ProcessorOptions{MaxConcurrency: 1, ReceiveInterval: to.Ptr(time.Second)}
+	p := NewProcessor(&peekingReceiver{}, noopHandler, options)
+	*options.ReceiveInterval = time.Minute
+	g.Expect(*p.Options().ReceiveInterval).To(Equal(time.Second))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- p.Start(ctx) }()
+	g.Eventually(p.Ready).Should(BeTrue())
+	g.Expect(p.Start(ctx)).To(MatchError(ErrProcessorRunning))
+	cancel()
+	g.Eventually(done).Should(Receive(MatchError(context.Canceled)))
+
+	// the processor can be started again once stopped
+	ctx, cancel = context.WithCancel(context.Background())
+	go func() { done <- p.Start(ctx) }()
+	g.Eventually(p.Ready).Should(BeTrue())
+	cancel()
+	g.Eventually(done).Should(Receive())
+}
+
+func TestChangedFields(t *testing.T) {
+	g := NewWithT(t)
+	onError := func(context.Context, error) {}
+	limiter := NewConcurrencyLimiter(2)
+	options := &ProcessorOptions{
+		ReceiveInterval:    to.Ptr(time.Second),
+		OnError:            onError,
+		Hooks:              &Hooks{},
+		ConcurrencyLimiter: limiter,
+		Clock:              systemClock{},
+	}
+	snapshot := snapshotOptions(options)
+	g.Expect(changedFields(reflect.ValueOf(snapshot), reflect.ValueOf(*options))).To(BeEmpty())
+
+	// the state of the shared components is not an option change
+	limiter.SetLimit(3)
+	g.Expect(changedFields(reflect.ValueOf(snapshot), reflect.ValueOf(*options))).To(BeEmpty())
+
+	*options.ReceiveInterval = time.Minute
+	options.Hooks.OnSettled = func(context.Context, SettledEvent) {}
+	options.OnError = func(context.Context, error) {}
+	options.EntityName = "orders"
+	g.Expect(changedFields(reflect.ValueOf(snapshot), reflect.ValueOf(*options))).To(
+		ConsistOf("ReceiveInterval", "Hooks", "OnError", "EntityName"))
+
+	// the funcs held by interfaces are compared like the func fields
+	senderOptions := &SenderOptions{MaxMessageSize: MaxMessageSizeFunc(func(context.Context) (int, error) { return 1024, nil })}
+	senderSnapshot := snapshotOptions(senderOptions)
+	g.Expect(changedFields(reflect.ValueOf(senderSnapshot), reflect.ValueOf(*senderOptions))).To(BeEmpty())
+	senderOptions.MaxMessageSize = MaxMessageSizeFunc(func(context.Context) (int, error) { return 2048, nil })
+	g.Expect(changedFields(reflect.ValueOf(senderSnapshot), reflect.ValueOf(*senderOptions))).To(ConsistOf("MaxMessageSize"))
+}
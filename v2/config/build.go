@@ -0,0 +1,142 @@
+package config
+
+import (
+	"fmt"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+
+	"github.com/Azure/go-shuttle/v2"
+)
+
+// NewClient creates the azservicebus.Client described by the configuration.
+func (c *Config) NewClient() (*azservicebus.Client, error) {
+	var options []shuttle.ClientOption
+	switch {
+	case c.ConnectionString != "":
+		options = append(options, shuttle.WithConnectionString(c.ConnectionString))
+	case c.ManagedIdentityClientID != "":
+		options = append(options, shuttle.WithManagedIdentity(c.ManagedIdentityClientID))
+	}
+	switch c.Cloud {
+	case "", "public":
+	case "china":
+		options = append(options, shuttle.WithCloud(shuttle.AzureChina))
+	case "government":
+		options = append(options, shuttle.WithCloud(shuttle.AzureGovernment))
+	default:
+		return nil, fmt.Errorf("unsupported cloud %q", c.Cloud)
+	}
+	if c.CustomEndpoint != "" {
+		options = append(options, shuttle.WithCustomEndpoint(c.CustomEndpoint))
+	}
+	switch {
+	case c.Proxy != "":
+		options = append(options, shuttle.WithProxy(c.Proxy))
+	case c.WebSockets:
+		options = append(options, shuttle.WithWebSockets())
+	}
+	if c.ApplicationID != "" {
+		options = append(options, shuttle.WithApplicationID(c.ApplicationID))
+	}
+	return shuttle.NewClient(c.Namespace, options...)
+}
+
+// NewSender creates the shuttle.Sender described by the configuration, using the client.
+func (c *Config) NewSender(client *azservicebus.Client) (*shuttle.Sender, error) {
+	if c.Sender == nil || c.Sender.Entity == "" {
+		return nil, fmt.Errorf("sender entity is not configured")
+	}
+	options := &shuttle.SenderOptions{EnableTracingPropagation: c.Sender.EnableTracingPropagation}
+	switch c.Sender.Marshaller {
+	case "", "json":
+		options.Marshaller = &shuttle.DefaultJSONMarshaller{}
+	case "protobuf":
+		options.Marshaller = &shuttle.DefaultProtoMarshaller{}
+	default:
+		return nil, fmt.Errorf("unsupported marshaller %q", c.Sender.Marshaller)
+	}
+	timeout, err := parseDuration("sendTimeout", c.Sender.SendTimeout)
+	if err != nil {
+		return nil, err
+	}
+	if timeout != nil {
+		options.SendTimeout = *timeout
+	}
+	azSender, err := client.NewSender(c.Sender.Entity, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create sender for %s: %w", c.Sender.Entity, err)
+	}
+	return shuttle.NewSender(azSender, options), nil
+}
+
+// NewProcessor creates the shuttle.Processor described by the configuration, using the client.
+// The handler is wrapped in the panic, lock renewal (when configured) and managed settling middlewares.
+func (c *Config) NewProcessor(client *azservicebus.Client, handler shuttle.ManagedSettlingHandler) (*shuttle.Processor, error) {
+	p := c.Processor
+	if p == nil {
+		return nil, fmt.Errorf("processor is not configured")
+	}
+	receiver, err := c.newReceiver(client)
+	if err != nil {
+		return nil, err
+	}
+	settlingOptions := &shuttle.ManagedSettlingOptions{}
+	if p.MaxAttempts > 0 {
+		settlingOptions.RetryDecision = &shuttle.MaxAttemptsRetryDecision{MaxAttempts: p.MaxAttempts}
+	}
+	retryDelay, err := parseDuration("retryDelay", p.RetryDelay)
+	if err != nil {
+		return nil, err
+	}
+	if retryDelay != nil {
+		settlingOptions.RetryDelayStrategy = &shuttle.ConstantDelayStrategy{Delay: *retryDelay}
+	}
+	var next shuttle.Handler = shuttle.NewManagedSettlingHandler(settlingOptions, handler)
+
+	lockRenewalInterval, err := parseDuration("lockRenewalInterval", p.LockRenewalInterval)
+	if err != nil {
+		return nil, err
+	}
+	if lockRenewalInterval != nil {
+		next = shuttle.NewLockRenewalHandler(receiver, &shuttle.LockRenewalOptions{Interval: lockRenewalInterval}, next)
+	}
+	receiveInterval, err := parseDuration("receiveInterval", p.ReceiveInterval)
+	if err != nil {
+		return nil, err
+	}
+	maxConcurrency := p.MaxConcurrency
+	if maxConcurrency <= 0 {
+		maxConcurrency = 1
+	}
+	return shuttle.NewProcessor(receiver, shuttle.NewPanicHandler(nil, next), &shuttle.ProcessorOptions{
+		MaxConcurrency:  maxConcurrency,
+		ReceiveInterval: receiveInterval,
+		EntityName:      c.entityName(),
+		Namespace:       c.Namespace,
+	}), nil
+}
+
+func (c *Config) newReceiver(client *azservicebus.Client) (*azservicebus.Receiver, error) {
+	p := c.Processor
+	var receiver *azservicebus.Receiver
+	var err error
+	switch {
+	case p.Queue != "" && p.Topic == "" && p.Subscription == "":
+		receiver, err = client.NewReceiverForQueue(p.Queue, nil)
+	case p.Queue == "" && p.Topic != "" && p.Subscription != "":
+		receiver, err = client.NewReceiverForSubscription(p.Topic, p.Subscription, nil)
+	default:
+		return nil, fmt.Errorf("processor must be configured with either a queue, or a topic and a subscription")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create receiver for %s: %w", c.entityName(), err)
+	}
+	return receiver, nil
+}
+
+func (c *Config) entityName() string {
+	if c.Processor.Queue != "" {
+		return c.Processor.Queue
+	}
+	return c.Processor.Topic + "/" + c.Processor.Subscription
+}
@@ -0,0 +1,69 @@
+package config
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	. "github.com/onsi/gomega"
+
+	"github.com/Azure/go-shuttle/v2"
+)
+
+const testConnectionString = "Endpoint=sb://myns.servicebus.windows.net/;SharedAccessKeyName=key;SharedAccessKey=secret"
+
+func noopHandler(_ context.Context, _ *azservicebus.ReceivedMessage) error {
+	return nil
+}
+
+func TestConfig_Build(t *testing.T) {
+	g := NewWithT(t)
+	cfg := *expectedConfig
+	cfg.ConnectionString = testConnectionString
+	client, err := cfg.NewClient()
+	g.Expect(err).ToNot(HaveOccurred())
+	sender, err := cfg.NewSender(client)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(sender).ToNot(BeNil())
+	processor, err := cfg.NewProcessor(client, shuttle.ManagedSettlingFunc(noopHandler))
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(processor).ToNot(BeNil())
+	g.Expect(processor.Options().Namespace).To(Equal(expectedConfig.Namespace))
+}
+
+func TestConfig_BuildErrors(t *testing.T) {
+	g := NewWithT(t)
+	cfg := &Config{ConnectionString: testConnectionString}
+	client, err := cfg.NewClient()
+	g.Expect(err).ToNot(HaveOccurred())
+
+	_, err = cfg.NewSender(client)
+	g.Expect(err).To(MatchError(ContainSubstring("sender entity")))
+	cfg.Sender = &SenderConfig{Entity: "q", Marshaller: "xml"}
+	_, err = cfg.NewSender(client)
+	g.Expect(err).To(MatchError(ContainSubstring("unsupported marshaller")))
+	cfg.Sender = &SenderConfig{Entity: "q", SendTimeout: "ten seconds"}
+	_, err = cfg.NewSender(client)
+	g.Expect(err).To(MatchError(ContainSubstring("invalid sendTimeout")))
+
+	_, err = cfg.NewProcessor(client, shuttle.ManagedSettlingFunc(noopHandler))
+	g.Expect(err).To(MatchError(ContainSubstring("processor is not configured")))
+	cfg.Processor = &ProcessorConfig{Queue: "q", Topic: "t"}
+	_, err = cfg.NewProcessor(client, shuttle.ManagedSettlingFunc(noopHandler))
+	g.Expect(err).To(MatchError(ContainSubstring("either a queue")))
+	cfg.Processor = &ProcessorConfig{Queue: "q", RetryDelay: "soon"}
+	_, err = cfg.NewProcessor(client, shuttle.ManagedSettlingFunc(noopHandler))
+	g.Expect(err).To(MatchError(ContainSubstring("invalid retryDelay")))
+
+	cfg.Proxy = "proxy:8080"
+	_, err = cfg.NewClient()
+	g.Expect(err).To(MatchError(ContainSubstring("invalid proxy url")))
+	cfg.Proxy = ""
+	cfg.Cloud = "germany"
+	_, err = cfg.NewClient()
+	g.Expect(err).To(MatchError(ContainSubstring("unsupported cloud")))
+	cfg.Cloud = ""
+	cfg.CustomEndpoint = "sb-gateway"
+	_, err = cfg.NewClient()
+	g.Expect(err).To(MatchError(ContainSubstring("invalid custom endpoint")))
+}
@@ -0,0 +1,179 @@
+// Package config builds go-shuttle senders and processors from a declarative configuration,
+// loaded from a YAML or JSON file, or from environment variables.
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultEnvPrefix is the prefix of the environment variables read by FromEnv.
+const DefaultEnvPrefix = "GOSHUTTLE_"
+
+// Config describes the connection to a service bus namespace, and the sender and processor to build.
+// Durations are expressed as strings parsed by time.ParseDuration (e.g. "30s").
+type Config struct {
+	// Namespace is the service bus namespace name or fully qualified name.
+	Namespace string `json:"namespace" yaml:"namespace"`
+	// ConnectionString authenticates with a connection string instead of a token credential.
+	ConnectionString string `json:"connectionString" yaml:"connectionString"`
+	// ManagedIdentityClientID authenticates with the user-assigned managed identity.
+	// When neither ConnectionString nor ManagedIdentityClientID are set, the default azure credential is used.
+	ManagedIdentityClientID string `json:"managedIdentityClientId" yaml:"managedIdentityClientId"`
+	// WebSockets connects with AMQP over WebSockets on port 443, for the networks blocking port 5671.
+	WebSockets bool `json:"webSockets" yaml:"webSockets"`
+	// Proxy is the url of the HTTP proxy to connect through. It implies WebSockets.
+	Proxy string `json:"proxy" yaml:"proxy"`
+	// Cloud is either "public" (default), "china" or "government".
+	Cloud string `json:"cloud" yaml:"cloud"`
+	// CustomEndpoint is the address of an application gateway or private endpoint to connect through. It implies WebSockets.
+	CustomEndpoint string `json:"customEndpoint" yaml:"customEndpoint"`
+	// ApplicationID identifies the application in the user agent of the client. See shuttle.WithApplicationID.
+	ApplicationID string `json:"applicationId" yaml:"applicationId"`
+
+	Sender    *SenderConfig    `json:"sender" yaml:"sender"`
+	Processor *ProcessorConfig `json:"processor" yaml:"processor"`
+}
+
+// SenderConfig describes a shuttle.Sender.
+type SenderConfig struct {
+	// Entity is the queue or topic to send to.
+	Entity string `json:"entity" yaml:"entity"`
+	// SendTimeout maps to shuttle.SenderOptions.SendTimeout.
+	SendTimeout string `json:"sendTimeout" yaml:"sendTimeout"`
+	// Marshaller is either "json" (default) or "protobuf".
+	Marshaller string `json:"marshaller" yaml:"marshaller"`
+	// EnableTracingPropagation maps to shuttle.SenderOptions.EnableTracingPropagation.
+	EnableTracingPropagation bool `json:"enableTracingPropagation" yaml:"enableTracingPropagation"`
+}
+
+// ProcessorConfig describes a shuttle.Processor and its middlewares.
+type ProcessorConfig struct {
+	// Queue to receive from. Mutually exclusive with Topic and Subscription.
+	Queue string `json:"queue" yaml:"queue"`
+	// Topic and Subscription to receive from.
+	Topic        string `json:"topic" yaml:"topic"`
+	Subscription string `json:"subscription" yaml:"subscription"`
+	// MaxConcurrency maps to shuttle.ProcessorOptions.MaxConcurrency.
+	MaxConcurrency int `json:"maxConcurrency" yaml:"maxConcurrency"`
+	// ReceiveInterval maps to shuttle.ProcessorOptions.ReceiveInterval.
+	ReceiveInterval string `json:"receiveInterval" yaml:"receiveInterval"`
+	// LockRenewalInterval enables the lock renewal middleware when set.
+	LockRenewalInterval string `json:"lockRenewalInterval" yaml:"lockRenewalInterval"`
+	// MaxAttempts maps to the shuttle.MaxAttemptsRetryDecision of the managed settling middleware.
+	MaxAttempts uint32 `json:"maxAttempts" yaml:"maxAttempts"`
+	// RetryDelay maps to the shuttle.ConstantDelayStrategy of the managed settling middleware.
+	RetryDelay string `json:"retryDelay" yaml:"retryDelay"`
+}
+
+// LoadFile loads the configuration from a YAML (.yaml, .yml) or JSON (.json) file.
+func LoadFile(path string) (*Config, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+	cfg := &Config{}
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(content, cfg)
+	case ".json":
+		err = json.Unmarshal(content, cfg)
+	default:
+		return nil, fmt.Errorf("unsupported config file extension %q", ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// FromEnv loads the configuration from environment variables with the given prefix (DefaultEnvPrefix if empty).
+// The sender is configured when <prefix>SENDER_ENTITY is set,
+// and the processor when <prefix>PROCESSOR_QUEUE or <prefix>PROCESSOR_TOPIC is set.
+//
+//	<prefix>NAMESPACE, <prefix>CONNECTION_STRING, <prefix>MANAGED_IDENTITY_CLIENT_ID, <prefix>WEBSOCKETS, <prefix>PROXY,
+//	<prefix>CLOUD, <prefix>CUSTOM_ENDPOINT, <prefix>APPLICATION_ID
+//	<prefix>SENDER_ENTITY, <prefix>SENDER_TIMEOUT, <prefix>SENDER_MARSHALLER, <prefix>SENDER_TRACING_PROPAGATION
+//	<prefix>PROCESSOR_QUEUE, <prefix>PROCESSOR_TOPIC, <prefix>PROCESSOR_SUBSCRIPTION, <prefix>PROCESSOR_MAX_CONCURRENCY,
+//	<prefix>PROCESSOR_RECEIVE_INTERVAL, <prefix>PROCESSOR_LOCK_RENEWAL_INTERVAL,
+//	<prefix>PROCESSOR_MAX_ATTEMPTS, <prefix>PROCESSOR_RETRY_DELAY
+func FromEnv(prefix string) (*Config, error) {
+	if prefix == "" {
+		prefix = DefaultEnvPrefix
+	}
+	env := func(name string) string { return os.Getenv(prefix + name) }
+	cfg := &Config{
+		Namespace:               env("NAMESPACE"),
+		ConnectionString:        env("CONNECTION_STRING"),
+		ManagedIdentityClientID: env("MANAGED_IDENTITY_CLIENT_ID"),
+		Proxy:                   env("PROXY"),
+		Cloud:                   env("CLOUD"),
+		CustomEndpoint:          env("CUSTOM_ENDPOINT"),
+		ApplicationID:           env("APPLICATION_ID"),
+	}
+	if v := env("WEBSOCKETS"); v != "" {
+		enabled, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %sWEBSOCKETS: %w", prefix, err)
+		}
+		cfg.WebSockets = enabled
+	}
+	if entity := env("SENDER_ENTITY"); entity != "" {
+		cfg.Sender = &SenderConfig{
+			Entity:      entity,
+			SendTimeout: env("SENDER_TIMEOUT"),
+			Marshaller:  env("SENDER_MARSHALLER"),
+		}
+		if v := env("SENDER_TRACING_PROPAGATION"); v != "" {
+			enabled, err := strconv.ParseBool(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %sSENDER_TRACING_PROPAGATION: %w", prefix, err)
+			}
+			cfg.Sender.EnableTracingPropagation = enabled
+		}
+	}
+	if env("PROCESSOR_QUEUE") != "" || env("PROCESSOR_TOPIC") != "" {
+		cfg.Processor = &ProcessorConfig{
+			Queue:               env("PROCESSOR_QUEUE"),
+			Topic:               env("PROCESSOR_TOPIC"),
+			Subscription:        env("PROCESSOR_SUBSCRIPTION"),
+			ReceiveInterval:     env("PROCESSOR_RECEIVE_INTERVAL"),
+			LockRenewalInterval: env("PROCESSOR_LOCK_RENEWAL_INTERVAL"),
+			RetryDelay:          env("PROCESSOR_RETRY_DELAY"),
+		}
+		if v := env("PROCESSOR_MAX_CONCURRENCY"); v != "" {
+			concurrency, err := strconv.Atoi(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %sPROCESSOR_MAX_CONCURRENCY: %w", prefix, err)
+			}
+			cfg.Processor.MaxConcurrency = concurrency
+		}
+		if v := env("PROCESSOR_MAX_ATTEMPTS"); v != "" {
+			attempts, err := strconv.ParseUint(v, 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %sPROCESSOR_MAX_ATTEMPTS: %w", prefix, err)
+			}
+			cfg.Processor.MaxAttempts = uint32(attempts)
+		}
+	}
+	return cfg, nil
+}
+
+// parseDuration parses an optional duration. it returns nil when the value is empty.
+func parseDuration(name, value string) (*time.Duration, error) {
+	if value == "" {
+		return nil, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", name, err)
+	}
+	return &d, nil
+}
@@ -0,0 +1,132 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+const yamlConfig = `
+namespace: myns
+webSockets: true
+proxy: http://proxy:8080
+cloud: china
+customEndpoint: https://sb-gateway.contoso.com
+applicationId: orders-api
+sender:
+  entity: topic-a
+  sendTimeout: 10s
+  marshaller: protobuf
+  enableTracingPropagation: true
+processor:
+  topic: topic-a
+  subscription: sub-a
+  maxConcurrency: 10
+  receiveInterval: 1s
+  lockRenewalInterval: 30s
+  maxAttempts: 3
+  retryDelay: 5s
+`
+
+const jsonConfig = `{
+  "namespace": "myns",
+  "webSockets": true,
+  "proxy": "http://proxy:8080",
+  "cloud": "china",
+  "customEndpoint": "https://sb-gateway.contoso.com",
+  "applicationId": "orders-api",
+  "sender": {"entity": "topic-a", "sendTimeout": "10s", "marshaller": "protobuf", "enableTracingPropagation": true},
+  "processor": {"topic": "topic-a", "subscription": "sub-a", "maxConcurrency": 10, "receiveInterval": "1s",
+    "lockRenewalInterval": "30s", "maxAttempts": 3, "retryDelay": "5s"}
+}`
+
+var expectedConfig = &Config{
+	Namespace:      "myns",
+	WebSockets:     true,
+	Proxy:          "http://proxy:8080",
+	Cloud:          "china",
+	CustomEndpoint: "https://sb-gateway.contoso.com",
+	ApplicationID:  "orders-api",
+	Sender: &SenderConfig{
+		Entity:                   "topic-a",
+		SendTimeout:              "10s",
+		Marshaller:               "protobuf",
+		EnableTracingPropagation: true,
+	},
+	Processor: &ProcessorConfig{
+		Topic:               "topic-a",
+		Subscription:        "sub-a",
+		MaxConcurrency:      10,
+		ReceiveInterval:     "1s",
+		LockRenewalInterval: "30s",
+		MaxAttempts:         3,
+		RetryDelay:          "5s",
+	},
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	NewWithT(t).Expect(os.WriteFile(path, []byte(content), 0o600)).To(Succeed())
+	return path
+}
+
+func TestLoadFile(t *testing.T) {
+	g := NewWithT(t)
+	for name, content := range map[string]string{"config.yaml": yamlConfig, "config.yml": yamlConfig, "config.json": jsonConfig} {
+		cfg, err := LoadFile(writeFile(t, name, content))
+		g.Expect(err).ToNot(HaveOccurred(), name)
+		g.Expect(cfg).To(Equal(expectedConfig), name)
+	}
+}
+
+func TestLoadFile_Errors(t *testing.T) {
+	g := NewWithT(t)
+	_, err := LoadFile(writeFile(t, "config.toml", ""))
+	g.Expect(err).To(MatchError(ContainSubstring("unsupported")))
+	_, err = LoadFile(writeFile(t, "config.json", "{"))
+	g.Expect(err).To(HaveOccurred())
+	_, err = LoadFile(filepath.Join(t.TempDir(), "missing.json"))
+	g.Expect(err).To(HaveOccurred())
+}
+
+func TestFromEnv(t *testing.T) {
+	g := NewWithT(t)
+	for k, v := range map[string]string{
+		"TEST_NAMESPACE":                       "myns",
+		"TEST_WEBSOCKETS":                      "true",
+		"TEST_PROXY":                           "http://proxy:8080",
+		"TEST_CLOUD":                           "china",
+		"TEST_CUSTOM_ENDPOINT":                 "https://sb-gateway.contoso.com",
+		"TEST_APPLICATION_ID":                  "orders-api",
+		"TEST_SENDER_ENTITY":                   "topic-a",
+		"TEST_SENDER_TIMEOUT":                  "10s",
+		"TEST_SENDER_MARSHALLER":               "protobuf",
+		"TEST_SENDER_TRACING_PROPAGATION":      "true",
+		"TEST_PROCESSOR_TOPIC":                 "topic-a",
+		"TEST_PROCESSOR_SUBSCRIPTION":          "sub-a",
+		"TEST_PROCESSOR_MAX_CONCURRENCY":       "10",
+		"TEST_PROCESSOR_RECEIVE_INTERVAL":      "1s",
+		"TEST_PROCESSOR_LOCK_RENEWAL_INTERVAL": "30s",
+		"TEST_PROCESSOR_MAX_ATTEMPTS":          "3",
+		"TEST_PROCESSOR_RETRY_DELAY":           "5s",
+	} {
+		t.Setenv(k, v)
+	}
+	cfg, err := FromEnv("TEST_")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(cfg).To(Equal(expectedConfig))
+
+	t.Setenv("TEST_PROCESSOR_MAX_CONCURRENCY", "ten")
+	_, err = FromEnv("TEST_")
+	g.Expect(err).To(MatchError(ContainSubstring("TEST_PROCESSOR_MAX_CONCURRENCY")))
+}
+
+func TestFromEnv_Empty(t *testing.T) {
+	g := NewWithT(t)
+	cfg, err := FromEnv("")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(cfg.Sender).To(BeNil())
+	g.Expect(cfg.Processor).To(BeNil())
+}
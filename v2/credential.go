@@ -0,0 +1,104 @@
+package shuttle
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+)
+
+// CredentialProvider creates the credential authenticating to a namespace.
+// It is called again when the credential must be replaced, for example after the federated credential
+// of the pod was rotated, so that the senders and processors keep their client.
+type CredentialProvider interface {
+	Credential(ctx context.Context) (azcore.TokenCredential, error)
+}
+
+// CredentialProviderFunc allows to use a func as a CredentialProvider.
+type CredentialProviderFunc func(ctx context.Context) (azcore.TokenCredential, error)
+
+func (f CredentialProviderFunc) Credential(ctx context.Context) (azcore.TokenCredential, error) {
+	return f(ctx)
+}
+
+// RotatingCredential is an azcore.TokenCredential delegating to the credential of its CredentialProvider.
+// The azservicebus client requests a new token from it before the previous one expires, on the same connection.
+// The credential is re-created by the provider after Rotate, or when it fails to get a token.
+//
+// The SAS tokens of a connection string cannot be rotated this way: the azservicebus client keeps them
+// for its lifetime, so a client created with WithConnectionString must be re-created.
+type RotatingCredential struct {
+	provider CredentialProvider
+
+	mu      sync.Mutex
+	current azcore.TokenCredential
+	// generation identifies the current credential, to discard a failed one only once.
+	generation int
+}
+
+var _ azcore.TokenCredential = &RotatingCredential{}
+
+// NewRotatingCredential creates a RotatingCredential. The provider is called on the first token request.
+func NewRotatingCredential(provider CredentialProvider) *RotatingCredential {
+	return &RotatingCredential{provider: provider}
+}
+
+// GetToken gets a token from the current credential. When it fails, the credential is re-created by the provider
+// and the token requested once more.
+func (c *RotatingCredential) GetToken(ctx context.Context, options policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	cred, generation, err := c.credential(ctx)
+	if err != nil {
+		return azcore.AccessToken{}, err
+	}
+	token, err := cred.GetToken(ctx, options)
+	if err == nil {
+		return token, nil
+	}
+	log(ctx, fmt.Sprintf("failed to get token, re-creating the credential: %s", err))
+	c.discard(generation)
+	if cred, _, err = c.credential(ctx); err != nil {
+		return azcore.AccessToken{}, err
+	}
+	return cred.GetToken(ctx, options)
+}
+
+// Rotate discards the current credential, so that the next token is requested from a credential
+// re-created by the provider. The tokens already issued stay valid until they expire.
+func (c *RotatingCredential) Rotate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.current = nil
+}
+
+// credential returns the current credential and its generation, creating it with the provider when there is none.
+func (c *RotatingCredential) credential(ctx context.Context) (azcore.TokenCredential, int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.current != nil {
+		return c.current, c.generation, nil
+	}
+	cred, err := c.provider.Credential(ctx)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to create credential: %w", err)
+	}
+	c.current = cred
+	c.generation++
+	return cred, c.generation, nil
+}
+
+// discard discards the credential of the generation, unless it was already replaced by a concurrent request.
+func (c *RotatingCredential) discard(generation int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.generation == generation {
+		c.current = nil
+	}
+}
+
+// WithCredentialProvider authenticates with the credentials of the provider, wrapped in a RotatingCredential.
+// Use WithTokenCredential with a RotatingCredential to call Rotate.
+func WithCredentialProvider(provider CredentialProvider) ClientOption {
+	return WithTokenCredential(NewRotatingCredential(provider))
+}
@@ -0,0 +1,72 @@
+package shuttle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	. "github.com/onsi/gomega"
+)
+
+type fakeTokenCredential struct {
+	token string
+	err   error
+}
+
+func (f *fakeTokenCredential) GetToken(_ context.Context, _ policy.TokenRequestOptions) (azcore.AccessToken, error) {
+	if f.err != nil {
+		return azcore.AccessToken{}, f.err
+	}
+	return azcore.AccessToken{Token: f.token}, nil
+}
+
+func TestRotatingCredential(t *testing.T) {
+	g := NewWithT(t)
+	var created []*fakeTokenCredential
+	cred := NewRotatingCredential(CredentialProviderFunc(func(ctx context.Context) (azcore.TokenCredential, error) {
+		c := &fakeTokenCredential{token: fmt.Sprintf("token-%d", len(created))}
+		created = append(created, c)
+		return c, nil
+	}))
+
+	token, err := cred.GetToken(context.Background(), policy.TokenRequestOptions{})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(token.Token).To(Equal("token-0"))
+	token, err = cred.GetToken(context.Background(), policy.TokenRequestOptions{})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(token.Token).To(Equal("token-0"))
+	g.Expect(created).To(HaveLen(1))
+
+	cred.Rotate()
+	token, err = cred.GetToken(context.Background(), policy.TokenRequestOptions{})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(token.Token).To(Equal("token-1"))
+
+	// a failing credential is re-created
+	created[1].err = errors.New("federated token expired")
+	token, err = cred.GetToken(context.Background(), policy.TokenRequestOptions{})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(token.Token).To(Equal("token-2"))
+	g.Expect(created).To(HaveLen(3))
+}
+
+func TestRotatingCredential_ProviderError(t *testing.T) {
+	g := NewWithT(t)
+	cred := NewRotatingCredential(CredentialProviderFunc(func(ctx context.Context) (azcore.TokenCredential, error) {
+		return nil, errors.New("no identity")
+	}))
+	_, err := cred.GetToken(context.Background(), policy.TokenRequestOptions{})
+	g.Expect(err).To(MatchError(ContainSubstring("no identity")))
+}
+
+func TestNewClient_CredentialProvider(t *testing.T) {
+	g := NewWithT(t)
+	client, err := NewClient("myns", WithCredentialProvider(CredentialProviderFunc(func(ctx context.Context) (azcore.TokenCredential, error) {
+		return &fakeTokenCredential{token: "token"}, nil
+	})))
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(client).ToNot(BeNil())
+}
@@ -0,0 +1,148 @@
+package shuttle
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+)
+
+// DeadlineProperty is the application property holding the absolute processing deadline of a message,
+// as a RFC 3339 UTC timestamp.
+const DeadlineProperty = "x-deadline"
+
+// DeadlineExpiredAction is the settlement of the messages received after their deadline by NewDeadlineHandler.
+type DeadlineExpiredAction int
+
+const (
+	// DeadlineExpiredDeadLetter dead-letters the expired messages with the DeadlineExceeded reason.
+	DeadlineExpiredDeadLetter DeadlineExpiredAction = iota
+	// DeadlineExpiredDrop completes the expired messages without handling them.
+	DeadlineExpiredDrop
+)
+
+// SetDeadline sets the DeadlineProperty of the message, for the receivers to skip it once the deadline has passed.
+func SetDeadline(deadline time.Time) func(msg *azservicebus.Message) error {
+	return func(msg *azservicebus.Message) error {
+		if msg.ApplicationProperties == nil {
+			msg.ApplicationProperties = map[string]any{}
+		}
+		msg.ApplicationProperties[DeadlineProperty] = deadline.UTC().Format(time.RFC3339Nano)
+		return nil
+	}
+}
+
+// SetDeadlineFromContext sets the DeadlineProperty of the message to the deadline of the context, if it has one.
+func SetDeadlineFromContext(ctx context.Context) func(msg *azservicebus.Message) error {
+	return func(msg *azservicebus.Message) error {
+		deadline, ok := ctx.Deadline()
+		if !ok {
+			return nil
+		}
+		return SetDeadline(deadline)(msg)
+	}
+}
+
+// WithDeadlinePropagation applies SetDeadlineFromContext on all messages sent through the sender,
+// so that the deadline of the producer's context is enforced by the receivers using NewDeadlineHandler.
+func WithDeadlinePropagation() SenderOption {
+	return func(options *SenderOptions) {
+		options.EnableDeadlinePropagation = true
+	}
+}
+
+// MessageDeadline returns the deadline set on the message by SetDeadline.
+// It returns false when the message has no deadline, or when the deadline cannot be parsed.
+func MessageDeadline(message *azservicebus.ReceivedMessage) (time.Time, bool) {
+	deadline, err := messageDeadline(message.ApplicationProperties)
+	if err != nil || deadline.IsZero() {
+		return time.Time{}, false
+	}
+	return deadline, true
+}
+
+func messageDeadline(properties map[string]any) (time.Time, error) {
+	value, ok := properties[DeadlineProperty]
+	if !ok {
+		return time.Time{}, nil
+	}
+	switch v := value.(type) {
+	case time.Time:
+		return v, nil
+	case string:
+		deadline, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid %s %q: %w", DeadlineProperty, v, err)
+		}
+		return deadline, nil
+	default:
+		return time.Time{}, fmt.Errorf("invalid %s of type %T", DeadlineProperty, value)
+	}
+}
+
+// DeadlineHandlerOptions configures NewDeadlineHandler.
+type DeadlineHandlerOptions struct {
+	// Action is the settlement of the expired messages. Defaults to DeadlineExpiredDeadLetter.
+	Action DeadlineExpiredAction
+	// Clock is used to compare the deadlines to the current time. Defaults to the system clock.
+	Clock Clock
+	// OnExpired is called with the expired messages before they are settled. Optional.
+	OnExpired func(ctx context.Context, message *azservicebus.ReceivedMessage, deadline time.Time)
+}
+
+// NewDeadlineHandler is a middleware that refuses the messages received after their deadline,
+// to avoid wasting work on stale commands, for example after an outage.
+// The messages that are not expired are handled with the deadline applied to the context.
+// The messages without deadline, or with an invalid one, are passed to next unchanged.
+func NewDeadlineHandler(options *DeadlineHandlerOptions, next Handler) HandlerFunc {
+	opts := DeadlineHandlerOptions{}
+	if options != nil {
+		opts = *options
+	}
+	clock := clockOrDefault(opts.Clock)
+	return func(ctx context.Context, settler MessageSettler, message *azservicebus.ReceivedMessage) {
+		deadline, err := messageDeadline(message.ApplicationProperties)
+		if err != nil {
+			log(ctx, fmt.Sprintf("ignoring deadline of message %s: %s", message.MessageID, err))
+		}
+		if deadline.IsZero() {
+			next.Handle(ctx, settler, message)
+			return
+		}
+		if !clock.Now().Before(deadline) {
+			refuseExpired(ctx, opts, settler, message, deadline)
+			return
+		}
+		ctx, cancel := context.WithDeadline(ctx, deadline)
+		defer cancel()
+		next.Handle(ctx, settler, message)
+	}
+}
+
+func refuseExpired(
+	ctx context.Context,
+	opts DeadlineHandlerOptions,
+	settler MessageSettler,
+	message *azservicebus.ReceivedMessage,
+	deadline time.Time) {
+	if opts.OnExpired != nil {
+		opts.OnExpired(ctx, message, deadline)
+	}
+	if opts.Action == DeadlineExpiredDrop {
+		log(ctx, fmt.Sprintf("dropping message %s: deadline %s exceeded", message.MessageID, deadline))
+		if err := settler.CompleteMessage(ctx, message, nil); err != nil {
+			log(ctx, fmt.Sprintf("failed to complete message %s: %s", message.MessageID, err))
+		}
+		return
+	}
+	log(ctx, fmt.Sprintf("dead-lettering message %s: deadline %s exceeded", message.MessageID, deadline))
+	reason := "DeadlineExceeded"
+	description := fmt.Sprintf("processing deadline %s exceeded", deadline.UTC().Format(time.RFC3339Nano))
+	if err := settler.DeadLetterMessage(ctx, message, &azservicebus.DeadLetterOptions{
+		Reason:           &reason,
+		ErrorDescription: &description,
+	}); err != nil {
+		log(ctx, fmt.Sprintf("failed to dead-letter message %s: %s", message.MessageID, err))
+	}
+}
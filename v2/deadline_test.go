@@ -0,0 +1,107 @@
+package shuttle_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	. "github.com/onsi/gomega"
+
+	"github.com/Azure/go-shuttle/v2"
+	"github.com/Azure/go-shuttle/v2/shuttletest"
+)
+
+func TestSetDeadlineFromContext(t *testing.T) {
+	g := NewWithT(t)
+	deadline := time.Date(2030, 1, 2, 3, 4, 5, 6, time.UTC)
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+
+	msg := &azservicebus.Message{}
+	g.Expect(shuttle.SetDeadlineFromContext(ctx)(msg)).To(Succeed())
+	got, ok := shuttle.MessageDeadline(&azservicebus.ReceivedMessage{ApplicationProperties: msg.ApplicationProperties})
+	g.Expect(ok).To(BeTrue())
+	g.Expect(got).To(BeTemporally("==", deadline))
+
+	msg = &azservicebus.Message{}
+	g.Expect(shuttle.SetDeadlineFromContext(context.Background())(msg)).To(Succeed())
+	g.Expect(msg.ApplicationProperties).ToNot(HaveKey(shuttle.DeadlineProperty))
+}
+
+func TestMessageDeadline_Invalid(t *testing.T) {
+	g := NewWithT(t)
+	_, ok := shuttle.MessageDeadline(&azservicebus.ReceivedMessage{})
+	g.Expect(ok).To(BeFalse())
+	_, ok = shuttle.MessageDeadline(&azservicebus.ReceivedMessage{
+		ApplicationProperties: map[string]any{shuttle.DeadlineProperty: "tomorrow"},
+	})
+	g.Expect(ok).To(BeFalse())
+}
+
+func TestNewDeadlineHandler(t *testing.T) {
+	now := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	testCases := []struct {
+		name             string
+		properties       map[string]any
+		action           shuttle.DeadlineExpiredAction
+		expectHandled    bool
+		expectDeadLetter int32
+		expectComplete   int32
+	}{
+		{name: "no deadline", expectHandled: true},
+		{name: "invalid deadline", properties: map[string]any{shuttle.DeadlineProperty: 42}, expectHandled: true},
+		{
+			name:          "deadline in the future",
+			properties:    map[string]any{shuttle.DeadlineProperty: now.Add(time.Minute).Format(time.RFC3339Nano)},
+			expectHandled: true,
+		},
+		{
+			name:             "expired deadline is dead-lettered",
+			properties:       map[string]any{shuttle.DeadlineProperty: now.Add(-time.Minute).Format(time.RFC3339Nano)},
+			expectDeadLetter: 1,
+		},
+		{
+			name:           "expired deadline is dropped",
+			properties:     map[string]any{shuttle.DeadlineProperty: now.Add(-time.Minute)},
+			action:         shuttle.DeadlineExpiredDrop,
+			expectComplete: 1,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+			settler := &fakeSettler{}
+			handled := false
+			expired := false
+			handler := shuttle.NewDeadlineHandler(&shuttle.DeadlineHandlerOptions{
+				Action: tc.action,
+				Clock:  shuttletest.NewFakeClock(now),
+				OnExpired: func(ctx context.Context, message *azservicebus.ReceivedMessage, deadline time.Time) {
+					expired = true
+				},
+			}, shuttle.HandlerFunc(func(ctx context.Context, settler shuttle.MessageSettler, message *azservicebus.ReceivedMessage) {
+				handled = true
+			}))
+			handler(context.Background(), settler, &azservicebus.ReceivedMessage{ApplicationProperties: tc.properties})
+			g.Expect(handled).To(Equal(tc.expectHandled))
+			g.Expect(expired).To(Equal(!tc.expectHandled))
+			g.Expect(settler.DeadLetterCalled.Load()).To(Equal(tc.expectDeadLetter))
+			g.Expect(settler.CompleteCalled.Load()).To(Equal(tc.expectComplete))
+		})
+	}
+}
+
+func TestNewDeadlineHandler_ContextDeadline(t *testing.T) {
+	g := NewWithT(t)
+	deadline := time.Now().Add(time.Hour)
+	handler := shuttle.NewDeadlineHandler(nil, shuttle.HandlerFunc(
+		func(ctx context.Context, settler shuttle.MessageSettler, message *azservicebus.ReceivedMessage) {
+			got, ok := ctx.Deadline()
+			g.Expect(ok).To(BeTrue())
+			g.Expect(got).To(BeTemporally("~", deadline, time.Millisecond))
+		}))
+	msg := &azservicebus.Message{}
+	g.Expect(shuttle.SetDeadline(deadline)(msg)).To(Succeed())
+	handler(context.Background(), &fakeSettler{}, &azservicebus.ReceivedMessage{ApplicationProperties: msg.ApplicationProperties})
+}
@@ -0,0 +1,86 @@
+package shuttle
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+)
+
+// DeferredMessageReceiver retrieves the deferred messages by their sequence number. It is satisfied by *azservicebus.Receiver.
+type DeferredMessageReceiver interface {
+	ReceiveDeferredMessages(ctx context.Context, sequenceNumbers []int64, options *azservicebus.ReceiveDeferredMessagesOptions) ([]*azservicebus.ReceivedMessage, error)
+}
+
+// DeferredMessageSource is a MessageSource returning the deferred messages of the sequence numbers added to it.
+// Combined with the receiver in NewSourceReceiver, it lets a Processor handle the deferred messages through
+// the same pipeline as the active ones, including the lock renewal of NewLockRenewalHandler:
+//
+//	source := shuttle.NewDeferredMessageSource(receiver)
+//	p := shuttle.NewProcessor(shuttle.NewSourceReceiver(source, receiver),
+//		shuttle.NewLockRenewalHandler(receiver, nil, handler), nil)
+//	source.Add(sequenceNumbers...)
+type DeferredMessageSource struct {
+	receiver DeferredMessageReceiver
+
+	mu      sync.Mutex
+	pending []int64
+}
+
+var _ MessageSource = &DeferredMessageSource{}
+
+// NewDeferredMessageSource creates a DeferredMessageSource retrieving the deferred messages from the receiver.
+func NewDeferredMessageSource(receiver DeferredMessageReceiver) *DeferredMessageSource {
+	return &DeferredMessageSource{receiver: receiver}
+}
+
+// Add queues the sequence numbers of deferred messages, to be returned by the next ReceiveMessages calls.
+func (s *DeferredMessageSource) Add(sequenceNumbers ...int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pending = append(s.pending, sequenceNumbers...)
+}
+
+// Pending returns the number of sequence numbers not received yet.
+func (s *DeferredMessageSource) Pending() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.pending)
+}
+
+// ReceiveMessages retrieves the deferred messages of up to maxMessages queued sequence numbers.
+// It returns no message when no sequence number is queued. The sequence numbers of the messages that no longer exist
+// are dropped, and the ones of a failed retrieval are queued again.
+func (s *DeferredMessageSource) ReceiveMessages(ctx context.Context, maxMessages int, _ *azservicebus.ReceiveMessagesOptions) ([]*azservicebus.ReceivedMessage, error) {
+	sequenceNumbers := s.take(maxMessages)
+	if len(sequenceNumbers) == 0 {
+		return nil, nil
+	}
+	messages, err := s.receiver.ReceiveDeferredMessages(ctx, sequenceNumbers, nil)
+	if err != nil {
+		s.requeue(sequenceNumbers)
+		return nil, fmt.Errorf("failed to receive deferred messages: %w", err)
+	}
+	if len(messages) < len(sequenceNumbers) {
+		log(ctx, fmt.Sprintf("%d deferred messages not found", len(sequenceNumbers)-len(messages)))
+	}
+	return messages, nil
+}
+
+func (s *DeferredMessageSource) take(maxMessages int) []int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if maxMessages > len(s.pending) {
+		maxMessages = len(s.pending)
+	}
+	taken := append([]int64(nil), s.pending[:maxMessages]...)
+	s.pending = s.pending[maxMessages:]
+	return taken
+}
+
+func (s *DeferredMessageSource) requeue(sequenceNumbers []int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pending = append(sequenceNumbers, s.pending...)
+}
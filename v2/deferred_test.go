@@ -0,0 +1,93 @@
+package shuttle
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	. "github.com/onsi/gomega"
+)
+
+// fakeDeferredReceiver returns the deferred messages of the known sequence numbers, and counts the lock renewals.
+type fakeDeferredReceiver struct {
+	fakeSettler
+	messages map[int64]*azservicebus.ReceivedMessage
+	err      error
+	renewed  atomic.Int32
+}
+
+func (f *fakeDeferredReceiver) ReceiveDeferredMessages(_ context.Context, sequenceNumbers []int64, _ *azservicebus.ReceiveDeferredMessagesOptions) ([]*azservicebus.ReceivedMessage, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	var messages []*azservicebus.ReceivedMessage
+	for _, sequenceNumber := range sequenceNumbers {
+		if message, ok := f.messages[sequenceNumber]; ok {
+			messages = append(messages, message)
+		}
+	}
+	return messages, nil
+}
+
+func (f *fakeDeferredReceiver) RenewMessageLock(_ context.Context, _ *azservicebus.ReceivedMessage, _ *azservicebus.RenewMessageLockOptions) error {
+	f.renewed.Add(1)
+	return nil
+}
+
+func TestDeferredMessageSource(t *testing.T) {
+	g := NewWithT(t)
+	receiver := &fakeDeferredReceiver{messages: map[int64]*azservicebus.ReceivedMessage{
+		1: {MessageID: "1", SequenceNumber: to.Ptr(int64(1))},
+		2: {MessageID: "2", SequenceNumber: to.Ptr(int64(2))},
+		3: {MessageID: "3", SequenceNumber: to.Ptr(int64(3))},
+	}}
+	source := NewDeferredMessageSource(receiver)
+
+	messages, err := source.ReceiveMessages(context.Background(), 10, nil)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(messages).To(BeEmpty())
+
+	source.Add(1, 2, 4, 3)
+	messages, err = source.ReceiveMessages(context.Background(), 3, nil)
+	g.Expect(err).ToNot(HaveOccurred())
+	// 4 no longer exists
+	g.Expect(messages).To(HaveLen(2))
+	g.Expect(source.Pending()).To(Equal(1))
+
+	receiver.err = errors.New("connection lost")
+	_, err = source.ReceiveMessages(context.Background(), 3, nil)
+	g.Expect(err).To(MatchError(ContainSubstring("connection lost")))
+	g.Expect(source.Pending()).To(Equal(1))
+
+	receiver.err = nil
+	messages, err = source.ReceiveMessages(context.Background(), 3, nil)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(messages[0].MessageID).To(Equal("3"))
+}
+
+func TestDeferredMessageSource_LockRenewal(t *testing.T) {
+	g := NewWithT(t)
+	receiver := &fakeDeferredReceiver{messages: map[int64]*azservicebus.ReceivedMessage{
+		1: {MessageID: "1", SequenceNumber: to.Ptr(int64(1))},
+	}}
+	source := NewDeferredMessageSource(receiver)
+	source.Add(1)
+
+	var handled atomic.Bool
+	interval := 10 * time.Millisecond
+	handler := NewLockRenewalHandler(receiver, &LockRenewalOptions{Interval: &interval},
+		HandlerFunc(func(ctx context.Context, settler MessageSettler, message *azservicebus.ReceivedMessage) {
+			time.Sleep(50 * time.Millisecond)
+			handled.Store(true)
+		}))
+	p := NewProcessor(NewSourceReceiver(source, receiver), handler, &ProcessorOptions{MaxConcurrency: 1, ReceiveInterval: to.Ptr(10 * time.Millisecond)})
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	g.Expect(p.Start(ctx)).To(MatchError(context.DeadlineExceeded))
+	g.Expect(handled.Load()).To(BeTrue())
+	g.Expect(receiver.renewed.Load()).To(BeNumerically(">", 0))
+}
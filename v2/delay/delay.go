@@ -0,0 +1,270 @@
+// Package delay delivers messages at a later time, with the native scheduled messages of Service Bus,
+// or with a store polled by the application where scheduling is not available:
+//
+//	provider, err := delay.NewProvider(ctx, adminClient, sender, delay.NewStoreProvider(delay.NewSQLStore(db, nil), sender, nil))
+//	err = provider.Deliver(ctx, msg, time.Now().Add(time.Hour))
+//
+// The native scheduling is used by default. The store is only used on the namespaces of the Basic tier,
+// where its StoreProvider must be running to send the due messages.
+package delay
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus/admin"
+	"github.com/google/uuid"
+
+	"github.com/Azure/go-shuttle/v2"
+)
+
+const (
+	defaultBatchSize    = 100
+	defaultPollInterval = time.Second
+	// basicSKU is the namespace tier on which the store is used.
+	basicSKU = "Basic"
+)
+
+// ErrFallbackRequired is returned by NewProvider when the namespace is on the Basic tier, which does not support
+// the scheduled messages, and no fallback is given.
+var ErrFallbackRequired = errors.New("the Basic tier does not support scheduled messages: a fallback StoreProvider is required")
+
+// Provider delivers a message to the entity of its sender at the given time.
+type Provider interface {
+	Deliver(ctx context.Context, message *azservicebus.Message, at time.Time) error
+}
+
+// NativeProvider delivers the messages with the scheduled messages of Service Bus.
+type NativeProvider struct {
+	sender *shuttle.Sender
+}
+
+// NewNativeProvider creates a NativeProvider scheduling the messages with the sender.
+func NewNativeProvider(sender *shuttle.Sender) *NativeProvider {
+	return &NativeProvider{sender: sender}
+}
+
+// Deliver schedules the message to be enqueued at the given time.
+func (p *NativeProvider) Deliver(ctx context.Context, message *azservicebus.Message, at time.Time) error {
+	if _, err := p.sender.ScheduleMessages(ctx, []*azservicebus.Message{message}, at); err != nil {
+		return fmt.Errorf("failed to schedule delayed message: %w", err)
+	}
+	return nil
+}
+
+// NewProvider returns the NativeProvider of the sender, unless the namespace is on the Basic tier,
+// in which case it returns the fallback. The tier is read from the namespace properties with the admin client.
+// The fallback can be nil when the namespace is known not to be on the Basic tier, ErrFallbackRequired is returned otherwise.
+func NewProvider(ctx context.Context, client *admin.Client, sender *shuttle.Sender, fallback *StoreProvider) (Provider, error) {
+	res, err := client.GetNamespaceProperties(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get namespace properties: %w", err)
+	}
+	return providerForNamespace(res.SKU, sender, fallback)
+}
+
+// providerForNamespace is NewProvider once the tier is known. A nil fallback is not passed to ProviderForSKU,
+// which would return it as a non-nil Provider.
+func providerForNamespace(sku string, sender *shuttle.Sender, fallback *StoreProvider) (Provider, error) {
+	if fallback != nil {
+		return ProviderForSKU(sku, NewNativeProvider(sender), fallback), nil
+	}
+	if strings.EqualFold(sku, basicSKU) {
+		return nil, ErrFallbackRequired
+	}
+	return NewNativeProvider(sender), nil
+}
+
+// ProviderForSKU returns the fallback on the Basic tier, and the native provider on the other tiers.
+func ProviderForSKU(sku string, native Provider, fallback Provider) Provider {
+	if strings.EqualFold(sku, basicSKU) {
+		return fallback
+	}
+	return native
+}
+
+// Record is a message waiting in the Store for its delivery time.
+type Record struct {
+	// ID identifies the record. The StoreProvider uses UUIDv7 ids, which sort in the order the records are saved.
+	ID string
+	// DeliverAt is the time at which the message is sent.
+	DeliverAt time.Time
+	// Message is the JSON encoded message.
+	Message []byte
+}
+
+// Store holds the delayed messages until they are due.
+type Store interface {
+	// Save stores the record.
+	Save(ctx context.Context, record Record) error
+	// Due returns up to limit records which DeliverAt is before now, ordered by DeliverAt then by ID,
+	// so that the records due at the same time are returned in the order they were saved.
+	Due(ctx context.Context, now time.Time, limit int) ([]Record, error)
+	// Delete deletes the records once sent.
+	Delete(ctx context.Context, ids ...string) error
+}
+
+// StoreProviderOptions configures the StoreProvider.
+type StoreProviderOptions struct {
+	// BatchSize is the maximum number of due records sent per poll. Defaults to 100.
+	BatchSize int
+	// PollInterval is the interval between two polls of the store. Defaults to 1 second,
+	// which is the precision of the delivery time.
+	PollInterval time.Duration
+	// OnError is called by Run when a poll or a send fails. The records are retried on the next poll.
+	OnError func(ctx context.Context, err error)
+}
+
+// StoreProvider delivers the messages by saving them to a Store, and sending them once due.
+// Run must be running for the messages to be sent. When it runs on several replicas, a message may be sent twice:
+// set its MessageID and enable the duplicate detection of the entity to drop the duplicates.
+type StoreProvider struct {
+	store   Store
+	sender  *shuttle.Sender
+	options StoreProviderOptions
+	now     func() time.Time
+}
+
+// NewStoreProvider creates a StoreProvider saving the messages to the store, and sending them with the sender.
+func NewStoreProvider(store Store, sender *shuttle.Sender, options *StoreProviderOptions) *StoreProvider {
+	opts := StoreProviderOptions{}
+	if options != nil {
+		opts = *options
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = defaultBatchSize
+	}
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = defaultPollInterval
+	}
+	return &StoreProvider{store: store, sender: sender, options: opts, now: time.Now}
+}
+
+// Deliver saves the message to the store. The application properties of the message are JSON encoded,
+// so the numbers are sent back as float64.
+func (p *StoreProvider) Deliver(ctx context.Context, message *azservicebus.Message, at time.Time) error {
+	encoded, err := json.Marshal(fromMessage(message))
+	if err != nil {
+		return fmt.Errorf("failed to encode delayed message: %w", err)
+	}
+	id, err := uuid.NewV7()
+	if err != nil {
+		return fmt.Errorf("failed to generate delayed message id: %w", err)
+	}
+	if err := p.store.Save(ctx, Record{ID: id.String(), DeliverAt: at, Message: encoded}); err != nil {
+		return fmt.Errorf("failed to save delayed message: %w", err)
+	}
+	return nil
+}
+
+// Run sends the due messages until ctx is done. It polls again immediately while the batches are full,
+// and waits for the PollInterval once the due messages are sent, or when a poll or a send fails.
+func (p *StoreProvider) Run(ctx context.Context) error {
+	for {
+		full, err := p.SendDue(ctx)
+		if err != nil && ctx.Err() == nil {
+			p.onError(ctx, err)
+		}
+		wait := p.options.PollInterval
+		if full && err == nil {
+			wait = 0
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// SendDue sends a batch of due messages one at a time, the earliest first. The messages due at the same time
+// keep the order they were delivered in by a StoreProvider, as their ids are ordered, but not across the replicas. It reports whether the batch was full, meaning that more messages are likely due.
+// It stops at the first failed send, so that the following messages are never sent before it, and returns the failure.
+// The messages that are not sent are retried on the next call.
+func (p *StoreProvider) SendDue(ctx context.Context) (bool, error) {
+	records, err := p.store.Due(ctx, p.now(), p.options.BatchSize)
+	if err != nil {
+		return false, fmt.Errorf("failed to read the due delayed messages: %w", err)
+	}
+	for _, record := range records {
+		if err := p.send(ctx, record); err != nil {
+			return false, err
+		}
+	}
+	return len(records) == p.options.BatchSize, nil
+}
+
+func (p *StoreProvider) send(ctx context.Context, record Record) error {
+	var stored storedMessage
+	if err := json.Unmarshal(record.Message, &stored); err != nil {
+		return fmt.Errorf("failed to decode delayed message %s: %w", record.ID, err)
+	}
+	if err := p.sender.SendAzMessage(ctx, stored.toMessage()); err != nil {
+		return fmt.Errorf("failed to send delayed message %s: %w", record.ID, err)
+	}
+	if err := p.store.Delete(ctx, record.ID); err != nil {
+		return fmt.Errorf("failed to delete delayed message %s: %w", record.ID, err)
+	}
+	return nil
+}
+
+func (p *StoreProvider) onError(ctx context.Context, err error) {
+	if p.options.OnError != nil {
+		p.options.OnError(ctx, err)
+	}
+}
+
+// storedMessage is the JSON encoding of the message in the Store.
+type storedMessage struct {
+	MessageID             *string        `json:"messageId,omitempty"`
+	Body                  []byte         `json:"body,omitempty"`
+	ContentType           *string        `json:"contentType,omitempty"`
+	CorrelationID         *string        `json:"correlationId,omitempty"`
+	Subject               *string        `json:"subject,omitempty"`
+	SessionID             *string        `json:"sessionId,omitempty"`
+	PartitionKey          *string        `json:"partitionKey,omitempty"`
+	To                    *string        `json:"to,omitempty"`
+	ReplyTo               *string        `json:"replyTo,omitempty"`
+	ReplyToSessionID      *string        `json:"replyToSessionId,omitempty"`
+	TimeToLive            *time.Duration `json:"timeToLive,omitempty"`
+	ApplicationProperties map[string]any `json:"applicationProperties,omitempty"`
+}
+
+func fromMessage(msg *azservicebus.Message) storedMessage {
+	return storedMessage{
+		MessageID:             msg.MessageID,
+		Body:                  msg.Body,
+		ContentType:           msg.ContentType,
+		CorrelationID:         msg.CorrelationID,
+		Subject:               msg.Subject,
+		SessionID:             msg.SessionID,
+		PartitionKey:          msg.PartitionKey,
+		To:                    msg.To,
+		ReplyTo:               msg.ReplyTo,
+		ReplyToSessionID:      msg.ReplyToSessionID,
+		TimeToLive:            msg.TimeToLive,
+		ApplicationProperties: msg.ApplicationProperties,
+	}
+}
+
+func (m storedMessage) toMessage() *azservicebus.Message {
+	return &azservicebus.Message{
+		MessageID:             m.MessageID,
+		Body:                  m.Body,
+		ContentType:           m.ContentType,
+		CorrelationID:         m.CorrelationID,
+		Subject:               m.Subject,
+		SessionID:             m.SessionID,
+		PartitionKey:          m.PartitionKey,
+		To:                    m.To,
+		ReplyTo:               m.ReplyTo,
+		ReplyToSessionID:      m.ReplyToSessionID,
+		TimeToLive:            m.TimeToLive,
+		ApplicationProperties: m.ApplicationProperties,
+	}
+}
@@ -0,0 +1,157 @@
+package delay_test
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	. "github.com/onsi/gomega"
+
+	"github.com/Azure/go-shuttle/v2"
+	"github.com/Azure/go-shuttle/v2/delay"
+	"github.com/Azure/go-shuttle/v2/shuttletest"
+)
+
+type memoryStore struct {
+	mu      sync.Mutex
+	records map[string]delay.Record
+}
+
+func (s *memoryStore) Save(_ context.Context, record delay.Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.records == nil {
+		s.records = map[string]delay.Record{}
+	}
+	s.records[record.ID] = record
+	return nil
+}
+
+func (s *memoryStore) Due(_ context.Context, now time.Time, limit int) ([]delay.Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var due []delay.Record
+	for _, record := range s.records {
+		if !record.DeliverAt.After(now) {
+			due = append(due, record)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		if !due[i].DeliverAt.Equal(due[j].DeliverAt) {
+			return due[i].DeliverAt.Before(due[j].DeliverAt)
+		}
+		return due[i].ID < due[j].ID
+	})
+	return due[:min(limit, len(due))], nil
+}
+
+func (s *memoryStore) Delete(_ context.Context, ids ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, id := range ids {
+		delete(s.records, id)
+	}
+	return nil
+}
+
+func (s *memoryStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.records)
+}
+
+func TestStoreProvider_SendsDueMessages(t *testing.T) {
+	g := NewWithT(t)
+	azSender := shuttletest.NewInMemorySender(nil)
+	store := &memoryStore{}
+	provider := delay.NewStoreProvider(store, shuttle.NewSender(azSender, nil), nil)
+	ctx := context.Background()
+
+	due := &azservicebus.Message{
+		MessageID:             to.Ptr("due"),
+		Body:                  []byte(`{"id":1}`),
+		ContentType:           to.Ptr("application/json"),
+		SessionID:             to.Ptr("session"),
+		ApplicationProperties: map[string]any{"type": "OrderPlaced"},
+	}
+	g.Expect(provider.Deliver(ctx, due, time.Now().Add(-time.Second))).To(Succeed())
+	g.Expect(provider.Deliver(ctx, &azservicebus.Message{MessageID: to.Ptr("later")}, time.Now().Add(time.Hour))).To(Succeed())
+	g.Expect(azSender.SentMessages()).To(BeEmpty())
+
+	full, err := provider.SendDue(ctx)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(full).To(BeFalse())
+	g.Expect(azSender.SentMessages()).To(HaveLen(1))
+	sent := azSender.SentMessages()[0]
+	g.Expect(*sent.MessageID).To(Equal("due"))
+	g.Expect(sent.Body).To(Equal(due.Body))
+	g.Expect(*sent.ContentType).To(Equal("application/json"))
+	g.Expect(*sent.SessionID).To(Equal("session"))
+	g.Expect(sent.ApplicationProperties).To(HaveKeyWithValue("type", "OrderPlaced"))
+	g.Expect(store.Len()).To(Equal(1), "the message not due yet stays in the store")
+}
+
+func TestStoreProvider_RetriesFailedSends(t *testing.T) {
+	g := NewWithT(t)
+	store := &memoryStore{}
+	failing := shuttle.NewSender(&rejectingSender{InMemorySender: shuttletest.NewInMemorySender(nil)}, nil)
+	provider := delay.NewStoreProvider(store, failing, &delay.StoreProviderOptions{BatchSize: 1})
+	g.Expect(provider.Deliver(context.Background(), &azservicebus.Message{}, time.Now())).To(Succeed())
+	full, err := provider.SendDue(context.Background())
+	g.Expect(err).To(MatchError(context.DeadlineExceeded))
+	g.Expect(full).To(BeFalse(), "Run waits before retrying the full batch")
+	g.Expect(store.Len()).To(Equal(1), "the message is kept to be retried")
+}
+
+func TestStoreProvider_SendsInOrder(t *testing.T) {
+	g := NewWithT(t)
+	azSender := shuttletest.NewInMemorySender(nil)
+	store := &memoryStore{}
+	provider := delay.NewStoreProvider(store, shuttle.NewSender(azSender, nil), nil)
+	at := time.Now().Add(-time.Minute)
+	for i, id := range []string{"1", "2", "3", "4", "5"} {
+		g.Expect(provider.Deliver(context.Background(), &azservicebus.Message{MessageID: to.Ptr(id), SessionID: to.Ptr("session")},
+			at.Add(time.Duration(i)*time.Millisecond))).To(Succeed())
+	}
+	// the messages due at the same time keep their order
+	for _, id := range []string{"6", "7", "8", "9", "10"} {
+		g.Expect(provider.Deliver(context.Background(), &azservicebus.Message{MessageID: to.Ptr(id), SessionID: to.Ptr("session")},
+			at.Add(time.Second))).To(Succeed())
+	}
+	_, err := provider.SendDue(context.Background())
+	g.Expect(err).ToNot(HaveOccurred())
+	var ids []string
+	for _, msg := range azSender.SentMessages() {
+		ids = append(ids, *msg.MessageID)
+	}
+	g.Expect(ids).To(Equal([]string{"1", "2", "3", "4", "5", "6", "7", "8", "9", "10"}))
+}
+
+type rejectingSender struct {
+	*shuttletest.InMemorySender
+}
+
+func (s *rejectingSender) SendMessage(context.Context, *azservicebus.Message, *azservicebus.SendMessageOptions) error {
+	return context.DeadlineExceeded
+}
+
+func TestNativeProvider_SchedulesMessages(t *testing.T) {
+	g := NewWithT(t)
+	azSender := shuttletest.NewInMemorySender(nil)
+	provider := delay.NewNativeProvider(shuttle.NewSender(azSender, nil))
+	g.Expect(provider.Deliver(context.Background(), &azservicebus.Message{MessageID: to.Ptr("id")}, time.Now().Add(time.Hour))).To(Succeed())
+	g.Expect(azSender.ScheduledMessages()).To(HaveLen(1))
+}
+
+func TestProviderForSKU(t *testing.T) {
+	g := NewWithT(t)
+	native := delay.NewNativeProvider(nil)
+	fallback := delay.NewStoreProvider(&memoryStore{}, nil, nil)
+	g.Expect(delay.ProviderForSKU("Basic", native, fallback)).To(BeIdenticalTo(fallback))
+	g.Expect(delay.ProviderForSKU("Standard", native, fallback)).To(BeIdenticalTo(native))
+	g.Expect(delay.ProviderForSKU("Premium", native, fallback)).To(BeIdenticalTo(native))
+}
@@ -0,0 +1,22 @@
+package delay
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestProviderForNamespace(t *testing.T) {
+	g := NewWithT(t)
+	fallback := NewStoreProvider(nil, nil, nil)
+	provider, err := providerForNamespace("Basic", nil, fallback)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(provider).To(BeIdenticalTo(fallback))
+
+	_, err = providerForNamespace("Basic", nil, nil)
+	g.Expect(err).To(MatchError(ErrFallbackRequired))
+
+	provider, err = providerForNamespace("Standard", nil, nil)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(provider).To(BeAssignableToTypeOf(&NativeProvider{}))
+}
@@ -0,0 +1,124 @@
+package delay
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultTable = "shuttle_delayed_messages"
+
+// SQLStoreOptions configures the SQLStore.
+type SQLStoreOptions struct {
+	// Table is the name of the table. Defaults to shuttle_delayed_messages. It is created with:
+	//
+	//	CREATE TABLE shuttle_delayed_messages (
+	//		id VARCHAR(36) PRIMARY KEY,
+	//		deliver_at TIMESTAMP NOT NULL,
+	//		message BLOB NOT NULL
+	//	);
+	//	CREATE INDEX shuttle_delayed_messages_deliver_at ON shuttle_delayed_messages (deliver_at, id);
+	//
+	// with the BYTEA type on PostgreSQL and VARBINARY(MAX) on SQL Server.
+	Table string
+	// Placeholder returns the placeholder of the nth parameter of a query, starting at 1.
+	// Defaults to "?", as used by MySQL and SQLite. Use DollarPlaceholder for PostgreSQL and AtPPlaceholder for SQL Server.
+	Placeholder func(n int) string
+	// LimitClause returns the clause limiting the number of rows of the query selecting the due records.
+	// Defaults to "LIMIT n". Use FetchFirstClause for SQL Server.
+	LimitClause func(limit int) string
+}
+
+// DollarPlaceholder returns the $n placeholders of PostgreSQL.
+func DollarPlaceholder(n int) string {
+	return "$" + strconv.Itoa(n)
+}
+
+// AtPPlaceholder returns the @pn placeholders of SQL Server.
+func AtPPlaceholder(n int) string {
+	return "@p" + strconv.Itoa(n)
+}
+
+// FetchFirstClause returns the OFFSET FETCH clause of SQL Server.
+func FetchFirstClause(limit int) string {
+	return fmt.Sprintf("OFFSET 0 ROWS FETCH NEXT %d ROWS ONLY", limit)
+}
+
+// SQLStore is a Store on top of a database/sql table, for the databases which driver is registered by the application.
+type SQLStore struct {
+	db      *sql.DB
+	options SQLStoreOptions
+}
+
+// NewSQLStore creates a SQLStore storing the records in the table of the database.
+func NewSQLStore(db *sql.DB, options *SQLStoreOptions) *SQLStore {
+	opts := SQLStoreOptions{}
+	if options != nil {
+		opts = *options
+	}
+	if opts.Table == "" {
+		opts.Table = defaultTable
+	}
+	if opts.Placeholder == nil {
+		opts.Placeholder = func(int) string { return "?" }
+	}
+	if opts.LimitClause == nil {
+		opts.LimitClause = func(limit int) string { return "LIMIT " + strconv.Itoa(limit) }
+	}
+	return &SQLStore{db: db, options: opts}
+}
+
+func (s *SQLStore) Save(ctx context.Context, record Record) error {
+	_, err := s.db.ExecContext(ctx, s.insertQuery(), record.ID, record.DeliverAt.UTC(), record.Message)
+	return err
+}
+
+func (s *SQLStore) Due(ctx context.Context, now time.Time, limit int) ([]Record, error) {
+	rows, err := s.db.QueryContext(ctx, s.dueQuery(limit), now.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var records []Record
+	for rows.Next() {
+		var record Record
+		if err := rows.Scan(&record.ID, &record.DeliverAt, &record.Message); err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, rows.Err()
+}
+
+func (s *SQLStore) Delete(ctx context.Context, ids ...string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	args := make([]any, 0, len(ids))
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	_, err := s.db.ExecContext(ctx, s.deleteQuery(len(ids)), args...)
+	return err
+}
+
+func (s *SQLStore) insertQuery() string {
+	return fmt.Sprintf("INSERT INTO %s (id, deliver_at, message) VALUES (%s, %s, %s)",
+		s.options.Table, s.options.Placeholder(1), s.options.Placeholder(2), s.options.Placeholder(3))
+}
+
+func (s *SQLStore) dueQuery(limit int) string {
+	return fmt.Sprintf("SELECT id, deliver_at, message FROM %s WHERE deliver_at <= %s ORDER BY deliver_at, id %s",
+		s.options.Table, s.options.Placeholder(1), s.options.LimitClause(limit))
+}
+
+func (s *SQLStore) deleteQuery(count int) string {
+	placeholders := make([]string, 0, count)
+	for i := 1; i <= count; i++ {
+		placeholders = append(placeholders, s.options.Placeholder(i))
+	}
+	return fmt.Sprintf("DELETE FROM %s WHERE id IN (%s)", s.options.Table, strings.Join(placeholders, ", "))
+}
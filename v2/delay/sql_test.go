@@ -0,0 +1,113 @@
+package delay
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+// fakeConn is a database/sql driver connection recording the statements, and returning rows to the queries.
+type fakeConn struct {
+	execs []fakeStatement
+	query fakeStatement
+	rows  [][]driver.Value
+}
+
+type fakeStatement struct {
+	query string
+	args  []any
+}
+
+func (c *fakeConn) Connect(context.Context) (driver.Conn, error) { return c, nil }
+func (c *fakeConn) Driver() driver.Driver                        { return nil }
+func (c *fakeConn) Prepare(string) (driver.Stmt, error)          { return nil, driver.ErrSkip }
+func (c *fakeConn) Close() error                                 { return nil }
+func (c *fakeConn) Begin() (driver.Tx, error)                    { return nil, driver.ErrSkip }
+
+func (c *fakeConn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	c.execs = append(c.execs, fakeStatement{query: query, args: values(args)})
+	return driver.RowsAffected(1), nil
+}
+
+func (c *fakeConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	c.query = fakeStatement{query: query, args: values(args)}
+	return &fakeRows{rows: c.rows}, nil
+}
+
+func values(args []driver.NamedValue) []any {
+	values := make([]any, 0, len(args))
+	for _, arg := range args {
+		values = append(values, arg.Value)
+	}
+	return values
+}
+
+type fakeRows struct {
+	rows [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return []string{"id", "deliver_at", "message"} }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+func TestSQLStore_Queries(t *testing.T) {
+	g := NewWithT(t)
+	store := NewSQLStore(nil, nil)
+	g.Expect(store.insertQuery()).To(Equal("INSERT INTO shuttle_delayed_messages (id, deliver_at, message) VALUES (?, ?, ?)"))
+	g.Expect(store.dueQuery(10)).To(Equal(
+		"SELECT id, deliver_at, message FROM shuttle_delayed_messages WHERE deliver_at <= ? ORDER BY deliver_at, id LIMIT 10"))
+	g.Expect(store.deleteQuery(2)).To(Equal("DELETE FROM shuttle_delayed_messages WHERE id IN (?, ?)"))
+
+	sqlServer := NewSQLStore(nil, &SQLStoreOptions{Table: "delayed", Placeholder: AtPPlaceholder, LimitClause: FetchFirstClause})
+	g.Expect(sqlServer.dueQuery(5)).To(Equal(
+		"SELECT id, deliver_at, message FROM delayed WHERE deliver_at <= @p1 ORDER BY deliver_at, id OFFSET 0 ROWS FETCH NEXT 5 ROWS ONLY"))
+	g.Expect(NewSQLStore(nil, &SQLStoreOptions{Placeholder: DollarPlaceholder}).deleteQuery(2)).To(Equal(
+		"DELETE FROM shuttle_delayed_messages WHERE id IN ($1, $2)"))
+}
+
+func TestSQLStore(t *testing.T) {
+	g := NewWithT(t)
+	conn := &fakeConn{}
+	db := sql.OpenDB(conn)
+	defer db.Close()
+	store := NewSQLStore(db, nil)
+	at := time.Date(2024, 1, 31, 12, 0, 0, 0, time.FixedZone("CET", 3600))
+
+	g.Expect(store.Save(context.Background(), Record{ID: "1", DeliverAt: at, Message: []byte(`{}`)})).To(Succeed())
+	g.Expect(conn.execs).To(ConsistOf(fakeStatement{query: store.insertQuery(), args: []any{"1", at.UTC(), []byte(`{}`)}}))
+
+	conn.rows = [][]driver.Value{
+		{"1", at.UTC(), []byte(`{"a":1}`)},
+		{"2", at.UTC(), []byte(`{"a":2}`)},
+	}
+	records, err := store.Due(context.Background(), at, 10)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(conn.query).To(Equal(fakeStatement{query: store.dueQuery(10), args: []any{at.UTC()}}))
+	g.Expect(records).To(HaveLen(2))
+	g.Expect(records[0].ID).To(Equal("1"))
+	g.Expect(records[0].DeliverAt.Equal(at)).To(BeTrue())
+	g.Expect(records[1].Message).To(Equal([]byte(`{"a":2}`)))
+
+	conn.rows = [][]driver.Value{{"1", "not a time", []byte(`{}`)}}
+	_, err = store.Due(context.Background(), at, 10)
+	g.Expect(err).To(HaveOccurred(), "the rows that cannot be scanned fail the query")
+
+	conn.execs = nil
+	g.Expect(store.Delete(context.Background())).To(Succeed())
+	g.Expect(conn.execs).To(BeEmpty(), "no statement is run without ids")
+	g.Expect(store.Delete(context.Background(), "1", "2")).To(Succeed())
+	g.Expect(conn.execs).To(ConsistOf(fakeStatement{query: store.deleteQuery(2), args: []any{"1", "2"}}))
+}
@@ -0,0 +1,77 @@
+package shuttle
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus/admin"
+)
+
+// MaxDeliveryCountProvider returns the MaxDeliveryCount of the queue or subscription the messages are received from.
+type MaxDeliveryCountProvider interface {
+	MaxDeliveryCount(ctx context.Context) (int32, error)
+}
+
+// MaxDeliveryCountFunc allows to use a func as a MaxDeliveryCountProvider.
+type MaxDeliveryCountFunc func(ctx context.Context) (int32, error)
+
+func (f MaxDeliveryCountFunc) MaxDeliveryCount(ctx context.Context) (int32, error) {
+	return f(ctx)
+}
+
+// StaticMaxDeliveryCount is a MaxDeliveryCountProvider for a known MaxDeliveryCount.
+type StaticMaxDeliveryCount int32
+
+func (s StaticMaxDeliveryCount) MaxDeliveryCount(_ context.Context) (int32, error) {
+	return int32(s), nil
+}
+
+// NewQueueMaxDeliveryCount fetches the MaxDeliveryCount of the queue with the admin client, and caches it for ttl.
+// ttl defaults to 5 minutes when set to 0. It is a CachedEntityInfo, see NewQueueEntityInfo.
+func NewQueueMaxDeliveryCount(client *admin.Client, queue string, ttl time.Duration) *CachedEntityInfo {
+	return NewQueueEntityInfo(client, queue, ttl)
+}
+
+// NewSubscriptionMaxDeliveryCount fetches the MaxDeliveryCount of the subscription with the admin client, and caches it for ttl.
+// ttl defaults to 5 minutes when set to 0. It is a CachedEntityInfo, see NewSubscriptionEntityInfo.
+func NewSubscriptionMaxDeliveryCount(client *admin.Client, topic, subscription string, ttl time.Duration) *CachedEntityInfo {
+	return NewSubscriptionEntityInfo(client, topic, subscription, ttl)
+}
+
+type remainingAttemptsKey struct{}
+
+// RemainingAttempts returns the number of deliveries left for the message after the current one,
+// as set in the context by the NewRemainingAttemptsHandler middleware.
+// It returns false when the middleware did not set it.
+func RemainingAttempts(ctx context.Context) (int32, bool) {
+	remaining, ok := ctx.Value(remainingAttemptsKey{}).(int32)
+	return remaining, ok
+}
+
+// IsFinalAttempt returns true when the current delivery is the last one before the message is dead-lettered by the broker.
+// Handlers can use it to fall back to a cheaper behavior. It returns false when the remaining attempts are unknown.
+func IsFinalAttempt(ctx context.Context) bool {
+	remaining, ok := RemainingAttempts(ctx)
+	return ok && remaining <= 0
+}
+
+// NewRemainingAttemptsHandler is a middleware that computes the remaining delivery attempts of the message
+// from its DeliveryCount and the entity's MaxDeliveryCount, and sets them in the context for RemainingAttempts.
+// The context is passed unchanged when the MaxDeliveryCount cannot be retrieved.
+func NewRemainingAttemptsHandler(provider MaxDeliveryCountProvider, next Handler) HandlerFunc {
+	return func(ctx context.Context, settler MessageSettler, message *azservicebus.ReceivedMessage) {
+		maxDeliveryCount, err := provider.MaxDeliveryCount(ctx)
+		if err != nil {
+			log(ctx, fmt.Sprintf("failed to compute remaining attempts: %s", err))
+			next.Handle(ctx, settler, message)
+			return
+		}
+		remaining := maxDeliveryCount - int32(message.DeliveryCount)
+		if remaining < 0 {
+			remaining = 0
+		}
+		next.Handle(context.WithValue(ctx, remainingAttemptsKey{}, remaining), settler, message)
+	}
+}
@@ -0,0 +1,51 @@
+package shuttle
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	. "github.com/onsi/gomega"
+)
+
+func TestRemainingAttemptsHandler(t *testing.T) {
+	g := NewWithT(t)
+	for _, tc := range []struct {
+		deliveryCount uint32
+		remaining     int32
+		final         bool
+	}{
+		{deliveryCount: 1, remaining: 9},
+		{deliveryCount: 9, remaining: 1},
+		{deliveryCount: 10, remaining: 0, final: true},
+		{deliveryCount: 11, remaining: 0, final: true},
+	} {
+		var remaining int32
+		var ok, final bool
+		handler := NewRemainingAttemptsHandler(StaticMaxDeliveryCount(10), HandlerFunc(
+			func(ctx context.Context, _ MessageSettler, _ *azservicebus.ReceivedMessage) {
+				remaining, ok = RemainingAttempts(ctx)
+				final = IsFinalAttempt(ctx)
+			}))
+		handler(context.Background(), &fakeSettler{}, &azservicebus.ReceivedMessage{DeliveryCount: tc.deliveryCount})
+		g.Expect(ok).To(BeTrue())
+		g.Expect(remaining).To(Equal(tc.remaining))
+		g.Expect(final).To(Equal(tc.final))
+	}
+}
+
+func TestRemainingAttemptsHandler_ProviderError(t *testing.T) {
+	g := NewWithT(t)
+	called := false
+	handler := NewRemainingAttemptsHandler(MaxDeliveryCountFunc(func(ctx context.Context) (int32, error) {
+		return 0, errors.New("forbidden")
+	}), HandlerFunc(func(ctx context.Context, _ MessageSettler, _ *azservicebus.ReceivedMessage) {
+		called = true
+		_, ok := RemainingAttempts(ctx)
+		g.Expect(ok).To(BeFalse())
+		g.Expect(IsFinalAttempt(ctx)).To(BeFalse())
+	}))
+	handler(context.Background(), &fakeSettler{}, &azservicebus.ReceivedMessage{})
+	g.Expect(called).To(BeTrue())
+}
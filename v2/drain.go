@@ -0,0 +1,85 @@
+package shuttle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	defaultDrainPollInterval = 5 * time.Second
+	defaultDrainEmptyPolls   = 2
+)
+
+// ErrDrainIncomplete is returned by DrainAndStop when ctx is done before the entity is drained.
+var ErrDrainIncomplete = errors.New("entity not drained")
+
+// DrainOptions configures Processor.DrainAndStop.
+type DrainOptions struct {
+	// PollInterval is the time between two checks of the active message count. Defaults to 5 seconds.
+	PollInterval time.Duration
+	// EmptyPolls is the number of consecutive checks reporting no active message and no message in flight
+	// before the processor stops, as the runtime properties of the entity are updated with a delay. Defaults to 2.
+	EmptyPolls int
+}
+
+// DrainAndStop starts the processor and keeps processing until the entity reports no active message,
+// for example to fully drain a queue before a blue/green cutover. The active message count is polled from depth,
+// built with NewQueueDepth or NewSubscriptionDepth. Once drained, the processor stops and DrainAndStop waits for
+// the messages in flight to be settled before returning nil.
+//
+// When ctx is done first, for example when its deadline passes, the processor stops and DrainAndStop returns
+// an error wrapping ErrDrainIncomplete and the error of ctx. The errors of the processor are returned as is.
+// The scheduled and deferred messages are not active messages and are not waited for.
+func (p *Processor) DrainAndStop(ctx context.Context, depth QueueDepthProvider, options *DrainOptions) error {
+	opts := DrainOptions{}
+	if options != nil {
+		opts = *options
+	}
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = defaultDrainPollInterval
+	}
+	if opts.EmptyPolls <= 0 {
+		opts.EmptyPolls = defaultDrainEmptyPolls
+	}
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- p.Start(runCtx) }()
+
+	emptyPolls := 0
+	remaining := "unknown"
+	for {
+		select {
+		case err := <-done:
+			if ctx.Err() == nil {
+				return err
+			}
+			return fmt.Errorf("%w, %s active messages: %w", ErrDrainIncomplete, remaining, ctx.Err())
+		case <-ctx.Done():
+			cancel()
+			<-done
+			return fmt.Errorf("%w, %s active messages: %w", ErrDrainIncomplete, remaining, ctx.Err())
+		case <-time.After(opts.PollInterval):
+		}
+		count, err := depth.ActiveMessageCount(ctx)
+		if err != nil {
+			log(ctx, fmt.Sprintf("failed to check the drain of the entity: %s", err))
+			emptyPolls = 0
+			continue
+		}
+		remaining = fmt.Sprint(count)
+		if count > 0 || p.stats.inFlight.Load() > 0 {
+			emptyPolls = 0
+			continue
+		}
+		if emptyPolls++; emptyPolls < opts.EmptyPolls {
+			continue
+		}
+		log(ctx, "entity drained, stopping processor")
+		cancel()
+		<-done
+		return p.waitForInFlight(ctx)
+	}
+}
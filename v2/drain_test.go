@@ -0,0 +1,73 @@
+package shuttle
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	. "github.com/onsi/gomega"
+)
+
+// sliceSource returns its messages until it is empty, without waiting for new messages.
+type sliceSource struct {
+	mu       sync.Mutex
+	messages []*azservicebus.ReceivedMessage
+}
+
+func (s *sliceSource) ReceiveMessages(_ context.Context, maxMessages int, _ *azservicebus.ReceiveMessagesOptions) ([]*azservicebus.ReceivedMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	count := min(maxMessages, len(s.messages))
+	received := s.messages[:count]
+	s.messages = s.messages[count:]
+	return received, nil
+}
+
+func (s *sliceSource) ActiveMessageCount(_ context.Context) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return int64(len(s.messages)), nil
+}
+
+func TestProcessor_DrainAndStop(t *testing.T) {
+	g := NewWithT(t)
+	source := &sliceSource{}
+	for i := 0; i < 10; i++ {
+		source.messages = append(source.messages, &azservicebus.ReceivedMessage{})
+	}
+	var handled atomic.Int32
+	p := NewProcessor(NewSourceReceiver(source, &fakeSettler{}), func(ctx context.Context, settler MessageSettler, message *azservicebus.ReceivedMessage) {
+		time.Sleep(time.Millisecond)
+		handled.Add(1)
+	}, &ProcessorOptions{MaxConcurrency: 3, ReceiveInterval: to.Ptr(time.Millisecond)})
+
+	err := p.DrainAndStop(context.Background(), source, &DrainOptions{PollInterval: 5 * time.Millisecond})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(handled.Load()).To(Equal(int32(10)))
+	g.Expect(p.Ready()).To(BeFalse())
+}
+
+func TestProcessor_DrainAndStopDeadline(t *testing.T) {
+	g := NewWithT(t)
+	p := NewProcessor(&idleReceiver{}, noopHandler, &ProcessorOptions{MaxConcurrency: 1})
+	depth := QueueDepthFunc(func(context.Context) (int64, error) { return 4, nil })
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	err := p.DrainAndStop(ctx, depth, &DrainOptions{PollInterval: time.Millisecond})
+	g.Expect(err).To(MatchError(ErrDrainIncomplete))
+	g.Expect(err).To(MatchError(context.DeadlineExceeded))
+	g.Expect(err).To(MatchError(ContainSubstring("4 active messages")))
+}
+
+func TestProcessor_DrainAndStopReceiveError(t *testing.T) {
+	g := NewWithT(t)
+	receiveErr := errors.New("link detached")
+	p := NewProcessor(&idleReceiver{receiveErr: receiveErr}, noopHandler, &ProcessorOptions{MaxConcurrency: 1})
+	depth := QueueDepthFunc(func(context.Context) (int64, error) { return 0, nil })
+	g.Expect(p.DrainAndStop(context.Background(), depth, nil)).To(MatchError(receiveErr))
+}
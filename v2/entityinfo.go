@@ -0,0 +1,273 @@
+package shuttle
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus/admin"
+)
+
+const (
+	defaultEntityInfoCacheTTL = 5 * time.Minute
+	// entityInfoErrorTTL is how long a failed fetch is returned before fetching again,
+	// to not query the admin api for each message while it fails.
+	entityInfoErrorTTL = 5 * time.Second
+)
+
+// EntityInfo are the properties of the queue or subscription the messages are received from,
+// for the middlewares to adapt to the entity instead of hardcoding assumptions.
+type EntityInfo struct {
+	// LockDuration is the duration of the lock of the received messages.
+	LockDuration time.Duration
+	// MaxDeliveryCount is the number of deliveries after which the broker dead-letters the message.
+	MaxDeliveryCount int32
+	// RequiresSession is true when the messages must be received with a session receiver.
+	RequiresSession bool
+	// MaxSizeInMegabytes is the maximum size of the queue or topic.
+	MaxSizeInMegabytes int32
+	// MaxMessageSizeInKilobytes is the maximum size of a message. It is 0 when not reported, on the standard tier.
+	MaxMessageSizeInKilobytes int64
+}
+
+// LockRenewalInterval returns half the LockDuration, to renew the lock well before it expires.
+// It returns 0 when the LockDuration is unknown.
+func (i EntityInfo) LockRenewalInterval() time.Duration {
+	return i.LockDuration / 2
+}
+
+// EntityInfoProvider returns the properties of the queue or subscription the messages are received from.
+type EntityInfoProvider interface {
+	EntityInfo(ctx context.Context) (EntityInfo, error)
+}
+
+// EntityInfoFunc allows to use a func as an EntityInfoProvider.
+type EntityInfoFunc func(ctx context.Context) (EntityInfo, error)
+
+func (f EntityInfoFunc) EntityInfo(ctx context.Context) (EntityInfo, error) {
+	return f(ctx)
+}
+
+// CachedEntityInfo caches the EntityInfo fetched from the admin api.
+// Concurrent callers share a single fetch, and a failed fetch is returned for a few seconds before fetching again.
+// It is also a MaxDeliveryCountProvider, for NewRemainingAttemptsHandler.
+type CachedEntityInfo struct {
+	fetch     func(ctx context.Context) (*EntityInfo, error)
+	ttl       time.Duration
+	mu        sync.Mutex
+	value     EntityInfo
+	fetchedAt time.Time
+	err       error
+	failedAt  time.Time
+	call      *entityInfoCall
+	now       func() time.Time
+}
+
+// entityInfoCall is a fetch in progress, shared by the concurrent callers.
+type entityInfoCall struct {
+	done  chan struct{}
+	value EntityInfo
+	err   error
+}
+
+// NewQueueEntityInfo fetches the properties of the queue with the admin client, and caches them for ttl.
+// ttl defaults to 5 minutes when set to 0.
+func NewQueueEntityInfo(client *admin.Client, queue string, ttl time.Duration) *CachedEntityInfo {
+	return newCachedEntityInfo(func(ctx context.Context) (*EntityInfo, error) {
+		res, err := client.GetQueue(ctx, queue, nil)
+		if err != nil || res == nil {
+			return nil, err
+		}
+		lockDuration, err := parseISO8601Duration(res.LockDuration)
+		if err != nil {
+			return nil, err
+		}
+		return &EntityInfo{
+			LockDuration:              lockDuration,
+			MaxDeliveryCount:          valueOrZero(res.MaxDeliveryCount),
+			RequiresSession:           valueOrZero(res.RequiresSession),
+			MaxSizeInMegabytes:        valueOrZero(res.MaxSizeInMegabytes),
+			MaxMessageSizeInKilobytes: valueOrZero(res.MaxMessageSizeInKilobytes),
+		}, nil
+	}, ttl)
+}
+
+// NewSubscriptionEntityInfo fetches the properties of the subscription, and the size limits of its topic,
+// with the admin client, and caches them for ttl.
+// ttl defaults to 5 minutes when set to 0.
+func NewSubscriptionEntityInfo(client *admin.Client, topic, subscription string, ttl time.Duration) *CachedEntityInfo {
+	return newCachedEntityInfo(func(ctx context.Context) (*EntityInfo, error) {
+		res, err := client.GetSubscription(ctx, topic, subscription, nil)
+		if err != nil || res == nil {
+			return nil, err
+		}
+		topicRes, err := client.GetTopic(ctx, topic, nil)
+		if err != nil || topicRes == nil {
+			return nil, err
+		}
+		lockDuration, err := parseISO8601Duration(res.LockDuration)
+		if err != nil {
+			return nil, err
+		}
+		return &EntityInfo{
+			LockDuration:              lockDuration,
+			MaxDeliveryCount:          valueOrZero(res.MaxDeliveryCount),
+			RequiresSession:           valueOrZero(res.RequiresSession),
+			MaxSizeInMegabytes:        valueOrZero(topicRes.MaxSizeInMegabytes),
+			MaxMessageSizeInKilobytes: valueOrZero(topicRes.MaxMessageSizeInKilobytes),
+		}, nil
+	}, ttl)
+}
+
+func newCachedEntityInfo(fetch func(ctx context.Context) (*EntityInfo, error), ttl time.Duration) *CachedEntityInfo {
+	if ttl <= 0 {
+		ttl = defaultEntityInfoCacheTTL
+	}
+	return &CachedEntityInfo{fetch: fetch, ttl: ttl, now: time.Now}
+}
+
+// EntityInfo returns the cached properties of the entity, fetching them when the cache expired.
+func (c *CachedEntityInfo) EntityInfo(ctx context.Context) (EntityInfo, error) {
+	c.mu.Lock()
+	now := c.now()
+	if !c.fetchedAt.IsZero() && now.Sub(c.fetchedAt) < c.ttl {
+		defer c.mu.Unlock()
+		return c.value, nil
+	}
+	if c.err != nil && now.Sub(c.failedAt) < entityInfoErrorTTL {
+		defer c.mu.Unlock()
+		return EntityInfo{}, c.err
+	}
+	call := c.call
+	if call == nil {
+		call = &entityInfoCall{done: make(chan struct{})}
+		c.call = call
+		c.mu.Unlock()
+		c.doFetch(ctx, call)
+	} else {
+		c.mu.Unlock()
+	}
+	select {
+	case <-call.done:
+		return call.value, call.err
+	case <-ctx.Done():
+		return EntityInfo{}, fmt.Errorf("failed to get entity info: %w", ctx.Err())
+	}
+}
+
+// doFetch fetches the properties outside the lock, and stores the result for the callers waiting on the call.
+// The errors of the canceled fetches are not cached, as they are specific to the caller.
+func (c *CachedEntityInfo) doFetch(ctx context.Context, call *entityInfoCall) {
+	value, err := c.fetch(ctx)
+	switch {
+	case err != nil:
+		call.err = fmt.Errorf("failed to get entity info: %w", err)
+	case value == nil:
+		call.err = fmt.Errorf("failed to get entity info: entity not found")
+	default:
+		call.value = *value
+	}
+	c.mu.Lock()
+	c.call = nil
+	switch {
+	case call.err == nil:
+		c.value = call.value
+		c.fetchedAt = c.now()
+		c.err = nil
+	case ctx.Err() == nil:
+		c.err = call.err
+		c.failedAt = c.now()
+	}
+	c.mu.Unlock()
+	close(call.done)
+}
+
+// MaxDeliveryCount returns the cached MaxDeliveryCount of the entity.
+func (c *CachedEntityInfo) MaxDeliveryCount(ctx context.Context) (int32, error) {
+	info, err := c.EntityInfo(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return info.MaxDeliveryCount, nil
+}
+
+type entityInfoKey struct{}
+
+// EntityInfoFromContext returns the properties of the entity the message was received from,
+// as set in the context by the NewEntityInfoHandler middleware.
+func EntityInfoFromContext(ctx context.Context) (EntityInfo, bool) {
+	info, ok := ctx.Value(entityInfoKey{}).(EntityInfo)
+	return info, ok
+}
+
+// NewEntityInfoHandler is a middleware that sets the properties of the entity in the context for EntityInfoFromContext.
+// The context is passed unchanged when the properties cannot be retrieved.
+func NewEntityInfoHandler(provider EntityInfoProvider, next Handler) HandlerFunc {
+	return func(ctx context.Context, settler MessageSettler, message *azservicebus.ReceivedMessage) {
+		info, err := provider.EntityInfo(ctx)
+		if err != nil {
+			log(ctx, fmt.Sprintf("failed to get entity info: %s", err))
+			next.Handle(ctx, settler, message)
+			return
+		}
+		next.Handle(context.WithValue(ctx, entityInfoKey{}, info), settler, message)
+	}
+}
+
+func valueOrZero[T any](v *T) T {
+	var zero T
+	if v == nil {
+		return zero
+	}
+	return *v
+}
+
+// parseISO8601Duration parses the durations returned by the admin api, such as PT1M or P1DT30S.
+// It returns 0 when the duration is nil.
+func parseISO8601Duration(value *string) (time.Duration, error) {
+	if value == nil {
+		return 0, nil
+	}
+	s := *value
+	if !strings.HasPrefix(s, "P") {
+		return 0, fmt.Errorf("invalid ISO 8601 duration %q", s)
+	}
+	s = s[1:]
+	var total time.Duration
+	inTime := false
+	for len(s) > 0 {
+		if s[0] == 'T' {
+			inTime = true
+			s = s[1:]
+			continue
+		}
+		end := strings.IndexAny(s, "DHMS")
+		if end <= 0 {
+			return 0, fmt.Errorf("invalid ISO 8601 duration %q", *value)
+		}
+		n, err := strconv.ParseFloat(s[:end], 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid ISO 8601 duration %q: %w", *value, err)
+		}
+		var unit time.Duration
+		switch {
+		case s[end] == 'D' && !inTime:
+			unit = 24 * time.Hour
+		case s[end] == 'H' && inTime:
+			unit = time.Hour
+		case s[end] == 'M' && inTime:
+			unit = time.Minute
+		case s[end] == 'S' && inTime:
+			unit = time.Second
+		default:
+			return 0, fmt.Errorf("invalid ISO 8601 duration %q", *value)
+		}
+		total += time.Duration(n * float64(unit))
+		s = s[end+1:]
+	}
+	return total, nil
+}
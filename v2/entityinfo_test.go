@@ -0,0 +1,129 @@
+package shuttle
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	. "github.com/onsi/gomega"
+)
+
+func TestCachedEntityInfo(t *testing.T) {
+	g := NewWithT(t)
+	fetches := 0
+	var fetchErr error
+	now := time.Now()
+	cache := newCachedEntityInfo(func(ctx context.Context) (*EntityInfo, error) {
+		fetches++
+		return &EntityInfo{LockDuration: time.Minute, MaxDeliveryCount: int32(fetches)}, fetchErr
+	}, time.Minute)
+	cache.now = func() time.Time { return now }
+
+	info, err := cache.EntityInfo(context.Background())
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(info.LockDuration).To(Equal(time.Minute))
+	g.Expect(info.LockRenewalInterval()).To(Equal(30 * time.Second))
+	maxDeliveryCount, err := cache.MaxDeliveryCount(context.Background())
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(maxDeliveryCount).To(Equal(int32(1)))
+	g.Expect(fetches).To(Equal(1))
+
+	now = now.Add(2 * time.Minute)
+	fetchErr = errors.New("forbidden")
+	_, err = cache.EntityInfo(context.Background())
+	g.Expect(err).To(MatchError(ContainSubstring("forbidden")))
+
+	fetchErr = nil
+	_, err = cache.EntityInfo(context.Background())
+	g.Expect(err).To(MatchError(ContainSubstring("forbidden")), "the error is cached briefly")
+	g.Expect(fetches).To(Equal(2))
+
+	now = now.Add(entityInfoErrorTTL)
+	info, err = cache.EntityInfo(context.Background())
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(info.MaxDeliveryCount).To(Equal(int32(3)))
+}
+
+func TestCachedEntityInfo_SharesFetch(t *testing.T) {
+	g := NewWithT(t)
+	var fetches atomic.Int32
+	release := make(chan struct{})
+	cache := newCachedEntityInfo(func(ctx context.Context) (*EntityInfo, error) {
+		fetches.Add(1)
+		<-release
+		return &EntityInfo{MaxDeliveryCount: 10}, nil
+	}, time.Minute)
+
+	results := make(chan int32, 5)
+	for i := 0; i < 5; i++ {
+		go func() {
+			info, err := cache.EntityInfo(context.Background())
+			g.Expect(err).ToNot(HaveOccurred())
+			results <- info.MaxDeliveryCount
+		}()
+	}
+	g.Eventually(fetches.Load).Should(Equal(int32(1)))
+	// the cache is not locked during the fetch
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := cache.EntityInfo(ctx)
+	g.Expect(err).To(MatchError(context.DeadlineExceeded))
+
+	close(release)
+	for i := 0; i < 5; i++ {
+		g.Eventually(results).Should(Receive(Equal(int32(10))))
+	}
+	g.Expect(fetches.Load()).To(Equal(int32(1)))
+}
+
+func TestCachedEntityInfo_NotFound(t *testing.T) {
+	g := NewWithT(t)
+	cache := newCachedEntityInfo(func(ctx context.Context) (*EntityInfo, error) { return nil, nil }, 0)
+	_, err := cache.EntityInfo(context.Background())
+	g.Expect(err).To(MatchError(ContainSubstring("entity not found")))
+}
+
+func TestEntityInfoHandler(t *testing.T) {
+	g := NewWithT(t)
+	var info EntityInfo
+	var ok bool
+	next := HandlerFunc(func(ctx context.Context, _ MessageSettler, _ *azservicebus.ReceivedMessage) {
+		info, ok = EntityInfoFromContext(ctx)
+	})
+	NewEntityInfoHandler(EntityInfoFunc(func(ctx context.Context) (EntityInfo, error) {
+		return EntityInfo{RequiresSession: true}, nil
+	}), next).Handle(context.Background(), &fakeSettler{}, &azservicebus.ReceivedMessage{})
+	g.Expect(ok).To(BeTrue())
+	g.Expect(info.RequiresSession).To(BeTrue())
+
+	NewEntityInfoHandler(EntityInfoFunc(func(ctx context.Context) (EntityInfo, error) {
+		return EntityInfo{}, errors.New("forbidden")
+	}), next).Handle(context.Background(), &fakeSettler{}, &azservicebus.ReceivedMessage{})
+	g.Expect(ok).To(BeFalse())
+}
+
+func TestParseISO8601Duration(t *testing.T) {
+	g := NewWithT(t)
+	for value, expected := range map[string]time.Duration{
+		"PT1M":       time.Minute,
+		"PT30S":      30 * time.Second,
+		"PT0.5S":     500 * time.Millisecond,
+		"P1DT2H3M4S": 26*time.Hour + 3*time.Minute + 4*time.Second,
+		"P14D":       14 * 24 * time.Hour,
+	} {
+		d, err := parseISO8601Duration(to.Ptr(value))
+		g.Expect(err).ToNot(HaveOccurred(), value)
+		g.Expect(d).To(Equal(expected), value)
+	}
+	for _, value := range []string{"1M", "PTM", "P1M", "PT1D", "PTxS"} {
+		_, err := parseISO8601Duration(to.Ptr(value))
+		g.Expect(err).To(HaveOccurred(), value)
+	}
+	d, err := parseISO8601Duration(nil)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(d).To(BeZero())
+}
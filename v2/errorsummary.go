@@ -0,0 +1,182 @@
+package shuttle
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+)
+
+const (
+	defaultErrorSummaryWindow = time.Minute
+	maxErrorSummaryMessageIDs = 5
+)
+
+// ErrorSummary aggregates the identical errors returned by the handler over a window.
+type ErrorSummary struct {
+	// Error is the message of the error.
+	Error string
+	// Count is the number of times the error was returned in the window, including the first one.
+	Count int
+	// FirstSeen and LastSeen are the times of the first and the last occurrence of the error in the window.
+	FirstSeen time.Time
+	LastSeen  time.Time
+	// MessageIDs are the ids of the first messages that failed with the error, up to 5.
+	MessageIDs []string
+}
+
+// String formats the summary as a structured log line.
+func (s ErrorSummary) String() string {
+	return fmt.Sprintf("handler error summary: error=%q count=%d first_seen=%s last_seen=%s message_ids=%s",
+		s.Error, s.Count, s.FirstSeen.UTC().Format(time.RFC3339), s.LastSeen.UTC().Format(time.RFC3339),
+		strings.Join(s.MessageIDs, ","))
+}
+
+// ErrorSummarizerOptions configures the ErrorSummarizer.
+type ErrorSummarizerOptions struct {
+	// Window is the period over which the identical errors are aggregated. Defaults to 1 minute.
+	Window time.Duration
+	// OnFirstError is called with the first occurrence of an error in the window.
+	// Defaults to logging the error with the Logger set by SetLoggerFunc.
+	OnFirstError func(ctx context.Context, message *azservicebus.ReceivedMessage, err error)
+	// OnSummary is called at the end of the window for each error returned more than once.
+	// Defaults to logging the summary with the Logger set by SetLoggerFunc.
+	OnSummary func(ctx context.Context, summary ErrorSummary)
+	// Clock is used to measure the window. Defaults to the system clock.
+	Clock Clock
+}
+
+// ErrorSummarizer throttles the logging of the handler errors: the first occurrence of an error is logged,
+// and its repetitions are aggregated into a single summary line with counts at the end of the window.
+// It prevents log storms when a downstream is hard-down and thousands of messages fail identically.
+type ErrorSummarizer struct {
+	options ErrorSummarizerOptions
+
+	mu          sync.Mutex
+	windowStart time.Time
+	summaries   map[string]*ErrorSummary
+}
+
+// NewErrorSummarizer creates an ErrorSummarizer.
+func NewErrorSummarizer(options *ErrorSummarizerOptions) *ErrorSummarizer {
+	opts := ErrorSummarizerOptions{}
+	if options != nil {
+		opts = *options
+	}
+	if opts.Window <= 0 {
+		opts.Window = defaultErrorSummaryWindow
+	}
+	if opts.OnFirstError == nil {
+		opts.OnFirstError = func(ctx context.Context, message *azservicebus.ReceivedMessage, err error) {
+			if l := getLogger(ctx); l != nil {
+				l.Error(fmt.Sprintf("handler error: error=%q message_id=%s", err.Error(), message.MessageID))
+			}
+		}
+	}
+	if opts.OnSummary == nil {
+		opts.OnSummary = func(ctx context.Context, summary ErrorSummary) {
+			if l := getLogger(ctx); l != nil {
+				l.Error(summary.String())
+			}
+		}
+	}
+	opts.Clock = clockOrDefault(opts.Clock)
+	return &ErrorSummarizer{options: opts, summaries: map[string]*ErrorSummary{}}
+}
+
+// Handler is a middleware recording the errors returned by next. The errors are returned unchanged,
+// so that it can be combined with the ManagedSettler:
+//
+//	shuttle.NewManagedSettlingHandler(opts, summarizer.Handler(handler))
+func (s *ErrorSummarizer) Handler(next ManagedSettlingHandler) ManagedSettlingFunc {
+	return func(ctx context.Context, message *azservicebus.ReceivedMessage) error {
+		err := next.Handle(ctx, message)
+		if err != nil {
+			s.record(ctx, message, err)
+		}
+		return err
+	}
+}
+
+// Run emits the summaries at the end of each window until ctx is done, then emits the pending ones.
+// Without Run, the summaries of a window are emitted on the first error of a later window, or by Flush.
+func (s *ErrorSummarizer) Run(ctx context.Context) error {
+	for {
+		select {
+		case <-ctx.Done():
+			s.Flush(ctx)
+			return ctx.Err()
+		case <-s.options.Clock.After(s.options.Window):
+			s.mu.Lock()
+			expired := s.expireLocked(s.options.Clock.Now())
+			s.mu.Unlock()
+			s.emit(ctx, expired)
+		}
+	}
+}
+
+// Flush emits the summaries of the current window, and starts a new one.
+func (s *ErrorSummarizer) Flush(ctx context.Context) {
+	s.mu.Lock()
+	pending := s.takeLocked()
+	s.mu.Unlock()
+	s.emit(ctx, pending)
+}
+
+func (s *ErrorSummarizer) record(ctx context.Context, message *azservicebus.ReceivedMessage, err error) {
+	now := s.options.Clock.Now()
+	s.mu.Lock()
+	expired := s.expireLocked(now)
+	if s.windowStart.IsZero() {
+		s.windowStart = now
+	}
+	key := err.Error()
+	summary, seen := s.summaries[key]
+	if !seen {
+		summary = &ErrorSummary{Error: key, FirstSeen: now}
+		s.summaries[key] = summary
+	}
+	summary.Count++
+	summary.LastSeen = now
+	if len(summary.MessageIDs) < maxErrorSummaryMessageIDs {
+		summary.MessageIDs = append(summary.MessageIDs, message.MessageID)
+	}
+	s.mu.Unlock()
+
+	s.emit(ctx, expired)
+	if !seen {
+		s.options.OnFirstError(ctx, message, err)
+	}
+}
+
+// expireLocked takes the summaries of the window when it is over at now.
+func (s *ErrorSummarizer) expireLocked(now time.Time) []ErrorSummary {
+	if s.windowStart.IsZero() || now.Sub(s.windowStart) < s.options.Window {
+		return nil
+	}
+	return s.takeLocked()
+}
+
+// takeLocked takes the summaries of the errors returned more than once, and starts a new window.
+func (s *ErrorSummarizer) takeLocked() []ErrorSummary {
+	var taken []ErrorSummary
+	for _, summary := range s.summaries {
+		if summary.Count > 1 {
+			taken = append(taken, *summary)
+		}
+	}
+	s.summaries = map[string]*ErrorSummary{}
+	s.windowStart = time.Time{}
+	sort.Slice(taken, func(i, j int) bool { return taken[i].FirstSeen.Before(taken[j].FirstSeen) })
+	return taken
+}
+
+func (s *ErrorSummarizer) emit(ctx context.Context, summaries []ErrorSummary) {
+	for _, summary := range summaries {
+		s.options.OnSummary(ctx, summary)
+	}
+}
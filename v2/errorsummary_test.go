@@ -0,0 +1,68 @@
+package shuttle_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	. "github.com/onsi/gomega"
+
+	"github.com/Azure/go-shuttle/v2"
+	"github.com/Azure/go-shuttle/v2/shuttletest"
+)
+
+func TestErrorSummarizer(t *testing.T) {
+	g := NewWithT(t)
+	clock := shuttletest.NewFakeClock(time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC))
+	var first []string
+	var summaries []shuttle.ErrorSummary
+	summarizer := shuttle.NewErrorSummarizer(&shuttle.ErrorSummarizerOptions{
+		Window: time.Minute,
+		Clock:  clock,
+		OnFirstError: func(ctx context.Context, message *azservicebus.ReceivedMessage, err error) {
+			first = append(first, err.Error())
+		},
+		OnSummary: func(ctx context.Context, summary shuttle.ErrorSummary) {
+			summaries = append(summaries, summary)
+		},
+	})
+	downstreamErr := errors.New("downstream unavailable")
+	handler := summarizer.Handler(shuttle.ManagedSettlingFunc(func(ctx context.Context, message *azservicebus.ReceivedMessage) error {
+		if message.MessageID == "ok" {
+			return nil
+		}
+		if message.MessageID == "other" {
+			return errors.New("invalid payload")
+		}
+		return downstreamErr
+	}))
+
+	for i := 0; i < 10; i++ {
+		err := handler(context.Background(), &azservicebus.ReceivedMessage{MessageID: fmt.Sprintf("msg-%d", i)})
+		g.Expect(err).To(Equal(downstreamErr))
+		clock.Advance(time.Second)
+	}
+	g.Expect(handler(context.Background(), &azservicebus.ReceivedMessage{MessageID: "ok"})).To(Succeed())
+	g.Expect(handler(context.Background(), &azservicebus.ReceivedMessage{MessageID: "other"})).ToNot(Succeed())
+	g.Expect(first).To(Equal([]string{"downstream unavailable", "invalid payload"}))
+	g.Expect(summaries).To(BeEmpty())
+
+	// the first error of the next window emits the summary of the previous one
+	clock.Advance(time.Minute)
+	g.Expect(handler(context.Background(), &azservicebus.ReceivedMessage{MessageID: "msg-10"})).ToNot(Succeed())
+	g.Expect(first).To(HaveLen(3))
+	g.Expect(summaries).To(HaveLen(1))
+	g.Expect(summaries[0].Error).To(Equal("downstream unavailable"))
+	g.Expect(summaries[0].Count).To(Equal(10))
+	g.Expect(summaries[0].LastSeen.Sub(summaries[0].FirstSeen)).To(Equal(9 * time.Second))
+	g.Expect(summaries[0].MessageIDs).To(Equal([]string{"msg-0", "msg-1", "msg-2", "msg-3", "msg-4"}))
+	g.Expect(summaries[0].String()).To(ContainSubstring(`error="downstream unavailable" count=10`))
+
+	g.Expect(handler(context.Background(), &azservicebus.ReceivedMessage{MessageID: "msg-11"})).ToNot(Succeed())
+	summarizer.Flush(context.Background())
+	g.Expect(summaries).To(HaveLen(2))
+	g.Expect(summaries[1].Count).To(Equal(2))
+}
@@ -0,0 +1,81 @@
+package shuttle
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+
+	"github.com/Azure/go-shuttle/v2/metrics/processor"
+)
+
+// TimeToExpiry returns the time left before the message expires on the broker, negative when it already expired.
+// The expiry is ExpiresAt, or EnqueuedTime plus TimeToLive when ExpiresAt is not set.
+// It returns false when the message does not carry enough information to compute its expiry.
+func TimeToExpiry(message *azservicebus.ReceivedMessage) (time.Duration, bool) {
+	return timeToExpiry(message, time.Now())
+}
+
+func timeToExpiry(message *azservicebus.ReceivedMessage, now time.Time) (time.Duration, bool) {
+	if message.ExpiresAt != nil {
+		return message.ExpiresAt.Sub(now), true
+	}
+	if message.EnqueuedTime != nil && message.TimeToLive != nil {
+		return message.EnqueuedTime.Add(*message.TimeToLive).Sub(now), true
+	}
+	return 0, false
+}
+
+// StaleMessagePolicy defines the messages too close to their expiry to be worth handling, for NewStaleMessageHandler.
+type StaleMessagePolicy struct {
+	// ExpiresWithin is the time the handler needs to finish. The messages expiring sooner are considered stale.
+	ExpiresWithin time.Duration
+	// DeadLetter dead-letters the stale messages instead of completing them.
+	DeadLetter bool
+	// Clock is used to compute the time to expiry. Defaults to the system clock.
+	Clock Clock
+}
+
+// DropExpiredWithin is the StaleMessagePolicy that completes, without handling them, the messages expiring within d.
+func DropExpiredWithin(d time.Duration) *StaleMessagePolicy {
+	return &StaleMessagePolicy{ExpiresWithin: d}
+}
+
+// NewStaleMessageHandler is a middleware that settles the messages that will expire before the handler could
+// plausibly finish, instead of handling them, for latency-sensitive pipelines where a late result is worthless.
+// The stale messages are counted in the message_stale_total metric.
+// The messages without known expiry are passed to next.
+// A nil policy only settles the messages which already expired.
+func NewStaleMessageHandler(policy *StaleMessagePolicy, next Handler) HandlerFunc {
+	opts := StaleMessagePolicy{}
+	if policy != nil {
+		opts = *policy
+	}
+	policy = &opts
+	clock := clockOrDefault(policy.Clock)
+	return func(ctx context.Context, settler MessageSettler, message *azservicebus.ReceivedMessage) {
+		remaining, ok := timeToExpiry(message, clock.Now())
+		if !ok || remaining > policy.ExpiresWithin {
+			next.Handle(ctx, settler, message)
+			return
+		}
+		processor.Metric.IncMessageStale(message)
+		if !policy.DeadLetter {
+			log(ctx, fmt.Sprintf("dropping message %s: expires in %s", message.MessageID, remaining))
+			if err := settler.CompleteMessage(ctx, message, nil); err != nil {
+				log(ctx, fmt.Sprintf("failed to complete message %s: %s", message.MessageID, err))
+			}
+			return
+		}
+		log(ctx, fmt.Sprintf("dead-lettering message %s: expires in %s", message.MessageID, remaining))
+		reason := "MessageStale"
+		description := fmt.Sprintf("message expires in %s, within %s", remaining, policy.ExpiresWithin)
+		if err := settler.DeadLetterMessage(ctx, message, &azservicebus.DeadLetterOptions{
+			Reason:           &reason,
+			ErrorDescription: &description,
+		}); err != nil {
+			log(ctx, fmt.Sprintf("failed to dead-letter message %s: %s", message.MessageID, err))
+		}
+	}
+}
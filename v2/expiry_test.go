@@ -0,0 +1,93 @@
+package shuttle_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	. "github.com/onsi/gomega"
+
+	"github.com/Azure/go-shuttle/v2"
+	"github.com/Azure/go-shuttle/v2/shuttletest"
+)
+
+func TestTimeToExpiry(t *testing.T) {
+	g := NewWithT(t)
+	_, ok := shuttle.TimeToExpiry(&azservicebus.ReceivedMessage{})
+	g.Expect(ok).To(BeFalse())
+
+	remaining, ok := shuttle.TimeToExpiry(&azservicebus.ReceivedMessage{ExpiresAt: to.Ptr(time.Now().Add(time.Hour))})
+	g.Expect(ok).To(BeTrue())
+	g.Expect(remaining).To(BeNumerically("~", time.Hour, time.Second))
+
+	remaining, ok = shuttle.TimeToExpiry(&azservicebus.ReceivedMessage{
+		EnqueuedTime: to.Ptr(time.Now().Add(-time.Hour)),
+		TimeToLive:   to.Ptr(30 * time.Minute),
+	})
+	g.Expect(ok).To(BeTrue())
+	g.Expect(remaining).To(BeNumerically("~", -30*time.Minute, time.Second))
+}
+
+func TestNewStaleMessageHandler(t *testing.T) {
+	now := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	testCases := []struct {
+		name             string
+		message          *azservicebus.ReceivedMessage
+		deadLetter       bool
+		expectHandled    bool
+		expectComplete   int32
+		expectDeadLetter int32
+	}{
+		{name: "unknown expiry", message: &azservicebus.ReceivedMessage{}, expectHandled: true},
+		{
+			name:          "expires later",
+			message:       &azservicebus.ReceivedMessage{ExpiresAt: to.Ptr(now.Add(time.Minute))},
+			expectHandled: true,
+		},
+		{
+			name:           "expires within is dropped",
+			message:        &azservicebus.ReceivedMessage{ExpiresAt: to.Ptr(now.Add(time.Second))},
+			expectComplete: 1,
+		},
+		{
+			name:             "expired is dead-lettered",
+			message:          &azservicebus.ReceivedMessage{ExpiresAt: to.Ptr(now.Add(-time.Second))},
+			deadLetter:       true,
+			expectDeadLetter: 1,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+			settler := &fakeSettler{}
+			handled := false
+			policy := shuttle.DropExpiredWithin(10 * time.Second)
+			policy.DeadLetter = tc.deadLetter
+			policy.Clock = shuttletest.NewFakeClock(now)
+			handler := shuttle.NewStaleMessageHandler(policy, shuttle.HandlerFunc(
+				func(ctx context.Context, settler shuttle.MessageSettler, message *azservicebus.ReceivedMessage) {
+					handled = true
+				}))
+			handler(context.Background(), settler, tc.message)
+			g.Expect(handled).To(Equal(tc.expectHandled))
+			g.Expect(settler.CompleteCalled.Load()).To(Equal(tc.expectComplete))
+			g.Expect(settler.DeadLetterCalled.Load()).To(Equal(tc.expectDeadLetter))
+		})
+	}
+}
+
+func TestNewStaleMessageHandler_NilPolicy(t *testing.T) {
+	g := NewWithT(t)
+	handled := 0
+	handler := shuttle.NewStaleMessageHandler(nil, shuttle.HandlerFunc(
+		func(ctx context.Context, settler shuttle.MessageSettler, message *azservicebus.ReceivedMessage) {
+			handled++
+		}))
+	settler := &fakeSettler{}
+	handler(context.Background(), settler, &azservicebus.ReceivedMessage{ExpiresAt: to.Ptr(time.Now().Add(time.Minute))})
+	handler(context.Background(), settler, &azservicebus.ReceivedMessage{ExpiresAt: to.Ptr(time.Now().Add(-time.Minute))})
+	g.Expect(handled).To(Equal(1))
+	g.Expect(settler.CompleteCalled.Load()).To(Equal(int32(1)))
+}
@@ -0,0 +1,48 @@
+package shuttle
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+)
+
+// ForwardTransformFunc converts a received message into the body of the message to publish.
+// Returning a nil MessageBody and a nil error filters out the message: it is completed without being forwarded.
+type ForwardTransformFunc func(ctx context.Context, message *azservicebus.ReceivedMessage) (MessageBody, error)
+
+// ForwardingHandlerOptions configures the forwarding handler.
+type ForwardingHandlerOptions struct {
+	// MessageOptions are applied to each forwarded message, for example to set the correlation id.
+	MessageOptions []func(msg *azservicebus.Message) error
+	// ManagedSettlingOptions configures how the received message is settled based on the forwarding outcome.
+	// Defaults to the ManagedSettlingHandler defaults.
+	ManagedSettlingOptions *ManagedSettlingOptions
+}
+
+// NewForwardingHandler creates a handler that bridges two entities: it transforms each received message,
+// publishes the result with the sender, then settles the received message based on the outcome.
+// the received message is completed once the forwarded message is sent.
+// transformation and publish errors are handled by the ManagedSettlingHandler (abandon or dead-letter).
+// Note: the message is forwarded at least once. The azservicebus SDK does not support transactions,
+// so a failure to complete the received message after a successful publish results in a duplicate.
+func NewForwardingHandler(sender *Sender, transform ForwardTransformFunc, options *ForwardingHandlerOptions) *ManagedSettler {
+	if options == nil {
+		options = &ForwardingHandlerOptions{}
+	}
+	return NewManagedSettlingHandler(options.ManagedSettlingOptions,
+		ManagedSettlingFunc(func(ctx context.Context, message *azservicebus.ReceivedMessage) error {
+			body, err := transform(ctx, message)
+			if err != nil {
+				return fmt.Errorf("failed to transform message for forwarding: %w", err)
+			}
+			if body == nil {
+				log(ctx, "transform returned nil body. message is not forwarded")
+				return nil
+			}
+			if err := sender.SendMessage(ctx, body, options.MessageOptions...); err != nil {
+				return fmt.Errorf("failed to forward message: %w", err)
+			}
+			return nil
+		}))
+}
@@ -0,0 +1,76 @@
+package shuttle
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	. "github.com/onsi/gomega"
+)
+
+func upperTransform(_ context.Context, message *azservicebus.ReceivedMessage) (MessageBody, error) {
+	return fmt.Sprintf("%s!", message.Body), nil
+}
+
+func TestForwardingHandler_ForwardsAndCompletes(t *testing.T) {
+	g := NewWithT(t)
+	azSender := &fakeAzSender{}
+	sender := NewSender(azSender, nil)
+	settler := &fakeSettler{}
+	h := NewForwardingHandler(sender, upperTransform, &ForwardingHandlerOptions{
+		MessageOptions: []func(msg *azservicebus.Message) error{SetCorrelationId(to.Ptr("corr"))},
+	})
+	h.Handle(context.Background(), settler, &azservicebus.ReceivedMessage{Body: []byte("hello")})
+	g.Expect(azSender.SendMessageCalled).To(BeTrue())
+	g.Expect(string(azSender.SendMessageReceivedValue.Body)).To(Equal("\"hello!\""))
+	g.Expect(*azSender.SendMessageReceivedValue.CorrelationID).To(Equal("corr"))
+	g.Expect(settler.completed).To(BeTrue())
+}
+
+func TestForwardingHandler_FilteredMessageIsCompleted(t *testing.T) {
+	g := NewWithT(t)
+	azSender := &fakeAzSender{}
+	settler := &fakeSettler{}
+	h := NewForwardingHandler(NewSender(azSender, nil),
+		func(_ context.Context, _ *azservicebus.ReceivedMessage) (MessageBody, error) { return nil, nil }, nil)
+	h.Handle(context.Background(), settler, &azservicebus.ReceivedMessage{})
+	g.Expect(azSender.SendMessageCalled).To(BeFalse())
+	g.Expect(settler.completed).To(BeTrue())
+}
+
+func TestForwardingHandler_PublishFailureAbandons(t *testing.T) {
+	g := NewWithT(t)
+	azSender := &fakeAzSender{SendMessageErr: fmt.Errorf("send failure")}
+	settler := &fakeSettler{}
+	var handleErr error
+	h := NewForwardingHandler(NewSender(azSender, nil), upperTransform, &ForwardingHandlerOptions{
+		ManagedSettlingOptions: &ManagedSettlingOptions{
+			RetryDelayStrategy: &ConstantDelayStrategy{Delay: time.Millisecond},
+			OnAbandoned: func(_ context.Context, _ *azservicebus.ReceivedMessage, err error) {
+				handleErr = err
+			},
+		},
+	})
+	h.Handle(context.Background(), settler, &azservicebus.ReceivedMessage{})
+	g.Expect(settler.completed).To(BeFalse())
+	g.Expect(settler.abandoned).To(BeTrue())
+	g.Expect(handleErr).To(MatchError(azSender.SendMessageErr))
+}
+
+func TestForwardingHandler_TransformFailureIsHandled(t *testing.T) {
+	g := NewWithT(t)
+	azSender := &fakeAzSender{}
+	settler := &fakeSettler{}
+	h := NewForwardingHandler(NewSender(azSender, nil),
+		func(_ context.Context, _ *azservicebus.ReceivedMessage) (MessageBody, error) {
+			return nil, fmt.Errorf("transform failure")
+		}, &ForwardingHandlerOptions{
+			ManagedSettlingOptions: &ManagedSettlingOptions{RetryDecision: &MaxAttemptsRetryDecision{MaxAttempts: 0}},
+		})
+	h.Handle(context.Background(), settler, &azservicebus.ReceivedMessage{})
+	g.Expect(azSender.SendMessageCalled).To(BeFalse())
+	g.Expect(settler.deadlettered).To(BeTrue())
+}
@@ -0,0 +1,117 @@
+package shuttle
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+)
+
+const defaultMaxAutoLockRenewalDuration = 5 * time.Minute
+
+// FunctionsOptions configures NewFunctionsHandler like the host.json settings of the Azure Functions Service Bus trigger.
+type FunctionsOptions struct {
+	// DisableAutoComplete leaves the settlement of the messages to the handler, like autoCompleteMessages set to false.
+	// The messages the handler does not settle are redelivered once their lock expires.
+	DisableAutoComplete bool
+	// MaxAutoLockRenewalDuration is the maximum duration the lock of a message is renewed for, like maxAutoLockRenewalDuration.
+	// Defaults to 5 minutes. A negative value disables the lock renewal.
+	MaxAutoLockRenewalDuration time.Duration
+	// LockRenewalInterval is the interval between two lock renewals. Defaults to 10 seconds.
+	LockRenewalInterval *time.Duration
+}
+
+// FunctionsHandlerFunc has the shape of a Service Bus triggered function: it receives the message,
+// and the actions to settle it when the auto-completion is disabled.
+type FunctionsHandlerFunc func(ctx context.Context, message *azservicebus.ReceivedMessage, actions MessageSettler) error
+
+// NewFunctionsHandler mimics the settlement of the Azure Functions Service Bus trigger, to ease the migration of functions:
+//   - the message is completed when the handler returns nil, and abandoned when it returns an error or panics.
+//     The broker dead-letters it once its MaxDeliveryCount is reached, without retry delay.
+//   - the message is not settled again when the handler settled it with the actions, or when DisableAutoComplete is set.
+//   - the lock is renewed while the handler runs, for up to MaxAutoLockRenewalDuration. The context of the handler is not
+//     canceled when the renewal stops.
+//
+// Use NewManagedSettlingHandler instead for retry delays and dead-lettering decided by the consumer.
+func NewFunctionsHandler(lockRenewer LockRenewer, options *FunctionsOptions, handler FunctionsHandlerFunc) HandlerFunc {
+	opts := FunctionsOptions{}
+	if options != nil {
+		opts = *options
+	}
+	if opts.MaxAutoLockRenewalDuration == 0 {
+		opts.MaxAutoLockRenewalDuration = defaultMaxAutoLockRenewalDuration
+	}
+	settling := HandlerFunc(func(ctx context.Context, settler MessageSettler, message *azservicebus.ReceivedMessage) {
+		actions := &functionsActions{MessageSettler: settler}
+		err := callFunction(ctx, handler, message, actions)
+		if opts.DisableAutoComplete || actions.settled.Load() {
+			if err != nil {
+				log(ctx, fmt.Sprintf("function failed: %s", err))
+			}
+			return
+		}
+		if err != nil {
+			log(ctx, fmt.Sprintf("function failed, abandoning message: %s", err))
+			abandonSettlement.settle(ctx, settler, message, nil)
+			return
+		}
+		if err := settler.CompleteMessage(ctx, message, nil); err != nil {
+			log(ctx, fmt.Sprintf("failed to complete message: %s", err))
+		}
+	})
+	if opts.MaxAutoLockRenewalDuration < 0 {
+		return settling
+	}
+	return NewLockRenewalHandler(lockRenewer, &LockRenewalOptions{
+		Interval:                   opts.LockRenewalInterval,
+		CancelMessageContextOnStop: to.Ptr(false),
+		MaxRenewalDuration:         opts.MaxAutoLockRenewalDuration,
+	}, settling)
+}
+
+// callFunction calls the handler, returning its panic as an error like the functions host does with exceptions.
+func callFunction(ctx context.Context, handler FunctionsHandlerFunc, message *azservicebus.ReceivedMessage, actions MessageSettler) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("function panicked: %v", r)
+		}
+	}()
+	return handler(ctx, message, actions)
+}
+
+// functionsActions records whether the handler settled the message.
+type functionsActions struct {
+	MessageSettler
+	settled atomic.Bool
+}
+
+// Unwrap returns the wrapped MessageSettler, for UnwrapSettler.
+func (a *functionsActions) Unwrap() MessageSettler {
+	return a.MessageSettler
+}
+
+func (a *functionsActions) record(err error) error {
+	if err == nil {
+		a.settled.Store(true)
+	}
+	return err
+}
+
+func (a *functionsActions) CompleteMessage(ctx context.Context, message *azservicebus.ReceivedMessage, options *azservicebus.CompleteMessageOptions) error {
+	return a.record(a.MessageSettler.CompleteMessage(ctx, message, options))
+}
+
+func (a *functionsActions) AbandonMessage(ctx context.Context, message *azservicebus.ReceivedMessage, options *azservicebus.AbandonMessageOptions) error {
+	return a.record(a.MessageSettler.AbandonMessage(ctx, message, options))
+}
+
+func (a *functionsActions) DeadLetterMessage(ctx context.Context, message *azservicebus.ReceivedMessage, options *azservicebus.DeadLetterOptions) error {
+	return a.record(a.MessageSettler.DeadLetterMessage(ctx, message, options))
+}
+
+func (a *functionsActions) DeferMessage(ctx context.Context, message *azservicebus.ReceivedMessage, options *azservicebus.DeferMessageOptions) error {
+	return a.record(a.MessageSettler.DeferMessage(ctx, message, options))
+}
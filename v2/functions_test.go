@@ -0,0 +1,103 @@
+package shuttle_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	. "github.com/onsi/gomega"
+
+	"github.com/Azure/go-shuttle/v2"
+)
+
+func TestFunctionsHandler_Settlement(t *testing.T) {
+	testCases := []struct {
+		name             string
+		options          *shuttle.FunctionsOptions
+		handler          shuttle.FunctionsHandlerFunc
+		expectComplete   int32
+		expectAbandon    int32
+		expectDeadLetter int32
+	}{
+		{
+			name: "completes on success",
+			handler: func(ctx context.Context, message *azservicebus.ReceivedMessage, actions shuttle.MessageSettler) error {
+				return nil
+			},
+			expectComplete: 1,
+		},
+		{
+			name: "abandons on error",
+			handler: func(ctx context.Context, message *azservicebus.ReceivedMessage, actions shuttle.MessageSettler) error {
+				return errors.New("failed")
+			},
+			expectAbandon: 1,
+		},
+		{
+			name: "abandons on panic",
+			handler: func(ctx context.Context, message *azservicebus.ReceivedMessage, actions shuttle.MessageSettler) error {
+				panic("boom")
+			},
+			expectAbandon: 1,
+		},
+		{
+			name: "does not settle a message settled by the handler",
+			handler: func(ctx context.Context, message *azservicebus.ReceivedMessage, actions shuttle.MessageSettler) error {
+				return actions.DeadLetterMessage(ctx, message, nil)
+			},
+			expectDeadLetter: 1,
+		},
+		{
+			name:    "does not settle when auto complete is disabled",
+			options: &shuttle.FunctionsOptions{DisableAutoComplete: true},
+			handler: func(ctx context.Context, message *azservicebus.ReceivedMessage, actions shuttle.MessageSettler) error {
+				return errors.New("failed")
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+			settler := &fakeSettler{}
+			handler := shuttle.NewFunctionsHandler(&fakeSBLockRenewer{}, tc.options, tc.handler)
+			handler.Handle(context.Background(), settler, &azservicebus.ReceivedMessage{})
+			g.Expect(settler.CompleteCalled.Load()).To(Equal(tc.expectComplete))
+			g.Expect(settler.AbandonCalled.Load()).To(Equal(tc.expectAbandon))
+			g.Expect(settler.DeadLetterCalled.Load()).To(Equal(tc.expectDeadLetter))
+		})
+	}
+}
+
+func TestFunctionsHandler_MaxAutoLockRenewalDuration(t *testing.T) {
+	g := NewWithT(t)
+	renewer := &fakeSBLockRenewer{}
+	interval := 20 * time.Millisecond
+	handler := shuttle.NewFunctionsHandler(renewer, &shuttle.FunctionsOptions{
+		MaxAutoLockRenewalDuration: 50 * time.Millisecond,
+		LockRenewalInterval:        &interval,
+	}, func(ctx context.Context, message *azservicebus.ReceivedMessage, actions shuttle.MessageSettler) error {
+		time.Sleep(150 * time.Millisecond)
+		return ctx.Err()
+	})
+	settler := &fakeSettler{}
+	handler.Handle(context.Background(), settler, &azservicebus.ReceivedMessage{})
+	g.Expect(renewer.RenewCount.Load()).To(Equal(int32(2)))
+	g.Expect(settler.CompleteCalled.Load()).To(Equal(int32(1)), "the handler context is not canceled when the renewal stops")
+}
+
+func TestFunctionsHandler_LockRenewalDisabled(t *testing.T) {
+	g := NewWithT(t)
+	renewer := &fakeSBLockRenewer{}
+	interval := 10 * time.Millisecond
+	handler := shuttle.NewFunctionsHandler(renewer, &shuttle.FunctionsOptions{
+		MaxAutoLockRenewalDuration: -1,
+		LockRenewalInterval:        &interval,
+	}, func(ctx context.Context, message *azservicebus.ReceivedMessage, actions shuttle.MessageSettler) error {
+		time.Sleep(50 * time.Millisecond)
+		return nil
+	})
+	handler.Handle(context.Background(), &fakeSettler{}, &azservicebus.ReceivedMessage{})
+	g.Expect(renewer.RenewCount.Load()).To(Equal(int32(0)))
+}
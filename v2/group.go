@@ -0,0 +1,211 @@
+package shuttle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultGroupDrainTimeout = 30 * time.Second
+	groupDrainPollInterval   = 10 * time.Millisecond
+)
+
+// GroupOptions configures the Group.
+type GroupOptions struct {
+	// DrainTimeout is the time given to each component to stop, when not set on the component. Defaults to 30 seconds.
+	DrainTimeout time.Duration
+}
+
+// GroupComponent is a component of a Group.
+type GroupComponent struct {
+	// Name identifies the component in the logs and errors.
+	Name string
+	// Run runs the component until ctx is canceled. Optional.
+	Run func(ctx context.Context) error
+	// Stop drains the component, once its Run returned. ctx is done after the drain timeout. Optional.
+	Stop func(ctx context.Context) error
+	// DrainTimeout overrides GroupOptions.DrainTimeout for this component.
+	DrainTimeout time.Duration
+}
+
+// ComponentError is the error of a component of a Group.
+type ComponentError struct {
+	Name string
+	Err  error
+}
+
+func (e *ComponentError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Name, e.Err)
+}
+
+func (e *ComponentError) Unwrap() error {
+	return e.Err
+}
+
+// GroupError aggregates the errors of the components of a Group.
+type GroupError struct {
+	Errors []*ComponentError
+}
+
+func (e *GroupError) Error() string {
+	messages := make([]string, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		messages = append(messages, err.Error())
+	}
+	return "group failed: " + strings.Join(messages, "; ")
+}
+
+func (e *GroupError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// Group manages the lifecycle of the processors and senders of a service.
+// The components are added in dependency order: a processor publishing with a sender is added after the sender.
+// Run starts the components in that order, and shuts them down in reverse order, so that the processors stop
+// handling messages before the senders they use are closed.
+type Group struct {
+	options    GroupOptions
+	components []GroupComponent
+}
+
+// NewGroup creates an empty Group.
+func NewGroup(options *GroupOptions) *Group {
+	opts := GroupOptions{}
+	if options != nil {
+		opts = *options
+	}
+	if opts.DrainTimeout <= 0 {
+		opts.DrainTimeout = defaultGroupDrainTimeout
+	}
+	return &Group{options: opts}
+}
+
+// Add adds the component to the group.
+func (g *Group) Add(component GroupComponent) *Group {
+	g.components = append(g.components, component)
+	return g
+}
+
+// AddProcessor adds the processor to the group.
+// On shutdown, the processor stops receiving and the contexts of the messages being handled are canceled,
+// then the group waits up to drainTimeout for the handlers to return.
+// drainTimeout defaults to GroupOptions.DrainTimeout when 0.
+func (g *Group) AddProcessor(name string, processor *Processor, drainTimeout time.Duration) *Group {
+	return g.Add(GroupComponent{
+		Name:         name,
+		Run:          processor.Start,
+		Stop:         processor.waitForInFlight,
+		DrainTimeout: drainTimeout,
+	})
+}
+
+// AddSender adds the sender to the group. On shutdown, the sender is closed, waiting up to drainTimeout for the in-flight sends.
+// drainTimeout defaults to GroupOptions.DrainTimeout when 0.
+func (g *Group) AddSender(name string, sender *Sender, drainTimeout time.Duration) *Group {
+	return g.Add(GroupComponent{
+		Name:         name,
+		Stop:         sender.Close,
+		DrainTimeout: drainTimeout,
+	})
+}
+
+type runningComponent struct {
+	GroupComponent
+	cancel context.CancelFunc
+	done   chan struct{}
+	err    error
+}
+
+// Run starts the components and blocks until ctx is done or a component fails, then shuts them down in reverse order.
+// Use signal.NotifyContext to shut down on SIGTERM.
+// It returns a *GroupError with the errors of the components, or nil when they all stopped cleanly.
+func (g *Group) Run(ctx context.Context) error {
+	failed := make(chan struct{})
+	var failOnce sync.Once
+	running := make([]*runningComponent, 0, len(g.components))
+	for _, component := range g.components {
+		// the components are detached from ctx, to be stopped one by one when it is done.
+		runCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		c := &runningComponent{GroupComponent: component, cancel: cancel, done: make(chan struct{})}
+		running = append(running, c)
+		if c.Run == nil {
+			close(c.done)
+			continue
+		}
+		log(ctx, fmt.Sprintf("starting %s", c.Name))
+		go func() {
+			defer close(c.done)
+			err := c.Run(runCtx)
+			if runCtx.Err() == nil {
+				// the component stopped on its own, shut down the group
+				if err == nil {
+					err = errors.New("stopped unexpectedly")
+				}
+				c.err = err
+				failOnce.Do(func() { close(failed) })
+			}
+		}()
+	}
+
+	select {
+	case <-ctx.Done():
+		log(ctx, "shutting down group")
+	case <-failed:
+		log(ctx, "component failed, shutting down group")
+	}
+
+	groupErr := &GroupError{}
+	for i := len(running) - 1; i >= 0; i-- {
+		if err := g.stop(ctx, running[i]); err != nil {
+			groupErr.Errors = append(groupErr.Errors, &ComponentError{Name: running[i].Name, Err: err})
+		}
+	}
+	if len(groupErr.Errors) > 0 {
+		return groupErr
+	}
+	return nil
+}
+
+// stop cancels the component and waits for it to drain within its drain timeout.
+func (g *Group) stop(ctx context.Context, c *runningComponent) error {
+	drainTimeout := c.DrainTimeout
+	if drainTimeout <= 0 {
+		drainTimeout = g.options.DrainTimeout
+	}
+	drainCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), drainTimeout)
+	defer cancel()
+	log(ctx, fmt.Sprintf("stopping %s", c.Name))
+	c.cancel()
+	select {
+	case <-c.done:
+	case <-drainCtx.Done():
+		return fmt.Errorf("failed to stop within %s: %w", drainTimeout, drainCtx.Err())
+	}
+	err := c.err
+	if c.Stop != nil {
+		if stopErr := c.Stop(drainCtx); stopErr != nil && err == nil {
+			err = fmt.Errorf("failed to drain: %w", stopErr)
+		}
+	}
+	return err
+}
+
+// waitForInFlight waits for the messages being handled to be settled, or for ctx to be done.
+func (p *Processor) waitForInFlight(ctx context.Context) error {
+	for p.stats.inFlight.Load() > 0 {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%d messages still in flight: %w", p.stats.inFlight.Load(), ctx.Err())
+		case <-time.After(groupDrainPollInterval):
+		}
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package shuttle_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	. "github.com/onsi/gomega"
+
+	"github.com/Azure/go-shuttle/v2"
+	"github.com/Azure/go-shuttle/v2/shuttletest"
+)
+
+type lifecycleRecorder struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (r *lifecycleRecorder) record(event string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, event)
+}
+
+func (r *lifecycleRecorder) Events() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.events...)
+}
+
+func (r *lifecycleRecorder) component(name string) shuttle.GroupComponent {
+	return shuttle.GroupComponent{
+		Name: name,
+		Run: func(ctx context.Context) error {
+			r.record("start " + name)
+			<-ctx.Done()
+			return ctx.Err()
+		},
+		Stop: func(ctx context.Context) error {
+			r.record("stop " + name)
+			return nil
+		},
+	}
+}
+
+func TestGroup_StopsInReverseOrder(t *testing.T) {
+	g := NewWithT(t)
+	recorder := &lifecycleRecorder{}
+	group := shuttle.NewGroup(nil).
+		Add(recorder.component("sender")).
+		Add(recorder.component("processor"))
+	ctx, cancel := context.WithCancel(context.Background())
+	runErr := make(chan error, 1)
+	go func() { runErr <- group.Run(ctx) }()
+	g.Eventually(recorder.Events).Should(ConsistOf("start sender", "start processor"))
+	cancel()
+	g.Eventually(runErr).Should(Receive(BeNil()))
+	g.Expect(recorder.Events()[2:]).To(Equal([]string{"stop processor", "stop sender"}))
+}
+
+func TestGroup_ShutsDownWhenAComponentFails(t *testing.T) {
+	g := NewWithT(t)
+	recorder := &lifecycleRecorder{}
+	failure := errors.New("connection lost")
+	group := shuttle.NewGroup(nil).
+		Add(recorder.component("sender")).
+		Add(shuttle.GroupComponent{
+			Name: "processor",
+			Run:  func(ctx context.Context) error { return failure },
+		})
+	err := group.Run(context.Background())
+	g.Expect(err).To(HaveOccurred())
+	g.Expect(errors.Is(err, failure)).To(BeTrue())
+	var groupErr *shuttle.GroupError
+	g.Expect(errors.As(err, &groupErr)).To(BeTrue())
+	g.Expect(groupErr.Errors).To(HaveLen(1))
+	g.Expect(groupErr.Errors[0].Name).To(Equal("processor"))
+	g.Expect(recorder.Events()).To(ContainElement("stop sender"))
+}
+
+func TestGroup_DrainTimeout(t *testing.T) {
+	g := NewWithT(t)
+	group := shuttle.NewGroup(&shuttle.GroupOptions{DrainTimeout: time.Second}).
+		Add(shuttle.GroupComponent{
+			Name: "stuck",
+			Run: func(ctx context.Context) error {
+				<-ctx.Done()
+				return ctx.Err()
+			},
+			Stop: func(ctx context.Context) error {
+				<-ctx.Done()
+				return ctx.Err()
+			},
+			DrainTimeout: 10 * time.Millisecond,
+		})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := group.Run(ctx)
+	g.Expect(err).To(HaveOccurred())
+	g.Expect(errors.Is(err, context.DeadlineExceeded)).To(BeTrue())
+	g.Expect(err.Error()).To(ContainSubstring("stuck"))
+}
+
+func TestGroup_AddProcessorWaitsForHandlers(t *testing.T) {
+	g := NewWithT(t)
+	settler := &fakeSettler{}
+	rcv := shuttle.NewSourceReceiver(shuttletest.NewInMemorySource(&azservicebus.ReceivedMessage{}), settler)
+	handling := make(chan struct{})
+	var handled sync.WaitGroup
+	handled.Add(1)
+	processor := shuttle.NewProcessor(rcv,
+		func(ctx context.Context, settler shuttle.MessageSettler, message *azservicebus.ReceivedMessage) {
+			defer handled.Done()
+			close(handling)
+			<-ctx.Done()
+			// simulate the settlement of the message after the cancellation
+			time.Sleep(50 * time.Millisecond)
+			_ = settler.AbandonMessage(context.Background(), message, nil)
+		}, &shuttle.ProcessorOptions{MaxConcurrency: 1, ReceiveInterval: to.Ptr(time.Millisecond)})
+	group := shuttle.NewGroup(nil).AddProcessor("processor", processor, time.Second)
+	ctx, cancel := context.WithCancel(context.Background())
+	runErr := make(chan error, 1)
+	go func() { runErr <- group.Run(ctx) }()
+	g.Eventually(handling).Should(BeClosed())
+	cancel()
+	g.Eventually(runErr, 2*time.Second).Should(Receive(BeNil()))
+	g.Expect(settler.AbandonCalled.Load()).To(Equal(int32(1)))
+	handled.Wait()
+}
@@ -0,0 +1,248 @@
+// Package grpcgateway bridges a Processor to a server-streaming gRPC endpoint, so that thin downstream consumers
+// that cannot speak AMQP receive the messages of a queue or subscription:
+//
+//	gateway := grpcgateway.NewGateway(nil)
+//	server := grpc.NewServer()
+//	gateway.Register(server)
+//	processor := shuttle.NewProcessor(receiver,
+//		shuttle.NewLockRenewalHandler(receiver, nil, gateway.Handle), &shuttle.ProcessorOptions{MaxConcurrency: 10})
+//
+// The clients call Subscribe to receive the messages, and Ack to settle each of them.
+// Each message is pushed to one of the subscribed clients. It is abandoned when the client disconnects
+// or does not acknowledge it within the AckTimeout, to be redelivered.
+package grpcgateway
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/Azure/go-shuttle/v2"
+	"github.com/Azure/go-shuttle/v2/grpcgateway/gatewaypb"
+)
+
+const defaultAckTimeout = time.Minute
+
+// GatewayOptions configures the Gateway.
+type GatewayOptions struct {
+	// AckTimeout is the duration a client has to acknowledge a message before it is abandoned. Defaults to 1 minute.
+	// Wrap the gateway in a lock renewal handler when it exceeds the lock duration of the entity.
+	AckTimeout time.Duration
+	// OnError is called with the errors of the abandons of the messages that are not acknowledged. Optional.
+	// The errors of the settlements requested by the clients are returned to them by Ack.
+	OnError func(ctx context.Context, message *azservicebus.ReceivedMessage, err error)
+}
+
+// Gateway is a shuttle handler pushing the messages to the clients subscribed to its gRPC Gateway service,
+// and settling them when the clients acknowledge them.
+type Gateway struct {
+	gatewaypb.UnimplementedGatewayServer
+	options    GatewayOptions
+	deliveries chan *delivery
+
+	mu       sync.Mutex
+	inFlight map[string]*delivery
+}
+
+// delivery is a message pushed to a client, waiting for its acknowledgement.
+type delivery struct {
+	id      string
+	message *azservicebus.ReceivedMessage
+	settler shuttle.MessageSettler
+	// subscription identifies the Subscribe call the message was pushed to, empty until a client receives it.
+	// It is guarded by the mutex of the Gateway.
+	subscription string
+	// lost is closed when the client disconnects before acknowledging the message.
+	lost chan struct{}
+	// acked is closed once the message is settled by Ack.
+	acked chan struct{}
+}
+
+// NewGateway creates a Gateway. Register it on a gRPC server, and use its Handle method as the handler of the processor.
+func NewGateway(options *GatewayOptions) *Gateway {
+	opts := GatewayOptions{}
+	if options != nil {
+		opts = *options
+	}
+	if opts.AckTimeout <= 0 {
+		opts.AckTimeout = defaultAckTimeout
+	}
+	return &Gateway{
+		options:    opts,
+		deliveries: make(chan *delivery),
+		inFlight:   map[string]*delivery{},
+	}
+}
+
+// Register registers the Gateway as the Gateway service of the gRPC server.
+func (g *Gateway) Register(server *grpc.Server) {
+	gatewaypb.RegisterGatewayServer(server, g)
+}
+
+// Handle pushes the message to a subscribed client, and waits for the client to acknowledge it.
+// The message is abandoned when the context is done before a client receives it, when the client disconnects,
+// or when the AckTimeout elapses.
+func (g *Gateway) Handle(ctx context.Context, settler shuttle.MessageSettler, message *azservicebus.ReceivedMessage) {
+	d := &delivery{
+		id:      uuid.NewString(),
+		message: message,
+		settler: settler,
+		lost:    make(chan struct{}),
+		acked:   make(chan struct{}),
+	}
+	// registered before the handoff, so that the delivery is in flight whenever Subscribe or Ack can see it,
+	// and claim only fails when Ack claimed it
+	g.mu.Lock()
+	g.inFlight[d.id] = d
+	g.mu.Unlock()
+	select {
+	case g.deliveries <- d:
+	case <-ctx.Done():
+		// no client received the message
+		g.claim(d.id)
+		g.abandon(ctx, d)
+		return
+	}
+	timer := time.NewTimer(g.options.AckTimeout)
+	defer timer.Stop()
+	select {
+	case <-d.acked:
+		return
+	case <-d.lost:
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+	if !g.claim(d.id) {
+		// the client is acknowledging the message
+		<-d.acked
+		return
+	}
+	g.abandon(ctx, d)
+}
+
+// abandon abandons the message of the delivery to be redelivered, and reports the failures to OnError.
+func (g *Gateway) abandon(ctx context.Context, d *delivery) {
+	if err := d.settler.AbandonMessage(context.WithoutCancel(ctx), d.message, nil); err != nil && g.options.OnError != nil {
+		g.options.OnError(ctx, d.message, fmt.Errorf("failed to abandon message %s: %w", d.message.MessageID, err))
+	}
+}
+
+// Subscribe streams the messages to the client until it disconnects.
+func (g *Gateway) Subscribe(_ *gatewaypb.SubscribeRequest, stream gatewaypb.Gateway_SubscribeServer) error {
+	ctx := stream.Context()
+	subscription := uuid.NewString()
+	defer g.disconnect(subscription)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case d := <-g.deliveries:
+			g.mu.Lock()
+			d.subscription = subscription
+			g.mu.Unlock()
+			if err := stream.Send(toMessage(d)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Ack settles the message as requested by the client.
+// It returns NotFound when the message was already settled, or abandoned after the AckTimeout.
+func (g *Gateway) Ack(ctx context.Context, req *gatewaypb.AckRequest) (*gatewaypb.AckResponse, error) {
+	g.mu.Lock()
+	d, ok := g.inFlight[req.GetDeliveryId()]
+	if ok {
+		delete(g.inFlight, d.id)
+	}
+	g.mu.Unlock()
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "delivery %s not found", req.GetDeliveryId())
+	}
+	defer close(d.acked)
+	if err := settle(ctx, d, req); err != nil {
+		return nil, status.Errorf(codes.Unavailable, "failed to settle message %s: %s", d.message.MessageID, err)
+	}
+	return &gatewaypb.AckResponse{}, nil
+}
+
+// claim removes the delivery from the in-flight deliveries. It returns false when Ack already claimed it.
+func (g *Gateway) claim(id string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if _, ok := g.inFlight[id]; !ok {
+		return false
+	}
+	delete(g.inFlight, id)
+	return true
+}
+
+// disconnect signals the loss of the deliveries of the subscription, for their handlers to abandon them.
+func (g *Gateway) disconnect(subscription string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, d := range g.inFlight {
+		if d.subscription == subscription {
+			close(d.lost)
+		}
+	}
+}
+
+func settle(ctx context.Context, d *delivery, req *gatewaypb.AckRequest) error {
+	switch req.GetSettlement() {
+	case gatewaypb.Settlement_COMPLETE:
+		return d.settler.CompleteMessage(ctx, d.message, nil)
+	case gatewaypb.Settlement_ABANDON:
+		return d.settler.AbandonMessage(ctx, d.message, nil)
+	case gatewaypb.Settlement_DEAD_LETTER:
+		return d.settler.DeadLetterMessage(ctx, d.message, &azservicebus.DeadLetterOptions{
+			Reason:           optional(req.GetDeadLetterReason()),
+			ErrorDescription: optional(req.GetDeadLetterDescription()),
+		})
+	default:
+		return fmt.Errorf("unknown settlement %s", req.GetSettlement())
+	}
+}
+
+func toMessage(d *delivery) *gatewaypb.Message {
+	msg := d.message
+	m := &gatewaypb.Message{
+		DeliveryId:    d.id,
+		MessageId:     msg.MessageID,
+		Body:          msg.Body,
+		DeliveryCount: msg.DeliveryCount,
+	}
+	if msg.ContentType != nil {
+		m.ContentType = *msg.ContentType
+	}
+	if msg.CorrelationID != nil {
+		m.CorrelationId = *msg.CorrelationID
+	}
+	if msg.Subject != nil {
+		m.Subject = *msg.Subject
+	}
+	if msg.SequenceNumber != nil {
+		m.SequenceNumber = *msg.SequenceNumber
+	}
+	if len(msg.ApplicationProperties) > 0 {
+		m.ApplicationProperties = make(map[string]string, len(msg.ApplicationProperties))
+		for key, value := range msg.ApplicationProperties {
+			m.ApplicationProperties[key] = fmt.Sprint(value)
+		}
+	}
+	return m
+}
+
+func optional(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
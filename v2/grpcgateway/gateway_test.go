@@ -0,0 +1,191 @@
+package grpcgateway_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	. "github.com/onsi/gomega"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/Azure/go-shuttle/v2/grpcgateway"
+	"github.com/Azure/go-shuttle/v2/grpcgateway/gatewaypb"
+	"github.com/Azure/go-shuttle/v2/shuttletest"
+)
+
+// serve serves the gateway on an in-memory listener and returns a client connected to it.
+func serve(t *testing.T, gateway *grpcgateway.Gateway) gatewaypb.GatewayClient {
+	listener := bufconn.Listen(1024 * 1024)
+	server := grpc.NewServer()
+	gateway.Register(server)
+	go func() { _ = server.Serve(listener) }()
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return gatewaypb.NewGatewayClient(conn)
+}
+
+func TestGateway_PushesAndSettlesOnAck(t *testing.T) {
+	g := NewWithT(t)
+	gateway := grpcgateway.NewGateway(nil)
+	client := serve(t, gateway)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream, err := client.Subscribe(ctx, &gatewaypb.SubscribeRequest{})
+	g.Expect(err).ToNot(HaveOccurred())
+
+	settler := shuttletest.NewRecordingSettler()
+	orders := []*azservicebus.ReceivedMessage{
+		{
+			MessageID:             "order-1",
+			Body:                  []byte(`{"id":1}`),
+			ContentType:           to.Ptr("application/json"),
+			ApplicationProperties: map[string]any{"type": "OrderPlaced", "version": 2},
+			SequenceNumber:        to.Ptr(int64(7)),
+			DeliveryCount:         1,
+		},
+		{MessageID: "order-2"},
+	}
+	handled := make(chan struct{})
+	go func() {
+		defer close(handled)
+		for _, msg := range orders {
+			gateway.Handle(ctx, settler, msg)
+		}
+	}()
+
+	msg, err := stream.Recv()
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(msg.MessageId).To(Equal("order-1"))
+	g.Expect(msg.Body).To(Equal([]byte(`{"id":1}`)))
+	g.Expect(msg.ContentType).To(Equal("application/json"))
+	g.Expect(msg.ApplicationProperties).To(Equal(map[string]string{"type": "OrderPlaced", "version": "2"}))
+	g.Expect(msg.SequenceNumber).To(Equal(int64(7)))
+	g.Expect(msg.DeliveryCount).To(Equal(uint32(1)))
+	_, err = client.Ack(ctx, &gatewaypb.AckRequest{DeliveryId: msg.DeliveryId, Settlement: gatewaypb.Settlement_COMPLETE})
+	g.Expect(err).ToNot(HaveOccurred())
+
+	_, err = client.Ack(ctx, &gatewaypb.AckRequest{DeliveryId: msg.DeliveryId})
+	g.Expect(status.Code(err)).To(Equal(codes.NotFound), "a delivery is settled once")
+
+	msg, err = stream.Recv()
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(msg.MessageId).To(Equal("order-2"))
+	_, err = client.Ack(ctx, &gatewaypb.AckRequest{DeliveryId: msg.DeliveryId, Settlement: gatewaypb.Settlement_DEAD_LETTER})
+	g.Expect(err).ToNot(HaveOccurred())
+
+	g.Eventually(handled).Should(BeClosed())
+	g.Expect(settler.Completed()).To(Equal(orders[:1]))
+	g.Expect(settler.DeadLettered()).To(Equal(orders[1:]))
+}
+
+func TestGateway_AbandonsUnacknowledgedMessages(t *testing.T) {
+	g := NewWithT(t)
+	gateway := grpcgateway.NewGateway(&grpcgateway.GatewayOptions{AckTimeout: 50 * time.Millisecond})
+	client := serve(t, gateway)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream, err := client.Subscribe(ctx, &gatewaypb.SubscribeRequest{})
+	g.Expect(err).ToNot(HaveOccurred())
+
+	settler := shuttletest.NewRecordingSettler()
+	go gateway.Handle(ctx, settler, &azservicebus.ReceivedMessage{MessageID: "timeout"})
+	msg, err := stream.Recv()
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Eventually(settler.Abandoned).Should(HaveLen(1), "abandoned after the ack timeout")
+	_, err = client.Ack(ctx, &gatewaypb.AckRequest{DeliveryId: msg.DeliveryId})
+	g.Expect(status.Code(err)).To(Equal(codes.NotFound))
+	g.Expect(settler.Completed()).To(BeEmpty())
+}
+
+func TestGateway_AbandonsOnDisconnect(t *testing.T) {
+	g := NewWithT(t)
+	gateway := grpcgateway.NewGateway(nil)
+	client := serve(t, gateway)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	streamCtx, disconnect := context.WithCancel(ctx)
+	stream, err := client.Subscribe(streamCtx, &gatewaypb.SubscribeRequest{})
+	g.Expect(err).ToNot(HaveOccurred())
+
+	settler := shuttletest.NewRecordingSettler()
+	go gateway.Handle(ctx, settler, &azservicebus.ReceivedMessage{MessageID: "lost"})
+	_, err = stream.Recv()
+	g.Expect(err).ToNot(HaveOccurred())
+	disconnect()
+	g.Eventually(settler.Abandoned).Should(HaveLen(1))
+}
+
+func TestGateway_AbandonsWithoutSubscribers(t *testing.T) {
+	g := NewWithT(t)
+	gateway := grpcgateway.NewGateway(nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	settler := shuttletest.NewRecordingSettler()
+	gateway.Handle(ctx, settler, &azservicebus.ReceivedMessage{MessageID: "unrouted"})
+	g.Expect(settler.Abandoned()).To(HaveLen(1))
+}
+
+func TestGateway_ReturnsWhenTheAckTimeoutElapsesDuringTheHandoff(t *testing.T) {
+	g := NewWithT(t)
+	gateway := grpcgateway.NewGateway(&grpcgateway.GatewayOptions{AckTimeout: time.Nanosecond})
+	client := serve(t, gateway)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream, err := client.Subscribe(ctx, &gatewaypb.SubscribeRequest{})
+	g.Expect(err).ToNot(HaveOccurred())
+	go func() {
+		for {
+			if _, err := stream.Recv(); err != nil {
+				return
+			}
+		}
+	}()
+
+	settler := shuttletest.NewRecordingSettler()
+	for i := 0; i < 100; i++ {
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			gateway.Handle(ctx, settler, &azservicebus.ReceivedMessage{MessageID: "expired"})
+		}()
+		g.Eventually(done).Should(BeClosed(), "the delivery is abandoned even when its timeout elapses before Subscribe takes it")
+	}
+	g.Expect(settler.Abandoned()).To(HaveLen(100))
+}
+
+// failingSettler fails the abandons.
+type failingSettler struct {
+	*shuttletest.RecordingSettler
+}
+
+func (s *failingSettler) AbandonMessage(context.Context, *azservicebus.ReceivedMessage, *azservicebus.AbandonMessageOptions) error {
+	return errors.New("connection lost")
+}
+
+func TestGateway_ReportsAbandonErrors(t *testing.T) {
+	g := NewWithT(t)
+	var errs []error
+	gateway := grpcgateway.NewGateway(&grpcgateway.GatewayOptions{
+		OnError: func(ctx context.Context, message *azservicebus.ReceivedMessage, err error) { errs = append(errs, err) },
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	gateway.Handle(ctx, &failingSettler{RecordingSettler: shuttletest.NewRecordingSettler()}, &azservicebus.ReceivedMessage{MessageID: "unrouted"})
+	g.Expect(errs).To(HaveLen(1))
+	g.Expect(errs[0]).To(MatchError("failed to abandon message unrouted: connection lost"))
+}
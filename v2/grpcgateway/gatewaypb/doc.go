@@ -0,0 +1,2 @@
+// Package gatewaypb contains the gRPC stubs of the gateway protocol, generated from the gateway.proto file.
+package gatewaypb
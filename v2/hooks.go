@@ -0,0 +1,97 @@
+package shuttle
+
+import (
+	"context"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+)
+
+// Send operation names of the SendAttemptEvent.
+const (
+	SendOperationMessage        = "send"
+	SendOperationBatch          = "batch"
+	SendOperationSchedule       = "schedule"
+	SendOperationCancelSchedule = "cancelSchedule"
+)
+
+// Hooks are optional callbacks fired at the lifecycle points of the processor and the sender,
+// for custom instrumentation without wrapping the receiver, the settler or the sender.
+// The processor fires OnMessageReceived and OnSettled, the sender fires OnSendAttempt. Nil hooks are skipped.
+// The hooks are called synchronously: they must be fast and safe for concurrent use.
+type Hooks struct {
+	// OnMessageReceived is called before the handler is called for a received message.
+	OnMessageReceived func(ctx context.Context, event MessageReceivedEvent)
+	// OnSettled is called after each settlement attempt made by the handler, successful or not.
+	OnSettled func(ctx context.Context, event SettledEvent)
+	// OnSendAttempt is called after each send operation of the sender, successful or not.
+	OnSendAttempt func(ctx context.Context, event SendAttemptEvent)
+}
+
+// MessageReceivedEvent describes a message received by the processor.
+type MessageReceivedEvent struct {
+	// Entity is the EntityName of the processor.
+	Entity  string
+	Message *azservicebus.ReceivedMessage
+}
+
+// SettledEvent describes a settlement attempt of a message.
+type SettledEvent struct {
+	// Entity is the EntityName of the processor.
+	Entity  string
+	Message *azservicebus.ReceivedMessage
+	// Settlement is one of the settlement label values of the metrics/processor package, such as processor.SettlementComplete.
+	Settlement string
+	// SinceReceived is the time elapsed between the start of the handling and the settlement.
+	SinceReceived time.Duration
+	// Err is the error returned by the settler, nil when the message was settled.
+	Err error
+}
+
+// SendAttemptEvent describes a send operation of the sender.
+type SendAttemptEvent struct {
+	// Entity is the EntityName of the sender.
+	Entity string
+	// Operation is one of the SendOperation constants.
+	Operation string
+	// MessageCount is the number of messages sent, scheduled or canceled by the operation.
+	MessageCount int
+	// Duration is the time spent in the operation, excluding the backpressure delay.
+	Duration time.Duration
+	// Throttled is true when the namespace rejected the operation because it is throttling the sender.
+	Throttled bool
+	// Err is the error of the operation, nil when it succeeded.
+	Err error
+}
+
+// WithHooks sets the Hooks of the processor. Only OnMessageReceived and OnSettled are used by the processor.
+func WithHooks(hooks *Hooks) ProcessorOption {
+	return func(options *ProcessorOptions) {
+		options.Hooks = hooks
+	}
+}
+
+// WithSenderHooks sets the Hooks of the sender. Only OnSendAttempt is used by the sender.
+func WithSenderHooks(hooks *Hooks) SenderOption {
+	return func(options *SenderOptions) {
+		options.Hooks = hooks
+	}
+}
+
+func (h *Hooks) messageReceived(ctx context.Context, event MessageReceivedEvent) {
+	if h != nil && h.OnMessageReceived != nil {
+		h.OnMessageReceived(ctx, event)
+	}
+}
+
+func (h *Hooks) settled(ctx context.Context, event SettledEvent) {
+	if h != nil && h.OnSettled != nil {
+		h.OnSettled(ctx, event)
+	}
+}
+
+func (h *Hooks) sendAttempt(ctx context.Context, event SendAttemptEvent) {
+	if h != nil && h.OnSendAttempt != nil {
+		h.OnSendAttempt(ctx, event)
+	}
+}
@@ -0,0 +1,62 @@
+package shuttle_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	. "github.com/onsi/gomega"
+
+	"github.com/Azure/go-shuttle/v2"
+	"github.com/Azure/go-shuttle/v2/metrics/processor"
+)
+
+func TestProcessor_Hooks(t *testing.T) {
+	g := NewWithT(t)
+	rcv := &fakeReceiver{
+		fakeSettler:           &fakeSettler{},
+		SetupReceivedMessages: messagesChannel(2),
+		SetupMaxReceiveCalls:  2,
+	}
+	close(rcv.SetupReceivedMessages)
+	var mu sync.Mutex
+	var received []shuttle.MessageReceivedEvent
+	var settled []shuttle.SettledEvent
+	hooks := &shuttle.Hooks{
+		OnMessageReceived: func(ctx context.Context, event shuttle.MessageReceivedEvent) {
+			mu.Lock()
+			defer mu.Unlock()
+			received = append(received, event)
+		},
+		OnSettled: func(ctx context.Context, event shuttle.SettledEvent) {
+			mu.Lock()
+			defer mu.Unlock()
+			settled = append(settled, event)
+		},
+	}
+	handler := shuttle.HandlerFunc(func(ctx context.Context, settler shuttle.MessageSettler, message *azservicebus.ReceivedMessage) {
+		g.Expect(settler.CompleteMessage(ctx, message, nil)).To(Succeed())
+	})
+	p := shuttle.NewProcessorWithOptions(rcv, handler,
+		shuttle.WithMaxConcurrency(2),
+		shuttle.WithEntityName("queue"),
+		shuttle.WithHooks(hooks))
+	g.Expect(p.Start(context.Background())).To(MatchError("max receive calls exceeded"))
+	g.Eventually(func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(settled)
+	}).Should(Equal(2))
+	mu.Lock()
+	defer mu.Unlock()
+	g.Expect(received).To(HaveLen(2))
+	g.Expect(received[0].Entity).To(Equal("queue"))
+	g.Expect(received[0].Message).ToNot(BeNil())
+	for _, event := range settled {
+		g.Expect(event.Entity).To(Equal("queue"))
+		g.Expect(event.Settlement).To(Equal(processor.SettlementComplete))
+		g.Expect(event.SinceReceived).To(BeNumerically(">", 0))
+		g.Expect(event.Err).ToNot(HaveOccurred())
+	}
+}
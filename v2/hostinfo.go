@@ -0,0 +1,114 @@
+package shuttle
+
+import (
+	"context"
+	"os"
+	"runtime/debug"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+)
+
+// Application properties recording the hosts that produced and abandoned a message, set by WithSenderHostInfo
+// and NewHostInfoHandler.
+const (
+	ProducerHostProperty    = "goshuttle-producer-host"
+	ProducerPodProperty     = "goshuttle-producer-pod"
+	ProducerVersionProperty = "goshuttle-producer-version"
+	ConsumerHostProperty    = "goshuttle-consumer-host"
+	ConsumerPodProperty     = "goshuttle-consumer-pod"
+	ConsumerVersionProperty = "goshuttle-consumer-version"
+	// ConsumerAttemptProperty is the delivery count of the message when the consumer abandoned it.
+	ConsumerAttemptProperty = "goshuttle-consumer-attempt"
+)
+
+// HostInfo identifies the process producing or consuming the messages, to trace the redeliveries across services.
+// The empty fields are not set on the messages.
+type HostInfo struct {
+	// Host is the name of the host, the pod name on kubernetes.
+	Host string
+	// Pod is the name of the pod, for the hosts whose name is not the pod name.
+	Pod string
+	// Version is the version of the application.
+	Version string
+}
+
+// CurrentHostInfo returns the HostInfo of the current process: the hostname, the POD_NAME environment variable
+// usually set with the kubernetes downward API, and the version, defaulting to the version of the main module.
+func CurrentHostInfo(version string) HostInfo {
+	host, _ := os.Hostname()
+	if version == "" {
+		if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "(devel)" {
+			version = info.Main.Version
+		}
+	}
+	return HostInfo{Host: host, Pod: os.Getenv("POD_NAME"), Version: version}
+}
+
+// properties returns the non-empty fields of the HostInfo under the given property names.
+func (h HostInfo) properties(hostProperty, podProperty, versionProperty string) map[string]any {
+	properties := map[string]any{}
+	for name, value := range map[string]string{hostProperty: h.Host, podProperty: h.Pod, versionProperty: h.Version} {
+		if value != "" {
+			properties[name] = value
+		}
+	}
+	return properties
+}
+
+// SetProducerHostInfo sets the producer properties of the HostInfo on the message.
+func SetProducerHostInfo(info HostInfo) func(msg *azservicebus.Message) error {
+	return func(msg *azservicebus.Message) error {
+		if msg.ApplicationProperties == nil {
+			msg.ApplicationProperties = map[string]any{}
+		}
+		for name, value := range info.properties(ProducerHostProperty, ProducerPodProperty, ProducerVersionProperty) {
+			msg.ApplicationProperties[name] = value
+		}
+		return nil
+	}
+}
+
+// WithSenderHostInfo applies SetProducerHostInfo on all the messages sent through the sender.
+func WithSenderHostInfo(info HostInfo) SenderOption {
+	return func(options *SenderOptions) {
+		options.HostInfo = &info
+	}
+}
+
+// NewHostInfoHandler is a middleware adding the consumer properties of the HostInfo and the delivery attempt
+// to the messages abandoned by next, so that the next consumer knows where the previous attempts failed.
+// The PropertiesToModify set by next take precedence.
+func NewHostInfoHandler(info HostInfo, next Handler) HandlerFunc {
+	properties := info.properties(ConsumerHostProperty, ConsumerPodProperty, ConsumerVersionProperty)
+	return func(ctx context.Context, settler MessageSettler, message *azservicebus.ReceivedMessage) {
+		next.Handle(ctx, &hostInfoSettler{MessageSettler: settler, properties: properties}, message)
+	}
+}
+
+// hostInfoSettler adds the consumer properties to the abandoned messages.
+type hostInfoSettler struct {
+	MessageSettler
+	properties map[string]any
+}
+
+// Unwrap returns the wrapped MessageSettler, for UnwrapSettler.
+func (s *hostInfoSettler) Unwrap() MessageSettler {
+	return s.MessageSettler
+}
+
+func (s *hostInfoSettler) AbandonMessage(ctx context.Context, message *azservicebus.ReceivedMessage, options *azservicebus.AbandonMessageOptions) error {
+	modified := make(map[string]any, len(s.properties)+1)
+	for name, value := range s.properties {
+		modified[name] = value
+	}
+	modified[ConsumerAttemptProperty] = int64(message.DeliveryCount)
+	abandonOptions := &azservicebus.AbandonMessageOptions{}
+	if options != nil {
+		*abandonOptions = *options
+		for name, value := range options.PropertiesToModify {
+			modified[name] = value
+		}
+	}
+	abandonOptions.PropertiesToModify = modified
+	return s.MessageSettler.AbandonMessage(ctx, message, abandonOptions)
+}
@@ -0,0 +1,60 @@
+package shuttle
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	. "github.com/onsi/gomega"
+)
+
+func TestCurrentHostInfo(t *testing.T) {
+	g := NewWithT(t)
+	t.Setenv("POD_NAME", "orders-7d9f")
+	host, err := os.Hostname()
+	g.Expect(err).ToNot(HaveOccurred())
+	info := CurrentHostInfo("v1.2.3")
+	g.Expect(info).To(Equal(HostInfo{Host: host, Pod: "orders-7d9f", Version: "v1.2.3"}))
+}
+
+func TestSender_HostInfo(t *testing.T) {
+	g := NewWithT(t)
+	azSender := &fakeAzSender{}
+	sender := NewSenderWithOptions(azSender, WithSenderHostInfo(HostInfo{Host: "node-1", Version: "v1.2.3"}))
+	g.Expect(sender.SendMessage(context.Background(), "test")).To(Succeed())
+	properties := azSender.SendMessageReceivedValue.ApplicationProperties
+	g.Expect(properties).To(HaveKeyWithValue(ProducerHostProperty, "node-1"))
+	g.Expect(properties).To(HaveKeyWithValue(ProducerVersionProperty, "v1.2.3"))
+	g.Expect(properties).ToNot(HaveKey(ProducerPodProperty))
+}
+
+func TestHostInfoHandler(t *testing.T) {
+	g := NewWithT(t)
+	info := HostInfo{Host: "node-2", Pod: "consumer-1"}
+	settler := &fakeSettler{}
+	message := &azservicebus.ReceivedMessage{DeliveryCount: 3}
+
+	handler := NewHostInfoHandler(info, HandlerFunc(func(ctx context.Context, settler MessageSettler, message *azservicebus.ReceivedMessage) {
+		_ = settler.AbandonMessage(ctx, message, nil)
+	}))
+	handler(context.Background(), settler, message)
+	g.Expect(settler.abandoned).To(BeTrue())
+	g.Expect(settler.abandonOptions.PropertiesToModify).To(Equal(map[string]any{
+		ConsumerHostProperty:    "node-2",
+		ConsumerPodProperty:     "consumer-1",
+		ConsumerAttemptProperty: int64(3),
+	}))
+
+	// the properties of the handler are kept and take precedence
+	settler = &fakeSettler{}
+	handler = NewHostInfoHandler(info, HandlerFunc(func(ctx context.Context, settler MessageSettler, message *azservicebus.ReceivedMessage) {
+		_ = settler.AbandonMessage(ctx, message, &azservicebus.AbandonMessageOptions{
+			PropertiesToModify: map[string]any{RetryLastErrorProperty: "timeout", ConsumerHostProperty: "override"},
+		})
+	}))
+	handler(context.Background(), settler, message)
+	g.Expect(settler.abandonOptions.PropertiesToModify).To(HaveKeyWithValue(RetryLastErrorProperty, "timeout"))
+	g.Expect(settler.abandonOptions.PropertiesToModify).To(HaveKeyWithValue(ConsumerPodProperty, "consumer-1"))
+	g.Expect(settler.abandonOptions.PropertiesToModify).To(HaveKeyWithValue(ConsumerHostProperty, "override"))
+}
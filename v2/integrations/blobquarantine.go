@@ -0,0 +1,78 @@
+package integrations
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/Azure/go-shuttle/v2"
+)
+
+const blobAPIVersion = "2021-08-06"
+
+var _ shuttle.Quarantine = &BlobContainerQuarantine{}
+
+// BlobContainerQuarantine is a shuttle.Quarantine uploading the payloads as block blobs of an Azure Storage container,
+// with the Put Blob REST operation authorized by a shared access signature.
+// For the Microsoft Entra authorization, implement shuttle.Quarantine with the container client of the azblob package.
+type BlobContainerQuarantine struct {
+	container *url.URL
+	client    *http.Client
+}
+
+// NewBlobContainerQuarantine creates a BlobContainerQuarantine uploading to the container URL,
+// such as https://account.blob.core.windows.net/quarantine?sv=...&sig=..., with a SAS granting the create permission.
+// The client defaults to http.DefaultClient.
+func NewBlobContainerQuarantine(containerURL string, client *http.Client) (*BlobContainerQuarantine, error) {
+	container, err := url.Parse(containerURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse container url: %w", err)
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &BlobContainerQuarantine{container: container, client: client}, nil
+}
+
+// Upload creates the blob and returns its URL, without the SAS.
+func (q *BlobContainerQuarantine) Upload(ctx context.Context, name string, body []byte, metadata map[string]string) (string, error) {
+	blob := *q.container
+	blob.Path = strings.TrimSuffix(blob.Path, "/") + "/" + name
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, blob.String(), bytes.NewReader(body))
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("x-ms-version", blobAPIVersion)
+	req.Header.Set("x-ms-blob-type", "BlockBlob")
+	if contentType, ok := metadata["contentType"]; ok {
+		req.Header.Set("x-ms-blob-content-type", contentType)
+	}
+	for key, value := range metadata {
+		req.Header.Set("x-ms-meta-"+key, headerSafe(value))
+	}
+	res, err := q.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to upload blob %s: %w", name, err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusCreated {
+		message, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+		return "", fmt.Errorf("failed to upload blob %s: status %d: %s", name, res.StatusCode, bytes.TrimSpace(message))
+	}
+	blob.RawQuery = ""
+	return blob.String(), nil
+}
+
+// headerSafe replaces the characters not allowed in the metadata headers, which must be printable ASCII.
+func headerSafe(value string) string {
+	return strings.Map(func(r rune) rune {
+		if r < ' ' || r > '~' {
+			return '?'
+		}
+		return r
+	}, value)
+}
@@ -0,0 +1,53 @@
+package integrations_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	"github.com/Azure/go-shuttle/v2/integrations"
+)
+
+func TestBlobContainerQuarantine_Upload(t *testing.T) {
+	g := NewWithT(t)
+	var req *http.Request
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req = r
+		body, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	quarantine, err := integrations.NewBlobContainerQuarantine(server.URL+"/quarantine?sv=2021&sig=secret", nil)
+	g.Expect(err).ToNot(HaveOccurred())
+	url, err := quarantine.Upload(context.Background(), "orders/2024/01/31/poison", []byte("{not json"), map[string]string{
+		"contentType":      "application/json",
+		"errorDescription": "invalid\ncharacter",
+	})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(url).To(Equal(server.URL+"/quarantine/orders/2024/01/31/poison"), "the SAS is not returned")
+	g.Expect(req.Method).To(Equal(http.MethodPut))
+	g.Expect(req.URL.Path).To(Equal("/quarantine/orders/2024/01/31/poison"))
+	g.Expect(req.URL.Query().Get("sig")).To(Equal("secret"))
+	g.Expect(req.Header.Get("x-ms-blob-type")).To(Equal("BlockBlob"))
+	g.Expect(req.Header.Get("x-ms-blob-content-type")).To(Equal("application/json"))
+	g.Expect(req.Header.Get("x-ms-meta-errorDescription")).To(Equal("invalid?character"))
+	g.Expect(body).To(Equal([]byte("{not json")))
+}
+
+func TestBlobContainerQuarantine_UploadFailure(t *testing.T) {
+	g := NewWithT(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "AuthorizationFailure", http.StatusForbidden)
+	}))
+	defer server.Close()
+	quarantine, err := integrations.NewBlobContainerQuarantine(server.URL+"/quarantine", nil)
+	g.Expect(err).ToNot(HaveOccurred())
+	_, err = quarantine.Upload(context.Background(), "poison", nil, nil)
+	g.Expect(err).To(MatchError(ContainSubstring("status 403: AuthorizationFailure")))
+}
@@ -0,0 +1,140 @@
+package integrations
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	"github.com/google/uuid"
+
+	"github.com/Azure/go-shuttle/v2"
+)
+
+const (
+	daprCloudEventContentType = "application/cloudevents+json"
+	daprDefaultEventType      = "com.dapr.event.sent"
+	cloudEventSpecVersion     = "1.0"
+	// daprEnvelopeErrorField is the application property set when a Dapr envelope cannot be unwrapped.
+	daprEnvelopeErrorField = "daprEnvelopeError"
+)
+
+// DaprOptions describes how the Dapr Service Bus pub/sub component is configured on the other side.
+type DaprOptions struct {
+	// PubSubName is the name of the Dapr pub/sub component.
+	PubSubName string
+	// Topic is the service bus topic name, which Dapr uses as the pub/sub topic name.
+	Topic string
+	// Source is the CloudEvents source. Dapr sets it to the publisher's app id.
+	Source string
+	// RawPayload mirrors the rawPayload metadata of Dapr: when true, messages are exchanged without the CloudEvents envelope.
+	RawPayload bool
+}
+
+// daprCloudEvent is the CloudEvents envelope produced and expected by Dapr.
+type daprCloudEvent struct {
+	cloudEvent
+	Topic       string `json:"topic,omitempty"`
+	PubSubName  string `json:"pubsubname,omitempty"`
+	TraceParent string `json:"traceparent,omitempty"`
+}
+
+// DaprSubscriptionName returns the service bus subscription name the Dapr pub/sub component uses for an app.
+// Dapr names the subscription after the consumerID metadata, which defaults to the app id.
+func DaprSubscriptionName(appID string) string {
+	return appID
+}
+
+// WithDaprEnvelope is a sender option that wraps the message body in the CloudEvents envelope expected by Dapr subscribers.
+// It must be the last option, so that the envelope captures the final message id, type and content type.
+// It is a no-op when options.RawPayload is true.
+func WithDaprEnvelope(options DaprOptions) func(msg *azservicebus.Message) error {
+	return func(msg *azservicebus.Message) error {
+		if options.RawPayload {
+			return nil
+		}
+		event := daprCloudEvent{
+			cloudEvent: cloudEvent{
+				SpecVersion: cloudEventSpecVersion,
+				Type:        daprDefaultEventType,
+				Source:      options.Source,
+			},
+			Topic:      options.Topic,
+			PubSubName: options.PubSubName,
+		}
+		if msg.MessageID != nil {
+			event.ID = *msg.MessageID
+		} else {
+			event.ID = uuid.NewString()
+		}
+		if msgType, ok := msg.ApplicationProperties[msgTypeField].(string); ok && msgType != "" {
+			event.Type = msgType
+		}
+		if traceParent, ok := msg.ApplicationProperties["traceparent"].(string); ok {
+			event.TraceParent = traceParent
+		}
+		if msg.ContentType != nil {
+			event.DataContentType = *msg.ContentType
+		}
+		if isJSONContentType(event.DataContentType) && json.Valid(msg.Body) {
+			event.Data = msg.Body
+		} else {
+			event.DataBase64 = base64.StdEncoding.EncodeToString(msg.Body)
+		}
+		body, err := json.Marshal(event)
+		if err != nil {
+			return fmt.Errorf("failed to marshal dapr cloud event: %w", err)
+		}
+		msg.Body = body
+		msg.ContentType = to.Ptr(daprCloudEventContentType)
+		return nil
+	}
+}
+
+// NewDaprEnvelopeHandler is a middleware that unwraps the CloudEvents envelope of messages published through Dapr,
+// so that the next handler receives the original payload. The envelope type is set as the go-shuttle type property.
+// Messages without the envelope, including Dapr rawPayload messages, are passed through unchanged.
+func NewDaprEnvelopeHandler(next shuttle.Handler) shuttle.HandlerFunc {
+	return func(ctx context.Context, settler shuttle.MessageSettler, message *azservicebus.ReceivedMessage) {
+		if message != nil && message.ContentType != nil && *message.ContentType == daprCloudEventContentType {
+			if message.ApplicationProperties == nil {
+				message.ApplicationProperties = map[string]any{}
+			}
+			if err := unwrapDaprEnvelope(message); err != nil {
+				// let the next handler decide what to do with a message it cannot decode
+				message.ApplicationProperties[daprEnvelopeErrorField] = err.Error()
+			}
+		}
+		next.Handle(ctx, settler, message)
+	}
+}
+
+func unwrapDaprEnvelope(message *azservicebus.ReceivedMessage) error {
+	var event daprCloudEvent
+	if err := json.Unmarshal(message.Body, &event); err != nil {
+		return fmt.Errorf("failed to unmarshal dapr cloud event: %w", err)
+	}
+	data, err := event.toEvent()
+	if err != nil {
+		return err
+	}
+	message.Body = data.Data
+	message.ContentType = nil
+	if data.DataContentType != "" {
+		message.ContentType = to.Ptr(data.DataContentType)
+	}
+	if event.Type != "" && event.Type != daprDefaultEventType {
+		message.ApplicationProperties[msgTypeField] = event.Type
+	}
+	if event.TraceParent != "" {
+		message.ApplicationProperties["traceparent"] = event.TraceParent
+	}
+	return nil
+}
+
+func isJSONContentType(contentType string) bool {
+	return contentType == "" || strings.HasPrefix(contentType, "application/json") || strings.HasSuffix(contentType, "+json")
+}
@@ -0,0 +1,92 @@
+package integrations
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	. "github.com/onsi/gomega"
+
+	"github.com/Azure/go-shuttle/v2"
+)
+
+type OrderCreated struct {
+	ID string
+}
+
+func TestWithDaprEnvelope(t *testing.T) {
+	g := NewWithT(t)
+	sender := shuttle.NewSender(nil, nil)
+	msg, err := sender.ToServiceBusMessage(context.Background(), &OrderCreated{ID: "1"},
+		shuttle.SetMessageId(to.Ptr("msg-1")),
+		WithDaprEnvelope(DaprOptions{PubSubName: "pubsub", Topic: "orders", Source: "app"}))
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(*msg.ContentType).To(Equal("application/cloudevents+json"))
+	var envelope map[string]any
+	g.Expect(json.Unmarshal(msg.Body, &envelope)).To(Succeed())
+	g.Expect(envelope).To(HaveKeyWithValue("id", "msg-1"))
+	g.Expect(envelope).To(HaveKeyWithValue("type", "OrderCreated"))
+	g.Expect(envelope).To(HaveKeyWithValue("source", "app"))
+	g.Expect(envelope).To(HaveKeyWithValue("topic", "orders"))
+	g.Expect(envelope).To(HaveKeyWithValue("pubsubname", "pubsub"))
+	g.Expect(envelope).To(HaveKeyWithValue("datacontenttype", "application/json"))
+	g.Expect(envelope).To(HaveKeyWithValue("data", map[string]any{"ID": "1"}))
+}
+
+func TestWithDaprEnvelope_BinaryAndRawPayload(t *testing.T) {
+	g := NewWithT(t)
+	msg := &azservicebus.Message{Body: []byte{0x01, 0x02}, ContentType: to.Ptr("application/octet-stream")}
+	g.Expect(WithDaprEnvelope(DaprOptions{})(msg)).To(Succeed())
+	var envelope map[string]any
+	g.Expect(json.Unmarshal(msg.Body, &envelope)).To(Succeed())
+	g.Expect(envelope).To(HaveKeyWithValue("data_base64", "AQI="))
+	g.Expect(envelope["id"]).ToNot(BeEmpty())
+
+	raw := &azservicebus.Message{Body: []byte("raw")}
+	g.Expect(WithDaprEnvelope(DaprOptions{RawPayload: true})(raw)).To(Succeed())
+	g.Expect(raw.Body).To(Equal([]byte("raw")))
+	g.Expect(raw.ContentType).To(BeNil())
+}
+
+func TestDaprEnvelopeHandler_RoundTrip(t *testing.T) {
+	g := NewWithT(t)
+	sender := shuttle.NewSender(nil, nil)
+	msg, err := sender.ToServiceBusMessage(context.Background(), &OrderCreated{ID: "1"},
+		WithDaprEnvelope(DaprOptions{Topic: "orders"}))
+	g.Expect(err).ToNot(HaveOccurred())
+	received := &azservicebus.ReceivedMessage{Body: msg.Body, ContentType: msg.ContentType}
+
+	var handled *azservicebus.ReceivedMessage
+	h := NewDaprEnvelopeHandler(shuttle.HandlerFunc(
+		func(_ context.Context, _ shuttle.MessageSettler, message *azservicebus.ReceivedMessage) {
+			handled = message
+		}))
+	h.Handle(context.Background(), &fakeSettler{}, received)
+	g.Expect(string(handled.Body)).To(Equal(`{"ID":"1"}`))
+	g.Expect(*handled.ContentType).To(Equal("application/json"))
+	g.Expect(handled.ApplicationProperties).To(HaveKeyWithValue("type", "OrderCreated"))
+}
+
+func TestDaprEnvelopeHandler_PassThrough(t *testing.T) {
+	g := NewWithT(t)
+	var handled *azservicebus.ReceivedMessage
+	h := NewDaprEnvelopeHandler(shuttle.HandlerFunc(
+		func(_ context.Context, _ shuttle.MessageSettler, message *azservicebus.ReceivedMessage) {
+			handled = message
+		}))
+	h.Handle(context.Background(), &fakeSettler{}, &azservicebus.ReceivedMessage{Body: []byte("raw")})
+	g.Expect(handled.Body).To(Equal([]byte("raw")))
+
+	h.Handle(context.Background(), &fakeSettler{}, &azservicebus.ReceivedMessage{
+		Body:        []byte("not json"),
+		ContentType: to.Ptr("application/cloudevents+json"),
+	})
+	g.Expect(handled.Body).To(Equal([]byte("not json")))
+	g.Expect(handled.ApplicationProperties).To(HaveKey("daprEnvelopeError"))
+}
+
+func TestDaprSubscriptionName(t *testing.T) {
+	NewWithT(t).Expect(DaprSubscriptionName("checkout")).To(Equal("checkout"))
+}
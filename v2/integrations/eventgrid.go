@@ -0,0 +1,117 @@
+package integrations
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+)
+
+// MessageSender is satisfied by *azservicebus.Sender and shuttle.AzServiceBusSender.
+type MessageSender interface {
+	SendMessage(ctx context.Context, message *azservicebus.Message, options *azservicebus.SendMessageOptions) error
+}
+
+// cloudEvent is the CloudEvents 1.0 JSON representation used by Event Grid webhooks.
+type cloudEvent struct {
+	SpecVersion     string          `json:"specversion"`
+	ID              string          `json:"id"`
+	Type            string          `json:"type"`
+	Source          string          `json:"source"`
+	Subject         string          `json:"subject,omitempty"`
+	Time            *time.Time      `json:"time,omitempty"`
+	DataContentType string          `json:"datacontenttype,omitempty"`
+	Data            json.RawMessage `json:"data,omitempty"`
+	DataBase64      string          `json:"data_base64,omitempty"`
+}
+
+func (c cloudEvent) toEvent() (Event, error) {
+	event := Event{
+		ID:              c.ID,
+		Type:            c.Type,
+		Source:          c.Source,
+		Subject:         c.Subject,
+		DataContentType: c.DataContentType,
+		Data:            c.Data,
+	}
+	if c.Time != nil {
+		event.Time = *c.Time
+	}
+	if c.DataBase64 != "" {
+		data, err := base64.StdEncoding.DecodeString(c.DataBase64)
+		if err != nil {
+			return Event{}, fmt.Errorf("failed to decode data_base64 of event %s: %w", c.ID, err)
+		}
+		event.Data = data
+	}
+	if event.DataContentType == "" && len(c.Data) > 0 {
+		event.DataContentType = "application/json"
+	}
+	return event, nil
+}
+
+// NewEventGridWebhookHandler creates an http.Handler that receives Event Grid events delivered with the CloudEvents schema,
+// and sends each of them to service bus, mapped with EventToMessage.
+// It answers the CloudEvents webhook validation handshake, and supports both single and batched deliveries.
+// A non-2xx status is returned when sending fails, so that Event Grid retries the delivery.
+func NewEventGridWebhookHandler(sender MessageSender) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodOptions {
+			// CloudEvents abuse protection handshake
+			if origin := r.Header.Get("WebHook-Request-Origin"); origin != "" {
+				w.Header().Set("WebHook-Allowed-Origin", origin)
+			}
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		events, err := decodeCloudEvents(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for _, event := range events {
+			if err := sender.SendMessage(r.Context(), EventToMessage(event), nil); err != nil {
+				http.Error(w, fmt.Sprintf("failed to send event %s: %s", event.ID, err), http.StatusInternalServerError)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+func decodeCloudEvents(body io.Reader) ([]Event, error) {
+	raw, err := io.ReadAll(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read request body: %w", err)
+	}
+	var cloudEvents []cloudEvent
+	if trimmed := bytes.TrimSpace(raw); len(trimmed) > 0 && trimmed[0] == '[' {
+		err = json.Unmarshal(trimmed, &cloudEvents)
+	} else {
+		var single cloudEvent
+		err = json.Unmarshal(trimmed, &single)
+		cloudEvents = []cloudEvent{single}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode cloud events: %w", err)
+	}
+	events := make([]Event, 0, len(cloudEvents))
+	for _, c := range cloudEvents {
+		event, err := c.toEvent()
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
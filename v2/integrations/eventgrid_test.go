@@ -0,0 +1,79 @@
+package integrations
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	. "github.com/onsi/gomega"
+)
+
+type fakeMessageSender struct {
+	sent []*azservicebus.Message
+	err  error
+}
+
+func (f *fakeMessageSender) SendMessage(_ context.Context, message *azservicebus.Message, _ *azservicebus.SendMessageOptions) error {
+	if f.err != nil {
+		return f.err
+	}
+	f.sent = append(f.sent, message)
+	return nil
+}
+
+func TestEventGridWebhookHandler_ValidationHandshake(t *testing.T) {
+	g := NewWithT(t)
+	req := httptest.NewRequest(http.MethodOptions, "/", nil)
+	req.Header.Set("WebHook-Request-Origin", "eventgrid.azure.net")
+	rec := httptest.NewRecorder()
+	NewEventGridWebhookHandler(&fakeMessageSender{}).ServeHTTP(rec, req)
+	g.Expect(rec.Code).To(Equal(http.StatusOK))
+	g.Expect(rec.Header().Get("WebHook-Allowed-Origin")).To(Equal("eventgrid.azure.net"))
+}
+
+func TestEventGridWebhookHandler_SingleEvent(t *testing.T) {
+	g := NewWithT(t)
+	sender := &fakeMessageSender{}
+	body := `{"specversion":"1.0","id":"1","type":"OrderCreated","source":"/orders","subject":"orders/1","data":{"a":1}}`
+	rec := httptest.NewRecorder()
+	NewEventGridWebhookHandler(sender).ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body)))
+	g.Expect(rec.Code).To(Equal(http.StatusOK))
+	g.Expect(sender.sent).To(HaveLen(1))
+	g.Expect(*sender.sent[0].MessageID).To(Equal("1"))
+	g.Expect(*sender.sent[0].ContentType).To(Equal("application/json"))
+	g.Expect(string(sender.sent[0].Body)).To(Equal(`{"a":1}`))
+	g.Expect(sender.sent[0].ApplicationProperties).To(HaveKeyWithValue("type", "OrderCreated"))
+	g.Expect(sender.sent[0].ApplicationProperties).To(HaveKeyWithValue("source", "/orders"))
+}
+
+func TestEventGridWebhookHandler_Batch(t *testing.T) {
+	g := NewWithT(t)
+	sender := &fakeMessageSender{}
+	body := `[{"specversion":"1.0","id":"1","type":"a","source":"/s","data_base64":"aGVsbG8="},
+		{"specversion":"1.0","id":"2","type":"b","source":"/s"}]`
+	rec := httptest.NewRecorder()
+	NewEventGridWebhookHandler(sender).ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body)))
+	g.Expect(rec.Code).To(Equal(http.StatusOK))
+	g.Expect(sender.sent).To(HaveLen(2))
+	g.Expect(string(sender.sent[0].Body)).To(Equal("hello"))
+}
+
+func TestEventGridWebhookHandler_Errors(t *testing.T) {
+	g := NewWithT(t)
+	rec := httptest.NewRecorder()
+	NewEventGridWebhookHandler(&fakeMessageSender{}).ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", strings.NewReader("not json")))
+	g.Expect(rec.Code).To(Equal(http.StatusBadRequest))
+
+	rec = httptest.NewRecorder()
+	NewEventGridWebhookHandler(&fakeMessageSender{err: fmt.Errorf("send failure")}).ServeHTTP(rec,
+		httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"id":"1"}`)))
+	g.Expect(rec.Code).To(Equal(http.StatusInternalServerError))
+
+	rec = httptest.NewRecorder()
+	NewEventGridWebhookHandler(&fakeMessageSender{}).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	g.Expect(rec.Code).To(Equal(http.StatusMethodNotAllowed))
+}
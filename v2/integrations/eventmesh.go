@@ -0,0 +1,134 @@
+// Package integrations bridges go-shuttle with other Azure messaging services.
+// It does not depend on their SDKs: the bridges are wired through small interfaces
+// that the Event Hubs producer or the Event Grid publisher clients can be adapted to.
+package integrations
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+
+	"github.com/Azure/go-shuttle/v2"
+)
+
+const (
+	// msgTypeField is the application property the shuttle.Sender uses to store the message type.
+	msgTypeField = "type"
+	// sourceField is the application property used to carry the event source on service bus messages.
+	sourceField = "source"
+)
+
+// Event is the representation of a message exchanged with Event Hubs or Event Grid.
+// Its fields follow the CloudEvents attributes so that metadata is mapped consistently in both directions.
+type Event struct {
+	ID              string
+	Type            string
+	Source          string
+	Subject         string
+	DataContentType string
+	Time            time.Time
+	Data            []byte
+	// Properties carries the service bus application properties that are not mapped to an attribute.
+	Properties map[string]any
+}
+
+// EventPublisher publishes events to Event Hubs or Event Grid.
+// Implement it by adapting an azeventhubs.ProducerClient or an Event Grid publisher client.
+type EventPublisher interface {
+	PublishEvents(ctx context.Context, events []Event) error
+}
+
+// EventPublisherFunc allows to use a func as an EventPublisher.
+type EventPublisherFunc func(ctx context.Context, events []Event) error
+
+func (f EventPublisherFunc) PublishEvents(ctx context.Context, events []Event) error {
+	return f(ctx, events)
+}
+
+// MessageToEvent maps a received service bus message to an Event.
+// the type application property set by the shuttle.Sender is mapped to the event type,
+// and the remaining application properties are carried in the event properties.
+func MessageToEvent(message *azservicebus.ReceivedMessage, source string) Event {
+	event := Event{
+		ID:         message.MessageID,
+		Source:     source,
+		Data:       message.Body,
+		Properties: map[string]any{},
+	}
+	if message.Subject != nil {
+		event.Subject = *message.Subject
+	}
+	if message.ContentType != nil {
+		event.DataContentType = *message.ContentType
+	}
+	if message.EnqueuedTime != nil {
+		event.Time = *message.EnqueuedTime
+	}
+	for k, v := range message.ApplicationProperties {
+		switch k {
+		case msgTypeField:
+			event.Type = fmt.Sprint(v)
+		case sourceField:
+			if event.Source == "" {
+				event.Source = fmt.Sprint(v)
+			}
+		default:
+			event.Properties[k] = v
+		}
+	}
+	return event
+}
+
+// EventToMessage maps an Event to a service bus message.
+// It is the reverse of MessageToEvent: the event type and source are stored in the application properties.
+func EventToMessage(event Event) *azservicebus.Message {
+	msg := &azservicebus.Message{
+		Body:                  event.Data,
+		ApplicationProperties: map[string]any{},
+	}
+	for k, v := range event.Properties {
+		msg.ApplicationProperties[k] = v
+	}
+	if event.ID != "" {
+		msg.MessageID = to.Ptr(event.ID)
+	}
+	if event.Subject != "" {
+		msg.Subject = to.Ptr(event.Subject)
+	}
+	if event.DataContentType != "" {
+		msg.ContentType = to.Ptr(event.DataContentType)
+	}
+	if event.Type != "" {
+		msg.ApplicationProperties[msgTypeField] = event.Type
+	}
+	if event.Source != "" {
+		msg.ApplicationProperties[sourceField] = event.Source
+	}
+	return msg
+}
+
+// EventForwardingOptions configures the handler created by NewEventForwardingHandler.
+type EventForwardingOptions struct {
+	// Source is set as the source of the published events. Typically, the service bus entity path.
+	Source string
+	// ManagedSettlingOptions configures how the received message is settled based on the publish outcome.
+	ManagedSettlingOptions *shuttle.ManagedSettlingOptions
+}
+
+// NewEventForwardingHandler creates a handler that publishes each received message as an Event,
+// and completes the message once it is published. Publish errors are handled by the ManagedSettlingHandler.
+func NewEventForwardingHandler(publisher EventPublisher, options *EventForwardingOptions) *shuttle.ManagedSettler {
+	if options == nil {
+		options = &EventForwardingOptions{}
+	}
+	return shuttle.NewManagedSettlingHandler(options.ManagedSettlingOptions,
+		shuttle.ManagedSettlingFunc(func(ctx context.Context, message *azservicebus.ReceivedMessage) error {
+			if err := publisher.PublishEvents(ctx, []Event{MessageToEvent(message, options.Source)}); err != nil {
+				return fmt.Errorf("failed to publish event: %w", err)
+			}
+			return nil
+		}))
+}
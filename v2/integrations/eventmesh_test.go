@@ -0,0 +1,110 @@
+package integrations
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	. "github.com/onsi/gomega"
+
+	"github.com/Azure/go-shuttle/v2"
+)
+
+type fakeSettler struct {
+	completed bool
+	abandoned bool
+}
+
+func (f *fakeSettler) AbandonMessage(_ context.Context, _ *azservicebus.ReceivedMessage, _ *azservicebus.AbandonMessageOptions) error {
+	f.abandoned = true
+	return nil
+}
+
+func (f *fakeSettler) CompleteMessage(_ context.Context, _ *azservicebus.ReceivedMessage, _ *azservicebus.CompleteMessageOptions) error {
+	f.completed = true
+	return nil
+}
+
+func (f *fakeSettler) DeadLetterMessage(_ context.Context, _ *azservicebus.ReceivedMessage, _ *azservicebus.DeadLetterOptions) error {
+	return nil
+}
+
+func (f *fakeSettler) DeferMessage(_ context.Context, _ *azservicebus.ReceivedMessage, _ *azservicebus.DeferMessageOptions) error {
+	return nil
+}
+
+func (f *fakeSettler) RenewMessageLock(_ context.Context, _ *azservicebus.ReceivedMessage, _ *azservicebus.RenewMessageLockOptions) error {
+	return nil
+}
+
+func TestMessageToEventToMessage(t *testing.T) {
+	g := NewWithT(t)
+	enqueued := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	received := &azservicebus.ReceivedMessage{
+		MessageID:    "id",
+		Body:         []byte(`{"a":1}`),
+		ContentType:  to.Ptr("application/json"),
+		Subject:      to.Ptr("orders/1"),
+		EnqueuedTime: &enqueued,
+		ApplicationProperties: map[string]any{
+			"type":   "OrderCreated",
+			"tenant": "x",
+		},
+	}
+	event := MessageToEvent(received, "sb://ns/topic")
+	g.Expect(event).To(Equal(Event{
+		ID:              "id",
+		Type:            "OrderCreated",
+		Source:          "sb://ns/topic",
+		Subject:         "orders/1",
+		DataContentType: "application/json",
+		Time:            enqueued,
+		Data:            []byte(`{"a":1}`),
+		Properties:      map[string]any{"tenant": "x"},
+	}))
+
+	msg := EventToMessage(event)
+	g.Expect(*msg.MessageID).To(Equal("id"))
+	g.Expect(*msg.Subject).To(Equal("orders/1"))
+	g.Expect(*msg.ContentType).To(Equal("application/json"))
+	g.Expect(msg.Body).To(Equal([]byte(`{"a":1}`)))
+	g.Expect(msg.ApplicationProperties).To(Equal(map[string]any{
+		"type":   "OrderCreated",
+		"source": "sb://ns/topic",
+		"tenant": "x",
+	}))
+}
+
+func TestEventForwardingHandler(t *testing.T) {
+	g := NewWithT(t)
+	var published []Event
+	publisher := EventPublisherFunc(func(_ context.Context, events []Event) error {
+		published = append(published, events...)
+		return nil
+	})
+	settler := &fakeSettler{}
+	h := NewEventForwardingHandler(publisher, &EventForwardingOptions{Source: "topic"})
+	h.Handle(context.Background(), settler, &azservicebus.ReceivedMessage{MessageID: "id"})
+	g.Expect(published).To(HaveLen(1))
+	g.Expect(published[0].Source).To(Equal("topic"))
+	g.Expect(settler.completed).To(BeTrue())
+}
+
+func TestEventForwardingHandler_PublishFailure(t *testing.T) {
+	g := NewWithT(t)
+	publisher := EventPublisherFunc(func(_ context.Context, _ []Event) error {
+		return fmt.Errorf("publish failure")
+	})
+	settler := &fakeSettler{}
+	h := NewEventForwardingHandler(publisher, &EventForwardingOptions{
+		ManagedSettlingOptions: &shuttle.ManagedSettlingOptions{
+			RetryDelayStrategy: &shuttle.ConstantDelayStrategy{Delay: time.Millisecond},
+		},
+	})
+	h.Handle(context.Background(), settler, &azservicebus.ReceivedMessage{})
+	g.Expect(settler.completed).To(BeFalse())
+	g.Expect(settler.abandoned).To(BeTrue())
+}
@@ -0,0 +1,158 @@
+package integrations
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+
+	"github.com/Azure/go-shuttle/v2"
+)
+
+// kafka headers used to carry the service bus system properties that have no kafka equivalent.
+const (
+	kafkaMessageIDHeader     = "messageId"
+	kafkaCorrelationIDHeader = "correlationId"
+	kafkaContentTypeHeader   = "contentType"
+	kafkaSubjectHeader       = "subject"
+)
+
+// KafkaPropertyHeaderPrefix prefixes the headers of the application properties of the messages,
+// so that they cannot collide with the headers of the system properties.
+const KafkaPropertyHeaderPrefix = "sb-property-"
+
+// KafkaHeader is a kafka record header.
+type KafkaHeader struct {
+	Key   string
+	Value []byte
+}
+
+// KafkaRecord is the client-agnostic representation of a kafka record.
+type KafkaRecord struct {
+	Topic     string
+	Key       []byte
+	Value     []byte
+	Headers   []KafkaHeader
+	Timestamp time.Time
+}
+
+// KafkaProducer produces records to kafka.
+// Implement it by adapting the kafka client of your choice (sarama, franz-go, confluent-kafka-go...).
+type KafkaProducer interface {
+	Produce(ctx context.Context, records ...KafkaRecord) error
+}
+
+// KafkaProducerFunc allows to use a func as a KafkaProducer.
+type KafkaProducerFunc func(ctx context.Context, records ...KafkaRecord) error
+
+func (f KafkaProducerFunc) Produce(ctx context.Context, records ...KafkaRecord) error {
+	return f(ctx, records...)
+}
+
+// MessageToKafkaRecord maps a received service bus message to a kafka record on the given topic.
+// The partition key, or the session id if not set, is used as the record key.
+// The system properties are mapped to the headers messageId, correlationId, contentType and subject, when set,
+// followed by the application properties, including the go-shuttle type property, sorted by name and
+// prefixed with KafkaPropertyHeaderPrefix.
+func MessageToKafkaRecord(message *azservicebus.ReceivedMessage, topic string) KafkaRecord {
+	record := KafkaRecord{
+		Topic: topic,
+		Value: message.Body,
+	}
+	if message.PartitionKey != nil {
+		record.Key = []byte(*message.PartitionKey)
+	} else if message.SessionID != nil {
+		record.Key = []byte(*message.SessionID)
+	}
+	if message.EnqueuedTime != nil {
+		record.Timestamp = *message.EnqueuedTime
+	}
+	addHeader := func(key string, value *string) {
+		if value != nil && *value != "" {
+			record.Headers = append(record.Headers, KafkaHeader{Key: key, Value: []byte(*value)})
+		}
+	}
+	addHeader(kafkaMessageIDHeader, &message.MessageID)
+	addHeader(kafkaCorrelationIDHeader, message.CorrelationID)
+	addHeader(kafkaContentTypeHeader, message.ContentType)
+	addHeader(kafkaSubjectHeader, message.Subject)
+	keys := make([]string, 0, len(message.ApplicationProperties))
+	for k := range message.ApplicationProperties {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		record.Headers = append(record.Headers, KafkaHeader{
+			Key:   KafkaPropertyHeaderPrefix + k,
+			Value: []byte(fmt.Sprint(message.ApplicationProperties[k])),
+		})
+	}
+	return record
+}
+
+// KafkaRecordToMessage maps a kafka record to a service bus message.
+// It is the reverse of MessageToKafkaRecord: the record key is used as partition key,
+// and the headers that do not map to a system property are set as string application properties,
+// without their KafkaPropertyHeaderPrefix. The headers of records produced by other applications,
+// without the prefix, are set as application properties as they are.
+func KafkaRecordToMessage(record KafkaRecord) *azservicebus.Message {
+	msg := &azservicebus.Message{
+		Body:                  record.Value,
+		ApplicationProperties: map[string]any{},
+	}
+	if len(record.Key) > 0 {
+		msg.PartitionKey = to.Ptr(string(record.Key))
+	}
+	for _, h := range record.Headers {
+		value := string(h.Value)
+		switch h.Key {
+		case kafkaMessageIDHeader:
+			msg.MessageID = to.Ptr(value)
+		case kafkaCorrelationIDHeader:
+			msg.CorrelationID = to.Ptr(value)
+		case kafkaContentTypeHeader:
+			msg.ContentType = to.Ptr(value)
+		case kafkaSubjectHeader:
+			msg.Subject = to.Ptr(value)
+		default:
+			msg.ApplicationProperties[strings.TrimPrefix(h.Key, KafkaPropertyHeaderPrefix)] = value
+		}
+	}
+	return msg
+}
+
+// KafkaForwardingOptions configures the handler created by NewKafkaForwardingHandler.
+type KafkaForwardingOptions struct {
+	// ManagedSettlingOptions configures how the received message is settled based on the produce outcome.
+	ManagedSettlingOptions *shuttle.ManagedSettlingOptions
+}
+
+// NewKafkaForwardingHandler creates a handler that produces each received message to the kafka topic,
+// and completes the message once it is produced. Produce errors are handled by the ManagedSettlingHandler.
+func NewKafkaForwardingHandler(producer KafkaProducer, topic string, options *KafkaForwardingOptions) *shuttle.ManagedSettler {
+	if options == nil {
+		options = &KafkaForwardingOptions{}
+	}
+	return shuttle.NewManagedSettlingHandler(options.ManagedSettlingOptions,
+		shuttle.ManagedSettlingFunc(func(ctx context.Context, message *azservicebus.ReceivedMessage) error {
+			if err := producer.Produce(ctx, MessageToKafkaRecord(message, topic)); err != nil {
+				return fmt.Errorf("failed to produce kafka record: %w", err)
+			}
+			return nil
+		}))
+}
+
+// NewKafkaRecordHandler returns a func that sends a consumed kafka record to service bus.
+// Call it from the kafka consumer loop, and commit the record offset only when it returns nil.
+func NewKafkaRecordHandler(sender MessageSender) func(ctx context.Context, record KafkaRecord) error {
+	return func(ctx context.Context, record KafkaRecord) error {
+		if err := sender.SendMessage(ctx, KafkaRecordToMessage(record), nil); err != nil {
+			return fmt.Errorf("failed to send kafka record from topic %s: %w", record.Topic, err)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,111 @@
+package integrations
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	. "github.com/onsi/gomega"
+
+	"github.com/Azure/go-shuttle/v2"
+)
+
+func TestKafkaRecordRoundTrip(t *testing.T) {
+	g := NewWithT(t)
+	received := &azservicebus.ReceivedMessage{
+		MessageID:     "id",
+		Body:          []byte("body"),
+		PartitionKey:  to.Ptr("key"),
+		CorrelationID: to.Ptr("corr"),
+		ContentType:   to.Ptr("application/json"),
+		ApplicationProperties: map[string]any{
+			"type":   "OrderCreated",
+			"tenant": "x",
+		},
+	}
+	record := MessageToKafkaRecord(received, "orders")
+	g.Expect(record.Topic).To(Equal("orders"))
+	g.Expect(record.Key).To(Equal([]byte("key")))
+	g.Expect(record.Value).To(Equal([]byte("body")))
+	g.Expect(record.Headers).To(Equal([]KafkaHeader{
+		{Key: "messageId", Value: []byte("id")},
+		{Key: "correlationId", Value: []byte("corr")},
+		{Key: "contentType", Value: []byte("application/json")},
+		{Key: "sb-property-tenant", Value: []byte("x")},
+		{Key: "sb-property-type", Value: []byte("OrderCreated")},
+	}))
+
+	msg := KafkaRecordToMessage(record)
+	g.Expect(*msg.MessageID).To(Equal("id"))
+	g.Expect(*msg.PartitionKey).To(Equal("key"))
+	g.Expect(*msg.CorrelationID).To(Equal("corr"))
+	g.Expect(*msg.ContentType).To(Equal("application/json"))
+	g.Expect(msg.Subject).To(BeNil())
+	g.Expect(msg.Body).To(Equal([]byte("body")))
+	g.Expect(msg.ApplicationProperties).To(Equal(map[string]any{"type": "OrderCreated", "tenant": "x"}))
+}
+
+func TestKafkaRecord_PropertiesDoNotCollideWithSystemHeaders(t *testing.T) {
+	g := NewWithT(t)
+	received := &azservicebus.ReceivedMessage{
+		ApplicationProperties: map[string]any{"messageId": "from-property", "subject": "from-property"},
+	}
+	record := MessageToKafkaRecord(received, "orders")
+	g.Expect(record.Headers).To(Equal([]KafkaHeader{
+		{Key: "sb-property-messageId", Value: []byte("from-property")},
+		{Key: "sb-property-subject", Value: []byte("from-property")},
+	}), "the empty message id is skipped")
+
+	msg := KafkaRecordToMessage(record)
+	g.Expect(msg.MessageID).To(BeNil())
+	g.Expect(msg.Subject).To(BeNil())
+	g.Expect(msg.ApplicationProperties).To(Equal(map[string]any{"messageId": "from-property", "subject": "from-property"}))
+
+	// the headers of other producers are kept as they are
+	msg = KafkaRecordToMessage(KafkaRecord{Headers: []KafkaHeader{{Key: "traceparent", Value: []byte("00-1")}}})
+	g.Expect(msg.ApplicationProperties).To(Equal(map[string]any{"traceparent": "00-1"}))
+}
+
+func TestMessageToKafkaRecord_SessionIDKey(t *testing.T) {
+	g := NewWithT(t)
+	record := MessageToKafkaRecord(&azservicebus.ReceivedMessage{SessionID: to.Ptr("session")}, "t")
+	g.Expect(record.Key).To(Equal([]byte("session")))
+	record = MessageToKafkaRecord(&azservicebus.ReceivedMessage{}, "t")
+	g.Expect(record.Key).To(BeNil())
+}
+
+func TestKafkaForwardingHandler(t *testing.T) {
+	g := NewWithT(t)
+	var produced []KafkaRecord
+	producer := KafkaProducerFunc(func(_ context.Context, records ...KafkaRecord) error {
+		produced = append(produced, records...)
+		return nil
+	})
+	settler := &fakeSettler{}
+	NewKafkaForwardingHandler(producer, "orders", nil).Handle(context.Background(), settler, &azservicebus.ReceivedMessage{})
+	g.Expect(produced).To(HaveLen(1))
+	g.Expect(settler.completed).To(BeTrue())
+
+	failing := KafkaProducerFunc(func(_ context.Context, _ ...KafkaRecord) error { return fmt.Errorf("produce failure") })
+	settler = &fakeSettler{}
+	NewKafkaForwardingHandler(failing, "orders", &KafkaForwardingOptions{
+		ManagedSettlingOptions: &shuttle.ManagedSettlingOptions{
+			RetryDelayStrategy: &shuttle.ConstantDelayStrategy{Delay: time.Millisecond},
+		},
+	}).Handle(context.Background(), settler, &azservicebus.ReceivedMessage{})
+	g.Expect(settler.abandoned).To(BeTrue())
+}
+
+func TestKafkaRecordHandler(t *testing.T) {
+	g := NewWithT(t)
+	sender := &fakeMessageSender{}
+	handle := NewKafkaRecordHandler(sender)
+	g.Expect(handle(context.Background(), KafkaRecord{Value: []byte("v")})).To(Succeed())
+	g.Expect(sender.sent).To(HaveLen(1))
+
+	sender.err = fmt.Errorf("send failure")
+	g.Expect(handle(context.Background(), KafkaRecord{Topic: "t"})).To(MatchError(sender.err))
+}
@@ -0,0 +1,297 @@
+package integrations
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+
+	"github.com/Azure/go-shuttle/v2"
+)
+
+// Headers of the webhook requests.
+const (
+	// WebhookSignatureHeader carries the HMAC-SHA256 signature of the request, as "sha256=<hex>".
+	WebhookSignatureHeader = "X-Shuttle-Signature"
+	// WebhookTimestampHeader carries the unix time of the request, in seconds, which is part of the signed payload.
+	WebhookTimestampHeader = "X-Shuttle-Timestamp"
+	// WebhookMessageIDHeader carries the message id, for the receivers to deduplicate the redeliveries.
+	WebhookMessageIDHeader = "X-Shuttle-Message-Id"
+	// WebhookDeliveryCountHeader carries the delivery count of the message.
+	WebhookDeliveryCountHeader = "X-Shuttle-Delivery-Count"
+)
+
+const (
+	defaultWebhookMaxAttempts = 3
+	defaultWebhookTimeout     = 10 * time.Second
+)
+
+// WebhookEndpoint is a webhook the messages are posted to.
+type WebhookEndpoint struct {
+	// URL of the webhook.
+	URL string
+	// Secret signs the requests with HMAC-SHA256 when set. See VerifyWebhookSignature.
+	Secret []byte
+	// Headers are added to the requests, for example an API key.
+	Headers map[string]string
+	// MaxAttempts is the number of attempts of a delivery before the message is abandoned. Defaults to 3.
+	MaxAttempts int
+	// Backoff is the delay between the attempts. Defaults to an exponential backoff from 1 second up to 30 seconds.
+	// The Retry-After header of the 429 and 503 responses takes precedence.
+	Backoff shuttle.Backoff
+	// Timeout is the timeout of an attempt. Defaults to 10 seconds.
+	Timeout time.Duration
+}
+
+// WebhookOptions configures NewWebhookHandler.
+type WebhookOptions struct {
+	// Client sends the requests. Defaults to http.DefaultClient.
+	Client *http.Client
+	// OnError is called with the errors of the settlements of the messages. Optional.
+	OnError func(ctx context.Context, message *azservicebus.ReceivedMessage, err error)
+}
+
+// WebhookError is the error of a delivery to an endpoint.
+type WebhookError struct {
+	URL string
+	// StatusCode is the status of the last response, 0 when no response was received.
+	StatusCode int
+	// Permanent is true when the endpoint rejected the message with a 4xx status,
+	// other than 401, 403, 404, 408 and 429 which are usually transient misconfigurations or throttling.
+	Permanent bool
+	Err       error
+}
+
+func (e *WebhookError) Error() string {
+	if e.StatusCode != 0 {
+		return fmt.Sprintf("webhook %s answered %d: %s", e.URL, e.StatusCode, e.Err)
+	}
+	return fmt.Sprintf("webhook %s failed: %s", e.URL, e.Err)
+}
+
+func (e *WebhookError) Unwrap() error {
+	return e.Err
+}
+
+// NewWebhookHandler creates a handler posting the body of the messages to the endpoints, concurrently,
+// with the content type of the message. The settlement follows the HTTP outcome:
+//   - the message is completed when all the endpoints answered with a 2xx status.
+//   - it is dead-lettered when an endpoint rejected it with a 4xx status, other than 401, 403, 404, 408 and 429,
+//     as retrying cannot succeed.
+//   - it is abandoned when an endpoint still fails after its MaxAttempts, to be redelivered to all the endpoints.
+//     The endpoints should deduplicate the messages on the WebhookMessageIDHeader.
+//
+// It returns an error when the URL of an endpoint is not an absolute http or https URL.
+func NewWebhookHandler(endpoints []WebhookEndpoint, options *WebhookOptions) (shuttle.HandlerFunc, error) {
+	opts := WebhookOptions{}
+	if options != nil {
+		opts = *options
+	}
+	if opts.Client == nil {
+		opts.Client = http.DefaultClient
+	}
+	dispatchers := make([]*webhookDispatcher, 0, len(endpoints))
+	for _, endpoint := range endpoints {
+		u, err := url.Parse(endpoint.URL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid webhook URL %q: %w", endpoint.URL, err)
+		}
+		if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid webhook URL %q: must be an absolute http or https URL", endpoint.URL)
+		}
+		dispatchers = append(dispatchers, newWebhookDispatcher(endpoint, opts))
+	}
+	onError := func(ctx context.Context, message *azservicebus.ReceivedMessage, err error) {
+		if err != nil && opts.OnError != nil {
+			opts.OnError(ctx, message, err)
+		}
+	}
+	return func(ctx context.Context, settler shuttle.MessageSettler, message *azservicebus.ReceivedMessage) {
+		errs := make([]error, len(dispatchers))
+		var wg sync.WaitGroup
+		for i, dispatcher := range dispatchers {
+			wg.Add(1)
+			go func(i int, dispatcher *webhookDispatcher) {
+				defer wg.Done()
+				errs[i] = dispatcher.dispatch(ctx, message)
+			}(i, dispatcher)
+		}
+		wg.Wait()
+		err := errors.Join(errs...)
+		if err == nil {
+			if err := settler.CompleteMessage(ctx, message, nil); err != nil {
+				onError(ctx, message, fmt.Errorf("failed to complete message: %w", err))
+			}
+			return
+		}
+		for _, e := range errs {
+			var webhookErr *WebhookError
+			if errors.As(e, &webhookErr) && webhookErr.Permanent {
+				if err := settler.DeadLetterMessage(ctx, message, &azservicebus.DeadLetterOptions{
+					Reason:           to.Ptr("WebhookRejected"),
+					ErrorDescription: to.Ptr(err.Error()),
+				}); err != nil {
+					onError(ctx, message, fmt.Errorf("failed to dead-letter message: %w", err))
+				}
+				return
+			}
+		}
+		if err := settler.AbandonMessage(ctx, message, nil); err != nil {
+			onError(ctx, message, fmt.Errorf("failed to abandon message: %w", err))
+		}
+	}, nil
+}
+
+// SignWebhookPayload returns the signature of the body sent at the timestamp, as set in the WebhookSignatureHeader.
+func SignWebhookPayload(secret []byte, timestamp time.Time, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(strconv.FormatInt(timestamp.Unix(), 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhookSignature checks the signature of a webhook request, for the receivers written in Go.
+// The timestamp is the value of the WebhookTimestampHeader. The receivers should also reject the old timestamps,
+// to prevent the replay of the requests.
+func VerifyWebhookSignature(secret []byte, timestamp string, body []byte, signature string) bool {
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	expected := SignWebhookPayload(secret, time.Unix(seconds, 0), body)
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
+
+type webhookDispatcher struct {
+	endpoint WebhookEndpoint
+	client   *http.Client
+}
+
+func newWebhookDispatcher(endpoint WebhookEndpoint, options WebhookOptions) *webhookDispatcher {
+	if endpoint.MaxAttempts <= 0 {
+		endpoint.MaxAttempts = defaultWebhookMaxAttempts
+	}
+	if endpoint.Backoff == nil {
+		endpoint.Backoff = shuttle.ExponentialBackoff{Initial: time.Second, Max: 30 * time.Second}
+	}
+	if endpoint.Timeout <= 0 {
+		endpoint.Timeout = defaultWebhookTimeout
+	}
+	return &webhookDispatcher{endpoint: endpoint, client: options.Client}
+}
+
+// dispatch posts the message until the endpoint accepts it, rejects it, or MaxAttempts is reached.
+// The delay before a retry is capped at the time left before the context deadline or the lock of the message expires.
+func (d *webhookDispatcher) dispatch(ctx context.Context, message *azservicebus.ReceivedMessage) error {
+	for attempt := 1; ; attempt++ {
+		retryAfter, err := d.post(ctx, message)
+		if err == nil {
+			return nil
+		}
+		var webhookErr *WebhookError
+		if errors.As(err, &webhookErr) && webhookErr.Permanent || attempt >= d.endpoint.MaxAttempts {
+			return err
+		}
+		delay := d.endpoint.Backoff.Delay(attempt)
+		if retryAfter > 0 {
+			delay = retryAfter
+		}
+		if remaining, ok := remainingTime(ctx, message); ok {
+			if remaining <= 0 {
+				return err
+			}
+			delay = min(delay, remaining)
+		}
+		select {
+		case <-ctx.Done():
+			return &WebhookError{URL: d.endpoint.URL, Err: fmt.Errorf("%w, last attempt: %w", ctx.Err(), err)}
+		case <-time.After(delay):
+		}
+	}
+}
+
+// remainingTime returns the time left before the context deadline or the lock of the message expires, whichever is first.
+func remainingTime(ctx context.Context, message *azservicebus.ReceivedMessage) (time.Duration, bool) {
+	var until time.Time
+	if deadline, ok := ctx.Deadline(); ok {
+		until = deadline
+	}
+	if message.LockedUntil != nil && (until.IsZero() || message.LockedUntil.Before(until)) {
+		until = *message.LockedUntil
+	}
+	if until.IsZero() {
+		return 0, false
+	}
+	return time.Until(until), true
+}
+
+// post sends one attempt. It returns the delay of the Retry-After header of the 429 and 503 responses.
+func (d *webhookDispatcher) post(ctx context.Context, message *azservicebus.ReceivedMessage) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, d.endpoint.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.endpoint.URL, bytes.NewReader(message.Body))
+	if err != nil {
+		return 0, &WebhookError{URL: d.endpoint.URL, Permanent: true, Err: fmt.Errorf("failed to create request: %w", err)}
+	}
+	for key, value := range d.endpoint.Headers {
+		req.Header.Set(key, value)
+	}
+	if message.ContentType != nil {
+		req.Header.Set("Content-Type", *message.ContentType)
+	}
+	req.Header.Set(WebhookMessageIDHeader, message.MessageID)
+	req.Header.Set(WebhookDeliveryCountHeader, strconv.FormatUint(uint64(message.DeliveryCount), 10))
+	if len(d.endpoint.Secret) > 0 {
+		now := time.Now()
+		req.Header.Set(WebhookTimestampHeader, strconv.FormatInt(now.Unix(), 10))
+		req.Header.Set(WebhookSignatureHeader, SignWebhookPayload(d.endpoint.Secret, now, message.Body))
+	}
+	res, err := d.client.Do(req)
+	if err != nil {
+		return 0, &WebhookError{URL: d.endpoint.URL, Err: err}
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return 0, nil
+	}
+	webhookErr := &WebhookError{
+		URL:        d.endpoint.URL,
+		StatusCode: res.StatusCode,
+		Permanent:  isPermanentStatus(res.StatusCode),
+		Err:        errors.New(http.StatusText(res.StatusCode)),
+	}
+	// the start of the body usually describes the error
+	if body, _ := io.ReadAll(io.LimitReader(res.Body, 1024)); len(bytes.TrimSpace(body)) > 0 {
+		webhookErr.Err = errors.New(string(bytes.TrimSpace(body)))
+	}
+	var retryAfter time.Duration
+	if res.StatusCode == http.StatusTooManyRequests || res.StatusCode == http.StatusServiceUnavailable {
+		if seconds, err := strconv.Atoi(res.Header.Get("Retry-After")); err == nil && seconds > 0 {
+			retryAfter = time.Duration(seconds) * time.Second
+		}
+	}
+	return retryAfter, webhookErr
+}
+
+// isPermanentStatus returns true for the 4xx statuses, other than the ones resolved without changing the message:
+// the authentication and routing errors fixed by the endpoint, the timeouts and the throttling.
+func isPermanentStatus(status int) bool {
+	switch status {
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound, http.StatusRequestTimeout, http.StatusTooManyRequests:
+		return false
+	}
+	return status >= 400 && status < 500
+}
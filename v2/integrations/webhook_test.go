@@ -0,0 +1,166 @@
+package integrations_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	. "github.com/onsi/gomega"
+
+	"github.com/Azure/go-shuttle/v2"
+	"github.com/Azure/go-shuttle/v2/integrations"
+	"github.com/Azure/go-shuttle/v2/shuttletest"
+)
+
+// webhookServer answers with the statuses in order, then with the last one.
+func webhookServer(t *testing.T, statuses ...int) (*httptest.Server, *atomic.Int32) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		call := int(calls.Add(1))
+		w.WriteHeader(statuses[min(call, len(statuses))-1])
+	}))
+	t.Cleanup(server.Close)
+	return server, &calls
+}
+
+func TestWebhookHandler_PostsSignedMessage(t *testing.T) {
+	g := NewWithT(t)
+	secret := []byte("s3cr3t")
+	received := make(chan *http.Request, 1)
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = io.ReadAll(r.Body)
+		received <- r
+	}))
+	defer server.Close()
+
+	handler, err := integrations.NewWebhookHandler([]integrations.WebhookEndpoint{
+		{URL: server.URL, Secret: secret, Headers: map[string]string{"X-Api-Key": "key"}},
+	}, nil)
+	g.Expect(err).ToNot(HaveOccurred())
+	settler := shuttletest.NewRecordingSettler()
+	msg := &azservicebus.ReceivedMessage{
+		MessageID:     "order-1",
+		Body:          []byte(`{"id":1}`),
+		ContentType:   to.Ptr("application/json"),
+		DeliveryCount: 2,
+	}
+	handler.Handle(context.Background(), settler, msg)
+
+	g.Expect(settler.Completed()).To(HaveLen(1))
+	r := <-received
+	g.Expect(body).To(Equal(msg.Body))
+	g.Expect(r.Header.Get("Content-Type")).To(Equal("application/json"))
+	g.Expect(r.Header.Get("X-Api-Key")).To(Equal("key"))
+	g.Expect(r.Header.Get(integrations.WebhookMessageIDHeader)).To(Equal("order-1"))
+	g.Expect(r.Header.Get(integrations.WebhookDeliveryCountHeader)).To(Equal("2"))
+	g.Expect(integrations.VerifyWebhookSignature(secret, r.Header.Get(integrations.WebhookTimestampHeader),
+		body, r.Header.Get(integrations.WebhookSignatureHeader))).To(BeTrue())
+	g.Expect(integrations.VerifyWebhookSignature([]byte("other"), r.Header.Get(integrations.WebhookTimestampHeader),
+		body, r.Header.Get(integrations.WebhookSignatureHeader))).To(BeFalse())
+}
+
+func TestWebhookHandler_Settlement(t *testing.T) {
+	testCases := []struct {
+		name             string
+		statuses         []int
+		expectCalls      int32
+		expectComplete   int
+		expectAbandon    int
+		expectDeadLetter int
+	}{
+		{name: "completes after a retry", statuses: []int{http.StatusBadGateway, http.StatusOK}, expectCalls: 2, expectComplete: 1},
+		{name: "retries throttled requests", statuses: []int{http.StatusTooManyRequests, http.StatusAccepted}, expectCalls: 2, expectComplete: 1},
+		{name: "abandons after the max attempts", statuses: []int{http.StatusInternalServerError}, expectCalls: 3, expectAbandon: 1},
+		{name: "dead-letters rejected messages", statuses: []int{http.StatusBadRequest}, expectCalls: 1, expectDeadLetter: 1},
+		{name: "retries unauthorized requests", statuses: []int{http.StatusUnauthorized}, expectCalls: 3, expectAbandon: 1},
+		{name: "retries forbidden requests", statuses: []int{http.StatusForbidden}, expectCalls: 3, expectAbandon: 1},
+		{name: "retries unknown routes", statuses: []int{http.StatusNotFound, http.StatusOK}, expectCalls: 2, expectComplete: 1},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+			server, calls := webhookServer(t, tc.statuses...)
+			handler, err := integrations.NewWebhookHandler([]integrations.WebhookEndpoint{
+				{URL: server.URL, Backoff: shuttle.ConstantBackoff(time.Millisecond)},
+			}, nil)
+			g.Expect(err).ToNot(HaveOccurred())
+			settler := shuttletest.NewRecordingSettler()
+			handler.Handle(context.Background(), settler, &azservicebus.ReceivedMessage{MessageID: "id"})
+			g.Expect(calls.Load()).To(Equal(tc.expectCalls))
+			g.Expect(settler.Completed()).To(HaveLen(tc.expectComplete))
+			g.Expect(settler.Abandoned()).To(HaveLen(tc.expectAbandon))
+			g.Expect(settler.DeadLettered()).To(HaveLen(tc.expectDeadLetter))
+		})
+	}
+}
+
+func TestWebhookHandler_CompletesOnlyWhenAllEndpointsSucceed(t *testing.T) {
+	g := NewWithT(t)
+	ok, _ := webhookServer(t, http.StatusOK)
+	failing, calls := webhookServer(t, http.StatusServiceUnavailable)
+	handler, err := integrations.NewWebhookHandler([]integrations.WebhookEndpoint{
+		{URL: ok.URL},
+		{URL: failing.URL, MaxAttempts: 2, Backoff: shuttle.ConstantBackoff(time.Millisecond)},
+	}, nil)
+	g.Expect(err).ToNot(HaveOccurred())
+	settler := shuttletest.NewRecordingSettler()
+	handler.Handle(context.Background(), settler, &azservicebus.ReceivedMessage{MessageID: "id"})
+	g.Expect(calls.Load()).To(Equal(int32(2)))
+	g.Expect(settler.Completed()).To(BeEmpty())
+	g.Expect(settler.Abandoned()).To(HaveLen(1))
+}
+
+func TestWebhookHandler_InvalidURL(t *testing.T) {
+	g := NewWithT(t)
+	for _, u := range []string{"", "example.com/hook", "ftp://example.com", "http://%zz"} {
+		_, err := integrations.NewWebhookHandler([]integrations.WebhookEndpoint{{URL: u}}, nil)
+		g.Expect(err).To(MatchError(ContainSubstring("invalid webhook URL")), u)
+	}
+}
+
+func TestWebhookHandler_RetryAfterCappedAtLock(t *testing.T) {
+	g := NewWithT(t)
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Header().Set("Retry-After", "3600")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	handler, err := integrations.NewWebhookHandler([]integrations.WebhookEndpoint{{URL: server.URL, MaxAttempts: 2}}, nil)
+	g.Expect(err).ToNot(HaveOccurred())
+	settler := shuttletest.NewRecordingSettler()
+	start := time.Now()
+	handler.Handle(context.Background(), settler, &azservicebus.ReceivedMessage{MessageID: "id", LockedUntil: to.Ptr(start.Add(100 * time.Millisecond))})
+	g.Expect(time.Since(start)).To(BeNumerically("<", 5*time.Second))
+	g.Expect(calls.Load()).To(Equal(int32(2)))
+	g.Expect(settler.Abandoned()).To(HaveLen(1))
+}
+
+type failingSettler struct {
+	*shuttletest.RecordingSettler
+}
+
+func (s *failingSettler) CompleteMessage(context.Context, *azservicebus.ReceivedMessage, *azservicebus.CompleteMessageOptions) error {
+	return errors.New("lock lost")
+}
+
+func TestWebhookHandler_ReportsSettlementErrors(t *testing.T) {
+	g := NewWithT(t)
+	server, _ := webhookServer(t, http.StatusOK)
+	var reported error
+	handler, err := integrations.NewWebhookHandler([]integrations.WebhookEndpoint{{URL: server.URL}}, &integrations.WebhookOptions{
+		OnError: func(_ context.Context, _ *azservicebus.ReceivedMessage, err error) { reported = err },
+	})
+	g.Expect(err).ToNot(HaveOccurred())
+	handler.Handle(context.Background(), &failingSettler{RecordingSettler: shuttletest.NewRecordingSettler()}, &azservicebus.ReceivedMessage{MessageID: "id"})
+	g.Expect(reported).To(MatchError(ContainSubstring("failed to complete message: lock lost")))
+}
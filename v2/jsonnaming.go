@@ -0,0 +1,220 @@
+package shuttle
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+var (
+	jsonMarshalerType   = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+	textMarshalerType   = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// jsonField is a struct field as encoded by encoding/json: its key, the key it is renamed to, and its type.
+type jsonField struct {
+	key     string
+	renamed string
+	typ     reflect.Type
+}
+
+// namingFields caches the fields of the struct types, keyed by the type and the naming.
+var namingFields sync.Map
+
+type namingFieldsKey struct {
+	typ    reflect.Type
+	naming JSONFieldNaming
+}
+
+// structFields returns the fields of the struct type keyed by their encoding/json key.
+// Only the fields without a name in their json tag are renamed, and the fields of the untagged embedded structs are promoted.
+func structFields(t reflect.Type, naming JSONFieldNaming) []jsonField {
+	key := namingFieldsKey{typ: t, naming: naming}
+	if cached, ok := namingFields.Load(key); ok {
+		return cached.([]jsonField)
+	}
+	var fields []jsonField
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		if field.Anonymous && name == "" {
+			embedded := field.Type
+			if embedded.Kind() == reflect.Pointer {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				fields = append(fields, structFields(embedded, naming)...)
+				continue
+			}
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name != "" {
+			fields = append(fields, jsonField{key: name, renamed: name, typ: field.Type})
+			continue
+		}
+		fields = append(fields, jsonField{key: field.Name, renamed: naming.rename(field.Name), typ: field.Type})
+	}
+	namingFields.Store(key, fields)
+	return fields
+}
+
+// jsonRenamer rewrites the keys of a JSON document for the FieldNaming of a type, guided by the type:
+// only the keys of the untagged struct fields are renamed. The map keys, the order of the keys
+// and the values are kept as they are.
+type jsonRenamer struct {
+	naming     JSONFieldNaming
+	unmarshal  bool
+	escapeHTML bool
+	decoder    *json.Decoder
+	out        *bytes.Buffer
+}
+
+// renameJSONFields renames the keys of data, marshalled from a value of type t, to the naming.
+// When unmarshal is true, it renames the keys of data back to the keys encoding/json expects for t.
+func renameJSONFields(data []byte, t reflect.Type, naming JSONFieldNaming, unmarshal, escapeHTML bool) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	// keep the numbers as they are written, without going through float64
+	decoder.UseNumber()
+	r := &jsonRenamer{naming: naming, unmarshal: unmarshal, escapeHTML: escapeHTML, decoder: decoder, out: &bytes.Buffer{}}
+	if err := r.copyValue(t); err != nil {
+		return nil, err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, fmt.Errorf("invalid JSON: unexpected data after the top-level value")
+	}
+	return r.out.Bytes(), nil
+}
+
+// elemType returns the type to rename the keys of, or nil when the keys are left as-is:
+// the interfaces, and the types encoding themselves.
+func (r *jsonRenamer) elemType(t reflect.Type) reflect.Type {
+	for t != nil && t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() == reflect.Interface {
+		return nil
+	}
+	ptr := reflect.PointerTo(t)
+	if r.unmarshal && (ptr.Implements(jsonUnmarshalerType) || ptr.Implements(textUnmarshalerType)) {
+		return nil
+	}
+	if !r.unmarshal && (ptr.Implements(jsonMarshalerType) || ptr.Implements(textMarshalerType)) {
+		return nil
+	}
+	return t
+}
+
+func (r *jsonRenamer) copyValue(t reflect.Type) error {
+	t = r.elemType(t)
+	token, err := r.decoder.Token()
+	if err != nil {
+		return err
+	}
+	switch value := token.(type) {
+	case json.Delim:
+		if value == '{' {
+			return r.copyObject(t)
+		}
+		return r.copyArray(t)
+	case string:
+		return r.writeString(value)
+	case json.Number:
+		r.out.WriteString(value.String())
+	case bool:
+		fmt.Fprint(r.out, value)
+	case nil:
+		r.out.WriteString("null")
+	}
+	return nil
+}
+
+func (r *jsonRenamer) copyObject(t reflect.Type) error {
+	var fields map[string]jsonField
+	if t != nil && t.Kind() == reflect.Struct {
+		fields = map[string]jsonField{}
+		for _, field := range structFields(t, r.naming) {
+			if r.unmarshal {
+				fields[field.renamed] = jsonField{key: field.renamed, renamed: field.key, typ: field.typ}
+			} else {
+				fields[field.key] = field
+			}
+		}
+	}
+	r.out.WriteByte('{')
+	for i := 0; r.decoder.More(); i++ {
+		token, err := r.decoder.Token()
+		if err != nil {
+			return err
+		}
+		key := token.(string)
+		var valueType reflect.Type
+		switch {
+		case fields != nil:
+			if field, ok := fields[key]; ok {
+				key, valueType = field.renamed, field.typ
+			}
+		case t != nil && t.Kind() == reflect.Map:
+			valueType = t.Elem()
+		}
+		if i > 0 {
+			r.out.WriteByte(',')
+		}
+		if err := r.writeString(key); err != nil {
+			return err
+		}
+		r.out.WriteByte(':')
+		if err := r.copyValue(valueType); err != nil {
+			return err
+		}
+	}
+	if _, err := r.decoder.Token(); err != nil {
+		return err
+	}
+	r.out.WriteByte('}')
+	return nil
+}
+
+func (r *jsonRenamer) copyArray(t reflect.Type) error {
+	var elem reflect.Type
+	if t != nil && (t.Kind() == reflect.Slice || t.Kind() == reflect.Array) {
+		elem = t.Elem()
+	}
+	r.out.WriteByte('[')
+	for i := 0; r.decoder.More(); i++ {
+		if i > 0 {
+			r.out.WriteByte(',')
+		}
+		if err := r.copyValue(elem); err != nil {
+			return err
+		}
+	}
+	if _, err := r.decoder.Token(); err != nil {
+		return err
+	}
+	r.out.WriteByte(']')
+	return nil
+}
+
+func (r *jsonRenamer) writeString(s string) error {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(r.escapeHTML)
+	if err := encoder.Encode(s); err != nil {
+		return err
+	}
+	r.out.Write(bytes.TrimSuffix(buf.Bytes(), []byte("\n")))
+	return nil
+}
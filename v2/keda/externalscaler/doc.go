@@ -0,0 +1,3 @@
+// Package externalscaler contains the gRPC stubs of the KEDA external scaler protocol,
+// generated from the externalscaler.proto file of github.com/kedacore/keda.
+package externalscaler
@@ -0,0 +1,231 @@
+// Package keda implements a KEDA external scaler serving the depth of the Service Bus entities,
+// so that the deployments using go-shuttle can scale on their backlog without installing a separate scaler.
+//
+// The ScaledObject selects the entity with the scaler metadata:
+//
+//	triggers:
+//	- type: external
+//	  metadata:
+//	    scalerAddress: my-scaler.my-namespace:6000
+//	    queueName: orders              # or topicName and subscriptionName
+//	    targetMessageCount: "10"       # optional, defaults to 5
+//	    activationMessageCount: "0"    # optional, defaults to 0
+package keda
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus/admin"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/Azure/go-shuttle/v2"
+	"github.com/Azure/go-shuttle/v2/keda/externalscaler"
+)
+
+// Scaler metadata keys of the ScaledObject trigger.
+const (
+	QueueNameMetadata              = "queueName"
+	TopicNameMetadata              = "topicName"
+	SubscriptionNameMetadata       = "subscriptionName"
+	TargetMessageCountMetadata     = "targetMessageCount"
+	ActivationMessageCountMetadata = "activationMessageCount"
+)
+
+const (
+	defaultTargetMessageCount = 5
+	defaultPollInterval       = 30 * time.Second
+)
+
+// Entity identifies the queue, or the subscription of a topic, of a ScaledObject.
+type Entity struct {
+	Queue        string
+	Topic        string
+	Subscription string
+}
+
+// String returns the queue name, or the topic and subscription names separated by a slash.
+func (e Entity) String() string {
+	if e.Queue != "" {
+		return e.Queue
+	}
+	return e.Topic + "/" + e.Subscription
+}
+
+// ScalerOptions configures the Scaler.
+type ScalerOptions struct {
+	// NewDepthProvider returns the QueueDepthProvider of an entity.
+	// Defaults to shuttle.NewQueueDepth and shuttle.NewSubscriptionDepth with the admin client of the Scaler.
+	NewDepthProvider func(entity Entity) shuttle.QueueDepthProvider
+	// PollInterval is the interval at which StreamIsActive polls the depth of the entity. Defaults to 30 seconds.
+	PollInterval time.Duration
+}
+
+// Scaler is a KEDA external scaler reporting the active message count of the Service Bus entities.
+type Scaler struct {
+	externalscaler.UnimplementedExternalScalerServer
+	options ScalerOptions
+}
+
+// NewScaler creates a Scaler fetching the depth of the entities with the admin client.
+// The client may be nil when ScalerOptions.NewDepthProvider is set.
+func NewScaler(client *admin.Client, options *ScalerOptions) *Scaler {
+	opts := ScalerOptions{}
+	if options != nil {
+		opts = *options
+	}
+	if opts.NewDepthProvider == nil {
+		opts.NewDepthProvider = func(entity Entity) shuttle.QueueDepthProvider {
+			if entity.Queue != "" {
+				return shuttle.NewQueueDepth(client, entity.Queue)
+			}
+			return shuttle.NewSubscriptionDepth(client, entity.Topic, entity.Subscription)
+		}
+	}
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = defaultPollInterval
+	}
+	return &Scaler{options: opts}
+}
+
+// Register registers the Scaler as the ExternalScaler service of the gRPC server.
+func (s *Scaler) Register(server *grpc.Server) {
+	externalscaler.RegisterExternalScalerServer(server, s)
+}
+
+// IsActive returns true when the entity has more active messages than the activationMessageCount.
+func (s *Scaler) IsActive(ctx context.Context, ref *externalscaler.ScaledObjectRef) (*externalscaler.IsActiveResponse, error) {
+	trigger, err := parseTrigger(ref)
+	if err != nil {
+		return nil, err
+	}
+	active, err := s.isActive(ctx, trigger)
+	if err != nil {
+		return nil, err
+	}
+	return &externalscaler.IsActiveResponse{Result: active}, nil
+}
+
+// StreamIsActive polls the entity every PollInterval, and sends the activity when it changes.
+func (s *Scaler) StreamIsActive(ref *externalscaler.ScaledObjectRef, stream externalscaler.ExternalScaler_StreamIsActiveServer) error {
+	trigger, err := parseTrigger(ref)
+	if err != nil {
+		return err
+	}
+	ctx := stream.Context()
+	var last *bool
+	for {
+		active, err := s.isActive(ctx, trigger)
+		if err == nil && (last == nil || *last != active) {
+			if err := stream.Send(&externalscaler.IsActiveResponse{Result: active}); err != nil {
+				return err
+			}
+			last = &active
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(s.options.PollInterval):
+		}
+	}
+}
+
+// GetMetricSpec returns the metric of the entity, with the targetMessageCount as target size.
+func (s *Scaler) GetMetricSpec(_ context.Context, ref *externalscaler.ScaledObjectRef) (*externalscaler.GetMetricSpecResponse, error) {
+	trigger, err := parseTrigger(ref)
+	if err != nil {
+		return nil, err
+	}
+	return &externalscaler.GetMetricSpecResponse{
+		MetricSpecs: []*externalscaler.MetricSpec{{MetricName: trigger.metricName(), TargetSize: trigger.targetMessageCount}},
+	}, nil
+}
+
+// GetMetrics returns the active message count of the entity.
+func (s *Scaler) GetMetrics(ctx context.Context, req *externalscaler.GetMetricsRequest) (*externalscaler.GetMetricsResponse, error) {
+	trigger, err := parseTrigger(req.GetScaledObjectRef())
+	if err != nil {
+		return nil, err
+	}
+	count, err := s.activeMessageCount(ctx, trigger)
+	if err != nil {
+		return nil, err
+	}
+	return &externalscaler.GetMetricsResponse{
+		MetricValues: []*externalscaler.MetricValue{{MetricName: trigger.metricName(), MetricValue: count}},
+	}, nil
+}
+
+func (s *Scaler) isActive(ctx context.Context, trigger trigger) (bool, error) {
+	count, err := s.activeMessageCount(ctx, trigger)
+	if err != nil {
+		return false, err
+	}
+	return count > trigger.activationMessageCount, nil
+}
+
+func (s *Scaler) activeMessageCount(ctx context.Context, trigger trigger) (int64, error) {
+	count, err := s.options.NewDepthProvider(trigger.entity).ActiveMessageCount(ctx)
+	if err != nil {
+		return 0, status.Errorf(codes.Unavailable, "failed to get active message count of %s: %s", trigger.entity, err)
+	}
+	return count, nil
+}
+
+// trigger is the scaler metadata of a ScaledObject.
+type trigger struct {
+	entity                 Entity
+	targetMessageCount     int64
+	activationMessageCount int64
+}
+
+func (t trigger) metricName() string {
+	return "servicebus-" + strings.ReplaceAll(strings.ToLower(t.entity.String()), "/", "-")
+}
+
+func parseTrigger(ref *externalscaler.ScaledObjectRef) (trigger, error) {
+	metadata := ref.GetScalerMetadata()
+	t := trigger{
+		entity: Entity{
+			Queue:        metadata[QueueNameMetadata],
+			Topic:        metadata[TopicNameMetadata],
+			Subscription: metadata[SubscriptionNameMetadata],
+		},
+		targetMessageCount: defaultTargetMessageCount,
+	}
+	switch {
+	case t.entity.Queue != "" && t.entity.Topic == "":
+	case t.entity.Queue == "" && t.entity.Topic != "" && t.entity.Subscription != "":
+	default:
+		return trigger{}, status.Errorf(codes.InvalidArgument, "either %s, or %s and %s, must be set",
+			QueueNameMetadata, TopicNameMetadata, SubscriptionNameMetadata)
+	}
+	var err error
+	if t.targetMessageCount, err = parseCount(metadata, TargetMessageCountMetadata, defaultTargetMessageCount); err != nil {
+		return trigger{}, err
+	}
+	if t.targetMessageCount <= 0 {
+		return trigger{}, status.Errorf(codes.InvalidArgument, "%s must be positive", TargetMessageCountMetadata)
+	}
+	if t.activationMessageCount, err = parseCount(metadata, ActivationMessageCountMetadata, 0); err != nil {
+		return trigger{}, err
+	}
+	return t, nil
+}
+
+func parseCount(metadata map[string]string, key string, defaultValue int64) (int64, error) {
+	value, ok := metadata[key]
+	if !ok || value == "" {
+		return defaultValue, nil
+	}
+	count, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, status.Error(codes.InvalidArgument, fmt.Sprintf("invalid %s %q: %s", key, value, err))
+	}
+	return count, nil
+}
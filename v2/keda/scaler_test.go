@@ -0,0 +1,145 @@
+package keda_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/Azure/go-shuttle/v2"
+	"github.com/Azure/go-shuttle/v2/keda"
+	"github.com/Azure/go-shuttle/v2/keda/externalscaler"
+)
+
+// fakeDepths returns the depth of each entity, keyed by Entity.String.
+func fakeDepths(depths map[string]*atomic.Int64) func(entity keda.Entity) shuttle.QueueDepthProvider {
+	return func(entity keda.Entity) shuttle.QueueDepthProvider {
+		return shuttle.QueueDepthFunc(func(ctx context.Context) (int64, error) {
+			depth, ok := depths[entity.String()]
+			if !ok {
+				return 0, errors.New("entity not found")
+			}
+			return depth.Load(), nil
+		})
+	}
+}
+
+func TestScaler_Metrics(t *testing.T) {
+	g := NewWithT(t)
+	var orders, audit atomic.Int64
+	orders.Store(42)
+	scaler := keda.NewScaler(nil, &keda.ScalerOptions{
+		NewDepthProvider: fakeDepths(map[string]*atomic.Int64{"orders": &orders, "events/audit": &audit}),
+	})
+	queueRef := &externalscaler.ScaledObjectRef{ScalerMetadata: map[string]string{
+		keda.QueueNameMetadata:          "orders",
+		keda.TargetMessageCountMetadata: "10",
+	}}
+
+	spec, err := scaler.GetMetricSpec(context.Background(), queueRef)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(spec.MetricSpecs).To(HaveLen(1))
+	g.Expect(spec.MetricSpecs[0].MetricName).To(Equal("servicebus-orders"))
+	g.Expect(spec.MetricSpecs[0].TargetSize).To(Equal(int64(10)))
+
+	metrics, err := scaler.GetMetrics(context.Background(), &externalscaler.GetMetricsRequest{ScaledObjectRef: queueRef})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(metrics.MetricValues).To(HaveLen(1))
+	g.Expect(metrics.MetricValues[0].MetricValue).To(Equal(int64(42)))
+
+	active, err := scaler.IsActive(context.Background(), queueRef)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(active.Result).To(BeTrue())
+
+	subscriptionRef := &externalscaler.ScaledObjectRef{ScalerMetadata: map[string]string{
+		keda.TopicNameMetadata:        "events",
+		keda.SubscriptionNameMetadata: "audit",
+	}}
+	active, err = scaler.IsActive(context.Background(), subscriptionRef)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(active.Result).To(BeFalse())
+	spec, err = scaler.GetMetricSpec(context.Background(), subscriptionRef)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(spec.MetricSpecs[0].MetricName).To(Equal("servicebus-events-audit"))
+	g.Expect(spec.MetricSpecs[0].TargetSize).To(Equal(int64(5)))
+}
+
+func TestScaler_InvalidMetadata(t *testing.T) {
+	testCases := []struct {
+		name     string
+		metadata map[string]string
+		code     codes.Code
+	}{
+		{name: "no entity", metadata: map[string]string{}, code: codes.InvalidArgument},
+		{name: "topic without subscription", metadata: map[string]string{keda.TopicNameMetadata: "events"}, code: codes.InvalidArgument},
+		{
+			name:     "queue and topic",
+			metadata: map[string]string{keda.QueueNameMetadata: "orders", keda.TopicNameMetadata: "events"},
+			code:     codes.InvalidArgument,
+		},
+		{
+			name:     "invalid target",
+			metadata: map[string]string{keda.QueueNameMetadata: "orders", keda.TargetMessageCountMetadata: "many"},
+			code:     codes.InvalidArgument,
+		},
+		{
+			name:     "zero target",
+			metadata: map[string]string{keda.QueueNameMetadata: "orders", keda.TargetMessageCountMetadata: "0"},
+			code:     codes.InvalidArgument,
+		},
+		{name: "unknown entity", metadata: map[string]string{keda.QueueNameMetadata: "unknown"}, code: codes.Unavailable},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+			scaler := keda.NewScaler(nil, &keda.ScalerOptions{NewDepthProvider: fakeDepths(nil)})
+			_, err := scaler.IsActive(context.Background(), &externalscaler.ScaledObjectRef{ScalerMetadata: tc.metadata})
+			g.Expect(status.Code(err)).To(Equal(tc.code))
+		})
+	}
+}
+
+func TestScaler_StreamIsActiveOverGRPC(t *testing.T) {
+	g := NewWithT(t)
+	var orders atomic.Int64
+	scaler := keda.NewScaler(nil, &keda.ScalerOptions{
+		NewDepthProvider: fakeDepths(map[string]*atomic.Int64{"orders": &orders}),
+		PollInterval:     5 * time.Millisecond,
+	})
+	listener := bufconn.Listen(1024 * 1024)
+	server := grpc.NewServer()
+	scaler.Register(server)
+	go func() { _ = server.Serve(listener) }()
+	defer server.Stop()
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	g.Expect(err).ToNot(HaveOccurred())
+	defer conn.Close()
+	client := externalscaler.NewExternalScalerClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream, err := client.StreamIsActive(ctx, &externalscaler.ScaledObjectRef{
+		ScalerMetadata: map[string]string{keda.QueueNameMetadata: "orders"},
+	})
+	g.Expect(err).ToNot(HaveOccurred())
+	res, err := stream.Recv()
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(res.Result).To(BeFalse())
+
+	orders.Store(3)
+	res, err = stream.Recv()
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(res.Result).To(BeTrue())
+}
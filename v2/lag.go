@@ -0,0 +1,165 @@
+package shuttle
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus/admin"
+
+	"github.com/Azure/go-shuttle/v2/metrics/processor"
+)
+
+const defaultLagPollInterval = 30 * time.Second
+
+// QueueDepthProvider returns the number of active messages waiting in the queue or subscription.
+type QueueDepthProvider interface {
+	ActiveMessageCount(ctx context.Context) (int64, error)
+}
+
+// QueueDepthFunc allows to use a func as a QueueDepthProvider.
+type QueueDepthFunc func(ctx context.Context) (int64, error)
+
+func (f QueueDepthFunc) ActiveMessageCount(ctx context.Context) (int64, error) {
+	return f(ctx)
+}
+
+// NewQueueDepth fetches the active message count of the queue from its runtime properties with the admin client.
+func NewQueueDepth(client *admin.Client, queue string) QueueDepthProvider {
+	return QueueDepthFunc(func(ctx context.Context) (int64, error) {
+		res, err := client.GetQueueRuntimeProperties(ctx, queue, nil)
+		if err != nil {
+			return 0, fmt.Errorf("failed to get queue runtime properties: %w", err)
+		}
+		if res == nil {
+			return 0, fmt.Errorf("failed to get queue runtime properties: queue %s not found", queue)
+		}
+		return int64(res.ActiveMessageCount), nil
+	})
+}
+
+// NewSubscriptionDepth fetches the active message count of the subscription from its runtime properties with the admin client.
+func NewSubscriptionDepth(client *admin.Client, topic, subscription string) QueueDepthProvider {
+	return QueueDepthFunc(func(ctx context.Context) (int64, error) {
+		res, err := client.GetSubscriptionRuntimeProperties(ctx, topic, subscription, nil)
+		if err != nil {
+			return 0, fmt.Errorf("failed to get subscription runtime properties: %w", err)
+		}
+		if res == nil {
+			return 0, fmt.Errorf("failed to get subscription runtime properties: subscription %s/%s not found", topic, subscription)
+		}
+		return int64(res.ActiveMessageCount), nil
+	})
+}
+
+// LagEstimate is the estimated time to drain the backlog of an entity at the current processing rate.
+type LagEstimate struct {
+	// ActiveMessageCount is the number of messages waiting in the entity.
+	ActiveMessageCount int64
+	// ProcessingRate is the number of messages handled per second since the previous estimate.
+	ProcessingRate float64
+	// TimeToDrain is the time needed to handle the waiting messages at the ProcessingRate.
+	// It is 0 when the entity is empty, and not meaningful when Stalled is true or before the second poll.
+	TimeToDrain time.Duration
+	// Stalled is true when messages are waiting but none was handled since the previous estimate.
+	// It is never set by the first poll, which has no previous estimate to measure the processing rate from.
+	Stalled bool
+	// At is the time of the estimate.
+	At time.Time
+}
+
+// LagEstimatorOptions configures the LagEstimator.
+type LagEstimatorOptions struct {
+	// EntityName is the queue or subscription of the processor. It is used to label the estimated_drain_seconds metric.
+	EntityName string
+	// PollInterval is the interval at which Run refreshes the estimate. Defaults to 30 seconds.
+	PollInterval time.Duration
+	// Clock is used to measure the processing rate and wait between polls. Defaults to the system clock.
+	Clock Clock
+}
+
+// LagEstimator estimates the time to drain the backlog of a processor, from the depth of its entity
+// and the number of messages it handled between two polls.
+// The estimate is exposed by LagEstimate and in the estimated_drain_seconds metric, for the autoscalers to act on it.
+type LagEstimator struct {
+	depth     QueueDepthProvider
+	processor *Processor
+	options   LagEstimatorOptions
+
+	mu          sync.Mutex
+	estimate    LagEstimate
+	lastHandled int64
+	lastPoll    time.Time
+}
+
+// NewLagEstimator creates a LagEstimator for the processor, consuming the entity measured by the depth provider.
+func NewLagEstimator(depth QueueDepthProvider, processor *Processor, options *LagEstimatorOptions) *LagEstimator {
+	opts := LagEstimatorOptions{}
+	if options != nil {
+		opts = *options
+	}
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = defaultLagPollInterval
+	}
+	opts.Clock = clockOrDefault(opts.Clock)
+	return &LagEstimator{depth: depth, processor: processor, options: opts}
+}
+
+// LagEstimate returns the latest estimate, or the zero LagEstimate before the first poll.
+func (e *LagEstimator) LagEstimate() LagEstimate {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.estimate
+}
+
+// Run polls every PollInterval until ctx is done. The poll errors are logged, and the previous estimate is kept.
+func (e *LagEstimator) Run(ctx context.Context) error {
+	for {
+		if _, err := e.Poll(ctx); err != nil && ctx.Err() == nil {
+			log(ctx, fmt.Sprintf("failed to estimate lag: %s", err))
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-e.options.Clock.After(e.options.PollInterval):
+		}
+	}
+}
+
+// Poll fetches the depth of the entity and refreshes the estimate.
+// The processing rate is measured from the previous poll: the first poll only records the baseline,
+// and only publishes the estimated_drain_seconds metric for an empty entity, so that a restart does not report a stall.
+func (e *LagEstimator) Poll(ctx context.Context) (LagEstimate, error) {
+	count, err := e.depth.ActiveMessageCount(ctx)
+	if err != nil {
+		return LagEstimate{}, fmt.Errorf("failed to get active message count: %w", err)
+	}
+	now := e.options.Clock.Now()
+	handled := e.processor.Stats().MessagesHandled
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	estimate := LagEstimate{ActiveMessageCount: count, At: now}
+	first := e.lastPoll.IsZero()
+	e.lastHandled, handled = handled, handled-e.lastHandled
+	elapsed := now.Sub(e.lastPoll).Seconds()
+	e.lastPoll = now
+	if !first && elapsed > 0 {
+		estimate.ProcessingRate = float64(handled) / elapsed
+	}
+	switch {
+	case count == 0:
+	case first:
+		// no processing rate yet
+		e.estimate = estimate
+		return estimate, nil
+	case estimate.ProcessingRate > 0:
+		estimate.TimeToDrain = time.Duration(float64(count) / estimate.ProcessingRate * float64(time.Second))
+	default:
+		estimate.Stalled = true
+	}
+	e.estimate = estimate
+	processor.Metric.SetEstimatedDrainTime(e.options.EntityName, estimate.TimeToDrain, estimate.Stalled)
+	return estimate, nil
+}
@@ -0,0 +1,76 @@
+package shuttle
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	. "github.com/onsi/gomega"
+)
+
+// steppingClock is a Clock whose time only moves when advanced by the test.
+type steppingClock struct {
+	systemClock
+	now time.Time
+}
+
+func (c *steppingClock) Now() time.Time {
+	return c.now
+}
+
+func TestLagEstimator_Poll(t *testing.T) {
+	g := NewWithT(t)
+	var depth atomic.Int64
+	depth.Store(100)
+	processor := NewProcessor(NewSourceReceiver(idleSource{}, nil),
+		func(context.Context, MessageSettler, *azservicebus.ReceivedMessage) {}, nil)
+	clock := &steppingClock{now: time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)}
+	estimator := NewLagEstimator(QueueDepthFunc(func(ctx context.Context) (int64, error) {
+		return depth.Load(), nil
+	}), processor, &LagEstimatorOptions{EntityName: "queue", Clock: clock})
+	g.Expect(estimator.LagEstimate()).To(Equal(LagEstimate{}))
+
+	estimate, err := estimator.Poll(context.Background())
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(estimate.ActiveMessageCount).To(Equal(int64(100)))
+	g.Expect(estimate.Stalled).To(BeFalse(), "the first poll has no processing rate to estimate from")
+	g.Expect(estimate.TimeToDrain).To(Equal(time.Duration(0)))
+
+	clock.now = clock.now.Add(10 * time.Second)
+	processor.stats.handled.Add(50)
+	depth.Store(60)
+	estimate, err = estimator.Poll(context.Background())
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(estimate.ProcessingRate).To(Equal(float64(5)))
+	g.Expect(estimate.TimeToDrain).To(Equal(12 * time.Second))
+	g.Expect(estimate.Stalled).To(BeFalse())
+	g.Expect(estimator.LagEstimate()).To(Equal(estimate))
+
+	clock.now = clock.now.Add(10 * time.Second)
+	depth.Store(0)
+	estimate, err = estimator.Poll(context.Background())
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(estimate.TimeToDrain).To(Equal(time.Duration(0)))
+	g.Expect(estimate.Stalled).To(BeFalse())
+
+	clock.now = clock.now.Add(10 * time.Second)
+	depth.Store(10)
+	estimate, err = estimator.Poll(context.Background())
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(estimate.Stalled).To(BeTrue())
+}
+
+func TestLagEstimator_PollError(t *testing.T) {
+	g := NewWithT(t)
+	processor := NewProcessor(NewSourceReceiver(idleSource{}, nil),
+		func(context.Context, MessageSettler, *azservicebus.ReceivedMessage) {}, nil)
+	estimator := NewLagEstimator(QueueDepthFunc(func(ctx context.Context) (int64, error) {
+		return 0, errors.New("unauthorized")
+	}), processor, nil)
+	_, err := estimator.Poll(context.Background())
+	g.Expect(err).To(MatchError(ContainSubstring("unauthorized")))
+	g.Expect(estimator.LagEstimate()).To(Equal(LagEstimate{}))
+}
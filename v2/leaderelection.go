@@ -0,0 +1,190 @@
+package shuttle
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+)
+
+// Lease is an exclusive lock shared by the replicas of a deployment.
+// Acquire returns an error when the lease is held by another replica.
+// Renew must be called before the lease expires to keep it, and Release lets another replica acquire it.
+// A blob lease (azblob lease.BlobClient AcquireLease/RenewLease/ReleaseLease) maps directly to this interface.
+// NewSessionLease implements it with the session lock of a session-enabled control queue.
+type Lease interface {
+	Acquire(ctx context.Context) error
+	Renew(ctx context.Context) error
+	Release(ctx context.Context) error
+}
+
+// LeaderElectionOptions configures the LeaderElector.
+type LeaderElectionOptions struct {
+	// RenewInterval is the interval at which the leader renews the lease. Defaults to 10 seconds.
+	// It must be lower than the lease duration.
+	RenewInterval time.Duration
+	// RetryInterval is the interval at which standby replicas try to acquire the lease. Defaults to 15 seconds.
+	RetryInterval time.Duration
+	// OnLeadershipChange is called when this replica becomes the leader or loses the leadership.
+	OnLeadershipChange func(ctx context.Context, isLeader bool)
+	// Clock schedules the renewals and retries. Defaults to the system clock.
+	Clock Clock
+}
+
+// LeaderElector runs a func only while this replica holds the lease,
+// so that a single replica of a deployment actively runs a processor while the others stand by.
+type LeaderElector struct {
+	lease    Lease
+	options  LeaderElectionOptions
+	isLeader atomic.Bool
+}
+
+// NewLeaderElector creates a LeaderElector competing for the lease.
+func NewLeaderElector(lease Lease, options *LeaderElectionOptions) *LeaderElector {
+	opts := LeaderElectionOptions{}
+	if options != nil {
+		opts = *options
+	}
+	if opts.RenewInterval <= 0 {
+		opts.RenewInterval = 10 * time.Second
+	}
+	if opts.RetryInterval <= 0 {
+		opts.RetryInterval = 15 * time.Second
+	}
+	opts.Clock = clockOrDefault(opts.Clock)
+	return &LeaderElector{lease: lease, options: opts}
+}
+
+// IsLeader returns true while this replica holds the lease.
+func (e *LeaderElector) IsLeader() bool {
+	return e.isLeader.Load()
+}
+
+// Run blocks until the context is canceled, or run returns an error while this replica is the leader.
+// run is started every time the lease is acquired, typically with the processor Start func:
+//
+//	elector.Run(ctx, processor.Start)
+//
+// The context passed to run is canceled when the lease cannot be renewed. The replica then waits for run to return,
+// and goes back to standby. The lease is released when Run returns.
+func (e *LeaderElector) Run(ctx context.Context, run func(ctx context.Context) error) error {
+	for {
+		if err := e.lease.Acquire(ctx); err != nil {
+			log(ctx, fmt.Sprintf("standing by, failed to acquire lease: %s", err))
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-e.options.Clock.After(e.options.RetryInterval):
+				continue
+			}
+		}
+		err := e.lead(ctx, run)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// lead runs the func while renewing the lease. It returns nil when the leadership is lost.
+func (e *LeaderElector) lead(ctx context.Context, run func(ctx context.Context) error) error {
+	e.setLeader(ctx, true)
+	defer e.setLeader(ctx, false)
+	defer func() {
+		// the parent context may be canceled, release the lease regardless.
+		if err := e.lease.Release(context.Background()); err != nil {
+			log(ctx, fmt.Sprintf("failed to release lease: %s", err))
+		}
+	}()
+
+	leaderCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var runErr error
+	runDone := make(chan struct{})
+	go func() {
+		defer close(runDone)
+		runErr = run(leaderCtx)
+	}()
+
+	for {
+		select {
+		case <-runDone:
+			if leaderCtx.Err() != nil {
+				return nil
+			}
+			return runErr
+		case <-ctx.Done():
+			<-runDone
+			return nil
+		case <-e.options.Clock.After(e.options.RenewInterval):
+			if err := e.lease.Renew(ctx); err != nil {
+				log(ctx, fmt.Sprintf("lost leadership, failed to renew lease: %s", err))
+				cancel()
+				<-runDone
+				return nil
+			}
+		}
+	}
+}
+
+func (e *LeaderElector) setLeader(ctx context.Context, isLeader bool) {
+	e.isLeader.Store(isLeader)
+	if e.options.OnLeadershipChange != nil {
+		e.options.OnLeadershipChange(ctx, isLeader)
+	}
+}
+
+// sessionLock is satisfied by *azservicebus.SessionReceiver
+type sessionLock interface {
+	RenewSessionLock(ctx context.Context, options *azservicebus.RenewSessionLockOptions) error
+	Close(ctx context.Context) error
+}
+
+var _ Lease = &sessionLease{}
+
+// sessionLease holds the lock of a session on a session-enabled control queue.
+// Service bus grants the lock of a session to a single receiver at a time.
+type sessionLease struct {
+	accept func(ctx context.Context) (sessionLock, error)
+	lock   sessionLock
+}
+
+// NewSessionLease creates a Lease backed by the lock of the session sessionID on the session-enabled queue.
+// The queue is only used for the session lock: no message needs to be sent to it.
+// The renew interval must be lower than the lock duration of the queue.
+func NewSessionLease(client *azservicebus.Client, queue, sessionID string) Lease {
+	return &sessionLease{
+		accept: func(ctx context.Context) (sessionLock, error) {
+			return client.AcceptSessionForQueue(ctx, queue, sessionID, nil)
+		},
+	}
+}
+
+func (l *sessionLease) Acquire(ctx context.Context) error {
+	lock, err := l.accept(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to accept session: %w", err)
+	}
+	l.lock = lock
+	return nil
+}
+
+func (l *sessionLease) Renew(ctx context.Context) error {
+	if l.lock == nil {
+		return fmt.Errorf("session lease is not acquired")
+	}
+	return l.lock.RenewSessionLock(ctx, nil)
+}
+
+func (l *sessionLease) Release(ctx context.Context) error {
+	if l.lock == nil {
+		return nil
+	}
+	lock := l.lock
+	l.lock = nil
+	return lock.Close(ctx)
+}
@@ -0,0 +1,171 @@
+package shuttle
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	. "github.com/onsi/gomega"
+)
+
+type fakeLease struct {
+	mu         sync.Mutex
+	acquireErr error
+	renewErr   error
+	acquired   int
+	renewed    int
+	released   int
+}
+
+func (l *fakeLease) Acquire(_ context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.acquireErr != nil {
+		return l.acquireErr
+	}
+	l.acquired++
+	return nil
+}
+
+func (l *fakeLease) Renew(_ context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.renewed++
+	return l.renewErr
+}
+
+func (l *fakeLease) Release(_ context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.released++
+	return nil
+}
+
+func (l *fakeLease) set(fn func(l *fakeLease)) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	fn(l)
+}
+
+func (l *fakeLease) get(fn func(l *fakeLease) int) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return fn(l)
+}
+
+var testLeaderElectionOptions = &LeaderElectionOptions{
+	RenewInterval: 5 * time.Millisecond,
+	RetryInterval: 5 * time.Millisecond,
+}
+
+func TestLeaderElector_RunsWhileLeader(t *testing.T) {
+	g := NewWithT(t)
+	lease := &fakeLease{}
+	elector := NewLeaderElector(lease, testLeaderElectionOptions)
+	ctx, cancel := context.WithCancel(context.Background())
+	started := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- elector.Run(ctx, func(ctx context.Context) error {
+			close(started)
+			<-ctx.Done()
+			return ctx.Err()
+		})
+	}()
+	<-started
+	g.Expect(elector.IsLeader()).To(BeTrue())
+	g.Eventually(func() int { return lease.get(func(l *fakeLease) int { return l.renewed }) }).Should(BeNumerically(">", 1))
+	cancel()
+	g.Expect(<-done).To(MatchError(context.Canceled))
+	g.Expect(elector.IsLeader()).To(BeFalse())
+	g.Expect(lease.released).To(Equal(1))
+}
+
+func TestLeaderElector_StandsByUntilAcquired(t *testing.T) {
+	g := NewWithT(t)
+	lease := &fakeLease{acquireErr: errors.New("leased by another replica")}
+	var changes []bool
+	var mu sync.Mutex
+	options := *testLeaderElectionOptions
+	options.OnLeadershipChange = func(_ context.Context, isLeader bool) {
+		mu.Lock()
+		defer mu.Unlock()
+		changes = append(changes, isLeader)
+	}
+	elector := NewLeaderElector(lease, &options)
+	runErr := errors.New("processor failed")
+	done := make(chan error)
+	go func() {
+		done <- elector.Run(context.Background(), func(ctx context.Context) error {
+			return runErr
+		})
+	}()
+	time.Sleep(20 * time.Millisecond)
+	g.Expect(elector.IsLeader()).To(BeFalse())
+	lease.set(func(l *fakeLease) { l.acquireErr = nil })
+	g.Eventually(done).Should(Receive(Equal(runErr)))
+	g.Expect(lease.acquired).To(Equal(1))
+	g.Expect(lease.released).To(Equal(1))
+	g.Expect(changes).To(Equal([]bool{true, false}))
+}
+
+func TestLeaderElector_StepsDownWhenRenewalFails(t *testing.T) {
+	g := NewWithT(t)
+	lease := &fakeLease{renewErr: errors.New("lease lost")}
+	elector := NewLeaderElector(lease, testLeaderElectionOptions)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	runs := make(chan struct{}, 10)
+	go func() {
+		_ = elector.Run(ctx, func(ctx context.Context) error {
+			runs <- struct{}{}
+			<-ctx.Done()
+			return ctx.Err()
+		})
+	}()
+	g.Eventually(func() int { return len(runs) }).Should(BeNumerically(">=", 2),
+		"the replica should go back to standby, and run again once it re-acquires the lease")
+	g.Eventually(func() int { return lease.get(func(l *fakeLease) int { return l.released }) }).Should(BeNumerically(">=", 1))
+}
+
+type fakeSessionLock struct {
+	renewed int
+	closed  int
+}
+
+func (f *fakeSessionLock) RenewSessionLock(_ context.Context, _ *azservicebus.RenewSessionLockOptions) error {
+	f.renewed++
+	return nil
+}
+
+func (f *fakeSessionLock) Close(_ context.Context) error {
+	f.closed++
+	return nil
+}
+
+func TestSessionLease(t *testing.T) {
+	g := NewWithT(t)
+	lock := &fakeSessionLock{}
+	acceptErr := errors.New("session is locked")
+	lease := &sessionLease{accept: func(ctx context.Context) (sessionLock, error) {
+		if acceptErr != nil {
+			return nil, acceptErr
+		}
+		return lock, nil
+	}}
+	ctx := context.Background()
+	g.Expect(lease.Acquire(ctx)).To(MatchError(acceptErr))
+	g.Expect(lease.Renew(ctx)).To(HaveOccurred())
+	g.Expect(lease.Release(ctx)).To(Succeed())
+
+	acceptErr = nil
+	g.Expect(lease.Acquire(ctx)).To(Succeed())
+	g.Expect(lease.Renew(ctx)).To(Succeed())
+	g.Expect(lease.Release(ctx)).To(Succeed())
+	g.Expect(lease.Release(ctx)).To(Succeed())
+	g.Expect(lock.renewed).To(Equal(1))
+	g.Expect(lock.closed).To(Equal(1))
+}
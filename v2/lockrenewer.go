@@ -26,33 +26,47 @@ type LockRenewalOptions struct {
 	// CancelMessageContextOnStop will cancel the downstream message context when the renewal handler is stopped.
 	// Defaults to true.
 	CancelMessageContextOnStop *bool
+	// Clock is used to schedule the renewals. Defaults to the system clock.
+	Clock Clock
+	// LockDuration is the lock duration configured on the entity. It is only used by Validate,
+	// to reject an Interval that would let the lock expire before it is renewed.
+	LockDuration *time.Duration
+	// MaxRenewalDuration stops renewing the lock once the handler ran for this duration,
+	// letting the lock expire if the handler takes longer. Defaults to 0, renewing until the handler returns.
+	MaxRenewalDuration time.Duration
 }
 
 // NewLockRenewalHandler returns a middleware handler that will renew the lock on the message at the specified interval.
 func NewLockRenewalHandler(lockRenewer LockRenewer, options *LockRenewalOptions, handler Handler) HandlerFunc {
 	interval := 10 * time.Second
 	cancelMessageContextOnStop := true
+	var clock Clock = systemClock{}
+	var maxRenewalDuration time.Duration
 	if options != nil {
+		maxRenewalDuration = options.MaxRenewalDuration
 		if options.Interval != nil {
 			interval = *options.Interval
 		}
 		if options.CancelMessageContextOnStop != nil {
 			cancelMessageContextOnStop = *options.CancelMessageContextOnStop
 		}
+		clock = clockOrDefault(options.Clock)
 	}
 	return func(ctx context.Context, settler MessageSettler, message *azservicebus.ReceivedMessage) {
 		plr := &peekLockRenewer{
 			next:                   handler,
 			lockRenewer:            lockRenewer,
 			renewalInterval:        &interval,
+			maxRenewalDuration:     maxRenewalDuration,
 			cancelMessageCtxOnStop: cancelMessageContextOnStop,
+			clock:                  clock,
 			stopped:                make(chan struct{}, 1), // buffered channel to ensure we are not blocking
 		}
-		renewalCtx, cancel := context.WithCancel(ctx)
+		renewalCtx, cancel := context.WithCancelCause(ctx)
 		plr.cancelMessageCtx = cancel
 		go plr.startPeriodicRenewal(renewalCtx, message)
 		handler.Handle(renewalCtx, settler, message)
-		plr.stop(renewalCtx)
+		plr.stop(renewalCtx, nil)
 	}
 }
 
@@ -74,9 +88,11 @@ type peekLockRenewer struct {
 	next                   Handler
 	lockRenewer            LockRenewer
 	renewalInterval        *time.Duration
+	maxRenewalDuration     time.Duration
 	alive                  atomic.Bool
 	cancelMessageCtxOnStop bool
-	cancelMessageCtx       func()
+	cancelMessageCtx       context.CancelCauseFunc
+	clock                  Clock
 
 	// stopped channel allows to short circuit the renewal loop
 	// when we are already waiting on the select.
@@ -86,8 +102,9 @@ type peekLockRenewer struct {
 	stopped chan struct{}
 }
 
-// stop will stop the renewal loop. if LockRenewalOptions.CancelMessageContextOnStop is set to true, it cancels the message context.
-func (plr *peekLockRenewer) stop(ctx context.Context) {
+// stop will stop the renewal loop. if LockRenewalOptions.CancelMessageContextOnStop is set to true, it cancels the message context
+// with the cause, or with context.Canceled when the cause is nil.
+func (plr *peekLockRenewer) stop(ctx context.Context, cause error) {
 	plr.alive.Store(false)
 	// don't send the stop signal to the loop if there is already one in the channel
 	if len(plr.stopped) == 0 {
@@ -95,7 +112,7 @@ func (plr *peekLockRenewer) stop(ctx context.Context) {
 	}
 	if plr.cancelMessageCtxOnStop {
 		log(ctx, "canceling message context")
-		plr.cancelMessageCtx()
+		plr.cancelMessageCtx(cause)
 	}
 	log(ctx, "stopped periodic renewal")
 }
@@ -110,15 +127,32 @@ func (plr *peekLockRenewer) isPermanent(err error) bool {
 	return false
 }
 
+// stopCause returns the cause of the cancellation of the message context after the permanent renewal error.
+func (plr *peekLockRenewer) stopCause(err error) error {
+	var sbErr *azservicebus.Error
+	if errors.As(err, &sbErr) && sbErr.Code == azservicebus.CodeLockLost {
+		return fmt.Errorf("%w: %w", ErrLockLost, err)
+	}
+	return err
+}
+
 func (plr *peekLockRenewer) startPeriodicRenewal(ctx context.Context, message *azservicebus.ReceivedMessage) {
 	count := 0
 	span := trace.SpanFromContext(ctx)
+	start := plr.clock.Now()
 	for plr.alive.Store(true); plr.alive.Load(); {
 		select {
-		case <-time.After(*plr.renewalInterval):
+		case <-plr.clock.After(*plr.renewalInterval):
 			if !plr.alive.Load() {
 				return
 			}
+			if plr.maxRenewalDuration > 0 && plr.clock.Now().Sub(start) >= plr.maxRenewalDuration {
+				// the message context is not canceled: the handler keeps running and its settlement fails if the lock expired.
+				log(ctx, fmt.Sprintf("max renewal duration reached: stopping periodic renewal for message: %s", message.MessageID))
+				span.AddEvent("max renewal duration reached: stopping message lock renewal")
+				plr.alive.Store(false)
+				return
+			}
 			log(ctx, "renewing lock")
 			count++
 			err := plr.lockRenewer.RenewMessageLock(ctx, message, nil)
@@ -135,7 +169,7 @@ func (plr *peekLockRenewer) startPeriodicRenewal(ctx context.Context, message *a
 				// if the error is anything else, we keep trying the renewal.
 				if plr.isPermanent(err) {
 					log(ctx, fmt.Sprintf("stopping periodic renewal for message: %s", message.MessageID))
-					plr.stop(ctx)
+					plr.stop(ctx, plr.stopCause(err))
 				}
 				continue
 			}
@@ -149,7 +183,7 @@ func (plr *peekLockRenewer) startPeriodicRenewal(ctx context.Context, message *a
 				span.RecordError(err)
 				processor.Metric.IncMessageDeadlineReachedCount(message)
 			}
-			plr.stop(ctx)
+			plr.stop(ctx, nil)
 		case <-plr.stopped:
 			if plr.alive.Load() {
 				log(ctx, "stop signal received: exiting periodic renewal")
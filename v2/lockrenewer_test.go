@@ -13,6 +13,7 @@ import (
 	. "github.com/onsi/gomega"
 
 	"github.com/Azure/go-shuttle/v2"
+	"github.com/Azure/go-shuttle/v2/shuttletest"
 )
 
 type fakeSBLockRenewer struct {
@@ -41,6 +42,13 @@ func (r *fakeSBLockRenewer) RenewMessageLock(ctx context.Context, message *azser
 	return r.Err
 }
 
+// renewCount returns the renewal counter of the message, nil when it was never renewed.
+func (r *fakeSBLockRenewer) renewCount(message *azservicebus.ReceivedMessage) *atomic.Int32 {
+	r.mapLock.Lock()
+	defer r.mapLock.Unlock()
+	return r.PerMessage[message]
+}
+
 func Test_StopRenewingOnHandlerCompletion(t *testing.T) {
 	renewer := &fakeSBLockRenewer{}
 	settler := &fakeSettler{}
@@ -92,9 +100,9 @@ func Test_RenewalHandlerStayIndependentPerMessage(t *testing.T) {
 	cancel2()
 	g.Eventually(
 		func(g Gomega) {
-			g.Expect(renewer.PerMessage[msg2]).To(BeNil(), "msg2 should not be in the map")
-			g.Expect(renewer.PerMessage[msg1]).ToNot(BeNil(), "msg1 should be in the map")
-			g.Expect(renewer.PerMessage[msg1].Load()).To(Equal(int32(2)))
+			g.Expect(renewer.renewCount(msg2)).To(BeNil(), "msg2 should not be in the map")
+			g.Expect(renewer.renewCount(msg1)).ToNot(BeNil(), "msg1 should be in the map")
+			g.Expect(renewer.renewCount(msg1).Load()).To(Equal(int32(2)))
 		},
 		200*time.Millisecond,
 		10*time.Millisecond).Should(Succeed())
@@ -193,6 +201,7 @@ func Test_RenewPeriodically_Error(t *testing.T) {
 					130*time.Millisecond,
 					20*time.Millisecond).Should(Succeed())
 				g.Expect(tc.gotMessageCtx.Err()).To(Equal(context.Canceled))
+				g.Expect(shuttle.IsLockLost(tc.gotMessageCtx)).To(BeTrue())
 			},
 		},
 		{
@@ -247,3 +256,30 @@ func Test_RenewPeriodically_Error(t *testing.T) {
 		})
 	}
 }
+
+func Test_StopRenewingAfterMaxRenewalDuration(t *testing.T) {
+	g := NewWithT(t)
+	renewer := &fakeSBLockRenewer{}
+	clock := shuttletest.NewFakeClock(time.Now())
+	interval := 20 * time.Millisecond
+	var handlerErr error
+	lr := shuttle.NewLockRenewalHandler(renewer, &shuttle.LockRenewalOptions{
+		Interval:           &interval,
+		MaxRenewalDuration: 50 * time.Millisecond,
+		Clock:              clock,
+	},
+		shuttle.HandlerFunc(func(ctx context.Context, settler shuttle.MessageSettler,
+			message *azservicebus.ReceivedMessage) {
+			// renewed at 20ms and 40ms, stopped at 60ms
+			for _, renewed := range []int32{1, 2, 2} {
+				g.Eventually(clock.PendingTimers).Should(Equal(1))
+				clock.Advance(interval)
+				g.Eventually(renewer.RenewCount.Load).Should(Equal(renewed))
+			}
+			g.Consistently(clock.PendingTimers, 50*time.Millisecond).Should(BeZero(), "no renewal is scheduled after the max renewal duration")
+			handlerErr = ctx.Err()
+		}))
+	lr.Handle(context.Background(), &fakeSettler{}, &azservicebus.ReceivedMessage{})
+	g.Expect(renewer.RenewCount.Load()).To(Equal(int32(2)))
+	g.Expect(handlerErr).To(BeNil(), "the message context is not canceled when the max renewal duration is reached")
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"time"
+	"unicode/utf8"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
 	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
@@ -101,8 +102,25 @@ type ManagedSettlingOptions struct {
 	OnDeadLettered func(context.Context, *azservicebus.ReceivedMessage, error)
 	// OnCompleted is a func that is invoked when the handler does not return any error. it is invoked after the message is completed.
 	OnCompleted func(context.Context, *azservicebus.ReceivedMessage)
+	// AnnotateRetries records the retry history on the message when it is abandoned for retry,
+	// by setting the RetryAttemptProperty, RetryLastErrorProperty and RetryNextAttemptProperty application properties.
+	// Downstream middlewares and dashboards can then see why and when the message was retried.
+	AnnotateRetries bool
 }
 
+// Application properties set on abandoned messages when ManagedSettlingOptions.AnnotateRetries is enabled.
+const (
+	// RetryAttemptProperty is the delivery count of the failed attempt.
+	RetryAttemptProperty = "goshuttle-retry-attempt"
+	// RetryLastErrorProperty is the error returned by the handler on the failed attempt,
+	// truncated to 256 bytes without cutting a UTF-8 character.
+	RetryLastErrorProperty = "goshuttle-retry-last-error"
+	// RetryNextAttemptProperty is the earliest time at which the message is redelivered, after the retry delay.
+	RetryNextAttemptProperty = "goshuttle-retry-next-attempt"
+
+	maxRetryErrorLength = 256
+)
+
 // NewManagedSettlingHandler allows to configure Retry decision logic and delay strategy.
 // It also adapts the handler to let the user return an error from the handler, instead of a settlement.
 // the settlement is inferred from the handler's return value.
@@ -131,6 +149,7 @@ func NewManagedSettlingHandler(opts *ManagedSettlingOptions, handler ManagedSett
 		if opts.OnDeadLettered != nil {
 			options.OnDeadLettered = opts.OnDeadLettered
 		}
+		options.AnnotateRetries = opts.AnnotateRetries
 	}
 	return &ManagedSettler{
 		next:    handler,
@@ -179,7 +198,33 @@ func handleError(ctx context.Context,
 	// this will continue renewing the lock on the message while we wait for this delay to pass.
 	delay := options.RetryDelayStrategy.GetDelay(message.DeliveryCount)
 	log(ctx, fmt.Sprintf("delay strategy return delay of %s", delay))
+	var abandonOptions *azservicebus.AbandonMessageOptions
+	if options.AnnotateRetries {
+		// the next attempt is computed before the delay, from the time the attempt failed
+		abandonOptions = &azservicebus.AbandonMessageOptions{PropertiesToModify: retryProperties(message, handleErr, time.Now().Add(delay))}
+	}
 	time.Sleep(delay)
-	abandonSettlement.settle(ctx, settler, message, nil)
+	abandonSettlement.settle(ctx, settler, message, abandonOptions)
 	options.OnAbandoned(ctx, message, handleErr)
 }
+
+// retryProperties returns the properties recording the failed attempt on the abandoned message.
+func retryProperties(message *azservicebus.ReceivedMessage, handleErr error, nextAttempt time.Time) map[string]any {
+	return map[string]any{
+		RetryAttemptProperty:     int64(message.DeliveryCount),
+		RetryLastErrorProperty:   truncateString(handleErr.Error(), maxRetryErrorLength),
+		RetryNextAttemptProperty: nextAttempt.UTC(),
+	}
+}
+
+// truncateString cuts s to at most max bytes, on a character boundary.
+func truncateString(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	end := max
+	for end > 0 && !utf8.RuneStart(s[end]) {
+		end--
+	}
+	return s[:end]
+}
@@ -3,7 +3,10 @@ package shuttle
 import (
 	"context"
 	"fmt"
+	"strings"
 	"testing"
+	"time"
+	"unicode/utf8"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
 	. "github.com/onsi/gomega"
@@ -12,6 +15,7 @@ import (
 
 type fakeSettler struct {
 	abandoned         bool
+	abandonOptions    *azservicebus.AbandonMessageOptions
 	completed         bool
 	completeErr       error
 	deadlettered      bool
@@ -22,6 +26,7 @@ type fakeSettler struct {
 
 func (f *fakeSettler) AbandonMessage(ctx context.Context, message *azservicebus.ReceivedMessage, options *azservicebus.AbandonMessageOptions) error {
 	f.abandoned = true
+	f.abandonOptions = options
 	return nil
 }
 
@@ -196,6 +201,54 @@ func TestOnErrorOverride(t *testing.T) {
 	g.Expect(onErrorCalled).To(BeTrue())
 }
 
+func TestAnnotateRetries(t *testing.T) {
+	g := NewWithT(t)
+	handleErr := fmt.Errorf("failed: %s", strings.Repeat("x", 300))
+	for _, annotate := range []bool{true, false} {
+		settler := &fakeSettler{}
+		h := NewManagedSettlingHandler(&ManagedSettlingOptions{
+			AnnotateRetries:    annotate,
+			RetryDelayStrategy: &ConstantDelayStrategy{Delay: 0},
+		}, ManagedSettlingFunc(func(_ context.Context, _ *azservicebus.ReceivedMessage) error {
+			return handleErr
+		}))
+		before := time.Now().UTC()
+		h.Handle(context.Background(), settler, &azservicebus.ReceivedMessage{DeliveryCount: 2})
+		g.Expect(settler.abandoned).To(BeTrue())
+		if !annotate {
+			g.Expect(settler.abandonOptions).To(BeNil())
+			continue
+		}
+		properties := settler.abandonOptions.PropertiesToModify
+		g.Expect(properties).To(HaveKeyWithValue(RetryAttemptProperty, int64(2)))
+		g.Expect(properties).To(HaveKeyWithValue(RetryLastErrorProperty, handleErr.Error()[:maxRetryErrorLength]))
+		g.Expect(properties[RetryNextAttemptProperty]).To(BeTemporally(">=", before))
+	}
+}
+
+func TestAnnotateRetries_NextAttemptAndTruncation(t *testing.T) {
+	g := NewWithT(t)
+	// the multi-byte characters straddle the truncation length
+	handleErr := fmt.Errorf("%s%s", strings.Repeat("x", maxRetryErrorLength-1), strings.Repeat("é", 10))
+	settler := &fakeSettler{}
+	h := NewManagedSettlingHandler(&ManagedSettlingOptions{
+		AnnotateRetries:    true,
+		RetryDelayStrategy: &ConstantDelayStrategy{Delay: 50 * time.Millisecond},
+	}, ManagedSettlingFunc(func(_ context.Context, _ *azservicebus.ReceivedMessage) error {
+		return handleErr
+	}))
+	before := time.Now()
+	h.Handle(context.Background(), settler, &azservicebus.ReceivedMessage{DeliveryCount: 1})
+	after := time.Now()
+	properties := settler.abandonOptions.PropertiesToModify
+	lastErr := properties[RetryLastErrorProperty].(string)
+	g.Expect(utf8.ValidString(lastErr)).To(BeTrue())
+	g.Expect(lastErr).To(Equal(strings.Repeat("x", maxRetryErrorLength-1)))
+	// the next attempt is the time of the failure plus the delay, not the time of the abandon
+	g.Expect(properties[RetryNextAttemptProperty]).To(BeTemporally(">=", before.Add(50*time.Millisecond)))
+	g.Expect(properties[RetryNextAttemptProperty]).To(BeTemporally("<=", after))
+}
+
 func TestMaxAttemptsRetryDecision(t *testing.T) {
 	for _, tc := range []struct {
 		maxAttempts   uint32
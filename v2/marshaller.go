@@ -1,13 +1,25 @@
 package shuttle
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"mime"
+	"reflect"
+	"strings"
+	"unicode"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
 	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/known/anypb"
 )
 
+// Marshaller converts the message bodies to and from the azservicebus messages.
+// ContentType is the MIME type of the marshalled bodies. The Sender sets it on the outgoing messages
+// when Marshal does not, and the MarshallerRegistry uses it to pick the marshaller of the received messages.
 type Marshaller interface {
 	Marshal(mb MessageBody) (*azservicebus.Message, error)
 	Unmarshal(msg *azservicebus.Message, mb MessageBody) error
@@ -17,12 +29,68 @@ type Marshaller interface {
 const jsonContentType = "application/json"
 const protobufContentType = "application/x-protobuf"
 
+// PreMarshalledBody is a message body that is already marshalled, for example a payload forwarded as-is.
+// The Sender sets Body as the message body without going through its Marshaller.
+type PreMarshalledBody struct {
+	Body []byte
+	// ContentType defaults to the ContentType of the sender's Marshaller.
+	ContentType string
+	// MessageType is set as the message type property.
+	MessageType string
+}
+
 // DefaultJSONMarshaller is the default marshaller for JSON messages
 type DefaultJSONMarshaller struct {
+	// BufferPool provides the buffers used to serialize the messages. Defaults to a shared SyncBufferPool.
+	BufferPool BufferPool
+	// DisableBufferPool allocates a new buffer for each message.
+	DisableBufferPool bool
+	// DisallowUnknownFields fails the unmarshalling of bodies with fields that are not in the destination struct.
+	DisallowUnknownFields bool
+	// UseNumber unmarshals the numbers into interface{} values as json.Number instead of float64,
+	// to keep the precision of large integers.
+	UseNumber bool
+	// DisableHTMLEscape does not escape &, < and > in the JSON strings.
+	DisableHTMLEscape bool
+	// Indent indents the marshalled bodies with the given string. Defaults to compact JSON.
+	Indent string
+	// FieldNaming transforms the keys of the struct fields without a name in their json tag, to match the casing of
+	// contracts shared with other languages. The tagged fields, the map keys and the order of the keys are kept.
+	// Defaults to the keys of encoding/json.
+	FieldNaming JSONFieldNaming
 }
 
+// JSONFieldNaming is the casing of the keys of the JSON objects.
+type JSONFieldNaming int
+
+const (
+	// JSONFieldNamingDefault keeps the keys of encoding/json: the struct field names or their json tag.
+	JSONFieldNamingDefault JSONFieldNaming = iota
+	// JSONFieldNamingCamelCase writes the keys in camelCase, such as firstName.
+	JSONFieldNamingCamelCase
+	// JSONFieldNamingSnakeCase writes the keys in snake_case, such as first_name.
+	JSONFieldNamingSnakeCase
+)
+
 // DefaultProtoMarshaller is the default marshaller for protobuf messages
 type DefaultProtoMarshaller struct {
+	// BufferPool provides the buffers used to serialize the messages. Defaults to a shared SyncBufferPool.
+	BufferPool BufferPool
+	// DisableBufferPool allocates a new buffer for each message.
+	DisableBufferPool bool
+	// Deterministic orders the map entries of the marshalled bodies, so that equal messages have the same bytes,
+	// for example to derive the MessageID from a hash of the body for duplicate detection.
+	// The output is only stable for a given version of the proto definitions and of the protobuf library.
+	Deterministic bool
+	// Resolver resolves the extensions, and the types of the Any messages unpacked by UnmarshalAny.
+	// Defaults to protoregistry.GlobalTypes. A *protoregistry.Types can be used.
+	Resolver ProtoResolver
+}
+
+// ProtoResolver resolves the message and extension types when unmarshalling protobuf messages.
+type ProtoResolver interface {
+	protoregistry.MessageTypeResolver
+	protoregistry.ExtensionTypeResolver
 }
 
 var _ Marshaller = &DefaultJSONMarshaller{}
@@ -31,17 +99,128 @@ var _ Marshaller = &DefaultProtoMarshaller{}
 // Marshal marshals the user-input struct into a JSON string and returns a new message with the JSON string as the body
 func (j *DefaultJSONMarshaller) Marshal(mb MessageBody) (*azservicebus.Message, error) {
 	JSONContentType := j.ContentType()
-	str, err := json.Marshal(mb)
-	if err != nil {
+	pool := bufferPoolOrDefault(j.BufferPool, j.DisableBufferPool)
+	buf := pool.Get()
+	defer pool.Put(buf)
+	if err := j.encode(buf, mb); err != nil {
 		return nil, err
 	}
+	if j.FieldNaming != JSONFieldNamingDefault {
+		renamed, err := renameJSONFields(buf.Bytes(), reflect.TypeOf(mb), j.FieldNaming, false, !j.DisableHTMLEscape)
+		if err != nil {
+			return nil, err
+		}
+		buf.Reset()
+		if j.Indent != "" {
+			if err := json.Indent(buf, renamed, "", j.Indent); err != nil {
+				return nil, err
+			}
+		} else {
+			buf.Write(renamed)
+		}
+	}
+	// the encoder terminates the value with a newline, unlike json.Marshal
+	str := append([]byte(nil), bytes.TrimSuffix(buf.Bytes(), []byte("\n"))...)
 
 	return &azservicebus.Message{Body: str, ContentType: &JSONContentType}, nil
 }
 
 // Unmarshal unmarshals the message body from a JSON string into the user-input struct
 func (j *DefaultJSONMarshaller) Unmarshal(msg *azservicebus.Message, mb MessageBody) error {
-	return json.Unmarshal(msg.Body, mb)
+	if !j.DisallowUnknownFields && !j.UseNumber && j.FieldNaming == JSONFieldNamingDefault {
+		return json.Unmarshal(msg.Body, mb)
+	}
+	body := msg.Body
+	if j.FieldNaming != JSONFieldNamingDefault {
+		renamed, err := renameJSONFields(body, reflect.TypeOf(mb), j.FieldNaming, true, true)
+		if err != nil {
+			return err
+		}
+		body = renamed
+	}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	if j.DisallowUnknownFields {
+		decoder.DisallowUnknownFields()
+	}
+	if j.UseNumber {
+		decoder.UseNumber()
+	}
+	if err := decoder.Decode(mb); err != nil {
+		return err
+	}
+	// like json.Unmarshal, reject the data after the JSON value
+	if _, err := decoder.Token(); err != io.EOF {
+		return fmt.Errorf("invalid JSON: unexpected data after the top-level value")
+	}
+	return nil
+}
+
+func (j *DefaultJSONMarshaller) encode(buf *bytes.Buffer, v any) error {
+	encoder := json.NewEncoder(buf)
+	encoder.SetEscapeHTML(!j.DisableHTMLEscape)
+	if j.Indent != "" {
+		encoder.SetIndent("", j.Indent)
+	}
+	return encoder.Encode(v)
+}
+
+func (n JSONFieldNaming) rename(key string) string {
+	words := splitFieldName(key)
+	switch n {
+	case JSONFieldNamingCamelCase:
+		for i, word := range words {
+			if i == 0 {
+				words[i] = strings.ToLower(word)
+			} else {
+				words[i] = capitalize(word)
+			}
+		}
+		return strings.Join(words, "")
+	case JSONFieldNamingSnakeCase:
+		for i, word := range words {
+			words[i] = strings.ToLower(word)
+		}
+		return strings.Join(words, "_")
+	default:
+		return key
+	}
+}
+
+func capitalize(word string) string {
+	if word == "" {
+		return word
+	}
+	return strings.ToUpper(word[:1]) + strings.ToLower(word[1:])
+}
+
+// splitFieldName splits the key into words on underscores, dashes and case changes: UserID is split into User and ID,
+// HTTPServer into HTTP and Server.
+func splitFieldName(key string) []string {
+	var words []string
+	runes := []rune(key)
+	start := 0
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		if r == '_' || r == '-' {
+			if i > start {
+				words = append(words, string(runes[start:i]))
+			}
+			start = i + 1
+			continue
+		}
+		if i > start && unicode.IsUpper(r) {
+			prevLower := unicode.IsLower(runes[i-1]) || unicode.IsDigit(runes[i-1])
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if prevLower || (unicode.IsUpper(runes[i-1]) && nextLower) {
+				words = append(words, string(runes[start:i]))
+				start = i
+			}
+		}
+	}
+	if start < len(runes) {
+		words = append(words, string(runes[start:]))
+	}
+	return words
 }
 
 // ContentType returns the content type for the JSON marshaller
@@ -57,11 +236,16 @@ func (p *DefaultProtoMarshaller) Marshal(mb MessageBody) (*azservicebus.Message,
 	if !ok {
 		return nil, fmt.Errorf("message must be a protobuf message")
 	}
-	data, err := proto.Marshal(message)
+	pool := bufferPoolOrDefault(p.BufferPool, p.DisableBufferPool)
+	buf := pool.Get()
+	defer pool.Put(buf)
+	// proto.Size caches the size in the message, so that MarshalAppend does not compute it again
+	buf.Grow(proto.Size(message))
+	data, err := proto.MarshalOptions{UseCachedSize: true, Deterministic: p.Deterministic}.MarshalAppend(buf.Bytes()[:0], message)
 	if err != nil {
 		return nil, err
 	}
-	msg := &azservicebus.Message{Body: data, ContentType: &protoContentType}
+	msg := &azservicebus.Message{Body: append([]byte(nil), data...), ContentType: &protoContentType}
 
 	return msg, nil
 }
@@ -72,10 +256,100 @@ func (p *DefaultProtoMarshaller) Unmarshal(msg *azservicebus.Message, mb Message
 	if !ok {
 		return fmt.Errorf("message body must be a protobuf message")
 	}
-	return proto.Unmarshal(msg.Body, castedMb)
+	return p.unmarshalOptions().Unmarshal(msg.Body, castedMb)
+}
+
+// UnmarshalAny unmarshals a body marshalled from an anypb.Any, and unpacks it into a new message of the type it contains.
+// The type is looked up in the Resolver.
+func (p *DefaultProtoMarshaller) UnmarshalAny(msg *azservicebus.Message) (proto.Message, error) {
+	packed := &anypb.Any{}
+	options := p.unmarshalOptions()
+	if err := options.Unmarshal(msg.Body, packed); err != nil {
+		return nil, err
+	}
+	unpacked, err := anypb.UnmarshalNew(packed, options)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unpack %s: %w", packed.GetTypeUrl(), err)
+	}
+	return unpacked, nil
+}
+
+func (p *DefaultProtoMarshaller) unmarshalOptions() proto.UnmarshalOptions {
+	options := proto.UnmarshalOptions{}
+	if p.Resolver != nil {
+		options.Resolver = p.Resolver
+	}
+	return options
 }
 
 // ContentType returns teh contentType for the protobuf marshaller
 func (p *DefaultProtoMarshaller) ContentType() string {
 	return protobufContentType
 }
+
+// ErrUnsupportedContentType is returned by the MarshallerRegistry when no marshaller is registered for the content type of a message.
+var ErrUnsupportedContentType = errors.New("unsupported content type")
+
+// MarshallerRegistry selects the marshaller to unmarshal a received message based on its ContentType,
+// to consume an entity carrying messages in several formats.
+type MarshallerRegistry struct {
+	marshallers map[string]Marshaller
+	fallback    Marshaller
+}
+
+// NewMarshallerRegistry creates a registry of the marshallers, keyed by their ContentType.
+// The first marshaller is used for the messages without ContentType.
+func NewMarshallerRegistry(marshallers ...Marshaller) *MarshallerRegistry {
+	r := &MarshallerRegistry{marshallers: map[string]Marshaller{}}
+	for _, marshaller := range marshallers {
+		r.Register(marshaller.ContentType(), marshaller)
+	}
+	return r
+}
+
+// Register uses the marshaller for the messages of the content type, for example to register a marshaller under an alias.
+// The first registered marshaller is used for the messages without ContentType.
+func (r *MarshallerRegistry) Register(contentType string, marshaller Marshaller) *MarshallerRegistry {
+	r.marshallers[normalizeContentType(contentType)] = marshaller
+	if r.fallback == nil {
+		r.fallback = marshaller
+	}
+	return r
+}
+
+// Marshaller returns the marshaller of the content type. The parameters of the content type, such as the charset, are ignored.
+func (r *MarshallerRegistry) Marshaller(contentType string) (Marshaller, error) {
+	if contentType == "" && r.fallback != nil {
+		return r.fallback, nil
+	}
+	marshaller, ok := r.marshallers[normalizeContentType(contentType)]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedContentType, contentType)
+	}
+	return marshaller, nil
+}
+
+// Unmarshal unmarshals the body of the received message into mb with the marshaller of its ContentType.
+// The bodies sent in a value section are unmarshalled too, see BodyBytes.
+func (r *MarshallerRegistry) Unmarshal(message *azservicebus.ReceivedMessage, mb MessageBody) error {
+	contentType := ""
+	if message.ContentType != nil {
+		contentType = *message.ContentType
+	}
+	marshaller, err := r.Marshaller(contentType)
+	if err != nil {
+		return err
+	}
+	body, err := BodyBytes(message)
+	if err != nil {
+		return err
+	}
+	return marshaller.Unmarshal(&azservicebus.Message{Body: body, ContentType: message.ContentType}, mb)
+}
+
+func normalizeContentType(contentType string) string {
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil {
+		return mediaType
+	}
+	return strings.ToLower(strings.TrimSpace(contentType))
+}
@@ -1,7 +1,18 @@
 package shuttle
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
 	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	. "github.com/onsi/gomega"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
 )
 
 type ContosoCreateUserRequest struct {
@@ -41,3 +52,264 @@ func Test_JSONMarshaller(t *testing.T) {
 func equalStructs(expected, actual *ContosoCreateUserRequest) bool {
 	return expected.FirstName == actual.FirstName && expected.LastName == actual.LastName && expected.Email == actual.Email
 }
+
+type countingBufferPool struct {
+	gets, puts int
+	pool       BufferPool
+}
+
+func (c *countingBufferPool) Get() *bytes.Buffer {
+	c.gets++
+	return c.pool.Get()
+}
+
+func (c *countingBufferPool) Put(buf *bytes.Buffer) {
+	c.puts++
+	c.pool.Put(buf)
+}
+
+func Test_JSONMarshallerBufferPool(t *testing.T) {
+	g := NewWithT(t)
+	pool := &countingBufferPool{pool: NewSyncBufferPool()}
+	bodies := []MessageBody{testStruct, "<html>&", 42, map[string]any{"a": []int{1, 2}}}
+	for _, marshaller := range []*DefaultJSONMarshaller{{}, {BufferPool: pool}, {DisableBufferPool: true}} {
+		var previous *azservicebus.Message
+		for _, body := range bodies {
+			expected, err := json.Marshal(body)
+			g.Expect(err).ToNot(HaveOccurred())
+			msg, err := marshaller.Marshal(body)
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(msg.Body).To(Equal(expected))
+			if previous != nil {
+				g.Expect(previous.Body).ToNot(Equal(msg.Body), "the body must not share the pooled buffer")
+			}
+			previous = msg
+		}
+	}
+	g.Expect(pool.gets).To(Equal(len(bodies)))
+	g.Expect(pool.puts).To(Equal(len(bodies)))
+}
+
+func Test_ProtoMarshallerBufferPool(t *testing.T) {
+	g := NewWithT(t)
+	for _, marshaller := range []*DefaultProtoMarshaller{{}, {DisableBufferPool: true}} {
+		first, err := marshaller.Marshal(wrapperspb.String("first"))
+		g.Expect(err).ToNot(HaveOccurred())
+		second, err := marshaller.Marshal(wrapperspb.String("second"))
+		g.Expect(err).ToNot(HaveOccurred())
+
+		value := &wrapperspb.StringValue{}
+		g.Expect(marshaller.Unmarshal(first, value)).To(Succeed())
+		g.Expect(value.Value).To(Equal("first"))
+		g.Expect(marshaller.Unmarshal(second, value)).To(Succeed())
+		g.Expect(value.Value).To(Equal("second"))
+	}
+}
+
+func Test_SyncBufferPoolDropsLargeBuffers(t *testing.T) {
+	g := NewWithT(t)
+	pool := NewSyncBufferPool()
+	large := pool.Get()
+	large.Grow(2 * maxPooledBufferSize)
+	pool.Put(large)
+	g.Expect(pool.Get()).ToNot(BeIdenticalTo(large))
+}
+
+func Benchmark_JSONMarshaller(b *testing.B) {
+	for name, marshaller := range map[string]*DefaultJSONMarshaller{
+		"pooled":   {},
+		"unpooled": {DisableBufferPool: true},
+	} {
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := marshaller.Marshal(testStruct); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func Benchmark_ProtoMarshaller(b *testing.B) {
+	body := wrapperspb.Bytes(make([]byte, 16*1024))
+	for name, marshaller := range map[string]*DefaultProtoMarshaller{
+		"pooled":   {},
+		"unpooled": {DisableBufferPool: true},
+	} {
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := marshaller.Marshal(body); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func Test_MarshallerRegistry(t *testing.T) {
+	g := NewWithT(t)
+	registry := NewMarshallerRegistry(&DefaultJSONMarshaller{}, &DefaultProtoMarshaller{})
+
+	jsonMsg, err := (&DefaultJSONMarshaller{}).Marshal(testStruct)
+	g.Expect(err).ToNot(HaveOccurred())
+	var user ContosoCreateUserRequest
+	g.Expect(registry.Unmarshal(&azservicebus.ReceivedMessage{Body: jsonMsg.Body, ContentType: jsonMsg.ContentType}, &user)).To(Succeed())
+	g.Expect(&user).To(Equal(testStruct))
+
+	protoMsg, err := (&DefaultProtoMarshaller{}).Marshal(wrapperspb.String("hello"))
+	g.Expect(err).ToNot(HaveOccurred())
+	value := &wrapperspb.StringValue{}
+	g.Expect(registry.Unmarshal(&azservicebus.ReceivedMessage{Body: protoMsg.Body, ContentType: protoMsg.ContentType}, value)).To(Succeed())
+	g.Expect(value.GetValue()).To(Equal("hello"))
+
+	charset := "application/json; charset=utf-8"
+	user = ContosoCreateUserRequest{}
+	g.Expect(registry.Unmarshal(&azservicebus.ReceivedMessage{Body: jsonMsg.Body, ContentType: &charset}, &user)).To(Succeed())
+	g.Expect(&user).To(Equal(testStruct))
+
+	user = ContosoCreateUserRequest{}
+	g.Expect(registry.Unmarshal(&azservicebus.ReceivedMessage{Body: jsonMsg.Body}, &user)).To(Succeed())
+	g.Expect(&user).To(Equal(testStruct))
+
+	xml := "application/xml"
+	err = registry.Unmarshal(&azservicebus.ReceivedMessage{Body: []byte("<a/>"), ContentType: &xml}, &user)
+	g.Expect(err).To(MatchError(ErrUnsupportedContentType))
+}
+
+func Test_JSONMarshallerStrictOptions(t *testing.T) {
+	g := NewWithT(t)
+	marshaller := &DefaultJSONMarshaller{DisallowUnknownFields: true, UseNumber: true}
+
+	var user ContosoCreateUserRequest
+	err := marshaller.Unmarshal(&azservicebus.Message{Body: []byte(`{"FirstName":"John","Age":42}`)}, &user)
+	g.Expect(err).To(MatchError(ContainSubstring("unknown field")))
+
+	var values map[string]any
+	g.Expect(marshaller.Unmarshal(&azservicebus.Message{Body: []byte(`{"id":9007199254740993}`)}, &values)).To(Succeed())
+	g.Expect(values["id"]).To(Equal(json.Number("9007199254740993")))
+}
+
+func Test_JSONMarshallerEncoderOptions(t *testing.T) {
+	g := NewWithT(t)
+	msg, err := (&DefaultJSONMarshaller{DisableHTMLEscape: true}).Marshal(map[string]string{"query": "a<b&c"})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(string(msg.Body)).To(Equal(`{"query":"a<b&c"}`))
+
+	msg, err = (&DefaultJSONMarshaller{Indent: "  "}).Marshal(map[string]int{"a": 1})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(string(msg.Body)).To(Equal("{\n  \"a\": 1\n}"))
+}
+
+func Test_JSONMarshallerFieldNaming(t *testing.T) {
+	type address struct {
+		StreetName string
+	}
+	type user struct {
+		UserID    int64
+		FirstName string
+		Addresses []address
+	}
+	testCases := []struct {
+		naming   JSONFieldNaming
+		expected string
+	}{
+		{naming: JSONFieldNamingCamelCase, expected: `{"userId":9007199254740993,"firstName":"John","addresses":[{"streetName":"Main"}]}`},
+		{naming: JSONFieldNamingSnakeCase, expected: `{"user_id":9007199254740993,"first_name":"John","addresses":[{"street_name":"Main"}]}`},
+	}
+	for _, tc := range testCases {
+		g := NewWithT(t)
+		marshaller := &DefaultJSONMarshaller{FieldNaming: tc.naming, DisallowUnknownFields: true}
+		in := user{UserID: 9007199254740993, FirstName: "John", Addresses: []address{{StreetName: "Main"}}}
+		msg, err := marshaller.Marshal(in)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(string(msg.Body)).To(Equal(tc.expected))
+
+		var out user
+		g.Expect(marshaller.Unmarshal(msg, &out)).To(Succeed())
+		g.Expect(out).To(Equal(in))
+	}
+}
+
+func Test_JSONMarshallerFieldNamingKeepsTagsAndMapKeys(t *testing.T) {
+	g := NewWithT(t)
+	type order struct {
+		OrderID  string            `json:"order_ID"`
+		Internal string            `json:"-"`
+		Labels   map[string]string `json:",omitempty"`
+		Payload  any
+	}
+	marshaller := &DefaultJSONMarshaller{FieldNaming: JSONFieldNamingCamelCase, DisallowUnknownFields: true}
+	in := order{OrderID: "1", Labels: map[string]string{"Team_Name": "a"}, Payload: map[string]any{"Item_Count": 1.0}}
+	msg, err := marshaller.Marshal(in)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(string(msg.Body)).To(Equal(`{"order_ID":"1","labels":{"Team_Name":"a"},"payload":{"Item_Count":1}}`))
+
+	var out order
+	g.Expect(marshaller.Unmarshal(msg, &out)).To(Succeed())
+	g.Expect(out).To(Equal(in))
+}
+
+func Test_JSONMarshallerRejectsTrailingData(t *testing.T) {
+	type user struct {
+		Name string
+	}
+	for _, marshaller := range []*DefaultJSONMarshaller{
+		{},
+		{DisallowUnknownFields: true},
+		{UseNumber: true},
+		{FieldNaming: JSONFieldNamingSnakeCase},
+	} {
+		g := NewWithT(t)
+		var out user
+		err := marshaller.Unmarshal(&azservicebus.Message{Body: []byte(`{"name":"a"} {"name":"b"}`)}, &out)
+		g.Expect(err).To(HaveOccurred())
+	}
+}
+
+func Test_SplitFieldName(t *testing.T) {
+	g := NewWithT(t)
+	g.Expect(splitFieldName("FirstName")).To(Equal([]string{"First", "Name"}))
+	g.Expect(splitFieldName("UserID")).To(Equal([]string{"User", "ID"}))
+	g.Expect(splitFieldName("HTTPServer")).To(Equal([]string{"HTTP", "Server"}))
+	g.Expect(splitFieldName("first_name")).To(Equal([]string{"first", "name"}))
+	g.Expect(splitFieldName("firstName")).To(Equal([]string{"first", "Name"}))
+	g.Expect(splitFieldName("v2Items")).To(Equal([]string{"v2", "Items"}))
+}
+
+func Test_ProtoMarshallerDeterministic(t *testing.T) {
+	g := NewWithT(t)
+	fields := map[string]any{}
+	for i := 0; i < 50; i++ {
+		fields[fmt.Sprintf("field-%d", i)] = i
+	}
+	body, err := structpb.NewStruct(fields)
+	g.Expect(err).ToNot(HaveOccurred())
+	expected, err := proto.MarshalOptions{Deterministic: true}.Marshal(body)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	marshaller := &DefaultProtoMarshaller{Deterministic: true}
+	for i := 0; i < 10; i++ {
+		msg, err := marshaller.Marshal(body)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(msg.Body).To(Equal(expected))
+	}
+}
+
+func Test_ProtoMarshallerUnmarshalAny(t *testing.T) {
+	g := NewWithT(t)
+	packed, err := anypb.New(wrapperspb.String("hello"))
+	g.Expect(err).ToNot(HaveOccurred())
+	msg, err := (&DefaultProtoMarshaller{}).Marshal(packed)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	types := &protoregistry.Types{}
+	g.Expect(types.RegisterMessage((&wrapperspb.StringValue{}).ProtoReflect().Type())).To(Succeed())
+	unpacked, err := (&DefaultProtoMarshaller{Resolver: types}).UnmarshalAny(msg)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(proto.Equal(unpacked, wrapperspb.String("hello"))).To(BeTrue())
+
+	_, err = (&DefaultProtoMarshaller{Resolver: &protoregistry.Types{}}).UnmarshalAny(msg)
+	g.Expect(err).To(MatchError(protoregistry.NotFound))
+}
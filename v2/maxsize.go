@@ -0,0 +1,170 @@
+package shuttle
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+
+	"github.com/Azure/go-shuttle/v2/metrics/sender"
+)
+
+// defaultMaxMessageSize is the maximum message size of the standard tier, which does not report it.
+const defaultMaxMessageSize = 256 * 1024
+
+// ErrMessageTooLarge is returned by the Sender when the message exceeds the maximum message size of the entity,
+// before the message is sent.
+type ErrMessageTooLarge struct {
+	// Size is the estimated size of the message in bytes.
+	Size int
+	// Limit is the maximum message size of the entity in bytes.
+	Limit int
+}
+
+func (e *ErrMessageTooLarge) Error() string {
+	return fmt.Sprintf("message of %d bytes exceeds the maximum message size of %d bytes", e.Size, e.Limit)
+}
+
+// MaxMessageSizeProvider returns the maximum size in bytes of the messages of the entity.
+type MaxMessageSizeProvider interface {
+	MaxMessageSize(ctx context.Context) (int, error)
+}
+
+// MaxMessageSizeFunc allows to use a func as a MaxMessageSizeProvider.
+type MaxMessageSizeFunc func(ctx context.Context) (int, error)
+
+func (f MaxMessageSizeFunc) MaxMessageSize(ctx context.Context) (int, error) {
+	return f(ctx)
+}
+
+// StaticMaxMessageSize is a MaxMessageSizeProvider for a known maximum message size in bytes.
+type StaticMaxMessageSize int
+
+func (s StaticMaxMessageSize) MaxMessageSize(_ context.Context) (int, error) {
+	return int(s), nil
+}
+
+// MaxMessageSize returns the cached maximum message size of the entity in bytes,
+// or the 256KB of the standard tier when the entity does not report it.
+func (c *CachedEntityInfo) MaxMessageSize(ctx context.Context) (int, error) {
+	info, err := c.EntityInfo(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if info.MaxMessageSizeInKilobytes <= 0 {
+		return defaultMaxMessageSize, nil
+	}
+	return int(info.MaxMessageSizeInKilobytes * 1024), nil
+}
+
+// OversizedMessageHandler is called by the Sender with a message exceeding the maximum message size.
+// It returns the message to send instead, for example with a compressed body or a claim-check reference
+// to a body stored out of band, or an error to fail the send.
+// With SenderOptions.Signing, the returned message is signed again, as its body no longer matches the signature.
+type OversizedMessageHandler func(ctx context.Context, msg *azservicebus.Message, err *ErrMessageTooLarge) (*azservicebus.Message, error)
+
+// WithMaxMessageSize checks the size of the messages before sending them, failing the oversized ones
+// with an *ErrMessageTooLarge instead of a broker error after a round trip.
+// Use a CachedEntityInfo to query the limit of the entity.
+func WithMaxMessageSize(provider MaxMessageSizeProvider) SenderOption {
+	return func(options *SenderOptions) {
+		options.MaxMessageSize = provider
+	}
+}
+
+// WithOversizedMessageHandler sets the handler called with the messages exceeding the maximum message size.
+// It requires WithMaxMessageSize.
+func WithOversizedMessageHandler(handler OversizedMessageHandler) SenderOption {
+	return func(options *SenderOptions) {
+		options.OnMessageTooLarge = handler
+	}
+}
+
+// MessageSizeWarningHandler is called by the Sender with a message whose size crossed the warning threshold
+// of the maximum message size. The message is still sent.
+type MessageSizeWarningHandler func(ctx context.Context, msg *azservicebus.Message, size, limit int)
+
+// WithMessageSizeWarning reports the messages whose size is above threshold, a fraction of the maximum message size,
+// for example 0.8 for 80%. They are counted in the message_size_warning_total metric and passed to handler when not nil,
+// so that growing payloads are noticed before the sends start failing.
+// It requires WithMaxMessageSize.
+func WithMessageSizeWarning(threshold float64, handler MessageSizeWarningHandler) SenderOption {
+	return func(options *SenderOptions) {
+		options.MessageSizeWarningThreshold = threshold
+		options.OnMessageSizeWarning = handler
+	}
+}
+
+// checkSize returns the message to send, after the OnMessageTooLarge handler when it exceeds the maximum message size.
+// The replacement returned by the handler is signed again when the Signing is enabled.
+// The message is sent unchecked when the maximum message size cannot be retrieved.
+func (d *Sender) checkSize(ctx context.Context, msg *azservicebus.Message) (*azservicebus.Message, error) {
+	if d.options.MaxMessageSize == nil {
+		return msg, nil
+	}
+	limit, err := d.options.MaxMessageSize.MaxMessageSize(ctx)
+	if err != nil {
+		log(ctx, fmt.Sprintf("failed to get max message size, sending unchecked: %s", err))
+		return msg, nil
+	}
+	size := estimateMessageSize(msg)
+	if size <= limit {
+		d.warnSize(ctx, msg, size, limit)
+		return msg, nil
+	}
+	tooLarge := &ErrMessageTooLarge{Size: size, Limit: limit}
+	if d.options.OnMessageTooLarge == nil {
+		return nil, tooLarge
+	}
+	replacement, err := d.options.OnMessageTooLarge(ctx, msg, tooLarge)
+	if err != nil {
+		return nil, fmt.Errorf("failed to handle oversized message: %w", err)
+	}
+	if d.options.Signing != nil {
+		if err := SignMessage(*d.options.Signing)(replacement); err != nil {
+			return nil, fmt.Errorf("failed to handle oversized message: %w", err)
+		}
+	}
+	if size = estimateMessageSize(replacement); size > limit {
+		return nil, &ErrMessageTooLarge{Size: size, Limit: limit}
+	}
+	d.warnSize(ctx, replacement, size, limit)
+	return replacement, nil
+}
+
+// warnSize reports the message when its size is above the MessageSizeWarningThreshold of the limit.
+func (d *Sender) warnSize(ctx context.Context, msg *azservicebus.Message, size, limit int) {
+	threshold := d.options.MessageSizeWarningThreshold
+	if threshold <= 0 || float64(size) < threshold*float64(limit) {
+		return
+	}
+	sender.Metric.IncMessageSizeWarningCount(d.options.EntityName)
+	log(ctx, fmt.Sprintf("message of %d bytes is above %.0f%% of the maximum message size of %d bytes", size, threshold*100, limit))
+	if d.options.OnMessageSizeWarning != nil {
+		d.options.OnMessageSizeWarning(ctx, msg, size, limit)
+	}
+}
+
+// estimateMessageSize estimates the size of the message on the wire from its body, its string properties
+// and its application properties. It ignores the AMQP encoding overhead.
+func estimateMessageSize(msg *azservicebus.Message) int {
+	size := len(msg.Body)
+	for _, s := range []*string{msg.MessageID, msg.ContentType, msg.CorrelationID, msg.SessionID, msg.PartitionKey,
+		msg.Subject, msg.To, msg.ReplyTo, msg.ReplyToSessionID} {
+		if s != nil {
+			size += len(*s)
+		}
+	}
+	for key, value := range msg.ApplicationProperties {
+		size += len(key)
+		switch v := value.(type) {
+		case string:
+			size += len(v)
+		case []byte:
+			size += len(v)
+		default:
+			size += 8
+		}
+	}
+	return size
+}
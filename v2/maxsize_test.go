@@ -0,0 +1,135 @@
+package shuttle
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	. "github.com/onsi/gomega"
+
+	metricsSender "github.com/Azure/go-shuttle/v2/metrics/sender"
+)
+
+func TestSender_MaxMessageSize(t *testing.T) {
+	g := NewWithT(t)
+	azSender := &fakeAzSender{}
+	sender := NewSenderWithOptions(azSender, WithMaxMessageSize(StaticMaxMessageSize(100)))
+
+	g.Expect(sender.SendMessage(context.Background(), "small")).To(Succeed())
+	g.Expect(azSender.SendMessageCalled).To(BeTrue())
+
+	azSender.SendMessageCalled = false
+	err := sender.SendMessage(context.Background(), strings.Repeat("a", 100))
+	var tooLarge *ErrMessageTooLarge
+	g.Expect(errors.As(err, &tooLarge)).To(BeTrue())
+	g.Expect(tooLarge.Size).To(BeNumerically(">", 100))
+	g.Expect(tooLarge.Limit).To(Equal(100))
+	g.Expect(azSender.SendMessageCalled).To(BeFalse())
+}
+
+func TestSender_OversizedMessageHandler(t *testing.T) {
+	g := NewWithT(t)
+	azSender := &fakeAzSender{}
+	var handledErr *ErrMessageTooLarge
+	sender := NewSenderWithOptions(azSender,
+		WithMaxMessageSize(StaticMaxMessageSize(100)),
+		WithOversizedMessageHandler(func(ctx context.Context, msg *azservicebus.Message, err *ErrMessageTooLarge) (*azservicebus.Message, error) {
+			handledErr = err
+			msg.Body = []byte(`"claim-check://blob/1"`)
+			return msg, nil
+		}))
+
+	g.Expect(sender.SendMessage(context.Background(), strings.Repeat("a", 100))).To(Succeed())
+	g.Expect(handledErr).ToNot(BeNil())
+	g.Expect(azSender.SendMessageReceivedValue.Body).To(Equal([]byte(`"claim-check://blob/1"`)))
+
+	// the replacement is checked too
+	sender = NewSenderWithOptions(azSender,
+		WithMaxMessageSize(StaticMaxMessageSize(100)),
+		WithOversizedMessageHandler(func(ctx context.Context, msg *azservicebus.Message, err *ErrMessageTooLarge) (*azservicebus.Message, error) {
+			return msg, nil
+		}))
+	err := sender.SendMessage(context.Background(), strings.Repeat("a", 100))
+	var tooLarge *ErrMessageTooLarge
+	g.Expect(errors.As(err, &tooLarge)).To(BeTrue())
+}
+
+func TestSender_MaxMessageSizeProviderError(t *testing.T) {
+	g := NewWithT(t)
+	azSender := &fakeAzSender{}
+	sender := NewSenderWithOptions(azSender, WithMaxMessageSize(MaxMessageSizeFunc(func(ctx context.Context) (int, error) {
+		return 0, errors.New("forbidden")
+	})))
+	g.Expect(sender.SendMessage(context.Background(), strings.Repeat("a", 1000))).To(Succeed())
+	g.Expect(azSender.SendMessageCalled).To(BeTrue())
+}
+
+func TestSender_MessageSizeWarning(t *testing.T) {
+	g := NewWithT(t)
+	azSender := &fakeAzSender{}
+	var warnedSize, warnedLimit int
+	sender := NewSenderWithOptions(azSender,
+		WithSenderEntityName("size-warning"),
+		WithMaxMessageSize(StaticMaxMessageSize(100)),
+		WithMessageSizeWarning(0.8, func(ctx context.Context, msg *azservicebus.Message, size, limit int) {
+			warnedSize, warnedLimit = size, limit
+		}))
+	informer := metricsSender.NewInformer()
+
+	g.Expect(sender.SendMessage(context.Background(), "small")).To(Succeed())
+	g.Expect(warnedSize).To(BeZero())
+
+	g.Expect(sender.SendMessage(context.Background(), strings.Repeat("a", 30))).To(Succeed())
+	g.Expect(azSender.SendMessageCalled).To(BeTrue())
+	g.Expect(warnedSize).To(BeNumerically(">=", 80))
+	g.Expect(warnedLimit).To(Equal(100))
+	count, err := informer.GetMessageSizeWarningCount("size-warning")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(count).To(Equal(float64(1)))
+}
+
+func TestSender_MaxMessageSizeBatchAndSchedule(t *testing.T) {
+	g := NewWithT(t)
+	azSender := &fakeAzSender{NewMessageBatchReturnValue: &azservicebus.MessageBatch{}}
+	var warned int
+	sender := NewSenderWithOptions(azSender,
+		WithMaxMessageSize(StaticMaxMessageSize(100)),
+		WithMessageSizeWarning(0.8, func(ctx context.Context, msg *azservicebus.Message, size, limit int) { warned++ }))
+	large := &azservicebus.Message{Body: []byte(strings.Repeat("a", 150))}
+	nearLimit := &azservicebus.Message{Body: []byte(strings.Repeat("a", 90))}
+
+	err := sender.SendMessageBatch(context.Background(), []*azservicebus.Message{large})
+	var addErr *BatchAddError
+	g.Expect(errors.As(err, &addErr)).To(BeTrue())
+	var tooLarge *ErrMessageTooLarge
+	g.Expect(errors.As(err, &tooLarge)).To(BeTrue())
+	g.Expect(tooLarge.Limit).To(Equal(100))
+
+	_, err = sender.ScheduleMessages(context.Background(), []*azservicebus.Message{nearLimit, large}, time.Now())
+	g.Expect(errors.As(err, &tooLarge)).To(BeTrue())
+	g.Expect(err).To(MatchError(ContainSubstring("failed to schedule message 1")))
+	g.Expect(azSender.ScheduledMessagesCalled).To(BeFalse())
+
+	_, err = sender.ScheduleMessages(context.Background(), []*azservicebus.Message{nearLimit}, time.Now())
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(azSender.ScheduledMessagesCalled).To(BeTrue())
+	g.Expect(warned).To(Equal(2))
+}
+
+func TestCachedEntityInfo_MaxMessageSize(t *testing.T) {
+	g := NewWithT(t)
+	info := EntityInfo{}
+	cache := newCachedEntityInfo(func(ctx context.Context) (*EntityInfo, error) { return &info, nil }, 0)
+	size, err := cache.MaxMessageSize(context.Background())
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(size).To(Equal(256 * 1024))
+
+	info.MaxMessageSizeInKilobytes = 1024
+	cache = newCachedEntityInfo(func(ctx context.Context) (*EntityInfo, error) { return &info, nil }, 0)
+	size, err = cache.MaxMessageSize(context.Background())
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(size).To(Equal(1024 * 1024))
+}
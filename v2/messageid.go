@@ -0,0 +1,38 @@
+package shuttle
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	"github.com/google/uuid"
+)
+
+// MessageIDGenerator returns the MessageID of the message sent for the MessageBody.
+// msg is the message built for the body, with its marshalled Body and its type application property,
+// before the message options are applied.
+// Entities with duplicate detection enabled drop the messages which MessageID was already seen within the
+// detection window, so the generator decides what a duplicate is. Returning an empty string leaves the MessageID unset.
+// The MessageID is generated once when the message is built, so the retries of its send keep it.
+type MessageIDGenerator func(mb MessageBody, msg *azservicebus.Message) string
+
+// UUIDv7MessageIDGenerator generates time-ordered UUIDv7 message ids. It is the default MessageIDGenerator of the Sender.
+// Each call returns a new id: a message is only deduplicated when the send is retried with the same azservicebus.Message.
+func UUIDv7MessageIDGenerator(_ MessageBody, _ *azservicebus.Message) string {
+	id, err := uuid.NewV7()
+	if err != nil {
+		return uuid.NewString()
+	}
+	return id.String()
+}
+
+// ContentHashMessageIDGenerator generates the hex encoded SHA-256 hash of the message type and marshalled body,
+// so that sending the same payload twice within the detection window is deduplicated.
+func ContentHashMessageIDGenerator(_ MessageBody, msg *azservicebus.Message) string {
+	hash := sha256.New()
+	hash.Write([]byte(fmt.Sprint(msg.ApplicationProperties[msgTypeField])))
+	hash.Write([]byte{0})
+	hash.Write(msg.Body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
@@ -0,0 +1,69 @@
+package shuttle
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	"github.com/google/uuid"
+	. "github.com/onsi/gomega"
+)
+
+func TestUUIDv7MessageIDGenerator(t *testing.T) {
+	g := NewWithT(t)
+	first := UUIDv7MessageIDGenerator("body", nil)
+	second := UUIDv7MessageIDGenerator("body", nil)
+	g.Expect(first).ToNot(Equal(second))
+	id, err := uuid.Parse(first)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(id.Version()).To(Equal(uuid.Version(7)))
+	g.Expect(first < second).To(BeTrue(), "UUIDv7 ids are time ordered")
+}
+
+func TestContentHashMessageIDGenerator(t *testing.T) {
+	g := NewWithT(t)
+	sender := NewSenderWithOptions(nil, WithMessageIDGenerator(ContentHashMessageIDGenerator))
+	id := func(mb MessageBody) string {
+		msg, err := sender.ToServiceBusMessage(context.Background(), mb)
+		g.Expect(err).ToNot(HaveOccurred())
+		return *msg.MessageID
+	}
+	type other struct{ Name string }
+	g.Expect(id(map[string]string{"a": "b"})).To(Equal(id(map[string]string{"a": "b"})))
+	g.Expect(id(map[string]string{"a": "b"})).ToNot(Equal(id(map[string]string{"a": "c"})))
+	g.Expect(id(map[string]string{"Name": "b"})).ToNot(Equal(id(other{Name: "b"})),
+		"the same payload of a different type is a different message")
+}
+
+func TestSender_DefaultMessageID(t *testing.T) {
+	g := NewWithT(t)
+	azSender := &fakeAzSender{}
+	sender := NewSender(azSender, nil)
+	msg, err := sender.ToServiceBusMessage(context.Background(), "body")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(msg.MessageID).ToNot(BeNil())
+	id, err := uuid.Parse(*msg.MessageID)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(id.Version()).To(Equal(uuid.Version(7)))
+}
+
+func TestSender_MessageIDGenerator(t *testing.T) {
+	g := NewWithT(t)
+	sender := NewSenderWithOptions(nil, WithMessageIDGenerator(func(mb MessageBody, msg *azservicebus.Message) string {
+		g.Expect(msg.Body).To(Equal([]byte(`"body"`)))
+		return "generated"
+	}))
+	msg, err := sender.ToServiceBusMessage(context.Background(), "body")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(*msg.MessageID).To(Equal("generated"))
+
+	msg, err = sender.ToServiceBusMessage(context.Background(), "body", SetMessageId(to.Ptr("explicit")))
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(*msg.MessageID).To(Equal("explicit"))
+
+	sender = NewSenderWithOptions(nil, WithMessageIDGenerator(func(MessageBody, *azservicebus.Message) string { return "" }))
+	msg, err = sender.ToServiceBusMessage(context.Background(), "body")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(msg.MessageID).To(BeNil())
+}
@@ -0,0 +1,103 @@
+package shuttle
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+
+	"github.com/Azure/go-shuttle/v2/metrics/processor"
+)
+
+type metricLabelsKey struct{}
+
+// metricLabels holds the labels added by the handler. The handler can add them from several goroutines.
+type metricLabels struct {
+	mu     sync.Mutex
+	values map[string]string
+}
+
+// AddMetricLabel sets a label on the processing metrics of the message being handled, for example
+// AddMetricLabel(ctx, "outcome", "skipped"). The labels are recorded by NewMetricLabelsHandler,
+// and are ignored when the handler is not wrapped in it.
+func AddMetricLabel(ctx context.Context, name, value string) {
+	labels, ok := ctx.Value(metricLabelsKey{}).(*metricLabels)
+	if !ok {
+		return
+	}
+	labels.mu.Lock()
+	defer labels.mu.Unlock()
+	labels.values[name] = value
+}
+
+// MetricLabels returns a copy of the labels added to the context with AddMetricLabel.
+func MetricLabels(ctx context.Context) map[string]string {
+	labels, ok := ctx.Value(metricLabelsKey{}).(*metricLabels)
+	if !ok {
+		return nil
+	}
+	labels.mu.Lock()
+	defer labels.mu.Unlock()
+	values := make(map[string]string, len(labels.values))
+	for name, value := range labels.values {
+		values[name] = value
+	}
+	return values
+}
+
+// NewMetricLabelsHandler is a middleware that records the duration and the settlement of the handling of each message
+// in the recorder, with the labels added by next with AddMetricLabel.
+// The label names must be declared when creating the recorder with processor.NewHandleDurationRecorder.
+func NewMetricLabelsHandler(recorder *processor.HandleDurationRecorder, next Handler) HandlerFunc {
+	return func(ctx context.Context, settler MessageSettler, message *azservicebus.ReceivedMessage) {
+		ctx = context.WithValue(ctx, metricLabelsKey{}, &metricLabels{values: map[string]string{}})
+		s := &settlementRecorder{MessageSettler: settler, settlement: processor.SettlementNone}
+		start := time.Now()
+		next.Handle(ctx, s, message)
+		recorder.ObserveHandleDuration(message, s.get(), MetricLabels(ctx), time.Since(start))
+	}
+}
+
+// settlementRecorder records the last successful settlement of the message.
+type settlementRecorder struct {
+	MessageSettler
+	mu         sync.Mutex
+	settlement string
+}
+
+// Unwrap returns the wrapped MessageSettler, for UnwrapSettler.
+func (s *settlementRecorder) Unwrap() MessageSettler {
+	return s.MessageSettler
+}
+
+func (s *settlementRecorder) AbandonMessage(ctx context.Context, message *azservicebus.ReceivedMessage, options *azservicebus.AbandonMessageOptions) error {
+	return s.record(processor.SettlementAbandon, s.MessageSettler.AbandonMessage(ctx, message, options))
+}
+
+func (s *settlementRecorder) CompleteMessage(ctx context.Context, message *azservicebus.ReceivedMessage, options *azservicebus.CompleteMessageOptions) error {
+	return s.record(processor.SettlementComplete, s.MessageSettler.CompleteMessage(ctx, message, options))
+}
+
+func (s *settlementRecorder) DeadLetterMessage(ctx context.Context, message *azservicebus.ReceivedMessage, options *azservicebus.DeadLetterOptions) error {
+	return s.record(processor.SettlementDeadLetter, s.MessageSettler.DeadLetterMessage(ctx, message, options))
+}
+
+func (s *settlementRecorder) DeferMessage(ctx context.Context, message *azservicebus.ReceivedMessage, options *azservicebus.DeferMessageOptions) error {
+	return s.record(processor.SettlementDefer, s.MessageSettler.DeferMessage(ctx, message, options))
+}
+
+func (s *settlementRecorder) record(settlement string, err error) error {
+	if err == nil {
+		s.mu.Lock()
+		s.settlement = settlement
+		s.mu.Unlock()
+	}
+	return err
+}
+
+func (s *settlementRecorder) get() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.settlement
+}
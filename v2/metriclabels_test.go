@@ -0,0 +1,51 @@
+package shuttle_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/Azure/go-shuttle/v2"
+	"github.com/Azure/go-shuttle/v2/metrics/processor"
+)
+
+func TestMetricLabelsHandler(t *testing.T) {
+	g := NewWithT(t)
+	recorder, err := processor.NewHandleDurationRecorder("outcome")
+	g.Expect(err).ToNot(HaveOccurred())
+	recorder.Init(prometheus.NewRegistry())
+	handler := shuttle.NewMetricLabelsHandler(recorder,
+		shuttle.HandlerFunc(func(ctx context.Context, settler shuttle.MessageSettler, message *azservicebus.ReceivedMessage) {
+			if message.MessageID == "skip" {
+				shuttle.AddMetricLabel(ctx, "outcome", "skipped")
+			}
+			g.Expect(settler.CompleteMessage(ctx, message, nil)).To(Succeed())
+		}))
+	settler := &fakeSettler{}
+	handler.Handle(context.Background(), settler, &azservicebus.ReceivedMessage{MessageID: "skip"})
+	handler.Handle(context.Background(), settler, &azservicebus.ReceivedMessage{MessageID: "process"})
+
+	g.Expect(settler.CompleteCalled.Load()).To(Equal(int32(2)))
+	g.Expect(recorder.GetHandleDurationCount(map[string]string{"outcome": "skipped", "settlement": processor.SettlementComplete})).To(Equal(uint64(1)))
+	g.Expect(recorder.GetHandleDurationCount(map[string]string{"outcome": ""})).To(Equal(uint64(1)))
+}
+
+func TestMetricLabelsHandler_Unsettled(t *testing.T) {
+	g := NewWithT(t)
+	recorder, err := processor.NewHandleDurationRecorder()
+	g.Expect(err).ToNot(HaveOccurred())
+	handler := shuttle.NewMetricLabelsHandler(recorder,
+		shuttle.HandlerFunc(func(ctx context.Context, settler shuttle.MessageSettler, message *azservicebus.ReceivedMessage) {}))
+	handler.Handle(context.Background(), &fakeSettler{}, &azservicebus.ReceivedMessage{})
+	g.Expect(recorder.GetHandleDurationCount(map[string]string{"settlement": processor.SettlementNone})).To(Equal(uint64(1)))
+}
+
+func TestAddMetricLabel_WithoutMiddleware(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+	g.Expect(func() { shuttle.AddMetricLabel(ctx, "outcome", "skipped") }).ToNot(Panic())
+	g.Expect(shuttle.MetricLabels(ctx)).To(BeNil())
+}
@@ -0,0 +1,285 @@
+// Package dashboard generates a Grafana dashboard and Prometheus alerting rules for the go-shuttle metrics.
+// The queries reference the metric names and labels registered by the metrics/processor and metrics/sender packages.
+package dashboard
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+// metric names, as registered by the metrics/processor and metrics/sender packages.
+const (
+	messageReceivedTotal    = "goshuttle_handler_message_received_total"
+	messageHandledTotal     = "goshuttle_handler_message_handled_total"
+	messageLockRenewedTotal = "goshuttle_handler_message_lock_renewed_total"
+	messageDeadlineReached  = "goshuttle_handler_message_deadline_reached_total"
+	concurrentMessageCount  = "goshuttle_handler_concurrent_message_count"
+	messageSettledTotal     = "goshuttle_handler_message_settled_total"
+	unsettledMessageCount   = "goshuttle_handler_unsettled_message_count"
+	middlewareDuration      = "goshuttle_handler_middleware_duration_seconds"
+	receiveBatchSize        = "goshuttle_handler_receive_batch_size"
+	emptyReceiveTotal       = "goshuttle_handler_empty_receive_total"
+	messageStaleTotal       = "goshuttle_handler_message_stale_total"
+	estimatedDrainSeconds   = "goshuttle_handler_estimated_drain_seconds"
+	processingStalled       = "goshuttle_handler_processing_stalled"
+	messageSentTotal        = "goshuttle_handler_message_sent_total"
+	sendLatencySeconds      = "goshuttle_handler_send_latency_seconds"
+	messageSizeBytes        = "goshuttle_handler_message_size_bytes"
+	batchSize               = "goshuttle_handler_batch_size"
+	sendThrottledTotal      = "goshuttle_handler_send_throttled_total"
+	batchWindowSize         = "goshuttle_handler_batch_window_size"
+	messageSizeWarningTotal = "goshuttle_handler_message_size_warning_total"
+)
+
+// Options configures the generated dashboard and alerting rules.
+type Options struct {
+	// Title of the dashboard. Defaults to "go-shuttle".
+	Title string
+	// Datasource is the uid of the Prometheus datasource. Defaults to the "datasource" dashboard variable.
+	Datasource string
+	// RateInterval is the range used in rate() queries. Defaults to 5m.
+	RateInterval string
+	// DeadLetterRatioThreshold is the ratio of dead-lettered to settled messages that fires the alert. Defaults to 0.05.
+	DeadLetterRatioThreshold float64
+	// SendFailureRatioThreshold is the ratio of failed sends that fires the alert. Defaults to 0.05.
+	SendFailureRatioThreshold float64
+}
+
+func (o *Options) withDefaults() Options {
+	opts := Options{}
+	if o != nil {
+		opts = *o
+	}
+	if opts.Title == "" {
+		opts.Title = "go-shuttle"
+	}
+	if opts.Datasource == "" {
+		opts.Datasource = "${datasource}"
+	}
+	if opts.RateInterval == "" {
+		opts.RateInterval = "5m"
+	}
+	if opts.DeadLetterRatioThreshold == 0 {
+		opts.DeadLetterRatioThreshold = 0.05
+	}
+	if opts.SendFailureRatioThreshold == 0 {
+		opts.SendFailureRatioThreshold = 0.05
+	}
+	return opts
+}
+
+type panel struct {
+	title   string
+	unit    string
+	queries []query
+}
+
+type query struct {
+	expr   string
+	legend string
+}
+
+func panels(o Options) []panel {
+	rate := func(metric string, by string) string {
+		return fmt.Sprintf("sum by (%s) (rate(%s[%s]))", by, metric, o.RateInterval)
+	}
+	quantile := func(q float64, metric string) string {
+		return fmt.Sprintf("histogram_quantile(%g, sum by (le, entity) (rate(%s_bucket[%s])))", q, metric, o.RateInterval)
+	}
+	return []panel{
+		{title: "Messages received", unit: "ops", queries: []query{
+			{expr: fmt.Sprintf("sum(rate(%s[%s]))", messageReceivedTotal, o.RateInterval), legend: "received"},
+		}},
+		{title: "Receive batch size", unit: "short", queries: []query{
+			{expr: quantile(0.5, receiveBatchSize), legend: "p50 {{entity}}"},
+			{expr: quantile(0.95, receiveBatchSize), legend: "p95 {{entity}}"},
+			{expr: rate(emptyReceiveTotal, "entity"), legend: "empty receives {{entity}}"},
+		}},
+		{title: "Messages handled by type", unit: "ops", queries: []query{
+			{expr: rate(messageHandledTotal, "messageType"), legend: "{{messageType}}"},
+		}},
+		{title: "Settlements", unit: "ops", queries: []query{
+			{expr: rate(messageSettledTotal, "entity, settlement"), legend: "{{entity}} {{settlement}}"},
+		}},
+		{title: "Unsettled messages in handlers", unit: "short", queries: []query{
+			{expr: fmt.Sprintf("sum by (entity) (%s)", unsettledMessageCount), legend: "{{entity}}"},
+		}},
+		{title: "Stale messages", unit: "ops", queries: []query{
+			{expr: rate(messageStaleTotal, "messageType"), legend: "{{messageType}}"},
+		}},
+		{title: "Estimated time to drain", unit: "s", queries: []query{
+			{expr: fmt.Sprintf("max by (entity) (%s)", estimatedDrainSeconds), legend: "{{entity}}"},
+			{expr: fmt.Sprintf("max by (entity) (%s)", processingStalled), legend: "stalled {{entity}}"},
+		}},
+		{title: "Concurrent messages", unit: "short", queries: []query{
+			{expr: fmt.Sprintf("sum by (messageType) (%s)", concurrentMessageCount), legend: "{{messageType}}"},
+		}},
+		{title: "Lock renewals", unit: "ops", queries: []query{
+			{expr: rate(messageLockRenewedTotal, "success"), legend: "success={{success}}"},
+			{expr: rate(messageDeadlineReached, "messageType"), legend: "deadline reached {{messageType}}"},
+		}},
+		{title: "Middleware duration", unit: "s", queries: []query{
+			{expr: fmt.Sprintf("histogram_quantile(0.95, sum by (le, middleware) (rate(%s_bucket[%s])))", middlewareDuration, o.RateInterval),
+				legend: "p95 {{middleware}}"},
+		}},
+		{title: "Messages sent", unit: "ops", queries: []query{
+			{expr: rate(messageSentTotal, "success"), legend: "success={{success}}"},
+		}},
+		{title: "Send latency", unit: "s", queries: []query{
+			{expr: quantile(0.5, sendLatencySeconds), legend: "p50 {{entity}}"},
+			{expr: quantile(0.95, sendLatencySeconds), legend: "p95 {{entity}}"},
+			{expr: quantile(0.99, sendLatencySeconds), legend: "p99 {{entity}}"},
+		}},
+		{title: "Throttled sends", unit: "ops", queries: []query{
+			{expr: rate(sendThrottledTotal, "entity"), legend: "{{entity}}"},
+		}},
+		{title: "Message size", unit: "bytes", queries: []query{
+			{expr: quantile(0.95, messageSizeBytes), legend: "p95 {{entity}}"},
+		}},
+		{title: "Messages near size limit", unit: "ops", queries: []query{
+			{expr: rate(messageSizeWarningTotal, "entity"), legend: "{{entity}}"},
+		}},
+		{title: "Batch size", unit: "short", queries: []query{
+			{expr: quantile(0.95, batchSize), legend: "p95 {{entity}}"},
+			{expr: fmt.Sprintf("max by (entity) (%s)", batchWindowSize), legend: "window {{entity}}"},
+		}},
+	}
+}
+
+// Dashboard returns the Grafana dashboard JSON model for the go-shuttle metrics.
+func Dashboard(options *Options) ([]byte, error) {
+	o := options.withDefaults()
+	datasource := map[string]any{"type": "prometheus", "uid": o.Datasource}
+	var grafanaPanels []map[string]any
+	for i, p := range panels(o) {
+		var targets []map[string]any
+		for j, q := range p.queries {
+			targets = append(targets, map[string]any{
+				"datasource":   datasource,
+				"expr":         q.expr,
+				"legendFormat": q.legend,
+				"refId":        string(rune('A' + j)),
+			})
+		}
+		grafanaPanels = append(grafanaPanels, map[string]any{
+			"id":         i + 1,
+			"type":       "timeseries",
+			"title":      p.title,
+			"datasource": datasource,
+			"gridPos":    map[string]int{"h": 8, "w": 12, "x": (i % 2) * 12, "y": (i / 2) * 8},
+			"fieldConfig": map[string]any{
+				"defaults":  map[string]any{"unit": p.unit},
+				"overrides": []any{},
+			},
+			"targets": targets,
+		})
+	}
+	dashboard := map[string]any{
+		"title":         o.Title,
+		"uid":           "goshuttle",
+		"schemaVersion": 38,
+		"time":          map[string]string{"from": "now-6h", "to": "now"},
+		"refresh":       "30s",
+		"tags":          []string{"go-shuttle", "servicebus"},
+		"templating": map[string]any{
+			"list": []map[string]any{{
+				"name":  "datasource",
+				"type":  "datasource",
+				"query": "prometheus",
+			}},
+		},
+		"panels": grafanaPanels,
+	}
+	return json.MarshalIndent(dashboard, "", "  ")
+}
+
+type ruleGroups struct {
+	Groups []ruleGroup `yaml:"groups"`
+}
+
+type ruleGroup struct {
+	Name  string `yaml:"name"`
+	Rules []rule `yaml:"rules"`
+}
+
+type rule struct {
+	Alert       string            `yaml:"alert"`
+	Expr        string            `yaml:"expr"`
+	For         string            `yaml:"for"`
+	Labels      map[string]string `yaml:"labels"`
+	Annotations map[string]string `yaml:"annotations"`
+}
+
+// AlertRules returns the Prometheus alerting rules file for the go-shuttle metrics.
+func AlertRules(options *Options) ([]byte, error) {
+	o := options.withDefaults()
+	warning := map[string]string{"severity": "warning"}
+	groups := ruleGroups{Groups: []ruleGroup{{
+		Name: "go-shuttle",
+		Rules: []rule{
+			{
+				Alert: "GoShuttleHighDeadLetterRatio",
+				Expr: fmt.Sprintf(`sum by (entity) (rate(%[1]s{settlement="deadletter"}[%[2]s])) / sum by (entity) (rate(%[1]s[%[2]s])) > %[3]g`,
+					messageSettledTotal, o.RateInterval, o.DeadLetterRatioThreshold),
+				For:    "10m",
+				Labels: warning,
+				Annotations: map[string]string{
+					"summary": "More than {{ $value | humanizePercentage }} of the messages of {{ $labels.entity }} are dead-lettered",
+				},
+			},
+			{
+				Alert:  "GoShuttleLockRenewalFailures",
+				Expr:   fmt.Sprintf(`sum(rate(%s{success="false"}[%s])) > 0`, messageLockRenewedTotal, o.RateInterval),
+				For:    "10m",
+				Labels: warning,
+				Annotations: map[string]string{
+					"summary": "Message locks fail to be renewed, messages may be processed more than once",
+				},
+			},
+			{
+				Alert: "GoShuttleHighSendFailureRatio",
+				Expr: fmt.Sprintf(`sum(rate(%[1]s{success="false"}[%[2]s])) / sum(rate(%[1]s[%[2]s])) > %[3]g`,
+					messageSentTotal, o.RateInterval, o.SendFailureRatioThreshold),
+				For:    "10m",
+				Labels: warning,
+				Annotations: map[string]string{
+					"summary": "More than {{ $value | humanizePercentage }} of the send operations fail",
+				},
+			},
+			{
+				Alert:  "GoShuttleSendThrottled",
+				Expr:   fmt.Sprintf(`sum by (entity) (rate(%s[%s])) > 0`, sendThrottledTotal, o.RateInterval),
+				For:    "15m",
+				Labels: warning,
+				Annotations: map[string]string{
+					"summary": "Sends to {{ $labels.entity }} are throttled by the namespace, consider scaling its messaging units",
+				},
+			},
+		},
+	}}}
+	return yaml.Marshal(groups)
+}
+
+// WriteFiles writes the dashboard to goshuttle-dashboard.json and the alerting rules to goshuttle-alerts.yaml in dir.
+// It can be invoked from a go:generate directive to keep the generated files in sync with the library version.
+func WriteFiles(dir string, options *Options) error {
+	dashboard, err := Dashboard(options)
+	if err != nil {
+		return fmt.Errorf("failed to generate dashboard: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "goshuttle-dashboard.json"), dashboard, 0o644); err != nil {
+		return fmt.Errorf("failed to write dashboard: %w", err)
+	}
+	rules, err := AlertRules(options)
+	if err != nil {
+		return fmt.Errorf("failed to generate alert rules: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "goshuttle-alerts.yaml"), rules, 0o644); err != nil {
+		return fmt.Errorf("failed to write alert rules: %w", err)
+	}
+	return nil
+}
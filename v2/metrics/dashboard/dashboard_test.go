@@ -0,0 +1,122 @@
+package dashboard
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	"gopkg.in/yaml.v3"
+
+	"github.com/Azure/go-shuttle/v2/metrics/processor"
+	"github.com/Azure/go-shuttle/v2/metrics/sender"
+)
+
+type collectingRegisterer struct {
+	collectors []prometheus.Collector
+}
+
+func (c *collectingRegisterer) Register(collector prometheus.Collector) error {
+	c.collectors = append(c.collectors, collector)
+	return nil
+}
+
+func (c *collectingRegisterer) MustRegister(collectors ...prometheus.Collector) {
+	c.collectors = append(c.collectors, collectors...)
+}
+
+func (c *collectingRegisterer) Unregister(_ prometheus.Collector) bool {
+	return false
+}
+
+var fqNameRegexp = regexp.MustCompile(`fqName: "([^"]+)"`)
+
+// registeredMetricNames returns the names of the metrics registered by the processor and sender packages.
+func registeredMetricNames(t *testing.T) []string {
+	reg := &collectingRegisterer{}
+	processor.Metric.Init(reg)
+	sender.Metric.Init(reg)
+	var names []string
+	for _, collector := range reg.collectors {
+		descs := make(chan *prometheus.Desc, 10)
+		collector.Describe(descs)
+		close(descs)
+		for desc := range descs {
+			match := fqNameRegexp.FindStringSubmatch(desc.String())
+			if match == nil {
+				t.Fatalf("failed to parse metric desc %s", desc)
+			}
+			names = append(names, match[1])
+		}
+	}
+	return names
+}
+
+func TestDashboard_CoversAllMetrics(t *testing.T) {
+	g := NewWithT(t)
+	dashboard, err := Dashboard(nil)
+	g.Expect(err).ToNot(HaveOccurred())
+	names := registeredMetricNames(t)
+	g.Expect(names).To(HaveLen(20))
+	for _, name := range names {
+		g.Expect(string(dashboard)).To(ContainSubstring(name), "the dashboard should have a panel for %s", name)
+	}
+}
+
+func TestDashboard_ReferencesRegisteredMetrics(t *testing.T) {
+	g := NewWithT(t)
+	names := registeredMetricNames(t)
+	var model struct {
+		Title  string `json:"title"`
+		Panels []struct {
+			Title   string `json:"title"`
+			Targets []struct {
+				Expr string `json:"expr"`
+			} `json:"targets"`
+		} `json:"panels"`
+	}
+	dashboard, err := Dashboard(&Options{Title: "orders"})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(json.Unmarshal(dashboard, &model)).To(Succeed())
+	g.Expect(model.Title).To(Equal("orders"))
+	g.Expect(model.Panels).ToNot(BeEmpty())
+	metricRegexp := regexp.MustCompile(`goshuttle_[a-z_]+`)
+	for _, p := range model.Panels {
+		g.Expect(p.Targets).ToNot(BeEmpty(), p.Title)
+		for _, target := range p.Targets {
+			for _, metric := range metricRegexp.FindAllString(target.Expr, -1) {
+				g.Expect(names).To(ContainElement(strings.TrimSuffix(metric, "_bucket")), target.Expr)
+			}
+		}
+	}
+}
+
+func TestAlertRules(t *testing.T) {
+	g := NewWithT(t)
+	names := registeredMetricNames(t)
+	rules, err := AlertRules(&Options{DeadLetterRatioThreshold: 0.1})
+	g.Expect(err).ToNot(HaveOccurred())
+	var parsed ruleGroups
+	g.Expect(yaml.Unmarshal(rules, &parsed)).To(Succeed())
+	g.Expect(parsed.Groups).To(HaveLen(1))
+	g.Expect(parsed.Groups[0].Rules).To(HaveLen(4))
+	g.Expect(parsed.Groups[0].Rules[0].Expr).To(HaveSuffix("> 0.1"))
+	metricRegexp := regexp.MustCompile(`goshuttle_[a-z_]+`)
+	for _, r := range parsed.Groups[0].Rules {
+		for _, metric := range metricRegexp.FindAllString(r.Expr, -1) {
+			g.Expect(names).To(ContainElement(metric), r.Alert)
+		}
+	}
+}
+
+func TestWriteFiles(t *testing.T) {
+	g := NewWithT(t)
+	dir := t.TempDir()
+	g.Expect(WriteFiles(dir, nil)).To(Succeed())
+	g.Expect(filepath.Join(dir, "goshuttle-dashboard.json")).To(BeAnExistingFile())
+	g.Expect(filepath.Join(dir, "goshuttle-alerts.yaml")).To(BeAnExistingFile())
+	g.Expect(WriteFiles(filepath.Join(dir, "missing"), nil)).ToNot(Succeed())
+}
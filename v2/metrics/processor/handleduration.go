@@ -0,0 +1,83 @@
+package processor
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	prom "github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// SettlementNone is the settlement label value of the messages that the handler did not settle.
+const SettlementNone = "none"
+
+// HandleDurationRecorder records the duration of the handler, with the message type and settlement labels,
+// and the labels declared by the user code.
+// Prometheus requires the label names to be known upfront: the labels that are not declared are ignored,
+// and the declared labels that are not set are recorded with an empty value.
+type HandleDurationRecorder struct {
+	labelNames []string
+	duration   *prom.HistogramVec
+}
+
+// NewHandleDurationRecorder creates the goshuttle_handler_handle_duration_seconds histogram with the additional label names.
+// It must be registered with Init.
+// It returns an error when a label name is empty, declared twice, or reserved for the messageType and settlement labels.
+func NewHandleDurationRecorder(labelNames ...string) (*HandleDurationRecorder, error) {
+	declared := map[string]struct{}{}
+	for _, name := range labelNames {
+		switch _, ok := declared[name]; {
+		case name == "":
+			return nil, fmt.Errorf("invalid label name: label names must not be empty")
+		case name == messageTypeLabel || name == settlementLabel:
+			return nil, fmt.Errorf("invalid label name %q: the label is reserved", name)
+		case ok:
+			return nil, fmt.Errorf("invalid label name %q: the label is declared twice", name)
+		}
+		declared[name] = struct{}{}
+	}
+	names := append([]string{messageTypeLabel, settlementLabel}, labelNames...)
+	return &HandleDurationRecorder{
+		labelNames: labelNames,
+		duration: prom.NewHistogramVec(prom.HistogramOpts{
+			Name:      "handle_duration_seconds",
+			Help:      "time spent handling a message, by settlement and user-defined labels",
+			Subsystem: subsystem,
+			Buckets:   prom.DefBuckets,
+		}, names),
+	}, nil
+}
+
+// Init registers the histogram.
+func (r *HandleDurationRecorder) Init(reg prom.Registerer) {
+	reg.MustRegister(r.duration)
+}
+
+// ObserveHandleDuration records the handling of the message, with the values of the declared labels.
+func (r *HandleDurationRecorder) ObserveHandleDuration(
+	msg *azservicebus.ReceivedMessage,
+	settlement string,
+	labels map[string]string,
+	duration time.Duration) {
+	values := getMessageTypeLabel(msg)
+	values[settlementLabel] = settlement
+	for _, name := range r.labelNames {
+		values[name] = labels[name]
+	}
+	r.duration.With(values).Observe(duration.Seconds())
+}
+
+// GetHandleDurationCount retrieves the number of durations observed with the labels, across the other labels.
+func (r *HandleDurationRecorder) GetHandleDurationCount(labels map[string]string) uint64 {
+	var total uint64
+	collect(r.duration, func(m *dto.Metric) {
+		for name, value := range labels {
+			if !hasLabel(m, name, value) {
+				return
+			}
+		}
+		total += m.GetHistogram().GetSampleCount()
+	})
+	return total
+}
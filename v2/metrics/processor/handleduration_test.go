@@ -0,0 +1,40 @@
+package processor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestHandleDurationRecorder(t *testing.T) {
+	g := NewWithT(t)
+	r, err := NewHandleDurationRecorder("outcome", "tenant")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(func() { r.Init(prometheus.NewRegistry()) }).ToNot(Panic())
+	msg := &azservicebus.ReceivedMessage{ApplicationProperties: map[string]interface{}{"type": "someType"}}
+
+	r.ObserveHandleDuration(msg, SettlementComplete, map[string]string{"outcome": "skipped", "undeclared": "x"}, time.Millisecond)
+	r.ObserveHandleDuration(msg, SettlementAbandon, map[string]string{"outcome": "failed", "tenant": "contoso"}, time.Millisecond)
+	r.ObserveHandleDuration(msg, SettlementNone, nil, time.Millisecond)
+
+	g.Expect(r.GetHandleDurationCount(nil)).To(Equal(uint64(3)))
+	g.Expect(r.GetHandleDurationCount(map[string]string{"outcome": "skipped", "tenant": ""})).To(Equal(uint64(1)))
+	g.Expect(r.GetHandleDurationCount(map[string]string{"tenant": "contoso", "settlement": SettlementAbandon})).To(Equal(uint64(1)))
+	g.Expect(r.GetHandleDurationCount(map[string]string{"messageType": "someType", "settlement": SettlementNone})).To(Equal(uint64(1)))
+}
+
+func TestHandleDurationRecorder_InvalidLabelNames(t *testing.T) {
+	g := NewWithT(t)
+	for _, names := range [][]string{
+		{"messageType"},
+		{"outcome", "settlement"},
+		{"outcome", "outcome"},
+		{""},
+	} {
+		_, err := NewHandleDurationRecorder(names...)
+		g.Expect(err).To(HaveOccurred(), "label names %v", names)
+	}
+}
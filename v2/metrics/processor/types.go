@@ -3,6 +3,7 @@ package processor
 import (
 	"fmt"
 	"strconv"
+	"time"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
 	prom "github.com/prometheus/client_golang/prometheus"
@@ -14,6 +15,19 @@ const (
 	messageTypeLabel   = "messageType"
 	deliveryCountLabel = "deliveryCount"
 	successLabel       = "success"
+	entityLabel        = "entity"
+	settlementLabel    = "settlement"
+	middlewareLabel    = "middleware"
+	// defaultEntity labels the metrics of the processors created without an entity name.
+	defaultEntity = "default"
+)
+
+// Settlement label values of the message_settled_total metric.
+const (
+	SettlementComplete   = "complete"
+	SettlementAbandon    = "abandon"
+	SettlementDeadLetter = "deadletter"
+	SettlementDefer      = "defer"
 )
 
 var (
@@ -49,6 +63,48 @@ func newRegistry() *Registry {
 			Help:      "number of messages being handled concurrently",
 			Subsystem: subsystem,
 		}, []string{messageTypeLabel}),
+		MessageSettledCount: prom.NewCounterVec(prom.CounterOpts{
+			Name:      "message_settled_total",
+			Help:      "total number of messages settled by the handler, by settlement",
+			Subsystem: subsystem,
+		}, []string{messageTypeLabel, entityLabel, settlementLabel}),
+		UnsettledMessageCount: prom.NewGaugeVec(prom.GaugeOpts{
+			Name:      "unsettled_message_count",
+			Help:      "number of messages being handled and not settled yet",
+			Subsystem: subsystem,
+		}, []string{entityLabel}),
+		MiddlewareDuration: prom.NewHistogramVec(prom.HistogramOpts{
+			Name:      "middleware_duration_seconds",
+			Help:      "time spent in a named middleware of the handler chain, including the handlers it calls",
+			Subsystem: subsystem,
+			Buckets:   prom.DefBuckets,
+		}, []string{middlewareLabel}),
+		ReceiveBatchSize: prom.NewHistogramVec(prom.HistogramOpts{
+			Name:      "receive_batch_size",
+			Help:      "number of messages returned by a receive call, by entity",
+			Subsystem: subsystem,
+			Buckets:   prom.ExponentialBuckets(1, 2, 10),
+		}, []string{entityLabel}),
+		EmptyReceiveCount: prom.NewCounterVec(prom.CounterOpts{
+			Name:      "empty_receive_total",
+			Help:      "total number of receive calls that returned no message, by entity",
+			Subsystem: subsystem,
+		}, []string{entityLabel}),
+		MessageStaleCount: prom.NewCounterVec(prom.CounterOpts{
+			Name:      "message_stale_total",
+			Help:      "total number of messages settled without being handled because they were about to expire",
+			Subsystem: subsystem,
+		}, []string{messageTypeLabel}),
+		EstimatedDrainSeconds: prom.NewGaugeVec(prom.GaugeOpts{
+			Name:      "estimated_drain_seconds",
+			Help:      "estimated time to drain the messages waiting in the entity at the current processing rate",
+			Subsystem: subsystem,
+		}, []string{entityLabel}),
+		ProcessingStalled: prom.NewGaugeVec(prom.GaugeOpts{
+			Name:      "processing_stalled",
+			Help:      "1 when messages are waiting in the entity but none was handled since the previous estimate, 0 otherwise",
+			Subsystem: subsystem,
+		}, []string{entityLabel}),
 	}
 }
 
@@ -65,7 +121,15 @@ func (m *Registry) Init(reg prom.Registerer) {
 		m.MessageHandledCount,
 		m.MessageLockRenewedCount,
 		m.MessageDeadlineReachedCount,
-		m.ConcurrentMessageCount)
+		m.ConcurrentMessageCount,
+		m.MessageSettledCount,
+		m.UnsettledMessageCount,
+		m.MiddlewareDuration,
+		m.ReceiveBatchSize,
+		m.EmptyReceiveCount,
+		m.MessageStaleCount,
+		m.EstimatedDrainSeconds,
+		m.ProcessingStalled)
 }
 
 type Registry struct {
@@ -74,6 +138,14 @@ type Registry struct {
 	MessageLockRenewedCount     *prom.CounterVec
 	MessageDeadlineReachedCount *prom.CounterVec
 	ConcurrentMessageCount      *prom.GaugeVec
+	MessageSettledCount         *prom.CounterVec
+	UnsettledMessageCount       *prom.GaugeVec
+	MiddlewareDuration          *prom.HistogramVec
+	ReceiveBatchSize            *prom.HistogramVec
+	EmptyReceiveCount           *prom.CounterVec
+	MessageStaleCount           *prom.CounterVec
+	EstimatedDrainSeconds       *prom.GaugeVec
+	ProcessingStalled           *prom.GaugeVec
 }
 
 // Recorder allows to initialize the metric registry and increase/decrease the registered metrics at runtime.
@@ -86,6 +158,13 @@ type Recorder interface {
 	IncMessageHandled(msg *azservicebus.ReceivedMessage)
 	IncMessageReceived(float64)
 	IncConcurrentMessageCount(msg *azservicebus.ReceivedMessage)
+	IncMessageSettled(msg *azservicebus.ReceivedMessage, entity string, settlement string)
+	IncUnsettledMessageCount(entity string)
+	DecUnsettledMessageCount(entity string)
+	ObserveMiddlewareDuration(middleware string, duration time.Duration)
+	ObserveReceiveBatch(entity string, count int)
+	IncMessageStale(msg *azservicebus.ReceivedMessage)
+	SetEstimatedDrainTime(entity string, timeToDrain time.Duration, stalled bool)
 }
 
 // IncMessageLockRenewedSuccess increase the message lock renewal success counter
@@ -125,11 +204,62 @@ func (m *Registry) IncMessageDeadlineReachedCount(msg *azservicebus.ReceivedMess
 	m.MessageDeadlineReachedCount.With(labels).Inc()
 }
 
+// IncMessageSettled increases the settled message counter for the settlement
+func (m *Registry) IncMessageSettled(msg *azservicebus.ReceivedMessage, entity string, settlement string) {
+	labels := getMessageTypeLabel(msg)
+	labels[entityLabel] = entityOrDefault(entity)
+	labels[settlementLabel] = settlement
+	m.MessageSettledCount.With(labels).Inc()
+}
+
+// IncUnsettledMessageCount increases the gauge of the messages being handled and not settled yet
+func (m *Registry) IncUnsettledMessageCount(entity string) {
+	m.UnsettledMessageCount.With(prom.Labels{entityLabel: entityOrDefault(entity)}).Inc()
+}
+
+// DecUnsettledMessageCount decreases the gauge of the messages being handled and not settled yet
+func (m *Registry) DecUnsettledMessageCount(entity string) {
+	m.UnsettledMessageCount.With(prom.Labels{entityLabel: entityOrDefault(entity)}).Dec()
+}
+
+// ObserveMiddlewareDuration records the time spent in the named middleware
+func (m *Registry) ObserveMiddlewareDuration(middleware string, duration time.Duration) {
+	m.MiddlewareDuration.With(prom.Labels{middlewareLabel: middleware}).Observe(duration.Seconds())
+}
+
 // IncMessageReceived increases the message received counter
 func (m *Registry) IncMessageReceived(count float64) {
 	m.MessageReceivedCount.With(map[string]string{}).Add(count)
 }
 
+// ObserveReceiveBatch records the number of messages returned by a receive call,
+// and counts the call as an empty receive when no message was returned
+func (m *Registry) ObserveReceiveBatch(entity string, count int) {
+	labels := prom.Labels{entityLabel: entityOrDefault(entity)}
+	m.ReceiveBatchSize.With(labels).Observe(float64(count))
+	if count == 0 {
+		m.EmptyReceiveCount.With(labels).Inc()
+	}
+}
+
+// IncMessageStale increases the stale message counter
+func (m *Registry) IncMessageStale(msg *azservicebus.ReceivedMessage) {
+	m.MessageStaleCount.With(getMessageTypeLabel(msg)).Inc()
+}
+
+// SetEstimatedDrainTime sets the estimated time to drain the entity, and whether the processing is stalled.
+// The estimated time is removed while the processing is stalled, as it cannot be estimated.
+func (m *Registry) SetEstimatedDrainTime(entity string, timeToDrain time.Duration, stalled bool) {
+	labels := prom.Labels{entityLabel: entityOrDefault(entity)}
+	if stalled {
+		m.EstimatedDrainSeconds.Delete(labels)
+		m.ProcessingStalled.With(labels).Set(1)
+		return
+	}
+	m.EstimatedDrainSeconds.With(labels).Set(timeToDrain.Seconds())
+	m.ProcessingStalled.With(labels).Set(0)
+}
+
 // Informer allows to inspect metrics value stored in the registry at runtime
 type Informer struct {
 	registry *Registry
@@ -152,6 +282,102 @@ func (i *Informer) GetMessageLockRenewedFailureCount() (float64, error) {
 	return total, nil
 }
 
+// GetMessageSettledCount retrieves the number of messages settled with the settlement, across message types and entities
+func (i *Informer) GetMessageSettledCount(settlement string) (float64, error) {
+	var total float64
+	collect(i.registry.MessageSettledCount, func(m *dto.Metric) {
+		if !hasLabel(m, settlementLabel, settlement) {
+			return
+		}
+		total += m.GetCounter().GetValue()
+	})
+	return total, nil
+}
+
+// GetMiddlewareDurationCount retrieves the number of durations observed for the middleware
+func (i *Informer) GetMiddlewareDurationCount(middleware string) (uint64, error) {
+	var total uint64
+	collect(i.registry.MiddlewareDuration, func(m *dto.Metric) {
+		if !hasLabel(m, middlewareLabel, middleware) {
+			return
+		}
+		total += m.GetHistogram().GetSampleCount()
+	})
+	return total, nil
+}
+
+// GetUnsettledMessageCount retrieves the current number of messages being handled and not settled yet, across entities
+func (i *Informer) GetUnsettledMessageCount() (float64, error) {
+	var total float64
+	collect(i.registry.UnsettledMessageCount, func(m *dto.Metric) {
+		total += m.GetGauge().GetValue()
+	})
+	return total, nil
+}
+
+// GetReceiveBatchCount retrieves the number of receive calls observed for the entity
+func (i *Informer) GetReceiveBatchCount(entity string) (uint64, error) {
+	var total uint64
+	collect(i.registry.ReceiveBatchSize, func(m *dto.Metric) {
+		if !hasLabel(m, entityLabel, entityOrDefault(entity)) {
+			return
+		}
+		total += m.GetHistogram().GetSampleCount()
+	})
+	return total, nil
+}
+
+// GetEmptyReceiveCount retrieves the number of receive calls that returned no message for the entity
+func (i *Informer) GetEmptyReceiveCount(entity string) (float64, error) {
+	var total float64
+	collect(i.registry.EmptyReceiveCount, func(m *dto.Metric) {
+		if !hasLabel(m, entityLabel, entityOrDefault(entity)) {
+			return
+		}
+		total += m.GetCounter().GetValue()
+	})
+	return total, nil
+}
+
+// GetMessageStaleCount retrieves the number of stale messages, across message types
+func (i *Informer) GetMessageStaleCount() (float64, error) {
+	var total float64
+	collect(i.registry.MessageStaleCount, func(m *dto.Metric) {
+		total += m.GetCounter().GetValue()
+	})
+	return total, nil
+}
+
+// GetEstimatedDrainSeconds retrieves the estimated time to drain the entity, in seconds
+func (i *Informer) GetEstimatedDrainSeconds(entity string) (float64, error) {
+	var total float64
+	collect(i.registry.EstimatedDrainSeconds, func(m *dto.Metric) {
+		if !hasLabel(m, entityLabel, entityOrDefault(entity)) {
+			return
+		}
+		total += m.GetGauge().GetValue()
+	})
+	return total, nil
+}
+
+// IsProcessingStalled reports whether the processing of the entity is stalled
+func (i *Informer) IsProcessingStalled(entity string) (bool, error) {
+	stalled := false
+	collect(i.registry.ProcessingStalled, func(m *dto.Metric) {
+		if hasLabel(m, entityLabel, entityOrDefault(entity)) && m.GetGauge().GetValue() > 0 {
+			stalled = true
+		}
+	})
+	return stalled, nil
+}
+
+func entityOrDefault(entity string) string {
+	if entity == "" {
+		return defaultEntity
+	}
+	return entity
+}
+
 func hasLabel(m *dto.Metric, key string, value string) bool {
 	for _, pair := range m.Label {
 		if pair == nil {
@@ -2,6 +2,7 @@ package processor
 
 import (
 	"testing"
+	"time"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
 	. "github.com/onsi/gomega"
@@ -30,7 +31,7 @@ func TestRegistry_Init(t *testing.T) {
 	fRegistry := &fakeRegistry{}
 	g.Expect(func() { r.Init(prometheus.NewRegistry()) }).ToNot(Panic())
 	g.Expect(func() { r.Init(fRegistry) }).ToNot(Panic())
-	g.Expect(fRegistry.collectors).To(HaveLen(5))
+	g.Expect(fRegistry.collectors).To(HaveLen(13))
 	Metric.IncMessageReceived(10)
 
 }
@@ -84,3 +85,99 @@ func TestMetrics(t *testing.T) {
 	}
 
 }
+
+func TestSettlementMetrics(t *testing.T) {
+	g := NewWithT(t)
+	r := newRegistry()
+	informer := &Informer{registry: r}
+	msg := &azservicebus.ReceivedMessage{ApplicationProperties: map[string]interface{}{"type": "someType"}}
+
+	r.IncMessageSettled(msg, "queue", SettlementComplete)
+	r.IncMessageSettled(msg, "other", SettlementComplete)
+	r.IncMessageSettled(msg, "queue", SettlementAbandon)
+	count, err := informer.GetMessageSettledCount(SettlementComplete)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(count).To(Equal(float64(2)))
+	count, _ = informer.GetMessageSettledCount(SettlementAbandon)
+	g.Expect(count).To(Equal(float64(1)))
+	count, _ = informer.GetMessageSettledCount(SettlementDeadLetter)
+	g.Expect(count).To(Equal(float64(0)))
+
+	r.IncUnsettledMessageCount("queue")
+	r.IncUnsettledMessageCount("queue")
+	r.DecUnsettledMessageCount("queue")
+	count, err = informer.GetUnsettledMessageCount()
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(count).To(Equal(float64(1)))
+
+	r.ObserveMiddlewareDuration("renewlock", time.Millisecond)
+	r.ObserveMiddlewareDuration("renewlock", time.Second)
+	observed, err := informer.GetMiddlewareDurationCount("renewlock")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(observed).To(Equal(uint64(2)))
+}
+
+func TestReceiveBatchMetrics(t *testing.T) {
+	g := NewWithT(t)
+	r := newRegistry()
+	informer := &Informer{registry: r}
+
+	r.ObserveReceiveBatch("queue", 5)
+	r.ObserveReceiveBatch("queue", 0)
+	r.ObserveReceiveBatch("queue", 0)
+	r.ObserveReceiveBatch("other", 0)
+
+	observed, err := informer.GetReceiveBatchCount("queue")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(observed).To(Equal(uint64(3)))
+	empty, err := informer.GetEmptyReceiveCount("queue")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(empty).To(Equal(float64(2)))
+	empty, _ = informer.GetEmptyReceiveCount("other")
+	g.Expect(empty).To(Equal(float64(1)))
+
+	r.ObserveReceiveBatch("", 0)
+	empty, _ = informer.GetEmptyReceiveCount(defaultEntity)
+	g.Expect(empty).To(Equal(float64(1)))
+	empty, _ = informer.GetEmptyReceiveCount("")
+	g.Expect(empty).To(Equal(float64(1)))
+}
+
+func TestMessageStaleMetrics(t *testing.T) {
+	g := NewWithT(t)
+	r := newRegistry()
+	informer := &Informer{registry: r}
+	msg := &azservicebus.ReceivedMessage{ApplicationProperties: map[string]interface{}{"type": "someType"}}
+
+	r.IncMessageStale(msg)
+	r.IncMessageStale(&azservicebus.ReceivedMessage{})
+	count, err := informer.GetMessageStaleCount()
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(count).To(Equal(float64(2)))
+}
+
+func TestEstimatedDrainMetrics(t *testing.T) {
+	g := NewWithT(t)
+	r := newRegistry()
+	informer := &Informer{registry: r}
+
+	r.SetEstimatedDrainTime("queue", time.Minute, false)
+	seconds, err := informer.GetEstimatedDrainSeconds("queue")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(seconds).To(Equal(float64(60)))
+
+	stalled, err := informer.IsProcessingStalled("queue")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(stalled).To(BeFalse())
+
+	r.SetEstimatedDrainTime("queue", 0, true)
+	series := make(chan prometheus.Metric, 1)
+	r.EstimatedDrainSeconds.Collect(series)
+	g.Expect(series).To(BeEmpty(), "no estimate while stalled")
+	stalled, _ = informer.IsProcessingStalled("queue")
+	g.Expect(stalled).To(BeTrue())
+
+	r.SetEstimatedDrainTime("", time.Second, false)
+	seconds, _ = informer.GetEstimatedDrainSeconds(defaultEntity)
+	g.Expect(seconds).To(Equal(float64(1)))
+}
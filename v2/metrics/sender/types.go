@@ -1,6 +1,9 @@
 package sender
 
 import (
+	"strconv"
+	"time"
+
 	prom "github.com/prometheus/client_golang/prometheus"
 	dto "github.com/prometheus/client_model/go"
 )
@@ -8,6 +11,9 @@ import (
 const (
 	subsystem    = "goshuttle_handler"
 	successLabel = "success"
+	entityLabel  = "entity"
+	// defaultEntity labels the metrics of the senders created without an entity name.
+	defaultEntity = "default"
 )
 
 var (
@@ -23,17 +29,64 @@ func newRegistry() *Registry {
 			Help:      "total number of messages sent by the sender",
 			Subsystem: subsystem,
 		}, []string{successLabel}),
+		SendLatency: prom.NewHistogramVec(prom.HistogramOpts{
+			Name:      "send_latency_seconds",
+			Help:      "latency of the send operations",
+			Subsystem: subsystem,
+			Buckets:   prom.DefBuckets,
+		}, []string{entityLabel, successLabel}),
+		MessageSize: prom.NewHistogramVec(prom.HistogramOpts{
+			Name:      "message_size_bytes",
+			Help:      "size of the serialized message bodies sent",
+			Subsystem: subsystem,
+			// 256B to 1MB
+			Buckets: prom.ExponentialBuckets(256, 4, 7),
+		}, []string{entityLabel}),
+		BatchSize: prom.NewHistogramVec(prom.HistogramOpts{
+			Name:      "batch_size",
+			Help:      "number of messages per batch sent",
+			Subsystem: subsystem,
+			Buckets:   prom.ExponentialBuckets(1, 2, 10),
+		}, []string{entityLabel}),
+		ThrottledCount: prom.NewCounterVec(prom.CounterOpts{
+			Name:      "send_throttled_total",
+			Help:      "total number of send operations rejected because the namespace is throttling",
+			Subsystem: subsystem,
+		}, []string{entityLabel}),
+		BatchWindowSize: prom.NewGaugeVec(prom.GaugeOpts{
+			Name:      "batch_window_size",
+			Help:      "current batch size of the adaptive batch senders",
+			Subsystem: subsystem,
+		}, []string{entityLabel}),
+		MessageSizeWarningCount: prom.NewCounterVec(prom.CounterOpts{
+			Name:      "message_size_warning_total",
+			Help:      "total number of messages sent with a size above the warning threshold of the entity limit",
+			Subsystem: subsystem,
+		}, []string{entityLabel}),
 	}
 }
 
 func (m *Registry) Init(reg prom.Registerer) {
 	reg.MustRegister(
 		m.MessageSentCount,
+		m.SendLatency,
+		m.MessageSize,
+		m.BatchSize,
+		m.ThrottledCount,
+		m.BatchWindowSize,
+		m.MessageSizeWarningCount,
 	)
 }
 
 type Registry struct {
 	MessageSentCount *prom.CounterVec
+	SendLatency      *prom.HistogramVec
+	MessageSize      *prom.HistogramVec
+	BatchSize        *prom.HistogramVec
+	ThrottledCount   *prom.CounterVec
+	BatchWindowSize  *prom.GaugeVec
+	// MessageSizeWarningCount counts the messages close to the maximum message size of the entity
+	MessageSizeWarningCount *prom.CounterVec
 }
 
 // Recorder allows to initialize the metric registry and increase/decrease the registered metrics at runtime.
@@ -41,6 +94,12 @@ type Recorder interface {
 	Init(registerer prom.Registerer)
 	IncSendMessageSuccessCount()
 	IncSendMessageFailureCount()
+	ObserveSendLatency(entity string, latency time.Duration, success bool)
+	ObserveMessageSize(entity string, bytes int)
+	ObserveBatchSize(entity string, messages int)
+	IncThrottledCount(entity string)
+	SetBatchWindowSize(entity string, size int)
+	IncMessageSizeWarningCount(entity string)
 }
 
 // IncSendMessageSuccessCount increases the MessageSentCount metric with success == true
@@ -59,6 +118,39 @@ func (m *Registry) IncSendMessageFailureCount() {
 		}).Inc()
 }
 
+// ObserveSendLatency records the latency of a send operation
+func (m *Registry) ObserveSendLatency(entity string, latency time.Duration, success bool) {
+	m.SendLatency.With(prom.Labels{
+		entityLabel:  entityOrDefault(entity),
+		successLabel: strconv.FormatBool(success),
+	}).Observe(latency.Seconds())
+}
+
+// ObserveMessageSize records the size of a serialized message body
+func (m *Registry) ObserveMessageSize(entity string, bytes int) {
+	m.MessageSize.With(prom.Labels{entityLabel: entityOrDefault(entity)}).Observe(float64(bytes))
+}
+
+// ObserveBatchSize records the number of messages in a batch
+func (m *Registry) ObserveBatchSize(entity string, messages int) {
+	m.BatchSize.With(prom.Labels{entityLabel: entityOrDefault(entity)}).Observe(float64(messages))
+}
+
+// IncThrottledCount increases the throttled send counter
+func (m *Registry) IncThrottledCount(entity string) {
+	m.ThrottledCount.With(prom.Labels{entityLabel: entityOrDefault(entity)}).Inc()
+}
+
+// SetBatchWindowSize records the current batch size of an adaptive batch sender
+func (m *Registry) SetBatchWindowSize(entity string, size int) {
+	m.BatchWindowSize.With(prom.Labels{entityLabel: entityOrDefault(entity)}).Set(float64(size))
+}
+
+// IncMessageSizeWarningCount increases the counter of messages above the size warning threshold
+func (m *Registry) IncMessageSizeWarningCount(entity string) {
+	m.MessageSizeWarningCount.With(prom.Labels{entityLabel: entityOrDefault(entity)}).Inc()
+}
+
 // Informer allows to inspect metrics value stored in the registry at runtime
 type Informer struct {
 	registry *Registry
@@ -81,6 +173,53 @@ func (i *Informer) GetSendMessageFailureCount() (float64, error) {
 	return total, nil
 }
 
+// GetThrottledCount returns the total number of send operations throttled, across entities
+func (i *Informer) GetThrottledCount() (float64, error) {
+	var total float64
+	collect(i.registry.ThrottledCount, func(m *dto.Metric) {
+		total += m.GetCounter().GetValue()
+	})
+	return total, nil
+}
+
+// GetSendLatencySampleCount returns the number of send latencies observed, across entities
+func (i *Informer) GetSendLatencySampleCount() (uint64, error) {
+	var total uint64
+	collect(i.registry.SendLatency, func(m *dto.Metric) {
+		total += m.GetHistogram().GetSampleCount()
+	})
+	return total, nil
+}
+
+// GetBatchWindowSize returns the current batch size of the adaptive batch sender of the entity
+func (i *Informer) GetBatchWindowSize(entity string) (float64, error) {
+	var size float64
+	collect(i.registry.BatchWindowSize, func(m *dto.Metric) {
+		if hasLabel(m, entityLabel, entityOrDefault(entity)) {
+			size = m.GetGauge().GetValue()
+		}
+	})
+	return size, nil
+}
+
+// GetMessageSizeWarningCount returns the number of messages above the size warning threshold of the entity
+func (i *Informer) GetMessageSizeWarningCount(entity string) (float64, error) {
+	var total float64
+	collect(i.registry.MessageSizeWarningCount, func(m *dto.Metric) {
+		if hasLabel(m, entityLabel, entityOrDefault(entity)) {
+			total += m.GetCounter().GetValue()
+		}
+	})
+	return total, nil
+}
+
+func entityOrDefault(entity string) string {
+	if entity == "" {
+		return defaultEntity
+	}
+	return entity
+}
+
 func hasLabel(m *dto.Metric, key string, value string) bool {
 	for _, pair := range m.Label {
 		if pair == nil {
@@ -2,6 +2,7 @@ package sender
 
 import (
 	"testing"
+	"time"
 
 	. "github.com/onsi/gomega"
 	"github.com/prometheus/client_golang/prometheus"
@@ -29,7 +30,7 @@ func TestRegistry_Init(t *testing.T) {
 	fRegistry := &fakeRegistry{}
 	g.Expect(func() { r.Init(prometheus.NewRegistry()) }).ToNot(Panic())
 	g.Expect(func() { r.Init(fRegistry) }).ToNot(Panic())
-	g.Expect(fRegistry.collectors).To(HaveLen(1))
+	g.Expect(fRegistry.collectors).To(HaveLen(7))
 	Metric.IncSendMessageSuccessCount()
 }
 
@@ -62,3 +63,42 @@ func TestMetrics(t *testing.T) {
 	g.Expect(err).ToNot(HaveOccurred())
 	g.Expect(count).To(Equal(float64(1)))
 }
+
+func TestSendMetrics(t *testing.T) {
+	g := NewWithT(t)
+	r := newRegistry()
+	informer := &Informer{registry: r}
+
+	r.ObserveSendLatency("topic", 10*time.Millisecond, true)
+	r.ObserveSendLatency("topic", 20*time.Millisecond, false)
+	count, err := informer.GetSendLatencySampleCount()
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(count).To(Equal(uint64(2)))
+
+	r.IncThrottledCount("topic")
+	r.IncThrottledCount("other")
+	throttled, err := informer.GetThrottledCount()
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(throttled).To(Equal(float64(2)))
+
+	r.SetBatchWindowSize("topic", 20)
+	r.SetBatchWindowSize("topic", 10)
+	window, err := informer.GetBatchWindowSize("topic")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(window).To(Equal(float64(10)))
+
+	r.IncMessageSizeWarningCount("topic")
+	warnings, err := informer.GetMessageSizeWarningCount("topic")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(warnings).To(Equal(float64(1)))
+
+	r.SetBatchWindowSize("", 5)
+	window, err = informer.GetBatchWindowSize(defaultEntity)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(window).To(Equal(float64(5)))
+
+	g.Expect(func() {
+		r.ObserveMessageSize("topic", 1024)
+		r.ObserveBatchSize("topic", 10)
+	}).ToNot(Panic())
+}
@@ -0,0 +1,285 @@
+package shuttle
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	prom "github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	migrationSubsystem = "goshuttle_migration"
+	outcomeLabel       = "outcome"
+	resultLabel        = "result"
+)
+
+// Outcomes of the dual writes of the MigrationSender.
+const (
+	DualWriteBoth          = "both"
+	DualWritePrimaryOnly   = "primary_only"
+	DualWriteSecondaryOnly = "secondary_only"
+	DualWriteFailed        = "failed"
+)
+
+// Results of the comparison of the messages received by the primary and the shadow processors.
+const (
+	ShadowMatched        = "matched"
+	ShadowDiverged       = "diverged"
+	ShadowMissingPrimary = "missing_primary"
+	ShadowMissingShadow  = "missing_shadow"
+)
+
+// MigrationMetrics are the Prometheus metrics comparing the old and the new namespaces during a migration.
+type MigrationMetrics struct {
+	// DualWrites counts the messages sent by the MigrationSender, by outcome.
+	DualWrites *prom.CounterVec
+	// ShadowComparisons counts the messages compared by the ShadowComparator, by result.
+	ShadowComparisons *prom.CounterVec
+}
+
+// NewMigrationMetrics creates the metrics of the migration. They must be registered with Init.
+func NewMigrationMetrics() *MigrationMetrics {
+	return &MigrationMetrics{
+		DualWrites: prom.NewCounterVec(prom.CounterOpts{
+			Name:      "dual_write_total",
+			Help:      "total number of messages written to the old and new namespaces, by outcome",
+			Subsystem: migrationSubsystem,
+		}, []string{outcomeLabel}),
+		ShadowComparisons: prom.NewCounterVec(prom.CounterOpts{
+			Name:      "shadow_comparison_total",
+			Help:      "total number of messages compared between the primary and shadow processors, by result",
+			Subsystem: migrationSubsystem,
+		}, []string{resultLabel}),
+	}
+}
+
+// Init registers the metrics.
+func (m *MigrationMetrics) Init(reg prom.Registerer) {
+	reg.MustRegister(m.DualWrites, m.ShadowComparisons)
+}
+
+func (m *MigrationMetrics) incDualWrite(outcome string) {
+	if m != nil {
+		m.DualWrites.With(prom.Labels{outcomeLabel: outcome}).Inc()
+	}
+}
+
+func (m *MigrationMetrics) incShadowComparison(result string, count int) {
+	if m != nil && count > 0 {
+		m.ShadowComparisons.With(prom.Labels{resultLabel: result}).Add(float64(count))
+	}
+}
+
+// MigrationSenderOptions configures the MigrationSender.
+type MigrationSenderOptions struct {
+	// Metrics records the outcome of the dual writes. Optional.
+	Metrics *MigrationMetrics
+	// OnSecondaryError is called when the send to the secondary namespace fails. The error is not returned to the caller.
+	OnSecondaryError func(ctx context.Context, err error)
+}
+
+// MigrationSender writes each message to the primary namespace, the one the application relies on,
+// and to the secondary namespace being migrated to, to derisk a migration between namespaces.
+// The failures of the secondary are recorded without failing the send.
+// Both copies have the same MessageID, generated when not set, so that a ShadowComparator can match them.
+type MigrationSender struct {
+	primary   *Sender
+	secondary *Sender
+	options   MigrationSenderOptions
+}
+
+// NewMigrationSender creates a MigrationSender. Swap the senders to cut over to the new namespace while
+// still writing to the old one for the consumers that are not migrated yet.
+func NewMigrationSender(primary, secondary *Sender, options *MigrationSenderOptions) *MigrationSender {
+	opts := MigrationSenderOptions{}
+	if options != nil {
+		opts = *options
+	}
+	return &MigrationSender{primary: primary, secondary: secondary, options: opts}
+}
+
+// SendMessage marshals the message body with the primary sender and sends it to both namespaces.
+// It returns the error of the primary namespace.
+func (s *MigrationSender) SendMessage(ctx context.Context, mb MessageBody, options ...func(msg *azservicebus.Message) error) error {
+	msg, err := s.primary.ToServiceBusMessage(ctx, mb, options...)
+	if err != nil {
+		return err
+	}
+	return s.SendAzMessage(ctx, msg)
+}
+
+// SendAzMessage sends the pre-built message to both namespaces. It returns the error of the primary namespace.
+func (s *MigrationSender) SendAzMessage(ctx context.Context, msg *azservicebus.Message, options ...func(msg *azservicebus.Message) error) error {
+	for _, option := range options {
+		if err := option(msg); err != nil {
+			return fmt.Errorf("failed to run message options: %w", err)
+		}
+	}
+	if msg.MessageID == nil {
+		id := UUIDv7MessageIDGenerator(nil, msg)
+		msg.MessageID = &id
+	}
+	secondaryMsg, err := CloneForResend(&azservicebus.ReceivedMessage{
+		MessageID:             *msg.MessageID,
+		Body:                  msg.Body,
+		ContentType:           msg.ContentType,
+		CorrelationID:         msg.CorrelationID,
+		SessionID:             msg.SessionID,
+		PartitionKey:          msg.PartitionKey,
+		Subject:               msg.Subject,
+		ReplyTo:               msg.ReplyTo,
+		ReplyToSessionID:      msg.ReplyToSessionID,
+		To:                    msg.To,
+		TimeToLive:            msg.TimeToLive,
+		ApplicationProperties: msg.ApplicationProperties,
+	}, SetMessageId(msg.MessageID))
+	if err != nil {
+		return err
+	}
+	secondaryMsg.ScheduledEnqueueTime = msg.ScheduledEnqueueTime
+
+	primaryErr := s.primary.SendAzMessage(ctx, msg)
+	secondaryErr := s.secondary.SendAzMessage(ctx, secondaryMsg)
+	if secondaryErr != nil && s.options.OnSecondaryError != nil {
+		s.options.OnSecondaryError(ctx, fmt.Errorf("failed to send message %s to the secondary namespace: %w", *msg.MessageID, secondaryErr))
+	}
+	switch {
+	case primaryErr == nil && secondaryErr == nil:
+		s.options.Metrics.incDualWrite(DualWriteBoth)
+	case primaryErr == nil:
+		s.options.Metrics.incDualWrite(DualWritePrimaryOnly)
+	case secondaryErr == nil:
+		s.options.Metrics.incDualWrite(DualWriteSecondaryOnly)
+	default:
+		s.options.Metrics.incDualWrite(DualWriteFailed)
+	}
+	return primaryErr
+}
+
+// ShadowComparison reports the outcome of ShadowComparator.Sweep.
+type ShadowComparison struct {
+	// MissingPrimary are the MessageIDs received by the shadow processor only.
+	MissingPrimary []string
+	// MissingShadow are the MessageIDs received by the primary processor only.
+	MissingShadow []string
+}
+
+type shadowEntry struct {
+	hash       [sha256.Size]byte
+	receivedAt time.Time
+}
+
+// ShadowComparator compares the messages received from the old namespace by the primary processor with the ones
+// received from the new namespace by the shadow processor, matching them by MessageID.
+// The primary processor records its messages with NewShadowRecordingHandler, the shadow processor with NewShadowHandler.
+type ShadowComparator struct {
+	metrics *MigrationMetrics
+	now     func() time.Time
+
+	mu      sync.Mutex
+	primary map[string]shadowEntry
+	shadow  map[string]shadowEntry
+}
+
+// NewShadowComparator creates a ShadowComparator recording the comparisons in the metrics, which are optional.
+func NewShadowComparator(metrics *MigrationMetrics) *ShadowComparator {
+	return &ShadowComparator{
+		metrics: metrics,
+		now:     time.Now,
+		primary: map[string]shadowEntry{},
+		shadow:  map[string]shadowEntry{},
+	}
+}
+
+// RecordPrimary records a message received by the primary processor.
+func (c *ShadowComparator) RecordPrimary(message *azservicebus.ReceivedMessage) {
+	c.record(message, c.primary, c.shadow)
+}
+
+// RecordShadow records a message received by the shadow processor.
+func (c *ShadowComparator) RecordShadow(message *azservicebus.ReceivedMessage) {
+	c.record(message, c.shadow, c.primary)
+}
+
+func (c *ShadowComparator) record(message *azservicebus.ReceivedMessage, own, other map[string]shadowEntry) {
+	entry := shadowEntry{hash: shadowHash(message), receivedAt: c.now()}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	counterpart, ok := other[message.MessageID]
+	if !ok {
+		// redeliveries overwrite the previous entry
+		own[message.MessageID] = entry
+		return
+	}
+	delete(other, message.MessageID)
+	if bytes.Equal(counterpart.hash[:], entry.hash[:]) {
+		c.metrics.incShadowComparison(ShadowMatched, 1)
+		return
+	}
+	c.metrics.incShadowComparison(ShadowDiverged, 1)
+}
+
+// Sweep reports the messages received by only one of the processors for longer than the grace period,
+// and stops tracking them. Call it periodically to bound the memory used by the comparator.
+func (c *ShadowComparator) Sweep(gracePeriod time.Duration) ShadowComparison {
+	cutoff := c.now().Add(-gracePeriod)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	result := ShadowComparison{
+		MissingShadow:  sweepShadowEntries(c.primary, cutoff),
+		MissingPrimary: sweepShadowEntries(c.shadow, cutoff),
+	}
+	c.metrics.incShadowComparison(ShadowMissingShadow, len(result.MissingShadow))
+	c.metrics.incShadowComparison(ShadowMissingPrimary, len(result.MissingPrimary))
+	return result
+}
+
+func sweepShadowEntries(entries map[string]shadowEntry, cutoff time.Time) []string {
+	var expired []string
+	for id, entry := range entries {
+		if entry.receivedAt.Before(cutoff) {
+			expired = append(expired, id)
+			delete(entries, id)
+		}
+	}
+	return expired
+}
+
+// shadowHash hashes the body and the application properties set by the application,
+// which must be the same in both namespaces.
+func shadowHash(message *azservicebus.ReceivedMessage) [sha256.Size]byte {
+	h := sha256.New()
+	h.Write(message.Body)
+	if msgType, ok := message.ApplicationProperties[msgTypeField].(string); ok {
+		h.Write([]byte(msgType))
+	}
+	var sum [sha256.Size]byte
+	copy(sum[:], h.Sum(nil))
+	return sum
+}
+
+// NewShadowRecordingHandler is a middleware for the primary processor that records the received messages
+// in the comparator before calling next.
+func NewShadowRecordingHandler(comparator *ShadowComparator, next Handler) HandlerFunc {
+	return func(ctx context.Context, settler MessageSettler, message *azservicebus.ReceivedMessage) {
+		comparator.RecordPrimary(message)
+		next.Handle(ctx, settler, message)
+	}
+}
+
+// NewShadowHandler is the handler of the shadow processor consuming from the new namespace.
+// It records the messages in the comparator and completes them, without handling them,
+// so that the new namespace is exercised without side effects.
+func NewShadowHandler(comparator *ShadowComparator) HandlerFunc {
+	return func(ctx context.Context, settler MessageSettler, message *azservicebus.ReceivedMessage) {
+		comparator.RecordShadow(message)
+		if err := settler.CompleteMessage(ctx, message, nil); err != nil {
+			log(ctx, fmt.Sprintf("failed to complete shadow message %s: %s", message.MessageID, err))
+		}
+	}
+}
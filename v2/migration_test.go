@@ -0,0 +1,110 @@
+package shuttle
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	. "github.com/onsi/gomega"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func counterValue(g *WithT, counter *dto.Metric) float64 {
+	g.Expect(counter.Counter).ToNot(BeNil())
+	return counter.Counter.GetValue()
+}
+
+func dualWrites(g *WithT, metrics *MigrationMetrics, outcome string) float64 {
+	m := &dto.Metric{}
+	g.Expect(metrics.DualWrites.WithLabelValues(outcome).Write(m)).To(Succeed())
+	return counterValue(g, m)
+}
+
+func shadowComparisons(g *WithT, metrics *MigrationMetrics, result string) float64 {
+	m := &dto.Metric{}
+	g.Expect(metrics.ShadowComparisons.WithLabelValues(result).Write(m)).To(Succeed())
+	return counterValue(g, m)
+}
+
+func TestMigrationSender_DualWrite(t *testing.T) {
+	g := NewWithT(t)
+	primaryAz := &fakeAzSender{}
+	secondaryAz := &fakeAzSender{}
+	metrics := NewMigrationMetrics()
+	sender := NewMigrationSender(NewSender(primaryAz, nil), NewSender(secondaryAz, nil), &MigrationSenderOptions{Metrics: metrics})
+
+	g.Expect(sender.SendMessage(context.Background(), "hello", SetCorrelationId(to.Ptr("c1")))).To(Succeed())
+	primaryMsg := primaryAz.SendMessageReceivedValue
+	secondaryMsg := secondaryAz.SendMessageReceivedValue
+	g.Expect(primaryMsg.MessageID).ToNot(BeNil())
+	g.Expect(*secondaryMsg.MessageID).To(Equal(*primaryMsg.MessageID))
+	g.Expect(secondaryMsg.Body).To(Equal(primaryMsg.Body))
+	g.Expect(*secondaryMsg.CorrelationID).To(Equal("c1"))
+	g.Expect(secondaryMsg.ApplicationProperties).To(HaveKeyWithValue(msgTypeField, "string"))
+	g.Expect(dualWrites(g, metrics, DualWriteBoth)).To(Equal(1.0))
+}
+
+func TestMigrationSender_SecondaryFailure(t *testing.T) {
+	g := NewWithT(t)
+	secondaryAz := &fakeAzSender{SendMessageErr: errors.New("namespace not found")}
+	metrics := NewMigrationMetrics()
+	var secondaryErr error
+	sender := NewMigrationSender(NewSender(&fakeAzSender{}, nil), NewSender(secondaryAz, nil), &MigrationSenderOptions{
+		Metrics:          metrics,
+		OnSecondaryError: func(_ context.Context, err error) { secondaryErr = err },
+	})
+
+	g.Expect(sender.SendMessage(context.Background(), "hello")).To(Succeed())
+	g.Expect(secondaryErr).To(MatchError(ContainSubstring("namespace not found")))
+	g.Expect(dualWrites(g, metrics, DualWritePrimaryOnly)).To(Equal(1.0))
+}
+
+func TestMigrationSender_PrimaryFailure(t *testing.T) {
+	g := NewWithT(t)
+	metrics := NewMigrationMetrics()
+	sender := NewMigrationSender(NewSender(&fakeAzSender{SendMessageErr: errors.New("unauthorized")}, nil),
+		NewSender(&fakeAzSender{}, nil), &MigrationSenderOptions{Metrics: metrics})
+
+	g.Expect(sender.SendMessage(context.Background(), "hello")).To(MatchError(ContainSubstring("unauthorized")))
+	g.Expect(dualWrites(g, metrics, DualWriteSecondaryOnly)).To(Equal(1.0))
+}
+
+func TestShadowComparator(t *testing.T) {
+	g := NewWithT(t)
+	metrics := NewMigrationMetrics()
+	comparator := NewShadowComparator(metrics)
+	now := time.Now()
+	comparator.now = func() time.Time { return now }
+
+	primaryHandler := NewShadowRecordingHandler(comparator, HandlerFunc(func(ctx context.Context, settler MessageSettler, message *azservicebus.ReceivedMessage) {
+		_ = settler.CompleteMessage(ctx, message, nil)
+	}))
+	shadowHandler := NewShadowHandler(comparator)
+	handle := func(h HandlerFunc, id string, body string) *fakeSettler {
+		settler := &fakeSettler{}
+		h.Handle(context.Background(), settler, &azservicebus.ReceivedMessage{MessageID: id, Body: []byte(body)})
+		return settler
+	}
+
+	g.Expect(handle(primaryHandler, "matched", "a").completed).To(BeTrue())
+	g.Expect(handle(shadowHandler, "matched", "a").completed).To(BeTrue())
+	handle(shadowHandler, "diverged", "a")
+	handle(primaryHandler, "diverged", "b")
+	handle(primaryHandler, "primary-only", "a")
+	handle(shadowHandler, "shadow-only", "a")
+	g.Expect(shadowComparisons(g, metrics, ShadowMatched)).To(Equal(1.0))
+	g.Expect(shadowComparisons(g, metrics, ShadowDiverged)).To(Equal(1.0))
+
+	// the unmatched messages are reported after the grace period only
+	g.Expect(comparator.Sweep(time.Minute)).To(Equal(ShadowComparison{}))
+	now = now.Add(2 * time.Minute)
+	result := comparator.Sweep(time.Minute)
+	g.Expect(result.MissingShadow).To(ConsistOf("primary-only"))
+	g.Expect(result.MissingPrimary).To(ConsistOf("shadow-only"))
+	g.Expect(shadowComparisons(g, metrics, ShadowMissingShadow)).To(Equal(1.0))
+	g.Expect(shadowComparisons(g, metrics, ShadowMissingPrimary)).To(Equal(1.0))
+	g.Expect(comparator.Sweep(0)).To(Equal(ShadowComparison{}))
+}
@@ -0,0 +1,54 @@
+package shuttle
+
+import (
+	"context"
+	"strings"
+)
+
+// MessageOrigin identifies the namespace and the entity a message was received from.
+// The Processor sets it in the context of every handler, so that the handlers and middlewares shared
+// between processors can branch or label their metrics by source.
+type MessageOrigin struct {
+	// Namespace is the Namespace of the processor, empty when not set.
+	Namespace string
+	// Entity is the EntityName of the processor: the queue name, or topic/subscription. Empty when not set.
+	Entity string
+}
+
+// Queue returns the queue name, empty when the entity is a subscription.
+func (o MessageOrigin) Queue() string {
+	if strings.Contains(o.Entity, "/") {
+		return ""
+	}
+	return o.Entity
+}
+
+// Topic returns the topic name, empty when the entity is a queue.
+func (o MessageOrigin) Topic() string {
+	topic, _, _ := strings.Cut(o.Entity, "/")
+	if topic == o.Entity {
+		return ""
+	}
+	return topic
+}
+
+// Subscription returns the subscription name, empty when the entity is a queue.
+func (o MessageOrigin) Subscription() string {
+	_, subscription, _ := strings.Cut(o.Entity, "/")
+	return subscription
+}
+
+type messageOriginKey struct{}
+
+// MessageOriginFromContext returns the origin of the message, as set in the context by the Processor.
+// It returns false outside of a handler called by a Processor.
+func MessageOriginFromContext(ctx context.Context) (MessageOrigin, bool) {
+	origin, ok := ctx.Value(messageOriginKey{}).(MessageOrigin)
+	return origin, ok
+}
+
+// ContextWithMessageOrigin sets the origin of the message in the context, to call the handlers outside of a Processor,
+// for example in tests.
+func ContextWithMessageOrigin(ctx context.Context, origin MessageOrigin) context.Context {
+	return context.WithValue(ctx, messageOriginKey{}, origin)
+}
@@ -0,0 +1,45 @@
+package shuttle
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	. "github.com/onsi/gomega"
+)
+
+func TestMessageOrigin(t *testing.T) {
+	g := NewWithT(t)
+	queue := MessageOrigin{Namespace: "ns", Entity: "orders"}
+	g.Expect(queue.Queue()).To(Equal("orders"))
+	g.Expect(queue.Topic()).To(BeEmpty())
+	g.Expect(queue.Subscription()).To(BeEmpty())
+
+	subscription := MessageOrigin{Entity: "events/billing"}
+	g.Expect(subscription.Queue()).To(BeEmpty())
+	g.Expect(subscription.Topic()).To(Equal("events"))
+	g.Expect(subscription.Subscription()).To(Equal("billing"))
+
+	_, ok := MessageOriginFromContext(context.Background())
+	g.Expect(ok).To(BeFalse())
+	origin, ok := MessageOriginFromContext(ContextWithMessageOrigin(context.Background(), subscription))
+	g.Expect(ok).To(BeTrue())
+	g.Expect(origin).To(Equal(subscription))
+}
+
+func TestProcessor_MessageOrigin(t *testing.T) {
+	g := NewWithT(t)
+	source := &sliceSource{messages: []*azservicebus.ReceivedMessage{{}}}
+	origins := make(chan MessageOrigin, 1)
+	p := NewProcessorWithOptions(NewSourceReceiver(source, &fakeSettler{}),
+		func(ctx context.Context, settler MessageSettler, message *azservicebus.ReceivedMessage) {
+			origin, _ := MessageOriginFromContext(ctx)
+			origins <- origin
+		},
+		WithEntityName("events/billing"), WithNamespace("contoso.servicebus.windows.net"), WithReceiveInterval(time.Millisecond))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = p.Start(ctx) }()
+	g.Eventually(origins).Should(Receive(Equal(MessageOrigin{Namespace: "contoso.servicebus.windows.net", Entity: "events/billing"})))
+}
@@ -2,6 +2,7 @@ package otel
 
 import (
 	"context"
+	"time"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
 	"go.opentelemetry.io/otel/attribute"
@@ -33,6 +34,10 @@ func MessageAttributes(message *azservicebus.ReceivedMessage) []attribute.KeyVal
 		if message.TimeToLive != nil {
 			attrs = append(attrs, attribute.String("message.ttl", message.TimeToLive.String()))
 		}
+
+		if delay, ok := SchedulingDelay(message); ok {
+			attrs = append(attrs, attribute.Float64("message.schedulingDelaySeconds", delay.Seconds()))
+		}
 	}
 	return attrs
 }
@@ -117,3 +122,77 @@ func (mw *messageWrapper) Keys() []string {
 	}
 	return keys
 }
+
+// Application properties recording the scheduling of a message, see InjectScheduling.
+const (
+	// ScheduledAtProperty is the time at which the message was scheduled, in RFC 3339 format.
+	ScheduledAtProperty = "goshuttle-scheduled-at"
+	// SchedulingTraceParentProperty is the trace context of the scheduling operation, in the W3C traceparent format.
+	SchedulingTraceParentProperty = "goshuttle-scheduling-traceparent"
+	// SchedulingTraceStateProperty is the W3C tracestate of the scheduling operation.
+	SchedulingTraceStateProperty = "goshuttle-scheduling-tracestate"
+)
+
+// InjectScheduling records the time the message is scheduled at, and the trace context of the scheduling operation,
+// for the receiver to link its span to the scheduling one and measure the deliberate delay of the message,
+// see SchedulingLink and MessageAttributes.
+func InjectScheduling(ctx context.Context, msg *azservicebus.Message, scheduledAt time.Time) {
+	carrier := MessageCarrierAdapter(msg)
+	carrier.Set(ScheduledAtProperty, scheduledAt.UTC().Format(time.RFC3339Nano))
+	propagation.TraceContext{}.Inject(ctx, &schedulingCarrier{carrier})
+}
+
+// SchedulingLink returns the link to the span that scheduled the message.
+// It returns false when the message was not scheduled with InjectScheduling, or without a span.
+func SchedulingLink(message *azservicebus.ReceivedMessage) (trace.Link, bool) {
+	ctx := propagation.TraceContext{}.Extract(context.Background(), &schedulingCarrier{ReceivedMessageCarrierAdapter(message)})
+	spanContext := trace.SpanContextFromContext(ctx)
+	if !spanContext.IsValid() {
+		return trace.Link{}, false
+	}
+	return trace.Link{
+		SpanContext: spanContext,
+		Attributes:  []attribute.KeyValue{attribute.String("link.type", "scheduled")},
+	}, true
+}
+
+// SchedulingDelay returns the delay the message was scheduled with: the time between its scheduling
+// and its scheduled enqueue time. It returns false when the message was not scheduled with InjectScheduling.
+func SchedulingDelay(message *azservicebus.ReceivedMessage) (time.Duration, bool) {
+	if message.ScheduledEnqueueTime == nil {
+		return 0, false
+	}
+	value, ok := message.ApplicationProperties[ScheduledAtProperty].(string)
+	if !ok {
+		return 0, false
+	}
+	scheduledAt, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return 0, false
+	}
+	return message.ScheduledEnqueueTime.Sub(scheduledAt), true
+}
+
+// schedulingCarrier maps the W3C trace context keys to the scheduling properties.
+type schedulingCarrier struct {
+	propagation.TextMapCarrier
+}
+
+func (c *schedulingCarrier) key(key string) string {
+	switch key {
+	case "traceparent":
+		return SchedulingTraceParentProperty
+	case "tracestate":
+		return SchedulingTraceStateProperty
+	default:
+		return key
+	}
+}
+
+func (c *schedulingCarrier) Get(key string) string {
+	return c.TextMapCarrier.Get(c.key(key))
+}
+
+func (c *schedulingCarrier) Set(key string, value string) {
+	c.TextMapCarrier.Set(c.key(key), value)
+}
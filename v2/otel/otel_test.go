@@ -92,3 +92,35 @@ func initUTTracerProvider() {
 	tp := tracesdk.NewTracerProvider(tracesdk.WithSampler(tracesdk.AlwaysSample()))
 	otel.SetTracerProvider(tp)
 }
+
+func Test_SchedulingLink(t *testing.T) {
+	g := NewWithT(t)
+	schedulingSpanContext := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{1, 2, 3},
+		SpanID:     trace.SpanID{4, 5, 6},
+		TraceFlags: trace.FlagsSampled,
+	})
+	scheduledAt := time.Date(2024, 1, 31, 10, 0, 0, 0, time.UTC)
+	msg := &azservicebus.Message{}
+	InjectScheduling(trace.ContextWithSpanContext(context.Background(), schedulingSpanContext), msg, scheduledAt)
+	g.Expect(msg.ApplicationProperties).ToNot(HaveKey("traceparent"), "the trace context of the send is kept apart")
+
+	received := &azservicebus.ReceivedMessage{
+		ApplicationProperties: msg.ApplicationProperties,
+		ScheduledEnqueueTime:  to.Ptr(scheduledAt.Add(90 * time.Second)),
+	}
+	link, ok := SchedulingLink(received)
+	g.Expect(ok).To(BeTrue())
+	g.Expect(link.SpanContext.TraceID()).To(Equal(schedulingSpanContext.TraceID()))
+	g.Expect(link.SpanContext.SpanID()).To(Equal(schedulingSpanContext.SpanID()))
+	g.Expect(link.SpanContext.IsRemote()).To(BeTrue())
+	delay, ok := SchedulingDelay(received)
+	g.Expect(ok).To(BeTrue())
+	g.Expect(delay).To(Equal(90 * time.Second))
+	g.Expect(MessageAttributes(received)).To(ContainElement(attribute.Float64("message.schedulingDelaySeconds", 90)))
+
+	_, ok = SchedulingLink(&azservicebus.ReceivedMessage{})
+	g.Expect(ok).To(BeFalse())
+	_, ok = SchedulingDelay(&azservicebus.ReceivedMessage{ScheduledEnqueueTime: to.Ptr(scheduledAt)})
+	g.Expect(ok).To(BeFalse())
+}
@@ -0,0 +1,272 @@
+// Package outbox relays the messages written to a transactional outbox table to Service Bus.
+//
+// The application writes the messages to its database in the same transaction as its state changes,
+// and the Relay publishes them with a shuttle.Sender, in-process or as a separate daemon:
+//
+//	relay := outbox.NewRelay(store, sender, &outbox.RelayOptions{Metrics: outbox.NewMetrics()})
+//	err := relay.Run(ctx)
+//
+// The Store is implemented on top of the database holding the outbox table.
+package outbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	prom "github.com/prometheus/client_golang/prometheus"
+
+	"github.com/Azure/go-shuttle/v2"
+)
+
+const (
+	defaultBatchSize      = 100
+	defaultPollInterval   = time.Second
+	defaultMaxConcurrency = 8
+	subsystem             = "goshuttle_outbox"
+)
+
+// Record is a message of the outbox.
+type Record struct {
+	// ID identifies the record. It is used as the MessageID, so that duplicate detection drops
+	// the messages sent twice when the relay fails to mark them as sent.
+	ID string
+	// AggregateID groups the records that must be sent in order, for example the events of an entity.
+	AggregateID string
+	// Body is the marshalled message body.
+	Body []byte
+	// ContentType of the body. Defaults to the ContentType of the sender's Marshaller.
+	ContentType string
+	// MessageType is set as the message type property.
+	MessageType string
+	// CreatedAt is the time at which the record was written, used to measure the outbox lag.
+	CreatedAt time.Time
+}
+
+// Store reads the pending records of the outbox and marks them as sent.
+type Store interface {
+	// Pending returns up to limit records that are not sent yet, in the order they were written.
+	Pending(ctx context.Context, limit int) ([]Record, error)
+	// MarkSent marks the records as sent, for example by deleting them.
+	MarkSent(ctx context.Context, ids ...string) error
+}
+
+// Metrics are the Prometheus metrics of the Relay.
+type Metrics struct {
+	// Lag is the age of the oldest pending record, as of the last poll.
+	Lag prom.Gauge
+	// Relayed is the number of records sent and marked as sent.
+	Relayed prom.Counter
+	// Failures is the number of records that failed to be sent or marked as sent, and are retried.
+	Failures prom.Counter
+}
+
+// NewMetrics creates the metrics of the Relay. They must be registered with Init.
+func NewMetrics() *Metrics {
+	return &Metrics{
+		Lag: prom.NewGauge(prom.GaugeOpts{
+			Name:      "lag_seconds",
+			Help:      "age of the oldest pending record of the outbox",
+			Subsystem: subsystem,
+		}),
+		Relayed: prom.NewCounter(prom.CounterOpts{
+			Name:      "relayed_total",
+			Help:      "total number of outbox records sent to Service Bus",
+			Subsystem: subsystem,
+		}),
+		Failures: prom.NewCounter(prom.CounterOpts{
+			Name:      "relay_failures_total",
+			Help:      "total number of outbox records that failed to be relayed and are retried",
+			Subsystem: subsystem,
+		}),
+	}
+}
+
+// Init registers the metrics.
+func (m *Metrics) Init(reg prom.Registerer) {
+	reg.MustRegister(m.Lag, m.Relayed, m.Failures)
+}
+
+// RelayOptions configures the Relay.
+type RelayOptions struct {
+	// BatchSize is the number of pending records read per poll. Defaults to 100.
+	BatchSize int
+	// PollInterval is the time to wait before polling again when the outbox is drained. Defaults to 1 second.
+	PollInterval time.Duration
+	// ErrorBackoff computes the time to wait before polling again after consecutive failed polls.
+	// Defaults to the PollInterval.
+	ErrorBackoff shuttle.Backoff
+	// MaxConcurrency is the number of aggregates relayed concurrently. Defaults to 8.
+	MaxConcurrency int
+	// SessionPerAggregate sets the AggregateID as the SessionID of the messages, so that sessionful receivers
+	// also handle the messages of an aggregate in order.
+	SessionPerAggregate bool
+	// Metrics records the outbox lag and the relayed records. Optional.
+	Metrics *Metrics
+	// OnError is called by Run with the errors of the relay, which are retried on the next poll. Optional.
+	OnError func(ctx context.Context, err error)
+}
+
+// Relay sends the pending records of the outbox with at-least-once delivery:
+// a record is marked as sent only after it is sent, and is sent again if marking it fails.
+// The records of an aggregate are sent one at a time, in order. When one of them fails,
+// the following records of the aggregate wait for the next poll, so that they are never sent before it.
+type Relay struct {
+	store   Store
+	sender  *shuttle.Sender
+	options RelayOptions
+	now     func() time.Time
+}
+
+// NewRelay creates a Relay sending the records of the store with the sender.
+func NewRelay(store Store, sender *shuttle.Sender, options *RelayOptions) *Relay {
+	opts := RelayOptions{}
+	if options != nil {
+		opts = *options
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = defaultBatchSize
+	}
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = defaultPollInterval
+	}
+	if opts.ErrorBackoff == nil {
+		opts.ErrorBackoff = shuttle.ConstantBackoff(opts.PollInterval)
+	}
+	if opts.MaxConcurrency <= 0 {
+		opts.MaxConcurrency = defaultMaxConcurrency
+	}
+	return &Relay{store: store, sender: sender, options: opts, now: time.Now}
+}
+
+// Run relays the records until ctx is done. It polls again immediately while the batches are full,
+// waits for the PollInterval once the outbox is drained, and for the ErrorBackoff when a poll or a record fails,
+// so that a failing batch is not sent again in a tight loop.
+func (r *Relay) Run(ctx context.Context) error {
+	failures := 0
+	for {
+		full, err := r.RelayOnce(ctx)
+		if err != nil && ctx.Err() == nil {
+			r.onError(ctx, err)
+		}
+		wait := r.options.PollInterval
+		switch {
+		case err != nil:
+			failures++
+			wait = r.options.ErrorBackoff.Delay(failures)
+		case full:
+			failures = 0
+			wait = 0
+		default:
+			failures = 0
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// RelayOnce relays a batch of pending records. It reports whether the batch was full,
+// meaning that more records are likely pending.
+// The records that fail to be sent or marked as sent are retried on the next call. Their errors are joined
+// and returned once the other aggregates of the batch are relayed.
+func (r *Relay) RelayOnce(ctx context.Context) (bool, error) {
+	records, err := r.store.Pending(ctx, r.options.BatchSize)
+	if err != nil {
+		return false, fmt.Errorf("failed to read the pending outbox records: %w", err)
+	}
+	r.recordLag(records)
+	if len(records) == 0 {
+		return false, nil
+	}
+
+	var order []string
+	aggregates := map[string][]Record{}
+	for _, record := range records {
+		if _, ok := aggregates[record.AggregateID]; !ok {
+			order = append(order, record.AggregateID)
+		}
+		aggregates[record.AggregateID] = append(aggregates[record.AggregateID], record)
+	}
+	tokens := make(chan struct{}, r.options.MaxConcurrency)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var errs []error
+	for _, aggregateID := range order {
+		tokens <- struct{}{}
+		wg.Add(1)
+		go func(records []Record) {
+			defer func() {
+				<-tokens
+				wg.Done()
+			}()
+			if err := r.relayAggregate(ctx, records); err != nil {
+				mu.Lock()
+				errs = append(errs, err)
+				mu.Unlock()
+			}
+		}(aggregates[aggregateID])
+	}
+	wg.Wait()
+	if len(errs) > 0 {
+		return false, errors.Join(errs...)
+	}
+	return len(records) == r.options.BatchSize, nil
+}
+
+// relayAggregate sends the records of an aggregate in order, and stops at the first failure.
+func (r *Relay) relayAggregate(ctx context.Context, records []Record) error {
+	for i, record := range records {
+		if err := r.relay(ctx, record); err != nil {
+			if r.options.Metrics != nil {
+				r.options.Metrics.Failures.Add(float64(len(records) - i))
+			}
+			return err
+		}
+		if r.options.Metrics != nil {
+			r.options.Metrics.Relayed.Inc()
+		}
+	}
+	return nil
+}
+
+func (r *Relay) relay(ctx context.Context, record Record) error {
+	body := shuttle.PreMarshalledBody{Body: record.Body, ContentType: record.ContentType, MessageType: record.MessageType}
+	options := []func(msg *azservicebus.Message) error{shuttle.SetMessageId(&record.ID)}
+	if r.options.SessionPerAggregate && record.AggregateID != "" {
+		aggregateID := record.AggregateID
+		options = append(options, func(msg *azservicebus.Message) error {
+			msg.SessionID = &aggregateID
+			return nil
+		})
+	}
+	if err := r.sender.SendMessage(ctx, body, options...); err != nil {
+		return fmt.Errorf("failed to send outbox record %s: %w", record.ID, err)
+	}
+	if err := r.store.MarkSent(ctx, record.ID); err != nil {
+		return fmt.Errorf("failed to mark outbox record %s as sent: %w", record.ID, err)
+	}
+	return nil
+}
+
+func (r *Relay) recordLag(records []Record) {
+	if r.options.Metrics == nil {
+		return
+	}
+	lag := time.Duration(0)
+	if len(records) > 0 && !records[0].CreatedAt.IsZero() {
+		lag = r.now().Sub(records[0].CreatedAt)
+	}
+	r.options.Metrics.Lag.Set(lag.Seconds())
+}
+
+func (r *Relay) onError(ctx context.Context, err error) {
+	if r.options.OnError != nil {
+		r.options.OnError(ctx, err)
+	}
+}
@@ -0,0 +1,234 @@
+package outbox_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/Azure/go-shuttle/v2"
+	"github.com/Azure/go-shuttle/v2/outbox"
+	"github.com/Azure/go-shuttle/v2/shuttletest"
+)
+
+type memoryStore struct {
+	mu      sync.Mutex
+	records []outbox.Record
+	markErr error
+}
+
+func (s *memoryStore) Pending(_ context.Context, limit int) ([]outbox.Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.records) < limit {
+		limit = len(s.records)
+	}
+	return append([]outbox.Record(nil), s.records[:limit]...), nil
+}
+
+func (s *memoryStore) MarkSent(_ context.Context, ids ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.markErr != nil {
+		return s.markErr
+	}
+	for _, id := range ids {
+		for i, record := range s.records {
+			if record.ID == id {
+				s.records = append(s.records[:i], s.records[i+1:]...)
+				break
+			}
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.records)
+}
+
+// failingSender fails the sends of the message ids until they are removed from failures.
+type failingSender struct {
+	*shuttletest.InMemorySender
+	mu       sync.Mutex
+	failures map[string]bool
+}
+
+func (s *failingSender) SendMessage(ctx context.Context, message *azservicebus.Message, options *azservicebus.SendMessageOptions) error {
+	s.mu.Lock()
+	fail := s.failures[*message.MessageID]
+	s.mu.Unlock()
+	if fail {
+		return errors.New("send failed")
+	}
+	return s.InMemorySender.SendMessage(ctx, message, options)
+}
+
+func (s *failingSender) recover(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.failures, id)
+}
+
+func sentIDs(sender *shuttletest.InMemorySender) []string {
+	var ids []string
+	for _, msg := range sender.SentMessages() {
+		ids = append(ids, *msg.MessageID)
+	}
+	return ids
+}
+
+func TestRelay_RelaysInOrderPerAggregate(t *testing.T) {
+	g := NewWithT(t)
+	store := &memoryStore{records: []outbox.Record{
+		{ID: "a1", AggregateID: "a", Body: []byte(`{}`), MessageType: "Created"},
+		{ID: "b1", AggregateID: "b", Body: []byte(`{}`), MessageType: "Created"},
+		{ID: "a2", AggregateID: "a", Body: []byte(`{}`), MessageType: "Updated"},
+		{ID: "b2", AggregateID: "b", Body: []byte(`{}`), MessageType: "Updated"},
+	}}
+	azSender := &failingSender{InMemorySender: shuttletest.NewInMemorySender(nil), failures: map[string]bool{"a1": true}}
+	relay := outbox.NewRelay(store, shuttle.NewSender(azSender, nil), &outbox.RelayOptions{SessionPerAggregate: true})
+
+	full, err := relay.RelayOnce(context.Background())
+	g.Expect(err).To(MatchError(ContainSubstring("failed to send outbox record a1")))
+	g.Expect(full).To(BeFalse())
+	// a2 waits for a1 to be sent
+	g.Expect(sentIDs(azSender.InMemorySender)).To(Equal([]string{"b1", "b2"}))
+	g.Expect(store.Len()).To(Equal(2))
+
+	azSender.recover("a1")
+	_, err = relay.RelayOnce(context.Background())
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(sentIDs(azSender.InMemorySender)).To(Equal([]string{"b1", "b2", "a1", "a2"}))
+	g.Expect(store.Len()).To(Equal(0))
+
+	sent := azSender.SentMessages()[2]
+	g.Expect(*sent.SessionID).To(Equal("a"))
+	g.Expect(sent.ApplicationProperties).To(HaveKeyWithValue("type", "Created"))
+}
+
+func TestRelay_ResendsWhenMarkSentFails(t *testing.T) {
+	g := NewWithT(t)
+	store := &memoryStore{records: []outbox.Record{{ID: "a1", AggregateID: "a"}}, markErr: errors.New("db down")}
+	azSender := shuttletest.NewInMemorySender(nil)
+	metrics := outbox.NewMetrics()
+	relay := outbox.NewRelay(store, shuttle.NewSender(azSender, nil), &outbox.RelayOptions{Metrics: metrics})
+
+	_, err := relay.RelayOnce(context.Background())
+	g.Expect(err).To(MatchError(ContainSubstring("db down")))
+	g.Expect(store.Len()).To(Equal(1))
+	g.Expect(counterValue(metrics.Failures)).To(Equal(1.0))
+
+	store.markErr = nil
+	_, err = relay.RelayOnce(context.Background())
+	g.Expect(err).ToNot(HaveOccurred())
+	// at-least-once: the record is sent again, with the same MessageID for duplicate detection
+	g.Expect(sentIDs(azSender)).To(Equal([]string{"a1", "a1"}))
+	g.Expect(counterValue(metrics.Relayed)).To(Equal(1.0))
+}
+
+func TestRelay_RunRecordsLag(t *testing.T) {
+	g := NewWithT(t)
+	store := &memoryStore{records: []outbox.Record{
+		{ID: "a1", AggregateID: "a", CreatedAt: time.Now().Add(-time.Minute)},
+		{ID: "a2", AggregateID: "a", CreatedAt: time.Now()},
+	}}
+	azSender := shuttletest.NewInMemorySender(nil)
+	metrics := outbox.NewMetrics()
+	g.Expect(func() { metrics.Init(prometheus.NewRegistry()) }).ToNot(Panic())
+	relay := outbox.NewRelay(store, shuttle.NewSender(azSender, nil), &outbox.RelayOptions{
+		BatchSize:    1,
+		PollInterval: 10 * time.Millisecond,
+		Metrics:      metrics,
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = relay.Run(ctx) }()
+
+	g.Eventually(store.Len).Should(Equal(0))
+	g.Eventually(func() float64 { return gaugeValue(metrics.Lag) }).Should(Equal(0.0))
+	g.Expect(counterValue(metrics.Relayed)).To(Equal(2.0))
+}
+
+// unavailableStore fails the polls until it is available.
+type unavailableStore struct {
+	memoryStore
+	failures int
+}
+
+func (s *unavailableStore) Pending(ctx context.Context, limit int) ([]outbox.Record, error) {
+	s.mu.Lock()
+	if s.failures > 0 {
+		s.failures--
+		s.mu.Unlock()
+		return nil, errors.New("connection refused")
+	}
+	s.mu.Unlock()
+	return s.memoryStore.Pending(ctx, limit)
+}
+
+func TestRelay_RunBacksOffOnErrors(t *testing.T) {
+	g := NewWithT(t)
+	store := &unavailableStore{
+		memoryStore: memoryStore{records: []outbox.Record{{ID: "a1", AggregateID: "a", CreatedAt: time.Now()}}},
+		failures:    3,
+	}
+	attempts := make(chan int, 10)
+	relay := outbox.NewRelay(store, shuttle.NewSender(shuttletest.NewInMemorySender(nil), nil), &outbox.RelayOptions{
+		PollInterval: time.Millisecond,
+		ErrorBackoff: shuttle.BackoffFunc(func(attempt int) time.Duration {
+			attempts <- attempt
+			return time.Millisecond
+		}),
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = relay.Run(ctx) }()
+
+	g.Eventually(store.Len).Should(Equal(0))
+	g.Expect(attempts).To(Receive(Equal(1)))
+	g.Expect(attempts).To(Receive(Equal(2)))
+	g.Expect(attempts).To(Receive(Equal(3)))
+	g.Consistently(attempts, 10*time.Millisecond).ShouldNot(Receive())
+}
+
+func TestRelay_RunBacksOffOnSendErrors(t *testing.T) {
+	g := NewWithT(t)
+	store := &memoryStore{records: []outbox.Record{{ID: "a1", AggregateID: "a"}}}
+	azSender := &failingSender{InMemorySender: shuttletest.NewInMemorySender(nil), failures: map[string]bool{"a1": true}}
+	attempts := make(chan int, 10)
+	relay := outbox.NewRelay(store, shuttle.NewSender(azSender, nil), &outbox.RelayOptions{
+		// the batch is full, but failed
+		BatchSize: 1,
+		ErrorBackoff: shuttle.BackoffFunc(func(attempt int) time.Duration {
+			attempts <- attempt
+			return time.Hour
+		}),
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = relay.Run(ctx) }()
+
+	g.Eventually(attempts).Should(Receive(Equal(1)))
+	g.Consistently(attempts, 20*time.Millisecond).ShouldNot(Receive(), "the relay waits before sending the batch again")
+}
+
+func counterValue(c prometheus.Counter) float64 {
+	m := &dto.Metric{}
+	_ = c.Write(m)
+	return m.GetCounter().GetValue()
+}
+
+func gaugeValue(c prometheus.Gauge) float64 {
+	m := &dto.Metric{}
+	_ = c.Write(m)
+	return m.GetGauge().GetValue()
+}
@@ -0,0 +1,112 @@
+package shuttle
+
+import (
+	"context"
+	"reflect"
+	"regexp"
+	"runtime"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+)
+
+const modulePath = "github.com/Azure/go-shuttle/v2."
+
+// closureSuffix matches the suffixes of the names of the closures and of the method values.
+var closureSuffix = regexp.MustCompile(`(\.func\d+)+$|-fm$`)
+
+// NamedHandler is implemented by the handlers reporting their name in DescribeHandler.
+type NamedHandler interface {
+	Name() string
+}
+
+// NextHandler is implemented by the middlewares exposing the handler they wrap, for DescribeHandler to walk the chain.
+type NextHandler interface {
+	Next() Handler
+}
+
+// Middleware is a named middleware of the handler chain. It reports its name and the handler it wraps,
+// so that Processor.DescribePipeline lists the whole chain:
+//
+//	handler := shuttle.NewMiddleware("panic", func(next shuttle.Handler) shuttle.HandlerFunc {
+//		return shuttle.NewPanicHandler(nil, next)
+//	}, shuttle.NewMiddleware("renewlock", func(next shuttle.Handler) shuttle.HandlerFunc {
+//		return shuttle.NewLockRenewalHandler(receiver, nil, next)
+//	}, business))
+//	processor := shuttle.NewProcessorFromHandler(receiver, handler, nil)
+type Middleware struct {
+	name   string
+	handle HandlerFunc
+	next   Handler
+}
+
+// NewMiddleware wraps next with the middleware created by wrap, under the given name.
+func NewMiddleware(name string, wrap func(next Handler) HandlerFunc, next Handler) *Middleware {
+	return &Middleware{name: name, handle: wrap(next), next: next}
+}
+
+func (m *Middleware) Handle(ctx context.Context, settler MessageSettler, message *azservicebus.ReceivedMessage) {
+	m.handle(ctx, settler, message)
+}
+
+func (m *Middleware) Name() string {
+	return m.name
+}
+
+func (m *Middleware) Next() Handler {
+	return m.next
+}
+
+// DescribeHandler returns the names of the handlers of the chain, from the outermost to the innermost.
+// The chain is walked through the NextHandler interface. The handlers not implementing NamedHandler are named after
+// the function that created them, like shuttle.NewPanicHandler, and end the description when they are not a NextHandler,
+// as the handlers they wrap cannot be seen.
+func DescribeHandler(handler Handler) []string {
+	var names []string
+	for handler != nil {
+		names = append(names, handlerName(handler))
+		next, ok := handler.(NextHandler)
+		if !ok {
+			break
+		}
+		handler = next.Next()
+	}
+	return names
+}
+
+func handlerName(handler Handler) string {
+	if named, ok := handler.(NamedHandler); ok {
+		return named.Name()
+	}
+	value := reflect.ValueOf(handler)
+	if value.Kind() != reflect.Func {
+		return reflect.TypeOf(handler).String()
+	}
+	if value.IsNil() {
+		return "nil"
+	}
+	fn := runtime.FuncForPC(value.Pointer())
+	if fn == nil {
+		return reflect.TypeOf(handler).String()
+	}
+	name := closureSuffix.ReplaceAllString(fn.Name(), "")
+	if strings.HasPrefix(name, modulePath) {
+		return "shuttle." + strings.TrimPrefix(name, modulePath)
+	}
+	return name[strings.LastIndex(name, "/")+1:]
+}
+
+// DescribePipeline returns the names of the handlers of the processor, from the outermost to the innermost,
+// to check the order of the middlewares, for example that the lock renewal wraps the handler timeout.
+// It is logged when the processor starts. See DescribeHandler.
+func (p *Processor) DescribePipeline() []string {
+	return DescribeHandler(p.handle)
+}
+
+// NewProcessorFromHandler creates a Processor handling the messages with the handler, such as a Middleware,
+// which DescribePipeline can walk, unlike a HandlerFunc.
+func NewProcessorFromHandler(receiver Receiver, handler Handler, options *ProcessorOptions) *Processor {
+	p := NewProcessor(receiver, nil, options)
+	p.handle = handler
+	return p
+}
@@ -0,0 +1,42 @@
+package shuttle
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	. "github.com/onsi/gomega"
+)
+
+func TestDescribeHandler(t *testing.T) {
+	g := NewWithT(t)
+	var calls []string
+	business := HandlerFunc(func(ctx context.Context, settler MessageSettler, message *azservicebus.ReceivedMessage) {
+		calls = append(calls, "business")
+	})
+	handler := NewMiddleware("panic", func(next Handler) HandlerFunc {
+		return NewPanicHandler(nil, next)
+	}, NewMiddleware("timeout", func(next Handler) HandlerFunc {
+		return NewHandleTimeoutHandler(time.Second, next)
+	}, business))
+
+	g.Expect(DescribeHandler(handler)).To(Equal([]string{"panic", "timeout", "shuttle.TestDescribeHandler"}))
+	handler.Handle(context.Background(), &fakeSettler{}, &azservicebus.ReceivedMessage{})
+	g.Expect(calls).To(Equal([]string{"business"}))
+
+	// the chain cannot be walked past a HandlerFunc
+	g.Expect(DescribeHandler(NewPanicHandler(nil, handler))).To(Equal([]string{"shuttle.NewPanicHandler"}))
+	g.Expect(DescribeHandler(NewManagedSettlingHandler(nil, nil))).To(Equal([]string{"*shuttle.ManagedSettler"}))
+	g.Expect(DescribeHandler(HandlerFunc(noopHandler))).To(Equal([]string{"shuttle.noopHandler"}))
+}
+
+func TestProcessor_DescribePipeline(t *testing.T) {
+	g := NewWithT(t)
+	handler := NewMiddleware("renewlock", func(next Handler) HandlerFunc {
+		return NewLockRenewalHandler(&fakeSettler{}, nil, next)
+	}, HandlerFunc(noopHandler))
+	p := NewProcessorFromHandler(&idleReceiver{}, handler, nil)
+	g.Expect(p.DescribePipeline()).To(Equal([]string{"renewlock", "shuttle.noopHandler"}))
+	g.Expect(NewProcessor(&idleReceiver{}, noopHandler, nil).DescribePipeline()).To(Equal([]string{"shuttle.noopHandler"}))
+}
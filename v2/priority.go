@@ -0,0 +1,204 @@
+package shuttle
+
+import (
+	"context"
+	"strconv"
+	"sync"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+)
+
+// PriorityProperty is the application property holding the priority of a message, set by SetMessagePriority.
+const PriorityProperty = "goshuttle-priority"
+
+// Priorities of the default PriorityOptions. Higher values are handled more often.
+const (
+	PriorityLow    = 0
+	PriorityNormal = 1
+	PriorityHigh   = 2
+)
+
+// SetMessagePriority sets the PriorityProperty of the message, for the receivers using NewPriorityHandler.
+func SetMessagePriority(priority int) func(msg *azservicebus.Message) error {
+	return func(msg *azservicebus.Message) error {
+		if msg.ApplicationProperties == nil {
+			msg.ApplicationProperties = map[string]any{}
+		}
+		msg.ApplicationProperties[PriorityProperty] = int64(priority)
+		return nil
+	}
+}
+
+// MessagePriority returns the priority set on the message by SetMessagePriority.
+// It returns false when the message has no priority, or when the priority cannot be parsed.
+func MessagePriority(message *azservicebus.ReceivedMessage) (int, bool) {
+	switch priority := message.ApplicationProperties[PriorityProperty].(type) {
+	case int64:
+		return int(priority), true
+	case int32:
+		return int(priority), true
+	case int:
+		return priority, true
+	case string:
+		parsed, err := strconv.Atoi(priority)
+		return parsed, err == nil
+	default:
+		return 0, false
+	}
+}
+
+// PriorityOptions configures NewPriorityHandler.
+type PriorityOptions struct {
+	// Weights is the share of the handling slots given to each priority, indexed by priority,
+	// when messages of several priorities are waiting. The weights below 1 are set to 1.
+	// Defaults to 1, 2 and 4 for PriorityLow, PriorityNormal and PriorityHigh.
+	Weights []int
+	// DefaultPriority is the priority of the messages without priority. Defaults to PriorityNormal.
+	// The priorities outside of the Weights are set to the closest one.
+	DefaultPriority *int
+	// MaxConcurrency is the number of messages handled concurrently by the next handler. Defaults to 1.
+	MaxConcurrency int
+}
+
+// NewPriorityHandler is a middleware buffering the messages per priority in front of next, within a single queue.
+// Next handles MaxConcurrency messages at a time, and each free slot goes to a waiting message picked by weighted
+// round-robin across the priorities, so that the high priority messages are handled sooner without starving the others.
+// The order of the messages is only kept within a priority.
+//
+// The messages are buffered while the processor receives them, so the MaxConcurrency of the processor must be higher
+// than the MaxConcurrency of the handler, the difference being the size of the buffer. The locks of the buffered messages
+// are renewed when the lock renewal middleware wraps this handler.
+func NewPriorityHandler(options *PriorityOptions, next Handler) HandlerFunc {
+	queue := newPriorityQueue(options)
+	return func(ctx context.Context, settler MessageSettler, message *azservicebus.ReceivedMessage) {
+		if !queue.acquire(ctx, queue.priority(message)) {
+			// the processor is stopping, the message lock expires and the message is redelivered.
+			log(ctx, "context done before the message was handled by priority")
+			return
+		}
+		defer queue.release()
+		next.Handle(ctx, settler, message)
+	}
+}
+
+// priorityQueue hands out the handling slots to the waiting messages with smooth weighted round-robin.
+type priorityQueue struct {
+	mu              sync.Mutex
+	weights         []int
+	current         []int
+	waiting         [][]*priorityWaiter
+	running         int
+	maxConcurrency  int
+	defaultPriority int
+}
+
+type priorityWaiter struct {
+	ready   chan struct{}
+	granted bool
+}
+
+func newPriorityQueue(options *PriorityOptions) *priorityQueue {
+	opts := PriorityOptions{}
+	if options != nil {
+		opts = *options
+	}
+	weights := []int{1, 2, 4}
+	if len(opts.Weights) > 0 {
+		weights = make([]int, len(opts.Weights))
+		for i, weight := range opts.Weights {
+			weights[i] = max(weight, 1)
+		}
+	}
+	defaultPriority := PriorityNormal
+	if opts.DefaultPriority != nil {
+		defaultPriority = *opts.DefaultPriority
+	}
+	return &priorityQueue{
+		weights:         weights,
+		current:         make([]int, len(weights)),
+		waiting:         make([][]*priorityWaiter, len(weights)),
+		maxConcurrency:  max(opts.MaxConcurrency, 1),
+		defaultPriority: min(max(defaultPriority, 0), len(weights)-1),
+	}
+}
+
+func (q *priorityQueue) priority(message *azservicebus.ReceivedMessage) int {
+	priority, ok := MessagePriority(message)
+	if !ok {
+		return q.defaultPriority
+	}
+	return min(max(priority, 0), len(q.weights)-1)
+}
+
+// acquire waits for a handling slot. It returns false when ctx is done first.
+func (q *priorityQueue) acquire(ctx context.Context, priority int) bool {
+	q.mu.Lock()
+	if q.running < q.maxConcurrency && q.pending() == 0 {
+		q.running++
+		q.mu.Unlock()
+		return true
+	}
+	waiter := &priorityWaiter{ready: make(chan struct{})}
+	q.waiting[priority] = append(q.waiting[priority], waiter)
+	q.mu.Unlock()
+
+	select {
+	case <-waiter.ready:
+		return true
+	case <-ctx.Done():
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if waiter.granted {
+		// the slot was granted concurrently, give it to the next message.
+		q.running--
+		q.dispatch()
+		return false
+	}
+	for i, w := range q.waiting[priority] {
+		if w == waiter {
+			q.waiting[priority] = append(q.waiting[priority][:i], q.waiting[priority][i+1:]...)
+			break
+		}
+	}
+	return false
+}
+
+func (q *priorityQueue) release() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.running--
+	q.dispatch()
+}
+
+// dispatch grants the free slots to the waiting messages, to the highest priority on ties.
+// It must be called with the lock held.
+func (q *priorityQueue) dispatch() {
+	for q.running < q.maxConcurrency && q.pending() > 0 {
+		total, next := 0, -1
+		for priority, waiters := range q.waiting {
+			if len(waiters) == 0 {
+				continue
+			}
+			q.current[priority] += q.weights[priority]
+			total += q.weights[priority]
+			if next < 0 || q.current[priority] >= q.current[next] {
+				next = priority
+			}
+		}
+		q.current[next] -= total
+		waiter := q.waiting[next][0]
+		q.waiting[next] = q.waiting[next][1:]
+		waiter.granted = true
+		q.running++
+		close(waiter.ready)
+	}
+}
+
+func (q *priorityQueue) pending() int {
+	count := 0
+	for _, waiters := range q.waiting {
+		count += len(waiters)
+	}
+	return count
+}
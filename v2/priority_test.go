@@ -0,0 +1,93 @@
+package shuttle
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	. "github.com/onsi/gomega"
+)
+
+func TestMessagePriority(t *testing.T) {
+	g := NewWithT(t)
+	msg := &azservicebus.Message{}
+	g.Expect(SetMessagePriority(PriorityHigh)(msg)).To(Succeed())
+	priority, ok := MessagePriority(&azservicebus.ReceivedMessage{ApplicationProperties: msg.ApplicationProperties})
+	g.Expect(ok).To(BeTrue())
+	g.Expect(priority).To(Equal(PriorityHigh))
+
+	priority, ok = MessagePriority(&azservicebus.ReceivedMessage{ApplicationProperties: map[string]any{PriorityProperty: "0"}})
+	g.Expect(ok).To(BeTrue())
+	g.Expect(priority).To(Equal(PriorityLow))
+
+	_, ok = MessagePriority(&azservicebus.ReceivedMessage{ApplicationProperties: map[string]any{PriorityProperty: "urgent"}})
+	g.Expect(ok).To(BeFalse())
+	_, ok = MessagePriority(&azservicebus.ReceivedMessage{})
+	g.Expect(ok).To(BeFalse())
+}
+
+func TestPriorityQueue_Weights(t *testing.T) {
+	g := NewWithT(t)
+	queue := newPriorityQueue(&PriorityOptions{Weights: []int{1, 3}})
+	g.Expect(queue.priority(&azservicebus.ReceivedMessage{})).To(Equal(1))
+	g.Expect(queue.priority(&azservicebus.ReceivedMessage{ApplicationProperties: map[string]any{PriorityProperty: int64(7)}})).To(Equal(1))
+	g.Expect(queue.acquire(context.Background(), 0)).To(BeTrue())
+
+	var mu sync.Mutex
+	var order []int
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		priority := i % 2
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			g.Expect(queue.acquire(context.Background(), priority)).To(BeTrue())
+			mu.Lock()
+			order = append(order, priority)
+			mu.Unlock()
+			queue.release()
+		}()
+		g.Eventually(func() int {
+			queue.mu.Lock()
+			defer queue.mu.Unlock()
+			return queue.pending()
+		}).Should(Equal(i + 1))
+	}
+	queue.release()
+	wg.Wait()
+	// 3 high priority messages for each low priority one, until the high priority messages are drained.
+	g.Expect(order).To(Equal([]int{1, 1, 0, 1, 1, 0, 0, 0}))
+}
+
+func TestPriorityHandler_ContextDone(t *testing.T) {
+	g := NewWithT(t)
+	started, block := make(chan struct{}, 1), make(chan struct{})
+	var handled []string
+	var mu sync.Mutex
+	handler := NewPriorityHandler(&PriorityOptions{DefaultPriority: to.Ptr(PriorityLow)}, HandlerFunc(
+		func(ctx context.Context, settler MessageSettler, message *azservicebus.ReceivedMessage) {
+			started <- struct{}{}
+			<-block
+			mu.Lock()
+			handled = append(handled, message.MessageID)
+			mu.Unlock()
+		}))
+
+	done := make(chan struct{})
+	go func() {
+		handler(context.Background(), &fakeSettler{}, &azservicebus.ReceivedMessage{MessageID: "first"})
+		close(done)
+	}()
+	<-started
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	// the buffered message is given up when its context is done
+	handler(ctx, &fakeSettler{}, &azservicebus.ReceivedMessage{MessageID: "canceled"})
+	close(block)
+	<-done
+	handler(context.Background(), &fakeSettler{}, &azservicebus.ReceivedMessage{MessageID: "last"})
+	g.Expect(handled).To(Equal([]string{"first", "last"}))
+}
@@ -3,6 +3,11 @@ package shuttle
 import (
 	"context"
 	"fmt"
+	"reflect"
+	"runtime/pprof"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
@@ -10,12 +15,32 @@ import (
 	"github.com/Azure/go-shuttle/v2/metrics/processor"
 )
 
-type Receiver interface {
+// MessageSource provides the messages handled by the Processor. It is satisfied by *azservicebus.Receiver.
+// Other implementations, such as an in-memory source or a replay of captured messages,
+// can drive the same processing pipeline when combined with a MessageSettler in NewSourceReceiver.
+type MessageSource interface {
 	ReceiveMessages(ctx context.Context, maxMessages int, options *azservicebus.ReceiveMessagesOptions) ([]*azservicebus.ReceivedMessage, error)
+}
+
+// Receiver is the MessageSource and MessageSettler used by the Processor. It is satisfied by *azservicebus.Receiver.
+type Receiver interface {
+	MessageSource
+	MessageSettler
+}
+
+// NewSourceReceiver combines a MessageSource with the MessageSettler settling its messages into a Receiver for the Processor.
+func NewSourceReceiver(source MessageSource, settler MessageSettler) Receiver {
+	return &sourceReceiver{MessageSource: source, MessageSettler: settler}
+}
+
+type sourceReceiver struct {
+	MessageSource
 	MessageSettler
 }
 
 // MessageSettler is passed to the handlers. it exposes the message settling functionality from the receiver needed within the handler.
+// The Processor passes the receiver wrapped to record the settlements: use UnwrapSettler to get the receiver,
+// for example to assert it is an *azservicebus.Receiver.
 type MessageSettler interface {
 	AbandonMessage(ctx context.Context, message *azservicebus.ReceivedMessage, options *azservicebus.AbandonMessageOptions) error
 	CompleteMessage(ctx context.Context, message *azservicebus.ReceivedMessage, options *azservicebus.CompleteMessageOptions) error
@@ -37,19 +62,66 @@ func (f HandlerFunc) Handle(ctx context.Context, settler MessageSettler, message
 
 // Processor encapsulates the message pump and concurrency handling of servicebus.
 // it exposes a handler API to provides a middleware based message processing pipeline.
+// Its methods are safe for concurrent use, but Start can only be called once at a time, see ErrProcessorRunning.
+// Its options are copied by NewProcessor and can only be changed afterwards with UpdateOptions.
 type Processor struct {
-	receiver          Receiver
+	receiverMu        sync.RWMutex
+	receiver          Receiver // replaced when the receive watchdog recovers from a stall, see WithReceiverRecovery
+	optionsMu         sync.RWMutex
 	options           ProcessorOptions
+	guard             *optionsGuard[ProcessorOptions] // reports the changes made to the caller's options after NewProcessor
 	handle            Handler
-	concurrencyTokens chan struct{} // tracks how many concurrent messages are currently being handled by the processor
+	concurrencyTokens *concurrencyLimiter // tracks how many concurrent messages are currently being handled by the processor
+	stats             *processorStats
+	lastActivity      atomic.Int64  // unix nano time of the last completed receive call
+	started           chan struct{} // closed once the receiver is attached, see Started
+	startedOnce       sync.Once
+	attachOnce        sync.Once // peeks the entity once to attach the receiver, see attach
+	running           atomic.Bool
+	windowClosed      atomic.Bool         // whether the ReceiveWindow was closed at the last receive, to log its transitions
+	settleThrottle    *settlementThrottle // paces the settlements throttled by the namespace, nil when disabled
+	settlePaused      atomic.Bool         // whether the receives were paused by a throttled settlement at the last receive, to log its transitions
+	receiveRate       receiveRateLimiter  // limits the receives to MaxMessagesPerSecond
 }
 
 // ProcessorOptions configures the processor
 // MaxConcurrency defaults to 1. Not setting MaxConcurrency, or setting it to 0 or a negative value will fallback to the default.
 // ReceiveInterval defaults to 2 seconds if not set.
+// EntityName optionally identifies the queue or subscription the processor receives from.
+// It is used to label the handler goroutines in CPU profiles and the processor metrics,
+// which are labeled "default" when it is not set.
+// Namespace optionally identifies the namespace of the entity.
+// EntityName and Namespace are set in the context of the handlers, see MessageOriginFromContext.
+// StrictOrdering handles the messages one at a time, in the order they are received. See WithStrictOrdering.
+// ReceiveStallTimeout enables the receive watchdog. See WithReceiveWatchdog.
+// NewReceiver recreates the receiver when the receive watchdog detects a stall. See WithReceiverRecovery.
+// OnError is called with the errors the processor detects, such as ErrReceiveStalled, before Start returns them
+// or the processor recovers from them.
+// ConcurrencyLimiter optionally caps the messages handled concurrently across all the processors sharing it,
+// in addition to the MaxConcurrency of each processor.
+// Hooks are called when a message is received and settled. See Hooks.
+// ReceiveWindow optionally restricts the times at which the processor receives messages. See ReceiveWindow.
+// SettlementThrottling optionally pauses the settlements and the receives while the namespace throttles the settlements.
+// See SettlementThrottlingOptions.
+// HandleTimeout optionally bounds the time spent handling each message, like NewHandleTimeoutHandler.
+// MaxMessagesPerSecond optionally limits the rate at which the messages are received, with bursts of up to one second of messages.
+// Clock is used to measure the timeouts of the receive watchdog. Defaults to the system clock.
 type ProcessorOptions struct {
-	MaxConcurrency  int
-	ReceiveInterval *time.Duration
+	MaxConcurrency       int
+	ReceiveInterval      *time.Duration
+	EntityName           string
+	Namespace            string
+	StrictOrdering       bool
+	ReceiveStallTimeout  time.Duration
+	NewReceiver          func(ctx context.Context) (Receiver, error)
+	OnError              func(ctx context.Context, err error)
+	ConcurrencyLimiter   *ConcurrencyLimiter
+	Hooks                *Hooks
+	ReceiveWindow        ReceiveWindow
+	SettlementThrottling *SettlementThrottlingOptions
+	HandleTimeout        time.Duration
+	MaxMessagesPerSecond float64
+	Clock                Clock
 }
 
 func NewProcessor(receiver Receiver, handler HandlerFunc, options *ProcessorOptions) *Processor {
@@ -59,45 +131,318 @@ func NewProcessor(receiver Receiver, handler HandlerFunc, options *ProcessorOpti
 	}
 	if options != nil {
 		if options.ReceiveInterval != nil {
-			opts.ReceiveInterval = options.ReceiveInterval
+			opts.ReceiveInterval = to.Ptr(*options.ReceiveInterval)
 		}
 		if options.MaxConcurrency >= 0 {
 			opts.MaxConcurrency = options.MaxConcurrency
 		}
+		opts.EntityName = options.EntityName
+		opts.Namespace = options.Namespace
+		opts.StrictOrdering = options.StrictOrdering
+		opts.ReceiveStallTimeout = options.ReceiveStallTimeout
+		opts.NewReceiver = options.NewReceiver
+		opts.OnError = options.OnError
+		opts.ConcurrencyLimiter = options.ConcurrencyLimiter
+		opts.ReceiveWindow = options.ReceiveWindow
+		opts.HandleTimeout = options.HandleTimeout
+		opts.MaxMessagesPerSecond = options.MaxMessagesPerSecond
+		opts.Clock = options.Clock
+		if options.SettlementThrottling != nil {
+			throttling := *options.SettlementThrottling
+			opts.SettlementThrottling = &throttling
+		}
+		if options.Hooks != nil {
+			hooks := *options.Hooks
+			opts.Hooks = &hooks
+		}
+	}
+	if opts.StrictOrdering && opts.MaxConcurrency == 0 {
+		opts.MaxConcurrency = 1
 	}
 	return &Processor{
 		receiver:          receiver,
 		handle:            handler,
 		options:           opts,
-		concurrencyTokens: make(chan struct{}, opts.MaxConcurrency),
+		guard:             newOptionsGuard(options),
+		concurrencyTokens: newConcurrencyLimiter(opts.MaxConcurrency),
+		stats:             &processorStats{},
+		started:           make(chan struct{}),
+		settleThrottle:    newSettlementThrottle(opts.SettlementThrottling),
+	}
+}
+
+// ProcessorOption configures the Processor created by NewProcessorWithOptions.
+type ProcessorOption func(options *ProcessorOptions)
+
+// NewProcessorWithOptions creates a Processor configured with functional options.
+// It applies the same defaults as NewProcessor: a MaxConcurrency of 1 and a ReceiveInterval of 1 second.
+func NewProcessorWithOptions(receiver Receiver, handler HandlerFunc, options ...ProcessorOption) *Processor {
+	opts := &ProcessorOptions{MaxConcurrency: 1}
+	for _, option := range options {
+		option(opts)
+	}
+	return NewProcessor(receiver, handler, opts)
+}
+
+// WithMaxConcurrency sets the maximum number of messages handled concurrently.
+func WithMaxConcurrency(maxConcurrency int) ProcessorOption {
+	return func(options *ProcessorOptions) {
+		options.MaxConcurrency = maxConcurrency
+	}
+}
+
+// WithReceiveInterval sets the interval between two receive calls.
+func WithReceiveInterval(interval time.Duration) ProcessorOption {
+	return func(options *ProcessorOptions) {
+		options.ReceiveInterval = &interval
+	}
+}
+
+// WithEntityName sets the name of the queue or subscription the processor receives from.
+func WithEntityName(entityName string) ProcessorOption {
+	return func(options *ProcessorOptions) {
+		options.EntityName = entityName
+	}
+}
+
+// WithNamespace sets the namespace of the entity the processor receives from.
+func WithNamespace(namespace string) ProcessorOption {
+	return func(options *ProcessorOptions) {
+		options.Namespace = namespace
+	}
+}
+
+// WithStrictOrdering handles the messages one at a time, in the order they are received from the queue or subscription.
+// It receives a single message per ReceiveMessages call, so that no message is locked on the client while
+// the previous one is being handled, and handles it with a MaxConcurrency of 1.
+// There is no prefetch to disable: the azservicebus receiver only requests from the entity the messages
+// of the current ReceiveMessages call, and releases the ones arriving after it returned.
+// A MaxConcurrency greater than 1 conflicts with StrictOrdering: it is rejected by ProcessorOptions.Validate,
+// NewProcessorE, UpdateOptions and Start, whatever the order of the options.
+func WithStrictOrdering() ProcessorOption {
+	return func(options *ProcessorOptions) {
+		options.StrictOrdering = true
+	}
+}
+
+// UpdateOptions adjusts the options of a running processor, for example to tune its throughput from a config service.
+// MaxConcurrency, ReceiveInterval, HandleTimeout, MaxMessagesPerSecond, EntityName, Namespace and ReceiveWindow can be updated.
+// Lowering MaxConcurrency does not interrupt the messages being handled: the processor stops receiving until enough of them complete.
+// The new HandleTimeout applies to the messages received after the update.
+// The options are validated before being applied. An invalid update, or an update of another option,
+// returns an *OptionError and leaves the options unchanged.
+func (p *Processor) UpdateOptions(options ...ProcessorOption) error {
+	p.optionsMu.Lock()
+	defer p.optionsMu.Unlock()
+	opts := p.options
+	for _, option := range options {
+		option(&opts)
+	}
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+	if name, changed := fixedOptionChanged(p.options, opts); changed {
+		return &OptionError{Option: name, Reason: "cannot be changed after NewProcessor"}
+	}
+	if opts.MaxConcurrency == 0 {
+		return &OptionError{Option: "MaxConcurrency", Reason: "must be at least 1 on a running processor"}
+	}
+	if opts.ReceiveInterval == nil {
+		return &OptionError{Option: "ReceiveInterval", Reason: "must not be nil"}
+	}
+	p.options = opts
+	p.concurrencyTokens.setLimit(opts.MaxConcurrency)
+	return nil
+}
+
+// fixedOptionChanged returns the first option that UpdateOptions cannot reload and that differs between the options.
+// The funcs are compared by pointer, as they cannot be compared by value.
+func fixedOptionChanged(before, after ProcessorOptions) (string, bool) {
+	switch {
+	case before.StrictOrdering != after.StrictOrdering:
+		return "StrictOrdering", true
+	case before.ReceiveStallTimeout != after.ReceiveStallTimeout:
+		return "ReceiveStallTimeout", true
+	case reflect.ValueOf(before.NewReceiver).Pointer() != reflect.ValueOf(after.NewReceiver).Pointer():
+		return "NewReceiver", true
+	case reflect.ValueOf(before.OnError).Pointer() != reflect.ValueOf(after.OnError).Pointer():
+		return "OnError", true
+	case before.ConcurrencyLimiter != after.ConcurrencyLimiter:
+		return "ConcurrencyLimiter", true
+	case before.Hooks != after.Hooks:
+		return "Hooks", true
+	case before.SettlementThrottling != after.SettlementThrottling:
+		return "SettlementThrottling", true
+	}
+	return "", false
+}
+
+// WithHandleTimeout bounds the time spent handling each message. The message context is canceled
+// with the ErrHandleTimeout cause once the timeout elapses. It can be changed with UpdateOptions.
+func WithHandleTimeout(timeout time.Duration) ProcessorOption {
+	return func(options *ProcessorOptions) {
+		options.HandleTimeout = timeout
+	}
+}
+
+// WithMaxMessagesPerSecond limits the rate at which the processor receives messages, for example to protect
+// a downstream system. It can be changed with UpdateOptions. 0 disables the limit.
+func WithMaxMessagesPerSecond(rate float64) ProcessorOption {
+	return func(options *ProcessorOptions) {
+		options.MaxMessagesPerSecond = rate
+	}
+}
+
+// WithProcessorClock sets the Clock used to measure the timeouts of the receive watchdog.
+func WithProcessorClock(clock Clock) ProcessorOption {
+	return func(options *ProcessorOptions) {
+		options.Clock = clock
+	}
+}
+
+// Options returns a copy of the current processor options.
+func (p *Processor) Options() ProcessorOptions {
+	return p.currentOptions()
+}
+
+func (p *Processor) currentOptions() ProcessorOptions {
+	p.optionsMu.RLock()
+	defer p.optionsMu.RUnlock()
+	return p.options
+}
+
+// WithConcurrencyLimiter shares the ConcurrencyLimiter between processors, to cap the messages handled concurrently
+// across all of them, for example to bound the memory used by a service consuming several subscriptions.
+func WithConcurrencyLimiter(limiter *ConcurrencyLimiter) ProcessorOption {
+	return func(options *ProcessorOptions) {
+		options.ConcurrencyLimiter = limiter
+	}
+}
+
+// ConcurrencyLimiter limits the number of messages handled concurrently by the processors it is shared with.
+// The processors only receive as many messages as there are tokens available in their own MaxConcurrency and
+// in the shared limiter, so that received messages rarely wait for a token while their lock is held.
+type ConcurrencyLimiter struct {
+	limiter *concurrencyLimiter
+}
+
+// NewConcurrencyLimiter creates a ConcurrencyLimiter allowing limit messages to be handled concurrently.
+func NewConcurrencyLimiter(limit int) *ConcurrencyLimiter {
+	return &ConcurrencyLimiter{limiter: newConcurrencyLimiter(limit)}
+}
+
+// SetLimit changes the limit. Lowering it does not interrupt the messages being handled.
+func (c *ConcurrencyLimiter) SetLimit(limit int) {
+	c.limiter.setLimit(limit)
+}
+
+// InUse returns the number of messages currently being handled across the processors.
+func (c *ConcurrencyLimiter) InUse() int {
+	c.limiter.mu.Lock()
+	defer c.limiter.mu.Unlock()
+	return c.limiter.inUse
+}
+
+// concurrencyLimiter is a semaphore which limit can be changed while it is in use.
+type concurrencyLimiter struct {
+	mu    sync.Mutex
+	cond  *sync.Cond
+	limit int
+	inUse int
+}
+
+func newConcurrencyLimiter(limit int) *concurrencyLimiter {
+	l := &concurrencyLimiter{limit: limit}
+	l.cond = sync.NewCond(&l.mu)
+	return l
+}
+
+// acquire blocks until a token is available.
+func (l *concurrencyLimiter) acquire() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for l.inUse >= l.limit {
+		l.cond.Wait()
 	}
+	l.inUse++
+}
+
+func (l *concurrencyLimiter) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inUse--
+	l.cond.Broadcast()
+}
+
+// available returns the number of tokens that can be acquired without blocking. It is negative when the limit
+// was lowered below the number of tokens in use.
+func (l *concurrencyLimiter) available() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.limit - l.inUse
+}
+
+func (l *concurrencyLimiter) setLimit(limit int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.limit = limit
+	l.cond.Broadcast()
+}
+
+// Stats returns a snapshot of the processor's message pump statistics.
+func (p *Processor) Stats() ProcessorStats {
+	return p.stats.snapshot()
 }
 
 // Start starts the processor and blocks until an error occurs or the context is canceled.
+// It returns ErrProcessorRunning when the processor is already running.
 func (p *Processor) Start(ctx context.Context) error {
-	log(ctx, "starting processor")
-	messages, err := p.receiver.ReceiveMessages(ctx, p.options.MaxConcurrency, nil)
-	if err != nil {
+	if !p.running.CompareAndSwap(false, true) {
+		return ErrProcessorRunning
+	}
+	defer p.running.Store(false)
+	opts := p.currentOptions()
+	if err := opts.validateStrictOrdering(); err != nil {
 		return err
 	}
-	log(ctx, fmt.Sprintf("received %d messages - initial", len(messages)))
-	processor.Metric.IncMessageReceived(float64(len(messages)))
-	for _, msg := range messages {
-		p.process(ctx, msg)
+	log(ctx, "starting processor")
+	p.guard.check(ctx, "Processor")
+	defer p.guard.check(ctx, "Processor")
+	log(ctx, fmt.Sprintf("handler pipeline: %s", strings.Join(p.DescribePipeline(), " -> ")))
+	p.attach(ctx)
+	// the initial receive is skipped when the shared concurrency limiter is exhausted by other processors
+	if count := p.receiveRate.allowed(time.Now(), p.currentOptions().MaxMessagesPerSecond, p.initialReceiveCount()); count > 0 && p.windowOpen(ctx) {
+		messages, err := p.receive(ctx, count)
+		if err != nil {
+			p.stats.recordReceiveError(err)
+			return err
+		}
+		log(ctx, fmt.Sprintf("received %d messages - initial", len(messages)))
+		processor.Metric.IncMessageReceived(float64(len(messages)))
+		processor.Metric.ObserveReceiveBatch(p.currentOptions().EntityName, len(messages))
+		p.stats.received.Add(int64(len(messages)))
+		p.receiveRate.take(len(messages))
+		for _, msg := range messages {
+			p.process(ctx, msg)
+		}
 	}
 	for ctx.Err() == nil {
 		select {
-		case <-time.After(*p.options.ReceiveInterval):
-			maxMessages := p.options.MaxConcurrency - len(p.concurrencyTokens)
-			if ctx.Err() != nil || maxMessages == 0 {
+		case <-time.After(*p.currentOptions().ReceiveInterval):
+			maxMessages := p.receiveRate.allowed(time.Now(), p.currentOptions().MaxMessagesPerSecond, p.availableConcurrency())
+			if ctx.Err() != nil || maxMessages <= 0 || !p.windowOpen(ctx) || p.settlementPaused(ctx) {
 				break
 			}
-			messages, err := p.receiver.ReceiveMessages(ctx, maxMessages, nil)
+			messages, err := p.receive(ctx, maxMessages)
 			if err != nil {
+				p.stats.recordReceiveError(err)
 				return err
 			}
 			log(ctx, fmt.Sprintf("received %d messages from processor loop", len(messages)))
 			processor.Metric.IncMessageReceived(float64(len(messages)))
+			processor.Metric.ObserveReceiveBatch(p.currentOptions().EntityName, len(messages))
+			p.stats.received.Add(int64(len(messages)))
+			p.receiveRate.take(len(messages))
 			for _, msg := range messages {
 				p.process(ctx, msg)
 			}
@@ -110,22 +455,117 @@ func (p *Processor) Start(ctx context.Context) error {
 	return ctx.Err()
 }
 
+// initialReceiveCount is the number of messages received when the processor starts.
+func (p *Processor) initialReceiveCount() int {
+	count := p.currentOptions().MaxConcurrency
+	if shared := p.currentOptions().ConcurrencyLimiter; shared != nil {
+		if available := shared.limiter.available(); available < count {
+			count = available
+		}
+	}
+	return count
+}
+
+// windowOpen reports whether the ReceiveWindow allows receiving, and logs when it opens or closes.
+func (p *Processor) windowOpen(ctx context.Context) bool {
+	window := p.currentOptions().ReceiveWindow
+	if window == nil {
+		return true
+	}
+	open := window.Open(time.Now())
+	if p.windowClosed.Swap(!open) == open {
+		if open {
+			log(ctx, "receive window opened, resuming receiving")
+		} else {
+			log(ctx, "receive window closed, pausing receiving")
+		}
+	}
+	return open
+}
+
+// availableConcurrency returns the number of messages that can be handled without waiting for a token.
+func (p *Processor) availableConcurrency() int {
+	available := p.concurrencyTokens.available()
+	if shared := p.currentOptions().ConcurrencyLimiter; shared != nil {
+		if sharedAvailable := shared.limiter.available(); sharedAvailable < available {
+			available = sharedAvailable
+		}
+	}
+	return available
+}
+
+// deadlineContext reports the deadline of deadlineFrom, for a context detached from it with context.WithoutCancel.
+type deadlineContext struct {
+	context.Context
+	deadlineFrom context.Context
+}
+
+func (c deadlineContext) Deadline() (time.Time, bool) {
+	return c.deadlineFrom.Deadline()
+}
+
 func (p *Processor) process(ctx context.Context, message *azservicebus.ReceivedMessage) {
-	p.concurrencyTokens <- struct{}{}
+	p.concurrencyTokens.acquire()
+	shared := p.currentOptions().ConcurrencyLimiter
+	if shared != nil {
+		shared.limiter.acquire()
+	}
 	go func() {
-		msgContext, cancel := context.WithCancel(ctx)
+		// the message context is detached from ctx, to be canceled with the ErrProcessorShuttingDown cause when ctx is done.
+		// it keeps the deadline of ctx, so that the handlers can budget their work.
+		msgContext, cancel := context.WithCancelCause(deadlineContext{Context: context.WithoutCancel(ctx), deadlineFrom: ctx})
+		stopShutdown := context.AfterFunc(ctx, func() { cancel(ErrProcessorShuttingDown) })
+		defer stopShutdown()
 		// cancel messageContext when we get out of this goroutine
-		defer cancel()
+		defer cancel(nil)
+		start := time.Now()
 		defer func() {
-			<-p.concurrencyTokens
+			if shared != nil {
+				shared.limiter.release()
+			}
+			p.concurrencyTokens.release()
 			processor.Metric.IncMessageHandled(message)
 			processor.Metric.DecConcurrentMessageCount(message)
+			p.stats.inFlight.Add(-1)
+			p.stats.recordHandled(time.Since(start))
 		}()
 		processor.Metric.IncConcurrentMessageCount(message)
-		p.handle.Handle(msgContext, p.receiver, message)
+		p.stats.inFlight.Add(1)
+		opts := p.currentOptions()
+		if opts.HandleTimeout > 0 {
+			var cancelTimeout context.CancelFunc
+			msgContext, cancelTimeout = context.WithTimeoutCause(msgContext, opts.HandleTimeout, ErrHandleTimeout)
+			defer cancelTimeout()
+		}
+		msgContext = ContextWithMessageOrigin(msgContext, MessageOrigin{Namespace: opts.Namespace, Entity: opts.EntityName})
+		opts.Hooks.messageReceived(msgContext, MessageReceivedEvent{Entity: opts.EntityName, Message: message})
+		settler := newStatsSettler(p.settler(), p.stats, opts.EntityName)
+		settler.hooks = opts.Hooks
+		settler.start = start
+		// the message lock expires on the broker if the handler returns without settling it.
+		defer settler.release()
+		// label the handler goroutine so that CPU profiles can be attributed per entity and message type.
+		pprof.Do(msgContext, p.profilerLabels(message), func(msgContext context.Context) {
+			p.handle.Handle(msgContext, settler, message)
+		})
 	}()
 }
 
+const (
+	entityProfilerLabel      = "goshuttle.entity"
+	messageTypeProfilerLabel = "goshuttle.messageType"
+)
+
+func (p *Processor) profilerLabels(message *azservicebus.ReceivedMessage) pprof.LabelSet {
+	msgType := ""
+	if message != nil {
+		if t, ok := message.ApplicationProperties[msgTypeField].(string); ok {
+			msgType = t
+		}
+	}
+	return pprof.Labels(entityProfilerLabel, p.currentOptions().EntityName, messageTypeProfilerLabel, msgType)
+}
+
 type PanicHandlerOptions struct {
 	OnPanicRecovered func(
 		ctx context.Context,
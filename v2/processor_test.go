@@ -2,6 +2,8 @@ package shuttle_test
 
 import (
 	"context"
+	"runtime/pprof"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -70,6 +72,213 @@ func TestProcessorStart_DefaultsToMaxConcurrency1(t *testing.T) {
 	a.Equal(1, rcv.ReceiveCalls[0], "the processor should have used the default max concurrency of 1")
 }
 
+func TestProcessorStart_WithOptions(t *testing.T) {
+	a := require.New(t)
+	messages := make(chan *azservicebus.ReceivedMessage, 1)
+	messages <- &azservicebus.ReceivedMessage{}
+	close(messages)
+	rcv := &fakeReceiver{
+		fakeSettler:           &fakeSettler{},
+		SetupReceivedMessages: messages,
+	}
+	processor := shuttle.NewProcessorWithOptions(rcv, MyHandler(0*time.Second),
+		shuttle.WithMaxConcurrency(3),
+		shuttle.WithReceiveInterval(10*time.Millisecond),
+		shuttle.WithEntityName("queue"))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := processor.Start(ctx)
+	a.EqualError(err, "max receive calls exceeded")
+	a.Equal(1, len(rcv.ReceiveCalls), "there should be 1 entry in the ReceiveCalls array")
+	a.Equal(3, rcv.ReceiveCalls[0], "the processor should have used the max concurrency option")
+}
+
+func TestProcessor_UpdateOptions(t *testing.T) {
+	a := require.New(t)
+	messages := make(chan *azservicebus.ReceivedMessage, 1)
+	messages <- &azservicebus.ReceivedMessage{}
+	close(messages)
+	rcv := &fakeReceiver{
+		fakeSettler:           &fakeSettler{},
+		SetupReceivedMessages: messages,
+	}
+	processor := shuttle.NewProcessorWithOptions(rcv, MyHandler(0*time.Second), shuttle.WithEntityName("queue"))
+
+	a.NoError(processor.UpdateOptions(shuttle.WithMaxConcurrency(4), shuttle.WithReceiveInterval(5*time.Millisecond)))
+	a.Equal(4, processor.Options().MaxConcurrency)
+	a.Equal(5*time.Millisecond, *processor.Options().ReceiveInterval)
+	a.Equal("queue", processor.Options().EntityName)
+
+	a.ErrorIs(processor.UpdateOptions(shuttle.WithMaxConcurrency(-1)), shuttle.ErrInvalidOptions)
+	a.ErrorIs(processor.UpdateOptions(shuttle.WithMaxConcurrency(0)), shuttle.ErrInvalidOptions)
+	a.ErrorIs(processor.UpdateOptions(shuttle.WithReceiveInterval(0)), shuttle.ErrInvalidOptions)
+	a.Equal(4, processor.Options().MaxConcurrency, "invalid updates should leave the options unchanged")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := processor.Start(ctx)
+	a.EqualError(err, "max receive calls exceeded")
+	a.Equal(4, rcv.ReceiveCalls[0], "the processor should use the updated max concurrency")
+}
+
+func TestProcessor_UpdateOptionsRejectsFixedOptions(t *testing.T) {
+	a := require.New(t)
+	rcv := &fakeReceiver{fakeSettler: &fakeSettler{}}
+	processor := shuttle.NewProcessorWithOptions(rcv, MyHandler(0), shuttle.WithReceiveWatchdog(time.Minute, nil))
+	for name, option := range map[string]shuttle.ProcessorOption{
+		"StrictOrdering": func(options *shuttle.ProcessorOptions) {
+			shuttle.WithStrictOrdering()(options)
+			options.MaxConcurrency = 1
+		},
+		"ReceiveStallTimeout": shuttle.WithReceiveWatchdog(time.Hour, nil),
+		"ConcurrencyLimiter":  shuttle.WithConcurrencyLimiter(shuttle.NewConcurrencyLimiter(1)),
+		"OnError":             func(options *shuttle.ProcessorOptions) { options.OnError = func(context.Context, error) {} },
+		"Hooks":               func(options *shuttle.ProcessorOptions) { options.Hooks = &shuttle.Hooks{} },
+	} {
+		var optionErr *shuttle.OptionError
+		a.ErrorAs(processor.UpdateOptions(shuttle.WithMaxConcurrency(2), option), &optionErr, name)
+		a.Equal(name, optionErr.Option)
+	}
+	a.Equal(1, processor.Options().MaxConcurrency, "rejected updates should leave the options unchanged")
+	a.NoError(processor.UpdateOptions(shuttle.WithHandleTimeout(time.Second), shuttle.WithMaxMessagesPerSecond(10)))
+	a.Equal(time.Second, processor.Options().HandleTimeout)
+	a.Equal(float64(10), processor.Options().MaxMessagesPerSecond)
+	a.ErrorIs(processor.UpdateOptions(shuttle.WithHandleTimeout(-time.Second)), shuttle.ErrInvalidOptions)
+	a.ErrorIs(processor.UpdateOptions(shuttle.WithMaxMessagesPerSecond(-1)), shuttle.ErrInvalidOptions)
+}
+
+func TestProcessor_HandleTimeout(t *testing.T) {
+	a := require.New(t)
+	rcv := &fakeReceiver{fakeSettler: &fakeSettler{}, SetupReceivedMessages: messagesChannel(1), SetupMaxReceiveCalls: 100}
+	timedOut := make(chan bool, 1)
+	processor := shuttle.NewProcessorWithOptions(rcv,
+		func(ctx context.Context, settler shuttle.MessageSettler, message *azservicebus.ReceivedMessage) {
+			<-ctx.Done()
+			timedOut <- shuttle.IsHandleTimeout(ctx)
+		}, shuttle.WithHandleTimeout(10*time.Millisecond), shuttle.WithReceiveInterval(time.Hour))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = processor.Start(ctx) }()
+	select {
+	case isTimeout := <-timedOut:
+		a.True(isTimeout)
+	case <-time.After(time.Second):
+		a.Fail("the handle timeout should cancel the message context")
+	}
+}
+
+func TestProcessor_UpdateOptionsWhileRunning(t *testing.T) {
+	a := require.New(t)
+	rcv := &fakeReceiver{
+		fakeSettler:           &fakeSettler{},
+		SetupReceivedMessages: messagesChannel(20),
+		SetupMaxReceiveCalls:  10,
+	}
+	close(rcv.SetupReceivedMessages)
+	processor := shuttle.NewProcessorWithOptions(rcv, MyHandler(50*time.Millisecond),
+		shuttle.WithMaxConcurrency(1), shuttle.WithReceiveInterval(10*time.Millisecond))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	done := make(chan error)
+	go func() { done <- processor.Start(ctx) }()
+	time.Sleep(20 * time.Millisecond)
+	a.NoError(processor.UpdateOptions(shuttle.WithMaxConcurrency(5)))
+	a.Error(<-done, "expect to exit with error because we consumed all configured messages")
+	a.Equal(1, rcv.ReceiveCalls[0])
+	maxReceived := 0
+	for _, n := range rcv.ReceiveCalls {
+		if n > maxReceived {
+			maxReceived = n
+		}
+	}
+	a.Greater(maxReceived, 1, "the processor should receive more messages after the max concurrency is raised")
+	a.LessOrEqual(maxReceived, 5)
+}
+
+func TestProcessor_StrictOrdering(t *testing.T) {
+	a := require.New(t)
+	messages := make(chan *azservicebus.ReceivedMessage, 5)
+	for i := int64(0); i < 5; i++ {
+		messages <- &azservicebus.ReceivedMessage{SequenceNumber: to.Ptr(i)}
+	}
+	close(messages)
+	rcv := &fakeReceiver{
+		fakeSettler:           &fakeSettler{},
+		SetupReceivedMessages: messages,
+		SetupMaxReceiveCalls:  6,
+	}
+	var handled []int64
+	processor := shuttle.NewProcessorWithOptions(rcv, func(ctx context.Context, settler shuttle.MessageSettler, message *azservicebus.ReceivedMessage) {
+		// handlers never overlap in strict ordering, no need to synchronize
+		handled = append(handled, *message.SequenceNumber)
+		time.Sleep(5 * time.Millisecond)
+		_ = settler.CompleteMessage(ctx, message, nil)
+	}, shuttle.WithStrictOrdering(), shuttle.WithReceiveInterval(time.Millisecond))
+	a.Equal(1, processor.Options().MaxConcurrency)
+	a.ErrorIs(processor.UpdateOptions(shuttle.WithMaxConcurrency(2)), shuttle.ErrInvalidOptions)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	a.EqualError(processor.Start(ctx), "max receive calls exceeded")
+	for _, n := range rcv.ReceiveCalls {
+		a.Equal(1, n, "strict ordering should receive one message at a time")
+	}
+	a.Eventually(func() bool { return rcv.fakeSettler.CompleteCalled.Load() == 5 }, time.Second, 5*time.Millisecond)
+	a.Equal([]int64{0, 1, 2, 3, 4}, handled)
+}
+
+func TestProcessor_StrictOrderingRejectsConcurrency(t *testing.T) {
+	a := require.New(t)
+	for _, options := range [][]shuttle.ProcessorOption{
+		{shuttle.WithMaxConcurrency(5), shuttle.WithStrictOrdering()},
+		{shuttle.WithStrictOrdering(), shuttle.WithMaxConcurrency(5)},
+	} {
+		rcv := &fakeReceiver{fakeSettler: &fakeSettler{}}
+		processor := shuttle.NewProcessorWithOptions(rcv, MyHandler(0), options...)
+		a.Equal(5, processor.Options().MaxConcurrency, "the conflicting MaxConcurrency is not silently overridden")
+		err := processor.Start(context.Background())
+		var optionErr *shuttle.OptionError
+		a.ErrorAs(err, &optionErr)
+		a.Equal("MaxConcurrency", optionErr.Option)
+		a.Empty(rcv.ReceiveCalls)
+	}
+}
+
+func TestProcessor_SharedConcurrencyLimiter(t *testing.T) {
+	a := require.New(t)
+	limiter := shuttle.NewConcurrencyLimiter(2)
+	var inFlight, maxInFlight, handled atomic.Int32
+	handler := func(ctx context.Context, settler shuttle.MessageSettler, message *azservicebus.ReceivedMessage) {
+		current := inFlight.Add(1)
+		for {
+			max := maxInFlight.Load()
+			if current <= max || maxInFlight.CompareAndSwap(max, current) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		inFlight.Add(-1)
+		handled.Add(1)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for i := 0; i < 2; i++ {
+		rcv := &fakeReceiver{
+			fakeSettler:           &fakeSettler{},
+			SetupReceivedMessages: messagesChannel(5),
+			SetupMaxReceiveCalls:  1000,
+		}
+		processor := shuttle.NewProcessorWithOptions(rcv, handler,
+			shuttle.WithMaxConcurrency(5),
+			shuttle.WithReceiveInterval(time.Millisecond),
+			shuttle.WithConcurrencyLimiter(limiter))
+		go func() { _ = processor.Start(ctx) }()
+	}
+	a.Eventually(func() bool { return handled.Load() == 10 }, 5*time.Second, 5*time.Millisecond)
+	a.LessOrEqual(maxInFlight.Load(), int32(2), "the processors should not exceed the shared limit")
+	a.Eventually(func() bool { return limiter.InUse() == 0 }, time.Second, 5*time.Millisecond)
+}
+
 func TestProcessorStart_ContextCanceledAfterStart(t *testing.T) {
 	messages := make(chan *azservicebus.ReceivedMessage, 3)
 	messages <- &azservicebus.ReceivedMessage{}
@@ -193,6 +402,38 @@ func TestProcessorStart_ReceiveDelta(t *testing.T) {
 	a.Equal(5, rcv.ReceiveCalls[1], "the processor should request 5 (delta)")
 }
 
+func TestProcessorStart_LabelsHandlerGoroutine(t *testing.T) {
+	g := NewWithT(t)
+	messages := make(chan *azservicebus.ReceivedMessage, 1)
+	messages <- &azservicebus.ReceivedMessage{ApplicationProperties: map[string]any{"type": "OrderCreated"}}
+	close(messages)
+	rcv := &fakeReceiver{
+		fakeSettler:           &fakeSettler{},
+		SetupReceivedMessages: messages,
+		SetupMaxReceiveCalls:  2,
+	}
+	labels := make(chan map[string]string, 1)
+	handler := shuttle.HandlerFunc(func(ctx context.Context, settler shuttle.MessageSettler, message *azservicebus.ReceivedMessage) {
+		found := map[string]string{}
+		pprof.ForLabels(ctx, func(key, value string) bool {
+			found[key] = value
+			return true
+		})
+		labels <- found
+	})
+	processor := shuttle.NewProcessor(rcv, handler, &shuttle.ProcessorOptions{
+		MaxConcurrency:  1,
+		EntityName:      "topic-a/sub-a",
+		ReceiveInterval: to.Ptr(10 * time.Millisecond),
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+	go func() { _ = processor.Start(ctx) }()
+	g.Eventually(labels).Should(Receive(And(
+		HaveKeyWithValue("goshuttle.entity", "topic-a/sub-a"),
+		HaveKeyWithValue("goshuttle.messageType", "OrderCreated"))))
+}
+
 func messagesChannel(messageCount int) chan *azservicebus.ReceivedMessage {
 	messages := make(chan *azservicebus.ReceivedMessage, messageCount)
 	for i := 0; i < messageCount; i++ {
@@ -0,0 +1,127 @@
+package shuttle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+)
+
+// ErrSequenceNumberNotFound is returned by a ProjectionStore when no sequence number is recorded for a key.
+var ErrSequenceNumberNotFound = errors.New("sequence number not found")
+
+// ProjectionStore records the highest sequence number processed per entity and partition.
+// Store it next to the projection, ideally in the same database, so that both survive the same failures.
+type ProjectionStore interface {
+	// LastSequenceNumber returns ErrSequenceNumberNotFound when no sequence number is recorded for the key.
+	LastSequenceNumber(ctx context.Context, key string) (int64, error)
+	SaveSequenceNumber(ctx context.Context, key string, sequenceNumber int64) error
+}
+
+var _ ProjectionStore = &InMemoryProjectionStore{}
+
+// InMemoryProjectionStore is a ProjectionStore that keeps the sequence numbers in memory.
+// The sequence numbers are lost when the process restarts.
+type InMemoryProjectionStore struct {
+	mu              sync.Mutex
+	sequenceNumbers map[string]int64
+}
+
+// NewInMemoryProjectionStore creates an empty InMemoryProjectionStore.
+func NewInMemoryProjectionStore() *InMemoryProjectionStore {
+	return &InMemoryProjectionStore{sequenceNumbers: map[string]int64{}}
+}
+
+func (s *InMemoryProjectionStore) LastSequenceNumber(_ context.Context, key string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sequenceNumber, ok := s.sequenceNumbers[key]
+	if !ok {
+		return 0, ErrSequenceNumberNotFound
+	}
+	return sequenceNumber, nil
+}
+
+func (s *InMemoryProjectionStore) SaveSequenceNumber(_ context.Context, key string, sequenceNumber int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if sequenceNumber > s.sequenceNumbers[key] {
+		s.sequenceNumbers[key] = sequenceNumber
+	}
+	return nil
+}
+
+// SequencePartition returns the partition of a message from its sequence number.
+// The topmost 16 bits of the sequence numbers of partitioned entities hold the partition, and are 0 otherwise.
+func SequencePartition(sequenceNumber int64) int64 {
+	return sequenceNumber >> 48
+}
+
+// ProjectionKey is the key of the ProjectionStore for the entity and the partition of the sequence number.
+func ProjectionKey(entity string, sequenceNumber int64) string {
+	return fmt.Sprintf("%s/%d", entity, SequencePartition(sequenceNumber))
+}
+
+// NewProjectionHandler is a middleware giving exactly-once semantics to idempotent projections without a dedup store.
+// It completes without calling next the messages at or below the highest sequence number processed for the entity
+// and partition, and records the sequence number of the messages completed by next.
+//
+// The sequence numbers only increase within a partition, so the messages must be handled in order, and settled
+// before the next one is received: the processor requires WithStrictOrdering, which receives one message at a time
+// without prefetching, or sessions. A message completed after a lower one that is not settled yet makes it skipped.
+//
+// For the same reason, next must complete or dead-letter the messages, and never abandon or defer them:
+// a message abandoned, deferred, or which lock is lost, is redelivered after the following message is completed,
+// and is then completed without being projected. Dead-letter the messages that cannot be projected, and use
+// NewLockRenewalHandler so that the lock is not lost while the message is projected.
+// The messages without a sequence number are passed to next unchecked.
+func NewProjectionHandler(entity string, store ProjectionStore, next Handler) HandlerFunc {
+	return func(ctx context.Context, settler MessageSettler, message *azservicebus.ReceivedMessage) {
+		if message.SequenceNumber == nil {
+			next.Handle(ctx, settler, message)
+			return
+		}
+		sequenceNumber := *message.SequenceNumber
+		key := ProjectionKey(entity, sequenceNumber)
+		last, err := store.LastSequenceNumber(ctx, key)
+		switch {
+		case errors.Is(err, ErrSequenceNumberNotFound):
+		case err != nil:
+			// abandon rather than risk projecting the message twice
+			log(ctx, fmt.Sprintf("failed to get last sequence number of %s: %s", key, err))
+			if err := settler.AbandonMessage(ctx, message, nil); err != nil {
+				log(ctx, fmt.Sprintf("failed to abandon message %s: %s", message.MessageID, err))
+			}
+			return
+		case sequenceNumber <= last:
+			log(ctx, fmt.Sprintf("skipping message %s: sequence number %d already projected up to %d", message.MessageID, sequenceNumber, last))
+			if err := settler.CompleteMessage(ctx, message, nil); err != nil {
+				log(ctx, fmt.Sprintf("failed to complete message %s: %s", message.MessageID, err))
+			}
+			return
+		}
+		next.Handle(ctx, &projectionSettler{MessageSettler: settler, store: store, key: key}, message)
+	}
+}
+
+// projectionSettler records the sequence number of the completed messages.
+type projectionSettler struct {
+	MessageSettler
+	store ProjectionStore
+	key   string
+}
+
+// Unwrap returns the wrapped MessageSettler, for UnwrapSettler.
+func (s *projectionSettler) Unwrap() MessageSettler {
+	return s.MessageSettler
+}
+
+func (s *projectionSettler) CompleteMessage(ctx context.Context, message *azservicebus.ReceivedMessage, options *azservicebus.CompleteMessageOptions) error {
+	// recorded before the completion, so that a message projected but not completed is skipped on redelivery
+	if err := s.store.SaveSequenceNumber(ctx, s.key, *message.SequenceNumber); err != nil {
+		return fmt.Errorf("failed to save sequence number of %s: %w", s.key, err)
+	}
+	return s.MessageSettler.CompleteMessage(ctx, message, options)
+}
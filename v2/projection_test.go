@@ -0,0 +1,75 @@
+package shuttle
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	. "github.com/onsi/gomega"
+)
+
+func TestProjectionHandler(t *testing.T) {
+	g := NewWithT(t)
+	store := NewInMemoryProjectionStore()
+	var projected []int64
+	handler := NewProjectionHandler("orders", store, HandlerFunc(func(ctx context.Context, settler MessageSettler, message *azservicebus.ReceivedMessage) {
+		projected = append(projected, *message.SequenceNumber)
+		g.Expect(settler.CompleteMessage(ctx, message, nil)).To(Succeed())
+	}))
+	partition1 := int64(1) << 48
+	for _, sequenceNumber := range []int64{1, 2, 2, 1, 3, partition1 + 1, partition1 + 1} {
+		settler := &fakeSettler{}
+		handler(context.Background(), settler, &azservicebus.ReceivedMessage{SequenceNumber: to.Ptr(sequenceNumber)})
+		g.Expect(settler.completed).To(BeTrue())
+	}
+	g.Expect(projected).To(Equal([]int64{1, 2, 3, partition1 + 1}))
+
+	last, err := store.LastSequenceNumber(context.Background(), ProjectionKey("orders", 3))
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(last).To(Equal(int64(3)))
+	g.Expect(ProjectionKey("orders", partition1+1)).To(Equal("orders/1"))
+}
+
+func TestProjectionHandler_Unsequenced(t *testing.T) {
+	g := NewWithT(t)
+	called := false
+	handler := NewProjectionHandler("orders", NewInMemoryProjectionStore(), HandlerFunc(func(ctx context.Context, settler MessageSettler, message *azservicebus.ReceivedMessage) {
+		called = true
+	}))
+	handler(context.Background(), &fakeSettler{}, &azservicebus.ReceivedMessage{})
+	g.Expect(called).To(BeTrue())
+}
+
+type failingProjectionStore struct {
+	loadErr, saveErr error
+}
+
+func (s *failingProjectionStore) LastSequenceNumber(_ context.Context, _ string) (int64, error) {
+	return 0, s.loadErr
+}
+
+func (s *failingProjectionStore) SaveSequenceNumber(_ context.Context, _ string, _ int64) error {
+	return s.saveErr
+}
+
+func TestProjectionHandler_StoreFailures(t *testing.T) {
+	g := NewWithT(t)
+	store := &failingProjectionStore{loadErr: errors.New("connection refused")}
+	var completeErr error
+	handler := NewProjectionHandler("orders", store, HandlerFunc(func(ctx context.Context, settler MessageSettler, message *azservicebus.ReceivedMessage) {
+		completeErr = settler.CompleteMessage(ctx, message, nil)
+	}))
+
+	settler := &fakeSettler{}
+	handler(context.Background(), settler, &azservicebus.ReceivedMessage{SequenceNumber: to.Ptr(int64(1))})
+	g.Expect(settler.abandoned).To(BeTrue())
+
+	store.loadErr = ErrSequenceNumberNotFound
+	store.saveErr = errors.New("connection refused")
+	settler = &fakeSettler{}
+	handler(context.Background(), settler, &azservicebus.ReceivedMessage{SequenceNumber: to.Ptr(int64(1))})
+	g.Expect(completeErr).To(MatchError(ContainSubstring("failed to save sequence number")))
+	g.Expect(settler.completed).To(BeFalse())
+}
@@ -0,0 +1,152 @@
+package shuttle
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+)
+
+// Reasons of the messages dead-lettered because their payload cannot be handled.
+const (
+	// DeadLetterReasonUnmarshalFailed is set by NewTypedHandler when the body cannot be decoded.
+	DeadLetterReasonUnmarshalFailed = "UnmarshalFailed"
+	// DeadLetterReasonUpcastFailed is set by NewUpcastHandler when the message cannot be upcast.
+	DeadLetterReasonUpcastFailed = "UpcastFailed"
+	// DeadLetterReasonValidationFailed is set by NewTypedHandler when the decoded body fails its validation.
+	DeadLetterReasonValidationFailed = "ValidationFailed"
+)
+
+const (
+	// maxQuarantineMetadataSize is the limit of the blob storage on the total size of the metadata names and values.
+	maxQuarantineMetadataSize = 8 * 1024
+	// maxQuarantineErrorDescriptionLength leaves most of the metadata to the application properties.
+	maxQuarantineErrorDescriptionLength = 1024
+	// quarantinePropertiesOmitted replaces the application properties which do not fit in the metadata.
+	quarantinePropertiesOmitted = "omitted: too large for the metadata"
+)
+
+// Quarantine stores the payloads of the poison messages, such as a blob container.
+type Quarantine interface {
+	// Upload stores the body under the name, with the metadata, and returns the URL of the stored payload.
+	Upload(ctx context.Context, name string, body []byte, metadata map[string]string) (string, error)
+}
+
+// QuarantineOptions configures NewQuarantineHandler.
+type QuarantineOptions struct {
+	// Reasons are the dead-letter reasons of the messages to quarantine.
+	// Defaults to DeadLetterReasonUnmarshalFailed, DeadLetterReasonUpcastFailed and DeadLetterReasonValidationFailed.
+	Reasons []string
+	// Name returns the name of the payload of the message.
+	// Defaults to the entity, the enqueued date and the message id, such as orders/2024/01/31/<message id>.
+	Name func(ctx context.Context, message *azservicebus.ReceivedMessage) string
+	// Redactor masks the application properties copied to the metadata. Defaults to DefaultRedactor.
+	// The body is uploaded as-is, for the investigation of the poison message.
+	Redactor Redactor
+}
+
+// NewQuarantineHandler is a middleware that uploads the body and the metadata of the messages dead-lettered by next
+// with one of the Reasons, and adds the URL of the upload to the dead-letter error description.
+// The payload outlives the dead-letter message, which expires, and can be inspected with the usual storage tools.
+// The message is dead-lettered without the URL when the upload fails.
+func NewQuarantineHandler(quarantine Quarantine, options *QuarantineOptions, next Handler) HandlerFunc {
+	opts := QuarantineOptions{}
+	if options != nil {
+		opts = *options
+	}
+	if len(opts.Reasons) == 0 {
+		opts.Reasons = []string{DeadLetterReasonUnmarshalFailed, DeadLetterReasonUpcastFailed, DeadLetterReasonValidationFailed}
+	}
+	if opts.Name == nil {
+		opts.Name = quarantineName
+	}
+	if opts.Redactor == nil {
+		opts.Redactor = DefaultRedactor
+	}
+	return func(ctx context.Context, settler MessageSettler, message *azservicebus.ReceivedMessage) {
+		next.Handle(ctx, &quarantineSettler{MessageSettler: settler, quarantine: quarantine, options: opts}, message)
+	}
+}
+
+type quarantineSettler struct {
+	MessageSettler
+	quarantine Quarantine
+	options    QuarantineOptions
+}
+
+// Unwrap returns the wrapped MessageSettler, for UnwrapSettler.
+func (s *quarantineSettler) Unwrap() MessageSettler {
+	return s.MessageSettler
+}
+
+func (s *quarantineSettler) DeadLetterMessage(ctx context.Context, message *azservicebus.ReceivedMessage, options *azservicebus.DeadLetterOptions) error {
+	if options == nil || options.Reason == nil || !slices.Contains(s.options.Reasons, *options.Reason) {
+		return s.MessageSettler.DeadLetterMessage(ctx, message, options)
+	}
+	url, err := s.quarantine.Upload(ctx, s.options.Name(ctx, message), message.Body, quarantineMetadata(message, options, s.options.Redactor))
+	if err != nil {
+		log(ctx, fmt.Sprintf("failed to quarantine message %s: %s", message.MessageID, err))
+		return s.MessageSettler.DeadLetterMessage(ctx, message, options)
+	}
+	description := "payload quarantined at " + url
+	if options.ErrorDescription != nil && *options.ErrorDescription != "" {
+		description = *options.ErrorDescription + "; " + description
+	}
+	quarantined := *options
+	quarantined.ErrorDescription = &description
+	return s.MessageSettler.DeadLetterMessage(ctx, message, &quarantined)
+}
+
+func quarantineName(ctx context.Context, message *azservicebus.ReceivedMessage) string {
+	enqueued := time.Now()
+	if message.EnqueuedTime != nil {
+		enqueued = *message.EnqueuedTime
+	}
+	name := enqueued.UTC().Format("2006/01/02") + "/" + message.MessageID
+	if origin, ok := MessageOriginFromContext(ctx); ok && origin.Entity != "" {
+		name = origin.Entity + "/" + name
+	}
+	return name
+}
+
+// quarantineMetadata returns the properties of the message needed to investigate its payload,
+// within the size limit of the blob metadata.
+func quarantineMetadata(message *azservicebus.ReceivedMessage, options *azservicebus.DeadLetterOptions, redactor Redactor) map[string]string {
+	metadata := map[string]string{
+		"messageId":        message.MessageID,
+		"deliveryCount":    strconv.FormatUint(uint64(message.DeliveryCount), 10),
+		"deadLetterReason": *options.Reason,
+	}
+	if options.ErrorDescription != nil {
+		metadata["errorDescription"] = truncateString(*options.ErrorDescription, maxQuarantineErrorDescriptionLength)
+	}
+	if message.ContentType != nil {
+		metadata["contentType"] = *message.ContentType
+	}
+	if message.CorrelationID != nil {
+		metadata["correlationId"] = *message.CorrelationID
+	}
+	if message.SequenceNumber != nil {
+		metadata["sequenceNumber"] = strconv.FormatInt(*message.SequenceNumber, 10)
+	}
+	if message.EnqueuedTime != nil {
+		metadata["enqueuedTime"] = message.EnqueuedTime.UTC().Format(time.RFC3339)
+	}
+	if len(message.ApplicationProperties) > 0 {
+		if properties, err := json.Marshal(redactor.RedactProperties(message.ApplicationProperties)); err == nil {
+			size := len("applicationProperties") + len(properties)
+			for name, value := range metadata {
+				size += len(name) + len(value)
+			}
+			metadata["applicationProperties"] = string(properties)
+			if size > maxQuarantineMetadataSize {
+				metadata["applicationProperties"] = quarantinePropertiesOmitted
+			}
+		}
+	}
+	return metadata
+}
@@ -0,0 +1,111 @@
+package shuttle
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	. "github.com/onsi/gomega"
+)
+
+type fakeQuarantine struct {
+	name     string
+	body     []byte
+	metadata map[string]string
+	err      error
+}
+
+func (q *fakeQuarantine) Upload(_ context.Context, name string, body []byte, metadata map[string]string) (string, error) {
+	if q.err != nil {
+		return "", q.err
+	}
+	q.name, q.body, q.metadata = name, body, metadata
+	return "https://account.blob.core.windows.net/quarantine/" + name, nil
+}
+
+func deadLetteringHandler(reason string) HandlerFunc {
+	return func(ctx context.Context, settler MessageSettler, message *azservicebus.ReceivedMessage) {
+		_ = settler.DeadLetterMessage(ctx, message, &azservicebus.DeadLetterOptions{
+			Reason:           to.Ptr(reason),
+			ErrorDescription: to.Ptr("invalid character"),
+		})
+	}
+}
+
+func TestQuarantineHandler_UploadsPoisonMessages(t *testing.T) {
+	g := NewWithT(t)
+	quarantine := &fakeQuarantine{}
+	settler := &fakeSettler{}
+	message := &azservicebus.ReceivedMessage{
+		MessageID:             "poison",
+		Body:                  []byte("{not json"),
+		ContentType:           to.Ptr("application/json"),
+		DeliveryCount:         1,
+		EnqueuedTime:          to.Ptr(time.Date(2024, 1, 31, 12, 0, 0, 0, time.UTC)),
+		ApplicationProperties: map[string]any{"type": "Order"},
+	}
+	ctx := ContextWithMessageOrigin(context.Background(), MessageOrigin{Entity: "orders"})
+	NewQuarantineHandler(quarantine, nil, deadLetteringHandler(DeadLetterReasonUnmarshalFailed)).Handle(ctx, settler, message)
+
+	g.Expect(quarantine.name).To(Equal("orders/2024/01/31/poison"))
+	g.Expect(quarantine.body).To(Equal(message.Body))
+	g.Expect(quarantine.metadata).To(HaveKeyWithValue("deadLetterReason", DeadLetterReasonUnmarshalFailed))
+	g.Expect(quarantine.metadata).To(HaveKeyWithValue("contentType", "application/json"))
+	g.Expect(quarantine.metadata).To(HaveKeyWithValue("applicationProperties", `{"type":"Order"}`))
+	g.Expect(settler.deadlettered).To(BeTrue())
+	g.Expect(*settler.deadletterOptions.ErrorDescription).To(Equal(
+		"invalid character; payload quarantined at https://account.blob.core.windows.net/quarantine/orders/2024/01/31/poison"))
+}
+
+func TestQuarantineHandler_IgnoresOtherReasons(t *testing.T) {
+	g := NewWithT(t)
+	quarantine := &fakeQuarantine{}
+	settler := &fakeSettler{}
+	NewQuarantineHandler(quarantine, nil, deadLetteringHandler("MessageStale")).
+		Handle(context.Background(), settler, &azservicebus.ReceivedMessage{MessageID: "stale"})
+	g.Expect(quarantine.name).To(BeEmpty())
+	g.Expect(*settler.deadletterOptions.ErrorDescription).To(Equal("invalid character"))
+}
+
+func TestQuarantineHandler_DeadLettersWhenUploadFails(t *testing.T) {
+	g := NewWithT(t)
+	settler := &fakeSettler{}
+	NewQuarantineHandler(&fakeQuarantine{err: errors.New("forbidden")}, nil, deadLetteringHandler(DeadLetterReasonValidationFailed)).
+		Handle(context.Background(), settler, &azservicebus.ReceivedMessage{MessageID: "poison"})
+	g.Expect(settler.deadlettered).To(BeTrue())
+	g.Expect(*settler.deadletterOptions.ErrorDescription).To(Equal("invalid character"))
+}
+
+func TestQuarantineHandler_RedactsAndCapsTheMetadata(t *testing.T) {
+	g := NewWithT(t)
+	quarantine := &fakeQuarantine{}
+	message := &azservicebus.ReceivedMessage{
+		MessageID:             "poison",
+		ApplicationProperties: map[string]any{"type": "Order", "customerEmail": "jane@contoso.com"},
+	}
+	NewQuarantineHandler(quarantine, nil, deadLetteringHandler(DeadLetterReasonUnmarshalFailed)).
+		Handle(context.Background(), &fakeSettler{}, message)
+	g.Expect(quarantine.metadata).To(HaveKeyWithValue("applicationProperties", `{"customerEmail":"[REDACTED]","type":"Order"}`))
+
+	message.ApplicationProperties = map[string]any{"large": strings.Repeat("a", maxQuarantineMetadataSize)}
+	handler := NewQuarantineHandler(quarantine, nil, HandlerFunc(func(ctx context.Context, settler MessageSettler, message *azservicebus.ReceivedMessage) {
+		_ = settler.DeadLetterMessage(ctx, message, &azservicebus.DeadLetterOptions{
+			Reason:           to.Ptr(DeadLetterReasonValidationFailed),
+			ErrorDescription: to.Ptr(strings.Repeat("é", maxQuarantineErrorDescriptionLength)),
+		})
+	}))
+	handler.Handle(context.Background(), &fakeSettler{}, message)
+	g.Expect(quarantine.metadata).To(HaveKeyWithValue("applicationProperties", quarantinePropertiesOmitted))
+	g.Expect(quarantine.metadata["errorDescription"]).To(HaveLen(maxQuarantineErrorDescriptionLength))
+	g.Expect(utf8.ValidString(quarantine.metadata["errorDescription"])).To(BeTrue())
+	size := 0
+	for name, value := range quarantine.metadata {
+		size += len(name) + len(value)
+	}
+	g.Expect(size).To(BeNumerically("<=", maxQuarantineMetadataSize))
+}
@@ -0,0 +1,6 @@
+//go:build race
+
+package shuttle
+
+// raceEnabled enables the runtime checks of the builds with the race detector, see optionsGuard.
+const raceEnabled = true
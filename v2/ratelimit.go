@@ -0,0 +1,47 @@
+package shuttle
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// receiveRateLimiter is a token bucket limiting the messages received by the processor to a rate per second.
+// The bucket holds up to one second of messages, and starts full. Its rate is read from the options on each receive,
+// so that it follows the updates of MaxMessagesPerSecond.
+type receiveRateLimiter struct {
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+	rate   float64
+}
+
+// allowed returns the number of messages that can be received at now, up to limit. It returns limit when rate is 0.
+func (l *receiveRateLimiter) allowed(now time.Time, rate float64, limit int) int {
+	if rate <= 0 {
+		return limit
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	burst := math.Max(rate, 1)
+	if l.last.IsZero() || l.rate != rate {
+		// a new or updated rate starts with a full bucket
+		l.tokens, l.rate = burst, rate
+	} else {
+		l.tokens = math.Min(burst, l.tokens+now.Sub(l.last).Seconds()*rate)
+	}
+	l.last = now
+	if allowed := int(l.tokens); allowed < limit {
+		return allowed
+	}
+	return limit
+}
+
+// take consumes the tokens of the received messages.
+func (l *receiveRateLimiter) take(count int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.rate > 0 {
+		l.tokens -= float64(count)
+	}
+}
@@ -0,0 +1,25 @@
+package shuttle
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestReceiveRateLimiter(t *testing.T) {
+	g := NewWithT(t)
+	limiter := &receiveRateLimiter{}
+	now := time.Date(2024, 1, 29, 10, 0, 0, 0, time.UTC)
+	g.Expect(limiter.allowed(now, 0, 10)).To(Equal(10), "no limit")
+
+	g.Expect(limiter.allowed(now, 5, 10)).To(Equal(5), "the bucket starts with one second of messages")
+	limiter.take(5)
+	g.Expect(limiter.allowed(now, 5, 10)).To(Equal(0))
+	g.Expect(limiter.allowed(now.Add(400*time.Millisecond), 5, 10)).To(Equal(2))
+	g.Expect(limiter.allowed(now.Add(10*time.Second), 5, 3)).To(Equal(3), "capped by the concurrency")
+	g.Expect(limiter.allowed(now.Add(10*time.Second), 5, 10)).To(Equal(5), "the bucket holds one second of messages")
+
+	limiter.take(5)
+	g.Expect(limiter.allowed(now.Add(10*time.Second), 20, 30)).To(Equal(20), "an updated rate starts with a full bucket")
+}
@@ -0,0 +1,45 @@
+package shuttle
+
+import (
+	"context"
+	"fmt"
+)
+
+// Started returns a channel closed once the receiver is attached to the entity.
+// The links of an *azservicebus.Receiver are attached by peeking a message the first time Start is called,
+// so that an idle entity does not delay the readiness. Other receivers, and the receivers which peek failed,
+// are attached by their first receive call.
+func (p *Processor) Started() <-chan struct{} {
+	return p.started
+}
+
+// Ready returns true when the receiver is attached and Start has not returned, for example to gate a readiness probe.
+func (p *Processor) Ready() bool {
+	select {
+	case <-p.started:
+		return p.running.Load()
+	default:
+		return false
+	}
+}
+
+// attach attaches the links of the receiver when it is a MessagePeeker, as peeking does not lock any message.
+// It only peeks once, as each peek moves the peek cursor of the receiver by one message.
+// A failed peek leaves the processor not ready until its first receive, which reports the error if it persists.
+func (p *Processor) attach(ctx context.Context) {
+	peeker, ok := p.currentReceiver().(MessagePeeker)
+	if !ok {
+		return
+	}
+	p.attachOnce.Do(func() {
+		if _, err := peeker.PeekMessages(ctx, 1, nil); err != nil {
+			log(ctx, fmt.Sprintf("failed to attach receiver, waiting for the first receive: %s", err))
+			return
+		}
+		p.markStarted()
+	})
+}
+
+func (p *Processor) markStarted() {
+	p.startedOnce.Do(func() { close(p.started) })
+}
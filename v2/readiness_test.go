@@ -0,0 +1,107 @@
+package shuttle
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	. "github.com/onsi/gomega"
+)
+
+// idleReceiver blocks the receive calls until the context is done, like a receiver on an idle entity.
+type idleReceiver struct {
+	fakeSettler
+	receiveErr error
+}
+
+func (r *idleReceiver) ReceiveMessages(ctx context.Context, _ int, _ *azservicebus.ReceiveMessagesOptions) ([]*azservicebus.ReceivedMessage, error) {
+	if r.receiveErr != nil {
+		return nil, r.receiveErr
+	}
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+// peekingReceiver attaches its links with PeekMessages, like *azservicebus.Receiver.
+type peekingReceiver struct {
+	idleReceiver
+	peekErr   error
+	peekCalls atomic.Int32
+}
+
+func (r *peekingReceiver) PeekMessages(_ context.Context, _ int, _ *azservicebus.PeekMessagesOptions) ([]*azservicebus.ReceivedMessage, error) {
+	r.peekCalls.Add(1)
+	return nil, r.peekErr
+}
+
+func noopHandler(context.Context, MessageSettler, *azservicebus.ReceivedMessage) {}
+
+func TestProcessor_StartedAfterPeek(t *testing.T) {
+	g := NewWithT(t)
+	p := NewProcessor(&peekingReceiver{}, noopHandler, &ProcessorOptions{MaxConcurrency: 1})
+	g.Expect(p.Ready()).To(BeFalse())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- p.Start(ctx) }()
+	g.Eventually(p.Started()).Should(BeClosed())
+	g.Expect(p.Ready()).To(BeTrue())
+
+	cancel()
+	g.Eventually(done).Should(Receive())
+	g.Expect(p.Ready()).To(BeFalse())
+}
+
+func TestProcessor_PeeksOnce(t *testing.T) {
+	g := NewWithT(t)
+	rcv := &peekingReceiver{}
+	p := NewProcessor(rcv, noopHandler, &ProcessorOptions{MaxConcurrency: 1})
+	for i := 0; i < 2; i++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error)
+		go func() { done <- p.Start(ctx) }()
+		g.Eventually(p.Ready).Should(BeTrue())
+		cancel()
+		g.Eventually(done).Should(Receive())
+	}
+	g.Expect(rcv.peekCalls.Load()).To(Equal(int32(1)))
+}
+
+func TestProcessor_PeekError(t *testing.T) {
+	g := NewWithT(t)
+	// the failed peek is not fatal, the processor is ready after its first receive
+	p := NewProcessor(&peekingReceiver{peekErr: errors.New("unauthorized")}, noopHandler, &ProcessorOptions{
+		MaxConcurrency:      1,
+		ReceiveInterval:     to.Ptr(time.Millisecond),
+		ReceiveStallTimeout: 20 * time.Millisecond,
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = p.Start(ctx) }()
+	g.Consistently(p.Started(), 10*time.Millisecond).ShouldNot(BeClosed())
+	g.Eventually(p.Started()).Should(BeClosed())
+}
+
+func TestProcessor_StartedAfterFirstReceive(t *testing.T) {
+	g := NewWithT(t)
+	// without peek, an idle entity delays the readiness until the watchdog returns the idle receive call
+	p := NewProcessor(&idleReceiver{}, noopHandler, &ProcessorOptions{
+		MaxConcurrency:      1,
+		ReceiveInterval:     to.Ptr(time.Millisecond),
+		ReceiveStallTimeout: 20 * time.Millisecond,
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = p.Start(ctx) }()
+	g.Consistently(p.Started(), 10*time.Millisecond).ShouldNot(BeClosed())
+	g.Eventually(p.Started()).Should(BeClosed())
+
+	// a failed receive does not start the processor
+	p = NewProcessor(&idleReceiver{receiveErr: errors.New("link detached")}, noopHandler, &ProcessorOptions{MaxConcurrency: 1})
+	g.Expect(p.Start(context.Background())).ToNot(Succeed())
+	g.Expect(p.Started()).ToNot(BeClosed())
+}
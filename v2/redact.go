@@ -0,0 +1,220 @@
+package shuttle
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+// RedactedValue replaces the values masked by the FieldRedactor, unless it has a Mask.
+const RedactedValue = "[REDACTED]"
+
+// Redactor masks the sensitive values of the messages, such as personal data, before their bodies and properties
+// are copied out of the entity by the capture, audit and quarantine middlewares.
+// The implementations must not modify the properties or the body they are passed.
+type Redactor interface {
+	// RedactProperties returns the application properties with their sensitive values masked.
+	RedactProperties(properties map[string]any) map[string]any
+	// RedactBody returns the body with its sensitive values masked.
+	RedactBody(contentType string, body []byte) []byte
+}
+
+var (
+	// DefaultRedactor masks the properties and the JSON body fields usually holding secrets and personal data.
+	// It is used by NewCaptureHandler, NewAuditHandler and NewAuditingSender unless WithRedactor is used,
+	// and by NewQuarantineHandler unless QuarantineOptions.Redactor is set.
+	DefaultRedactor Redactor = &FieldRedactor{Names: []string{
+		"password", "secret", "token", "authorization", "apikey", "credential",
+		"email", "phone", "ssn", "creditcard", "cardnumber", "iban",
+	}}
+	// NoRedaction leaves the messages as-is.
+	NoRedaction Redactor = noRedaction{}
+)
+
+// RedactionOption configures the Redactor of a middleware.
+type RedactionOption func(redactor *Redactor)
+
+// WithRedactor sets the Redactor of the middleware. Pass NoRedaction to copy the messages as-is.
+func WithRedactor(redactor Redactor) RedactionOption {
+	return func(r *Redactor) {
+		*r = redactor
+	}
+}
+
+func redactorFrom(options []RedactionOption) Redactor {
+	redactor := DefaultRedactor
+	for _, option := range options {
+		option(&redactor)
+	}
+	if redactor == nil {
+		return NoRedaction
+	}
+	return redactor
+}
+
+type noRedaction struct{}
+
+func (noRedaction) RedactProperties(properties map[string]any) map[string]any {
+	return properties
+}
+
+func (noRedaction) RedactBody(_ string, body []byte) []byte {
+	return body
+}
+
+// FieldRedactor masks the application properties and the fields of the JSON bodies by name, and the fields
+// of the JSON bodies by path.
+type FieldRedactor struct {
+	// Names mask the properties and the body fields, at any depth, which name contains one of them.
+	// The names are compared ignoring the case, the dashes and the underscores, so "apikey" matches "X-Api-Key".
+	Names []string
+	// Paths mask the body fields at the JSON paths, such as "$.customer.address" or "$.payments[*].iban".
+	// The segments are separated by dots, and "*" or "[*]" match all the fields of an object or the items of an array.
+	Paths []string
+	// Mask replaces the masked values. Defaults to RedactedValue.
+	Mask string
+	// RedactOtherBodies replaces the bodies that are not JSON with the Mask. By default, they are left as-is.
+	RedactOtherBodies bool
+}
+
+func (r *FieldRedactor) RedactProperties(properties map[string]any) map[string]any {
+	if len(properties) == 0 {
+		return properties
+	}
+	redacted := make(map[string]any, len(properties))
+	for name, value := range properties {
+		if r.sensitive(name) {
+			value = r.mask()
+		}
+		redacted[name] = value
+	}
+	return redacted
+}
+
+func (r *FieldRedactor) RedactBody(contentType string, body []byte) []byte {
+	if len(body) == 0 {
+		return body
+	}
+	var document any
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if !isJSONContentType(contentType) || decoder.Decode(&document) != nil || decoder.More() {
+		if r.RedactOtherBodies {
+			return []byte(r.mask())
+		}
+		return body
+	}
+	changed := r.redactNames(document)
+	for _, path := range r.Paths {
+		if r.redactPath(document, parsePath(path)) {
+			changed = true
+		}
+	}
+	if !changed {
+		return body
+	}
+	redacted, err := json.Marshal(document)
+	if err != nil {
+		return []byte(r.mask())
+	}
+	return redacted
+}
+
+func (r *FieldRedactor) mask() string {
+	if r.Mask == "" {
+		return RedactedValue
+	}
+	return r.Mask
+}
+
+func (r *FieldRedactor) sensitive(name string) bool {
+	normalized := normalizeFieldName(name)
+	for _, sensitive := range r.Names {
+		if s := normalizeFieldName(sensitive); s != "" && strings.Contains(normalized, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactNames masks the fields matching the Names in the decoded JSON value, and reports whether any was masked.
+func (r *FieldRedactor) redactNames(value any) bool {
+	changed := false
+	switch v := value.(type) {
+	case map[string]any:
+		for key, field := range v {
+			if r.sensitive(key) {
+				v[key] = r.mask()
+				changed = true
+			} else if r.redactNames(field) {
+				changed = true
+			}
+		}
+	case []any:
+		for _, item := range v {
+			if r.redactNames(item) {
+				changed = true
+			}
+		}
+	}
+	return changed
+}
+
+// redactPath masks the fields at the path in the decoded JSON value, and reports whether any was masked.
+func (r *FieldRedactor) redactPath(value any, path []string) bool {
+	if len(path) == 0 {
+		return false
+	}
+	segment, last := path[0], len(path) == 1
+	changed := false
+	switch v := value.(type) {
+	case map[string]any:
+		for key, field := range v {
+			if segment != "*" && segment != key {
+				continue
+			}
+			if last {
+				v[key] = r.mask()
+				changed = true
+			} else if r.redactPath(field, path[1:]) {
+				changed = true
+			}
+		}
+	case []any:
+		for i, item := range v {
+			if segment != "*" && segment != strconv.Itoa(i) {
+				continue
+			}
+			if last {
+				v[i] = r.mask()
+				changed = true
+			} else if r.redactPath(item, path[1:]) {
+				changed = true
+			}
+		}
+	}
+	return changed
+}
+
+// parsePath splits a JSON path such as $.items[*].card into its segments: items, *, card.
+func parsePath(path string) []string {
+	path = strings.TrimPrefix(strings.TrimPrefix(path, "$"), ".")
+	path = strings.NewReplacer("[", ".", "]", "").Replace(path)
+	var segments []string
+	for _, segment := range strings.Split(path, ".") {
+		if segment != "" {
+			segments = append(segments, segment)
+		}
+	}
+	return segments
+}
+
+func normalizeFieldName(name string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", "_", "").Replace(name))
+}
+
+// isJSONContentType reports whether the body may be JSON: the content type is not set, or is a JSON media type.
+func isJSONContentType(contentType string) bool {
+	return contentType == "" || strings.Contains(strings.ToLower(contentType), "json")
+}
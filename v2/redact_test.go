@@ -0,0 +1,94 @@
+package shuttle_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	"github.com/Azure/go-shuttle/v2"
+)
+
+func TestFieldRedactor_RedactProperties(t *testing.T) {
+	g := NewWithT(t)
+	redactor := &shuttle.FieldRedactor{Names: []string{"email", "api_key"}}
+	properties := map[string]any{"type": "OrderCreated", "customerEmail": "jane@contoso.com", "X-Api-Key": "secret", "attempt": 2}
+	redacted := redactor.RedactProperties(properties)
+	g.Expect(redacted).To(Equal(map[string]any{
+		"type":          "OrderCreated",
+		"customerEmail": shuttle.RedactedValue,
+		"X-Api-Key":     shuttle.RedactedValue,
+		"attempt":       2,
+	}))
+	g.Expect(properties).To(HaveKeyWithValue("customerEmail", "jane@contoso.com"), "the properties are not modified")
+	g.Expect(redactor.RedactProperties(nil)).To(BeNil())
+}
+
+func TestFieldRedactor_RedactBody(t *testing.T) {
+	testCases := []struct {
+		name        string
+		redactor    *shuttle.FieldRedactor
+		contentType string
+		body        string
+		expected    string
+	}{
+		{
+			name:     "names at any depth",
+			redactor: &shuttle.FieldRedactor{Names: []string{"email"}},
+			body:     `{"id":1,"customer":{"Email":"jane@contoso.com"},"contacts":[{"email":"joe@contoso.com"}]}`,
+			expected: `{"contacts":[{"email":"[REDACTED]"}],"customer":{"Email":"[REDACTED]"},"id":1}`,
+		},
+		{
+			name:        "paths",
+			redactor:    &shuttle.FieldRedactor{Paths: []string{"$.customer.address", "$.payments[*].iban", "lines.0"}, Mask: "***"},
+			contentType: "application/json; charset=utf-8",
+			body:        `{"customer":{"name":"Jane","address":{"city":"Paris"}},"payments":[{"iban":"FR76","amount":12.50}],"lines":["a","b"]}`,
+			expected:    `{"customer":{"address":"***","name":"Jane"},"lines":["***","b"],"payments":[{"amount":12.50,"iban":"***"}]}`,
+		},
+		{
+			name:     "nothing to redact keeps the body",
+			redactor: &shuttle.FieldRedactor{Names: []string{"email"}, Paths: []string{"$.missing.field"}},
+			body:     `{ "id": 1 }`,
+			expected: `{ "id": 1 }`,
+		},
+		{
+			name:        "other bodies are kept",
+			redactor:    &shuttle.FieldRedactor{Names: []string{"email"}},
+			contentType: "text/plain",
+			body:        `{"email":"jane@contoso.com"}`,
+			expected:    `{"email":"jane@contoso.com"}`,
+		},
+		{
+			name:     "invalid json",
+			redactor: &shuttle.FieldRedactor{Names: []string{"email"}},
+			body:     `email=jane@contoso.com`,
+			expected: `email=jane@contoso.com`,
+		},
+		{
+			name:        "other bodies redacted",
+			redactor:    &shuttle.FieldRedactor{RedactOtherBodies: true},
+			contentType: "application/octet-stream",
+			body:        `binary`,
+			expected:    shuttle.RedactedValue,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+			body := []byte(tc.body)
+			g.Expect(string(tc.redactor.RedactBody(tc.contentType, body))).To(Equal(tc.expected))
+			g.Expect(string(body)).To(Equal(tc.body), "the body is not modified")
+		})
+	}
+}
+
+func TestDefaultRedactor(t *testing.T) {
+	g := NewWithT(t)
+	redacted := shuttle.DefaultRedactor.RedactProperties(map[string]any{
+		"type":          "OrderCreated",
+		"Authorization": "Bearer abc",
+		"user_password": "hunter2",
+	})
+	g.Expect(redacted).To(HaveKeyWithValue("type", "OrderCreated"))
+	g.Expect(redacted).To(HaveKeyWithValue("Authorization", shuttle.RedactedValue))
+	g.Expect(redacted).To(HaveKeyWithValue("user_password", shuttle.RedactedValue))
+}
@@ -0,0 +1,277 @@
+// Package requestreply implements the responder side of request/reply over Service Bus.
+//
+// The requester sends a request with its ReplyTo, and ReplyToSessionID when the replies are session-based.
+// The responder handles the request and sends the reply with the request's MessageID as CorrelationID:
+//
+//	handler := requestreply.NewResponder(replySender, func(ctx context.Context, request *azservicebus.ReceivedMessage) (shuttle.MessageBody, error) {
+//		return computeReply(request)
+//	}, &requestreply.ResponderOptions{Store: requestreply.NewMemoryStore(time.Hour)})
+//
+// Requests sent with SetIdempotencyKey are handled once per key: a retried request gets the cached reply
+// instead of re-executing the handler.
+package requestreply
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+
+	"github.com/Azure/go-shuttle/v2"
+)
+
+const (
+	// IdempotencyKeyProperty is the application property holding the idempotency key of a request.
+	IdempotencyKeyProperty = "goshuttle-idempotency-key"
+	defaultClaimTimeout    = 5 * time.Minute
+)
+
+// ErrRequestInProgress is passed to ResponderOptions.OnError when a request is abandoned because another delivery
+// with the same idempotency key is being handled. The abandoned request gets the cached reply once redelivered.
+var ErrRequestInProgress = errors.New("a request with the same idempotency key is being handled")
+
+// SetIdempotencyKey sets the idempotency key of the request. Retries of the request must use the same key.
+func SetIdempotencyKey(key string) func(msg *azservicebus.Message) error {
+	return func(msg *azservicebus.Message) error {
+		if msg.ApplicationProperties == nil {
+			msg.ApplicationProperties = map[string]interface{}{}
+		}
+		msg.ApplicationProperties[IdempotencyKeyProperty] = key
+		return nil
+	}
+}
+
+// IdempotencyKey returns the idempotency key of the request, or false when it has none.
+func IdempotencyKey(request *azservicebus.ReceivedMessage) (string, bool) {
+	key, ok := request.ApplicationProperties[IdempotencyKeyProperty].(string)
+	return key, ok && key != ""
+}
+
+// Reply is a reply cached in the Store.
+type Reply struct {
+	Body                  []byte
+	ContentType           *string
+	ApplicationProperties map[string]interface{}
+}
+
+// Store caches the replies by idempotency key.
+// It is shared by the responder instances, for example on top of a database or a distributed cache.
+type Store interface {
+	// Get returns the reply cached for the key, or nil when there is none.
+	Get(ctx context.Context, key string) (*Reply, error)
+	// Claim atomically reserves the key for the caller until the reply is Put, the claim is released,
+	// or the timeout elapses. It returns false when the key is already claimed or has a reply,
+	// so that the concurrent deliveries with the same key do not both run the handler.
+	// It maps to an insert-if-absent with an expiry, such as SET NX PX on Redis.
+	Claim(ctx context.Context, key string, timeout time.Duration) (bool, error)
+	// Release removes the claim on the key when the handler failed, so that the retries can handle the request.
+	Release(ctx context.Context, key string) error
+	// Put caches the reply for the key, replacing its claim.
+	Put(ctx context.Context, key string, reply *Reply) error
+}
+
+// RequestHandler handles a request and returns the body of the reply, which is marshalled by the reply sender.
+type RequestHandler func(ctx context.Context, request *azservicebus.ReceivedMessage) (shuttle.MessageBody, error)
+
+// ResponderOptions configures the responder.
+type ResponderOptions struct {
+	// Store caches the replies of the requests with an idempotency key. Defaults to nil, disabling the idempotency.
+	Store Store
+	// ClaimTimeout is how long a request is claimed in the Store while it is handled. Defaults to 5 minutes.
+	// It must be longer than the handler, or a redelivery of the request may be handled concurrently.
+	ClaimTimeout time.Duration
+	// OnError is called with the errors of the responder, before the request is abandoned to be retried. Optional.
+	OnError func(ctx context.Context, request *azservicebus.ReceivedMessage, err error)
+}
+
+// NewResponder returns a handler sending the reply of the handler to the request's ReplyTo with the sender.
+// The request is completed once the reply is sent, and abandoned when the handler or the send fails.
+// With a Store, the requests with an idempotency key are handled once: the reply is cached before it is sent,
+// and the retries get the cached reply, including when the send of the reply failed.
+// The key is claimed in the Store while the request is handled: a delivery with the same key received meanwhile
+// is abandoned with ErrRequestInProgress, to get the cached reply once redelivered.
+func NewResponder(sender *shuttle.Sender, handler RequestHandler, options *ResponderOptions) shuttle.HandlerFunc {
+	opts := ResponderOptions{}
+	if options != nil {
+		opts = *options
+	}
+	if opts.ClaimTimeout <= 0 {
+		opts.ClaimTimeout = defaultClaimTimeout
+	}
+	return func(ctx context.Context, settler shuttle.MessageSettler, request *azservicebus.ReceivedMessage) {
+		reply, err := respond(ctx, sender, handler, opts, request)
+		if err == nil {
+			err = sender.SendAzMessage(ctx, replyMessage(request, reply))
+		}
+		if err != nil {
+			if opts.OnError != nil {
+				opts.OnError(ctx, request, err)
+			}
+			if err := settler.AbandonMessage(ctx, request, nil); err != nil && opts.OnError != nil {
+				opts.OnError(ctx, request, fmt.Errorf("failed to abandon request: %w", err))
+			}
+			return
+		}
+		if err := settler.CompleteMessage(ctx, request, nil); err != nil && opts.OnError != nil {
+			opts.OnError(ctx, request, fmt.Errorf("failed to complete request: %w", err))
+		}
+	}
+}
+
+// respond returns the cached reply of the request, or claims its key, handles it and caches the reply.
+func respond(ctx context.Context, sender *shuttle.Sender, handler RequestHandler, opts ResponderOptions, request *azservicebus.ReceivedMessage) (*Reply, error) {
+	key, idempotent := IdempotencyKey(request)
+	if !idempotent || opts.Store == nil {
+		return handle(ctx, sender, handler, request)
+	}
+	cached, err := opts.Store.Get(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get cached reply for idempotency key %s: %w", key, err)
+	}
+	if cached != nil {
+		return cached, nil
+	}
+	claimed, err := opts.Store.Claim(ctx, key, opts.ClaimTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim idempotency key %s: %w", key, err)
+	}
+	if !claimed {
+		// the reply may have been cached since the Get
+		if cached, err := opts.Store.Get(ctx, key); err == nil && cached != nil {
+			return cached, nil
+		}
+		return nil, fmt.Errorf("failed to handle request %s with idempotency key %s: %w", request.MessageID, key, ErrRequestInProgress)
+	}
+	reply, err := handle(ctx, sender, handler, request)
+	if err != nil {
+		if releaseErr := opts.Store.Release(ctx, key); releaseErr != nil {
+			return nil, errors.Join(err, fmt.Errorf("failed to release idempotency key %s: %w", key, releaseErr))
+		}
+		return nil, err
+	}
+	if err := opts.Store.Put(ctx, key, reply); err != nil {
+		return nil, fmt.Errorf("failed to cache reply for idempotency key %s: %w", key, err)
+	}
+	return reply, nil
+}
+
+// handle runs the handler and marshals its reply.
+func handle(ctx context.Context, sender *shuttle.Sender, handler RequestHandler, request *azservicebus.ReceivedMessage) (*Reply, error) {
+	body, err := handler(ctx, request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to handle request %s: %w", request.MessageID, err)
+	}
+	msg, err := sender.ToServiceBusMessage(ctx, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build reply to request %s: %w", request.MessageID, err)
+	}
+	return &Reply{Body: msg.Body, ContentType: msg.ContentType, ApplicationProperties: msg.ApplicationProperties}, nil
+}
+
+func replyMessage(request *azservicebus.ReceivedMessage, reply *Reply) *azservicebus.Message {
+	properties := make(map[string]interface{}, len(reply.ApplicationProperties))
+	for k, v := range reply.ApplicationProperties {
+		properties[k] = v
+	}
+	correlationID := request.MessageID
+	return &azservicebus.Message{
+		Body:                  reply.Body,
+		ContentType:           reply.ContentType,
+		ApplicationProperties: properties,
+		CorrelationID:         &correlationID,
+		SessionID:             request.ReplyToSessionID,
+		To:                    request.ReplyTo,
+	}
+}
+
+// memoryEntry is a cached reply, or a claim when reply is nil.
+type memoryEntry struct {
+	reply     *Reply
+	expiresAt time.Time
+}
+
+// MemoryStore is an in-memory Store, for a single responder instance or tests.
+type MemoryStore struct {
+	ttl time.Duration
+	now func() time.Time
+
+	mu        sync.Mutex
+	entries   map[string]memoryEntry
+	lastSweep time.Time
+}
+
+// NewMemoryStore creates a MemoryStore keeping the replies for ttl.
+// The expired entries are evicted when they are read, and by a sweep of all the entries at most once per ttl.
+func NewMemoryStore(ttl time.Duration) *MemoryStore {
+	return &MemoryStore{ttl: ttl, now: time.Now, entries: map[string]memoryEntry{}}
+}
+
+// Get returns the reply cached for the key, or nil when there is none, it expired, or the key is only claimed.
+func (s *MemoryStore) Get(_ context.Context, key string) (*Reply, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.entry(key)
+	if !ok {
+		return nil, nil
+	}
+	return entry.reply, nil
+}
+
+// Claim reserves the key for timeout, unless it is already claimed or has a reply.
+func (s *MemoryStore) Claim(_ context.Context, key string, timeout time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.entry(key); ok {
+		return false, nil
+	}
+	s.entries[key] = memoryEntry{expiresAt: s.now().Add(timeout)}
+	s.sweep()
+	return true, nil
+}
+
+// Release removes the claim on the key. The cached reply of the key is kept.
+func (s *MemoryStore) Release(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if entry, ok := s.entries[key]; ok && entry.reply == nil {
+		delete(s.entries, key)
+	}
+	return nil
+}
+
+// Put caches the reply for the key.
+func (s *MemoryStore) Put(_ context.Context, key string, reply *Reply) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries[key] = memoryEntry{reply: reply, expiresAt: s.now().Add(s.ttl)}
+	s.sweep()
+	return nil
+}
+
+// entry returns the entry of the key, evicting it when it expired. It must be called with mu held.
+func (s *MemoryStore) entry(key string) (memoryEntry, bool) {
+	entry, ok := s.entries[key]
+	if ok && !s.now().Before(entry.expiresAt) {
+		delete(s.entries, key)
+		return memoryEntry{}, false
+	}
+	return entry, ok
+}
+
+// sweep evicts the expired entries, at most once per ttl, so that the keys never read again do not accumulate.
+// It must be called with mu held.
+func (s *MemoryStore) sweep() {
+	now := s.now()
+	if now.Sub(s.lastSweep) < s.ttl {
+		return
+	}
+	s.lastSweep = now
+	for key, entry := range s.entries {
+		if !now.Before(entry.expiresAt) {
+			delete(s.entries, key)
+		}
+	}
+}
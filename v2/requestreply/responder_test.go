@@ -0,0 +1,193 @@
+package requestreply
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	. "github.com/onsi/gomega"
+
+	"github.com/Azure/go-shuttle/v2"
+	"github.com/Azure/go-shuttle/v2/shuttletest"
+)
+
+type pong struct {
+	Count int
+}
+
+func request(id string, options ...func(msg *azservicebus.Message) error) *azservicebus.ReceivedMessage {
+	msg := &azservicebus.Message{}
+	for _, option := range options {
+		_ = option(msg)
+	}
+	return &azservicebus.ReceivedMessage{
+		MessageID:             id,
+		ReplyTo:               to.Ptr("replies"),
+		ReplyToSessionID:      to.Ptr("client-1"),
+		ApplicationProperties: msg.ApplicationProperties,
+	}
+}
+
+func TestResponder(t *testing.T) {
+	g := NewWithT(t)
+	azSender := shuttletest.NewInMemorySender(nil)
+	calls := 0
+	handler := NewResponder(shuttle.NewSender(azSender, nil), func(ctx context.Context, request *azservicebus.ReceivedMessage) (shuttle.MessageBody, error) {
+		calls++
+		return &pong{Count: calls}, nil
+	}, &ResponderOptions{Store: NewMemoryStore(time.Hour)})
+	settler := shuttletest.NewRecordingSettler()
+
+	handler(context.Background(), settler, request("r1", SetIdempotencyKey("k1")))
+	// the retry with the same key gets the cached reply
+	handler(context.Background(), settler, request("r2", SetIdempotencyKey("k1")))
+	handler(context.Background(), settler, request("r3", SetIdempotencyKey("k2")))
+	// requests without key are always handled
+	handler(context.Background(), settler, request("r4"))
+
+	g.Expect(calls).To(Equal(3))
+	g.Expect(settler.Completed()).To(HaveLen(4))
+	sent := azSender.SentMessages()
+	g.Expect(sent).To(HaveLen(4))
+	g.Expect(string(sent[0].Body)).To(Equal(`{"Count":1}`))
+	g.Expect(*sent[0].CorrelationID).To(Equal("r1"))
+	g.Expect(*sent[0].SessionID).To(Equal("client-1"))
+	g.Expect(*sent[0].To).To(Equal("replies"))
+	g.Expect(sent[0].ApplicationProperties).To(HaveKeyWithValue("type", "pong"))
+	g.Expect(string(sent[1].Body)).To(Equal(`{"Count":1}`))
+	g.Expect(*sent[1].CorrelationID).To(Equal("r2"))
+	g.Expect(string(sent[2].Body)).To(Equal(`{"Count":2}`))
+	g.Expect(string(sent[3].Body)).To(Equal(`{"Count":3}`))
+}
+
+func TestResponder_Failures(t *testing.T) {
+	g := NewWithT(t)
+	azSender := shuttletest.NewInMemorySender(nil)
+	var errs []error
+	handlerErr := errors.New("database unavailable")
+	calls := 0
+	handler := NewResponder(shuttle.NewSender(azSender, nil), func(ctx context.Context, request *azservicebus.ReceivedMessage) (shuttle.MessageBody, error) {
+		calls++
+		return nil, handlerErr
+	}, &ResponderOptions{
+		Store:   NewMemoryStore(time.Hour),
+		OnError: func(ctx context.Context, request *azservicebus.ReceivedMessage, err error) { errs = append(errs, err) },
+	})
+	settler := shuttletest.NewRecordingSettler()
+
+	handler(context.Background(), settler, request("r1", SetIdempotencyKey("k1")))
+	g.Expect(settler.Abandoned()).To(HaveLen(1))
+	g.Expect(errs).To(HaveLen(1))
+	g.Expect(errs[0]).To(MatchError(handlerErr))
+	g.Expect(azSender.SentMessages()).To(BeEmpty())
+
+	// the claim is released, so that the retry handles the request again
+	handler(context.Background(), settler, request("r1", SetIdempotencyKey("k1")))
+	g.Expect(calls).To(Equal(2))
+	g.Expect(errs[1]).To(MatchError(handlerErr))
+}
+
+func TestResponder_ConcurrentDeliveries(t *testing.T) {
+	g := NewWithT(t)
+	azSender := shuttletest.NewInMemorySender(nil)
+	var errs []error
+	var calls atomic.Int32
+	handling, release := make(chan struct{}), make(chan struct{})
+	handler := NewResponder(shuttle.NewSender(azSender, nil), func(ctx context.Context, request *azservicebus.ReceivedMessage) (shuttle.MessageBody, error) {
+		calls.Add(1)
+		close(handling)
+		<-release
+		return &pong{Count: 1}, nil
+	}, &ResponderOptions{
+		Store:   NewMemoryStore(time.Hour),
+		OnError: func(ctx context.Context, request *azservicebus.ReceivedMessage, err error) { errs = append(errs, err) },
+	})
+	settler := shuttletest.NewRecordingSettler()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		handler(context.Background(), settler, request("r1", SetIdempotencyKey("k1")))
+	}()
+	<-handling
+	// the redelivery received while the request is handled is abandoned instead of running the handler
+	handler(context.Background(), settler, request("r1", SetIdempotencyKey("k1")))
+	g.Expect(errs).To(HaveLen(1))
+	g.Expect(errs[0]).To(MatchError(ErrRequestInProgress))
+	g.Expect(settler.Abandoned()).To(HaveLen(1))
+
+	close(release)
+	<-done
+	// and gets the cached reply once redelivered
+	handler(context.Background(), settler, request("r1", SetIdempotencyKey("k1")))
+	g.Expect(calls.Load()).To(Equal(int32(1)))
+	g.Expect(settler.Completed()).To(HaveLen(2))
+	g.Expect(azSender.SentMessages()).To(HaveLen(2))
+}
+
+func TestMemoryStore(t *testing.T) {
+	g := NewWithT(t)
+	now := time.Now()
+	store := NewMemoryStore(time.Minute)
+	store.now = func() time.Time { return now }
+
+	g.Expect(store.Put(context.Background(), "k1", &Reply{Body: []byte("1")})).To(Succeed())
+	reply, err := store.Get(context.Background(), "k1")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(reply.Body).To(Equal([]byte("1")))
+
+	now = now.Add(time.Minute)
+	reply, err = store.Get(context.Background(), "k1")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(reply).To(BeNil())
+
+	g.Expect(store.Put(context.Background(), "k2", &Reply{})).To(Succeed())
+	g.Expect(store.entries).To(HaveLen(1))
+}
+
+func TestMemoryStore_Claim(t *testing.T) {
+	g := NewWithT(t)
+	now := time.Now()
+	store := NewMemoryStore(time.Minute)
+	store.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	claimed, err := store.Claim(ctx, "k1", time.Second)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(claimed).To(BeTrue())
+	g.Expect(store.Claim(ctx, "k1", time.Second)).To(BeFalse(), "the key is claimed")
+	g.Expect(store.Get(ctx, "k1")).To(BeNil(), "a claim is not a reply")
+
+	g.Expect(store.Release(ctx, "k1")).To(Succeed())
+	g.Expect(store.Claim(ctx, "k1", time.Second)).To(BeTrue(), "the key is released")
+	now = now.Add(time.Second)
+	g.Expect(store.Claim(ctx, "k1", time.Second)).To(BeTrue(), "the claim expired")
+
+	g.Expect(store.Put(ctx, "k1", &Reply{Body: []byte("1")})).To(Succeed())
+	g.Expect(store.Claim(ctx, "k1", time.Second)).To(BeFalse(), "the key has a reply")
+	g.Expect(store.Release(ctx, "k1")).To(Succeed())
+	g.Expect(store.Get(ctx, "k1")).ToNot(BeNil(), "the reply is not released")
+}
+
+func TestMemoryStore_Sweep(t *testing.T) {
+	g := NewWithT(t)
+	now := time.Now()
+	store := NewMemoryStore(time.Minute)
+	store.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	g.Expect(store.Put(ctx, "k1", &Reply{})).To(Succeed())
+	now = now.Add(time.Minute)
+	g.Expect(store.Put(ctx, "k2", &Reply{})).To(Succeed())
+	g.Expect(store.entries).To(HaveLen(1), "the expired keys are swept")
+	g.Expect(store.Claim(ctx, "k3", time.Second)).To(BeTrue())
+	now = now.Add(2 * time.Second)
+	g.Expect(store.Put(ctx, "k4", &Reply{})).To(Succeed())
+	g.Expect(store.entries).To(HaveLen(3), "the sweeps run at most once per ttl")
+	g.Expect(store.Get(ctx, "k3")).To(BeNil())
+	g.Expect(store.entries).To(HaveLen(2), "the expired keys are evicted when read")
+}
@@ -0,0 +1,67 @@
+package shuttle
+
+import (
+	"fmt"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+)
+
+// brokerApplicationProperties are the application properties describing the previous delivery of a message,
+// set by service bus itself when it is dead-lettered, or by go-shuttle when it is retried or abandoned.
+// They are not copied when cloning a message for resend.
+var brokerApplicationProperties = map[string]struct{}{
+	"DeadLetterReason":           {},
+	"DeadLetterErrorDescription": {},
+	RetryAttemptProperty:         {},
+	RetryLastErrorProperty:       {},
+	RetryNextAttemptProperty:     {},
+	ProducerHostProperty:         {},
+	ProducerPodProperty:          {},
+	ProducerVersionProperty:      {},
+	ConsumerHostProperty:         {},
+	ConsumerPodProperty:          {},
+	ConsumerVersionProperty:      {},
+	ConsumerAttemptProperty:      {},
+}
+
+// CloneForResend builds a new azservicebus.Message from a received message so that it can be sent again,
+// for example to retry, replay or resubmit a dead-lettered message.
+// It preserves the body, content type, application properties (except the ones describing the previous delivery),
+// correlation id, session id, partition key, subject, reply-to, to and time to live.
+// The MessageID is not preserved to avoid the resent message being dropped by duplicate detection.
+// Use SetMessageId in the options to keep it.
+// The options are applied to the new message before returning it.
+func CloneForResend(received *azservicebus.ReceivedMessage, options ...func(msg *azservicebus.Message) error) (*azservicebus.Message, error) {
+	if received == nil {
+		return nil, fmt.Errorf("cannot clone a nil message")
+	}
+	msg := &azservicebus.Message{
+		ContentType:      received.ContentType,
+		CorrelationID:    received.CorrelationID,
+		SessionID:        received.SessionID,
+		PartitionKey:     received.PartitionKey,
+		Subject:          received.Subject,
+		ReplyTo:          received.ReplyTo,
+		ReplyToSessionID: received.ReplyToSessionID,
+		To:               received.To,
+		TimeToLive:       received.TimeToLive,
+	}
+	if received.Body != nil {
+		msg.Body = append([]byte{}, received.Body...)
+	}
+	if received.ApplicationProperties != nil {
+		msg.ApplicationProperties = make(map[string]any, len(received.ApplicationProperties))
+		for k, v := range received.ApplicationProperties {
+			if _, ok := brokerApplicationProperties[k]; ok {
+				continue
+			}
+			msg.ApplicationProperties[k] = v
+		}
+	}
+	for _, option := range options {
+		if err := option(msg); err != nil {
+			return nil, fmt.Errorf("failed to run message options: %w", err)
+		}
+	}
+	return msg, nil
+}
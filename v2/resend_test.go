@@ -0,0 +1,70 @@
+package shuttle
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	. "github.com/onsi/gomega"
+)
+
+func TestCloneForResend(t *testing.T) {
+	g := NewWithT(t)
+	received := &azservicebus.ReceivedMessage{
+		MessageID:     "original-id",
+		Body:          []byte("body"),
+		ContentType:   to.Ptr(jsonContentType),
+		CorrelationID: to.Ptr("correlation"),
+		SessionID:     to.Ptr("session"),
+		PartitionKey:  to.Ptr("session"),
+		Subject:       to.Ptr("subject"),
+		TimeToLive:    to.Ptr(time.Minute),
+		ApplicationProperties: map[string]any{
+			msgTypeField:                 "OrderCreated",
+			"tenant":                     "x",
+			"DeadLetterReason":           "reason",
+			"DeadLetterErrorDescription": "description",
+			RetryAttemptProperty:         int64(2),
+			RetryLastErrorProperty:       "timeout",
+			ConsumerHostProperty:         "host",
+			ConsumerAttemptProperty:      int64(2),
+			ProducerPodProperty:          "pod",
+		},
+		DeliveryCount: 3,
+	}
+	msg, err := CloneForResend(received)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(msg.Body).To(Equal([]byte("body")))
+	g.Expect(*msg.ContentType).To(Equal(jsonContentType))
+	g.Expect(*msg.CorrelationID).To(Equal("correlation"))
+	g.Expect(*msg.SessionID).To(Equal("session"))
+	g.Expect(*msg.PartitionKey).To(Equal("session"))
+	g.Expect(*msg.Subject).To(Equal("subject"))
+	g.Expect(*msg.TimeToLive).To(Equal(time.Minute))
+	g.Expect(msg.MessageID).To(BeNil())
+	g.Expect(msg.ApplicationProperties).To(Equal(map[string]any{msgTypeField: "OrderCreated", "tenant": "x"}))
+
+	// the clone does not share the body and properties with the received message
+	msg.Body[0] = 'B'
+	msg.ApplicationProperties["tenant"] = "y"
+	g.Expect(received.Body).To(Equal([]byte("body")))
+	g.Expect(received.ApplicationProperties["tenant"]).To(Equal("x"))
+}
+
+func TestCloneForResend_Options(t *testing.T) {
+	g := NewWithT(t)
+	received := &azservicebus.ReceivedMessage{MessageID: "original-id"}
+	msg, err := CloneForResend(received, SetMessageId(&received.MessageID))
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(*msg.MessageID).To(Equal("original-id"))
+
+	_, err = CloneForResend(received, func(msg *azservicebus.Message) error {
+		return fmt.Errorf("option failure")
+	})
+	g.Expect(err).To(MatchError(ContainSubstring("option failure")))
+
+	_, err = CloneForResend(nil)
+	g.Expect(err).To(HaveOccurred())
+}
@@ -0,0 +1,219 @@
+package shuttle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+)
+
+// defaultScheduleChunkSize keeps each schedule request well below the service's request size limit for typical messages.
+const defaultScheduleChunkSize = 100
+
+// ScheduleResult is the outcome of scheduling a single message.
+type ScheduleResult struct {
+	Message *azservicebus.Message
+	// SequenceNumber is the sequence number of the scheduled message, used to cancel it. It is only set when Err is nil.
+	SequenceNumber int64
+	Err            error
+}
+
+// ScheduleMessagesError is returned by ScheduleMessagesInChunks when some of the messages could not be scheduled.
+type ScheduleMessagesError struct {
+	// Failed contains the results of the messages that were not scheduled.
+	Failed []ScheduleResult
+	// Total is the number of messages that were requested to be scheduled.
+	Total int
+}
+
+func (e *ScheduleMessagesError) Error() string {
+	return fmt.Sprintf("failed to schedule %d of %d messages: %s", len(e.Failed), e.Total, e.Failed[0].Err)
+}
+
+// Unwrap returns the error of the first failed message.
+func (e *ScheduleMessagesError) Unwrap() error {
+	return e.Failed[0].Err
+}
+
+// FailedMessages returns the messages that were not scheduled, to retry only the failed subset.
+func (e *ScheduleMessagesError) FailedMessages() []*azservicebus.Message {
+	messages := make([]*azservicebus.Message, 0, len(e.Failed))
+	for _, result := range e.Failed {
+		messages = append(messages, result.Message)
+	}
+	return messages
+}
+
+// ScheduleOptions configures ScheduleMessagesInChunks.
+type ScheduleOptions struct {
+	// ChunkSize is the maximum number of messages scheduled per request. Defaults to 100.
+	ChunkSize int
+}
+
+// ScheduleMessagesInChunks schedules the messages in chunks of ScheduleOptions.ChunkSize messages,
+// instead of the all-or-nothing single request of ScheduleMessages.
+// It returns one result per message, in the same order as msgs.
+// A chunk is scheduled atomically by the service, so all the messages of a failed chunk fail with the same error.
+// When some messages fail, the returned error is a *ScheduleMessagesError listing them.
+// Once ctx is done, the remaining chunks are not sent and their messages fail with the context error.
+func (d *Sender) ScheduleMessagesInChunks(
+	ctx context.Context,
+	msgs []*azservicebus.Message,
+	scheduledEnqueueTime time.Time,
+	options *ScheduleOptions,
+) ([]ScheduleResult, error) {
+	chunkSize := defaultScheduleChunkSize
+	if options != nil && options.ChunkSize > 0 {
+		chunkSize = options.ChunkSize
+	}
+	results := make([]ScheduleResult, 0, len(msgs))
+	var failed []ScheduleResult
+	for start := 0; start < len(msgs); start += chunkSize {
+		end := start + chunkSize
+		if end > len(msgs) {
+			end = len(msgs)
+		}
+		chunk := msgs[start:end]
+		var sequenceNumbers []int64
+		err := ctx.Err()
+		if err == nil {
+			sequenceNumbers, err = d.ScheduleMessages(ctx, chunk, scheduledEnqueueTime)
+		}
+		if err == nil && len(sequenceNumbers) != len(chunk) {
+			err = fmt.Errorf("failed to schedule messages: expected %d sequence numbers, got %d", len(chunk), len(sequenceNumbers))
+		}
+		for i, msg := range chunk {
+			result := ScheduleResult{Message: msg, Err: err}
+			if err == nil {
+				result.SequenceNumber = sequenceNumbers[i]
+			} else {
+				failed = append(failed, result)
+			}
+			results = append(results, result)
+		}
+	}
+	if len(failed) > 0 {
+		return results, &ScheduleMessagesError{Failed: failed, Total: len(msgs)}
+	}
+	return results, nil
+}
+
+// ErrScheduledMessageNotFound is returned when no scheduled message is tracked for a MessageID.
+var ErrScheduledMessageNotFound = errors.New("scheduled message not found")
+
+// ScheduledMessageStore persists the sequence numbers of the scheduled messages by MessageID.
+// Entries are keyed by sequence number, so that several messages scheduled with the same MessageID are all tracked.
+// Use a durable implementation (sql, redis, table storage...) to cancel messages scheduled by another process or replica.
+type ScheduledMessageStore interface {
+	Save(ctx context.Context, messageID string, sequenceNumber int64) error
+	// Get returns the sequence numbers tracked for the MessageID, in ascending order.
+	// It returns ErrScheduledMessageNotFound when the MessageID is not tracked.
+	Get(ctx context.Context, messageID string) ([]int64, error)
+	// Delete stops tracking the sequence number, leaving the other sequence numbers of the MessageID tracked.
+	Delete(ctx context.Context, sequenceNumber int64) error
+}
+
+var _ ScheduledMessageStore = &InMemoryScheduledMessageStore{}
+
+// InMemoryScheduledMessageStore is a ScheduledMessageStore that keeps the sequence numbers in memory.
+// The mapping is lost when the process restarts.
+type InMemoryScheduledMessageStore struct {
+	mu         sync.Mutex
+	messageIDs map[int64]string
+}
+
+// NewInMemoryScheduledMessageStore creates an empty InMemoryScheduledMessageStore.
+func NewInMemoryScheduledMessageStore() *InMemoryScheduledMessageStore {
+	return &InMemoryScheduledMessageStore{messageIDs: map[int64]string{}}
+}
+
+func (s *InMemoryScheduledMessageStore) Save(_ context.Context, messageID string, sequenceNumber int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messageIDs[sequenceNumber] = messageID
+	return nil
+}
+
+func (s *InMemoryScheduledMessageStore) Get(_ context.Context, messageID string) ([]int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var sequenceNumbers []int64
+	for sequenceNumber, id := range s.messageIDs {
+		if id == messageID {
+			sequenceNumbers = append(sequenceNumbers, sequenceNumber)
+		}
+	}
+	if len(sequenceNumbers) == 0 {
+		return nil, ErrScheduledMessageNotFound
+	}
+	sort.Slice(sequenceNumbers, func(i, j int) bool { return sequenceNumbers[i] < sequenceNumbers[j] })
+	return sequenceNumbers, nil
+}
+
+func (s *InMemoryScheduledMessageStore) Delete(_ context.Context, sequenceNumber int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.messageIDs, sequenceNumber)
+	return nil
+}
+
+// ScheduledMessageTracker schedules messages through a Sender and tracks their sequence numbers by MessageID,
+// so that applications can cancel them with their own ids instead of the broker sequence numbers.
+type ScheduledMessageTracker struct {
+	sender *Sender
+	store  ScheduledMessageStore
+}
+
+// NewScheduledMessageTracker creates a ScheduledMessageTracker. A nil store defaults to an InMemoryScheduledMessageStore.
+func NewScheduledMessageTracker(sender *Sender, store ScheduledMessageStore) *ScheduledMessageTracker {
+	if store == nil {
+		store = NewInMemoryScheduledMessageStore()
+	}
+	return &ScheduledMessageTracker{sender: sender, store: store}
+}
+
+// ScheduleMessages schedules the messages and saves their sequence numbers in the store.
+// All the messages must have a MessageID.
+func (t *ScheduledMessageTracker) ScheduleMessages(ctx context.Context, msgs []*azservicebus.Message, scheduledEnqueueTime time.Time) ([]int64, error) {
+	for _, msg := range msgs {
+		if msg.MessageID == nil || *msg.MessageID == "" {
+			return nil, fmt.Errorf("failed to schedule messages: scheduled messages must have a MessageID to be tracked")
+		}
+	}
+	sequenceNumbers, err := t.sender.ScheduleMessages(ctx, msgs, scheduledEnqueueTime)
+	if err != nil {
+		return nil, err
+	}
+	for i, msg := range msgs {
+		if i >= len(sequenceNumbers) {
+			break
+		}
+		if err := t.store.Save(ctx, *msg.MessageID, sequenceNumbers[i]); err != nil {
+			return sequenceNumbers, fmt.Errorf("failed to save sequence number of scheduled message %s: %w", *msg.MessageID, err)
+		}
+	}
+	return sequenceNumbers, nil
+}
+
+// CancelScheduledByMessageID cancels all the scheduled messages tracked with the MessageID.
+// It returns ErrScheduledMessageNotFound when the message is not tracked in the store.
+// The sequence numbers stay tracked when the cancellation fails, so that it can be retried.
+func (t *ScheduledMessageTracker) CancelScheduledByMessageID(ctx context.Context, messageID string) error {
+	sequenceNumbers, err := t.store.Get(ctx, messageID)
+	if err != nil {
+		return fmt.Errorf("failed to get sequence numbers of scheduled message %s: %w", messageID, err)
+	}
+	if err := t.sender.CancelScheduledMessages(ctx, sequenceNumbers); err != nil {
+		return err
+	}
+	for _, sequenceNumber := range sequenceNumbers {
+		if err := t.store.Delete(ctx, sequenceNumber); err != nil {
+			return fmt.Errorf("failed to delete sequence number %d of scheduled message %s: %w", sequenceNumber, messageID, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,183 @@
+package shuttle_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	. "github.com/onsi/gomega"
+
+	"github.com/Azure/go-shuttle/v2"
+	"github.com/Azure/go-shuttle/v2/shuttletest"
+)
+
+var errScheduleRejected = errors.New("schedule rejected")
+
+// rejectingScheduler fails to schedule the chunks containing a message with the "reject" property.
+type rejectingScheduler struct {
+	*shuttletest.InMemorySender
+	calls int
+}
+
+func (r *rejectingScheduler) ScheduleMessages(ctx context.Context, messages []*azservicebus.Message, scheduledEnqueueTime time.Time, options *azservicebus.ScheduleMessagesOptions) ([]int64, error) {
+	r.calls++
+	for _, msg := range messages {
+		if _, ok := msg.ApplicationProperties["reject"]; ok {
+			return nil, errScheduleRejected
+		}
+	}
+	return r.InMemorySender.ScheduleMessages(ctx, messages, scheduledEnqueueTime, options)
+}
+
+func TestSender_ScheduleMessagesInChunks(t *testing.T) {
+	g := NewWithT(t)
+	azSender := &rejectingScheduler{InMemorySender: shuttletest.NewInMemorySender(nil)}
+	sender := shuttle.NewSender(azSender, nil)
+	var msgs []*azservicebus.Message
+	for i := 0; i < 5; i++ {
+		msgs = append(msgs, &azservicebus.Message{ApplicationProperties: map[string]any{}})
+	}
+	msgs[3].ApplicationProperties["reject"] = true
+
+	results, err := sender.ScheduleMessagesInChunks(context.Background(), msgs, time.Now().Add(time.Hour),
+		&shuttle.ScheduleOptions{ChunkSize: 2})
+	g.Expect(azSender.calls).To(Equal(3))
+	g.Expect(results).To(HaveLen(5))
+	for i, result := range results {
+		g.Expect(result.Message).To(BeIdenticalTo(msgs[i]))
+	}
+	g.Expect(results[0].Err).ToNot(HaveOccurred())
+	g.Expect(results[1].Err).ToNot(HaveOccurred())
+	g.Expect(results[4].Err).ToNot(HaveOccurred())
+	g.Expect(results[0].SequenceNumber).ToNot(Equal(results[1].SequenceNumber))
+	g.Expect(results[2].Err).To(MatchError(errScheduleRejected))
+	g.Expect(results[3].Err).To(MatchError(errScheduleRejected))
+
+	var scheduleErr *shuttle.ScheduleMessagesError
+	g.Expect(errors.As(err, &scheduleErr)).To(BeTrue())
+	g.Expect(err).To(MatchError(errScheduleRejected))
+	g.Expect(err).To(MatchError(ContainSubstring("failed to schedule 2 of 5 messages")))
+	g.Expect(scheduleErr.FailedMessages()).To(Equal([]*azservicebus.Message{msgs[2], msgs[3]}))
+	g.Expect(azSender.ScheduledMessages()).To(HaveLen(3))
+}
+
+func TestSender_ScheduleMessagesInChunks_DefaultChunkSize(t *testing.T) {
+	g := NewWithT(t)
+	azSender := &rejectingScheduler{InMemorySender: shuttletest.NewInMemorySender(nil)}
+	sender := shuttle.NewSender(azSender, nil)
+	msgs := make([]*azservicebus.Message, 150)
+	for i := range msgs {
+		msgs[i] = &azservicebus.Message{}
+	}
+	results, err := sender.ScheduleMessagesInChunks(context.Background(), msgs, time.Now().Add(time.Hour), nil)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(results).To(HaveLen(150))
+	g.Expect(azSender.calls).To(Equal(2))
+}
+
+func TestSender_ScheduleMessagesInChunks_StopsWhenContextDone(t *testing.T) {
+	g := NewWithT(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	azSender := &rejectingScheduler{InMemorySender: shuttletest.NewInMemorySender(nil)}
+	// cancel the context once the first chunk is scheduled
+	sender := shuttle.NewSender(azSender, &shuttle.SenderOptions{Hooks: &shuttle.Hooks{
+		OnSendAttempt: func(context.Context, shuttle.SendAttemptEvent) { cancel() },
+	}})
+	msgs := make([]*azservicebus.Message, 5)
+	for i := range msgs {
+		msgs[i] = &azservicebus.Message{}
+	}
+
+	results, err := sender.ScheduleMessagesInChunks(ctx, msgs, time.Now().Add(time.Hour), &shuttle.ScheduleOptions{ChunkSize: 2})
+	g.Expect(azSender.calls).To(Equal(1))
+	g.Expect(results).To(HaveLen(5))
+	g.Expect(results[0].Err).ToNot(HaveOccurred())
+	g.Expect(results[1].Err).ToNot(HaveOccurred())
+	for _, result := range results[2:] {
+		g.Expect(result.Err).To(MatchError(context.Canceled))
+	}
+	var scheduleErr *shuttle.ScheduleMessagesError
+	g.Expect(errors.As(err, &scheduleErr)).To(BeTrue())
+	g.Expect(scheduleErr.FailedMessages()).To(Equal(msgs[2:]))
+}
+
+func TestScheduledMessageTracker(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+	inMemory := shuttletest.NewInMemorySender(nil)
+	tracker := shuttle.NewScheduledMessageTracker(shuttle.NewSender(inMemory, nil), nil)
+
+	_, err := tracker.ScheduleMessages(ctx, []*azservicebus.Message{{}}, time.Now().Add(time.Hour))
+	g.Expect(err).To(MatchError(ContainSubstring("must have a MessageID")))
+
+	sequenceNumbers, err := tracker.ScheduleMessages(ctx, []*azservicebus.Message{
+		{MessageID: to.Ptr("reminder-1")},
+		{MessageID: to.Ptr("reminder-2")},
+	}, time.Now().Add(time.Hour))
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(sequenceNumbers).To(HaveLen(2))
+	g.Expect(inMemory.ScheduledMessages()).To(HaveLen(2))
+
+	g.Expect(tracker.CancelScheduledByMessageID(ctx, "reminder-1")).To(Succeed())
+	g.Expect(inMemory.ScheduledMessages()).To(HaveLen(1))
+	g.Expect(inMemory.ScheduledMessages()).To(HaveKey(sequenceNumbers[1]))
+
+	err = tracker.CancelScheduledByMessageID(ctx, "reminder-1")
+	g.Expect(errors.Is(err, shuttle.ErrScheduledMessageNotFound)).To(BeTrue())
+}
+
+func TestScheduledMessageTracker_DuplicateMessageIDs(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+	inMemory := shuttletest.NewInMemorySender(nil)
+	tracker := shuttle.NewScheduledMessageTracker(shuttle.NewSender(inMemory, nil), nil)
+
+	for i := 0; i < 2; i++ {
+		_, err := tracker.ScheduleMessages(ctx, []*azservicebus.Message{{MessageID: to.Ptr("reminder")}}, time.Now().Add(time.Hour))
+		g.Expect(err).ToNot(HaveOccurred())
+	}
+	g.Expect(inMemory.ScheduledMessages()).To(HaveLen(2))
+
+	g.Expect(tracker.CancelScheduledByMessageID(ctx, "reminder")).To(Succeed())
+	g.Expect(inMemory.ScheduledMessages()).To(BeEmpty())
+}
+
+func TestScheduledMessageTracker_KeepsEntryWhenCancelFails(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+	inMemory := shuttletest.NewInMemorySender(nil)
+	store := shuttle.NewInMemoryScheduledMessageStore()
+	tracker := shuttle.NewScheduledMessageTracker(shuttle.NewSender(inMemory, nil), store)
+
+	sequenceNumbers, err := tracker.ScheduleMessages(ctx, []*azservicebus.Message{{MessageID: to.Ptr("reminder")}}, time.Now().Add(time.Hour))
+	g.Expect(err).ToNot(HaveOccurred())
+	// the message is no longer scheduled on the broker, so the cancellation fails
+	g.Expect(inMemory.CancelScheduledMessages(ctx, sequenceNumbers, nil)).To(Succeed())
+
+	g.Expect(tracker.CancelScheduledByMessageID(ctx, "reminder")).ToNot(Succeed())
+	tracked, err := store.Get(ctx, "reminder")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(tracked).To(Equal(sequenceNumbers))
+}
+
+func TestInMemoryScheduledMessageStore(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+	store := shuttle.NewInMemoryScheduledMessageStore()
+	g.Expect(store.Save(ctx, "id", 43)).To(Succeed())
+	g.Expect(store.Save(ctx, "id", 42)).To(Succeed())
+	sequenceNumbers, err := store.Get(ctx, "id")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(sequenceNumbers).To(Equal([]int64{42, 43}))
+	g.Expect(store.Delete(ctx, 42)).To(Succeed())
+	sequenceNumbers, err = store.Get(ctx, "id")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(sequenceNumbers).To(Equal([]int64{43}))
+	g.Expect(store.Delete(ctx, 43)).To(Succeed())
+	_, err = store.Get(ctx, "id")
+	g.Expect(err).To(MatchError(shuttle.ErrScheduledMessageNotFound))
+}
@@ -0,0 +1,113 @@
+package shuttle
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+)
+
+// Schedule returns the next occurrence strictly after the given time, or the zero time when there is none.
+// It is satisfied by the schedules of cron libraries, such as github.com/robfig/cron/v3 Schedule.
+type Schedule interface {
+	Next(after time.Time) time.Time
+}
+
+// ScheduleFunc allows to use a func as a Schedule.
+type ScheduleFunc func(after time.Time) time.Time
+
+func (f ScheduleFunc) Next(after time.Time) time.Time {
+	return f(after)
+}
+
+// Every returns a Schedule firing at a fixed interval, aligned on the interval (every minute fires at the start of each minute).
+func Every(interval time.Duration) Schedule {
+	return ScheduleFunc(func(after time.Time) time.Time {
+		return after.Truncate(interval).Add(interval)
+	})
+}
+
+// SchedulerOptions configures the Scheduler.
+type SchedulerOptions struct {
+	// LeaderElector restricts the publishing to the replica holding the lease.
+	// Without it, every replica publishes, and the entity's duplicate detection must be enabled to drop the duplicates.
+	LeaderElector *LeaderElector
+	// MessageOptions are applied to each published message.
+	MessageOptions []func(msg *azservicebus.Message) error
+	// OnError is called when an occurrence fails to be published. The occurrence is skipped.
+	OnError func(ctx context.Context, occurrence time.Time, err error)
+	// Clock is used to wait for the occurrences. Defaults to the system clock.
+	Clock Clock
+}
+
+// Scheduler publishes a message on a recurring schedule, for example heartbeats or saga timeouts.
+// Each occurrence is sent ahead of time as a scheduled message, enqueued by the broker at the occurrence time.
+// The MessageID of an occurrence is derived from the scheduler name and the occurrence time,
+// so that duplicate detection drops an occurrence published twice.
+type Scheduler struct {
+	sender   *Sender
+	name     string
+	schedule Schedule
+	body     func(ctx context.Context, occurrence time.Time) MessageBody
+	options  SchedulerOptions
+}
+
+// NewScheduler creates a Scheduler publishing the body returned for each occurrence of the schedule.
+func NewScheduler(
+	sender *Sender,
+	name string,
+	schedule Schedule,
+	body func(ctx context.Context, occurrence time.Time) MessageBody,
+	options *SchedulerOptions) *Scheduler {
+	opts := SchedulerOptions{}
+	if options != nil {
+		opts = *options
+	}
+	opts.Clock = clockOrDefault(opts.Clock)
+	if opts.OnError == nil {
+		opts.OnError = func(ctx context.Context, occurrence time.Time, err error) {
+			log(ctx, fmt.Sprintf("failed to publish occurrence %s: %s", occurrence, err))
+		}
+	}
+	return &Scheduler{sender: sender, name: name, schedule: schedule, body: body, options: opts}
+}
+
+// Run publishes the occurrences until the context is canceled or the schedule has no next occurrence.
+// When a LeaderElector is configured, only the leader publishes.
+func (s *Scheduler) Run(ctx context.Context) error {
+	if s.options.LeaderElector != nil {
+		return s.options.LeaderElector.Run(ctx, s.run)
+	}
+	return s.run(ctx)
+}
+
+func (s *Scheduler) run(ctx context.Context) error {
+	for {
+		occurrence := s.schedule.Next(s.options.Clock.Now())
+		if occurrence.IsZero() {
+			return nil
+		}
+		if err := s.publish(ctx, occurrence); err != nil {
+			s.options.OnError(ctx, occurrence, err)
+		}
+		// the publish can be slow, so the wait is computed from the clock after it.
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-s.options.Clock.After(occurrence.Sub(s.options.Clock.Now())):
+		}
+	}
+}
+
+// OccurrenceMessageID returns the MessageID of the occurrence published by the scheduler.
+func (s *Scheduler) OccurrenceMessageID(occurrence time.Time) string {
+	return fmt.Sprintf("%s-%d", s.name, occurrence.UnixNano())
+}
+
+func (s *Scheduler) publish(ctx context.Context, occurrence time.Time) error {
+	messageID := s.OccurrenceMessageID(occurrence)
+	options := append([]func(msg *azservicebus.Message) error{}, s.options.MessageOptions...)
+	options = append(options, SetMessageId(&messageID), SetScheduleAt(occurrence))
+	return s.sender.SendMessage(ctx, s.body(ctx, occurrence), options...)
+}
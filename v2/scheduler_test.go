@@ -0,0 +1,111 @@
+package shuttle_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	. "github.com/onsi/gomega"
+
+	"github.com/Azure/go-shuttle/v2"
+	"github.com/Azure/go-shuttle/v2/shuttletest"
+)
+
+func TestEvery(t *testing.T) {
+	g := NewWithT(t)
+	schedule := shuttle.Every(time.Minute)
+	start := time.Date(2023, 1, 1, 10, 0, 30, 0, time.UTC)
+	g.Expect(schedule.Next(start)).To(Equal(time.Date(2023, 1, 1, 10, 1, 0, 0, time.UTC)))
+	g.Expect(schedule.Next(time.Date(2023, 1, 1, 10, 1, 0, 0, time.UTC))).To(Equal(time.Date(2023, 1, 1, 10, 2, 0, 0, time.UTC)))
+}
+
+func TestScheduler_PublishesOccurrences(t *testing.T) {
+	g := NewWithT(t)
+	start := time.Date(2023, 1, 1, 10, 0, 30, 0, time.UTC)
+	clock := shuttletest.NewFakeClock(start)
+	inMemory := shuttletest.NewInMemorySender(nil)
+	scheduler := shuttle.NewScheduler(shuttle.NewSender(inMemory, nil), "heartbeat", shuttle.Every(time.Minute),
+		func(ctx context.Context, occurrence time.Time) shuttle.MessageBody {
+			return map[string]string{"at": occurrence.Format(time.RFC3339)}
+		}, &shuttle.SchedulerOptions{Clock: clock})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- scheduler.Run(ctx) }()
+
+	g.Eventually(clock.PendingTimers).Should(Equal(1))
+	clock.Advance(30 * time.Second)
+	g.Eventually(func() int { return len(inMemory.SentMessages()) }).Should(Equal(2))
+	cancel()
+	g.Expect(<-done).To(MatchError(context.Canceled))
+
+	sent := inMemory.SentMessages()
+	first := time.Date(2023, 1, 1, 10, 1, 0, 0, time.UTC)
+	g.Expect(*sent[0].ScheduledEnqueueTime).To(Equal(first))
+	g.Expect(*sent[0].MessageID).To(Equal(scheduler.OccurrenceMessageID(first)))
+	g.Expect(*sent[1].ScheduledEnqueueTime).To(Equal(first.Add(time.Minute)))
+	g.Expect(*sent[1].MessageID).ToNot(Equal(*sent[0].MessageID))
+}
+
+func TestScheduler_WaitsFromTheClockAfterPublishing(t *testing.T) {
+	g := NewWithT(t)
+	start := time.Date(2023, 1, 1, 10, 0, 30, 0, time.UTC)
+	clock := shuttletest.NewFakeClock(start)
+	inMemory := shuttletest.NewInMemorySender(nil)
+	var once sync.Once
+	scheduler := shuttle.NewScheduler(shuttle.NewSender(inMemory, nil), "heartbeat", shuttle.Every(time.Minute),
+		func(ctx context.Context, occurrence time.Time) shuttle.MessageBody {
+			// the first publish takes 20 seconds
+			once.Do(func() { clock.Advance(20 * time.Second) })
+			return "body"
+		}, &shuttle.SchedulerOptions{Clock: clock})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- scheduler.Run(ctx) }()
+
+	g.Eventually(clock.PendingTimers).Should(Equal(1))
+	clock.Advance(10 * time.Second)
+	g.Eventually(func() int { return len(inMemory.SentMessages()) }).Should(Equal(2))
+	cancel()
+	g.Expect(<-done).To(MatchError(context.Canceled))
+}
+
+func TestScheduler_StopsWithoutNextOccurrence(t *testing.T) {
+	g := NewWithT(t)
+	clock := shuttletest.NewFakeClock(time.Now())
+	var mu sync.Mutex
+	var errs []error
+	scheduler := shuttle.NewScheduler(shuttle.NewSender(&failingAzSender{}, nil), "once",
+		shuttle.ScheduleFunc(func(after time.Time) time.Time {
+			return time.Time{}
+		}), func(ctx context.Context, occurrence time.Time) shuttle.MessageBody { return "body" },
+		&shuttle.SchedulerOptions{Clock: clock, OnError: func(_ context.Context, _ time.Time, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			errs = append(errs, err)
+		}})
+	g.Expect(scheduler.Run(context.Background())).To(Succeed())
+	g.Expect(errs).To(BeEmpty())
+}
+
+func TestScheduler_ReportsPublishErrors(t *testing.T) {
+	g := NewWithT(t)
+	clock := shuttletest.NewFakeClock(time.Now())
+	errs := make(chan error, 1)
+	scheduler := shuttle.NewScheduler(shuttle.NewSender(&failingAzSender{}, nil), "failing", shuttle.Every(time.Minute),
+		func(ctx context.Context, occurrence time.Time) shuttle.MessageBody { return "body" },
+		&shuttle.SchedulerOptions{
+			Clock:          clock,
+			MessageOptions: []func(msg *azservicebus.Message) error{shuttle.SetMessageTTL(time.Minute)},
+			OnError: func(_ context.Context, _ time.Time, err error) {
+				errs <- err
+			}})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = scheduler.Run(ctx) }()
+	var err error
+	g.Eventually(errs).Should(Receive(&err))
+	g.Expect(errors.Unwrap(err)).To(MatchError("send failed"))
+}
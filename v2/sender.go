@@ -2,20 +2,32 @@ package shuttle
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"reflect"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	"github.com/Azure/go-amqp"
+	"google.golang.org/protobuf/proto"
 
 	"github.com/Azure/go-shuttle/v2/metrics/sender"
+	shuttleotel "github.com/Azure/go-shuttle/v2/otel"
 )
 
 const (
 	msgTypeField       = "type"
 	defaultSendTimeout = 30 * time.Second
+	// serverBusyCondition is the AMQP error condition returned when the namespace throttles requests.
+	serverBusyCondition amqp.ErrCond = "com.microsoft:server-busy"
 )
 
+// ErrSenderClosed is returned by the send operations of a Sender after Close was called.
+var ErrSenderClosed = errors.New("sender is closed")
+
 // MessageBody is a type to represent that an input message body can be of any type
 type MessageBody any
 
@@ -29,67 +41,275 @@ type AzServiceBusSender interface {
 }
 
 // Sender contains an SBSender used to send the message to the ServiceBus queue and a Marshaller used to marshal any struct into a ServiceBus message
+// A Sender is safe for concurrent use by multiple goroutines. Its options are copied by NewSender and cannot be changed afterwards.
 type Sender struct {
 	sbSender AzServiceBusSender
 	options  *SenderOptions
+	// guard reports the changes made to the caller's options after NewSender, in the builds with the race detector.
+	guard *optionsGuard[SenderOptions]
+	// closeMu guards closed, so that no send is started once Close waits for the in-flight ones.
+	closeMu  sync.RWMutex
+	closed   bool
+	inflight sync.WaitGroup
+	// backpressure tracks the throttled operations, to report and smooth them.
+	backpressure *backpressureTracker
 }
 
 type SenderOptions struct {
 	// Marshaller will be used to marshall the messageBody to the azservicebus.Message Body property
 	// defaults to DefaultJSONMarshaller
 	Marshaller Marshaller
-	// EnableTracingPropagation automatically applies WithTracePropagation option on all message sent through this sender
+	// EnableTracingPropagation automatically applies WithTracePropagation option on all message sent through this sender,
+	// and records the scheduling trace context of the scheduled messages, see WithSchedulingTracePropagation.
 	EnableTracingPropagation bool
+	// EnableDeadlinePropagation automatically applies SetDeadlineFromContext on all message sent through this sender
+	EnableDeadlinePropagation bool
 	// SendTimeout is the timeout value used on the context that sends messages
 	// Defaults to 30 seconds if not set or 0
 	// Disabled when set to a negative value
 	SendTimeout time.Duration
+	// Clock is used to measure the SendTimeout. Defaults to the system clock.
+	Clock Clock
+	// MessageIDGenerator sets the MessageID of the messages before the message options are applied,
+	// so that SetMessageId still overrides it. Defaults to UUIDv7MessageIDGenerator.
+	// A generator returning an empty string leaves the MessageID to the broker.
+	MessageIDGenerator MessageIDGenerator
+	// PartitionKeyExtractor sets the PartitionKey of the messages from their body, before the message options are applied.
+	// An empty key leaves the PartitionKey unset.
+	PartitionKeyExtractor func(mb MessageBody) string
+	// EntityName is the queue or topic the sender sends to. It is used to label the sender metrics,
+	// which are labeled "default" when it is not set.
+	EntityName string
+	// CloseAzSender closes the underlying AzServiceBusSender on Close, when the Sender owns it.
+	// The AzServiceBusSender must implement Close(ctx) error, like *azservicebus.Sender.
+	CloseAzSender bool
+	// Backpressure configures how the sender reacts to the throttling of the namespace, reported by Sender.Backpressure.
+	// Defaults to tracking the throttling over 10 seconds without delaying the sends.
+	Backpressure *BackpressureOptions
+	// MaxMessageSize checks the size of the messages before sending them. Defaults to nil, leaving the check to the broker.
+	MaxMessageSize MaxMessageSizeProvider
+	// OnMessageTooLarge is called with the messages exceeding the MaxMessageSize, to send a smaller message instead.
+	// Defaults to nil, failing the send with an *ErrMessageTooLarge.
+	OnMessageTooLarge OversizedMessageHandler
+	// MessageSizeWarningThreshold is the fraction of the MaxMessageSize above which the messages are reported,
+	// for example 0.8. Defaults to 0, disabling the warnings.
+	MessageSizeWarningThreshold float64
+	// OnMessageSizeWarning is called with the messages above the MessageSizeWarningThreshold. Defaults to nil.
+	OnMessageSizeWarning MessageSizeWarningHandler
+	// Hooks are called after each send operation. See Hooks.
+	Hooks *Hooks
+	// SkipUnbatchableMessages sends the messages of SendMessageBatch that could be added to the batch,
+	// instead of failing on the first one that cannot. See WithSkipUnbatchableMessages.
+	SkipUnbatchableMessages bool
+	// HostInfo applies SetProducerHostInfo on all the messages sent through this sender. Defaults to nil.
+	HostInfo *HostInfo
+	// Signing applies SignMessage on all the messages sent and scheduled through this sender, after the other options.
+	// Defaults to nil.
+	Signing *SigningOptions
+	// RawBodies sends the []byte and json.RawMessage bodies as-is, and marshals the proto.Message bodies
+	// with the protobuf wire format, instead of going through the Marshaller. See WithRawBodies.
+	RawBodies bool
 }
 
 // NewSender takes in a Sender and a Marshaller to create a new object that can send messages to the ServiceBus queue
+// The options are copied, including the Backpressure, Hooks, HostInfo and Signing they point to,
+// so that the caller can reuse them for other senders.
 func NewSender(sender AzServiceBusSender, options *SenderOptions) *Sender {
-	if options == nil {
-		options = &SenderOptions{Marshaller: &DefaultJSONMarshaller{}}
+	opts := SenderOptions{}
+	if options != nil {
+		opts = *options
+	}
+	if opts.Marshaller == nil {
+		opts.Marshaller = &DefaultJSONMarshaller{}
+	}
+	if opts.SendTimeout == 0 {
+		opts.SendTimeout = defaultSendTimeout
+	}
+	if opts.MessageIDGenerator == nil {
+		opts.MessageIDGenerator = UUIDv7MessageIDGenerator
+	}
+	if opts.Backpressure != nil {
+		backpressure := *opts.Backpressure
+		opts.Backpressure = &backpressure
+	}
+	if opts.Hooks != nil {
+		hooks := *opts.Hooks
+		opts.Hooks = &hooks
+	}
+	if opts.HostInfo != nil {
+		hostInfo := *opts.HostInfo
+		opts.HostInfo = &hostInfo
+	}
+	if opts.Signing != nil {
+		signing := *opts.Signing
+		opts.Signing = &signing
+	}
+	return &Sender{
+		sbSender:     sender,
+		options:      &opts,
+		guard:        newOptionsGuard(options),
+		backpressure: newBackpressureTracker(opts.Backpressure, opts.Clock),
+	}
+}
+
+// SenderOption configures the Sender created by NewSenderWithOptions.
+type SenderOption func(options *SenderOptions)
+
+// NewSenderWithOptions creates a Sender configured with functional options.
+// It applies the same defaults as NewSender: DefaultJSONMarshaller and a 30 seconds send timeout.
+func NewSenderWithOptions(sender AzServiceBusSender, options ...SenderOption) *Sender {
+	opts := &SenderOptions{Marshaller: &DefaultJSONMarshaller{}}
+	for _, option := range options {
+		option(opts)
+	}
+	return NewSender(sender, opts)
+}
+
+// WithMarshaller sets the Marshaller used to marshal the message bodies.
+func WithMarshaller(marshaller Marshaller) SenderOption {
+	return func(options *SenderOptions) {
+		options.Marshaller = marshaller
+	}
+}
+
+// WithSendTimeout sets the timeout of the send operations. A negative value disables the timeout.
+func WithSendTimeout(timeout time.Duration) SenderOption {
+	return func(options *SenderOptions) {
+		options.SendTimeout = timeout
+	}
+}
+
+// WithSenderTracePropagation applies WithTracePropagation on all messages sent through the sender.
+func WithSenderTracePropagation() SenderOption {
+	return func(options *SenderOptions) {
+		options.EnableTracingPropagation = true
+	}
+}
+
+// WithSenderClock sets the Clock used to measure the send timeout.
+func WithSenderClock(clock Clock) SenderOption {
+	return func(options *SenderOptions) {
+		options.Clock = clock
 	}
-	if options.SendTimeout == 0 {
-		options.SendTimeout = defaultSendTimeout
+}
+
+// WithMessageIDGenerator sets the MessageIDGenerator of the sender.
+func WithMessageIDGenerator(generator MessageIDGenerator) SenderOption {
+	return func(options *SenderOptions) {
+		options.MessageIDGenerator = generator
+	}
+}
+
+// WithSenderEntityName sets the name of the queue or topic the sender sends to, used to label the sender metrics.
+func WithSenderEntityName(entityName string) SenderOption {
+	return func(options *SenderOptions) {
+		options.EntityName = entityName
+	}
+}
+
+// WithCloseAzSender closes the underlying AzServiceBusSender when the Sender is closed.
+func WithCloseAzSender() SenderOption {
+	return func(options *SenderOptions) {
+		options.CloseAzSender = true
+	}
+}
+
+// WithRawBodies skips the Marshaller for the bodies that are already bytes, []byte and json.RawMessage,
+// and for the proto.Message bodies, marshalled with the protobuf wire format.
+// It changes the body sent for these types when the Marshaller encodes them differently, for example
+// the DefaultJSONMarshaller encoding a []byte as a base64 JSON string, so the consumers must expect the raw bytes.
+func WithRawBodies() SenderOption {
+	return func(options *SenderOptions) {
+		options.RawBodies = true
+	}
+}
+
+// WithPartitionKeyFromBody derives the PartitionKey of the messages from their body, for example from an order id,
+// so that partitioned entities receive consistent partition keys without setting them on each send.
+func WithPartitionKeyFromBody(extractor func(mb MessageBody) string) SenderOption {
+	return func(options *SenderOptions) {
+		options.PartitionKeyExtractor = extractor
 	}
-	return &Sender{sbSender: sender, options: options}
 }
 
 // SendMessage sends a payload on the bus.
 // the MessageBody is marshalled and set as the message body.
 func (d *Sender) SendMessage(ctx context.Context, mb MessageBody, options ...func(msg *azservicebus.Message) error) error {
+	if err := d.begin(ctx); err != nil {
+		return err
+	}
+	defer d.inflight.Done()
 	msg, err := d.ToServiceBusMessage(ctx, mb, options...)
 	if err != nil {
 		return err
 	}
-	if d.options.SendTimeout > 0 {
-		var cancel func()
-		ctx, cancel = context.WithTimeout(ctx, d.options.SendTimeout)
-		defer cancel()
+	return d.send(ctx, msg)
+}
+
+// SendAzMessage sends a pre-built azservicebus.Message, for example a message being replayed or forwarded.
+// The marshaller is skipped, but the trace propagation, the options, the send timeout and the metrics are applied
+// like for SendMessage.
+func (d *Sender) SendAzMessage(ctx context.Context, msg *azservicebus.Message, options ...func(msg *azservicebus.Message) error) error {
+	if err := d.begin(ctx); err != nil {
+		return err
 	}
+	defer d.inflight.Done()
+	if err := d.applyOptions(ctx, msg, options); err != nil {
+		return err
+	}
+	return d.send(ctx, msg)
+}
 
-	errChan := make(chan error)
+// applyOptions applies the options and the ones enabled on the sender to a pre-built message.
+func (d *Sender) applyOptions(ctx context.Context, msg *azservicebus.Message, options []func(msg *azservicebus.Message) error) error {
+	if msg.ApplicationProperties == nil {
+		msg.ApplicationProperties = map[string]interface{}{}
+	}
+	for _, option := range d.messageOptions(ctx, options) {
+		if err := option(msg); err != nil {
+			return fmt.Errorf("failed to run message options: %w", err)
+		}
+	}
+	return nil
+}
 
-	go func() {
-		if err := d.sbSender.SendMessage(ctx, msg, nil); err != nil { // sendMessageOptions currently does nothing
+func (d *Sender) send(ctx context.Context, msg *azservicebus.Message) error {
+	msg, err := d.checkSize(ctx, msg)
+	if err != nil {
+		return err
+	}
+	sender.Metric.ObserveMessageSize(d.options.EntityName, len(msg.Body))
+	return d.sendTimed(ctx, func(ctx context.Context) error {
+		return d.sbSender.SendMessage(ctx, msg, nil) // sendMessageOptions currently does nothing
+	})
+}
+
+// sendTimed sends with the backpressure delay, the send timeout and the metrics of the send operations.
+func (d *Sender) sendTimed(ctx context.Context, sendFn func(ctx context.Context) error) error {
+	if err := d.backpressure.wait(ctx); err != nil {
+		return err
+	}
+	ctx, cancel := d.withSendTimeout(ctx)
+	defer cancel()
+	start := time.Now()
+
+	// buffered so that the goroutine does not block forever when the send times out before it returns
+	errChan := make(chan error, 1)
+
+	d.goTracked(func() {
+		if err := sendFn(ctx); err != nil {
 			errChan <- fmt.Errorf("failed to send message: %w", err)
 		} else {
 			errChan <- nil
 		}
-	}()
+	})
 
 	select {
 	case <-ctx.Done():
-		sender.Metric.IncSendMessageFailureCount()
+		d.recordSend(ctx, SendOperationMessage, 1, start, ctx.Err())
 		return fmt.Errorf("failed to send message: %w", ctx.Err())
 	case err := <-errChan:
-		if err == nil {
-			sender.Metric.IncSendMessageSuccessCount()
-		} else {
-			sender.Metric.IncSendMessageFailureCount()
-		}
+		d.recordSend(ctx, SendOperationMessage, 1, start, err)
 		return err
 	}
 
@@ -104,19 +324,34 @@ func (d *Sender) ToServiceBusMessage(
 	ctx context.Context,
 	mb MessageBody,
 	options ...func(msg *azservicebus.Message) error) (*azservicebus.Message, error) {
-	// uses a marshaller to marshal the message into a service bus message
-	msg, err := d.options.Marshaller.Marshal(mb)
-	if err != nil {
-		return nil, fmt.Errorf("failed to marshal original struct into ServiceBus message: %w", err)
+	msg, msgType, ok := d.rawMessage(mb)
+	if !ok {
+		// uses a marshaller to marshal the message into a service bus message
+		marshaller := d.marshaller(mb)
+		var err error
+		msg, err = marshaller.Marshal(mb)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal original struct into ServiceBus message: %w", err)
+		}
+		// custom marshallers may leave the content type to the sender
+		if msg.ContentType == nil {
+			if contentType := marshaller.ContentType(); contentType != "" {
+				msg.ContentType = &contentType
+			}
+		}
+		msgType = getMessageType(mb)
 	}
-	msgType := getMessageType(mb)
 	msg.ApplicationProperties = map[string]interface{}{msgTypeField: msgType}
-
-	if d.options.EnableTracingPropagation {
-		options = append(options, WithTracePropagation(ctx))
+	if id := d.options.MessageIDGenerator(mb, msg); id != "" {
+		msg.MessageID = &id
+	}
+	if d.options.PartitionKeyExtractor != nil {
+		if key := d.options.PartitionKeyExtractor(mb); key != "" {
+			msg.PartitionKey = &key
+		}
 	}
 
-	for _, option := range options {
+	for _, option := range d.messageOptions(ctx, options) {
 		if err := option(msg); err != nil {
 			return nil, fmt.Errorf("failed to run message options: %w", err)
 		}
@@ -124,46 +359,252 @@ func (d *Sender) ToServiceBusMessage(
 	return msg, nil
 }
 
+// messageOptions returns the options followed by the ones enabled on the sender.
+// The options are copied, so that the caller's slice is never appended to, for example when it is reused
+// across the messages of a batch.
+func (d *Sender) messageOptions(ctx context.Context, options []func(msg *azservicebus.Message) error) []func(msg *azservicebus.Message) error {
+	all := make([]func(msg *azservicebus.Message) error, len(options), len(options)+5)
+	copy(all, options)
+	if d.options.EnableTracingPropagation {
+		all = append(all, WithTracePropagation(ctx), WithSchedulingTracePropagation(ctx, d.options.Clock))
+	}
+	if d.options.EnableDeadlinePropagation {
+		all = append(all, SetDeadlineFromContext(ctx))
+	}
+	if d.options.HostInfo != nil {
+		all = append(all, SetProducerHostInfo(*d.options.HostInfo))
+	}
+	if d.options.Signing != nil {
+		all = append(all, SignMessage(*d.options.Signing))
+	}
+	return all
+}
+
+// rawProtoMarshaller marshals the proto.Message bodies of the senders with RawBodies.
+var rawProtoMarshaller = &DefaultProtoMarshaller{}
+
+// marshaller returns the marshaller of the body: the protobuf wire format for the proto.Message bodies
+// with RawBodies, the configured Marshaller otherwise.
+func (d *Sender) marshaller(mb MessageBody) Marshaller {
+	if _, ok := mb.(proto.Message); ok && d.options.RawBodies {
+		return rawProtoMarshaller
+	}
+	return d.options.Marshaller
+}
+
+// rawMessage is the fast path for the PreMarshalledBody, and for the []byte and json.RawMessage bodies with RawBodies,
+// used as the message body as-is, skipping the marshaller and the reflection on the body type.
+// Without RawBodies, the []byte and json.RawMessage bodies go through the marshaller, which may encode or encrypt them.
+func (d *Sender) rawMessage(mb MessageBody) (*azservicebus.Message, string, bool) {
+	var body []byte
+	var msgType, contentType string
+	switch b := mb.(type) {
+	case []byte:
+		if !d.options.RawBodies {
+			return nil, "", false
+		}
+		body = b
+	case json.RawMessage:
+		if !d.options.RawBodies {
+			return nil, "", false
+		}
+		body, msgType, contentType = b, "RawMessage", jsonContentType
+	case PreMarshalledBody:
+		body, msgType, contentType = b.Body, b.MessageType, b.ContentType
+	case *PreMarshalledBody:
+		body, msgType, contentType = b.Body, b.MessageType, b.ContentType
+	default:
+		return nil, "", false
+	}
+	if contentType == "" {
+		contentType = d.options.Marshaller.ContentType()
+	}
+	return &azservicebus.Message{Body: body, ContentType: &contentType}, msgType, true
+}
+
 // SendMessageBatch sends the array of azservicebus messages as a batch.
+// It returns a *BatchAddError identifying the first message that cannot be added to the batch,
+// unless WithSkipUnbatchableMessages is used. The messages are checked against the maximum message size
+// like with SendMessage, a message too large being a *BatchAddError wrapping the *ErrMessageTooLarge.
 func (d *Sender) SendMessageBatch(ctx context.Context, messages []*azservicebus.Message) error {
+	if err := d.begin(ctx); err != nil {
+		return err
+	}
+	defer d.inflight.Done()
 	batch, err := d.sbSender.NewMessageBatch(ctx, &azservicebus.MessageBatchOptions{})
 	if err != nil {
 		return err
 	}
-	for _, msg := range messages {
-		if err := batch.AddMessage(msg, nil); err != nil {
-			return err
+	var addErrs []error
+	added := 0
+	for i, msg := range messages {
+		checked, err := d.checkSize(ctx, msg)
+		if err == nil {
+			msg = checked
+			err = batch.AddMessage(msg, nil)
 		}
+		if err != nil {
+			addErr := &BatchAddError{Index: i, Size: estimateMessageSize(msg), Err: err}
+			if !d.options.SkipUnbatchableMessages {
+				return addErr
+			}
+			log(ctx, fmt.Sprintf("skipping message: %s", addErr))
+			addErrs = append(addErrs, addErr)
+			continue
+		}
+		added++
+		sender.Metric.ObserveMessageSize(d.options.EntityName, len(msg.Body))
+	}
+	if added == 0 && len(addErrs) > 0 {
+		return joinErrors(addErrs...)
 	}
-	if d.options.SendTimeout > 0 {
-		var cancel func()
-		ctx, cancel = context.WithTimeout(ctx, d.options.SendTimeout)
-		defer cancel()
+	sender.Metric.ObserveBatchSize(d.options.EntityName, added)
+	if err := d.sendBatch(ctx, batch, added); err != nil || len(addErrs) > 0 {
+		return joinErrors(append([]error{err}, addErrs...)...)
 	}
+	return nil
+}
 
-	errChan := make(chan error)
+// joinErrors joins the non-nil errors, returning a single error as is so that its text is unchanged.
+func joinErrors(errs ...error) error {
+	var nonNil []error
+	for _, err := range errs {
+		if err != nil {
+			nonNil = append(nonNil, err)
+		}
+	}
+	if len(nonNil) == 1 {
+		return nonNil[0]
+	}
+	return errors.Join(nonNil...)
+}
 
-	go func() {
+// sendBatch sends the batch of messageCount messages with the send timeout, the backpressure and the metrics.
+func (d *Sender) sendBatch(ctx context.Context, batch *azservicebus.MessageBatch, messageCount int) error {
+	if err := d.backpressure.wait(ctx); err != nil {
+		return err
+	}
+	ctx, cancel := d.withSendTimeout(ctx)
+	defer cancel()
+	start := time.Now()
+
+	errChan := make(chan error, 1)
+
+	d.goTracked(func() {
 		if err := d.sbSender.SendMessageBatch(ctx, batch, nil); err != nil {
 			errChan <- fmt.Errorf("failed to send message batch: %w", err)
 		} else {
 			errChan <- nil
 		}
-	}()
+	})
 
 	select {
 	case <-ctx.Done():
-		sender.Metric.IncSendMessageFailureCount()
+		d.recordSend(ctx, SendOperationBatch, messageCount, start, ctx.Err())
 		return fmt.Errorf("failed to send message batch: %w", ctx.Err())
 	case err := <-errChan:
-		if err == nil {
-			sender.Metric.IncSendMessageSuccessCount()
-		} else {
-			sender.Metric.IncSendMessageFailureCount()
+		d.recordSend(ctx, SendOperationBatch, messageCount, start, err)
+		return err
+	}
+
+}
+
+// BatchAddError is returned by SendMessageBatch when a message cannot be added to the batch,
+// for example because it does not fit in the space left by the previous messages.
+type BatchAddError struct {
+	// Index is the index of the message in the messages passed to SendMessageBatch.
+	Index int
+	// Size is the estimated size of the message in bytes.
+	Size int
+	// Err is the error returned by the batch.
+	Err error
+}
+
+func (e *BatchAddError) Error() string {
+	return fmt.Sprintf("failed to add message %d of %d bytes to batch: %s", e.Index, e.Size, e.Err)
+}
+
+func (e *BatchAddError) Unwrap() error {
+	return e.Err
+}
+
+// WithSkipUnbatchableMessages makes SendMessageBatch skip the messages that cannot be added to the batch and send the others.
+// The *BatchAddError of the skipped messages are returned joined, after the batch is sent.
+func WithSkipUnbatchableMessages() SenderOption {
+	return func(options *SenderOptions) {
+		options.SkipUnbatchableMessages = true
+	}
+}
+
+// SendMessageBodies marshals the bodies into messages with ToServiceBusMessage, applying the same options to each of them,
+// and sends them in one batch with SendMessageBatch. It fails before sending when two messages get the same MessageID,
+// as the duplicate detection of the entity would silently drop all but one, for example when SetMessageId is passed.
+func (d *Sender) SendMessageBodies(ctx context.Context, bodies []MessageBody, options ...func(msg *azservicebus.Message) error) error {
+	messages := make([]*azservicebus.Message, 0, len(bodies))
+	messageIDs := map[string]int{}
+	for i, mb := range bodies {
+		msg, err := d.ToServiceBusMessage(ctx, mb, options...)
+		if err != nil {
+			return fmt.Errorf("failed to build message %d of the batch: %w", i, err)
 		}
+		if msg.MessageID != nil {
+			if first, ok := messageIDs[*msg.MessageID]; ok {
+				return fmt.Errorf("messages %d and %d of the batch have the same message id %q", first, i, *msg.MessageID)
+			}
+			messageIDs[*msg.MessageID] = i
+		}
+		messages = append(messages, msg)
+	}
+	return d.SendMessageBatch(ctx, messages)
+}
+
+// SendMessageBatchGrouped sends the messages in one batch per partition key.
+// Partitioned entities require all the messages of a batch to share the same partition key,
+// so the messages are grouped by SessionID, or PartitionKey when SessionID is not set.
+// Messages without any key are sent together. The order of the messages is preserved within each group.
+// It stops at the first batch that fails to be sent: the batches sent before are not rolled back.
+func (d *Sender) SendMessageBatchGrouped(ctx context.Context, messages []*azservicebus.Message) error {
+	groups, err := groupByPartitionKey(messages)
+	if err != nil {
 		return err
 	}
+	for _, group := range groups {
+		if err := d.SendMessageBatch(ctx, group.messages); err != nil {
+			return fmt.Errorf("failed to send message batch for partition key %q: %w", group.key, err)
+		}
+	}
+	return nil
+}
 
+type partitionGroup struct {
+	key      string
+	messages []*azservicebus.Message
+}
+
+// groupByPartitionKey groups the messages by partition key, in order of first appearance.
+func groupByPartitionKey(messages []*azservicebus.Message) ([]*partitionGroup, error) {
+	var groups []*partitionGroup
+	byKey := map[string]*partitionGroup{}
+	for _, msg := range messages {
+		key := ""
+		switch {
+		case msg.SessionID != nil:
+			if msg.PartitionKey != nil && *msg.PartitionKey != *msg.SessionID {
+				return nil, fmt.Errorf("message partition key %q must match its session id %q", *msg.PartitionKey, *msg.SessionID)
+			}
+			key = *msg.SessionID
+		case msg.PartitionKey != nil:
+			key = *msg.PartitionKey
+		}
+		group, ok := byKey[key]
+		if !ok {
+			group = &partitionGroup{key: key}
+			byKey[key] = group
+			groups = append(groups, group)
+		}
+		group.messages = append(group.messages, msg)
+	}
+	return groups, nil
 }
 
 func (d *Sender) ScheduleMessages(
@@ -171,75 +612,199 @@ func (d *Sender) ScheduleMessages(
 	msgs []*azservicebus.Message,
 	scheduledEnqueueTime time.Time,
 ) ([]int64, error) {
-	if d.options.SendTimeout > 0 {
-		var cancel func()
-		ctx, cancel = context.WithTimeout(ctx, d.options.SendTimeout)
-		defer cancel()
+	if err := d.begin(ctx); err != nil {
+		return nil, err
+	}
+	defer d.inflight.Done()
+	checked := make([]*azservicebus.Message, len(msgs))
+	for i, msg := range msgs {
+		msg, err := d.checkSize(ctx, msg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to schedule message %d: %w", i, err)
+		}
+		checked[i] = msg
+	}
+	msgs = checked
+	for _, msg := range msgs {
+		sender.Metric.ObserveMessageSize(d.options.EntityName, len(msg.Body))
+	}
+	if d.options.EnableTracingPropagation {
+		scheduledAt := clockOrDefault(d.options.Clock).Now()
+		for _, msg := range msgs {
+			shuttleotel.InjectScheduling(ctx, msg, scheduledAt)
+		}
+	}
+	if d.options.Signing != nil {
+		for _, msg := range msgs {
+			if err := SignMessage(*d.options.Signing)(msg); err != nil {
+				return nil, fmt.Errorf("failed to schedule messages: %w", err)
+			}
+		}
+	}
+	if err := d.backpressure.wait(ctx); err != nil {
+		return nil, err
 	}
+	ctx, cancel := d.withSendTimeout(ctx)
+	defer cancel()
+	start := time.Now()
 
 	type result struct {
 		sequenceNumbers []int64
 		err             error
 	}
-	resultChan := make(chan result)
+	resultChan := make(chan result, 1)
 
-	go func() {
+	d.goTracked(func() {
 		sequenceNumbers, err := d.sbSender.ScheduleMessages(ctx, msgs, scheduledEnqueueTime, nil) // scheduleMessagesOptions currently does nothing
 		if err != nil {
 			resultChan <- result{err: fmt.Errorf("failed to schedule messages: %w", err)}
 		} else {
 			resultChan <- result{sequenceNumbers: sequenceNumbers}
 		}
-	}()
+	})
 
 	select {
 	case <-ctx.Done():
-		sender.Metric.IncSendMessageFailureCount()
+		d.recordSend(ctx, SendOperationSchedule, len(msgs), start, ctx.Err())
 		return nil, fmt.Errorf("failed to schedule messages: %w", ctx.Err())
 	case res := <-resultChan:
-		if res.err == nil {
-			sender.Metric.IncSendMessageSuccessCount()
-		} else {
-			sender.Metric.IncSendMessageFailureCount()
-		}
+		d.recordSend(ctx, SendOperationSchedule, len(msgs), start, res.err)
 		return res.sequenceNumbers, res.err
 	}
 
 }
 
 func (d *Sender) CancelScheduledMessages(ctx context.Context, sequenceNumbers []int64) error {
-	// SendTimeout is used here as a time constraint to send the cancel schedule messages request
-	if d.options.SendTimeout > 0 {
-		var cancel func()
-		ctx, cancel = context.WithTimeout(ctx, d.options.SendTimeout)
-		defer cancel()
+	if err := d.begin(ctx); err != nil {
+		return err
 	}
+	defer d.inflight.Done()
+	// SendTimeout is used here as a time constraint to send the cancel schedule messages request
+	ctx, cancel := d.withSendTimeout(ctx)
+	defer cancel()
+	start := time.Now()
 
-	errChan := make(chan error)
+	errChan := make(chan error, 1)
 
-	go func() {
+	d.goTracked(func() {
 		if err := d.sbSender.CancelScheduledMessages(ctx, sequenceNumbers, nil); err != nil { // cancelScheduledMessagesOptions currently does nothing
 			errChan <- fmt.Errorf("failed to cancel scheduled messages: %w", err)
 		} else {
 			errChan <- nil
 		}
-	}()
+	})
 
 	select {
 	case <-ctx.Done():
-		sender.Metric.IncSendMessageFailureCount()
+		d.recordSend(ctx, SendOperationCancelSchedule, len(sequenceNumbers), start, ctx.Err())
 		return fmt.Errorf("failed to cancel scheduled messages: %w", ctx.Err())
 	case err := <-errChan:
-		if err == nil {
-			sender.Metric.IncSendMessageSuccessCount()
-		} else {
-			sender.Metric.IncSendMessageFailureCount()
-		}
+		d.recordSend(ctx, SendOperationCancelSchedule, len(sequenceNumbers), start, err)
 		return err
 	}
 
 }
 
+// Close stops accepting sends and waits for the in-flight send operations to finish, or for ctx to be done.
+// The sends started after Close return ErrSenderClosed.
+// When SenderOptions.CloseAzSender is set, the underlying AzServiceBusSender is closed once the sends are drained,
+// or when ctx is done, aborting the remaining ones.
+func (d *Sender) Close(ctx context.Context) error {
+	d.closeMu.Lock()
+	d.closed = true
+	d.closeMu.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		d.inflight.Wait()
+		close(drained)
+	}()
+	var drainErr error
+	select {
+	case <-ctx.Done():
+		drainErr = fmt.Errorf("failed to drain in-flight sends: %w", ctx.Err())
+	case <-drained:
+	}
+	if d.options.CloseAzSender {
+		if closer, ok := d.sbSender.(interface {
+			Close(ctx context.Context) error
+		}); ok {
+			if err := closer.Close(ctx); err != nil {
+				return fmt.Errorf("failed to close sender: %w", err)
+			}
+		}
+	}
+	return drainErr
+}
+
+// begin registers an in-flight send operation, unless the sender is closed.
+// The caller must call d.inflight.Done() when the operation returns.
+func (d *Sender) begin(ctx context.Context) error {
+	d.guard.check(ctx, "Sender")
+	d.closeMu.RLock()
+	defer d.closeMu.RUnlock()
+	if d.closed {
+		return ErrSenderClosed
+	}
+	d.inflight.Add(1)
+	return nil
+}
+
+// goTracked runs f in a goroutine that Close waits for.
+// It must be called during an operation registered with begin, so that the WaitGroup counter is not zero.
+func (d *Sender) goTracked(f func()) {
+	d.inflight.Add(1)
+	go func() {
+		defer d.inflight.Done()
+		f()
+	}()
+}
+
+// recordSend records the outcome and latency of a send operation started at start, and reports it to the OnSendAttempt hook.
+func (d *Sender) recordSend(ctx context.Context, operation string, messageCount int, start time.Time, err error) {
+	duration := time.Since(start)
+	throttled := isThrottlingError(err)
+	sender.Metric.ObserveSendLatency(d.options.EntityName, duration, err == nil)
+	d.backpressure.record(err)
+	d.options.Hooks.sendAttempt(ctx, SendAttemptEvent{
+		Entity:       d.options.EntityName,
+		Operation:    operation,
+		MessageCount: messageCount,
+		Duration:     duration,
+		Throttled:    throttled,
+		Err:          err,
+	})
+	if err == nil {
+		sender.Metric.IncSendMessageSuccessCount()
+		return
+	}
+	sender.Metric.IncSendMessageFailureCount()
+	if throttled {
+		sender.Metric.IncThrottledCount(d.options.EntityName)
+	}
+}
+
+// isThrottlingError returns true when the namespace rejected the operation because it is throttling the sender.
+// azservicebus.Error has no code for throttling and the sdk returns the *amqp.Error as is,
+// so the AMQP error condition is matched on the typed error instead of the error message.
+func isThrottlingError(err error) bool {
+	var amqpErr *amqp.Error
+	return errors.As(err, &amqpErr) && amqpErr.Condition == serverBusyCondition
+}
+
+// withSendTimeout applies the SendTimeout, measured on the sender's clock, to the context if enabled.
+func (d *Sender) withSendTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if d.options.SendTimeout <= 0 {
+		return ctx, func() {}
+	}
+	return withClockTimeout(ctx, clockOrDefault(d.options.Clock), d.options.SendTimeout)
+}
+
+// Backpressure reports the recent throttling of the namespace, for the producers to slow down or shed load.
+func (d *Sender) Backpressure() Backpressure {
+	return d.backpressure.state()
+}
+
 // AzSender returns the underlying azservicebus.Sender instance.
 func (d *Sender) AzSender() AzServiceBusSender {
 	return d.sbSender
@@ -271,8 +836,13 @@ func SetScheduleAt(t time.Time) func(msg *azservicebus.Message) error {
 
 // SetMessageDelay schedules a message in the future
 func SetMessageDelay(delay time.Duration) func(msg *azservicebus.Message) error {
+	return SetMessageDelayWithClock(systemClock{}, delay)
+}
+
+// SetMessageDelayWithClock schedules a message in the future, relative to the clock's current time
+func SetMessageDelayWithClock(clock Clock, delay time.Duration) func(msg *azservicebus.Message) error {
 	return func(msg *azservicebus.Message) error {
-		newTime := time.Now().Add(delay)
+		newTime := clock.Now().Add(delay)
 		msg.ScheduledEnqueueTime = &newTime
 		return nil
 	}
@@ -286,14 +856,50 @@ func SetMessageTTL(ttl time.Duration) func(msg *azservicebus.Message) error {
 	}
 }
 
+// messageTypeNames caches the message type name of each body type, to avoid the reflection on every send.
+var messageTypeNames sync.Map
+
 func getMessageType(mb MessageBody) string {
-	var msgType string
-	vo := reflect.ValueOf(mb)
-	if vo.Kind() == reflect.Ptr {
-		msgType = reflect.Indirect(vo).Type().Name()
-	} else {
-		msgType = vo.Type().Name()
+	if mb == nil {
+		return ""
+	}
+	t := reflect.TypeOf(mb)
+	if name, ok := messageTypeNames.Load(t); ok {
+		return name.(string)
+	}
+	name := messageTypeName(t)
+	messageTypeNames.Store(t, name)
+	return name
+}
+
+// messageTypeName returns the name of the type, or of the type pointed to.
+// The package paths of the generic type arguments are trimmed: Event[github.com/org/pkg.Order] is named Event[Order].
+// Unnamed types, like anonymous structs, are named after their type literal instead of an empty name.
+func messageTypeName(t reflect.Type) string {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	name := t.Name()
+	if name == "" {
+		return t.String()
+	}
+	if !strings.Contains(name, "[") {
+		return name
 	}
+	var b strings.Builder
+	start := 0
+	for i, r := range name {
+		switch r {
+		case '[', ']', ',', '*', ' ':
+			b.WriteString(trimPackagePath(name[start:i]))
+			b.WriteRune(r)
+			start = i + 1
+		}
+	}
+	b.WriteString(trimPackagePath(name[start:]))
+	return b.String()
+}
 
-	return msgType
+func trimPackagePath(identifier string) string {
+	return identifier[strings.LastIndex(identifier, ".")+1:]
 }
@@ -2,8 +2,12 @@ package shuttle
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"reflect"
+	"runtime"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -11,7 +15,10 @@ import (
 
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
 	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	"github.com/Azure/go-amqp"
 	. "github.com/onsi/gomega"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
 )
 
 func TestFunc_NewSender(t *testing.T) {
@@ -23,6 +30,26 @@ func TestFunc_NewSender(t *testing.T) {
 	}
 }
 
+func TestFunc_NewSenderWithOptions(t *testing.T) {
+	g := NewWithT(t)
+	sender := NewSenderWithOptions(nil)
+	g.Expect(sender.options.Marshaller).To(BeAssignableToTypeOf(&DefaultJSONMarshaller{}))
+	g.Expect(sender.options.SendTimeout).To(Equal(defaultSendTimeout))
+	g.Expect(sender.options.EnableTracingPropagation).To(BeFalse())
+
+	marshaller := &DefaultProtoMarshaller{}
+	clock := &systemClock{}
+	sender = NewSenderWithOptions(nil,
+		WithMarshaller(marshaller),
+		WithSendTimeout(5*time.Second),
+		WithSenderTracePropagation(),
+		WithSenderClock(clock))
+	g.Expect(sender.options.Marshaller).To(BeIdenticalTo(marshaller))
+	g.Expect(sender.options.SendTimeout).To(Equal(5 * time.Second))
+	g.Expect(sender.options.EnableTracingPropagation).To(BeTrue())
+	g.Expect(sender.options.Clock).To(BeIdenticalTo(clock))
+}
+
 func TestHandlers_SetMessageId(t *testing.T) {
 	randId := "testmessageid"
 
@@ -218,6 +245,35 @@ func TestSender_SendMessage(t *testing.T) {
 	g.Expect(err).To(And(HaveOccurred(), MatchError(azSender.SendMessageErr)))
 }
 
+func TestSender_SendAzMessage(t *testing.T) {
+	g := NewWithT(t)
+	azSender := &fakeAzSender{}
+	sender := NewSender(azSender, &SenderOptions{
+		EnableTracingPropagation: true,
+		Marshaller:               &DefaultJSONMarshaller{},
+	})
+	tp := trace.NewTracerProvider(trace.WithSampler(trace.AlwaysSample()))
+	ctx, span := tp.Tracer("testTracer").Start(context.Background(), "forward")
+	defer span.End()
+
+	msg := &azservicebus.Message{Body: []byte("raw body")}
+	err := sender.SendAzMessage(ctx, msg, SetMessageId(to.Ptr("messageID")))
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(azSender.SendMessageReceivedValue).To(BeIdenticalTo(msg))
+	g.Expect(string(msg.Body)).To(Equal("raw body"), "the body is not marshalled")
+	g.Expect(*msg.MessageID).To(Equal("messageID"))
+	g.Expect(msg.ApplicationProperties["traceparent"]).ToNot(BeNil())
+
+	err = sender.SendAzMessage(ctx, &azservicebus.Message{}, func(msg *azservicebus.Message) error {
+		return fmt.Errorf("option failure")
+	})
+	g.Expect(err).To(MatchError(ContainSubstring("option failure")))
+
+	azSender.SendMessageErr = fmt.Errorf("msg send failure")
+	err = sender.SendAzMessage(ctx, &azservicebus.Message{})
+	g.Expect(err).To(MatchError(azSender.SendMessageErr))
+}
+
 func TestSender_SendMessageBatch(t *testing.T) {
 	g := NewWithT(t)
 	azSender := &fakeAzSender{
@@ -229,6 +285,66 @@ func TestSender_SendMessageBatch(t *testing.T) {
 	err = sender.SendMessageBatch(context.Background(), []*azservicebus.Message{msg})
 	g.Expect(err).To(HaveOccurred())
 	// No way to create a MessageBatch struct with a non-0 max bytes in test, so the best we can do is expect an error.
+	var addErr *BatchAddError
+	g.Expect(errors.As(err, &addErr)).To(BeTrue())
+	g.Expect(addErr.Index).To(Equal(0))
+	g.Expect(addErr.Size).To(BeNumerically(">", 0))
+	g.Expect(errors.Is(err, azservicebus.ErrMessageTooLarge)).To(BeTrue())
+}
+
+func TestSender_SendMessageBatchSkipUnbatchable(t *testing.T) {
+	g := NewWithT(t)
+	azSender := &fakeAzSender{NewMessageBatchReturnValue: &azservicebus.MessageBatch{}}
+	sender := NewSenderWithOptions(azSender, WithSkipUnbatchableMessages())
+	first, err := sender.ToServiceBusMessage(context.Background(), "first")
+	g.Expect(err).ToNot(HaveOccurred())
+	second, err := sender.ToServiceBusMessage(context.Background(), "second")
+	g.Expect(err).ToNot(HaveOccurred())
+
+	// none of the messages fit in the test batch: all are reported, and no batch is sent
+	err = sender.SendMessageBatch(context.Background(), []*azservicebus.Message{first, second})
+	g.Expect(err).To(MatchError(And(
+		ContainSubstring("failed to add message 0 of"),
+		ContainSubstring("failed to add message 1 of"))))
+	g.Expect(azSender.SendMessageBatchCalled).To(BeFalse())
+
+	// a single skipped message is returned as is
+	err = sender.SendMessageBatch(context.Background(), []*azservicebus.Message{first})
+	var addErr *BatchAddError
+	g.Expect(errors.As(err, &addErr)).To(BeTrue())
+	g.Expect(err).To(BeIdenticalTo(addErr))
+}
+
+func TestJoinErrors(t *testing.T) {
+	g := NewWithT(t)
+	first, second := errors.New("first"), errors.New("second")
+	g.Expect(joinErrors()).To(BeNil())
+	g.Expect(joinErrors(nil, nil)).To(BeNil())
+	g.Expect(joinErrors(nil, first)).To(BeIdenticalTo(first))
+	g.Expect(joinErrors(first, nil, second)).To(MatchError("first\nsecond"))
+}
+
+func TestSender_SendMessageBodies(t *testing.T) {
+	g := NewWithT(t)
+	azSender := &fakeAzSender{NewMessageBatchReturnValue: &azservicebus.MessageBatch{}}
+	sender := NewSenderWithOptions(azSender, WithSenderTracePropagation())
+
+	var applied []string
+	// spare capacity, to check that the sender options are not appended to it
+	options := make([]func(msg *azservicebus.Message) error, 1, 4)
+	options[0] = func(msg *azservicebus.Message) error {
+		applied = append(applied, string(msg.Body))
+		return nil
+	}
+	err := sender.SendMessageBodies(context.Background(), []MessageBody{"a", "b"}, options...)
+	g.Expect(applied).To(Equal([]string{`"a"`, `"b"`}))
+	g.Expect(options[:cap(options)][1]).To(BeNil())
+	// No way to create a MessageBatch struct with a non-0 max bytes in test, so the best we can do is expect an error.
+	g.Expect(err).To(HaveOccurred())
+
+	err = sender.SendMessageBodies(context.Background(), []MessageBody{"a", "b"}, SetMessageId(to.Ptr("same")))
+	g.Expect(err).To(MatchError(ContainSubstring(`messages 0 and 1 of the batch have the same message id "same"`)))
+	g.Expect(azSender.SendMessageBatchCalled).To(BeFalse())
 }
 
 func TestSender_ScheduledMessages(t *testing.T) {
@@ -366,3 +482,349 @@ func (f *fakeAzSender) CancelScheduledMessages(
 	f.CancelScheduledMessagesReceivedValue = sequenceNumbers
 	return f.CancelScheduledMessagesErr
 }
+
+func TestSender_SendMessageBatchGrouped(t *testing.T) {
+	g := NewWithT(t)
+	azSender := &fakeAzSender{NewMessageBatchErr: fmt.Errorf("msg batch failure")}
+	sender := NewSender(azSender, nil)
+	err := sender.SendMessageBatchGrouped(context.Background(), []*azservicebus.Message{
+		{PartitionKey: to.Ptr("a")},
+		{PartitionKey: to.Ptr("b")},
+	})
+	g.Expect(err).To(MatchError(ContainSubstring(`partition key "a"`)))
+	g.Expect(err).To(MatchError(ContainSubstring("msg batch failure")))
+
+	err = sender.SendMessageBatchGrouped(context.Background(), []*azservicebus.Message{
+		{PartitionKey: to.Ptr("a"), SessionID: to.Ptr("b")},
+	})
+	g.Expect(err).To(MatchError(ContainSubstring("must match its session id")))
+	g.Expect(sender.SendMessageBatchGrouped(context.Background(), nil)).To(Succeed())
+}
+
+func TestGroupByPartitionKey(t *testing.T) {
+	g := NewWithT(t)
+	m1 := &azservicebus.Message{PartitionKey: to.Ptr("a")}
+	m2 := &azservicebus.Message{SessionID: to.Ptr("b")}
+	m3 := &azservicebus.Message{}
+	m4 := &azservicebus.Message{PartitionKey: to.Ptr("b"), SessionID: to.Ptr("b")}
+	m5 := &azservicebus.Message{PartitionKey: to.Ptr("a")}
+	groups, err := groupByPartitionKey([]*azservicebus.Message{m1, m2, m3, m4, m5})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(groups).To(HaveLen(3))
+	g.Expect(groups[0].key).To(Equal("a"))
+	g.Expect(groups[0].messages).To(Equal([]*azservicebus.Message{m1, m5}))
+	g.Expect(groups[1].key).To(Equal("b"))
+	g.Expect(groups[1].messages).To(Equal([]*azservicebus.Message{m2, m4}))
+	g.Expect(groups[2].key).To(Equal(""))
+	g.Expect(groups[2].messages).To(Equal([]*azservicebus.Message{m3}))
+}
+
+func TestSender_WithPartitionKeyFromBody(t *testing.T) {
+	g := NewWithT(t)
+	type order struct{ OrderID string }
+	sender := NewSenderWithOptions(nil, WithPartitionKeyFromBody(func(mb MessageBody) string {
+		if o, ok := mb.(order); ok {
+			return o.OrderID
+		}
+		return ""
+	}))
+	msg, err := sender.ToServiceBusMessage(context.Background(), order{OrderID: "order-1"})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(*msg.PartitionKey).To(Equal("order-1"))
+
+	msg, err = sender.ToServiceBusMessage(context.Background(), "not an order")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(msg.PartitionKey).To(BeNil())
+
+	msg, err = sender.ToServiceBusMessage(context.Background(), order{OrderID: "order-1"}, func(msg *azservicebus.Message) error {
+		msg.PartitionKey = to.Ptr("explicit")
+		return nil
+	})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(*msg.PartitionKey).To(Equal("explicit"))
+}
+
+func TestIsThrottlingError(t *testing.T) {
+	g := NewWithT(t)
+	g.Expect(isThrottlingError(nil)).To(BeFalse())
+	g.Expect(isThrottlingError(fmt.Errorf("failed to send message: %w", errServerBusy))).To(BeTrue())
+	g.Expect(isThrottlingError(&amqp.Error{Condition: amqp.ErrCondResourceLimitExceeded})).To(BeFalse())
+	g.Expect(isThrottlingError(fmt.Errorf("*Error{Condition: com.microsoft:server-busy}"))).To(BeFalse())
+	g.Expect(isThrottlingError(fmt.Errorf("connection lost"))).To(BeFalse())
+}
+
+type closableAzSender struct {
+	fakeAzSender
+	closed atomic.Bool
+}
+
+func (c *closableAzSender) Close(_ context.Context) error {
+	c.closed.Store(true)
+	return nil
+}
+
+func TestSender_CloseWaitsForInFlightSends(t *testing.T) {
+	g := NewWithT(t)
+	release := make(chan struct{})
+	started := make(chan struct{})
+	azSender := &closableAzSender{fakeAzSender: fakeAzSender{
+		DoSendMessage: func(ctx context.Context, message *azservicebus.Message, options *azservicebus.SendMessageOptions) error {
+			close(started)
+			<-release
+			return nil
+		},
+	}}
+	sender := NewSenderWithOptions(azSender, WithCloseAzSender())
+	sendErr := make(chan error)
+	go func() { sendErr <- sender.SendMessage(context.Background(), "test") }()
+	<-started
+
+	closeErr := make(chan error)
+	go func() { closeErr <- sender.Close(context.Background()) }()
+	g.Consistently(closeErr, 100*time.Millisecond).ShouldNot(Receive())
+	g.Expect(sender.SendMessage(context.Background(), "rejected")).To(MatchError(ErrSenderClosed))
+	g.Expect(sender.CancelScheduledMessages(context.Background(), []int64{1})).To(MatchError(ErrSenderClosed))
+
+	close(release)
+	g.Eventually(sendErr).Should(Receive(BeNil()))
+	g.Eventually(closeErr).Should(Receive(BeNil()))
+	g.Expect(azSender.closed.Load()).To(BeTrue())
+}
+
+func TestSender_CloseTimesOut(t *testing.T) {
+	g := NewWithT(t)
+	release := make(chan struct{})
+	defer close(release)
+	started := make(chan struct{})
+	azSender := &closableAzSender{fakeAzSender: fakeAzSender{
+		DoSendMessage: func(ctx context.Context, message *azservicebus.Message, options *azservicebus.SendMessageOptions) error {
+			close(started)
+			<-release
+			return nil
+		},
+	}}
+	sender := NewSender(azSender, nil)
+	go func() { _ = sender.SendMessage(context.Background(), "test") }()
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	g.Expect(sender.Close(ctx)).To(MatchError(context.DeadlineExceeded))
+	g.Expect(azSender.closed.Load()).To(BeFalse(), "the az sender is not owned by default")
+}
+
+func TestSender_NoGoroutineLeakOnTimeout(t *testing.T) {
+	g := NewWithT(t)
+	azSender := &blockingAzSender{}
+	sender := NewSender(azSender, &SenderOptions{
+		Marshaller:  &DefaultJSONMarshaller{},
+		SendTimeout: 10 * time.Millisecond,
+	})
+	baseline := runtime.NumGoroutine()
+	for i := 0; i < 20; i++ {
+		g.Expect(sender.SendMessage(context.Background(), "test")).To(MatchError(context.DeadlineExceeded))
+		_, err := sender.ScheduleMessages(context.Background(), nil, time.Now())
+		g.Expect(err).To(MatchError(context.DeadlineExceeded))
+		g.Expect(sender.CancelScheduledMessages(context.Background(), []int64{1})).To(MatchError(context.DeadlineExceeded))
+	}
+	g.Eventually(runtime.NumGoroutine).Should(BeNumerically("<=", baseline))
+	g.Expect(sender.Close(context.Background())).To(Succeed())
+}
+
+// blockingAzSender blocks the operations until their context is done, like the sdk does for an unresponsive namespace.
+type blockingAzSender struct {
+	AzServiceBusSender
+}
+
+func (b *blockingAzSender) SendMessage(ctx context.Context, _ *azservicebus.Message, _ *azservicebus.SendMessageOptions) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (b *blockingAzSender) ScheduleMessages(ctx context.Context, _ []*azservicebus.Message, _ time.Time, _ *azservicebus.ScheduleMessagesOptions) ([]int64, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (b *blockingAzSender) CancelScheduledMessages(ctx context.Context, _ []int64, _ *azservicebus.CancelScheduledMessagesOptions) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestSender_ToServiceBusMessageRawBodies(t *testing.T) {
+	g := NewWithT(t)
+	sender := NewSender(nil, nil)
+
+	// the bytes go through the marshaller, which encodes them as a JSON string
+	msg, err := sender.ToServiceBusMessage(context.Background(), []byte("raw"))
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(msg.Body).To(Equal([]byte(`"cmF3"`)))
+	g.Expect(*msg.ContentType).To(Equal(jsonContentType))
+
+	msg, err = sender.ToServiceBusMessage(context.Background(), json.RawMessage(`{"a": 1}`))
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(msg.Body).To(Equal([]byte(`{"a":1}`)))
+
+	msg, err = sender.ToServiceBusMessage(context.Background(),
+		PreMarshalledBody{Body: []byte{0x08, 0x01}, ContentType: protobufContentType, MessageType: "OrderCreated"},
+		SetMessageTTL(time.Minute))
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(msg.Body).To(Equal([]byte{0x08, 0x01}))
+	g.Expect(*msg.ContentType).To(Equal(protobufContentType))
+	g.Expect(msg.ApplicationProperties).To(HaveKeyWithValue(msgTypeField, "OrderCreated"))
+	g.Expect(*msg.TimeToLive).To(Equal(time.Minute))
+}
+
+func TestSender_ToServiceBusMessageWithRawBodies(t *testing.T) {
+	g := NewWithT(t)
+	sender := NewSenderWithOptions(nil, WithRawBodies())
+
+	msg, err := sender.ToServiceBusMessage(context.Background(), []byte("raw"))
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(msg.Body).To(Equal([]byte("raw")))
+	g.Expect(*msg.ContentType).To(Equal(jsonContentType))
+
+	msg, err = sender.ToServiceBusMessage(context.Background(), json.RawMessage(`{"a": 1}`))
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(msg.Body).To(Equal([]byte(`{"a": 1}`)))
+	g.Expect(msg.ApplicationProperties).To(HaveKeyWithValue(msgTypeField, "RawMessage"))
+
+	// the proto.Message bodies use the protobuf wire format instead of the JSON marshaller
+	msg, err = sender.ToServiceBusMessage(context.Background(), wrapperspb.String("hello"))
+	g.Expect(err).ToNot(HaveOccurred())
+	expected, err := proto.Marshal(wrapperspb.String("hello"))
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(msg.Body).To(Equal(expected))
+	g.Expect(*msg.ContentType).To(Equal(protobufContentType))
+	g.Expect(msg.ApplicationProperties).To(HaveKeyWithValue(msgTypeField, "StringValue"))
+
+	// the other bodies still go through the marshaller
+	msg, err = sender.ToServiceBusMessage(context.Background(), "text")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(msg.Body).To(Equal([]byte(`"text"`)))
+}
+
+// textMarshaller leaves the content type of the messages to the sender.
+type textMarshaller struct{}
+
+func (textMarshaller) Marshal(mb MessageBody) (*azservicebus.Message, error) {
+	return &azservicebus.Message{Body: []byte(fmt.Sprint(mb))}, nil
+}
+
+func (textMarshaller) Unmarshal(msg *azservicebus.Message, mb MessageBody) error {
+	return nil
+}
+
+func (textMarshaller) ContentType() string {
+	return "text/plain"
+}
+
+func TestSender_SetsMarshallerContentType(t *testing.T) {
+	g := NewWithT(t)
+	sender := NewSender(nil, &SenderOptions{Marshaller: textMarshaller{}})
+	msg, err := sender.ToServiceBusMessage(context.Background(), "hello")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(msg.ContentType).ToNot(BeNil())
+	g.Expect(*msg.ContentType).To(Equal("text/plain"))
+}
+
+func BenchmarkSender_ToServiceBusMessage(b *testing.B) {
+	type order struct {
+		ID      string
+		Payload []byte
+	}
+	payload := make([]byte, 64*1024)
+	marshalled, err := json.Marshal(order{ID: "order-1", Payload: payload})
+	if err != nil {
+		b.Fatal(err)
+	}
+	sender := NewSender(nil, nil)
+	bodies := map[string]MessageBody{
+		"struct":      order{ID: "order-1", Payload: payload},
+		"bytes":       marshalled,
+		"raw-json":    json.RawMessage(marshalled),
+		"premarshall": PreMarshalledBody{Body: marshalled, MessageType: "order"},
+	}
+	for name, body := range bodies {
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := sender.ToServiceBusMessage(context.Background(), body); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+type orderCreated struct{ ID string }
+
+type envelope[T any] struct{ Payload T }
+
+func TestGetMessageType(t *testing.T) {
+	g := NewWithT(t)
+	g.Expect(getMessageType(orderCreated{})).To(Equal("orderCreated"))
+	g.Expect(getMessageType(&orderCreated{})).To(Equal("orderCreated"))
+	g.Expect(getMessageType((*orderCreated)(nil))).To(Equal("orderCreated"))
+	g.Expect(getMessageType(envelope[orderCreated]{})).To(Equal("envelope[orderCreated]"))
+	g.Expect(getMessageType(envelope[map[string]*orderCreated]{})).To(Equal("envelope[map[string]*orderCreated]"))
+	g.Expect(getMessageType(struct{ A int }{})).To(Equal("struct { A int }"))
+	g.Expect(getMessageType([]string{})).To(Equal("[]string"))
+	g.Expect(getMessageType(nil)).To(Equal(""))
+}
+
+func BenchmarkGetMessageType(b *testing.B) {
+	b.Run("cached", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			getMessageType(envelope[orderCreated]{})
+		}
+	})
+	b.Run("uncached", func(b *testing.B) {
+		b.ReportAllocs()
+		t := reflect.TypeOf(envelope[orderCreated]{})
+		for i := 0; i < b.N; i++ {
+			messageTypeName(t)
+		}
+	})
+}
+
+func TestSender_OnSendAttempt(t *testing.T) {
+	g := NewWithT(t)
+	var events []SendAttemptEvent
+	hooks := &Hooks{
+		OnSendAttempt: func(ctx context.Context, event SendAttemptEvent) {
+			events = append(events, event)
+		},
+	}
+	azSender := &fakeAzSender{}
+	s := NewSenderWithOptions(azSender, WithSenderEntityName("topic"), WithSenderHooks(hooks))
+
+	g.Expect(s.SendMessage(context.Background(), "test")).To(Succeed())
+	azSender.ScheduledMessagesErr = errServerBusy
+	_, err := s.ScheduleMessages(context.Background(), []*azservicebus.Message{{}, {}}, time.Now())
+	g.Expect(err).To(HaveOccurred())
+
+	g.Expect(events).To(HaveLen(2))
+	g.Expect(events[0].Entity).To(Equal("topic"))
+	g.Expect(events[0].Operation).To(Equal(SendOperationMessage))
+	g.Expect(events[0].MessageCount).To(Equal(1))
+	g.Expect(events[0].Err).ToNot(HaveOccurred())
+	g.Expect(events[1].Operation).To(Equal(SendOperationSchedule))
+	g.Expect(events[1].MessageCount).To(Equal(2))
+	g.Expect(events[1].Throttled).To(BeTrue())
+	g.Expect(events[1].Err).To(HaveOccurred())
+}
+
+func TestSender_DeadlinePropagation(t *testing.T) {
+	g := NewWithT(t)
+	azSender := &fakeAzSender{}
+	s := NewSenderWithOptions(azSender, WithDeadlinePropagation())
+	deadline := time.Now().Add(time.Minute)
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+
+	g.Expect(s.SendMessage(ctx, "test")).To(Succeed())
+	g.Expect(azSender.SendMessageReceivedValue.ApplicationProperties).To(
+		HaveKeyWithValue(DeadlineProperty, deadline.UTC().Format(time.RFC3339Nano)))
+}
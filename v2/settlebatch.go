@@ -0,0 +1,158 @@
+package shuttle
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+)
+
+const defaultBatchSettleConcurrency = 8
+
+// BatchSettlerOptions configures the BatchSettler.
+type BatchSettlerOptions struct {
+	// MaxConcurrency is the number of settlement requests in flight. Defaults to 8.
+	MaxConcurrency int
+}
+
+// MessageSettleError is the error of the settlement of a message of a batch.
+type MessageSettleError struct {
+	// Index is the index of the message in the settled slice.
+	Index   int
+	Message *azservicebus.ReceivedMessage
+	Err     error
+}
+
+func (e *MessageSettleError) Error() string {
+	return fmt.Sprintf("message %d (%s): %s", e.Index, e.Message.MessageID, e.Err)
+}
+
+func (e *MessageSettleError) Unwrap() error {
+	return e.Err
+}
+
+// BatchSettleError aggregates the errors of the messages of a batch that could not be settled.
+// The other messages of the batch were settled.
+type BatchSettleError struct {
+	// Settlement is the name of the settlement: complete, abandon, dead letter or defer.
+	Settlement string
+	// Total is the number of messages of the batch.
+	Total int
+	// Errors are sorted by index.
+	Errors []*MessageSettleError
+}
+
+func (e *BatchSettleError) Error() string {
+	messages := make([]string, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		messages = append(messages, err.Error())
+	}
+	return fmt.Sprintf("failed to %s %d of %d messages: %s", e.Settlement, len(e.Errors), e.Total, strings.Join(messages, "; "))
+}
+
+func (e *BatchSettleError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// Failed returns the messages that could not be settled, for example to retry them.
+func (e *BatchSettleError) Failed() []*azservicebus.ReceivedMessage {
+	failed := make([]*azservicebus.ReceivedMessage, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		failed = append(failed, err.Message)
+	}
+	return failed
+}
+
+// BatchSettler is a MessageSettler that also settles slices of messages, issuing the settlement requests concurrently
+// instead of one round trip after the other.
+type BatchSettler struct {
+	MessageSettler
+	options BatchSettlerOptions
+}
+
+// Unwrap returns the wrapped MessageSettler, for UnwrapSettler.
+func (s *BatchSettler) Unwrap() MessageSettler {
+	return s.MessageSettler
+}
+
+// NewBatchSettler creates a BatchSettler settling the messages with the settler, usually the receiver of the messages.
+func NewBatchSettler(settler MessageSettler, options *BatchSettlerOptions) *BatchSettler {
+	opts := BatchSettlerOptions{}
+	if options != nil {
+		opts = *options
+	}
+	if opts.MaxConcurrency <= 0 {
+		opts.MaxConcurrency = defaultBatchSettleConcurrency
+	}
+	return &BatchSettler{MessageSettler: settler, options: opts}
+}
+
+// CompleteMessages completes the messages. It returns a *BatchSettleError listing the messages that could not be completed.
+func (s *BatchSettler) CompleteMessages(ctx context.Context, messages []*azservicebus.ReceivedMessage, options *azservicebus.CompleteMessageOptions) error {
+	return s.settleAll(ctx, "complete", messages, func(ctx context.Context, message *azservicebus.ReceivedMessage) error {
+		return s.MessageSettler.CompleteMessage(ctx, message, options)
+	})
+}
+
+// AbandonMessages abandons the messages. It returns a *BatchSettleError listing the messages that could not be abandoned.
+func (s *BatchSettler) AbandonMessages(ctx context.Context, messages []*azservicebus.ReceivedMessage, options *azservicebus.AbandonMessageOptions) error {
+	return s.settleAll(ctx, "abandon", messages, func(ctx context.Context, message *azservicebus.ReceivedMessage) error {
+		return s.MessageSettler.AbandonMessage(ctx, message, options)
+	})
+}
+
+// DeadLetterMessages dead-letters the messages. It returns a *BatchSettleError listing the messages that could not be dead-lettered.
+func (s *BatchSettler) DeadLetterMessages(ctx context.Context, messages []*azservicebus.ReceivedMessage, options *azservicebus.DeadLetterOptions) error {
+	return s.settleAll(ctx, "dead letter", messages, func(ctx context.Context, message *azservicebus.ReceivedMessage) error {
+		return s.MessageSettler.DeadLetterMessage(ctx, message, options)
+	})
+}
+
+// DeferMessages defers the messages. It returns a *BatchSettleError listing the messages that could not be deferred.
+func (s *BatchSettler) DeferMessages(ctx context.Context, messages []*azservicebus.ReceivedMessage, options *azservicebus.DeferMessageOptions) error {
+	return s.settleAll(ctx, "defer", messages, func(ctx context.Context, message *azservicebus.ReceivedMessage) error {
+		return s.MessageSettler.DeferMessage(ctx, message, options)
+	})
+}
+
+// settleAll settles the messages with MaxConcurrency requests in flight.
+// The settlements are all attempted: a failure does not prevent the other messages from being settled.
+func (s *BatchSettler) settleAll(
+	ctx context.Context,
+	settlement string,
+	messages []*azservicebus.ReceivedMessage,
+	settle func(ctx context.Context, message *azservicebus.ReceivedMessage) error) error {
+	var mu sync.Mutex
+	var errs []*MessageSettleError
+	var wg sync.WaitGroup
+	tokens := make(chan struct{}, s.options.MaxConcurrency)
+	for i, message := range messages {
+		tokens <- struct{}{}
+		wg.Add(1)
+		go func(i int, message *azservicebus.ReceivedMessage) {
+			defer func() {
+				<-tokens
+				wg.Done()
+			}()
+			if err := settle(ctx, message); err != nil {
+				mu.Lock()
+				errs = append(errs, &MessageSettleError{Index: i, Message: message, Err: err})
+				mu.Unlock()
+			}
+		}(i, message)
+	}
+	wg.Wait()
+	if len(errs) == 0 {
+		return nil
+	}
+	sort.Slice(errs, func(i, j int) bool { return errs[i].Index < errs[j].Index })
+	log(ctx, fmt.Sprintf("failed to %s %d of %d messages", settlement, len(errs), len(messages)))
+	return &BatchSettleError{Settlement: settlement, Total: len(messages), Errors: errs}
+}
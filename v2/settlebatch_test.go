@@ -0,0 +1,80 @@
+package shuttle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	. "github.com/onsi/gomega"
+)
+
+// slowSettler records the settled messages and the peak of concurrent requests, and fails the messages in failing.
+type slowSettler struct {
+	fakeSettler
+	mu       sync.Mutex
+	settled  []string
+	inFlight atomic.Int32
+	peak     atomic.Int32
+	failing  map[string]bool
+}
+
+func (s *slowSettler) settle(message *azservicebus.ReceivedMessage) error {
+	current := s.inFlight.Add(1)
+	defer s.inFlight.Add(-1)
+	for peak := s.peak.Load(); current > peak && !s.peak.CompareAndSwap(peak, current); peak = s.peak.Load() {
+	}
+	time.Sleep(5 * time.Millisecond)
+	if s.failing[message.MessageID] {
+		return errors.New("lock lost")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.settled = append(s.settled, message.MessageID)
+	return nil
+}
+
+func (s *slowSettler) CompleteMessage(_ context.Context, message *azservicebus.ReceivedMessage, _ *azservicebus.CompleteMessageOptions) error {
+	return s.settle(message)
+}
+
+func (s *slowSettler) AbandonMessage(_ context.Context, message *azservicebus.ReceivedMessage, _ *azservicebus.AbandonMessageOptions) error {
+	return s.settle(message)
+}
+
+func batchMessages(count int) []*azservicebus.ReceivedMessage {
+	messages := make([]*azservicebus.ReceivedMessage, count)
+	for i := range messages {
+		messages[i] = &azservicebus.ReceivedMessage{MessageID: fmt.Sprintf("m%d", i)}
+	}
+	return messages
+}
+
+func TestBatchSettler_CompleteMessages(t *testing.T) {
+	g := NewWithT(t)
+	settler := &slowSettler{}
+	batch := NewBatchSettler(settler, &BatchSettlerOptions{MaxConcurrency: 3})
+	g.Expect(batch.CompleteMessages(context.Background(), batchMessages(10), nil)).To(Succeed())
+	g.Expect(settler.settled).To(HaveLen(10))
+	g.Expect(settler.peak.Load()).To(Equal(int32(3)))
+	g.Expect(batch.CompleteMessages(context.Background(), nil, nil)).To(Succeed())
+}
+
+func TestBatchSettler_Errors(t *testing.T) {
+	g := NewWithT(t)
+	settler := &slowSettler{failing: map[string]bool{"m1": true, "m4": true}}
+	messages := batchMessages(5)
+	err := NewBatchSettler(settler, nil).AbandonMessages(context.Background(), messages, nil)
+
+	var batchErr *BatchSettleError
+	g.Expect(errors.As(err, &batchErr)).To(BeTrue())
+	g.Expect(batchErr.Total).To(Equal(5))
+	g.Expect(batchErr.Failed()).To(Equal([]*azservicebus.ReceivedMessage{messages[1], messages[4]}))
+	g.Expect(err).To(MatchError("failed to abandon 2 of 5 messages: message 1 (m1): lock lost; message 4 (m4): lock lost"))
+	// the other messages are settled
+	g.Expect(settler.settled).To(ConsistOf("m0", "m2", "m3"))
+}
@@ -20,6 +20,12 @@ type Abandon struct {
 	options *azservicebus.AbandonMessageOptions
 }
 
+// NewAbandon creates an Abandon settlement with the given options.
+// options.PropertiesToModify allows to record the retry history on the message, for example the last error.
+func NewAbandon(options *azservicebus.AbandonMessageOptions) *Abandon {
+	return &Abandon{options: options}
+}
+
 func (a *Abandon) Settle(ctx context.Context, settler MessageSettler, message *azservicebus.ReceivedMessage) {
 	abandonSettlement.settle(ctx, settler, message, a.options)
 }
@@ -40,6 +46,11 @@ type DeadLetter struct {
 	options *azservicebus.DeadLetterOptions
 }
 
+// NewDeadLetter creates a DeadLetter settlement with the given options.
+func NewDeadLetter(options *azservicebus.DeadLetterOptions) *DeadLetter {
+	return &DeadLetter{options: options}
+}
+
 func (a *DeadLetter) Settle(ctx context.Context, settler MessageSettler, message *azservicebus.ReceivedMessage) {
 	deadLetterSettlement.settle(ctx, settler, message, a.options)
 }
@@ -50,6 +61,11 @@ type Defer struct {
 	options *azservicebus.DeferMessageOptions
 }
 
+// NewDefer creates a Defer settlement with the given options.
+func NewDefer(options *azservicebus.DeferMessageOptions) *Defer {
+	return &Defer{options: options}
+}
+
 func (a *Defer) Settle(ctx context.Context, settler MessageSettler, message *azservicebus.ReceivedMessage) {
 	deferSettlement.settle(ctx, settler, message, a.options)
 }
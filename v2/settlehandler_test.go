@@ -4,6 +4,7 @@ import (
 	"context"
 	"testing"
 
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
 	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
 	. "github.com/onsi/gomega"
 )
@@ -29,6 +30,16 @@ func TestSettlementHandler(t *testing.T) {
 				g.Expect(settler.abandoned).To(BeTrue())
 			},
 		},
+		{
+			name: "Abandon with properties to modify",
+			settlement: NewAbandon(&azservicebus.AbandonMessageOptions{
+				PropertiesToModify: map[string]any{"attempt": 1},
+			}),
+			assert: func(g Gomega, settler *fakeSettler) {
+				g.Expect(settler.abandoned).To(BeTrue())
+				g.Expect(settler.abandonOptions.PropertiesToModify).To(HaveKeyWithValue("attempt", 1))
+			},
+		},
 		{
 			name:       "Deadletter",
 			settlement: &DeadLetter{},
@@ -36,6 +47,14 @@ func TestSettlementHandler(t *testing.T) {
 				g.Expect(settler.deadlettered).To(BeTrue())
 			},
 		},
+		{
+			name:       "Deadletter with options",
+			settlement: NewDeadLetter(&azservicebus.DeadLetterOptions{Reason: to.Ptr("reason")}),
+			assert: func(g Gomega, settler *fakeSettler) {
+				g.Expect(settler.deadlettered).To(BeTrue())
+				g.Expect(*settler.deadletterOptions.Reason).To(Equal("reason"))
+			},
+		},
 		{
 			name:       "Defer",
 			settlement: &Defer{},
@@ -43,6 +62,13 @@ func TestSettlementHandler(t *testing.T) {
 				g.Expect(settler.defered).To(BeTrue())
 			},
 		},
+		{
+			name:       "Defer with options",
+			settlement: NewDefer(&azservicebus.DeferMessageOptions{}),
+			assert: func(g Gomega, settler *fakeSettler) {
+				g.Expect(settler.defered).To(BeTrue())
+			},
+		},
 		{
 			name:       "NoOp",
 			settlement: &NoOp{},
@@ -0,0 +1,179 @@
+package shuttle
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+)
+
+const (
+	defaultSettlementThrottlingInitial    = time.Second
+	defaultSettlementThrottlingMax        = 30 * time.Second
+	defaultSettlementThrottlingMaxRetries = 3
+)
+
+// SettlementThrottlingOptions configures how the Processor reacts to the settlements throttled by the namespace.
+// A throttled settlement pauses the settlements of all the messages of the processor, and its receives,
+// for the Backoff delay, instead of hammering the namespace with the settlements of the messages in flight.
+type SettlementThrottlingOptions struct {
+	// Backoff is the pause after the given number of consecutive throttled settlements.
+	// Defaults to an ExponentialBackoff from 1 second to 30 seconds.
+	Backoff Backoff
+	// MaxRetries is the number of times a throttled settlement is retried after the pause,
+	// before its error is returned to the handler. Defaults to 3. Negative disables the retries.
+	MaxRetries int
+	// Clock is used to measure the pause. Defaults to the system clock.
+	Clock Clock
+}
+
+// WithSettlementThrottling paces the settlements and the receives of the processor while the namespace
+// throttles its settlements. See SettlementThrottlingOptions.
+func WithSettlementThrottling(options SettlementThrottlingOptions) ProcessorOption {
+	return func(o *ProcessorOptions) {
+		o.SettlementThrottling = &options
+	}
+}
+
+// ThrottledUntil returns the time until which the settlements and the receives of the processor are paused
+// because the namespace throttled a settlement. It is in the past when the processor is not throttled,
+// and zero when SettlementThrottling is not enabled.
+func (p *Processor) ThrottledUntil() time.Time {
+	if p.settleThrottle == nil {
+		return time.Time{}
+	}
+	return p.settleThrottle.throttledUntil()
+}
+
+// settlementPaused reports whether the receives are paused by a throttled settlement, and logs when they pause or resume.
+func (p *Processor) settlementPaused(ctx context.Context) bool {
+	if p.settleThrottle == nil {
+		return false
+	}
+	remaining := p.settleThrottle.remaining()
+	paused := remaining > 0
+	if p.settlePaused.Swap(paused) != paused {
+		if paused {
+			log(ctx, fmt.Sprintf("settlements are throttled, pausing receiving for %s", remaining))
+		} else {
+			log(ctx, "settlements are no longer throttled, resuming receiving")
+		}
+	}
+	return paused
+}
+
+// settler returns the settler of the messages, pacing the settlements when SettlementThrottling is enabled.
+func (p *Processor) settler() MessageSettler {
+	if p.settleThrottle == nil {
+		return p.currentReceiver()
+	}
+	return &throttledSettler{MessageSettler: p.currentReceiver(), throttle: p.settleThrottle}
+}
+
+// settlementThrottle tracks the throttled settlements shared by the messages of a Processor.
+type settlementThrottle struct {
+	options SettlementThrottlingOptions
+	clock   Clock
+
+	mu                   sync.Mutex
+	consecutiveThrottles int
+	until                time.Time
+}
+
+func newSettlementThrottle(options *SettlementThrottlingOptions) *settlementThrottle {
+	if options == nil {
+		return nil
+	}
+	opts := *options
+	if opts.Backoff == nil {
+		opts.Backoff = ExponentialBackoff{Initial: defaultSettlementThrottlingInitial, Max: defaultSettlementThrottlingMax}
+	}
+	if opts.MaxRetries == 0 {
+		opts.MaxRetries = defaultSettlementThrottlingMaxRetries
+	}
+	return &settlementThrottle{options: opts, clock: clockOrDefault(opts.Clock)}
+}
+
+func (t *settlementThrottle) record(err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if isThrottlingError(err) {
+		t.consecutiveThrottles++
+		until := t.clock.Now().Add(t.options.Backoff.Delay(t.consecutiveThrottles))
+		if until.After(t.until) {
+			t.until = until
+		}
+		return
+	}
+	if err == nil && t.consecutiveThrottles > 0 {
+		t.consecutiveThrottles--
+	}
+}
+
+func (t *settlementThrottle) throttledUntil() time.Time {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.until
+}
+
+func (t *settlementThrottle) remaining() time.Duration {
+	return t.throttledUntil().Sub(t.clock.Now())
+}
+
+// wait blocks until the pause is over.
+func (t *settlementThrottle) wait(ctx context.Context) error {
+	for {
+		remaining := t.remaining()
+		if remaining <= 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("failed to settle message while throttled: %w", ctx.Err())
+		case <-t.clock.After(remaining):
+		}
+	}
+}
+
+// throttledSettler waits for the pause of the throttle before each settlement, and retries the throttled ones.
+type throttledSettler struct {
+	MessageSettler
+	throttle *settlementThrottle
+}
+
+// Unwrap returns the wrapped MessageSettler, for UnwrapSettler.
+func (s *throttledSettler) Unwrap() MessageSettler {
+	return s.MessageSettler
+}
+
+func (s *throttledSettler) AbandonMessage(ctx context.Context, message *azservicebus.ReceivedMessage, options *azservicebus.AbandonMessageOptions) error {
+	return s.settle(ctx, func() error { return s.MessageSettler.AbandonMessage(ctx, message, options) })
+}
+
+func (s *throttledSettler) CompleteMessage(ctx context.Context, message *azservicebus.ReceivedMessage, options *azservicebus.CompleteMessageOptions) error {
+	return s.settle(ctx, func() error { return s.MessageSettler.CompleteMessage(ctx, message, options) })
+}
+
+func (s *throttledSettler) DeadLetterMessage(ctx context.Context, message *azservicebus.ReceivedMessage, options *azservicebus.DeadLetterOptions) error {
+	return s.settle(ctx, func() error { return s.MessageSettler.DeadLetterMessage(ctx, message, options) })
+}
+
+func (s *throttledSettler) DeferMessage(ctx context.Context, message *azservicebus.ReceivedMessage, options *azservicebus.DeferMessageOptions) error {
+	return s.settle(ctx, func() error { return s.MessageSettler.DeferMessage(ctx, message, options) })
+}
+
+func (s *throttledSettler) settle(ctx context.Context, settle func() error) error {
+	for attempt := 0; ; attempt++ {
+		if err := s.throttle.wait(ctx); err != nil {
+			return err
+		}
+		err := settle()
+		s.throttle.record(err)
+		if !isThrottlingError(err) || attempt >= s.throttle.options.MaxRetries {
+			return err
+		}
+		log(ctx, fmt.Sprintf("settlement throttled, retrying after %s", s.throttle.remaining()))
+	}
+}
@@ -0,0 +1,141 @@
+package shuttle
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	. "github.com/onsi/gomega"
+)
+
+// throttlingSettler throttles the first completions, up to throttled.
+type throttlingSettler struct {
+	fakeSettler
+	throttled   int32
+	settleCalls atomic.Int32
+}
+
+func (s *throttlingSettler) CompleteMessage(ctx context.Context, message *azservicebus.ReceivedMessage, options *azservicebus.CompleteMessageOptions) error {
+	if s.settleCalls.Add(1) <= s.throttled {
+		return errServerBusy
+	}
+	return nil
+}
+
+func (s *throttlingSettler) AbandonMessage(ctx context.Context, message *azservicebus.ReceivedMessage, options *azservicebus.AbandonMessageOptions) error {
+	s.settleCalls.Add(1)
+	return nil
+}
+
+// countingSource returns one message on the first receive, and counts the receives.
+type countingSource struct {
+	calls atomic.Int32
+}
+
+func (s *countingSource) ReceiveMessages(_ context.Context, _ int, _ *azservicebus.ReceiveMessagesOptions) ([]*azservicebus.ReceivedMessage, error) {
+	if s.calls.Add(1) == 1 {
+		return []*azservicebus.ReceivedMessage{{MessageID: "1"}}, nil
+	}
+	return nil, nil
+}
+
+func TestSettlementThrottling_PausesAndRetries(t *testing.T) {
+	g := NewWithT(t)
+	throttle := newSettlementThrottle(&SettlementThrottlingOptions{Backoff: ConstantBackoff(30 * time.Millisecond)})
+	settler := &throttlingSettler{throttled: 2}
+	throttled := &throttledSettler{MessageSettler: settler, throttle: throttle}
+
+	start := time.Now()
+	g.Expect(throttled.CompleteMessage(context.Background(), &azservicebus.ReceivedMessage{}, nil)).To(Succeed())
+	g.Expect(time.Since(start)).To(BeNumerically(">=", 60*time.Millisecond))
+	g.Expect(settler.settleCalls.Load()).To(Equal(int32(3)))
+
+	// the other settlements wait for the pause too
+	throttle.record(errServerBusy)
+	start = time.Now()
+	g.Expect(throttled.AbandonMessage(context.Background(), &azservicebus.ReceivedMessage{}, nil)).To(Succeed())
+	g.Expect(time.Since(start)).To(BeNumerically(">=", 20*time.Millisecond))
+}
+
+func TestSettlementThrottling_ReturnsErrorAfterMaxRetries(t *testing.T) {
+	g := NewWithT(t)
+	throttle := newSettlementThrottle(&SettlementThrottlingOptions{Backoff: ConstantBackoff(time.Minute), MaxRetries: -1})
+	settler := &throttlingSettler{throttled: 1}
+	throttled := &throttledSettler{MessageSettler: settler, throttle: throttle}
+
+	err := throttled.CompleteMessage(context.Background(), &azservicebus.ReceivedMessage{}, nil)
+	g.Expect(err).To(MatchError(errServerBusy))
+	g.Expect(settler.settleCalls.Load()).To(Equal(int32(1)))
+	g.Expect(throttle.throttledUntil()).To(BeTemporally("~", time.Now().Add(time.Minute), time.Second))
+
+	// the pause is aborted with the context
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = throttled.CompleteMessage(ctx, &azservicebus.ReceivedMessage{}, nil)
+	g.Expect(errors.Is(err, context.Canceled)).To(BeTrue())
+}
+
+func TestSettlementThrottling_IgnoresOtherErrors(t *testing.T) {
+	g := NewWithT(t)
+	throttle := newSettlementThrottle(&SettlementThrottlingOptions{})
+	throttle.record(errors.New("lock lost"))
+	g.Expect(throttle.throttledUntil().IsZero()).To(BeTrue())
+	g.Expect(newSettlementThrottle(nil)).To(BeNil())
+}
+
+func TestProcessor_PausesReceivingWhileSettlementsAreThrottled(t *testing.T) {
+	g := NewWithT(t)
+	source := &countingSource{}
+	settler := &throttlingSettler{throttled: 1}
+	p := NewProcessor(NewSourceReceiver(source, settler),
+		func(ctx context.Context, settler MessageSettler, message *azservicebus.ReceivedMessage) {
+			_ = settler.CompleteMessage(ctx, message, nil)
+		},
+		&ProcessorOptions{
+			MaxConcurrency:       2,
+			ReceiveInterval:      to.Ptr(5 * time.Millisecond),
+			SettlementThrottling: &SettlementThrottlingOptions{Backoff: ConstantBackoff(300 * time.Millisecond)},
+		})
+	g.Expect(p.ThrottledUntil().IsZero()).To(BeTrue())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = p.Start(ctx) }()
+
+	g.Eventually(settler.settleCalls.Load).Should(Equal(int32(1)))
+	g.Expect(p.ThrottledUntil()).To(BeTemporally(">", time.Now()))
+	// let a receive already in progress finish
+	time.Sleep(20 * time.Millisecond)
+	paused := source.calls.Load()
+	g.Consistently(source.calls.Load, 150*time.Millisecond).Should(Equal(paused))
+	g.Eventually(settler.settleCalls.Load).Should(Equal(int32(2)), "the settlement is retried after the pause")
+	g.Eventually(source.calls.Load).Should(BeNumerically(">", paused), "the receives resume after the pause")
+}
+
+func TestProcessor_SettlementPausedLogsTransitions(t *testing.T) {
+	g := NewWithT(t)
+	t.Setenv("GOSHUTTLE_LOG", "ALL")
+	SetLoggerFunc(getTestLogger)
+	defer SetLoggerFunc(func(_ context.Context) Logger { return &printLogger{} })
+	logger := &testLogger{}
+	ctx := context.WithValue(context.Background(), testlogkey, logger)
+	p := &Processor{settleThrottle: newSettlementThrottle(&SettlementThrottlingOptions{Backoff: ConstantBackoff(time.Hour)})}
+
+	g.Expect(p.settlementPaused(ctx)).To(BeFalse())
+	p.settleThrottle.record(errServerBusy)
+	for i := 0; i < 3; i++ {
+		g.Expect(p.settlementPaused(ctx)).To(BeTrue())
+	}
+	p.settleThrottle.mu.Lock()
+	p.settleThrottle.until = time.Time{}
+	p.settleThrottle.mu.Unlock()
+	g.Expect(p.settlementPaused(ctx)).To(BeFalse())
+	g.Expect(p.settlementPaused(ctx)).To(BeFalse())
+
+	g.Expect(logger.entries).To(HaveLen(2))
+	g.Expect(logger.entries[0]).To(ContainSubstring("pausing receiving"))
+	g.Expect(logger.entries[1]).To(ContainSubstring("resuming receiving"))
+}
@@ -0,0 +1,264 @@
+package shuttle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+)
+
+const defaultShardRefreshInterval = 30 * time.Second
+
+// ShardFor returns the shard of the key, between 0 and shardCount-1.
+// The same key always maps to the same shard for a given shardCount, which preserves the order of the messages of a key
+// when each shard is a queue consumed by a single processor.
+func ShardFor(key string, shardCount int) int {
+	if shardCount <= 1 {
+		return 0
+	}
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return int(h.Sum32() % uint32(shardCount))
+}
+
+// ShardEntityName returns the name of the queue of the shard, like "orders-3" for the prefix "orders" and the shard 3.
+func ShardEntityName(prefix string, shard int) string {
+	return fmt.Sprintf("%s-%d", prefix, shard)
+}
+
+// ShardsForMember spreads shardCount shards evenly over memberCount consumers, and returns the shards of the member,
+// for example the ordinal of a StatefulSet replica. It returns no shard when the member is out of range.
+func ShardsForMember(shardCount, member, memberCount int) []int {
+	if memberCount <= 0 || member < 0 || member >= memberCount {
+		return nil
+	}
+	var shards []int
+	for shard := member; shard < shardCount; shard += memberCount {
+		shards = append(shards, shard)
+	}
+	return shards
+}
+
+// ShardedSender sends the messages to one of several queues by a hash of their key,
+// to scale a logical stream beyond the throughput limits of a single entity.
+type ShardedSender struct {
+	senders []*Sender
+}
+
+// NewShardedSender creates a ShardedSender over the senders of each shard, in shard order.
+func NewShardedSender(senders []*Sender) *ShardedSender {
+	return &ShardedSender{senders: senders}
+}
+
+// ShardCount returns the number of shards of the sender.
+func (s *ShardedSender) ShardCount() int {
+	return len(s.senders)
+}
+
+// SendMessage sends the message to the shard of the key. The messages with the same key are sent to the same shard.
+func (s *ShardedSender) SendMessage(ctx context.Context, key string, mb MessageBody, options ...func(msg *azservicebus.Message) error) error {
+	if len(s.senders) == 0 {
+		return errors.New("sharded sender has no shard")
+	}
+	return s.senders[ShardFor(key, len(s.senders))].SendMessage(ctx, mb, options...)
+}
+
+// ShardCoordinator assigns shards to this consumer. The assignments of all the consumers should cover every shard,
+// and a shard should be assigned to a single consumer at a time to preserve the per-key ordering.
+type ShardCoordinator interface {
+	AssignedShards(ctx context.Context) ([]int, error)
+}
+
+// ShardCoordinatorFunc allows to use a func as a ShardCoordinator.
+type ShardCoordinatorFunc func(ctx context.Context) ([]int, error)
+
+func (f ShardCoordinatorFunc) AssignedShards(ctx context.Context) ([]int, error) {
+	return f(ctx)
+}
+
+// StaticShardAssignment is a ShardCoordinator for a fixed set of shards, for example computed with ShardsForMember.
+type StaticShardAssignment []int
+
+func (s StaticShardAssignment) AssignedShards(_ context.Context) ([]int, error) {
+	return s, nil
+}
+
+// ShardedProcessorOptions configures the ShardedProcessor.
+type ShardedProcessorOptions struct {
+	// NewProcessor creates the processor of the queue of a shard. Required.
+	// Use WithStrictOrdering to handle the messages of each key in order.
+	NewProcessor func(ctx context.Context, shard int) (*Processor, error)
+	// RefreshInterval is the interval at which Run fetches the assigned shards. Defaults to 30 seconds.
+	RefreshInterval time.Duration
+	// Clock is used to wait between refreshes. Defaults to the system clock.
+	Clock Clock
+}
+
+type shardProcessor struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// ShardedProcessor runs a processor for each shard assigned to this consumer by the ShardCoordinator,
+// started and stopped as the assignment changes.
+type ShardedProcessor struct {
+	coordinator ShardCoordinator
+	options     ShardedProcessorOptions
+
+	mu         sync.Mutex
+	processors map[int]*shardProcessor
+	runCtx     context.Context
+}
+
+// NewShardedProcessor creates a ShardedProcessor for the shards assigned by the coordinator.
+func NewShardedProcessor(coordinator ShardCoordinator, options *ShardedProcessorOptions) *ShardedProcessor {
+	opts := ShardedProcessorOptions{}
+	if options != nil {
+		opts = *options
+	}
+	if opts.RefreshInterval <= 0 {
+		opts.RefreshInterval = defaultShardRefreshInterval
+	}
+	opts.Clock = clockOrDefault(opts.Clock)
+	return &ShardedProcessor{
+		coordinator: coordinator,
+		options:     opts,
+		processors:  map[int]*shardProcessor{},
+	}
+}
+
+// Shards returns the shards with a running processor, in ascending order.
+func (p *ShardedProcessor) Shards() []int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	shards := make([]int, 0, len(p.processors))
+	for shard := range p.processors {
+		shards = append(shards, shard)
+	}
+	sort.Ints(shards)
+	return shards
+}
+
+// Run refreshes the assigned shards every RefreshInterval until ctx is done,
+// starting a processor for each newly assigned shard and stopping the processors of the revoked shards.
+// The processors of revoked shards are stopped before the new ones are started.
+// A processor that stops with an error is restarted on the next refresh.
+// Run returns once all the processors are stopped.
+func (p *ShardedProcessor) Run(ctx context.Context) error {
+	if p.options.NewProcessor == nil {
+		return errors.New("ShardedProcessorOptions.NewProcessor is required to run processors")
+	}
+	p.mu.Lock()
+	p.runCtx = ctx
+	p.mu.Unlock()
+	defer p.stopProcessors()
+	for {
+		if err := p.Refresh(ctx); err != nil && ctx.Err() == nil {
+			log(ctx, fmt.Sprintf("failed to refresh shard assignment: %s", err))
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-p.options.Clock.After(p.options.RefreshInterval):
+		}
+	}
+}
+
+// Refresh fetches the assigned shards. When Run is active, the processors are started and stopped to match the assignment.
+func (p *ShardedProcessor) Refresh(ctx context.Context) error {
+	shards, err := p.coordinator.AssignedShards(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to fetch assigned shards: %w", err)
+	}
+	assigned := make(map[int]struct{}, len(shards))
+	for _, shard := range shards {
+		assigned[shard] = struct{}{}
+	}
+
+	p.mu.Lock()
+	var stopping []*shardProcessor
+	for shard, sp := range p.processors {
+		if _, ok := assigned[shard]; !ok {
+			stopping = append(stopping, sp)
+			delete(p.processors, shard)
+		}
+	}
+	var starting []int
+	if p.runCtx != nil && p.runCtx.Err() == nil {
+		for shard := range assigned {
+			if _, ok := p.processors[shard]; !ok {
+				starting = append(starting, shard)
+			}
+		}
+	}
+	p.mu.Unlock()
+
+	for _, sp := range stopping {
+		sp.cancel()
+		<-sp.done
+	}
+	sort.Ints(starting)
+	for _, shard := range starting {
+		p.startProcessor(shard)
+	}
+	return nil
+}
+
+// startProcessor creates the processor of the shard outside of the lock, as NewProcessor may call the network,
+// and starts it unless Run stopped or another refresh started the shard meanwhile.
+func (p *ShardedProcessor) startProcessor(shard int) {
+	p.mu.Lock()
+	runCtx := p.runCtx
+	_, running := p.processors[shard]
+	p.mu.Unlock()
+	if runCtx == nil || runCtx.Err() != nil || running {
+		return
+	}
+	processor, err := p.options.NewProcessor(runCtx, shard)
+	if err != nil {
+		log(runCtx, fmt.Sprintf("failed to create processor for shard %d: %s", shard, err))
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.runCtx != runCtx || runCtx.Err() != nil {
+		return
+	}
+	if _, ok := p.processors[shard]; ok {
+		return
+	}
+	ctx, cancel := context.WithCancel(runCtx)
+	sp := &shardProcessor{cancel: cancel, done: make(chan struct{})}
+	p.processors[shard] = sp
+	log(ctx, fmt.Sprintf("starting processor for shard %d", shard))
+	go func() {
+		defer close(sp.done)
+		if err := processor.Start(ctx); err != nil && ctx.Err() == nil {
+			log(ctx, fmt.Sprintf("processor for shard %d stopped: %s", shard, err))
+		}
+		p.mu.Lock()
+		if p.processors[shard] == sp {
+			delete(p.processors, shard)
+		}
+		p.mu.Unlock()
+		cancel()
+	}()
+}
+
+func (p *ShardedProcessor) stopProcessors() {
+	p.mu.Lock()
+	processors := p.processors
+	p.processors = map[int]*shardProcessor{}
+	p.runCtx = nil
+	p.mu.Unlock()
+	for _, sp := range processors {
+		sp.cancel()
+		<-sp.done
+	}
+}
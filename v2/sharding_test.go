@@ -0,0 +1,140 @@
+package shuttle
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	. "github.com/onsi/gomega"
+)
+
+func TestShardFor(t *testing.T) {
+	g := NewWithT(t)
+	g.Expect(ShardFor("order-1", 0)).To(Equal(0))
+	g.Expect(ShardFor("order-1", 1)).To(Equal(0))
+	counts := make([]int, 4)
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("order-%d", i)
+		shard := ShardFor(key, 4)
+		g.Expect(shard).To(BeNumerically(">=", 0))
+		g.Expect(shard).To(BeNumerically("<", 4))
+		g.Expect(ShardFor(key, 4)).To(Equal(shard))
+		counts[shard]++
+	}
+	for _, count := range counts {
+		g.Expect(count).To(BeNumerically(">", 150))
+	}
+}
+
+func TestShardsForMember(t *testing.T) {
+	g := NewWithT(t)
+	g.Expect(ShardsForMember(8, 0, 3)).To(Equal([]int{0, 3, 6}))
+	g.Expect(ShardsForMember(8, 2, 3)).To(Equal([]int{2, 5}))
+	g.Expect(ShardsForMember(2, 2, 3)).To(BeEmpty())
+	g.Expect(ShardsForMember(8, 3, 3)).To(BeEmpty())
+	g.Expect(ShardEntityName("orders", 3)).To(Equal("orders-3"))
+}
+
+func TestShardedSender_SendMessage(t *testing.T) {
+	g := NewWithT(t)
+	azSenders := []*fakeAzSender{{}, {}, {}}
+	var senders []*Sender
+	for _, azSender := range azSenders {
+		senders = append(senders, NewSender(azSender, nil))
+	}
+	sharded := NewShardedSender(senders)
+	g.Expect(sharded.ShardCount()).To(Equal(3))
+	g.Expect(sharded.SendMessage(context.Background(), "order-1", "hello")).To(Succeed())
+	for shard, azSender := range azSenders {
+		g.Expect(azSender.SendMessageCalled).To(Equal(shard == ShardFor("order-1", 3)))
+	}
+	g.Expect(NewShardedSender(nil).SendMessage(context.Background(), "order-1", "hello")).ToNot(Succeed())
+}
+
+// mutableShardAssignment is a ShardCoordinator updated by the tests to assign and revoke shards.
+type mutableShardAssignment struct {
+	mu     sync.Mutex
+	shards []int
+}
+
+func (m *mutableShardAssignment) set(shards []int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.shards = shards
+}
+
+func (m *mutableShardAssignment) AssignedShards(_ context.Context) ([]int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.shards, nil
+}
+
+func TestShardedProcessor_RunFollowsAssignment(t *testing.T) {
+	g := NewWithT(t)
+	coordinator := &mutableShardAssignment{shards: []int{0, 2}}
+	var mu sync.Mutex
+	var started []int
+	sharded := NewShardedProcessor(coordinator, &ShardedProcessorOptions{
+		NewProcessor: func(_ context.Context, shard int) (*Processor, error) {
+			mu.Lock()
+			started = append(started, shard)
+			mu.Unlock()
+			return NewProcessor(NewSourceReceiver(idleSource{}, nil), func(context.Context, MessageSettler, *azservicebus.ReceivedMessage) {},
+				&ProcessorOptions{MaxConcurrency: 1}), nil
+		},
+		RefreshInterval: 10 * time.Millisecond,
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	runErr := make(chan error, 1)
+	go func() { runErr <- sharded.Run(ctx) }()
+
+	g.Eventually(sharded.Shards).Should(Equal([]int{0, 2}))
+	coordinator.set([]int{2, 3})
+	g.Eventually(sharded.Shards).Should(Equal([]int{2, 3}))
+	mu.Lock()
+	g.Expect(started).To(Equal([]int{0, 2, 3}))
+	mu.Unlock()
+
+	cancel()
+	g.Eventually(runErr).Should(Receive(MatchError(context.Canceled)))
+	g.Expect(sharded.Shards()).To(BeEmpty())
+}
+
+func TestShardedProcessor_CreatesProcessorsOutsideLock(t *testing.T) {
+	g := NewWithT(t)
+	creating := make(chan struct{})
+	release := make(chan struct{})
+	sharded := NewShardedProcessor(StaticShardAssignment{0}, &ShardedProcessorOptions{
+		NewProcessor: func(_ context.Context, shard int) (*Processor, error) {
+			close(creating)
+			<-release
+			return NewProcessor(NewSourceReceiver(idleSource{}, nil), func(context.Context, MessageSettler, *azservicebus.ReceivedMessage) {},
+				&ProcessorOptions{MaxConcurrency: 1}), nil
+		},
+		RefreshInterval: time.Hour,
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	runErr := make(chan error, 1)
+	go func() { runErr <- sharded.Run(ctx) }()
+
+	<-creating
+	shards := make(chan []int, 1)
+	go func() { shards <- sharded.Shards() }()
+	g.Eventually(shards).Should(Receive(BeEmpty()), "a slow NewProcessor does not block the sharded processor")
+	close(release)
+	g.Eventually(sharded.Shards).Should(Equal([]int{0}))
+
+	cancel()
+	g.Eventually(runErr).Should(Receive(MatchError(context.Canceled)))
+}
+
+func TestShardedProcessor_RequiresFactory(t *testing.T) {
+	g := NewWithT(t)
+	sharded := NewShardedProcessor(StaticShardAssignment{0}, nil)
+	g.Expect(sharded.Run(context.Background())).ToNot(Succeed())
+}
@@ -0,0 +1,143 @@
+package shuttletest
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+
+	"github.com/Azure/go-shuttle/v2"
+)
+
+// msgTypeField is the application property the shuttle.Sender uses to store the message type.
+const msgTypeField = "type"
+
+// MessageMatcher inspects a captured message and returns an error describing the mismatch, if any.
+// the marshaller is the one configured on the InMemorySender and can be used to decode the message body.
+type MessageMatcher func(msg *azservicebus.Message, marshaller shuttle.Marshaller) error
+
+// WithType matches messages whose type, as set by the shuttle.Sender, equals msgType.
+func WithType(msgType string) MessageMatcher {
+	return WithProperty(msgTypeField, msgType)
+}
+
+// WithProperty matches messages that have the application property key set to value.
+func WithProperty(key string, value any) MessageMatcher {
+	return func(msg *azservicebus.Message, _ shuttle.Marshaller) error {
+		actual, ok := msg.ApplicationProperties[key]
+		if !ok {
+			return fmt.Errorf("application property %q is not set", key)
+		}
+		if !reflect.DeepEqual(actual, value) {
+			return fmt.Errorf("application property %q is %v, expected %v", key, actual, value)
+		}
+		return nil
+	}
+}
+
+// WithMessageID matches messages with the given MessageID.
+func WithMessageID(messageID string) MessageMatcher {
+	return func(msg *azservicebus.Message, _ shuttle.Marshaller) error {
+		if msg.MessageID == nil || *msg.MessageID != messageID {
+			return fmt.Errorf("message id is %v, expected %s", deref(msg.MessageID), messageID)
+		}
+		return nil
+	}
+}
+
+// WithCorrelationID matches messages with the given CorrelationID.
+func WithCorrelationID(correlationID string) MessageMatcher {
+	return func(msg *azservicebus.Message, _ shuttle.Marshaller) error {
+		if msg.CorrelationID == nil || *msg.CorrelationID != correlationID {
+			return fmt.Errorf("correlation id is %v, expected %s", deref(msg.CorrelationID), correlationID)
+		}
+		return nil
+	}
+}
+
+// WithBody matches messages whose body, unmarshalled with the sender's marshaller, deeply equals expected.
+// expected can be a value or a pointer. the body is unmarshalled into a new value of the same type.
+func WithBody(expected any) MessageMatcher {
+	return func(msg *azservicebus.Message, marshaller shuttle.Marshaller) error {
+		expectedType := reflect.TypeOf(expected)
+		isPtr := expectedType.Kind() == reflect.Ptr
+		if isPtr {
+			expectedType = expectedType.Elem()
+		}
+		actual := reflect.New(expectedType)
+		if err := marshaller.Unmarshal(msg, actual.Interface()); err != nil {
+			return fmt.Errorf("failed to unmarshal message body into %s: %w", expectedType, err)
+		}
+		actualValue := actual.Interface()
+		if !isPtr {
+			actualValue = actual.Elem().Interface()
+		}
+		if !reflect.DeepEqual(actualValue, expected) {
+			return fmt.Errorf("body is %+v, expected %+v", actualValue, expected)
+		}
+		return nil
+	}
+}
+
+// FindMessages returns all the messages sent through the sender that satisfy every matcher.
+func FindMessages(sender *InMemorySender, matchers ...MessageMatcher) []*azservicebus.Message {
+	var found []*azservicebus.Message
+	for _, msg := range sender.SentMessages() {
+		if matchAll(msg, sender.marshaller(), matchers) == nil {
+			found = append(found, msg)
+		}
+	}
+	return found
+}
+
+// ExpectMessageSent fails the test if no message sent through the sender satisfies every matcher.
+// it returns the first matching message.
+func ExpectMessageSent(t testing.TB, sender *InMemorySender, matchers ...MessageMatcher) *azservicebus.Message {
+	t.Helper()
+	sent := sender.SentMessages()
+	var mismatches []string
+	for i, msg := range sent {
+		err := matchAll(msg, sender.marshaller(), matchers)
+		if err == nil {
+			return msg
+		}
+		mismatches = append(mismatches, fmt.Sprintf("  message %d: %s", i, err))
+	}
+	t.Errorf("expected a matching message to be sent, but none of the %d sent messages matched:\n%s",
+		len(sent), strings.Join(mismatches, "\n"))
+	return nil
+}
+
+// ExpectNoMessageSent fails the test if any message sent through the sender satisfies every matcher.
+func ExpectNoMessageSent(t testing.TB, sender *InMemorySender, matchers ...MessageMatcher) {
+	t.Helper()
+	if found := FindMessages(sender, matchers...); len(found) > 0 {
+		t.Errorf("expected no matching message to be sent, but found %d", len(found))
+	}
+}
+
+// ExpectMessageCount fails the test if the number of messages sent through the sender that satisfy every matcher is not count.
+func ExpectMessageCount(t testing.TB, sender *InMemorySender, count int, matchers ...MessageMatcher) {
+	t.Helper()
+	if found := FindMessages(sender, matchers...); len(found) != count {
+		t.Errorf("expected %d matching messages to be sent, but found %d", count, len(found))
+	}
+}
+
+func matchAll(msg *azservicebus.Message, marshaller shuttle.Marshaller, matchers []MessageMatcher) error {
+	for _, match := range matchers {
+		if err := match(msg, marshaller); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func deref(s *string) any {
+	if s == nil {
+		return nil
+	}
+	return *s
+}
@@ -0,0 +1,82 @@
+package shuttletest
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	. "github.com/onsi/gomega"
+
+	"github.com/Azure/go-shuttle/v2"
+)
+
+type OrderCreated struct {
+	ID     string
+	Amount int
+}
+
+// recordingT captures the failures reported by the assertion helpers.
+type recordingT struct {
+	testing.TB
+	errors []string
+}
+
+func (r *recordingT) Helper() {}
+
+func (r *recordingT) Errorf(format string, args ...any) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func sendOrder(t *testing.T, inMemory *InMemorySender) {
+	sender := shuttle.NewSender(inMemory, nil)
+	err := sender.SendMessage(context.Background(), &OrderCreated{ID: "1", Amount: 10},
+		shuttle.SetMessageId(to.Ptr("msg-1")),
+		shuttle.SetCorrelationId(to.Ptr("corr-1")),
+		func(msg *azservicebus.Message) error {
+			msg.ApplicationProperties["tenant"] = "x"
+			return nil
+		})
+	NewWithT(t).Expect(err).ToNot(HaveOccurred())
+}
+
+func TestExpectMessageSent_Matches(t *testing.T) {
+	g := NewWithT(t)
+	inMemory := NewInMemorySender(nil)
+	sendOrder(t, inMemory)
+	rt := &recordingT{TB: t}
+	msg := ExpectMessageSent(rt, inMemory,
+		WithType("OrderCreated"),
+		WithProperty("tenant", "x"),
+		WithMessageID("msg-1"),
+		WithCorrelationID("corr-1"),
+		WithBody(&OrderCreated{ID: "1", Amount: 10}),
+		WithBody(OrderCreated{ID: "1", Amount: 10}))
+	g.Expect(rt.errors).To(BeEmpty())
+	g.Expect(msg).ToNot(BeNil())
+	ExpectMessageCount(rt, inMemory, 1, WithType("OrderCreated"))
+	ExpectNoMessageSent(rt, inMemory, WithType("OrderDeleted"))
+	g.Expect(rt.errors).To(BeEmpty())
+}
+
+func TestExpectMessageSent_ReportsMismatch(t *testing.T) {
+	g := NewWithT(t)
+	inMemory := NewInMemorySender(nil)
+	sendOrder(t, inMemory)
+	rt := &recordingT{TB: t}
+	msg := ExpectMessageSent(rt, inMemory, WithType("OrderCreated"), WithBody(&OrderCreated{ID: "2"}))
+	g.Expect(msg).To(BeNil())
+	g.Expect(rt.errors).To(HaveLen(1))
+	g.Expect(rt.errors[0]).To(ContainSubstring("body is"))
+
+	rt.errors = nil
+	ExpectMessageSent(rt, inMemory, WithProperty("tenant", "y"))
+	g.Expect(rt.errors).To(HaveLen(1))
+	g.Expect(rt.errors[0]).To(ContainSubstring("application property \"tenant\""))
+
+	rt.errors = nil
+	ExpectNoMessageSent(rt, inMemory, WithType("OrderCreated"))
+	ExpectMessageCount(rt, inMemory, 2)
+	g.Expect(rt.errors).To(HaveLen(2))
+}
@@ -0,0 +1,112 @@
+package shuttletest
+
+import (
+	"sync"
+	"time"
+
+	"github.com/Azure/go-shuttle/v2"
+)
+
+var _ shuttle.Clock = &FakeClock{}
+
+// FakeClock is a shuttle.Clock that only moves forward when Advance is called.
+// It allows to test timeouts and lock renewals without real sleeps.
+type FakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+// NewFakeClock creates a FakeClock set to now.
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+// Now returns the current time of the fake clock.
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// After returns a channel that receives the fake clock's time once it has been advanced by d.
+func (c *FakeClock) After(d time.Duration) <-chan time.Time {
+	return c.NewTimer(d).C()
+}
+
+// NewTimer creates a timer that fires once the fake clock has been advanced by d.
+func (c *FakeClock) NewTimer(d time.Duration) shuttle.Timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTimer{clock: c, c: make(chan time.Time, 1)}
+	c.schedule(t, d)
+	return t
+}
+
+// Advance moves the fake clock forward by d and fires the timers that are due.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	pending := c.timers[:0]
+	for _, t := range c.timers {
+		if t.deadline.After(c.now) {
+			pending = append(pending, t)
+			continue
+		}
+		select {
+		case t.c <- c.now:
+		default:
+		}
+	}
+	c.timers = pending
+}
+
+// PendingTimers returns the number of timers waiting on the fake clock.
+// It allows tests to wait for the code under test to start waiting before calling Advance.
+func (c *FakeClock) PendingTimers() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.timers)
+}
+
+// schedule must be called with the clock lock held.
+func (c *FakeClock) schedule(t *fakeTimer, d time.Duration) {
+	t.deadline = c.now.Add(d)
+	c.timers = append(c.timers, t)
+}
+
+// unschedule must be called with the clock lock held.
+func (c *FakeClock) unschedule(t *fakeTimer) bool {
+	for i, pending := range c.timers {
+		if pending == t {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+type fakeTimer struct {
+	clock    *FakeClock
+	c        chan time.Time
+	deadline time.Time
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	return t.clock.unschedule(t)
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	active := t.clock.unschedule(t)
+	t.clock.schedule(t, d)
+	return active
+}
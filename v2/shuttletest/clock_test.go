@@ -0,0 +1,43 @@
+package shuttletest
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestFakeClock_Advance(t *testing.T) {
+	g := NewWithT(t)
+	start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewFakeClock(start)
+	after := clock.After(10 * time.Second)
+	timer := clock.NewTimer(20 * time.Second)
+	g.Expect(clock.PendingTimers()).To(Equal(2))
+
+	clock.Advance(5 * time.Second)
+	g.Expect(clock.Now()).To(Equal(start.Add(5 * time.Second)))
+	g.Expect(after).ToNot(Receive())
+
+	clock.Advance(5 * time.Second)
+	g.Expect(after).To(Receive(Equal(start.Add(10 * time.Second))))
+	g.Expect(clock.PendingTimers()).To(Equal(1))
+
+	g.Expect(timer.Stop()).To(BeTrue())
+	g.Expect(timer.Stop()).To(BeFalse())
+	clock.Advance(time.Minute)
+	g.Expect(timer.C()).ToNot(Receive())
+}
+
+func TestFakeClock_Reset(t *testing.T) {
+	g := NewWithT(t)
+	clock := NewFakeClock(time.Now())
+	timer := clock.NewTimer(time.Second)
+	g.Expect(timer.Reset(time.Minute)).To(BeTrue())
+	clock.Advance(time.Second)
+	g.Expect(timer.C()).ToNot(Receive())
+	clock.Advance(time.Minute)
+	g.Expect(timer.C()).To(Receive())
+	g.Expect(timer.Reset(time.Second)).To(BeFalse())
+	g.Expect(clock.PendingTimers()).To(Equal(1))
+}
@@ -0,0 +1,186 @@
+package shuttletest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+
+	"github.com/Azure/go-shuttle/v2"
+)
+
+// ErrInjectedTransientFault is returned by the fault injecting wrappers when a transient error is injected.
+var ErrInjectedTransientFault = errors.New("injected transient fault")
+
+// FaultInjectionOptions configures the faults injected by FaultInjectingSender and FaultInjectingReceiver.
+// Probabilities are expressed between 0 (never) and 1 (always). The zero value injects no fault.
+type FaultInjectionOptions struct {
+	// Latency is added before the call to the wrapped sender or receiver, with a probability of LatencyProbability.
+	Latency            time.Duration
+	LatencyProbability float64
+	// TransientErrorProbability is the probability of failing a call with ErrInjectedTransientFault.
+	TransientErrorProbability float64
+	// LockLostProbability is the probability of failing a settlement or lock renewal
+	// with an *azservicebus.Error with code azservicebus.CodeLockLost.
+	LockLostProbability float64
+	// ConnectionDropProbability is the probability of failing a call
+	// with an *azservicebus.Error with code azservicebus.CodeConnectionLost.
+	ConnectionDropProbability float64
+	// Seed allows a reproducible sequence of faults. Defaults to a time based seed when 0.
+	Seed int64
+}
+
+type faultInjector struct {
+	options FaultInjectionOptions
+	mu      sync.Mutex
+	rand    *rand.Rand
+}
+
+func newFaultInjector(options *FaultInjectionOptions) *faultInjector {
+	f := &faultInjector{}
+	if options != nil {
+		f.options = *options
+	}
+	seed := f.options.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	f.rand = rand.New(rand.NewSource(seed))
+	return f
+}
+
+func (f *faultInjector) roll(probability float64) bool {
+	if probability <= 0 {
+		return false
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.rand.Float64() < probability
+}
+
+// inject applies the configured latency and returns the fault to inject, if any.
+// lock lost faults are only injected on operations that require the message lock.
+func (f *faultInjector) inject(ctx context.Context, operation string, requiresLock bool) error {
+	if f.options.Latency > 0 && f.roll(f.options.LatencyProbability) {
+		select {
+		case <-time.After(f.options.Latency):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if f.roll(f.options.ConnectionDropProbability) {
+		return fmt.Errorf("%s: %w", operation, &azservicebus.Error{Code: azservicebus.CodeConnectionLost})
+	}
+	if f.roll(f.options.TransientErrorProbability) {
+		return fmt.Errorf("%s: %w", operation, ErrInjectedTransientFault)
+	}
+	if requiresLock && f.roll(f.options.LockLostProbability) {
+		return fmt.Errorf("%s: %w", operation, &azservicebus.Error{Code: azservicebus.CodeLockLost})
+	}
+	return nil
+}
+
+var _ shuttle.AzServiceBusSender = &FaultInjectingSender{}
+
+// FaultInjectingSender wraps a shuttle.AzServiceBusSender and injects faults before delegating the calls.
+type FaultInjectingSender struct {
+	next     shuttle.AzServiceBusSender
+	injector *faultInjector
+}
+
+// NewFaultInjectingSender wraps the sender with the fault injection configured by options.
+func NewFaultInjectingSender(sender shuttle.AzServiceBusSender, options *FaultInjectionOptions) *FaultInjectingSender {
+	return &FaultInjectingSender{next: sender, injector: newFaultInjector(options)}
+}
+
+func (s *FaultInjectingSender) SendMessage(ctx context.Context, message *azservicebus.Message, options *azservicebus.SendMessageOptions) error {
+	if err := s.injector.inject(ctx, "send message", false); err != nil {
+		return err
+	}
+	return s.next.SendMessage(ctx, message, options)
+}
+
+func (s *FaultInjectingSender) SendMessageBatch(ctx context.Context, batch *azservicebus.MessageBatch, options *azservicebus.SendMessageBatchOptions) error {
+	if err := s.injector.inject(ctx, "send message batch", false); err != nil {
+		return err
+	}
+	return s.next.SendMessageBatch(ctx, batch, options)
+}
+
+func (s *FaultInjectingSender) NewMessageBatch(ctx context.Context, options *azservicebus.MessageBatchOptions) (*azservicebus.MessageBatch, error) {
+	return s.next.NewMessageBatch(ctx, options)
+}
+
+func (s *FaultInjectingSender) ScheduleMessages(ctx context.Context, messages []*azservicebus.Message, scheduledEnqueueTime time.Time, options *azservicebus.ScheduleMessagesOptions) ([]int64, error) {
+	if err := s.injector.inject(ctx, "schedule messages", false); err != nil {
+		return nil, err
+	}
+	return s.next.ScheduleMessages(ctx, messages, scheduledEnqueueTime, options)
+}
+
+func (s *FaultInjectingSender) CancelScheduledMessages(ctx context.Context, sequenceNumbers []int64, options *azservicebus.CancelScheduledMessagesOptions) error {
+	if err := s.injector.inject(ctx, "cancel scheduled messages", false); err != nil {
+		return err
+	}
+	return s.next.CancelScheduledMessages(ctx, sequenceNumbers, options)
+}
+
+var _ shuttle.Receiver = &FaultInjectingReceiver{}
+
+// FaultInjectingReceiver wraps a shuttle.Receiver and injects faults before delegating the calls.
+// Lock lost faults are only injected on settlement and lock renewal.
+type FaultInjectingReceiver struct {
+	next     shuttle.Receiver
+	injector *faultInjector
+}
+
+// NewFaultInjectingReceiver wraps the receiver with the fault injection configured by options.
+func NewFaultInjectingReceiver(receiver shuttle.Receiver, options *FaultInjectionOptions) *FaultInjectingReceiver {
+	return &FaultInjectingReceiver{next: receiver, injector: newFaultInjector(options)}
+}
+
+func (r *FaultInjectingReceiver) ReceiveMessages(ctx context.Context, maxMessages int, options *azservicebus.ReceiveMessagesOptions) ([]*azservicebus.ReceivedMessage, error) {
+	if err := r.injector.inject(ctx, "receive messages", false); err != nil {
+		return nil, err
+	}
+	return r.next.ReceiveMessages(ctx, maxMessages, options)
+}
+
+func (r *FaultInjectingReceiver) AbandonMessage(ctx context.Context, message *azservicebus.ReceivedMessage, options *azservicebus.AbandonMessageOptions) error {
+	if err := r.injector.inject(ctx, "abandon message", true); err != nil {
+		return err
+	}
+	return r.next.AbandonMessage(ctx, message, options)
+}
+
+func (r *FaultInjectingReceiver) CompleteMessage(ctx context.Context, message *azservicebus.ReceivedMessage, options *azservicebus.CompleteMessageOptions) error {
+	if err := r.injector.inject(ctx, "complete message", true); err != nil {
+		return err
+	}
+	return r.next.CompleteMessage(ctx, message, options)
+}
+
+func (r *FaultInjectingReceiver) DeadLetterMessage(ctx context.Context, message *azservicebus.ReceivedMessage, options *azservicebus.DeadLetterOptions) error {
+	if err := r.injector.inject(ctx, "dead letter message", true); err != nil {
+		return err
+	}
+	return r.next.DeadLetterMessage(ctx, message, options)
+}
+
+func (r *FaultInjectingReceiver) DeferMessage(ctx context.Context, message *azservicebus.ReceivedMessage, options *azservicebus.DeferMessageOptions) error {
+	if err := r.injector.inject(ctx, "defer message", true); err != nil {
+		return err
+	}
+	return r.next.DeferMessage(ctx, message, options)
+}
+
+func (r *FaultInjectingReceiver) RenewMessageLock(ctx context.Context, message *azservicebus.ReceivedMessage, options *azservicebus.RenewMessageLockOptions) error {
+	if err := r.injector.inject(ctx, "renew message lock", true); err != nil {
+		return err
+	}
+	return r.next.RenewMessageLock(ctx, message, options)
+}
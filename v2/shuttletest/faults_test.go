@@ -0,0 +1,104 @@
+package shuttletest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	. "github.com/onsi/gomega"
+)
+
+type stubReceiver struct {
+	completed int
+}
+
+func (r *stubReceiver) ReceiveMessages(_ context.Context, _ int, _ *azservicebus.ReceiveMessagesOptions) ([]*azservicebus.ReceivedMessage, error) {
+	return []*azservicebus.ReceivedMessage{{}}, nil
+}
+
+func (r *stubReceiver) AbandonMessage(_ context.Context, _ *azservicebus.ReceivedMessage, _ *azservicebus.AbandonMessageOptions) error {
+	return nil
+}
+
+func (r *stubReceiver) CompleteMessage(_ context.Context, _ *azservicebus.ReceivedMessage, _ *azservicebus.CompleteMessageOptions) error {
+	r.completed++
+	return nil
+}
+
+func (r *stubReceiver) DeadLetterMessage(_ context.Context, _ *azservicebus.ReceivedMessage, _ *azservicebus.DeadLetterOptions) error {
+	return nil
+}
+
+func (r *stubReceiver) DeferMessage(_ context.Context, _ *azservicebus.ReceivedMessage, _ *azservicebus.DeferMessageOptions) error {
+	return nil
+}
+
+func (r *stubReceiver) RenewMessageLock(_ context.Context, _ *azservicebus.ReceivedMessage, _ *azservicebus.RenewMessageLockOptions) error {
+	return nil
+}
+
+func TestFaultInjectingSender_NoFaultsByDefault(t *testing.T) {
+	g := NewWithT(t)
+	inMemory := NewInMemorySender(nil)
+	sender := NewFaultInjectingSender(inMemory, nil)
+	g.Expect(sender.SendMessage(context.Background(), &azservicebus.Message{}, nil)).To(Succeed())
+	g.Expect(inMemory.SentMessages()).To(HaveLen(1))
+}
+
+func TestFaultInjectingSender_TransientError(t *testing.T) {
+	g := NewWithT(t)
+	inMemory := NewInMemorySender(nil)
+	sender := NewFaultInjectingSender(inMemory, &FaultInjectionOptions{TransientErrorProbability: 1})
+	err := sender.SendMessage(context.Background(), &azservicebus.Message{}, nil)
+	g.Expect(err).To(MatchError(ErrInjectedTransientFault))
+	g.Expect(inMemory.SentMessages()).To(BeEmpty())
+}
+
+func TestFaultInjectingSender_ConnectionDrop(t *testing.T) {
+	g := NewWithT(t)
+	sender := NewFaultInjectingSender(NewInMemorySender(nil), &FaultInjectionOptions{ConnectionDropProbability: 1})
+	_, err := sender.ScheduleMessages(context.Background(), nil, time.Now(), nil)
+	var sbErr *azservicebus.Error
+	g.Expect(errors.As(err, &sbErr)).To(BeTrue())
+	g.Expect(sbErr.Code).To(Equal(azservicebus.CodeConnectionLost))
+}
+
+func TestFaultInjectingSender_LatencyRespectsContext(t *testing.T) {
+	g := NewWithT(t)
+	sender := NewFaultInjectingSender(NewInMemorySender(nil), &FaultInjectionOptions{
+		Latency:            time.Minute,
+		LatencyProbability: 1,
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := sender.SendMessage(ctx, &azservicebus.Message{}, nil)
+	g.Expect(err).To(MatchError(context.DeadlineExceeded))
+}
+
+func TestFaultInjectingReceiver_LockLostOnlyOnSettlement(t *testing.T) {
+	g := NewWithT(t)
+	stub := &stubReceiver{}
+	receiver := NewFaultInjectingReceiver(stub, &FaultInjectionOptions{LockLostProbability: 1})
+	messages, err := receiver.ReceiveMessages(context.Background(), 1, nil)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(messages).To(HaveLen(1))
+
+	err = receiver.CompleteMessage(context.Background(), messages[0], nil)
+	var sbErr *azservicebus.Error
+	g.Expect(errors.As(err, &sbErr)).To(BeTrue())
+	g.Expect(sbErr.Code).To(Equal(azservicebus.CodeLockLost))
+	g.Expect(stub.completed).To(Equal(0))
+}
+
+func TestFaultInjector_SeedIsReproducible(t *testing.T) {
+	g := NewWithT(t)
+	options := &FaultInjectionOptions{TransientErrorProbability: 0.5, Seed: 42}
+	first, second := newFaultInjector(options), newFaultInjector(options)
+	for i := 0; i < 20; i++ {
+		firstErr := first.inject(context.Background(), "op", false)
+		secondErr := second.inject(context.Background(), "op", false)
+		g.Expect(firstErr == nil).To(Equal(secondErr == nil))
+	}
+}
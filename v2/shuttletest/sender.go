@@ -0,0 +1,116 @@
+// Package shuttletest provides in-memory fakes and assertion helpers to test code using go-shuttle.
+package shuttletest
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+
+	"github.com/Azure/go-shuttle/v2"
+)
+
+var _ shuttle.AzServiceBusSender = &InMemorySender{}
+
+// InMemorySender is an in-memory implementation of shuttle.AzServiceBusSender.
+// It records the messages sent or scheduled through it so that they can be asserted on in tests.
+type InMemorySender struct {
+	// Marshaller is used to unmarshal the body of the captured messages in assertions.
+	// Defaults to shuttle.DefaultJSONMarshaller.
+	Marshaller shuttle.Marshaller
+
+	mu           sync.Mutex
+	sent         []*azservicebus.Message
+	scheduled    map[int64]*azservicebus.Message
+	nextSequence int64
+}
+
+// NewInMemorySender creates an InMemorySender using the given marshaller to decode captured message bodies.
+// a nil marshaller defaults to shuttle.DefaultJSONMarshaller.
+func NewInMemorySender(marshaller shuttle.Marshaller) *InMemorySender {
+	return &InMemorySender{Marshaller: marshaller}
+}
+
+func (s *InMemorySender) marshaller() shuttle.Marshaller {
+	if s.Marshaller == nil {
+		return &shuttle.DefaultJSONMarshaller{}
+	}
+	return s.Marshaller
+}
+
+// SendMessage records the message as sent.
+func (s *InMemorySender) SendMessage(_ context.Context, message *azservicebus.Message, _ *azservicebus.SendMessageOptions) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sent = append(s.sent, message)
+	return nil
+}
+
+// SendMessageBatch is not supported: azservicebus.MessageBatch cannot be created or inspected outside the azservicebus package.
+func (s *InMemorySender) SendMessageBatch(_ context.Context, _ *azservicebus.MessageBatch, _ *azservicebus.SendMessageBatchOptions) error {
+	return fmt.Errorf("message batches are not supported by the in-memory sender")
+}
+
+// NewMessageBatch is not supported: azservicebus.MessageBatch cannot be created or inspected outside the azservicebus package.
+func (s *InMemorySender) NewMessageBatch(_ context.Context, _ *azservicebus.MessageBatchOptions) (*azservicebus.MessageBatch, error) {
+	return nil, fmt.Errorf("message batches are not supported by the in-memory sender")
+}
+
+// ScheduleMessages records the messages as scheduled and returns their assigned sequence numbers.
+func (s *InMemorySender) ScheduleMessages(_ context.Context, messages []*azservicebus.Message, scheduledEnqueueTime time.Time, _ *azservicebus.ScheduleMessagesOptions) ([]int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.scheduled == nil {
+		s.scheduled = make(map[int64]*azservicebus.Message)
+	}
+	sequenceNumbers := make([]int64, 0, len(messages))
+	for _, msg := range messages {
+		s.nextSequence++
+		enqueueTime := scheduledEnqueueTime
+		msg.ScheduledEnqueueTime = &enqueueTime
+		s.scheduled[s.nextSequence] = msg
+		sequenceNumbers = append(sequenceNumbers, s.nextSequence)
+	}
+	return sequenceNumbers, nil
+}
+
+// CancelScheduledMessages removes the scheduled messages with the given sequence numbers.
+func (s *InMemorySender) CancelScheduledMessages(_ context.Context, sequenceNumbers []int64, _ *azservicebus.CancelScheduledMessagesOptions) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, seq := range sequenceNumbers {
+		if _, ok := s.scheduled[seq]; !ok {
+			return fmt.Errorf("no scheduled message with sequence number %d", seq)
+		}
+		delete(s.scheduled, seq)
+	}
+	return nil
+}
+
+// SentMessages returns a copy of the messages sent through the sender, in order.
+func (s *InMemorySender) SentMessages() []*azservicebus.Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*azservicebus.Message{}, s.sent...)
+}
+
+// ScheduledMessages returns the messages currently scheduled, indexed by sequence number.
+func (s *InMemorySender) ScheduledMessages() map[int64]*azservicebus.Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	scheduled := make(map[int64]*azservicebus.Message, len(s.scheduled))
+	for seq, msg := range s.scheduled {
+		scheduled[seq] = msg
+	}
+	return scheduled
+}
+
+// Reset clears all the captured messages.
+func (s *InMemorySender) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sent = nil
+	s.scheduled = nil
+}
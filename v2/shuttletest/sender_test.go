@@ -0,0 +1,46 @@
+package shuttletest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	. "github.com/onsi/gomega"
+
+	"github.com/Azure/go-shuttle/v2"
+)
+
+func TestInMemorySender_SendMessage(t *testing.T) {
+	g := NewWithT(t)
+	inMemory := NewInMemorySender(nil)
+	sender := shuttle.NewSender(inMemory, nil)
+	g.Expect(sender.SendMessage(context.Background(), "test")).To(Succeed())
+	g.Expect(inMemory.SentMessages()).To(HaveLen(1))
+	g.Expect(string(inMemory.SentMessages()[0].Body)).To(Equal("\"test\""))
+	inMemory.Reset()
+	g.Expect(inMemory.SentMessages()).To(BeEmpty())
+}
+
+func TestInMemorySender_SendMessageBatchNotSupported(t *testing.T) {
+	g := NewWithT(t)
+	sender := shuttle.NewSender(NewInMemorySender(nil), nil)
+	g.Expect(sender.SendMessageBatch(context.Background(), nil)).ToNot(Succeed())
+}
+
+func TestInMemorySender_ScheduleAndCancel(t *testing.T) {
+	g := NewWithT(t)
+	inMemory := NewInMemorySender(nil)
+	sender := shuttle.NewSender(inMemory, nil)
+	enqueueTime := time.Now().Add(time.Hour)
+	seqs, err := sender.ScheduleMessages(context.Background(),
+		[]*azservicebus.Message{{Body: []byte("a")}, {Body: []byte("b")}}, enqueueTime)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(seqs).To(Equal([]int64{1, 2}))
+	g.Expect(inMemory.ScheduledMessages()).To(HaveLen(2))
+	g.Expect(*inMemory.ScheduledMessages()[1].ScheduledEnqueueTime).To(Equal(enqueueTime))
+
+	g.Expect(sender.CancelScheduledMessages(context.Background(), []int64{1})).To(Succeed())
+	g.Expect(inMemory.ScheduledMessages()).To(HaveLen(1))
+	g.Expect(sender.CancelScheduledMessages(context.Background(), []int64{1})).ToNot(Succeed())
+}
@@ -0,0 +1,141 @@
+package shuttletest
+
+import (
+	"context"
+	"sync"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+
+	"github.com/Azure/go-shuttle/v2"
+)
+
+var _ shuttle.MessageSource = &InMemorySource{}
+
+// InMemorySource is an in-memory shuttle.MessageSource serving the messages added to it, in order.
+// Like the azservicebus receiver, ReceiveMessages blocks until at least one message is available or the context is done.
+type InMemorySource struct {
+	mu       sync.Mutex
+	messages []*azservicebus.ReceivedMessage
+	// available is closed and replaced when messages are added, to wake up the pending receive calls.
+	available chan struct{}
+}
+
+// NewInMemorySource creates an InMemorySource serving the messages.
+func NewInMemorySource(messages ...*azservicebus.ReceivedMessage) *InMemorySource {
+	return &InMemorySource{messages: messages, available: make(chan struct{})}
+}
+
+// Add appends messages to serve.
+func (s *InMemorySource) Add(messages ...*azservicebus.ReceivedMessage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messages = append(s.messages, messages...)
+	close(s.available)
+	s.available = make(chan struct{})
+}
+
+// Len returns the number of messages not received yet.
+func (s *InMemorySource) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.messages)
+}
+
+// ReceiveMessages returns up to maxMessages messages, blocking until at least one is available or ctx is done.
+func (s *InMemorySource) ReceiveMessages(ctx context.Context, maxMessages int, _ *azservicebus.ReceiveMessagesOptions) ([]*azservicebus.ReceivedMessage, error) {
+	for {
+		s.mu.Lock()
+		if len(s.messages) > 0 {
+			n := maxMessages
+			if n > len(s.messages) {
+				n = len(s.messages)
+			}
+			received := s.messages[:n:n]
+			s.messages = s.messages[n:]
+			s.mu.Unlock()
+			return received, nil
+		}
+		available := s.available
+		s.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-available:
+		}
+	}
+}
+
+var _ shuttle.MessageSettler = &RecordingSettler{}
+
+// RecordingSettler is a shuttle.MessageSettler recording the settled messages, to assert on them in tests.
+type RecordingSettler struct {
+	mu           sync.Mutex
+	completed    []*azservicebus.ReceivedMessage
+	abandoned    []*azservicebus.ReceivedMessage
+	deadLettered []*azservicebus.ReceivedMessage
+	deferred     []*azservicebus.ReceivedMessage
+	renewed      []*azservicebus.ReceivedMessage
+}
+
+// NewRecordingSettler creates an empty RecordingSettler.
+func NewRecordingSettler() *RecordingSettler {
+	return &RecordingSettler{}
+}
+
+func (s *RecordingSettler) record(list *[]*azservicebus.ReceivedMessage, message *azservicebus.ReceivedMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	*list = append(*list, message)
+	return nil
+}
+
+func (s *RecordingSettler) get(list *[]*azservicebus.ReceivedMessage) []*azservicebus.ReceivedMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*azservicebus.ReceivedMessage{}, *list...)
+}
+
+func (s *RecordingSettler) AbandonMessage(_ context.Context, message *azservicebus.ReceivedMessage, _ *azservicebus.AbandonMessageOptions) error {
+	return s.record(&s.abandoned, message)
+}
+
+func (s *RecordingSettler) CompleteMessage(_ context.Context, message *azservicebus.ReceivedMessage, _ *azservicebus.CompleteMessageOptions) error {
+	return s.record(&s.completed, message)
+}
+
+func (s *RecordingSettler) DeadLetterMessage(_ context.Context, message *azservicebus.ReceivedMessage, _ *azservicebus.DeadLetterOptions) error {
+	return s.record(&s.deadLettered, message)
+}
+
+func (s *RecordingSettler) DeferMessage(_ context.Context, message *azservicebus.ReceivedMessage, _ *azservicebus.DeferMessageOptions) error {
+	return s.record(&s.deferred, message)
+}
+
+func (s *RecordingSettler) RenewMessageLock(_ context.Context, message *azservicebus.ReceivedMessage, _ *azservicebus.RenewMessageLockOptions) error {
+	return s.record(&s.renewed, message)
+}
+
+// Completed returns the completed messages.
+func (s *RecordingSettler) Completed() []*azservicebus.ReceivedMessage {
+	return s.get(&s.completed)
+}
+
+// Abandoned returns the abandoned messages.
+func (s *RecordingSettler) Abandoned() []*azservicebus.ReceivedMessage {
+	return s.get(&s.abandoned)
+}
+
+// DeadLettered returns the dead-lettered messages.
+func (s *RecordingSettler) DeadLettered() []*azservicebus.ReceivedMessage {
+	return s.get(&s.deadLettered)
+}
+
+// Deferred returns the deferred messages.
+func (s *RecordingSettler) Deferred() []*azservicebus.ReceivedMessage {
+	return s.get(&s.deferred)
+}
+
+// Renewed returns the messages which lock was renewed, once per renewal.
+func (s *RecordingSettler) Renewed() []*azservicebus.ReceivedMessage {
+	return s.get(&s.renewed)
+}
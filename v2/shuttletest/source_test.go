@@ -0,0 +1,60 @@
+package shuttletest_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	. "github.com/onsi/gomega"
+
+	"github.com/Azure/go-shuttle/v2"
+	"github.com/Azure/go-shuttle/v2/shuttletest"
+)
+
+func TestInMemorySource_DrivesProcessor(t *testing.T) {
+	g := NewWithT(t)
+	source := shuttletest.NewInMemorySource(
+		&azservicebus.ReceivedMessage{MessageID: "1", Body: []byte("ok")},
+		&azservicebus.ReceivedMessage{MessageID: "2", Body: []byte("poison")})
+	settler := shuttletest.NewRecordingSettler()
+	processor := shuttle.NewProcessorWithOptions(shuttle.NewSourceReceiver(source, settler),
+		func(ctx context.Context, settler shuttle.MessageSettler, message *azservicebus.ReceivedMessage) {
+			if string(message.Body) == "poison" {
+				_ = settler.DeadLetterMessage(ctx, message, nil)
+				return
+			}
+			_ = settler.CompleteMessage(ctx, message, nil)
+		},
+		shuttle.WithMaxConcurrency(2),
+		shuttle.WithReceiveInterval(time.Millisecond))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error)
+	go func() { done <- processor.Start(ctx) }()
+
+	g.Eventually(func() int { return len(settler.Completed()) + len(settler.DeadLettered()) }).Should(Equal(2))
+	source.Add(&azservicebus.ReceivedMessage{MessageID: "3", Body: []byte("ok")})
+	g.Eventually(func() int { return len(settler.Completed()) }).Should(Equal(2))
+	g.Expect(settler.DeadLettered()[0].MessageID).To(Equal("2"))
+	g.Expect(source.Len()).To(Equal(0))
+
+	cancel()
+	g.Eventually(done).Should(Receive(MatchError(context.Canceled)))
+}
+
+func TestInMemorySource_ReceiveBlocksUntilMessages(t *testing.T) {
+	g := NewWithT(t)
+	source := shuttletest.NewInMemorySource()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := source.ReceiveMessages(ctx, 1, nil)
+	g.Expect(err).To(MatchError(context.DeadlineExceeded))
+
+	source.Add(&azservicebus.ReceivedMessage{SequenceNumber: to.Ptr(int64(1))}, &azservicebus.ReceivedMessage{SequenceNumber: to.Ptr(int64(2))})
+	messages, err := source.ReceiveMessages(context.Background(), 1, nil)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(messages).To(HaveLen(1))
+	g.Expect(*messages[0].SequenceNumber).To(Equal(int64(1)))
+}
@@ -0,0 +1,223 @@
+package shuttle
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+)
+
+// Application properties carrying the signature of a message, set by SignMessage.
+const (
+	// SignatureProperty is the base64 encoded HMAC-SHA256 of the signed headers and the body.
+	SignatureProperty = "goshuttle-signature"
+	// SignatureKeyIDProperty is the ID of the SigningKey used to sign the message.
+	SignatureKeyIDProperty = "goshuttle-signature-key-id"
+)
+
+// DeadLetterReasonSignatureInvalid is set by NewSignatureVerificationHandler on the messages failing the verification.
+const DeadLetterReasonSignatureInvalid = "SignatureInvalid"
+
+// ErrNoSigningKey is returned by SignMessage when the keyring has no current key.
+var ErrNoSigningKey = errors.New("no signing key")
+
+// SigningKey is a shared secret signing the messages, identified by its ID so that the keys can be rotated.
+type SigningKey struct {
+	ID     string
+	Secret []byte
+}
+
+// SigningKeyring holds the signing keys.
+// To rotate the keys, the consumers first accept the new key, then the producers sign with it,
+// and the old key is removed once the messages it signed are consumed.
+type SigningKeyring interface {
+	// Current returns the key signing the messages. It returns false when the keyring cannot sign.
+	Current() (SigningKey, bool)
+	// Key returns the key with the ID, to verify the messages.
+	Key(id string) (SigningKey, bool)
+}
+
+// StaticKeyring is a SigningKeyring signing with its first key and verifying with all its keys.
+type StaticKeyring []SigningKey
+
+func (k StaticKeyring) Current() (SigningKey, bool) {
+	if len(k) == 0 {
+		return SigningKey{}, false
+	}
+	return k[0], true
+}
+
+func (k StaticKeyring) Key(id string) (SigningKey, bool) {
+	for _, key := range k {
+		if key.ID == id {
+			return key, true
+		}
+	}
+	return SigningKey{}, false
+}
+
+// SigningOptions configures the signing and the verification of the messages.
+// The producers and the consumers of an entity must use the same Headers.
+type SigningOptions struct {
+	// Keyring holds the signing keys.
+	Keyring SigningKeyring
+	// Headers are the headers signed with the body: the MessageID, Subject, ContentType, CorrelationID, To,
+	// ReplyTo, SessionID and PartitionKey of the message, or the names of its application properties.
+	// The signature fails when one of them is modified, or removed.
+	// Only sign the MessageID when the producers set it, as the broker assigns it to the messages sent without.
+	Headers []string
+	// AllowUnsigned passes the messages without signature to next, while the producers are rolling out the signing.
+	// The messages with an invalid signature are still dead-lettered. Only used by NewSignatureVerificationHandler.
+	AllowUnsigned bool
+}
+
+// SignMessage is a message option signing the body and the Headers of the message with the current key of the keyring.
+// It must be applied after the options setting the signed headers.
+func SignMessage(options SigningOptions) func(msg *azservicebus.Message) error {
+	return func(msg *azservicebus.Message) error {
+		key, ok := options.Keyring.Current()
+		if !ok {
+			return fmt.Errorf("failed to sign message: %w", ErrNoSigningKey)
+		}
+		if msg.ApplicationProperties == nil {
+			msg.ApplicationProperties = map[string]any{}
+		}
+		msg.ApplicationProperties[SignatureKeyIDProperty] = key.ID
+		msg.ApplicationProperties[SignatureProperty] = signature(key, options.Headers, func(header string) (string, bool) {
+			return messageHeader(msg, header)
+		}, msg.Body)
+		return nil
+	}
+}
+
+// WithSenderSigning applies SignMessage on all the messages sent and scheduled through the sender,
+// after the other options.
+func WithSenderSigning(options SigningOptions) SenderOption {
+	return func(o *SenderOptions) {
+		o.Signing = &options
+	}
+}
+
+// NewSignatureVerificationHandler is a middleware verifying the signature set by SignMessage, to reject the messages
+// of the producers writing to the wrong entity, or without the shared key.
+// The messages without signature, signed with an unknown key, or which signature does not match are dead-lettered
+// with the DeadLetterReasonSignatureInvalid reason.
+func NewSignatureVerificationHandler(options SigningOptions, next Handler) HandlerFunc {
+	return func(ctx context.Context, settler MessageSettler, message *azservicebus.ReceivedMessage) {
+		err := verifySignature(options, message)
+		if err == nil || errors.Is(err, errUnsigned) && options.AllowUnsigned {
+			next.Handle(ctx, settler, message)
+			return
+		}
+		log(ctx, fmt.Sprintf("dead-lettering message %s: %s", message.MessageID, err))
+		reason := DeadLetterReasonSignatureInvalid
+		description := err.Error()
+		if err := settler.DeadLetterMessage(ctx, message, &azservicebus.DeadLetterOptions{
+			Reason:           &reason,
+			ErrorDescription: &description,
+		}); err != nil {
+			log(ctx, fmt.Sprintf("failed to dead-letter message %s: %s", message.MessageID, err))
+		}
+	}
+}
+
+var errUnsigned = errors.New("message is not signed")
+
+func verifySignature(options SigningOptions, message *azservicebus.ReceivedMessage) error {
+	signed, ok := message.ApplicationProperties[SignatureProperty].(string)
+	if !ok {
+		return errUnsigned
+	}
+	keyID, _ := message.ApplicationProperties[SignatureKeyIDProperty].(string)
+	key, ok := options.Keyring.Key(keyID)
+	if !ok {
+		return fmt.Errorf("message is signed with unknown key %q", keyID)
+	}
+	expected := signature(key, options.Headers, func(header string) (string, bool) {
+		return receivedMessageHeader(message, header)
+	}, message.Body)
+	if !hmac.Equal([]byte(signed), []byte(expected)) {
+		return fmt.Errorf("signature of key %q does not match", keyID)
+	}
+	return nil
+}
+
+// signature returns the HMAC of the headers, each on its own line, followed by the body.
+// The missing headers are signed as such, so that a header cannot be moved to another one.
+func signature(key SigningKey, headers []string, header func(name string) (string, bool), body []byte) string {
+	mac := hmac.New(sha256.New, key.Secret)
+	for _, name := range headers {
+		value, ok := header(name)
+		if !ok {
+			fmt.Fprintf(mac, "%s!\n", name)
+			continue
+		}
+		fmt.Fprintf(mac, "%s:%q\n", name, value)
+	}
+	mac.Write([]byte("\n"))
+	mac.Write(body)
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func messageHeader(msg *azservicebus.Message, name string) (string, bool) {
+	switch name {
+	case "MessageID":
+		return deref(msg.MessageID)
+	case "Subject":
+		return deref(msg.Subject)
+	case "ContentType":
+		return deref(msg.ContentType)
+	case "CorrelationID":
+		return deref(msg.CorrelationID)
+	case "To":
+		return deref(msg.To)
+	case "ReplyTo":
+		return deref(msg.ReplyTo)
+	case "SessionID":
+		return deref(msg.SessionID)
+	case "PartitionKey":
+		return deref(msg.PartitionKey)
+	}
+	return propertyHeader(msg.ApplicationProperties, name)
+}
+
+func receivedMessageHeader(message *azservicebus.ReceivedMessage, name string) (string, bool) {
+	switch name {
+	case "MessageID":
+		return message.MessageID, true
+	case "Subject":
+		return deref(message.Subject)
+	case "ContentType":
+		return deref(message.ContentType)
+	case "CorrelationID":
+		return deref(message.CorrelationID)
+	case "To":
+		return deref(message.To)
+	case "ReplyTo":
+		return deref(message.ReplyTo)
+	case "SessionID":
+		return deref(message.SessionID)
+	case "PartitionKey":
+		return deref(message.PartitionKey)
+	}
+	return propertyHeader(message.ApplicationProperties, name)
+}
+
+func propertyHeader(properties map[string]any, name string) (string, bool) {
+	value, ok := properties[name]
+	if !ok {
+		return "", false
+	}
+	return fmt.Sprint(value), true
+}
+
+func deref(value *string) (string, bool) {
+	if value == nil {
+		return "", false
+	}
+	return *value, true
+}
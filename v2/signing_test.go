@@ -0,0 +1,222 @@
+package shuttle_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	. "github.com/onsi/gomega"
+
+	"github.com/Azure/go-shuttle/v2"
+	"github.com/Azure/go-shuttle/v2/shuttletest"
+)
+
+func receivedFrom(msg *azservicebus.Message) *azservicebus.ReceivedMessage {
+	return &azservicebus.ReceivedMessage{
+		MessageID:             "broker-assigned",
+		Body:                  msg.Body,
+		Subject:               msg.Subject,
+		ContentType:           msg.ContentType,
+		ApplicationProperties: msg.ApplicationProperties,
+	}
+}
+
+func TestSignatureVerificationHandler(t *testing.T) {
+	oldKey := shuttle.SigningKey{ID: "2023", Secret: []byte("old secret")}
+	newKey := shuttle.SigningKey{ID: "2024", Secret: []byte("new secret")}
+	headers := []string{"Subject", "tenant"}
+	sign := func(key shuttle.SigningKey, msg *azservicebus.Message) *azservicebus.ReceivedMessage {
+		err := shuttle.SignMessage(shuttle.SigningOptions{Keyring: shuttle.StaticKeyring{key}, Headers: headers})(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return receivedFrom(msg)
+	}
+	newMessage := func() *azservicebus.Message {
+		return &azservicebus.Message{
+			Body:                  []byte(`{"id":1}`),
+			Subject:               to.Ptr("orders"),
+			ApplicationProperties: map[string]any{"tenant": "contoso", "attempt": 2},
+		}
+	}
+	testCases := []struct {
+		name          string
+		message       func() *azservicebus.ReceivedMessage
+		allowUnsigned bool
+		handled       bool
+	}{
+		{
+			name:    "valid signature",
+			message: func() *azservicebus.ReceivedMessage { return sign(newKey, newMessage()) },
+			handled: true,
+		},
+		{
+			name:    "signed with the previous key during the rotation",
+			message: func() *azservicebus.ReceivedMessage { return sign(oldKey, newMessage()) },
+			handled: true,
+		},
+		{
+			name: "unsigned header modified",
+			message: func() *azservicebus.ReceivedMessage {
+				message := sign(newKey, newMessage())
+				message.ApplicationProperties["attempt"] = 3
+				return message
+			},
+			handled: true,
+		},
+		{
+			name: "body modified",
+			message: func() *azservicebus.ReceivedMessage {
+				message := sign(newKey, newMessage())
+				message.Body = []byte(`{"id":2}`)
+				return message
+			},
+		},
+		{
+			name: "signed header modified",
+			message: func() *azservicebus.ReceivedMessage {
+				message := sign(newKey, newMessage())
+				message.Subject = to.Ptr("invoices")
+				return message
+			},
+		},
+		{
+			name: "signed header removed",
+			message: func() *azservicebus.ReceivedMessage {
+				message := sign(newKey, newMessage())
+				delete(message.ApplicationProperties, "tenant")
+				return message
+			},
+		},
+		{
+			name: "unknown key",
+			message: func() *azservicebus.ReceivedMessage {
+				return sign(shuttle.SigningKey{ID: "other", Secret: []byte("other secret")}, newMessage())
+			},
+		},
+		{
+			name: "wrong secret",
+			message: func() *azservicebus.ReceivedMessage {
+				return sign(shuttle.SigningKey{ID: newKey.ID, Secret: []byte("guessed")}, newMessage())
+			},
+		},
+		{
+			name:    "unsigned",
+			message: func() *azservicebus.ReceivedMessage { return receivedFrom(newMessage()) },
+		},
+		{
+			name:          "unsigned allowed during the rollout",
+			message:       func() *azservicebus.ReceivedMessage { return receivedFrom(newMessage()) },
+			allowUnsigned: true,
+			handled:       true,
+		},
+		{
+			name: "invalid signature with unsigned allowed",
+			message: func() *azservicebus.ReceivedMessage {
+				message := sign(newKey, newMessage())
+				message.Body = nil
+				return message
+			},
+			allowUnsigned: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+			settler := shuttletest.NewRecordingSettler()
+			handled := false
+			h := shuttle.NewSignatureVerificationHandler(shuttle.SigningOptions{
+				Keyring:       shuttle.StaticKeyring{newKey, oldKey},
+				Headers:       headers,
+				AllowUnsigned: tc.allowUnsigned,
+			}, shuttle.HandlerFunc(func(ctx context.Context, settler shuttle.MessageSettler, message *azservicebus.ReceivedMessage) {
+				handled = true
+			}))
+			h.Handle(context.Background(), settler, tc.message())
+			g.Expect(handled).To(Equal(tc.handled))
+			if tc.handled {
+				g.Expect(settler.DeadLettered()).To(BeEmpty())
+			} else {
+				g.Expect(settler.DeadLettered()).To(HaveLen(1))
+			}
+		})
+	}
+}
+
+func TestSignMessage_NoKey(t *testing.T) {
+	g := NewWithT(t)
+	err := shuttle.SignMessage(shuttle.SigningOptions{Keyring: shuttle.StaticKeyring{}})(&azservicebus.Message{})
+	g.Expect(errors.Is(err, shuttle.ErrNoSigningKey)).To(BeTrue())
+}
+
+func TestSender_Signing(t *testing.T) {
+	g := NewWithT(t)
+	options := shuttle.SigningOptions{
+		Keyring: shuttle.StaticKeyring{{ID: "2024", Secret: []byte("secret")}},
+		Headers: []string{"MessageID", "tenant"},
+	}
+	azSender := shuttletest.NewInMemorySender(nil)
+	sender := shuttle.NewSenderWithOptions(azSender, shuttle.WithSenderSigning(options))
+	err := sender.SendMessage(context.Background(), "hello",
+		shuttle.SetMessageId(to.Ptr("id-1")),
+		func(msg *azservicebus.Message) error {
+			msg.ApplicationProperties["tenant"] = "contoso"
+			return nil
+		})
+	g.Expect(err).ToNot(HaveOccurred())
+	scheduled := &azservicebus.Message{MessageID: to.Ptr("id-2"), Body: []byte("later")}
+	_, err = sender.ScheduleMessages(context.Background(), []*azservicebus.Message{scheduled}, time.Now().Add(time.Hour))
+	g.Expect(err).ToNot(HaveOccurred())
+
+	settler := shuttletest.NewRecordingSettler()
+	var handled []string
+	h := shuttle.NewSignatureVerificationHandler(options, shuttle.HandlerFunc(
+		func(ctx context.Context, settler shuttle.MessageSettler, message *azservicebus.ReceivedMessage) {
+			handled = append(handled, message.MessageID)
+		}))
+	for _, msg := range append(azSender.SentMessages(), scheduled) {
+		g.Expect(msg.ApplicationProperties).To(HaveKeyWithValue(shuttle.SignatureKeyIDProperty, "2024"))
+		received := receivedFrom(msg)
+		received.MessageID = *msg.MessageID
+		h.Handle(context.Background(), settler, received)
+	}
+	g.Expect(handled).To(Equal([]string{"id-1", "id-2"}))
+	g.Expect(settler.DeadLettered()).To(BeEmpty())
+}
+
+func TestSender_SigningOversizedMessage(t *testing.T) {
+	g := NewWithT(t)
+	options := shuttle.SigningOptions{Keyring: shuttle.StaticKeyring{{ID: "2024", Secret: []byte("secret")}}}
+	azSender := shuttletest.NewInMemorySender(nil)
+	sender := shuttle.NewSenderWithOptions(azSender,
+		shuttle.WithSenderSigning(options),
+		shuttle.WithMaxMessageSize(shuttle.StaticMaxMessageSize(200)),
+		shuttle.WithOversizedMessageHandler(func(ctx context.Context, msg *azservicebus.Message, err *shuttle.ErrMessageTooLarge) (*azservicebus.Message, error) {
+			msg.Body = []byte(`"claim-check://blob/1"`)
+			return msg, nil
+		}))
+	g.Expect(sender.SendMessage(context.Background(), strings.Repeat("a", 200))).To(Succeed())
+	scheduled, err := sender.ToServiceBusMessage(context.Background(), strings.Repeat("c", 200))
+	g.Expect(err).ToNot(HaveOccurred())
+	_, err = sender.ScheduleMessages(context.Background(), []*azservicebus.Message{scheduled}, time.Now().Add(time.Hour))
+	g.Expect(err).ToNot(HaveOccurred())
+
+	settler := shuttletest.NewRecordingSettler()
+	handled := 0
+	h := shuttle.NewSignatureVerificationHandler(options, shuttle.HandlerFunc(
+		func(ctx context.Context, settler shuttle.MessageSettler, message *azservicebus.ReceivedMessage) {
+			handled++
+		}))
+	messages := append(azSender.SentMessages(), scheduled)
+	g.Expect(messages).To(HaveLen(2))
+	for _, msg := range messages {
+		g.Expect(msg.Body).To(Equal([]byte(`"claim-check://blob/1"`)))
+		h.Handle(context.Background(), settler, receivedFrom(msg))
+	}
+	g.Expect(handled).To(Equal(2))
+	g.Expect(settler.DeadLettered()).To(BeEmpty())
+}
@@ -0,0 +1,162 @@
+package shuttle
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+
+	"github.com/Azure/go-shuttle/v2/metrics/processor"
+)
+
+// ProcessorStats is a snapshot of the processor's message pump statistics since it was created.
+// It is independent of the prometheus metrics, and allows applications to expose the numbers in their own status endpoints.
+type ProcessorStats struct {
+	// MessagesReceived is the total number of messages received from the receiver.
+	MessagesReceived int64
+	// MessagesCompleted is the number of messages successfully completed by the handler.
+	MessagesCompleted int64
+	// MessagesAbandoned is the number of messages successfully abandoned by the handler.
+	MessagesAbandoned int64
+	// MessagesDeadLettered is the number of messages successfully dead-lettered by the handler.
+	MessagesDeadLettered int64
+	// MessagesDeferred is the number of messages successfully deferred by the handler.
+	MessagesDeferred int64
+	// MessagesHandled is the number of messages for which the handler returned.
+	MessagesHandled int64
+	// InFlight is the number of messages currently being handled.
+	InFlight int64
+	// AverageHandleTime is the average time spent in the handler for the handled messages.
+	AverageHandleTime time.Duration
+	// LastReceiveError is the last error returned by the receiver, if any.
+	LastReceiveError error
+	// LastReceiveErrorTime is the time at which LastReceiveError occurred.
+	LastReceiveErrorTime time.Time
+}
+
+// processorStats records the processor statistics. it is safe for concurrent use.
+type processorStats struct {
+	received        atomic.Int64
+	completed       atomic.Int64
+	abandoned       atomic.Int64
+	deadLettered    atomic.Int64
+	deferred        atomic.Int64
+	handled         atomic.Int64
+	inFlight        atomic.Int64
+	totalHandleTime atomic.Int64
+
+	mu                   sync.Mutex
+	lastReceiveError     error
+	lastReceiveErrorTime time.Time
+}
+
+func (s *processorStats) recordReceiveError(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastReceiveError = err
+	s.lastReceiveErrorTime = time.Now()
+}
+
+func (s *processorStats) recordHandled(duration time.Duration) {
+	s.totalHandleTime.Add(int64(duration))
+	s.handled.Add(1)
+}
+
+func (s *processorStats) snapshot() ProcessorStats {
+	s.mu.Lock()
+	lastErr, lastErrTime := s.lastReceiveError, s.lastReceiveErrorTime
+	s.mu.Unlock()
+	stats := ProcessorStats{
+		MessagesReceived:     s.received.Load(),
+		MessagesCompleted:    s.completed.Load(),
+		MessagesAbandoned:    s.abandoned.Load(),
+		MessagesDeadLettered: s.deadLettered.Load(),
+		MessagesDeferred:     s.deferred.Load(),
+		MessagesHandled:      s.handled.Load(),
+		InFlight:             s.inFlight.Load(),
+		LastReceiveError:     lastErr,
+		LastReceiveErrorTime: lastErrTime,
+	}
+	if stats.MessagesHandled > 0 {
+		stats.AverageHandleTime = time.Duration(s.totalHandleTime.Load() / stats.MessagesHandled)
+	}
+	return stats
+}
+
+// statsSettler counts the successful settlements made through the wrapped MessageSettler,
+// and records them in the processor metrics.
+// The message is counted as unsettled, in the unsettled_message_count metric, until its first successful settlement
+// or until release is called once the handler returns.
+// The settlement attempts are reported to the OnSettled hook.
+type statsSettler struct {
+	MessageSettler
+	stats   *processorStats
+	entity  string
+	settled atomic.Bool
+	hooks   *Hooks
+	start   time.Time
+}
+
+func newStatsSettler(settler MessageSettler, stats *processorStats, entity string) *statsSettler {
+	processor.Metric.IncUnsettledMessageCount(entity)
+	return &statsSettler{MessageSettler: settler, stats: stats, entity: entity}
+}
+
+// Unwrap returns the wrapped MessageSettler, for UnwrapSettler.
+func (s *statsSettler) Unwrap() MessageSettler {
+	return s.MessageSettler
+}
+
+// UnwrapSettler returns the settler underlying the wrappers of the Processor and the middlewares,
+// following the chain of the settlers with an Unwrap() MessageSettler method.
+// The settler is returned as-is when it does not wrap another one.
+func UnwrapSettler(settler MessageSettler) MessageSettler {
+	for {
+		wrapper, ok := settler.(interface{ Unwrap() MessageSettler })
+		if !ok {
+			return settler
+		}
+		settler = wrapper.Unwrap()
+	}
+}
+
+func (s *statsSettler) AbandonMessage(ctx context.Context, message *azservicebus.ReceivedMessage, options *azservicebus.AbandonMessageOptions) error {
+	return s.count(ctx, message, processor.SettlementAbandon, &s.stats.abandoned, s.MessageSettler.AbandonMessage(ctx, message, options))
+}
+
+func (s *statsSettler) CompleteMessage(ctx context.Context, message *azservicebus.ReceivedMessage, options *azservicebus.CompleteMessageOptions) error {
+	return s.count(ctx, message, processor.SettlementComplete, &s.stats.completed, s.MessageSettler.CompleteMessage(ctx, message, options))
+}
+
+func (s *statsSettler) DeadLetterMessage(ctx context.Context, message *azservicebus.ReceivedMessage, options *azservicebus.DeadLetterOptions) error {
+	return s.count(ctx, message, processor.SettlementDeadLetter, &s.stats.deadLettered, s.MessageSettler.DeadLetterMessage(ctx, message, options))
+}
+
+func (s *statsSettler) DeferMessage(ctx context.Context, message *azservicebus.ReceivedMessage, options *azservicebus.DeferMessageOptions) error {
+	return s.count(ctx, message, processor.SettlementDefer, &s.stats.deferred, s.MessageSettler.DeferMessage(ctx, message, options))
+}
+
+func (s *statsSettler) count(ctx context.Context, message *azservicebus.ReceivedMessage, settlement string, counter *atomic.Int64, err error) error {
+	s.hooks.settled(ctx, SettledEvent{
+		Entity:        s.entity,
+		Message:       message,
+		Settlement:    settlement,
+		SinceReceived: time.Since(s.start),
+		Err:           err,
+	})
+	if err == nil {
+		counter.Add(1)
+		processor.Metric.IncMessageSettled(message, s.entity, settlement)
+		s.release()
+	}
+	return err
+}
+
+// release stops counting the message as unsettled. It is a no-op after the first call.
+func (s *statsSettler) release() {
+	if s.settled.CompareAndSwap(false, true) {
+		processor.Metric.DecUnsettledMessageCount(s.entity)
+	}
+}
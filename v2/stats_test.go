@@ -0,0 +1,95 @@
+package shuttle_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	. "github.com/onsi/gomega"
+
+	"github.com/Azure/go-shuttle/v2"
+)
+
+func TestProcessor_Stats(t *testing.T) {
+	g := NewWithT(t)
+	rcv := &fakeReceiver{
+		fakeSettler:           &fakeSettler{},
+		SetupReceivedMessages: messagesChannel(3),
+		SetupMaxReceiveCalls:  4,
+	}
+	close(rcv.SetupReceivedMessages)
+	handled := 0
+	handler := shuttle.HandlerFunc(func(ctx context.Context, settler shuttle.MessageSettler, message *azservicebus.ReceivedMessage) {
+		time.Sleep(5 * time.Millisecond)
+		handled++
+		var err error
+		switch handled {
+		case 1:
+			err = settler.CompleteMessage(ctx, message, nil)
+		case 2:
+			err = settler.AbandonMessage(ctx, message, nil)
+		default:
+			err = settler.DeadLetterMessage(ctx, message, nil)
+		}
+		g.Expect(err).ToNot(HaveOccurred())
+	})
+	processor := shuttle.NewProcessor(rcv, handler, &shuttle.ProcessorOptions{
+		MaxConcurrency:  1,
+		ReceiveInterval: to.Ptr(20 * time.Millisecond),
+	})
+	g.Expect(processor.Stats()).To(Equal(shuttle.ProcessorStats{}))
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+	err := processor.Start(ctx)
+	g.Expect(err).To(MatchError("max receive calls exceeded"))
+	g.Eventually(func() int64 { return processor.Stats().MessagesHandled }).Should(Equal(int64(3)))
+	stats := processor.Stats()
+	g.Expect(stats.MessagesReceived).To(Equal(int64(3)))
+	g.Expect(stats.MessagesCompleted).To(Equal(int64(1)))
+	g.Expect(stats.MessagesAbandoned).To(Equal(int64(1)))
+	g.Expect(stats.MessagesDeadLettered).To(Equal(int64(1)))
+	g.Expect(stats.InFlight).To(Equal(int64(0)))
+	g.Expect(stats.AverageHandleTime).To(BeNumerically(">=", 5*time.Millisecond))
+	g.Expect(stats.LastReceiveError).To(MatchError("max receive calls exceeded"))
+	g.Expect(stats.LastReceiveErrorTime).To(BeTemporally("~", time.Now(), time.Second))
+}
+
+func TestProcessor_StatsReceiveError(t *testing.T) {
+	g := NewWithT(t)
+	rcv := &fakeReceiver{
+		fakeSettler:           &fakeSettler{},
+		SetupReceivedMessages: messagesChannel(0),
+		SetupMaxReceiveCalls:  2,
+		SetupReceiveError:     fmt.Errorf("receive failure"),
+	}
+	close(rcv.SetupReceivedMessages)
+	processor := shuttle.NewProcessor(rcv, MyHandler(0), &shuttle.ProcessorOptions{MaxConcurrency: 1})
+	err := processor.Start(context.Background())
+	g.Expect(err).To(MatchError("receive failure"))
+	g.Expect(processor.Stats().LastReceiveError).To(MatchError("receive failure"))
+	g.Expect(processor.Stats().MessagesReceived).To(Equal(int64(0)))
+}
+
+func TestProcessor_UnwrapSettler(t *testing.T) {
+	g := NewWithT(t)
+	rcv := &fakeReceiver{
+		fakeSettler:           &fakeSettler{},
+		SetupReceivedMessages: messagesChannel(1),
+		SetupMaxReceiveCalls:  2,
+	}
+	close(rcv.SetupReceivedMessages)
+	unwrapped := make(chan shuttle.MessageSettler, 1)
+	handler := shuttle.HandlerFunc(func(ctx context.Context, settler shuttle.MessageSettler, message *azservicebus.ReceivedMessage) {
+		unwrapped <- shuttle.UnwrapSettler(settler)
+	})
+	processor := shuttle.NewProcessor(rcv, handler, &shuttle.ProcessorOptions{
+		MaxConcurrency:       1,
+		SettlementThrottling: &shuttle.SettlementThrottlingOptions{},
+	})
+	g.Expect(processor.Start(context.Background())).To(MatchError("max receive calls exceeded"))
+	g.Eventually(unwrapped).Should(Receive(BeIdenticalTo(rcv)))
+	g.Expect(shuttle.UnwrapSettler(rcv)).To(BeIdenticalTo(rcv))
+}
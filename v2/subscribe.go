@@ -0,0 +1,162 @@
+package shuttle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus/admin"
+)
+
+// TypeFilterRuleName is the name of the subscription rule filtering the messages on their type.
+const TypeFilterRuleName = "goshuttle-type"
+
+// defaultRuleName is the rule created by the broker with a subscription when no rule is given, accepting all the messages.
+const defaultRuleName = "$Default"
+
+// ErrSubscriptionFilterMismatch is returned by SubscribeToType when the existing subscription does not filter
+// the messages on the type. The subscription is not modified.
+var ErrSubscriptionFilterMismatch = errors.New("subscription filter does not match the message type")
+
+// Validator is implemented by the message bodies that validate themselves, see NewTypedHandler.
+type Validator interface {
+	Validate() error
+}
+
+// TypedHandlerFunc handles the decoded body of a message. Its error is handled by the ManagedSettler.
+type TypedHandlerFunc[T any] func(ctx context.Context, message *azservicebus.ReceivedMessage, body *T) error
+
+// SubscribeOptions configures SubscribeToType.
+type SubscribeOptions struct {
+	// Admin provisions the subscription with a correlation filter on the type, or validates the filter
+	// of the existing subscription. Defaults to nil, receiving from the subscription as is.
+	Admin *admin.Client
+	// Marshaller unmarshals the message bodies. Defaults to DefaultJSONMarshaller.
+	Marshaller Marshaller
+	// Settling configures the settlement of the messages. Defaults to the ManagedSettler defaults.
+	Settling *ManagedSettlingOptions
+	// ReceiverOptions are the options of the subscription receiver.
+	ReceiverOptions *azservicebus.ReceiverOptions
+	// ProcessorOptions configure the processor. The entity name defaults to topic/subscription.
+	ProcessorOptions []ProcessorOption
+}
+
+// subscriptionAdmin is satisfied by *admin.Client.
+type subscriptionAdmin interface {
+	GetSubscription(ctx context.Context, topicName string, subscriptionName string, options *admin.GetSubscriptionOptions) (*admin.GetSubscriptionResponse, error)
+	CreateSubscription(ctx context.Context, topicName string, subscriptionName string, options *admin.CreateSubscriptionOptions) (admin.CreateSubscriptionResponse, error)
+	GetRule(ctx context.Context, topicName string, subscriptionName string, ruleName string, options *admin.GetRuleOptions) (*admin.GetRuleResponse, error)
+}
+
+// SubscribeToType receives the messages of type T sent by the Sender on the topic, and handles their decoded body.
+// With an Admin client, the subscription is created with a correlation filter on the type property
+// when it does not exist, or validated when it does. It blocks until ctx is done, like Processor.Start.
+func SubscribeToType[T any](ctx context.Context, client *azservicebus.Client, topic, subscription string, handler TypedHandlerFunc[T], options *SubscribeOptions) error {
+	opts := SubscribeOptions{}
+	if options != nil {
+		opts = *options
+	}
+	if opts.Admin != nil {
+		if err := ensureTypeSubscription[T](ctx, opts.Admin, topic, subscription); err != nil {
+			return err
+		}
+	}
+	receiver, err := client.NewReceiverForSubscription(topic, subscription, opts.ReceiverOptions)
+	if err != nil {
+		return fmt.Errorf("failed to create receiver for %s/%s: %w", topic, subscription, err)
+	}
+	defer receiver.Close(context.WithoutCancel(ctx))
+	processorOptions := append([]ProcessorOption{WithEntityName(topic + "/" + subscription)}, opts.ProcessorOptions...)
+	processor := NewProcessorWithOptions(receiver, NewTypedHandler(opts.Marshaller, opts.Settling, handler), processorOptions...)
+	return processor.Start(ctx)
+}
+
+// NewTypedHandler decodes the body of the messages into a T with the marshaller, defaulting to DefaultJSONMarshaller,
+// and settles them with a ManagedSettler after handler. The body is decoded once, see DecodedBody.
+// The messages that cannot be decoded are dead-lettered, as retrying them would fail the same way.
+// When *T implements Validator, the messages which body fails the validation are dead-lettered as well,
+// with the DeadLetterReasonValidationFailed reason.
+func NewTypedHandler[T any](marshaller Marshaller, settling *ManagedSettlingOptions, handler TypedHandlerFunc[T]) HandlerFunc {
+	if marshaller == nil {
+		marshaller = &DefaultJSONMarshaller{}
+	}
+	managed := NewManagedSettlingHandler(settling, ManagedSettlingFunc(func(ctx context.Context, message *azservicebus.ReceivedMessage) error {
+		body, err := DecodedBody[T](ctx, marshaller, message)
+		if err != nil {
+			return err
+		}
+		return handler(ctx, message, body)
+	}))
+	return NewDecodedBodyHandler(HandlerFunc(func(ctx context.Context, settler MessageSettler, message *azservicebus.ReceivedMessage) {
+		body, err := DecodedBody[T](ctx, marshaller, message)
+		if err != nil {
+			deadLetterInvalidBody(ctx, settler, message, DeadLetterReasonUnmarshalFailed, err)
+			return
+		}
+		if validator, ok := any(body).(Validator); ok {
+			if err := validator.Validate(); err != nil {
+				deadLetterInvalidBody(ctx, settler, message, DeadLetterReasonValidationFailed, err)
+				return
+			}
+		}
+		managed.Handle(ctx, settler, message)
+	}))
+}
+
+func deadLetterInvalidBody(ctx context.Context, settler MessageSettler, message *azservicebus.ReceivedMessage, reason string, err error) {
+	log(ctx, fmt.Sprintf("dead-lettering message %s: %s", message.MessageID, err))
+	description := err.Error()
+	if err := settler.DeadLetterMessage(ctx, message, &azservicebus.DeadLetterOptions{
+		Reason:           &reason,
+		ErrorDescription: &description,
+	}); err != nil {
+		log(ctx, fmt.Sprintf("failed to dead-letter message %s: %s", message.MessageID, err))
+	}
+}
+
+// TypeFilter returns the correlation filter matching the messages of type T sent by the Sender.
+func TypeFilter[T any]() *admin.CorrelationFilter {
+	return &admin.CorrelationFilter{
+		ApplicationProperties: map[string]any{msgTypeField: messageTypeName(reflect.TypeOf((*T)(nil)).Elem())},
+	}
+}
+
+// ensureTypeSubscription creates the subscription filtering the messages of type T,
+// or checks that the existing one only receives them.
+func ensureTypeSubscription[T any](ctx context.Context, client subscriptionAdmin, topic, subscription string) error {
+	filter := TypeFilter[T]()
+	existing, err := client.GetSubscription(ctx, topic, subscription, nil)
+	if err != nil {
+		return fmt.Errorf("failed to get subscription %s/%s: %w", topic, subscription, err)
+	}
+	if existing == nil {
+		_, err := client.CreateSubscription(ctx, topic, subscription, &admin.CreateSubscriptionOptions{
+			Properties: &admin.SubscriptionProperties{
+				DefaultRule: &admin.RuleProperties{Name: TypeFilterRuleName, Filter: filter},
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to create subscription %s/%s: %w", topic, subscription, err)
+		}
+		return nil
+	}
+	rule, err := client.GetRule(ctx, topic, subscription, TypeFilterRuleName, nil)
+	if err != nil {
+		return fmt.Errorf("failed to get rule %s of subscription %s/%s: %w", TypeFilterRuleName, topic, subscription, err)
+	}
+	if rule == nil || !reflect.DeepEqual(rule.Filter, filter) {
+		return fmt.Errorf("%w: %s/%s has no rule %s on type %v", ErrSubscriptionFilterMismatch, topic, subscription,
+			TypeFilterRuleName, filter.ApplicationProperties[msgTypeField])
+	}
+	defaultRule, err := client.GetRule(ctx, topic, subscription, defaultRuleName, nil)
+	if err != nil {
+		return fmt.Errorf("failed to get rule %s of subscription %s/%s: %w", defaultRuleName, topic, subscription, err)
+	}
+	if defaultRule != nil {
+		return fmt.Errorf("%w: %s/%s also receives all the messages with rule %s", ErrSubscriptionFilterMismatch,
+			topic, subscription, defaultRuleName)
+	}
+	return nil
+}
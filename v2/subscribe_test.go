@@ -0,0 +1,129 @@
+package shuttle
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus/admin"
+	. "github.com/onsi/gomega"
+)
+
+type fakeSubscriptionAdmin struct {
+	subscription *admin.GetSubscriptionResponse
+	rules        map[string]admin.RuleProperties
+	created      *admin.CreateSubscriptionOptions
+}
+
+func (f *fakeSubscriptionAdmin) GetSubscription(_ context.Context, _ string, _ string, _ *admin.GetSubscriptionOptions) (*admin.GetSubscriptionResponse, error) {
+	return f.subscription, nil
+}
+
+func (f *fakeSubscriptionAdmin) CreateSubscription(_ context.Context, _ string, _ string, options *admin.CreateSubscriptionOptions) (admin.CreateSubscriptionResponse, error) {
+	f.created = options
+	return admin.CreateSubscriptionResponse{}, nil
+}
+
+func (f *fakeSubscriptionAdmin) GetRule(_ context.Context, _ string, _ string, ruleName string, _ *admin.GetRuleOptions) (*admin.GetRuleResponse, error) {
+	rule, ok := f.rules[ruleName]
+	if !ok {
+		return nil, nil
+	}
+	return &admin.GetRuleResponse{RuleProperties: rule}, nil
+}
+
+func TestTypeFilter(t *testing.T) {
+	g := NewWithT(t)
+	filter := TypeFilter[ContosoCreateUserRequest]()
+	g.Expect(filter.ApplicationProperties).To(Equal(map[string]any{"type": "ContosoCreateUserRequest"}))
+	// matches the type set by the Sender
+	g.Expect(filter.ApplicationProperties["type"]).To(Equal(getMessageType(testStruct)))
+}
+
+func TestEnsureTypeSubscription(t *testing.T) {
+	g := NewWithT(t)
+	client := &fakeSubscriptionAdmin{}
+	g.Expect(ensureTypeSubscription[ContosoCreateUserRequest](context.Background(), client, "topic", "users")).To(Succeed())
+	g.Expect(client.created).ToNot(BeNil())
+	g.Expect(client.created.Properties.DefaultRule.Name).To(Equal(TypeFilterRuleName))
+	g.Expect(client.created.Properties.DefaultRule.Filter).To(Equal(TypeFilter[ContosoCreateUserRequest]()))
+
+	// existing subscription with the type filter
+	client = &fakeSubscriptionAdmin{
+		subscription: &admin.GetSubscriptionResponse{},
+		rules: map[string]admin.RuleProperties{
+			TypeFilterRuleName: {Name: TypeFilterRuleName, Filter: TypeFilter[ContosoCreateUserRequest]()},
+		},
+	}
+	g.Expect(ensureTypeSubscription[ContosoCreateUserRequest](context.Background(), client, "topic", "users")).To(Succeed())
+	g.Expect(client.created).To(BeNil())
+
+	// filter on another type
+	err := ensureTypeSubscription[map[string]any](context.Background(), client, "topic", "users")
+	g.Expect(errors.Is(err, ErrSubscriptionFilterMismatch)).To(BeTrue())
+
+	// the default rule receives all the messages
+	client.rules[defaultRuleName] = admin.RuleProperties{Name: defaultRuleName, Filter: &admin.TrueFilter{}}
+	err = ensureTypeSubscription[ContosoCreateUserRequest](context.Background(), client, "topic", "users")
+	g.Expect(errors.Is(err, ErrSubscriptionFilterMismatch)).To(BeTrue())
+	g.Expect(client.created).To(BeNil())
+}
+
+func TestNewTypedHandler(t *testing.T) {
+	g := NewWithT(t)
+	msg, err := (&DefaultJSONMarshaller{}).Marshal(testStruct)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	var handled *ContosoCreateUserRequest
+	handler := NewTypedHandler[ContosoCreateUserRequest](nil, nil,
+		func(ctx context.Context, message *azservicebus.ReceivedMessage, body *ContosoCreateUserRequest) error {
+			handled = body
+			return nil
+		})
+	settler := &fakeSettler{}
+	handler(context.Background(), settler, &azservicebus.ReceivedMessage{Body: msg.Body})
+	g.Expect(handled).To(Equal(testStruct))
+	g.Expect(settler.completed).To(BeTrue())
+
+	// invalid bodies are dead-lettered without calling the handler
+	handled = nil
+	settler = &fakeSettler{}
+	handler(context.Background(), settler, &azservicebus.ReceivedMessage{Body: []byte("{")})
+	g.Expect(handled).To(BeNil())
+	g.Expect(settler.deadlettered).To(BeTrue())
+	g.Expect(*settler.deadletterOptions.Reason).To(Equal("UnmarshalFailed"))
+}
+
+type validatedOrder struct {
+	Quantity int `json:"quantity"`
+}
+
+func (o *validatedOrder) Validate() error {
+	if o.Quantity <= 0 {
+		return errors.New("quantity must be positive")
+	}
+	return nil
+}
+
+func TestNewTypedHandler_Validation(t *testing.T) {
+	g := NewWithT(t)
+	handled := 0
+	handler := NewTypedHandler[validatedOrder](nil, nil,
+		func(ctx context.Context, message *azservicebus.ReceivedMessage, body *validatedOrder) error {
+			handled++
+			return nil
+		})
+
+	settler := &fakeSettler{}
+	handler(context.Background(), settler, &azservicebus.ReceivedMessage{Body: []byte(`{"quantity":1}`)})
+	g.Expect(handled).To(Equal(1))
+	g.Expect(settler.completed).To(BeTrue())
+
+	settler = &fakeSettler{}
+	handler(context.Background(), settler, &azservicebus.ReceivedMessage{Body: []byte(`{"quantity":0}`)})
+	g.Expect(handled).To(Equal(1))
+	g.Expect(settler.deadlettered).To(BeTrue())
+	g.Expect(*settler.deadletterOptions.Reason).To(Equal(DeadLetterReasonValidationFailed))
+	g.Expect(*settler.deadletterOptions.ErrorDescription).To(Equal("quantity must be positive"))
+}
@@ -0,0 +1,153 @@
+package shuttle
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+)
+
+const defaultSweepPageSize = 100
+
+// MessagePeeker peeks messages without locking them. It is satisfied by *azservicebus.Receiver.
+type MessagePeeker interface {
+	PeekMessages(ctx context.Context, maxMessageCount int, options *azservicebus.PeekMessagesOptions) ([]*azservicebus.ReceivedMessage, error)
+}
+
+// ScheduledMessagePredicate selects the scheduled messages to cancel.
+type ScheduledMessagePredicate func(msg *azservicebus.ReceivedMessage) bool
+
+// ScheduledWithType selects the scheduled messages of the given type, as set by the Sender.
+func ScheduledWithType(msgType string) ScheduledMessagePredicate {
+	return func(msg *azservicebus.ReceivedMessage) bool {
+		value, ok := msg.ApplicationProperties[msgTypeField]
+		return ok && value == msgType
+	}
+}
+
+// ScheduledWithCorrelationID selects the scheduled messages with the given CorrelationID.
+func ScheduledWithCorrelationID(correlationID string) ScheduledMessagePredicate {
+	return func(msg *azservicebus.ReceivedMessage) bool {
+		return msg.CorrelationID != nil && *msg.CorrelationID == correlationID
+	}
+}
+
+// ScheduledBefore selects the messages that were scheduled before the given time.
+func ScheduledBefore(t time.Time) ScheduledMessagePredicate {
+	return func(msg *azservicebus.ReceivedMessage) bool {
+		return msg.EnqueuedTime != nil && msg.EnqueuedTime.Before(t)
+	}
+}
+
+// ScheduledFor selects the messages scheduled to be enqueued after the given time.
+func ScheduledFor(after time.Time) ScheduledMessagePredicate {
+	return func(msg *azservicebus.ReceivedMessage) bool {
+		return msg.ScheduledEnqueueTime != nil && msg.ScheduledEnqueueTime.After(after)
+	}
+}
+
+// AllOf selects the messages matching all the predicates.
+func AllOf(predicates ...ScheduledMessagePredicate) ScheduledMessagePredicate {
+	return func(msg *azservicebus.ReceivedMessage) bool {
+		for _, predicate := range predicates {
+			if !predicate(msg) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// SweepOptions configures SweepScheduledMessages.
+type SweepOptions struct {
+	// PageSize is the number of messages peeked, and cancelled, per request. Defaults to 100.
+	PageSize int
+	// DryRun only lists the matching messages without cancelling them.
+	DryRun bool
+}
+
+// SweepResult reports the outcome of SweepScheduledMessages.
+type SweepResult struct {
+	// Inspected is the number of scheduled messages that were peeked.
+	Inspected int
+	// Matched are the scheduled messages selected by the predicate.
+	Matched []*azservicebus.ReceivedMessage
+	// Cancelled is the number of matched messages that were cancelled. It is 0 in dry run.
+	Cancelled int
+}
+
+// ListScheduledMessages peeks all the messages of the entity and returns the ones in the scheduled state.
+func ListScheduledMessages(ctx context.Context, peeker MessagePeeker, pageSize int) ([]*azservicebus.ReceivedMessage, error) {
+	var scheduled []*azservicebus.ReceivedMessage
+	err := peekScheduledMessages(ctx, peeker, pageSize, func(page []*azservicebus.ReceivedMessage) error {
+		scheduled = append(scheduled, page...)
+		return nil
+	})
+	return scheduled, err
+}
+
+// SweepScheduledMessages cancels the scheduled messages selected by the predicate,
+// for example to clean up bad messages scheduled far in the future.
+// The messages are peeked from the receiver of the entity and cancelled through the sender to the same entity.
+// Use SweepOptions.DryRun to review the matching messages before cancelling them.
+// The returned result reflects the messages cancelled before an error occurred.
+func SweepScheduledMessages(
+	ctx context.Context,
+	peeker MessagePeeker,
+	sender *Sender,
+	predicate ScheduledMessagePredicate,
+	options *SweepOptions) (*SweepResult, error) {
+	opts := SweepOptions{}
+	if options != nil {
+		opts = *options
+	}
+	result := &SweepResult{}
+	err := peekScheduledMessages(ctx, peeker, opts.PageSize, func(page []*azservicebus.ReceivedMessage) error {
+		result.Inspected += len(page)
+		var sequenceNumbers []int64
+		for _, msg := range page {
+			if !predicate(msg) {
+				continue
+			}
+			result.Matched = append(result.Matched, msg)
+			if msg.SequenceNumber != nil {
+				sequenceNumbers = append(sequenceNumbers, *msg.SequenceNumber)
+			}
+		}
+		if opts.DryRun || len(sequenceNumbers) == 0 {
+			return nil
+		}
+		if err := sender.CancelScheduledMessages(ctx, sequenceNumbers); err != nil {
+			return err
+		}
+		result.Cancelled += len(sequenceNumbers)
+		return nil
+	})
+	if err != nil {
+		return result, fmt.Errorf("failed to sweep scheduled messages: %w", err)
+	}
+	log(ctx, fmt.Sprintf("swept scheduled messages: inspected %d, matched %d, cancelled %d",
+		result.Inspected, len(result.Matched), result.Cancelled))
+	return result, nil
+}
+
+// peekScheduledMessages pages through the entity from the first sequence number, and calls onPage with the scheduled messages of each page.
+func peekScheduledMessages(
+	ctx context.Context,
+	peeker MessagePeeker,
+	pageSize int,
+	onPage func(page []*azservicebus.ReceivedMessage) error) error {
+	if pageSize <= 0 {
+		pageSize = defaultSweepPageSize
+	}
+	return browsePages(ctx, peeker, pageSize, 0, func(page []*azservicebus.ReceivedMessage) error {
+		var scheduled []*azservicebus.ReceivedMessage
+		for _, msg := range page {
+			if msg.State == azservicebus.MessageStateScheduled {
+				scheduled = append(scheduled, msg)
+			}
+		}
+		return onPage(scheduled)
+	})
+}
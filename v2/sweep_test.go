@@ -0,0 +1,120 @@
+package shuttle_test
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	. "github.com/onsi/gomega"
+
+	"github.com/Azure/go-shuttle/v2"
+	"github.com/Azure/go-shuttle/v2/shuttletest"
+)
+
+// fakePeeker pages through the messages sorted by sequence number, like the broker does.
+type fakePeeker struct {
+	messages  []*azservicebus.ReceivedMessage
+	peekCalls int
+	err       error
+}
+
+func (p *fakePeeker) PeekMessages(_ context.Context, maxMessageCount int, options *azservicebus.PeekMessagesOptions) ([]*azservicebus.ReceivedMessage, error) {
+	p.peekCalls++
+	if p.err != nil {
+		return nil, p.err
+	}
+	var page []*azservicebus.ReceivedMessage
+	for _, msg := range p.messages {
+		if *msg.SequenceNumber >= *options.FromSequenceNumber && len(page) < maxMessageCount {
+			page = append(page, msg)
+		}
+	}
+	return page, nil
+}
+
+// peekerFor exposes the scheduled messages of the sender, and an active message, to the fakePeeker.
+func peekerFor(sender *shuttletest.InMemorySender) *fakePeeker {
+	active := int64(1000)
+	peeker := &fakePeeker{messages: []*azservicebus.ReceivedMessage{{
+		SequenceNumber:        &active,
+		State:                 azservicebus.MessageStateActive,
+		ApplicationProperties: map[string]any{"type": "Bad"},
+	}}}
+	for sequenceNumber, msg := range sender.ScheduledMessages() {
+		sequenceNumber := sequenceNumber
+		peeker.messages = append(peeker.messages, &azservicebus.ReceivedMessage{
+			SequenceNumber:        &sequenceNumber,
+			State:                 azservicebus.MessageStateScheduled,
+			CorrelationID:         msg.CorrelationID,
+			ApplicationProperties: msg.ApplicationProperties,
+			ScheduledEnqueueTime:  msg.ScheduledEnqueueTime,
+		})
+	}
+	sort.Slice(peeker.messages, func(i, j int) bool {
+		return *peeker.messages[i].SequenceNumber < *peeker.messages[j].SequenceNumber
+	})
+	return peeker
+}
+
+func scheduleTyped(t *testing.T, sender *shuttletest.InMemorySender, msgType string, count int, at time.Time) {
+	var msgs []*azservicebus.Message
+	for i := 0; i < count; i++ {
+		msgs = append(msgs, &azservicebus.Message{ApplicationProperties: map[string]any{"type": msgType}})
+	}
+	_, err := sender.ScheduleMessages(context.Background(), msgs, at, nil)
+	NewWithT(t).Expect(err).ToNot(HaveOccurred())
+}
+
+func TestSweepScheduledMessages_CancelsMatchingMessages(t *testing.T) {
+	g := NewWithT(t)
+	inMemory := shuttletest.NewInMemorySender(nil)
+	farFuture := time.Now().Add(365 * 24 * time.Hour)
+	scheduleTyped(t, inMemory, "Bad", 5, farFuture)
+	scheduleTyped(t, inMemory, "Good", 3, farFuture)
+	peeker := peekerFor(inMemory)
+
+	result, err := shuttle.SweepScheduledMessages(context.Background(), peeker, shuttle.NewSender(inMemory, nil),
+		shuttle.AllOf(shuttle.ScheduledWithType("Bad"), shuttle.ScheduledFor(time.Now().Add(24*time.Hour))),
+		&shuttle.SweepOptions{PageSize: 2})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(result.Inspected).To(Equal(8))
+	g.Expect(result.Matched).To(HaveLen(5))
+	g.Expect(result.Cancelled).To(Equal(5))
+	g.Expect(inMemory.ScheduledMessages()).To(HaveLen(3))
+	for _, msg := range inMemory.ScheduledMessages() {
+		g.Expect(msg.ApplicationProperties).To(HaveKeyWithValue("type", "Good"))
+	}
+}
+
+func TestSweepScheduledMessages_DryRun(t *testing.T) {
+	g := NewWithT(t)
+	inMemory := shuttletest.NewInMemorySender(nil)
+	scheduleTyped(t, inMemory, "Bad", 2, time.Now().Add(time.Hour))
+
+	result, err := shuttle.SweepScheduledMessages(context.Background(), peekerFor(inMemory), shuttle.NewSender(inMemory, nil),
+		shuttle.ScheduledWithType("Bad"), &shuttle.SweepOptions{DryRun: true})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(result.Matched).To(HaveLen(2))
+	g.Expect(result.Cancelled).To(Equal(0))
+	g.Expect(inMemory.ScheduledMessages()).To(HaveLen(2))
+}
+
+func TestSweepScheduledMessages_PeekError(t *testing.T) {
+	g := NewWithT(t)
+	peeker := &fakePeeker{err: errors.New("peek failed")}
+	_, err := shuttle.SweepScheduledMessages(context.Background(), peeker, shuttle.NewSender(shuttletest.NewInMemorySender(nil), nil),
+		shuttle.ScheduledWithType("Bad"), nil)
+	g.Expect(err).To(MatchError(ContainSubstring("peek failed")))
+}
+
+func TestListScheduledMessages(t *testing.T) {
+	g := NewWithT(t)
+	inMemory := shuttletest.NewInMemorySender(nil)
+	scheduleTyped(t, inMemory, "Good", 3, time.Now().Add(time.Hour))
+	scheduled, err := shuttle.ListScheduledMessages(context.Background(), peekerFor(inMemory), 0)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(scheduled).To(HaveLen(3))
+}
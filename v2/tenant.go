@@ -0,0 +1,322 @@
+package shuttle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+)
+
+const defaultTenantRefreshInterval = time.Minute
+
+// ErrUnknownTenant is returned when sending to a tenant which is not in the tenant to entity mapping.
+var ErrUnknownTenant = errors.New("unknown tenant")
+
+// TenantEntityProvider returns the queue or topic of each tenant.
+type TenantEntityProvider interface {
+	TenantEntities(ctx context.Context) (map[string]string, error)
+}
+
+// TenantEntityProviderFunc allows to use a func as a TenantEntityProvider.
+type TenantEntityProviderFunc func(ctx context.Context) (map[string]string, error)
+
+func (f TenantEntityProviderFunc) TenantEntities(ctx context.Context) (map[string]string, error) {
+	return f(ctx)
+}
+
+// StaticTenantEntities is a TenantEntityProvider for a fixed set of tenants.
+type StaticTenantEntities map[string]string
+
+func (s StaticTenantEntities) TenantEntities(_ context.Context) (map[string]string, error) {
+	return s, nil
+}
+
+// TenantRouterOptions configures the TenantRouter.
+type TenantRouterOptions struct {
+	// NewSender creates the sender to the entity of a tenant. Required to send messages with the router.
+	NewSender func(ctx context.Context, tenant, entity string) (*Sender, error)
+	// NewProcessor creates the processor of the entity of a tenant. Required to run processors with the router.
+	NewProcessor func(ctx context.Context, tenant, entity string) (*Processor, error)
+	// RefreshInterval is the interval at which Run fetches the tenant to entity mapping. Defaults to 1 minute.
+	RefreshInterval time.Duration
+	// Clock is used to wait between refreshes. Defaults to the system clock.
+	Clock Clock
+}
+
+type tenantSender struct {
+	entity string
+	sender *Sender
+	// users counts the sends in progress, which the sender is drained of before it is closed.
+	users sync.WaitGroup
+}
+
+// close closes the sender once the sends in progress are done, or ctx is done.
+func (s *tenantSender) close(ctx context.Context) error {
+	drained := make(chan struct{})
+	go func() {
+		s.users.Wait()
+		close(drained)
+	}()
+	var drainErr error
+	select {
+	case <-ctx.Done():
+		drainErr = fmt.Errorf("failed to drain tenant sender: %w", ctx.Err())
+	case <-drained:
+	}
+	return errors.Join(drainErr, s.sender.Close(ctx))
+}
+
+type tenantProcessor struct {
+	entity string
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// TenantRouter routes the outgoing messages to the entity of each tenant,
+// and runs a processor per tenant entity, started and stopped as tenants are onboarded or removed.
+type TenantRouter struct {
+	provider TenantEntityProvider
+	options  TenantRouterOptions
+
+	mu         sync.Mutex
+	entities   map[string]string
+	senders    map[string]*tenantSender
+	processors map[string]*tenantProcessor
+	runCtx     context.Context
+}
+
+// NewTenantRouter creates a TenantRouter for the tenants of the provider.
+// The mapping is fetched on the first send, then refreshed by Run or Refresh.
+func NewTenantRouter(provider TenantEntityProvider, options *TenantRouterOptions) *TenantRouter {
+	opts := TenantRouterOptions{}
+	if options != nil {
+		opts = *options
+	}
+	if opts.RefreshInterval <= 0 {
+		opts.RefreshInterval = defaultTenantRefreshInterval
+	}
+	opts.Clock = clockOrDefault(opts.Clock)
+	return &TenantRouter{
+		provider:   provider,
+		options:    opts,
+		senders:    map[string]*tenantSender{},
+		processors: map[string]*tenantProcessor{},
+	}
+}
+
+// SendMessage sends the message to the entity of the tenant.
+// It returns ErrUnknownTenant when the tenant is not in the mapping.
+// A Refresh removing the tenant waits for the send to be done before closing the sender.
+func (r *TenantRouter) SendMessage(ctx context.Context, tenant string, mb MessageBody, options ...func(msg *azservicebus.Message) error) error {
+	s, err := r.acquireSender(ctx, tenant)
+	if err != nil {
+		return err
+	}
+	defer s.users.Done()
+	return s.sender.SendMessage(ctx, mb, options...)
+}
+
+// Sender returns the sender to the entity of the tenant, created on first use.
+// The sender is closed when a Refresh removes the tenant or moves it to another entity:
+// use SendMessage to send without racing with the refreshes.
+func (r *TenantRouter) Sender(ctx context.Context, tenant string) (*Sender, error) {
+	s, err := r.acquireSender(ctx, tenant)
+	if err != nil {
+		return nil, err
+	}
+	s.users.Done()
+	return s.sender, nil
+}
+
+// acquireSender returns the sender of the tenant, counted as used until users.Done is called.
+// The sender is created outside the lock, and discarded when another one was created in the meantime.
+func (r *TenantRouter) acquireSender(ctx context.Context, tenant string) (*tenantSender, error) {
+	if r.options.NewSender == nil {
+		return nil, errors.New("TenantRouterOptions.NewSender is required to send messages")
+	}
+	r.mu.Lock()
+	loaded := r.entities != nil
+	r.mu.Unlock()
+	if !loaded {
+		if err := r.Refresh(ctx); err != nil {
+			return nil, err
+		}
+	}
+	for {
+		r.mu.Lock()
+		entity, ok := r.entities[tenant]
+		if !ok {
+			r.mu.Unlock()
+			return nil, fmt.Errorf("%w: %s", ErrUnknownTenant, tenant)
+		}
+		if s, ok := r.senders[tenant]; ok {
+			s.users.Add(1)
+			r.mu.Unlock()
+			return s, nil
+		}
+		r.mu.Unlock()
+
+		sender, err := r.options.NewSender(ctx, tenant, entity)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create sender for tenant %s: %w", tenant, err)
+		}
+		r.mu.Lock()
+		_, exists := r.senders[tenant]
+		if exists || r.entities[tenant] != entity {
+			// another sender was created, or the tenant moved, while this one was created
+			r.mu.Unlock()
+			if err := sender.Close(ctx); err != nil {
+				log(ctx, fmt.Sprintf("failed to close tenant sender: %s", err))
+			}
+			continue
+		}
+		s := &tenantSender{entity: entity, sender: sender}
+		s.users.Add(1)
+		r.senders[tenant] = s
+		r.mu.Unlock()
+		return s, nil
+	}
+}
+
+// Tenants returns the tenants with a running processor.
+func (r *TenantRouter) Tenants() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	tenants := make([]string, 0, len(r.processors))
+	for tenant := range r.processors {
+		tenants = append(tenants, tenant)
+	}
+	return tenants
+}
+
+// Run refreshes the tenant to entity mapping every RefreshInterval until ctx is done,
+// starting a processor for each onboarded tenant and stopping the processors of the removed tenants.
+// A processor that stops with an error is restarted on the next refresh.
+// Run returns once all the processors are stopped.
+func (r *TenantRouter) Run(ctx context.Context) error {
+	if r.options.NewProcessor == nil {
+		return errors.New("TenantRouterOptions.NewProcessor is required to run processors")
+	}
+	r.mu.Lock()
+	r.runCtx = ctx
+	r.mu.Unlock()
+	defer r.stopProcessors()
+	for {
+		if err := r.Refresh(ctx); err != nil && ctx.Err() == nil {
+			log(ctx, fmt.Sprintf("failed to refresh tenants: %s", err))
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-r.options.Clock.After(r.options.RefreshInterval):
+		}
+	}
+}
+
+// Refresh fetches the tenant to entity mapping.
+// The senders of removed tenants, or of tenants moved to another entity, are replaced at once for the new sends,
+// and closed once their sends in progress are done.
+// When Run is active, the processors are started and stopped to match the mapping.
+func (r *TenantRouter) Refresh(ctx context.Context) error {
+	entities, err := r.provider.TenantEntities(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to fetch tenant entities: %w", err)
+	}
+	current := make(map[string]string, len(entities))
+	for tenant, entity := range entities {
+		current[tenant] = entity
+	}
+
+	r.mu.Lock()
+	r.entities = current
+	var closing []*tenantSender
+	for tenant, s := range r.senders {
+		if entity, ok := current[tenant]; !ok || entity != s.entity {
+			closing = append(closing, s)
+			delete(r.senders, tenant)
+		}
+	}
+	var stopping []*tenantProcessor
+	for tenant, p := range r.processors {
+		if entity, ok := current[tenant]; !ok || entity != p.entity {
+			stopping = append(stopping, p)
+			delete(r.processors, tenant)
+		}
+	}
+	var starting []string
+	if r.runCtx != nil && r.runCtx.Err() == nil {
+		for tenant := range current {
+			if _, ok := r.processors[tenant]; !ok {
+				starting = append(starting, tenant)
+			}
+		}
+	}
+	r.mu.Unlock()
+
+	for _, p := range stopping {
+		p.cancel()
+		<-p.done
+	}
+	for _, s := range closing {
+		if err := s.close(ctx); err != nil {
+			log(ctx, fmt.Sprintf("failed to close tenant sender: %s", err))
+		}
+	}
+	for _, tenant := range starting {
+		r.startProcessor(tenant, current[tenant])
+	}
+	return nil
+}
+
+// startProcessor creates the processor of the tenant outside the lock, and starts it unless Run stopped,
+// or another processor was started for the tenant, in the meantime.
+func (r *TenantRouter) startProcessor(tenant, entity string) {
+	r.mu.Lock()
+	runCtx := r.runCtx
+	_, running := r.processors[tenant]
+	r.mu.Unlock()
+	if runCtx == nil || runCtx.Err() != nil || running {
+		return
+	}
+	processor, err := r.options.NewProcessor(runCtx, tenant, entity)
+	if err != nil {
+		log(runCtx, fmt.Sprintf("failed to create processor for tenant %s: %s", tenant, err))
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, running := r.processors[tenant]; running || r.runCtx != runCtx || runCtx.Err() != nil || r.entities[tenant] != entity {
+		return
+	}
+	ctx, cancel := context.WithCancel(runCtx)
+	p := &tenantProcessor{entity: entity, cancel: cancel, done: make(chan struct{})}
+	r.processors[tenant] = p
+	log(ctx, fmt.Sprintf("starting processor for tenant %s on %s", tenant, entity))
+	go func() {
+		defer close(p.done)
+		if err := processor.Start(ctx); err != nil && ctx.Err() == nil {
+			log(ctx, fmt.Sprintf("processor for tenant %s stopped: %s", tenant, err))
+		}
+		r.mu.Lock()
+		if r.processors[tenant] == p {
+			delete(r.processors, tenant)
+		}
+		r.mu.Unlock()
+		cancel()
+	}()
+}
+
+func (r *TenantRouter) stopProcessors() {
+	r.mu.Lock()
+	processors := r.processors
+	r.processors = map[string]*tenantProcessor{}
+	r.runCtx = nil
+	r.mu.Unlock()
+	for _, p := range processors {
+		p.cancel()
+		<-p.done
+	}
+}
@@ -0,0 +1,189 @@
+package shuttle
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	. "github.com/onsi/gomega"
+)
+
+// mutableTenantEntities is a TenantEntityProvider updated by the tests to onboard and remove tenants.
+type mutableTenantEntities struct {
+	mu       sync.Mutex
+	entities map[string]string
+}
+
+func (m *mutableTenantEntities) set(entities map[string]string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entities = entities
+}
+
+func (m *mutableTenantEntities) TenantEntities(_ context.Context) (map[string]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.entities, nil
+}
+
+// idleSource blocks until the processor stops.
+type idleSource struct{}
+
+func (idleSource) ReceiveMessages(ctx context.Context, _ int, _ *azservicebus.ReceiveMessagesOptions) ([]*azservicebus.ReceivedMessage, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestTenantRouter_SendMessage(t *testing.T) {
+	g := NewWithT(t)
+	provider := &mutableTenantEntities{entities: map[string]string{"contoso": "contoso-queue", "fabrikam": "fabrikam-queue"}}
+	azSenders := map[string]*closableAzSender{}
+	router := NewTenantRouter(provider, &TenantRouterOptions{
+		NewSender: func(_ context.Context, tenant, entity string) (*Sender, error) {
+			azSenders[entity] = &closableAzSender{}
+			return NewSender(azSenders[entity], nil), nil
+		},
+	})
+
+	g.Expect(router.SendMessage(context.Background(), "contoso", "hello")).To(Succeed())
+	g.Expect(azSenders).To(HaveKey("contoso-queue"))
+	g.Expect(azSenders["contoso-queue"].SendMessageCalled).To(BeTrue())
+	g.Expect(azSenders).ToNot(HaveKey("fabrikam-queue"))
+
+	err := router.SendMessage(context.Background(), "unknown", "hello")
+	g.Expect(errors.Is(err, ErrUnknownTenant)).To(BeTrue())
+
+	provider.set(map[string]string{"contoso": "contoso-queue-v2"})
+	g.Expect(router.Refresh(context.Background())).To(Succeed())
+	g.Expect(router.SendMessage(context.Background(), "contoso", "hello")).To(Succeed())
+	g.Expect(azSenders).To(HaveKey("contoso-queue-v2"))
+	g.Expect(azSenders["contoso-queue-v2"].SendMessageCalled).To(BeTrue())
+	g.Expect(errors.Is(router.SendMessage(context.Background(), "fabrikam", "hello"), ErrUnknownTenant)).To(BeTrue())
+}
+
+func TestTenantRouter_ClosesSendersOfRemovedTenants(t *testing.T) {
+	g := NewWithT(t)
+	provider := &mutableTenantEntities{entities: map[string]string{"contoso": "contoso-queue"}}
+	azSender := &closableAzSender{}
+	router := NewTenantRouter(provider, &TenantRouterOptions{
+		NewSender: func(_ context.Context, tenant, entity string) (*Sender, error) {
+			return NewSenderWithOptions(azSender, WithCloseAzSender()), nil
+		},
+	})
+	g.Expect(router.SendMessage(context.Background(), "contoso", "hello")).To(Succeed())
+	provider.set(map[string]string{})
+	g.Expect(router.Refresh(context.Background())).To(Succeed())
+	g.Expect(azSender.closed.Load()).To(BeTrue())
+}
+
+func TestTenantRouter_CreatesSendersOutsideTheLock(t *testing.T) {
+	g := NewWithT(t)
+	provider := &mutableTenantEntities{entities: map[string]string{"contoso": "contoso-queue"}}
+	creating := make(chan struct{})
+	release := make(chan struct{})
+	azSenders := map[string]*closableAzSender{}
+	var mu sync.Mutex
+	router := NewTenantRouter(provider, &TenantRouterOptions{
+		NewSender: func(_ context.Context, tenant, entity string) (*Sender, error) {
+			if entity == "contoso-queue" {
+				close(creating)
+				<-release
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			azSenders[entity] = &closableAzSender{}
+			return NewSenderWithOptions(azSenders[entity], WithCloseAzSender()), nil
+		},
+	})
+	g.Expect(router.Refresh(context.Background())).To(Succeed())
+
+	sent := make(chan error, 1)
+	go func() { sent <- router.SendMessage(context.Background(), "contoso", "hello") }()
+	<-creating
+	// the tenant moves while its sender is created: the refresh does not wait for the creation
+	provider.set(map[string]string{"contoso": "contoso-queue-v2"})
+	g.Expect(router.Refresh(context.Background())).To(Succeed())
+	close(release)
+	g.Eventually(sent).Should(Receive(Succeed()))
+
+	mu.Lock()
+	defer mu.Unlock()
+	g.Expect(azSenders["contoso-queue"].closed.Load()).To(BeTrue(), "the sender of the previous entity is discarded")
+	g.Expect(azSenders["contoso-queue"].SendMessageCalled).To(BeFalse())
+	g.Expect(azSenders["contoso-queue-v2"].SendMessageCalled).To(BeTrue())
+}
+
+func TestTenantRouter_RefreshDrainsSends(t *testing.T) {
+	g := NewWithT(t)
+	provider := &mutableTenantEntities{entities: map[string]string{"contoso": "contoso-queue"}}
+	started := make(chan struct{})
+	release := make(chan struct{})
+	azSender := &closableAzSender{fakeAzSender: fakeAzSender{
+		DoSendMessage: func(context.Context, *azservicebus.Message, *azservicebus.SendMessageOptions) error {
+			close(started)
+			<-release
+			return nil
+		},
+	}}
+	router := NewTenantRouter(provider, &TenantRouterOptions{
+		NewSender: func(_ context.Context, tenant, entity string) (*Sender, error) {
+			return NewSenderWithOptions(azSender, WithCloseAzSender()), nil
+		},
+	})
+	sent := make(chan error, 1)
+	go func() { sent <- router.SendMessage(context.Background(), "contoso", "hello") }()
+	<-started
+
+	provider.set(map[string]string{})
+	refreshed := make(chan error, 1)
+	go func() { refreshed <- router.Refresh(context.Background()) }()
+	g.Consistently(azSender.closed.Load, 50*time.Millisecond).Should(BeFalse())
+	g.Expect(errors.Is(router.SendMessage(context.Background(), "contoso", "hello"), ErrUnknownTenant)).To(BeTrue())
+
+	close(release)
+	g.Eventually(sent).Should(Receive(Succeed()))
+	g.Eventually(refreshed).Should(Receive(Succeed()))
+	g.Expect(azSender.closed.Load()).To(BeTrue())
+}
+
+func TestTenantRouter_RunStartsAndStopsProcessors(t *testing.T) {
+	g := NewWithT(t)
+	provider := &mutableTenantEntities{entities: map[string]string{"contoso": "contoso-queue"}}
+	var mu sync.Mutex
+	var started []string
+	router := NewTenantRouter(provider, &TenantRouterOptions{
+		NewProcessor: func(_ context.Context, tenant, entity string) (*Processor, error) {
+			mu.Lock()
+			started = append(started, entity)
+			mu.Unlock()
+			return NewProcessor(NewSourceReceiver(idleSource{}, nil), func(context.Context, MessageSettler, *azservicebus.ReceivedMessage) {},
+				&ProcessorOptions{MaxConcurrency: 1}), nil
+		},
+		RefreshInterval: 10 * time.Millisecond,
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	runErr := make(chan error, 1)
+	go func() { runErr <- router.Run(ctx) }()
+
+	g.Eventually(router.Tenants).Should(ConsistOf("contoso"))
+	provider.set(map[string]string{"fabrikam": "fabrikam-queue"})
+	g.Eventually(router.Tenants).Should(ConsistOf("fabrikam"))
+	mu.Lock()
+	g.Expect(started).To(Equal([]string{"contoso-queue", "fabrikam-queue"}))
+	mu.Unlock()
+
+	cancel()
+	g.Eventually(runErr).Should(Receive(MatchError(context.Canceled)))
+	g.Expect(router.Tenants()).To(BeEmpty())
+}
+
+func TestTenantRouter_RequiresFactories(t *testing.T) {
+	g := NewWithT(t)
+	router := NewTenantRouter(StaticTenantEntities{"contoso": "contoso-queue"}, nil)
+	g.Expect(router.SendMessage(context.Background(), "contoso", "hello")).ToNot(Succeed())
+	g.Expect(router.Run(context.Background())).ToNot(Succeed())
+}
@@ -0,0 +1,126 @@
+// Package tools provides operational tooling for the go-shuttle entities, such as the export and import of
+// the messages of a queue or subscription to migrate them between namespaces and environments.
+package tools
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+
+	"github.com/Azure/go-shuttle/v2"
+)
+
+const defaultExportPageSize = 100
+
+// ExportOptions configures Export.
+type ExportOptions struct {
+	// PageSize is the number of messages peeked per request. Defaults to 100.
+	PageSize int
+	// FromSequenceNumber is the sequence number of the first message to export. Defaults to the first message.
+	FromSequenceNumber int64
+}
+
+// Export writes all the messages of the entity to w as JSON lines, one shuttle.CapturedMessage per line.
+// The messages are peeked: they stay in the entity, and are not locked nor settled.
+// The peeker is a receiver of the queue or subscription, or of its dead-letter queue.
+// It returns the number of messages exported, including when an error occurred.
+func Export(ctx context.Context, peeker shuttle.MessagePeeker, w io.Writer, options *ExportOptions) (int, error) {
+	opts := ExportOptions{}
+	if options != nil {
+		opts = *options
+	}
+	if opts.PageSize <= 0 {
+		opts.PageSize = defaultExportPageSize
+	}
+	buffered := bufio.NewWriter(w)
+	sink := shuttle.NewJSONCaptureSink(buffered)
+	exported := 0
+	from := opts.FromSequenceNumber
+	for {
+		messages, err := peeker.PeekMessages(ctx, opts.PageSize, &azservicebus.PeekMessagesOptions{FromSequenceNumber: &from})
+		if err != nil {
+			// keep the messages exported so far
+			_ = buffered.Flush()
+			return exported, fmt.Errorf("failed to peek messages: %w", err)
+		}
+		if len(messages) == 0 {
+			return exported, flush(buffered)
+		}
+		now := time.Now()
+		for _, msg := range messages {
+			if err := sink.Write(ctx, shuttle.NewCapturedMessage(msg, now)); err != nil {
+				return exported, fmt.Errorf("failed to export message %s: %w", msg.MessageID, err)
+			}
+			exported++
+			if msg.SequenceNumber != nil && *msg.SequenceNumber >= from {
+				from = *msg.SequenceNumber + 1
+			}
+		}
+	}
+}
+
+func flush(w *bufio.Writer) error {
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("failed to flush export: %w", err)
+	}
+	return nil
+}
+
+// ImportOptions configures Import.
+type ImportOptions struct {
+	// DropMessageID lets the broker assign new MessageIDs. By default the MessageIDs are preserved,
+	// so that importing the same file twice in an entity with duplicate detection does not duplicate the messages.
+	DropMessageID bool
+	// IgnoreSchedule sends the scheduled messages immediately. By default the messages scheduled in the future
+	// are scheduled again at their ScheduledEnqueueTime.
+	IgnoreSchedule bool
+}
+
+// Import sends the messages read from r, in the format written by Export, with the sender.
+// The body and the metadata of the messages are preserved, except the properties set by the broker
+// such as the sequence number and the enqueued time. The messages are sent one at a time, in order.
+// It returns the number of messages imported, including when an error occurred.
+func Import(ctx context.Context, r io.Reader, sender *shuttle.Sender, options *ImportOptions) (int, error) {
+	opts := ImportOptions{}
+	if options != nil {
+		opts = *options
+	}
+	decoder := json.NewDecoder(bufio.NewReader(r))
+	imported := 0
+	for decoder.More() {
+		var captured shuttle.CapturedMessage
+		if err := decoder.Decode(&captured); err != nil {
+			return imported, fmt.Errorf("failed to read message %d: %w", imported, err)
+		}
+		msg, err := importedMessage(captured, opts)
+		if err != nil {
+			return imported, err
+		}
+		if err := sender.SendAzMessage(ctx, msg); err != nil {
+			return imported, fmt.Errorf("failed to import message %s: %w", captured.MessageID, err)
+		}
+		imported++
+	}
+	return imported, nil
+}
+
+func importedMessage(captured shuttle.CapturedMessage, opts ImportOptions) (*azservicebus.Message, error) {
+	var options []func(msg *azservicebus.Message) error
+	if !opts.DropMessageID && captured.MessageID != "" {
+		messageID := captured.MessageID
+		options = append(options, shuttle.SetMessageId(&messageID))
+	}
+	if !opts.IgnoreSchedule && captured.ScheduledEnqueueTime != nil && captured.ScheduledEnqueueTime.After(time.Now()) {
+		options = append(options, shuttle.SetScheduleAt(*captured.ScheduledEnqueueTime))
+	}
+	msg, err := shuttle.CloneForResend(captured.ReceivedMessage(), options...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build message %s: %w", captured.MessageID, err)
+	}
+	return msg, nil
+}
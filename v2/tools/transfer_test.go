@@ -0,0 +1,128 @@
+package tools_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	. "github.com/onsi/gomega"
+
+	"github.com/Azure/go-shuttle/v2"
+	"github.com/Azure/go-shuttle/v2/shuttletest"
+	"github.com/Azure/go-shuttle/v2/tools"
+)
+
+// pagedPeeker serves the messages from the requested sequence number.
+type pagedPeeker struct {
+	messages []*azservicebus.ReceivedMessage
+	calls    int
+	failAt   int
+}
+
+func (p *pagedPeeker) PeekMessages(_ context.Context, maxMessageCount int, options *azservicebus.PeekMessagesOptions) ([]*azservicebus.ReceivedMessage, error) {
+	p.calls++
+	if p.failAt > 0 && p.calls == p.failAt {
+		return nil, errors.New("connection lost")
+	}
+	var page []*azservicebus.ReceivedMessage
+	for _, msg := range p.messages {
+		if *msg.SequenceNumber >= *options.FromSequenceNumber && len(page) < maxMessageCount {
+			page = append(page, msg)
+		}
+	}
+	return page, nil
+}
+
+func testMessages() []*azservicebus.ReceivedMessage {
+	scheduledAt := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	return []*azservicebus.ReceivedMessage{
+		{
+			MessageID:             "m1",
+			SequenceNumber:        to.Ptr(int64(1)),
+			Body:                  []byte(`{"id":1}`),
+			ContentType:           to.Ptr("application/json"),
+			CorrelationID:         to.Ptr("c1"),
+			SessionID:             to.Ptr("s1"),
+			Subject:               to.Ptr("orders"),
+			ApplicationProperties: map[string]any{"type": "OrderCreated"},
+			TimeToLive:            to.Ptr(time.Hour),
+		},
+		{MessageID: "m2", SequenceNumber: to.Ptr(int64(2)), Body: []byte(`{"id":2}`)},
+		{MessageID: "m3", SequenceNumber: to.Ptr(int64(5)), Body: []byte(`{"id":3}`), ScheduledEnqueueTime: &scheduledAt},
+	}
+}
+
+func TestExportImport(t *testing.T) {
+	g := NewWithT(t)
+	peeker := &pagedPeeker{messages: testMessages()}
+	export := &bytes.Buffer{}
+	exported, err := tools.Export(context.Background(), peeker, export, &tools.ExportOptions{PageSize: 2})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(exported).To(Equal(3))
+	g.Expect(peeker.calls).To(Equal(3))
+
+	azSender := shuttletest.NewInMemorySender(nil)
+	imported, err := tools.Import(context.Background(), export, shuttle.NewSender(azSender, nil), nil)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(imported).To(Equal(3))
+
+	sent := azSender.SentMessages()
+	g.Expect(sent).To(HaveLen(3))
+	g.Expect(*sent[0].MessageID).To(Equal("m1"))
+	g.Expect(sent[0].Body).To(Equal([]byte(`{"id":1}`)))
+	g.Expect(*sent[0].ContentType).To(Equal("application/json"))
+	g.Expect(*sent[0].CorrelationID).To(Equal("c1"))
+	g.Expect(*sent[0].SessionID).To(Equal("s1"))
+	g.Expect(*sent[0].Subject).To(Equal("orders"))
+	g.Expect(*sent[0].TimeToLive).To(Equal(time.Hour))
+	g.Expect(sent[0].ApplicationProperties).To(HaveKeyWithValue("type", "OrderCreated"))
+	g.Expect(*sent[1].MessageID).To(Equal("m2"))
+	g.Expect(sent[1].ScheduledEnqueueTime).To(BeNil())
+	// the message scheduled in the future is scheduled again
+	g.Expect(*sent[2].MessageID).To(Equal("m3"))
+	g.Expect(sent[2].ScheduledEnqueueTime).ToNot(BeNil())
+	g.Expect(*sent[2].ScheduledEnqueueTime).To(BeTemporally(">", time.Now()))
+}
+
+func TestImport_Options(t *testing.T) {
+	g := NewWithT(t)
+	export := &bytes.Buffer{}
+	_, err := tools.Export(context.Background(), &pagedPeeker{messages: testMessages()}, export, nil)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	azSender := shuttletest.NewInMemorySender(nil)
+	imported, err := tools.Import(context.Background(), export, shuttle.NewSender(azSender, nil),
+		&tools.ImportOptions{DropMessageID: true, IgnoreSchedule: true})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(imported).To(Equal(3))
+	g.Expect(azSender.SentMessages()).To(HaveLen(3))
+	for _, msg := range azSender.SentMessages() {
+		g.Expect(msg.MessageID).To(BeNil())
+		g.Expect(msg.ScheduledEnqueueTime).To(BeNil())
+	}
+}
+
+func TestExport_PeekFailureKeepsExportedMessages(t *testing.T) {
+	g := NewWithT(t)
+	export := &bytes.Buffer{}
+	exported, err := tools.Export(context.Background(), &pagedPeeker{messages: testMessages(), failAt: 2}, export,
+		&tools.ExportOptions{PageSize: 2})
+	g.Expect(err).To(MatchError(ContainSubstring("connection lost")))
+	g.Expect(exported).To(Equal(2))
+	captured, err := shuttle.ReadCapturedMessages(export)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(captured).To(HaveLen(2))
+}
+
+func TestImport_InvalidLine(t *testing.T) {
+	g := NewWithT(t)
+	azSender := shuttletest.NewInMemorySender(nil)
+	imported, err := tools.Import(context.Background(), bytes.NewBufferString(`{"messageId":"m1","body":"e30="}
+not json`), shuttle.NewSender(azSender, nil), nil)
+	g.Expect(err).To(HaveOccurred())
+	g.Expect(imported).To(Equal(1))
+}
@@ -2,10 +2,12 @@ package shuttle
 
 import (
 	"context"
+	"time"
 
 	"go.opentelemetry.io/otel"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	"github.com/Azure/go-shuttle/v2/metrics/processor"
 	shuttleotel "github.com/Azure/go-shuttle/v2/otel"
 	"go.opentelemetry.io/otel/trace"
 )
@@ -44,6 +46,9 @@ func NewTracingHandler(next Handler, options ...func(t *TracingHandlerOpts)) Han
 	}
 	return func(ctx context.Context, settler MessageSettler, message *azservicebus.ReceivedMessage) {
 		defaultStartOptions := []trace.SpanStartOption{trace.WithAttributes(shuttleotel.MessageAttributes(message)...)}
+		if link, ok := shuttleotel.SchedulingLink(message); ok {
+			defaultStartOptions = append(defaultStartOptions, trace.WithLinks(link))
+		}
 		startOptions := append(defaultStartOptions, t.spanStartOptions...)
 		ctx, span := t.tracer().Start(
 			shuttleotel.Extract(ctx, message),
@@ -54,6 +59,33 @@ func NewTracingHandler(next Handler, options ...func(t *TracingHandlerOpts)) Han
 	}
 }
 
+// NewNamedHandler names a middleware of the handler chain, to see where the time is spent when handling a message.
+// It starts a child span named "middleware.<name>" and records the time spent in next, including the handlers it calls,
+// in the goshuttle_handler_middleware_duration_seconds metric.
+// Wrap each layer of the chain to get one span per middleware:
+//
+//	NewNamedHandler("panic", NewPanicHandler(nil,
+//		NewNamedHandler("renewlock", NewRenewLockHandler(receiver, &interval,
+//			NewNamedHandler("business", handler)))))
+//
+// The options of NewTracingHandler allow to set the trace provider and span start options.
+func NewNamedHandler(name string, next Handler, options ...func(t *TracingHandlerOpts)) HandlerFunc {
+	t := &TracingHandlerOpts{}
+	for _, opt := range options {
+		opt(t)
+	}
+	spanName := "middleware." + name
+	return func(ctx context.Context, settler MessageSettler, message *azservicebus.ReceivedMessage) {
+		ctx, span := t.tracer().Start(ctx, spanName, t.spanStartOptions...)
+		start := time.Now()
+		defer func() {
+			processor.Metric.ObserveMiddlewareDuration(name, time.Since(start))
+			span.End()
+		}()
+		next.Handle(ctx, settler, message)
+	}
+}
+
 // WithTraceProvider allows setting a custom trace provider for the tracing handler in NewTracingHandler.
 func WithTraceProvider(tp trace.TracerProvider) func(t *TracingHandlerOpts) {
 	return func(t *TracingHandlerOpts) {
@@ -82,3 +114,16 @@ func WithTracePropagation(ctx context.Context) func(msg *azservicebus.Message) e
 		return nil
 	}
 }
+
+// WithSchedulingTracePropagation is a sender option to record the scheduling time and trace context of the messages
+// scheduled with SetScheduleAt or SetMessageDelay, for the receiving span to link to the scheduling one
+// and report the scheduling delay apart from the processing lag. It must be applied after the scheduling option.
+// The messages sent without a scheduled enqueue time are left as-is.
+func WithSchedulingTracePropagation(ctx context.Context, clock Clock) func(msg *azservicebus.Message) error {
+	return func(message *azservicebus.Message) error {
+		if message.ScheduledEnqueueTime != nil {
+			shuttleotel.InjectScheduling(ctx, message, clockOrDefault(clock).Now())
+		}
+		return nil
+	}
+}
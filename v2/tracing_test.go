@@ -4,6 +4,7 @@ import (
 	"context"
 	"reflect"
 	"testing"
+	"time"
 
 	. "github.com/onsi/gomega"
 
@@ -15,7 +16,9 @@ import (
 	"go.opentelemetry.io/otel/trace"
 
 	"github.com/Azure/go-shuttle/v2"
+	"github.com/Azure/go-shuttle/v2/metrics/processor"
 	shuttleotel "github.com/Azure/go-shuttle/v2/otel"
+	"github.com/Azure/go-shuttle/v2/shuttletest"
 )
 
 func TestHandlers_SetMessageTrace(t *testing.T) {
@@ -155,3 +158,92 @@ func Test_NewTracingHandler(t *testing.T) {
 		})
 	}
 }
+
+func TestNewNamedHandler(t *testing.T) {
+	g := NewWithT(t)
+	recorder := tracetest.NewSpanRecorder()
+	tp := tracesdk.NewTracerProvider(tracesdk.WithSampler(tracesdk.AlwaysSample()), tracesdk.WithSpanProcessor(recorder))
+	informer := processor.NewInformer()
+	before, err := informer.GetMiddlewareDurationCount("business")
+	g.Expect(err).ToNot(HaveOccurred())
+
+	h := shuttle.NewTracingHandler(
+		shuttle.NewNamedHandler("decode",
+			shuttle.NewNamedHandler("business",
+				shuttle.HandlerFunc(func(ctx context.Context, settler shuttle.MessageSettler, message *azservicebus.ReceivedMessage) {
+					time.Sleep(time.Millisecond)
+				}), shuttle.WithTraceProvider(tp)),
+			shuttle.WithTraceProvider(tp)),
+		shuttle.WithTraceProvider(tp))
+	h.Handle(context.Background(), &fakeSettler{}, &azservicebus.ReceivedMessage{})
+
+	spans := recorder.Ended()
+	g.Expect(spans).To(HaveLen(3))
+	g.Expect(spans[0].Name()).To(Equal("middleware.business"))
+	g.Expect(spans[1].Name()).To(Equal("middleware.decode"))
+	g.Expect(spans[2].Name()).To(Equal("receiver.Handle"))
+	g.Expect(spans[0].Parent().SpanID()).To(Equal(spans[1].SpanContext().SpanID()))
+	g.Expect(spans[1].Parent().SpanID()).To(Equal(spans[2].SpanContext().SpanID()))
+
+	after, err := informer.GetMiddlewareDurationCount("business")
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(after).To(Equal(before + 1))
+}
+
+func TestNewTracingHandler_LinksToSchedulingSpan(t *testing.T) {
+	g := NewWithT(t)
+	recorder := tracetest.NewSpanRecorder()
+	tp := tracesdk.NewTracerProvider(tracesdk.WithSampler(tracesdk.AlwaysSample()), tracesdk.WithSpanProcessor(recorder))
+	schedulingCtx, schedulingSpan := tp.Tracer("test").Start(context.Background(), "schedule")
+	schedulingSpan.End()
+
+	azSender := shuttletest.NewInMemorySender(nil)
+	sender := shuttle.NewSender(azSender, &shuttle.SenderOptions{EnableTracingPropagation: true})
+	scheduledAt := time.Now().Add(time.Minute)
+	err := sender.SendMessage(schedulingCtx, "hello", shuttle.SetScheduleAt(scheduledAt))
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(azSender.SentMessages()).To(HaveLen(1))
+	sent := azSender.SentMessages()[0]
+	g.Expect(sent.ApplicationProperties).To(HaveKey(shuttleotel.ScheduledAtProperty))
+
+	received := &azservicebus.ReceivedMessage{
+		ApplicationProperties: sent.ApplicationProperties,
+		ScheduledEnqueueTime:  &scheduledAt,
+	}
+	h := shuttle.NewTracingHandler(shuttle.HandlerFunc(func(ctx context.Context, settler shuttle.MessageSettler, message *azservicebus.ReceivedMessage) {
+	}), shuttle.WithTraceProvider(tp))
+	h.Handle(context.Background(), &fakeSettler{}, received)
+
+	spans := recorder.Ended()
+	g.Expect(spans).To(HaveLen(2))
+	handled := spans[1]
+	g.Expect(handled.Links()).To(HaveLen(1))
+	g.Expect(handled.Links()[0].SpanContext.SpanID()).To(Equal(schedulingSpan.SpanContext().SpanID()))
+	var delay float64
+	for _, attr := range handled.Attributes() {
+		if attr.Key == "message.schedulingDelaySeconds" {
+			delay = attr.Value.AsFloat64()
+		}
+	}
+	g.Expect(delay).To(BeNumerically("~", 60, 1))
+}
+
+func TestSender_ScheduleMessagesRecordsSchedulingTrace(t *testing.T) {
+	g := NewWithT(t)
+	azSender := shuttletest.NewInMemorySender(nil)
+	sender := shuttle.NewSender(azSender, &shuttle.SenderOptions{EnableTracingPropagation: true})
+	ctx := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{1, 2, 3},
+		SpanID:     trace.SpanID{4, 5, 6},
+		TraceFlags: trace.FlagsSampled,
+	}))
+	msg := &azservicebus.Message{Body: []byte("hello")}
+	_, err := sender.ScheduleMessages(ctx, []*azservicebus.Message{msg}, time.Now().Add(time.Hour))
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(msg.ApplicationProperties).To(HaveKey(shuttleotel.ScheduledAtProperty))
+	g.Expect(msg.ApplicationProperties).To(HaveKeyWithValue(shuttleotel.SchedulingTraceParentProperty, ContainSubstring("0102030000")))
+
+	plain := &azservicebus.Message{Body: []byte("hello")}
+	g.Expect(sender.SendAzMessage(ctx, plain)).To(Succeed())
+	g.Expect(plain.ApplicationProperties).ToNot(HaveKey(shuttleotel.ScheduledAtProperty), "only scheduled messages are recorded")
+}
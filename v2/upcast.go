@@ -0,0 +1,157 @@
+package shuttle
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+)
+
+// SchemaVersionProperty is the application property holding the version of the message body schema.
+const SchemaVersionProperty = "goshuttle-schema-version"
+
+// defaultSchemaVersion is the version of the messages sent without the SchemaVersionProperty.
+const defaultSchemaVersion = 1
+
+// Upcaster transforms a message body from one schema version to the next one.
+type Upcaster func(body []byte) ([]byte, error)
+
+// UpcasterRegistry holds the upcasters of each message type, to bring old payloads to the current schema version
+// before they are unmarshalled: a v1 body goes through the v1→v2 then the v2→v3 upcasters.
+// It is safe for concurrent use.
+type UpcasterRegistry struct {
+	mu        sync.RWMutex
+	upcasters map[string]map[int]Upcaster
+}
+
+// NewUpcasterRegistry creates an empty UpcasterRegistry.
+func NewUpcasterRegistry() *UpcasterRegistry {
+	return &UpcasterRegistry{upcasters: map[string]map[int]Upcaster{}}
+}
+
+// Register adds the upcaster transforming the bodies of msgType from fromVersion to fromVersion+1.
+// msgType is the message type set by the Sender, the name of the body type.
+func (r *UpcasterRegistry) Register(msgType string, fromVersion int, upcaster Upcaster) *UpcasterRegistry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.upcasters[msgType] == nil {
+		r.upcasters[msgType] = map[int]Upcaster{}
+	}
+	r.upcasters[msgType][fromVersion] = upcaster
+	return r
+}
+
+// CurrentVersion returns the version the bodies of msgType are upcast to.
+func (r *UpcasterRegistry) CurrentVersion(msgType string) int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	version := defaultSchemaVersion
+	for {
+		if _, ok := r.upcasters[msgType][version]; !ok {
+			return version
+		}
+		version++
+	}
+}
+
+// Upcast applies the chain of upcasters of msgType, from version to the current version.
+// It returns the transformed body and its version.
+func (r *UpcasterRegistry) Upcast(msgType string, version int, body []byte) ([]byte, int, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for {
+		upcaster, ok := r.upcasters[msgType][version]
+		if !ok {
+			return body, version, nil
+		}
+		upcast, err := upcaster(body)
+		if err != nil {
+			return body, version, fmt.Errorf("failed to upcast %s from version %d to %d: %w", msgType, version, version+1, err)
+		}
+		body = upcast
+		version++
+	}
+}
+
+// UpcastMessage upcasts the body of the received message in place, based on its message type and SchemaVersionProperty.
+// The SchemaVersionProperty is updated to the resulting version.
+// Messages without the SchemaVersionProperty are considered to be in version 1.
+func (r *UpcasterRegistry) UpcastMessage(message *azservicebus.ReceivedMessage) error {
+	msgType, _ := message.ApplicationProperties[msgTypeField].(string)
+	version, err := schemaVersion(message.ApplicationProperties)
+	if err != nil {
+		return err
+	}
+	body, upcastVersion, err := r.Upcast(msgType, version, message.Body)
+	if err != nil {
+		return err
+	}
+	if upcastVersion == version {
+		return nil
+	}
+	message.Body = body
+	if message.ApplicationProperties == nil {
+		message.ApplicationProperties = map[string]any{}
+	}
+	message.ApplicationProperties[SchemaVersionProperty] = int64(upcastVersion)
+	return nil
+}
+
+func schemaVersion(properties map[string]any) (int, error) {
+	value, ok := properties[SchemaVersionProperty]
+	if !ok {
+		return defaultSchemaVersion, nil
+	}
+	switch v := value.(type) {
+	case int:
+		return v, nil
+	case int32:
+		return int(v), nil
+	case int64:
+		return int(v), nil
+	case float64:
+		return int(v), nil
+	case string:
+		version, err := strconv.Atoi(v)
+		if err != nil {
+			return 0, fmt.Errorf("invalid %s %q: %w", SchemaVersionProperty, v, err)
+		}
+		return version, nil
+	default:
+		return 0, fmt.Errorf("invalid %s of type %T", SchemaVersionProperty, value)
+	}
+}
+
+// NewUpcastHandler is a middleware that upcasts the received messages to the current schema version before calling next,
+// so that the handlers only deal with the latest shape of each message type.
+// The messages that cannot be upcast are dead-lettered, as retrying them would fail the same way.
+func NewUpcastHandler(registry *UpcasterRegistry, next Handler) HandlerFunc {
+	return func(ctx context.Context, settler MessageSettler, message *azservicebus.ReceivedMessage) {
+		if err := registry.UpcastMessage(message); err != nil {
+			log(ctx, fmt.Sprintf("dead-lettering message %s: %s", message.MessageID, err))
+			reason := DeadLetterReasonUpcastFailed
+			description := err.Error()
+			if err := settler.DeadLetterMessage(ctx, message, &azservicebus.DeadLetterOptions{
+				Reason:           &reason,
+				ErrorDescription: &description,
+			}); err != nil {
+				log(ctx, fmt.Sprintf("failed to dead-letter message %s: %s", message.MessageID, err))
+			}
+			return
+		}
+		next.Handle(ctx, settler, message)
+	}
+}
+
+// SetSchemaVersion sets the SchemaVersionProperty of the message, to be upcast by the receivers with NewUpcastHandler.
+func SetSchemaVersion(version int) func(msg *azservicebus.Message) error {
+	return func(msg *azservicebus.Message) error {
+		if msg.ApplicationProperties == nil {
+			msg.ApplicationProperties = map[string]any{}
+		}
+		msg.ApplicationProperties[SchemaVersionProperty] = int64(version)
+		return nil
+	}
+}
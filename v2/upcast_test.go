@@ -0,0 +1,110 @@
+package shuttle_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	. "github.com/onsi/gomega"
+
+	"github.com/Azure/go-shuttle/v2"
+)
+
+func testUpcasterRegistry() *shuttle.UpcasterRegistry {
+	return shuttle.NewUpcasterRegistry().
+		Register("Order", 1, func(body []byte) ([]byte, error) {
+			return bytes.ReplaceAll(body, []byte(`"name"`), []byte(`"fullName"`)), nil
+		}).
+		Register("Order", 2, func(body []byte) ([]byte, error) {
+			return bytes.ReplaceAll(body, []byte(`"fullName"`), []byte(`"customer"`)), nil
+		})
+}
+
+func TestUpcasterRegistry_Upcast(t *testing.T) {
+	g := NewWithT(t)
+	registry := testUpcasterRegistry()
+	g.Expect(registry.CurrentVersion("Order")).To(Equal(3))
+	g.Expect(registry.CurrentVersion("Unknown")).To(Equal(1))
+
+	body, version, err := registry.Upcast("Order", 1, []byte(`{"name":"a"}`))
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(version).To(Equal(3))
+	g.Expect(string(body)).To(Equal(`{"customer":"a"}`))
+
+	body, version, err = registry.Upcast("Order", 2, []byte(`{"fullName":"a"}`))
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(version).To(Equal(3))
+	g.Expect(string(body)).To(Equal(`{"customer":"a"}`))
+
+	body, version, err = registry.Upcast("Order", 3, []byte(`{"customer":"a"}`))
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(version).To(Equal(3))
+	g.Expect(string(body)).To(Equal(`{"customer":"a"}`))
+}
+
+func TestUpcasterRegistry_UpcastMessage(t *testing.T) {
+	testCases := []struct {
+		name       string
+		properties map[string]any
+		body       string
+		expected   string
+	}{
+		{name: "no version is v1", properties: map[string]any{"type": "Order"}, body: `{"name":"a"}`, expected: `{"customer":"a"}`},
+		{name: "int64 version", properties: map[string]any{"type": "Order", shuttle.SchemaVersionProperty: int64(2)}, body: `{"fullName":"a"}`, expected: `{"customer":"a"}`},
+		{name: "string version", properties: map[string]any{"type": "Order", shuttle.SchemaVersionProperty: "2"}, body: `{"fullName":"a"}`, expected: `{"customer":"a"}`},
+		{name: "other type untouched", properties: map[string]any{"type": "Invoice"}, body: `{"name":"a"}`, expected: `{"name":"a"}`},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+			message := &azservicebus.ReceivedMessage{Body: []byte(tc.body), ApplicationProperties: tc.properties}
+			g.Expect(testUpcasterRegistry().UpcastMessage(message)).To(Succeed())
+			g.Expect(string(message.Body)).To(Equal(tc.expected))
+		})
+	}
+}
+
+func TestUpcastHandler(t *testing.T) {
+	g := NewWithT(t)
+	var received *azservicebus.ReceivedMessage
+	handler := shuttle.NewUpcastHandler(testUpcasterRegistry(),
+		shuttle.HandlerFunc(func(ctx context.Context, settler shuttle.MessageSettler, message *azservicebus.ReceivedMessage) {
+			received = message
+		}))
+	settler := &fakeSettler{}
+	handler.Handle(context.Background(), settler, &azservicebus.ReceivedMessage{
+		Body:                  []byte(`{"name":"a"}`),
+		ApplicationProperties: map[string]any{"type": "Order", shuttle.SchemaVersionProperty: int64(1)},
+	})
+	g.Expect(received).ToNot(BeNil())
+	g.Expect(string(received.Body)).To(Equal(`{"customer":"a"}`))
+	g.Expect(received.ApplicationProperties).To(HaveKeyWithValue(shuttle.SchemaVersionProperty, int64(3)))
+	g.Expect(settler.DeadLetterCalled.Load()).To(Equal(int32(0)))
+}
+
+func TestUpcastHandler_DeadLettersOnFailure(t *testing.T) {
+	g := NewWithT(t)
+	registry := shuttle.NewUpcasterRegistry().Register("Order", 1, func(body []byte) ([]byte, error) {
+		return nil, errors.New("missing field")
+	})
+	handled := false
+	handler := shuttle.NewUpcastHandler(registry,
+		shuttle.HandlerFunc(func(ctx context.Context, settler shuttle.MessageSettler, message *azservicebus.ReceivedMessage) {
+			handled = true
+		}))
+	settler := &fakeSettler{}
+	handler.Handle(context.Background(), settler, &azservicebus.ReceivedMessage{
+		ApplicationProperties: map[string]any{"type": "Order"},
+	})
+	g.Expect(handled).To(BeFalse())
+	g.Expect(settler.DeadLetterCalled.Load()).To(Equal(int32(1)))
+}
+
+func TestSetSchemaVersion(t *testing.T) {
+	g := NewWithT(t)
+	msg := &azservicebus.Message{}
+	g.Expect(shuttle.SetSchemaVersion(2)(msg)).To(Succeed())
+	g.Expect(msg.ApplicationProperties).To(HaveKeyWithValue(shuttle.SchemaVersionProperty, int64(2)))
+}
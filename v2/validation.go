@@ -0,0 +1,137 @@
+package shuttle
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrInvalidOptions is matched by all the errors returned when validating the options of
+// NewSenderE, NewProcessorE and NewLockRenewalHandlerE.
+var ErrInvalidOptions = errors.New("invalid options")
+
+// OptionError describes an invalid option value, or an incompatible combination of options.
+type OptionError struct {
+	// Option is the name of the invalid option field.
+	Option string
+	// Reason explains why the value is rejected.
+	Reason string
+}
+
+func (e *OptionError) Error() string {
+	return fmt.Sprintf("invalid option %s: %s", e.Option, e.Reason)
+}
+
+// Is makes errors.Is(err, ErrInvalidOptions) true for any OptionError.
+func (e *OptionError) Is(target error) bool {
+	return target == ErrInvalidOptions
+}
+
+// Validate returns an *OptionError when the sender options cannot be used to send messages.
+// The unset options, like a nil Marshaller, are valid: NewSender defaults them.
+func (o *SenderOptions) Validate() error {
+	if o.MaxMessageSize == nil && o.OnMessageTooLarge != nil {
+		return &OptionError{Option: "OnMessageTooLarge", Reason: "requires MaxMessageSize, the message size is not checked"}
+	}
+	if o.MessageSizeWarningThreshold < 0 || o.MessageSizeWarningThreshold > 1 {
+		return &OptionError{Option: "MessageSizeWarningThreshold", Reason: fmt.Sprintf("must be between 0 and 1, got %g", o.MessageSizeWarningThreshold)}
+	}
+	if o.MaxMessageSize == nil && o.MessageSizeWarningThreshold > 0 {
+		return &OptionError{Option: "MessageSizeWarningThreshold", Reason: "requires MaxMessageSize, the message size is not checked"}
+	}
+	return nil
+}
+
+// Validate returns an *OptionError when the processor options cannot be used to receive messages.
+func (o *ProcessorOptions) Validate() error {
+	if o.MaxConcurrency < 0 {
+		return &OptionError{Option: "MaxConcurrency", Reason: fmt.Sprintf("must not be negative, got %d", o.MaxConcurrency)}
+	}
+	if err := o.validateStrictOrdering(); err != nil {
+		return err
+	}
+	if o.ReceiveStallTimeout < 0 {
+		return &OptionError{Option: "ReceiveStallTimeout", Reason: fmt.Sprintf("must not be negative, got %s", o.ReceiveStallTimeout)}
+	}
+	if o.NewReceiver != nil && o.ReceiveStallTimeout == 0 {
+		return &OptionError{Option: "NewReceiver", Reason: "requires ReceiveStallTimeout, the stalls are not detected"}
+	}
+	if o.ReceiveInterval != nil && *o.ReceiveInterval <= 0 {
+		return &OptionError{Option: "ReceiveInterval", Reason: fmt.Sprintf("must be positive, got %s", *o.ReceiveInterval)}
+	}
+	if o.HandleTimeout < 0 {
+		return &OptionError{Option: "HandleTimeout", Reason: fmt.Sprintf("must not be negative, got %s", o.HandleTimeout)}
+	}
+	if o.MaxMessagesPerSecond < 0 {
+		return &OptionError{Option: "MaxMessagesPerSecond", Reason: fmt.Sprintf("must not be negative, got %g", o.MaxMessagesPerSecond)}
+	}
+	return nil
+}
+
+// validateStrictOrdering rejects a MaxConcurrency conflicting with StrictOrdering.
+// It is also checked by Processor.Start, as NewProcessor cannot return an error.
+func (o *ProcessorOptions) validateStrictOrdering() error {
+	if o.StrictOrdering && o.MaxConcurrency > 1 {
+		return &OptionError{Option: "MaxConcurrency", Reason: fmt.Sprintf("must be 1 with StrictOrdering, got %d", o.MaxConcurrency)}
+	}
+	return nil
+}
+
+// Validate returns an *OptionError when the lock renewal options cannot keep the message lock.
+func (o *LockRenewalOptions) Validate() error {
+	if o.Interval == nil {
+		return nil
+	}
+	if *o.Interval <= 0 {
+		return &OptionError{Option: "Interval", Reason: fmt.Sprintf("must be positive, got %s", *o.Interval)}
+	}
+	if o.LockDuration != nil && *o.Interval >= *o.LockDuration {
+		return &OptionError{
+			Option: "Interval",
+			Reason: fmt.Sprintf("must be lower than the lock duration %s, got %s", *o.LockDuration, *o.Interval),
+		}
+	}
+	return nil
+}
+
+// NewSenderE is like NewSender, but validates the options up front instead of failing on the first send.
+func NewSenderE(sender AzServiceBusSender, options *SenderOptions) (*Sender, error) {
+	if sender == nil {
+		return nil, &OptionError{Option: "sender", Reason: "must not be nil"}
+	}
+	if options != nil {
+		if err := options.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	return NewSender(sender, options), nil
+}
+
+// NewProcessorE is like NewProcessor, but validates the options up front instead of failing on the first receive.
+func NewProcessorE(receiver Receiver, handler HandlerFunc, options *ProcessorOptions) (*Processor, error) {
+	if receiver == nil {
+		return nil, &OptionError{Option: "receiver", Reason: "must not be nil"}
+	}
+	if handler == nil {
+		return nil, &OptionError{Option: "handler", Reason: "must not be nil"}
+	}
+	if options != nil {
+		if err := options.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	return NewProcessor(receiver, handler, options), nil
+}
+
+// NewLockRenewalHandlerE is like NewLockRenewalHandler, but validates the options up front.
+// Set LockRenewalOptions.LockDuration to the lock duration of the entity to verify that the interval renews the lock in time.
+func NewLockRenewalHandlerE(lockRenewer LockRenewer, options *LockRenewalOptions, handler Handler) (HandlerFunc, error) {
+	if lockRenewer == nil {
+		return nil, &OptionError{Option: "lockRenewer", Reason: "must not be nil"}
+	}
+	if options != nil {
+		if err := options.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	return NewLockRenewalHandler(lockRenewer, options, handler), nil
+}
@@ -0,0 +1,88 @@
+package shuttle_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	. "github.com/onsi/gomega"
+
+	"github.com/Azure/go-shuttle/v2"
+	"github.com/Azure/go-shuttle/v2/shuttletest"
+)
+
+func expectOptionError(g *WithT, err error, option string) {
+	g.Expect(errors.Is(err, shuttle.ErrInvalidOptions)).To(BeTrue())
+	var optionErr *shuttle.OptionError
+	g.Expect(errors.As(err, &optionErr)).To(BeTrue())
+	g.Expect(optionErr.Option).To(Equal(option))
+}
+
+func TestNewSenderE(t *testing.T) {
+	g := NewWithT(t)
+	sender, err := shuttle.NewSenderE(shuttletest.NewInMemorySender(nil), nil)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(sender).ToNot(BeNil())
+
+	_, err = shuttle.NewSenderE(nil, nil)
+	expectOptionError(g, err, "sender")
+
+	// the unset options are defaulted
+	_, err = shuttle.NewSenderE(shuttletest.NewInMemorySender(nil), &shuttle.SenderOptions{SendTimeout: time.Second})
+	g.Expect(err).ToNot(HaveOccurred())
+
+	_, err = shuttle.NewSenderE(shuttletest.NewInMemorySender(nil), &shuttle.SenderOptions{MessageSizeWarningThreshold: 0.8})
+	expectOptionError(g, err, "MessageSizeWarningThreshold")
+	_, err = shuttle.NewSenderE(shuttletest.NewInMemorySender(nil), &shuttle.SenderOptions{
+		MaxMessageSize:              shuttle.StaticMaxMessageSize(1024),
+		MessageSizeWarningThreshold: 80,
+	})
+	expectOptionError(g, err, "MessageSizeWarningThreshold")
+}
+
+func TestNewProcessorE(t *testing.T) {
+	g := NewWithT(t)
+	rcv := &fakeReceiver{fakeSettler: &fakeSettler{}}
+	processor, err := shuttle.NewProcessorE(rcv, MyHandler(0), &shuttle.ProcessorOptions{MaxConcurrency: 2})
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(processor).ToNot(BeNil())
+
+	_, err = shuttle.NewProcessorE(nil, MyHandler(0), nil)
+	expectOptionError(g, err, "receiver")
+	_, err = shuttle.NewProcessorE(rcv, nil, nil)
+	expectOptionError(g, err, "handler")
+	_, err = shuttle.NewProcessorE(rcv, MyHandler(0), &shuttle.ProcessorOptions{MaxConcurrency: -1})
+	expectOptionError(g, err, "MaxConcurrency")
+	_, err = shuttle.NewProcessorE(rcv, MyHandler(0), &shuttle.ProcessorOptions{ReceiveInterval: to.Ptr(time.Duration(0))})
+	expectOptionError(g, err, "ReceiveInterval")
+	_, err = shuttle.NewProcessorE(rcv, MyHandler(0), &shuttle.ProcessorOptions{StrictOrdering: true, MaxConcurrency: 2})
+	expectOptionError(g, err, "MaxConcurrency")
+	_, err = shuttle.NewProcessorE(rcv, MyHandler(0), &shuttle.ProcessorOptions{NewReceiver: func(ctx context.Context) (shuttle.Receiver, error) {
+		return rcv, nil
+	}})
+	expectOptionError(g, err, "NewReceiver")
+}
+
+func TestNewLockRenewalHandlerE(t *testing.T) {
+	g := NewWithT(t)
+	renewer := &fakeSettler{}
+	handler, err := shuttle.NewLockRenewalHandlerE(renewer, &shuttle.LockRenewalOptions{
+		Interval:     to.Ptr(10 * time.Second),
+		LockDuration: to.Ptr(30 * time.Second),
+	}, MyHandler(0))
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(handler).ToNot(BeNil())
+
+	_, err = shuttle.NewLockRenewalHandlerE(nil, nil, MyHandler(0))
+	expectOptionError(g, err, "lockRenewer")
+	_, err = shuttle.NewLockRenewalHandlerE(renewer, &shuttle.LockRenewalOptions{Interval: to.Ptr(-time.Second)}, MyHandler(0))
+	expectOptionError(g, err, "Interval")
+	_, err = shuttle.NewLockRenewalHandlerE(renewer, &shuttle.LockRenewalOptions{
+		Interval:     to.Ptr(30 * time.Second),
+		LockDuration: to.Ptr(30 * time.Second),
+	}, MyHandler(0))
+	expectOptionError(g, err, "Interval")
+	g.Expect(err).To(MatchError(ContainSubstring("lower than the lock duration")))
+}
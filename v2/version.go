@@ -0,0 +1,39 @@
+package shuttle
+
+import (
+	"runtime/debug"
+	"strings"
+)
+
+const shuttleModulePath = "github.com/Azure/go-shuttle/v2"
+
+// Version is the version of the go-shuttle module the application is built with, such as v2.4.1.
+// It is read from the build info, and is "devel" when go-shuttle is built from its own source tree.
+var Version = moduleVersion(debug.ReadBuildInfo())
+
+func moduleVersion(info *debug.BuildInfo, ok bool) string {
+	if !ok {
+		return "devel"
+	}
+	modules := append([]*debug.Module{&info.Main}, info.Deps...)
+	for _, module := range modules {
+		if module.Path != shuttleModulePath {
+			continue
+		}
+		if module.Replace != nil && module.Replace.Version != "" {
+			return module.Replace.Version
+		}
+		if module.Version != "" && module.Version != "(devel)" {
+			return module.Version
+		}
+	}
+	return "devel"
+}
+
+// UserAgent returns the user agent identifying the application and the go-shuttle Version,
+// such as "orders-api go-shuttle/v2.4.1", or "go-shuttle/v2.4.1" without applicationID.
+// NewClient passes it as the ApplicationID of the azservicebus client, so that the service-side diagnostics
+// attribute the connections to the applications and the go-shuttle versions.
+func UserAgent(applicationID string) string {
+	return strings.TrimSpace(applicationID + " go-shuttle/" + Version)
+}
@@ -0,0 +1,41 @@
+package shuttle
+
+import (
+	"runtime/debug"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	. "github.com/onsi/gomega"
+)
+
+func TestModuleVersion(t *testing.T) {
+	g := NewWithT(t)
+	g.Expect(moduleVersion(nil, false)).To(Equal("devel"))
+	g.Expect(moduleVersion(&debug.BuildInfo{Main: debug.Module{Path: shuttleModulePath, Version: "(devel)"}}, true)).To(Equal("devel"))
+	g.Expect(moduleVersion(&debug.BuildInfo{
+		Main: debug.Module{Path: "github.com/contoso/orders", Version: "v1.0.0"},
+		Deps: []*debug.Module{
+			{Path: "github.com/google/uuid", Version: "v1.6.0"},
+			{Path: shuttleModulePath, Version: "v2.4.1"},
+		},
+	}, true)).To(Equal("v2.4.1"))
+	g.Expect(moduleVersion(&debug.BuildInfo{
+		Deps: []*debug.Module{{Path: shuttleModulePath, Version: "v2.4.1", Replace: &debug.Module{Path: "../go-shuttle/v2"}}},
+	}, true)).To(Equal("v2.4.1"), "a local replacement keeps the required version")
+}
+
+func TestUserAgent(t *testing.T) {
+	g := NewWithT(t)
+	g.Expect(UserAgent("orders-api")).To(Equal("orders-api go-shuttle/" + Version))
+	g.Expect(UserAgent("")).To(Equal("go-shuttle/" + Version))
+}
+
+func TestWithUserAgent(t *testing.T) {
+	g := NewWithT(t)
+	g.Expect(withUserAgent(nil, "orders-api").ApplicationID).To(Equal(UserAgent("orders-api")))
+
+	options := &azservicebus.ClientOptions{ApplicationID: "billing"}
+	g.Expect(withUserAgent(options, "").ApplicationID).To(Equal(UserAgent("billing")))
+	g.Expect(withUserAgent(options, "orders-api").ApplicationID).To(Equal(UserAgent("orders-api")), "WithApplicationID takes precedence")
+	g.Expect(options.ApplicationID).To(Equal("billing"), "the caller's options are not modified")
+}
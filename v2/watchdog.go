@@ -0,0 +1,157 @@
+package shuttle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+)
+
+// ErrReceiveStalled is reported when a receive call does not return after being canceled,
+// which happens when the AMQP link is silently dead.
+// The processor recovers by recreating the receiver when it is configured WithReceiverRecovery,
+// otherwise Processor.Start returns it.
+var ErrReceiveStalled = errors.New("receive loop stalled")
+
+// WithReceiveWatchdog bounds each receive call to stallTimeout, and flags the receive loop as stalled
+// when a call still has not returned stallTimeout after being canceled.
+// A receive call that times out without message is considered idle: the processor keeps receiving.
+// On stall, onError is called with ErrReceiveStalled, then the processor recreates the receiver
+// when it is configured WithReceiverRecovery, or Start returns ErrReceiveStalled.
+// onError may be nil, in which case the OnError already set is kept.
+// The timeouts are measured with the Clock of the processor.
+func WithReceiveWatchdog(stallTimeout time.Duration, onError func(ctx context.Context, err error)) ProcessorOption {
+	return func(options *ProcessorOptions) {
+		options.ReceiveStallTimeout = stallTimeout
+		if onError != nil {
+			options.OnError = onError
+		}
+	}
+}
+
+// WithReceiverRecovery recovers the processor from the stalls detected by the receive watchdog:
+// the stalled receiver is replaced by the one returned by newReceiver, for example a receiver created
+// by the azservicebus.Client, which attaches a new link, and the processor keeps receiving with it.
+// The messages being handled are still settled with the receiver they were received from.
+// The stalled receiver is closed in the background when it implements Close(ctx) error, like *azservicebus.Receiver.
+// Start returns the error of newReceiver, joined with ErrReceiveStalled.
+// It requires the receive watchdog, see WithReceiveWatchdog.
+func WithReceiverRecovery(newReceiver func(ctx context.Context) (Receiver, error)) ProcessorOption {
+	return func(options *ProcessorOptions) {
+		options.NewReceiver = newReceiver
+	}
+}
+
+// LastActivity returns the time at which the last receive call completed, or the zero time if none did.
+func (p *Processor) LastActivity() time.Time {
+	nano := p.lastActivity.Load()
+	if nano == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, nano)
+}
+
+func (p *Processor) currentReceiver() Receiver {
+	p.receiverMu.RLock()
+	defer p.receiverMu.RUnlock()
+	return p.receiver
+}
+
+// receive calls the receiver, watched by the receive watchdog when ReceiveStallTimeout is set.
+func (p *Processor) receive(ctx context.Context, maxMessages int) ([]*azservicebus.ReceivedMessage, error) {
+	opts := p.currentOptions()
+	receiver := p.currentReceiver()
+	if opts.ReceiveStallTimeout <= 0 {
+		messages, err := receiver.ReceiveMessages(ctx, maxMessages, nil)
+		p.lastActivity.Store(time.Now().UnixNano())
+		if err == nil {
+			p.markStarted()
+		}
+		return messages, err
+	}
+	clock := clockOrDefault(opts.Clock)
+	receiveCtx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	type result struct {
+		messages []*azservicebus.ReceivedMessage
+		err      error
+	}
+	// buffered so that a stalled receive call can still complete after the processor gave up on it
+	results := make(chan result, 1)
+	go func() {
+		messages, err := receiver.ReceiveMessages(receiveCtx, maxMessages, nil)
+		results <- result{messages: messages, err: err}
+	}()
+	timeout := clock.NewTimer(opts.ReceiveStallTimeout)
+	defer timeout.Stop()
+	canceled := false
+	for {
+		select {
+		case res := <-results:
+			p.lastActivity.Store(time.Now().UnixNano())
+			if len(res.messages) == 0 && res.err != nil && ctx.Err() == nil &&
+				errors.Is(context.Cause(receiveCtx), context.DeadlineExceeded) {
+				// no message arrived before the watchdog timeout, the entity is idle
+				p.markStarted()
+				return nil, nil
+			}
+			if res.err == nil {
+				p.markStarted()
+			}
+			return res.messages, res.err
+		case <-timeout.C():
+			if !canceled {
+				// the receive call is canceled, and flagged as stalled if it still does not return after the same timeout
+				cancel(context.DeadlineExceeded)
+				canceled = true
+				timeout.Reset(opts.ReceiveStallTimeout)
+				continue
+			}
+			err := fmt.Errorf("%w: no receive call completed since %s", ErrReceiveStalled, p.LastActivity())
+			log(ctx, err)
+			if opts.OnError != nil {
+				opts.OnError(ctx, err)
+			}
+			if opts.NewReceiver == nil {
+				return nil, err
+			}
+			if recreateErr := p.recreateReceiver(ctx, receiver, opts); recreateErr != nil {
+				return nil, errors.Join(err, recreateErr)
+			}
+			p.stats.recordReceiveError(err)
+			return nil, nil
+		}
+	}
+}
+
+// recreateReceiver replaces the stalled receiver with the one returned by NewReceiver.
+// The stalled receiver is closed in the background, bounded by the ReceiveStallTimeout, as its link is dead.
+func (p *Processor) recreateReceiver(ctx context.Context, stalled Receiver, opts ProcessorOptions) error {
+	receiver, err := opts.NewReceiver(ctx)
+	if err == nil && receiver == nil {
+		err = errors.New("no receiver returned")
+	}
+	if err != nil {
+		err = fmt.Errorf("failed to recreate the stalled receiver: %w", err)
+		log(ctx, err)
+		return err
+	}
+	p.receiverMu.Lock()
+	p.receiver = receiver
+	p.receiverMu.Unlock()
+	log(ctx, "recreated the stalled receiver")
+	if closer, ok := stalled.(interface {
+		Close(ctx context.Context) error
+	}); ok {
+		go func() {
+			closeCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), opts.ReceiveStallTimeout)
+			defer cancel()
+			if err := closer.Close(closeCtx); err != nil {
+				log(ctx, fmt.Sprintf("failed to close the stalled receiver: %s", err))
+			}
+		}()
+	}
+	return nil
+}
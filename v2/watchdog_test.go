@@ -0,0 +1,154 @@
+package shuttle_test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	. "github.com/onsi/gomega"
+
+	"github.com/Azure/go-shuttle/v2"
+	"github.com/Azure/go-shuttle/v2/shuttletest"
+)
+
+// idleReceiver blocks until the context is done, like the sdk on an entity without messages.
+// it ignores the cancellation when stalled, like a receive on a silently dead link.
+type idleReceiver struct {
+	*fakeSettler
+	stalled       bool
+	receiveCalls  atomic.Int32
+	stalledCancel chan struct{}
+	closed        atomic.Bool
+}
+
+func (r *idleReceiver) Close(context.Context) error {
+	r.closed.Store(true)
+	return nil
+}
+
+func (r *idleReceiver) ReceiveMessages(ctx context.Context, _ int, _ *azservicebus.ReceiveMessagesOptions) ([]*azservicebus.ReceivedMessage, error) {
+	r.receiveCalls.Add(1)
+	if r.stalled {
+		<-r.stalledCancel
+		return nil, nil
+	}
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestProcessor_ReceiveWatchdogKeepsReceivingWhenIdle(t *testing.T) {
+	g := NewWithT(t)
+	rcv := &idleReceiver{fakeSettler: &fakeSettler{}}
+	processor := shuttle.NewProcessorWithOptions(rcv, MyHandler(0),
+		shuttle.WithReceiveInterval(time.Millisecond),
+		shuttle.WithReceiveWatchdog(50*time.Millisecond, nil))
+	g.Expect(processor.LastActivity()).To(BeZero())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	err := processor.Start(ctx)
+	g.Expect(err).To(MatchError(context.DeadlineExceeded))
+	g.Expect(rcv.receiveCalls.Load()).To(BeNumerically(">", 2), "idle receive calls should time out and be retried")
+	g.Expect(processor.LastActivity()).To(BeTemporally("~", time.Now(), 200*time.Millisecond))
+}
+
+func TestProcessor_ReceiveWatchdogDetectsStall(t *testing.T) {
+	g := NewWithT(t)
+	rcv := &idleReceiver{fakeSettler: &fakeSettler{}, stalled: true, stalledCancel: make(chan struct{})}
+	defer close(rcv.stalledCancel)
+	var reported error
+	processor := shuttle.NewProcessorWithOptions(rcv, MyHandler(0),
+		shuttle.WithReceiveWatchdog(10*time.Millisecond, func(ctx context.Context, err error) {
+			reported = err
+		}))
+
+	start := time.Now()
+	err := processor.Start(context.Background())
+	g.Expect(err).To(MatchError(shuttle.ErrReceiveStalled))
+	g.Expect(reported).To(MatchError(shuttle.ErrReceiveStalled))
+	g.Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+	g.Expect(errors.Is(processor.Stats().LastReceiveError, shuttle.ErrReceiveStalled)).To(BeTrue())
+}
+
+func TestProcessor_ReceiveWatchdogUsesClock(t *testing.T) {
+	g := NewWithT(t)
+	rcv := &idleReceiver{fakeSettler: &fakeSettler{}, stalled: true, stalledCancel: make(chan struct{})}
+	defer close(rcv.stalledCancel)
+	clock := shuttletest.NewFakeClock(time.Now())
+	var reported error
+	processor := shuttle.NewProcessorWithOptions(rcv, MyHandler(0),
+		shuttle.WithProcessorClock(clock),
+		shuttle.WithReceiveWatchdog(time.Hour, func(ctx context.Context, err error) {
+			reported = err
+		}),
+		// the OnError already set is kept
+		shuttle.WithReceiveWatchdog(time.Hour, nil))
+	done := make(chan error, 1)
+	go func() { done <- processor.Start(context.Background()) }()
+
+	g.Eventually(clock.PendingTimers).Should(Equal(1))
+	clock.Advance(time.Hour)
+	g.Consistently(done, 20*time.Millisecond).ShouldNot(Receive(), "the receive call is canceled, then given the timeout to return")
+	g.Eventually(clock.PendingTimers).Should(Equal(1))
+	clock.Advance(time.Hour)
+	g.Eventually(done).Should(Receive(MatchError(shuttle.ErrReceiveStalled)))
+	g.Expect(reported).To(MatchError(shuttle.ErrReceiveStalled))
+}
+
+func TestProcessor_ReceiveWatchdogRecreatesTheStalledReceiver(t *testing.T) {
+	g := NewWithT(t)
+	stalled := &idleReceiver{fakeSettler: &fakeSettler{}, stalled: true, stalledCancel: make(chan struct{})}
+	defer close(stalled.stalledCancel)
+	messages := make(chan *azservicebus.ReceivedMessage, 1)
+	messages <- &azservicebus.ReceivedMessage{}
+	close(messages)
+	recreated := &fakeReceiver{fakeSettler: &fakeSettler{}, SetupReceivedMessages: messages, SetupMaxReceiveCalls: 1000}
+	var reported atomic.Int32
+	var newReceiverCalls atomic.Int32
+	processor := shuttle.NewProcessorWithOptions(stalled, MyHandler(0),
+		shuttle.WithReceiveInterval(time.Millisecond),
+		shuttle.WithReceiveWatchdog(10*time.Millisecond, func(ctx context.Context, err error) {
+			if errors.Is(err, shuttle.ErrReceiveStalled) {
+				reported.Add(1)
+			}
+		}),
+		shuttle.WithReceiverRecovery(func(ctx context.Context) (shuttle.Receiver, error) {
+			newReceiverCalls.Add(1)
+			return recreated, nil
+		}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- processor.Start(ctx) }()
+
+	g.Eventually(recreated.CompleteCalled.Load).Should(Equal(int32(1)), "the processor keeps receiving with the new receiver")
+	g.Consistently(done, 50*time.Millisecond).ShouldNot(Receive())
+	g.Expect(reported.Load()).To(Equal(int32(1)))
+	g.Expect(newReceiverCalls.Load()).To(Equal(int32(1)))
+	g.Eventually(stalled.closed.Load).Should(BeTrue(), "the stalled receiver is closed")
+	g.Expect(errors.Is(processor.Stats().LastReceiveError, shuttle.ErrReceiveStalled)).To(BeTrue())
+
+	cancel()
+	g.Eventually(done).Should(Receive(MatchError(context.Canceled)))
+}
+
+func TestProcessor_ReceiveWatchdogReturnsTheRecoveryError(t *testing.T) {
+	g := NewWithT(t)
+	rcv := &idleReceiver{fakeSettler: &fakeSettler{}, stalled: true, stalledCancel: make(chan struct{})}
+	defer close(rcv.stalledCancel)
+	recoveryErr := errors.New("namespace unreachable")
+	processor := shuttle.NewProcessorWithOptions(rcv, MyHandler(0),
+		shuttle.WithReceiveWatchdog(10*time.Millisecond, nil),
+		shuttle.WithReceiverRecovery(func(ctx context.Context) (shuttle.Receiver, error) {
+			return nil, recoveryErr
+		}))
+
+	err := processor.Start(context.Background())
+	g.Expect(err).To(MatchError(shuttle.ErrReceiveStalled))
+	g.Expect(err).To(MatchError(recoveryErr))
+	g.Expect(rcv.closed.Load()).To(BeFalse(), "the receiver is kept when it cannot be replaced")
+}
@@ -0,0 +1,116 @@
+package shuttle
+
+import (
+	"fmt"
+	"slices"
+	"time"
+)
+
+// ReceiveWindow restricts the times at which the Processor receives messages, for the batch-style consumers
+// that must only touch their downstream systems in maintenance windows, or outside business hours.
+// Outside the window, the processor stops receiving: the messages being handled complete, and the new messages
+// wait in the entity until the window opens.
+type ReceiveWindow interface {
+	// Open reports whether the processor may receive messages at t.
+	Open(t time.Time) bool
+}
+
+// ReceiveWindowFunc allows to use a func as a ReceiveWindow.
+type ReceiveWindowFunc func(t time.Time) bool
+
+func (f ReceiveWindowFunc) Open(t time.Time) bool {
+	return f(t)
+}
+
+// TimeOfDay is a time of the day, as the duration since midnight.
+type TimeOfDay time.Duration
+
+// At returns the TimeOfDay at hour:minute.
+func At(hour, minute int) TimeOfDay {
+	return TimeOfDay(time.Duration(hour)*time.Hour + time.Duration(minute)*time.Minute)
+}
+
+func (d TimeOfDay) String() string {
+	return fmt.Sprintf("%02d:%02d", int(time.Duration(d).Hours()), int(time.Duration(d).Minutes())%60)
+}
+
+// DailyWindow is open between Start and End on its Days, in its Location.
+// The window spans midnight when End is before Start, such as 22:00 to 06:00: it is then open from Start
+// on the Days until End on the next day.
+type DailyWindow struct {
+	Start TimeOfDay
+	End   TimeOfDay
+	// Days are the days the window opens. Defaults to every day.
+	Days []time.Weekday
+	// Location is the time zone of Start and End. Defaults to UTC.
+	Location *time.Location
+}
+
+// BusinessHours returns the DailyWindow from 9:00 to 17:00, Monday to Friday, in the location.
+func BusinessHours(location *time.Location) DailyWindow {
+	return DailyWindow{
+		Start:    At(9, 0),
+		End:      At(17, 0),
+		Days:     []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday},
+		Location: location,
+	}
+}
+
+func (w DailyWindow) Open(t time.Time) bool {
+	location := w.Location
+	if location == nil {
+		location = time.UTC
+	}
+	t = t.In(location)
+	// the wall clock time, rather than the time elapsed since midnight, which differs on the DST changes
+	now := TimeOfDay(time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute +
+		time.Duration(t.Second())*time.Second + time.Duration(t.Nanosecond()))
+	if w.Start <= w.End {
+		return w.openOn(t.Weekday()) && now >= w.Start && now < w.End
+	}
+	// the window spans midnight: it is open late on an opening day, or early on the day after
+	return w.openOn(t.Weekday()) && now >= w.Start || w.openOn((t.Weekday()+6)%7) && now < w.End
+}
+
+func (w DailyWindow) openOn(day time.Weekday) bool {
+	return len(w.Days) == 0 || slices.Contains(w.Days, day)
+}
+
+// ScheduledWindow is open for Duration after each occurrence of its Schedule, such as a cron expression
+// parsed by github.com/robfig/cron/v3, which time zone is set with the CRON_TZ prefix.
+type ScheduledWindow struct {
+	Schedule Schedule
+	Duration time.Duration
+}
+
+func (w ScheduledWindow) Open(t time.Time) bool {
+	// an occurrence in the last Duration opened the window
+	next := w.Schedule.Next(t.Add(-w.Duration))
+	return !next.IsZero() && !next.After(t)
+}
+
+// AnyWindow is open when one of the windows is open.
+func AnyWindow(windows ...ReceiveWindow) ReceiveWindow {
+	return ReceiveWindowFunc(func(t time.Time) bool {
+		for _, window := range windows {
+			if window.Open(t) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// Outside is open when the window is closed, such as Outside(BusinessHours(location)).
+func Outside(window ReceiveWindow) ReceiveWindow {
+	return ReceiveWindowFunc(func(t time.Time) bool {
+		return !window.Open(t)
+	})
+}
+
+// WithReceiveWindow restricts the receiving of the processor to the window.
+func WithReceiveWindow(window ReceiveWindow) ProcessorOption {
+	return func(options *ProcessorOptions) {
+		options.ReceiveWindow = window
+	}
+}
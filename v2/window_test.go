@@ -0,0 +1,83 @@
+package shuttle_test
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	. "github.com/onsi/gomega"
+
+	"github.com/Azure/go-shuttle/v2"
+)
+
+func TestDailyWindow(t *testing.T) {
+	paris, err := time.LoadLocation("Europe/Paris")
+	if err != nil {
+		t.Skip("time zone database not available")
+	}
+	businessHours := shuttle.BusinessHours(paris)
+	nightly := shuttle.DailyWindow{Start: shuttle.At(22, 0), End: shuttle.At(6, 0), Days: []time.Weekday{time.Friday}}
+	testCases := []struct {
+		name   string
+		window shuttle.ReceiveWindow
+		time   time.Time
+		open   bool
+	}{
+		{"business hours, monday morning", businessHours, time.Date(2024, 1, 29, 9, 30, 0, 0, paris), true},
+		{"business hours, in utc", businessHours, time.Date(2024, 1, 29, 8, 30, 0, 0, time.UTC), true},
+		{"business hours, end is excluded", businessHours, time.Date(2024, 1, 29, 17, 0, 0, 0, paris), false},
+		{"daily, dst change", shuttle.DailyWindow{Start: shuttle.At(9, 0), End: shuttle.At(17, 0), Location: paris}, time.Date(2024, 3, 31, 9, 30, 0, 0, paris), true},
+		{"business hours, saturday", businessHours, time.Date(2024, 2, 3, 10, 0, 0, 0, paris), false},
+		{"outside business hours, evening", shuttle.Outside(businessHours), time.Date(2024, 1, 29, 20, 0, 0, 0, paris), true},
+		{"nightly, friday night", nightly, time.Date(2024, 2, 2, 23, 0, 0, 0, time.UTC), true},
+		{"nightly, saturday morning", nightly, time.Date(2024, 2, 3, 5, 59, 0, 0, time.UTC), true},
+		{"nightly, saturday night", nightly, time.Date(2024, 2, 3, 23, 0, 0, 0, time.UTC), false},
+		{"nightly, friday morning", nightly, time.Date(2024, 2, 2, 5, 0, 0, 0, time.UTC), false},
+		{"any window", shuttle.AnyWindow(businessHours, nightly), time.Date(2024, 2, 2, 23, 0, 0, 0, time.UTC), true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			NewWithT(t).Expect(tc.window.Open(tc.time)).To(Equal(tc.open))
+		})
+	}
+}
+
+func TestScheduledWindow(t *testing.T) {
+	g := NewWithT(t)
+	// open for 15 minutes every hour
+	window := shuttle.ScheduledWindow{Schedule: shuttle.Every(time.Hour), Duration: 15 * time.Minute}
+	g.Expect(window.Open(time.Date(2024, 1, 29, 10, 0, 0, 0, time.UTC))).To(BeTrue())
+	g.Expect(window.Open(time.Date(2024, 1, 29, 10, 14, 0, 0, time.UTC))).To(BeTrue())
+	g.Expect(window.Open(time.Date(2024, 1, 29, 10, 15, 0, 0, time.UTC))).To(BeFalse())
+	g.Expect(window.Open(time.Date(2024, 1, 29, 10, 59, 0, 0, time.UTC))).To(BeFalse())
+}
+
+func TestProcessor_ReceivesWithinWindow(t *testing.T) {
+	g := NewWithT(t)
+	var open atomic.Bool
+	messages := make(chan *azservicebus.ReceivedMessage, 1)
+	messages <- &azservicebus.ReceivedMessage{MessageID: "in-window"}
+	close(messages)
+	receiver := &fakeReceiver{fakeSettler: &fakeSettler{}, SetupReceivedMessages: messages, SetupMaxReceiveCalls: 100}
+	var handled atomic.Int32
+	p := shuttle.NewProcessorWithOptions(receiver,
+		func(ctx context.Context, settler shuttle.MessageSettler, message *azservicebus.ReceivedMessage) {
+			handled.Add(1)
+		},
+		shuttle.WithReceiveInterval(10*time.Millisecond),
+		shuttle.WithReceiveWindow(shuttle.ReceiveWindowFunc(func(time.Time) bool { return open.Load() })))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	g.Expect(p.Start(ctx)).To(MatchError(context.DeadlineExceeded))
+	g.Expect(receiver.ReceiveCalls).To(BeEmpty(), "the processor does not receive outside the window")
+
+	open.Store(true)
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_ = p.Start(ctx)
+	g.Expect(receiver.ReceiveCalls).ToNot(BeEmpty())
+	g.Eventually(handled.Load).Should(Equal(int32(1)))
+}